@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// errBusy is the reply to a heavy request that found every slot taken and
+// the queue full, or waited its full turn in it.
+var errBusy = errors.New("the server is busy with other large requests; try again shortly")
+
+// admission bounds how many heavy requests (those that parse CSVs across a
+// range) run at once, process-wide: each holds its series in memory while
+// it runs, and a few large ranges together can exhaust a small machine.
+var admission struct {
+	sync.Mutex
+	running, queued int
+	rejected        uint64
+	// freed is closed, and replaced, each time a slot frees, waking the
+	// queue to try again.
+	freed chan struct{}
+	// took is a moving average of how long heavy requests run, for
+	// Retry-After.
+	took time.Duration
+}
+
+// admit takes a heavy-request slot, waiting up to HEAVY_QUEUE_SECONDS for
+// one behind at most HEAVY_QUEUE others. It returns the func that gives
+// the slot back, or errBusy. With MAX_HEAVY_REQUESTS 0 every request is
+// admitted at once.
+func admit(r *http.Request, c *Config) (func(), error) {
+	if c.MaxHeavy == 0 {
+		return func() {}, nil
+	}
+	var timeout <-chan time.Time
+	queued := false
+	defer func() {
+		if queued {
+			admission.Lock()
+			admission.queued--
+			admission.Unlock()
+		}
+	}()
+	for {
+		admission.Lock()
+		if done, ok := takeSlot(c); ok {
+			admission.Unlock()
+			return done, nil
+		}
+		if !queued {
+			if admission.queued >= c.HeavyQueue {
+				admission.rejected++
+				admission.Unlock()
+				return nil, errBusy
+			}
+			admission.queued++
+			queued = true
+			timeout = time.After(c.HeavyQueueWait)
+		}
+		freed := slotFreed()
+		admission.Unlock()
+
+		select {
+		case <-freed:
+		case <-timeout:
+			admission.Lock()
+			admission.rejected++
+			admission.Unlock()
+			return nil, errBusy
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+	}
+}
+
+// admitJob takes a heavy-request slot for a queued job, waiting as long as
+// it takes: the job was accepted already, and jobs queue in their runner
+// rather than in admission's queue. It returns the func that gives the slot
+// back.
+func admitJob(c *Config) func() {
+	if c.MaxHeavy == 0 {
+		return func() {}
+	}
+	for {
+		admission.Lock()
+		if done, ok := takeSlot(c); ok {
+			admission.Unlock()
+			return done
+		}
+		freed := slotFreed()
+		admission.Unlock()
+		<-freed
+	}
+}
+
+// takeSlot takes a slot if one is free, returning the func that gives it
+// back. Callers hold admission.
+func takeSlot(c *Config) (func(), bool) {
+	if admission.running >= c.MaxHeavy {
+		return nil, false
+	}
+	admission.running++
+	start := time.Now()
+	return func() { release(time.Since(start)) }, true
+}
+
+// slotFreed is closed when a slot next frees. Callers hold admission.
+func slotFreed() <-chan struct{} {
+	if admission.freed == nil {
+		admission.freed = make(chan struct{})
+	}
+	return admission.freed
+}
+
+// release gives back a slot held for took.
+func release(took time.Duration) {
+	admission.Lock()
+	defer admission.Unlock()
+	admission.running--
+	if admission.took == 0 {
+		admission.took = took
+	} else {
+		admission.took = (admission.took*7 + took) / 8
+	}
+	if admission.freed != nil {
+		close(admission.freed)
+		admission.freed = nil
+	}
+}
+
+// retryAfter is how many seconds a refused request should wait: long
+// enough, at the recent pace, for the queue ahead of it to drain.
+func retryAfter(c *Config) int {
+	admission.Lock()
+	defer admission.Unlock()
+	ahead := float64(admission.queued + 1)
+	wait := admission.took.Seconds() * ahead / float64(max(c.MaxHeavy, 1))
+	return min(max(int(math.Ceil(wait)), 1), 60)
+}
+
+// withAdmission runs h only once admit lets it, answering 503 with a
+// Retry-After when the server is saturated, and gives it HEAVY_TIMEOUT_SECONDS
+// from then to reply. OPTIONS requests, which do no work, skip the queue.
+func withAdmission(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			h(w, r)
+			return
+		}
+		c := currentConfig()
+		done, err := admit(r, c)
+		if err != nil {
+			if errors.Is(err, errBusy) {
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter(c)))
+				writeError(w, http.StatusServiceUnavailable, err)
+			}
+			return // a client that left gets no reply
+		}
+		defer done()
+		if c.HeavyTimeout > 0 {
+			// Its time starts now, in place of the server's write timeout
+			// counted from when it arrived, queue included.
+			ctx, cancel := context.WithTimeout(r.Context(), c.HeavyTimeout)
+			defer cancel()
+			http.NewResponseController(w).SetWriteDeadline(time.Now().Add(c.HeavyTimeout))
+			r = r.WithContext(ctx)
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdmission(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+	setConfig(&Config{MaxHeavy: 1, HeavyQueue: 1, HeavyQueueWait: 5 * time.Second})
+
+	unblock := make(chan struct{})
+	h := withAdmission(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+		w.WriteHeader(http.StatusTeapot)
+	})
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("GET", "/generate-data-range", nil))
+		return w
+	}
+	waitFor := func(running, queued int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			admission.Lock()
+			ok := admission.running == running && admission.queued == queued
+			admission.Unlock()
+			if ok {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("never reached %d running, %d queued", running, queued)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	first, second := make(chan int), make(chan int)
+	go func() { first <- serve().Code }()
+	waitFor(1, 0)
+	go func() { second <- serve().Code }()
+	waitFor(1, 1)
+
+	// Slot and queue taken: refused at once.
+	w := serve()
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" || !strings.Contains(w.Body.String(), `"code":"unavailable"`) {
+		t.Errorf("saturated: %d %q %s", w.Code, w.Header().Get("Retry-After"), w.Body.String())
+	}
+
+	// The queued request runs once the first is done.
+	close(unblock)
+	if code := <-first; code != http.StatusTeapot {
+		t.Errorf("first: %d", code)
+	}
+	if code := <-second; code != http.StatusTeapot {
+		t.Errorf("queued: %d", code)
+	}
+	waitFor(0, 0)
+
+	// A request waits no longer than HEAVY_QUEUE_SECONDS.
+	setConfig(&Config{MaxHeavy: 1, HeavyQueue: 1, HeavyQueueWait: 20 * time.Millisecond})
+	done, err := admit(httptest.NewRequest("GET", "/", nil), currentConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admit(httptest.NewRequest("GET", "/", nil), currentConfig()); err != errBusy {
+		t.Errorf("after waiting: %v, want errBusy", err)
+	}
+	done()
+	waitFor(0, 0)
+}
+
+func TestAdmissionDeadline(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+	setConfig(&Config{HeavyTimeout: time.Minute})
+
+	var left time.Duration
+	h := withAdmission(func(w http.ResponseWriter, r *http.Request) {
+		if deadline, ok := r.Context().Deadline(); ok {
+			left = time.Until(deadline)
+		}
+	})
+	h(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/profile", nil))
+	if left <= 50*time.Second || left > time.Minute {
+		t.Errorf("deadline in %s, want HEAVY_TIMEOUT_SECONDS from admission", left)
+	}
+}
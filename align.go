@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// dayClock places a reading on the day it counts towards (that day's
+// midnight) and its minute into the day, or says to leave it out.
+type dayClock func(at int64) (day time.Time, minute int, ok bool)
+
+// wallClock is the Tallinn day and time of day: what the typical-day
+// endpoints use unless asked to align.
+func wallClock(tallinn *time.Location) dayClock {
+	return func(at int64) (time.Time, int, bool) {
+		t := time.Unix(at, 0).In(tallinn)
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, tallinn), t.Hour()*60 + t.Minute(), true
+	}
+}
+
+// openHours is a day's opening hours as the readings show them: from the
+// first reading above zero after one of zero, until the next zero.
+type openHours struct {
+	open, close time.Time
+}
+
+// openings finds each Tallinn day's opening hours. A day with no zero reading
+// before its first busy one (open round the clock, or missing its night)
+// has none.
+func openings(s *gymdata.Series, tallinn *time.Location) map[string]openHours {
+	out := map[string]openHours{}
+	closed, day := false, ""
+	for _, p := range s.Points {
+		t := time.Unix(p.At, 0).In(tallinn)
+		if p.Y <= 0 {
+			if sess, ok := out[day]; ok && sess.close.IsZero() {
+				sess.close = t
+				out[day] = sess
+			}
+			closed = true
+			continue
+		}
+		if d := t.Format("2006-01-02"); closed {
+			if _, seen := out[d]; !seen {
+				out[d], day = openHours{open: t}, d
+			}
+		}
+		closed = false
+	}
+	return out
+}
+
+// contains says whether t falls in the session; one still open at the end
+// of the readings runs until 24 hours after opening.
+func (s openHours) contains(t time.Time) bool {
+	end := s.close
+	if end.IsZero() || end.Sub(s.open) > 24*time.Hour {
+		end = s.open.Add(24 * time.Hour)
+	}
+	return !t.Before(s.open) && t.Before(end)
+}
+
+// openingClock counts minutes from the day's opening rather than midnight,
+// so gyms with different hours line up at t=0. Readings after midnight
+// belong to the previous day while its session lasts, and those of a closed
+// gym are left out. Days with no opening found keep wall-clock time.
+func openingClock(s *gymdata.Series, tallinn *time.Location) dayClock {
+	sessions := openings(s, tallinn)
+	wall := wallClock(tallinn)
+	return func(at int64) (time.Time, int, bool) {
+		day, minute, _ := wall(at)
+		t := time.Unix(at, 0)
+		prevDay := day.AddDate(0, 0, -1)
+		if prev, ok := sessions[prevDay.Format("2006-01-02")]; ok && prev.contains(t) {
+			return prevDay, int(t.Sub(prev.open) / time.Minute), true
+		}
+		sess, ok := sessions[day.Format("2006-01-02")]
+		if !ok {
+			return day, minute, true
+		}
+		if sess.contains(t) {
+			return day, int(t.Sub(sess.open) / time.Minute), true
+		}
+		return day, 0, false
+	}
+}
+
+// typicalOpening is the median opening time of day over the days in
+// [from, to), "HH:MM", or "" if none was found.
+func typicalOpening(s *gymdata.Series, from, to time.Time, tallinn *time.Location) string {
+	var minutes []int
+	for _, sess := range openings(s, tallinn) {
+		if t := sess.open; !t.Before(from) && t.Before(to) {
+			minutes = append(minutes, t.Hour()*60+t.Minute())
+		}
+	}
+	if len(minutes) == 0 {
+		return ""
+	}
+	sort.Ints(minutes)
+	m := minutes[len(minutes)/2]
+	return fmt.Sprintf("%02d:%02d", m/60, m%60)
+}
+
+// slotLabel names the bucket starting minute minutes into the day: a time
+// of day, or with align=open the time since opening ("+02:30").
+func slotLabel(minute int, aligned bool) string {
+	if aligned {
+		return fmt.Sprintf("+%02d:%02d", minute/60, minute%60)
+	}
+	return fmt.Sprintf("%02d:%02d", minute/60, minute%60)
+}
+
+// parseAlign reads the align parameter: "" or "clock" for wall-clock time,
+// "open" to count from each day's opening.
+func parseAlign(s string) (bool, error) {
+	switch s {
+	case "", "clock":
+		return false, nil
+	case "open":
+		return true, nil
+	}
+	return false, fieldErr("align", nil, fmt.Errorf("align must be clock or open"))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// hoursSeries is a gym open from open until close (hours after midnight,
+// past 24 for after midnight) on each of days days from start, with count
+// people an hour into each opening and 10 otherwise, read every 30 minutes
+// and 0 while closed.
+func hoursSeries(start time.Time, days int, open, close float64, count float64) *gymdata.Series {
+	s := &gymdata.Series{Key: gymdata.Key{Location: "gym", Metric: gymdata.DefaultMetric}}
+	for at := start; at.Before(start.AddDate(0, 0, days)); at = at.Add(30 * time.Minute) {
+		y := 0.0
+		for _, d := range []time.Time{at.AddDate(0, 0, -1), at} {
+			midnight := time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, at.Location())
+			h := at.Sub(midnight).Hours()
+			if h >= open && h < close {
+				y = 10
+				if h-open == 1 {
+					y = count
+				}
+			}
+		}
+		s.Points = append(s.Points, gymdata.Point{At: at.Unix(), Y: y})
+	}
+	return s
+}
+
+func TestOpeningClock(t *testing.T) {
+	tallinn := loadTallinn(t)
+	start := time.Date(2025, 10, 6, 0, 0, 0, 0, tallinn)
+	// Opens 06:30 until 01:00 the next night.
+	s := hoursSeries(start, 3, 6.5, 25, 40)
+	if got := typicalOpening(s, start, start.AddDate(0, 0, 3), tallinn); got != "06:30" {
+		t.Errorf("typicalOpening = %q, want 06:30", got)
+	}
+	clock := openingClock(s, tallinn)
+	for _, c := range []struct {
+		at     time.Time
+		day    int
+		minute int
+		ok     bool
+	}{
+		{start.Add(7 * time.Hour), 6, 30, true},
+		{start.Add(24*time.Hour + 30*time.Minute), 6, 18 * 60, true}, // 00:30 the next night
+		{start.Add(24*time.Hour + 3*time.Hour), 7, 0, false},         // closed
+		{start.Add(24*time.Hour + 6*time.Hour + 30*time.Minute), 7, 0, true},
+	} {
+		day, minute, ok := clock(c.at.Unix())
+		if day.Day() != c.day || ok != c.ok || ok && minute != c.minute {
+			t.Errorf("%s: day %d minute %d ok %v, want %d %d %v", c.at.Format("Jan 2 15:04"), day.Day(), minute, ok, c.day, c.minute, c.ok)
+		}
+	}
+
+	// Round the clock there is no opening, and the time of day is kept.
+	always := &gymdata.Series{Points: []gymdata.Point{{At: start.Add(time.Hour).Unix(), Y: 3}, {At: start.Add(9 * time.Hour).Unix(), Y: 8}}}
+	if _, minute, ok := openingClock(always, tallinn)(start.Add(9 * time.Hour).Unix()); !ok || minute != 9*60 {
+		t.Errorf("24h gym: minute %d ok %v, want 540", minute, ok)
+	}
+}
+
+func TestAlignedProfiles(t *testing.T) {
+	tallinn := loadTallinn(t)
+	start := time.Date(2025, 10, 6, 0, 0, 0, 0, tallinn) // Monday
+	early := hoursSeries(start, 5, 6, 22, 40)
+	late := hoursSeries(start, 5, 9, 23, 40)
+	from, to := start, start.AddDate(0, 0, 5)
+
+	// On the clock their rush hours are three hours apart...
+	clockEarly, _ := computeProfile(early, 60, from, to, false, tallinn)
+	if clockEarly[7].Time != "07:00" || clockEarly[7].Avg != 25 {
+		t.Errorf("early 07:00 = %+v, want 25 (40 and 10)", clockEarly[7])
+	}
+	// ...opened, they line up.
+	e, _ := computeProfile(early, 60, from, to, true, tallinn)
+	l, _ := computeProfile(late, 60, from, to, true, tallinn)
+	if len(e) != 16 || len(l) != 14 {
+		t.Fatalf("aligned slots: %d and %d, want 16 and 14", len(e), len(l))
+	}
+	for i := range l {
+		if e[i] != l[i] {
+			t.Errorf("slot %d: early %+v, late %+v", i, e[i], l[i])
+		}
+	}
+	if e[1].Time != "+01:00" || e[1].Avg != 25 {
+		t.Errorf("slot +01:00 = %+v, want 25", e[1])
+	}
+
+	if b := computeBands(late, 60, from, to, nil, true, tallinn); b[0].Time != "+00:00" || b[1].P50 != 25 {
+		t.Errorf("aligned bands start %+v %+v", b[0], b[1])
+	}
+	if _, err := parseAlign("noon"); err == nil {
+		t.Error("align=noon accepted")
+	}
+}
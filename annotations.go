@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// Annotation explains a stretch of the chart ("pool closed for maintenance").
+// An empty Location applies to every gym; an empty End marks a single moment.
+type Annotation struct {
+	ID       int    `json:"id"`
+	Text     string `json:"text"`
+	Start    string `json:"start"`
+	End      string `json:"end,omitempty"`
+	Location string `json:"location,omitempty"`
+	Created  string `json:"created,omitempty"`
+	Author   string `json:"author,omitempty"`
+}
+
+var annotationsMu sync.Mutex
+
+// readAnnotations loads the store; a missing file is an empty list.
+func readAnnotations(cfg *Config) ([]Annotation, error) {
+	data, err := os.ReadFile(cfg.path(cfg.AnnotationsFile))
+	if os.IsNotExist(err) {
+		return []Annotation{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := []Annotation{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %v", cfg.AnnotationsFile, err)
+	}
+	return list, nil
+}
+
+// writeAnnotations saves list as the whole store.
+func writeAnnotations(cfg *Config, list []Annotation) error {
+	return writeFileAtomic(cfg.path(cfg.AnnotationsFile), list)
+}
+
+// check normalises a submitted annotation's times to RFC 3339 and rejects
+// ones that could not be drawn.
+func (a *Annotation) check() error {
+	a.Text = strings.TrimSpace(a.Text)
+	if a.Text == "" {
+		return fmt.Errorf("text is required")
+	}
+	start, err := parseAnnotationTime(a.Start)
+	if err != nil {
+		return fmt.Errorf("start: %v", err)
+	}
+	a.Start = start.Format(time.RFC3339)
+	if a.End != "" {
+		end, err := parseAnnotationTime(a.End)
+		if err != nil {
+			return fmt.Errorf("end: %v", err)
+		}
+		if end.Before(start) {
+			return fmt.Errorf("end is before start")
+		}
+		a.End = end.Format(time.RFC3339)
+	}
+	return nil
+}
+
+// parseAnnotationTime accepts RFC 3339 or Tallinn-local "YYYY-MM-DD[ HH:MM]".
+func parseAnnotationTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, gymdata.Tallinn()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not RFC 3339 or YYYY-MM-DD[ HH:MM]", s)
+}
+
+// annotationsBetween returns the annotations overlapping [from, to), with
+// their times in loc for the chart.
+func annotationsBetween(cfg *Config, from, to time.Time, loc *time.Location) []Annotation {
+	annotationsMu.Lock()
+	list, err := readAnnotations(cfg)
+	annotationsMu.Unlock()
+	if err != nil {
+		return nil
+	}
+	var out []Annotation
+	for _, a := range list {
+		start, err := time.Parse(time.RFC3339, a.Start)
+		if err != nil {
+			continue
+		}
+		end := start
+		if e, err := time.Parse(time.RFC3339, a.End); err == nil {
+			end = e
+		}
+		if !start.Before(to) || end.Before(from) {
+			continue
+		}
+		a.Start = start.In(loc).Format(time.RFC3339)
+		if a.End != "" {
+			a.End = end.In(loc).Format(time.RFC3339)
+		}
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start < out[j].Start })
+	return out
+}
+
+// annotationsForDays is annotationsBetween for the Tallinn days from..to, as
+// the chart endpoints take them. Unparseable dates give none.
+func annotationsForDays(cfg *Config, from, to string, loc *time.Location) []Annotation {
+	start, err := time.ParseInLocation("2006-01-02", from, gymdata.Tallinn())
+	if err != nil {
+		return nil
+	}
+	end, err := time.ParseInLocation("2006-01-02", to, gymdata.Tallinn())
+	if err != nil {
+		return nil
+	}
+	return annotationsBetween(cfg, start, end.AddDate(0, 0, 1), loc)
+}
+
+// annotationsHandler serves the annotation store. Viewers read; changes are
+// admin-only and audited.
+//
+//	GET    /api/annotations[?from=YYYY-MM-DD&to=YYYY-MM-DD][&location=NAME][&tz=ZONE]
+//	POST   /api/annotations {text, start[, end][, location]}
+//	PUT    /api/annotations/ID {text, start[, end][, location]}
+//	DELETE /api/annotations/ID
+func annotationsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "OPTIONS":
+		requireRole(RoleViewer, listAnnotationsHandler)(w, r)
+	default:
+		requireRole(RoleAdmin, changeAnnotationHandler)(w, r)
+	}
+}
+
+func listAnnotationsHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	q := r.URL.Query()
+	tallinn := gymdata.Tallinn()
+	outZone, err := requestZone(q.Get("tz"), tallinn)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	from, to := time.Time{}, time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	if t, err := time.ParseInLocation("2006-01-02", q.Get("from"), tallinn); err == nil {
+		from = t
+	}
+	if t, err := time.ParseInLocation("2006-01-02", q.Get("to"), tallinn); err == nil {
+		to = t.AddDate(0, 0, 1)
+	}
+	out := []Annotation{}
+	location := strings.TrimSpace(q.Get("location"))
+	for _, a := range annotationsBetween(requestConfig(r), from, to, outZone) {
+		if location == "" || a.Location == "" || a.Location == location {
+			out = append(out, a)
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]any{"annotations": out})
+}
+
+func changeAnnotationHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	fail := func(status int, err error) {
+		writeError(w, status, err)
+	}
+
+	id := 0
+	if rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/annotations"), "/"); rest != "" {
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			fail(http.StatusNotFound, fmt.Errorf("no annotation %q", rest))
+			return
+		}
+		id = n
+	}
+	if (r.Method == "POST") != (id == 0) || (r.Method != "POST" && r.Method != "PUT" && r.Method != "DELETE") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var in Annotation
+	if r.Method != "DELETE" {
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			fail(http.StatusBadRequest, fmt.Errorf("invalid request body"))
+			return
+		}
+		if err := in.check(); err != nil {
+			fail(http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	cfg := requestConfig(r)
+	annotationsMu.Lock()
+	defer annotationsMu.Unlock()
+	list, err := readAnnotations(cfg)
+	if err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+
+	idx := -1
+	for i, a := range list {
+		if a.ID == id {
+			idx = i
+		}
+	}
+	if id != 0 && idx == -1 {
+		fail(http.StatusNotFound, fmt.Errorf("no annotation %d", id))
+		return
+	}
+
+	_, actor, _ := requestRole(r, cfg)
+	var action string
+	switch r.Method {
+	case "POST":
+		action = "annotation-create"
+		in.ID = 0
+		for _, a := range list {
+			in.ID = max(in.ID, a.ID)
+		}
+		in.ID++
+		in.Created = time.Now().UTC().Format(time.RFC3339)
+		in.Author = actor
+		list = append(list, in)
+	case "PUT":
+		action = "annotation-update"
+		in.ID, in.Created, in.Author = id, list[idx].Created, list[idx].Author
+		list[idx] = in
+	case "DELETE":
+		action = "annotation-delete"
+		in = list[idx]
+		list = append(list[:idx], list[idx+1:]...)
+	}
+
+	err = writeAnnotations(cfg, list)
+	recordAudit(r, action, map[string]any{"id": in.ID, "text": in.Text}, err)
+	if err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	if r.Method == "POST" {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(in)
+}
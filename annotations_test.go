@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnnotationCheck(t *testing.T) {
+	a := Annotation{Text: " pool closed ", Start: "2025-12-31 18:00", End: "2026-01-01"}
+	if err := a.check(); err != nil {
+		t.Fatal(err)
+	}
+	if a.Text != "pool closed" || a.Start != "2025-12-31T18:00:00+02:00" || a.End != "2026-01-01T00:00:00+02:00" {
+		t.Errorf("got %+v", a)
+	}
+	for _, bad := range []Annotation{
+		{Start: "2025-12-31"},
+		{Text: "x", Start: "31.12.2025"},
+		{Text: "x", Start: "2025-12-31", End: "2025-12-30"},
+	} {
+		if err := bad.check(); err == nil {
+			t.Errorf("check(%+v): expected error", bad)
+		}
+	}
+}
+
+func TestAnnotationsHandler(t *testing.T) {
+	keys, _ := parseAPIKeys("alice:admintoken:admin,tv:viewtoken:viewer")
+	old := currentConfig()
+	defer setConfig(old)
+	dir := t.TempDir()
+	setConfig(&Config{
+		APIKeys:         keys,
+		AnonymousRole:   RoleViewer,
+		AuditLog:        filepath.Join(dir, "audit.jsonl"),
+		AnnotationsFile: filepath.Join(dir, "annotations.json"),
+	})
+
+	call := func(method, path, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		annotationsHandler(w, r)
+		return w
+	}
+
+	if w := call("POST", "/api/annotations", "viewtoken", `{"text":"x","start":"2025-12-31"}`); w.Code != http.StatusForbidden {
+		t.Errorf("viewer create: code %d, want 403", w.Code)
+	}
+	if w := call("POST", "/api/annotations", "admintoken", `{"text":"new year rush","start":"2026-01-02","end":"2026-01-10"}`); w.Code != http.StatusCreated {
+		t.Fatalf("create: code %d: %s", w.Code, w.Body)
+	}
+	w := call("POST", "/api/annotations", "admintoken", `{"id":7,"text":"pool closed","start":"2026-01-05 08:00","location":"Kristiine"}`)
+	var created Annotation
+	json.NewDecoder(w.Body).Decode(&created)
+	if created.ID != 2 || created.Author != "alice" {
+		t.Errorf("second create = %+v, want id 2 by alice", created)
+	}
+	if w := call("PUT", "/api/annotations/2", "admintoken", `{"text":"pool closed all day","start":"2026-01-05","end":"2026-01-06","location":"Kristiine"}`); w.Code != http.StatusOK {
+		t.Errorf("update: code %d: %s", w.Code, w.Body)
+	}
+	if w := call("DELETE", "/api/annotations/9", "admintoken", ""); w.Code != http.StatusNotFound {
+		t.Errorf("delete missing: code %d, want 404", w.Code)
+	}
+	if w := call("PUT", "/api/annotations", "admintoken", `{}`); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT without id: code %d, want 405", w.Code)
+	}
+
+	list := func(query string) []Annotation {
+		w := call("GET", "/api/annotations"+query, "", "")
+		var resp struct{ Annotations []Annotation }
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.Annotations
+	}
+	if got := list("?from=2026-01-05&to=2026-01-05&location=Kristiine"); len(got) != 2 || got[1].Text != "pool closed all day" || got[1].Author != "alice" {
+		t.Errorf("Kristiine on Jan 5 = %+v", got)
+	}
+	if got := list("?from=2026-01-05&to=2026-01-05&location=Ülemiste"); len(got) != 1 {
+		t.Errorf("Ülemiste on Jan 5 = %+v, want only the all-gyms one", got)
+	}
+	if got := list("?from=2026-01-11&to=2026-01-31"); len(got) != 0 {
+		t.Errorf("after both = %+v, want none", got)
+	}
+	if got := list("?tz=UTC"); len(got) != 2 || got[0].Start != "2026-01-01T22:00:00Z" {
+		t.Errorf("UTC = %+v", got)
+	}
+
+	if w := call("DELETE", "/api/annotations/1", "admintoken", ""); w.Code != http.StatusOK {
+		t.Errorf("delete: code %d", w.Code)
+	}
+	if got := annotationsForDays(currentConfig(), "2026-01-01", "2026-01-31", time.UTC); len(got) != 1 || got[0].ID != 2 {
+		t.Errorf("after delete = %+v", got)
+	}
+	audit, _ := readAudit(currentConfig(), "", 10)
+	if len(audit) != 4 || audit[0].Action != "annotation-delete" || audit[3].Action != "annotation-create" {
+		t.Errorf("audit = %+v", audit)
+	}
+}
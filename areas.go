@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gym/internal/gymdata"
+)
+
+// requestAreas reads a request's areas option for gyms split into areas
+// (gym floor, pool, classes): split, the default, charts each area as a
+// line of its own; club rolls them up into one line per club.
+func requestAreas(areas string) (rollUp bool, err error) {
+	switch strings.ToLower(strings.TrimSpace(areas)) {
+	case "", "split":
+		return false, nil
+	case "club":
+		return true, nil
+	}
+	return false, fmt.Errorf("areas must be split or club")
+}
+
+// withAreas returns list with each club's areas rolled up when rollUp is
+// set. list itself, which may be cached, is not changed.
+func withAreas(list []*gymdata.Series, rollUp bool, bucketMinutes int) []*gymdata.Series {
+	if !rollUp {
+		return list
+	}
+	return gymdata.RollUpAreas(list, bridgeGap(bucketMinutes))
+}
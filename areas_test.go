@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"gym/internal/gymdata"
+)
+
+func TestWithAreas(t *testing.T) {
+	series := func(location string, y float64) *gymdata.Series {
+		return &gymdata.Series{Key: gymdata.Key{Location: location, Metric: gymdata.DefaultMetric}, Points: []gymdata.Point{{At: 0, Y: y}}}
+	}
+	list := []*gymdata.Series{series("Hipodroom / Gym floor", 30), series("Hipodroom / Pool", 12), series("T1", 7)}
+
+	if _, err := requestAreas("pool"); err == nil {
+		t.Error("areas=pool accepted")
+	}
+	if rollUp, _ := requestAreas(""); withAreas(list, rollUp, 60)[0] != list[0] {
+		t.Error("split by default")
+	}
+	rollUp, _ := requestAreas("Club")
+	if got := withAreas(list, rollUp, 60); len(got) != 2 || got[0].Key.Location != "Hipodroom" || got[0].Points[0].Y != 42 {
+		t.Errorf("club = %+v", got)
+	}
+
+	// A named club's total counts its areas, whether or not they're split.
+	if got := withTotal(list, "Hipodroom", 60); len(got) != 4 || got[3].Points[0].Y != 42 {
+		t.Errorf("Hipodroom total = %+v", got)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// writeFileAtomic replaces path with v as indented JSON, or with v as it is
+// if it is already []byte. It writes a temp file beside path, syncs it and
+// renames it over path, so a crash mid-write leaves the old file or the new
+// one, never a truncated one.
+func writeFileAtomic(path string, v any) error {
+	data, ok := v.([]byte)
+	if !ok {
+		indented, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		data = append(indented, '\n')
+	}
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "store.json")
+	if err := writeFileAtomic(path, map[string]int{"a": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "{\n  \"a\": 1\n}\n" {
+		t.Errorf("JSON written as %q", data)
+	}
+	if err := writeFileAtomic(path, []byte("raw\n")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "raw\n" {
+		t.Errorf("bytes written as %q", data)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temp file left behind: %v", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "store.json"), []byte("x")); err == nil {
+		t.Error("write into a missing directory: expected an error")
+	}
+	if err := writeFileAtomic(path, func() {}); err == nil {
+		t.Error("unmarshalable value: expected an error")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "raw\n" {
+		t.Errorf("failed write changed the file to %q", data)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type AuditEntry struct {
+	Time   string         `json:"time"`
+	Action string         `json:"action"`
+	Actor  string         `json:"actor,omitempty"`
+	IP     string         `json:"ip,omitempty"`
+	Params map[string]any `json:"params,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+var auditMu sync.Mutex
+
+// appendAudit adds one JSON line to cfg's audit log. The file is only ever
+// opened for appending; failures are logged but never fail the operation.
+func appendAudit(cfg *Config, entry AuditEntry) {
+	if entry.Time == "" {
+		entry.Time = time.Now().UTC().Format(time.RFC3339)
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("audit: %v", err)
+		return
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	file, err := os.OpenFile(cfg.path(cfg.AuditLog), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		log.Printf("audit: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Printf("audit: %v", err)
+	}
+}
+
+// recordAudit logs a data-modifying request with its actor and parameters.
+// opErr, when non-nil, records that the operation failed.
+func recordAudit(r *http.Request, action string, params map[string]any, opErr error) {
+	cfg := requestConfig(r)
+	_, actor, _ := requestRole(r, cfg)
+	entry := AuditEntry{Action: action, Actor: actor, IP: clientIP(r), Params: params}
+	if opErr != nil {
+		entry.Error = opErr.Error()
+	}
+	appendAudit(cfg, entry)
+}
+
+// readAudit returns up to limit entries, newest first, optionally only those
+// with the given action. Unparseable lines are skipped.
+func readAudit(cfg *Config, action string, limit int) ([]AuditEntry, error) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	file, err := os.Open(cfg.path(cfg.AuditLog))
+	if os.IsNotExist(err) {
+		return []AuditEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var all []AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e AuditEntry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		if action != "" && e.Action != action {
+			continue
+		}
+		all = append(all, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make([]AuditEntry, 0, min(limit, len(all)))
+	for i := len(all) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, all[i])
+	}
+	return out, nil
+}
+
+// auditHandler serves the audit log to admins.
+//
+//	GET /api/admin/audit[?action=NAME][&limit=N]
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 100
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = min(n, 10000)
+	}
+	entries, err := readAudit(requestConfig(r), strings.TrimSpace(r.URL.Query().Get("action")), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"entries": entries})
+}
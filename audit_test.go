@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditRoundTrip(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+	setConfig(&Config{AuditLog: filepath.Join(t.TempDir(), "audit.jsonl")})
+
+	if got, err := readAudit(currentConfig(), "", 10); err != nil || len(got) != 0 {
+		t.Fatalf("missing log: got %v, %v; want empty", got, err)
+	}
+
+	r := httptest.NewRequest("POST", "/generate-data-range", nil)
+	r.RemoteAddr = "192.0.2.7:51234"
+	recordAudit(r, "generate-data-range", map[string]any{"from": "2025-10-01"}, nil)
+	recordAudit(r, "generate-data", nil, nil)
+	recordAudit(r, "generate-data-range", map[string]any{"from": "2025-10-02"}, nil)
+
+	all, err := readAudit(currentConfig(), "", 10)
+	if err != nil || len(all) != 3 {
+		t.Fatalf("got %d entries, %v; want 3", len(all), err)
+	}
+	if all[0].Params["from"] != "2025-10-02" {
+		t.Errorf("first entry = %+v, want newest first", all[0])
+	}
+	if all[0].IP != "192.0.2.7" {
+		t.Errorf("ip = %q, want 192.0.2.7", all[0].IP)
+	}
+
+	ranges, _ := readAudit(currentConfig(), "generate-data-range", 1)
+	if len(ranges) != 1 || ranges[0].Params["from"] != "2025-10-02" {
+		t.Errorf("filtered = %+v, want only the newest range entry", ranges)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleViewer
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+func parseRole(s string) (Role, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "none", "":
+		return RoleNone, nil
+	case "viewer":
+		return RoleViewer, nil
+	case "admin":
+		return RoleAdmin, nil
+	}
+	return RoleNone, fmt.Errorf("unknown role %q (want none, viewer or admin)", s)
+}
+
+type APIKey struct {
+	Name  string
+	Token string
+	Role  Role
+}
+
+// parseAPIKeys reads API_KEYS: comma-separated name:token:role entries. The
+// name is what the logs record, so the token itself never appears there.
+func parseAPIKeys(s string) ([]APIKey, error) {
+	var keys []APIKey
+	names := map[string]bool{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("entry %q: want name:token:role", parts[0])
+		}
+		role, err := parseRole(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %v", parts[0], err)
+		}
+		if role == RoleNone {
+			return nil, fmt.Errorf("key %q: role must be viewer or admin", parts[0])
+		}
+		if names[parts[0]] {
+			return nil, fmt.Errorf("duplicate key name %q", parts[0])
+		}
+		names[parts[0]] = true
+		keys = append(keys, APIKey{Name: parts[0], Token: parts[1], Role: role})
+	}
+	return keys, nil
+}
+
+// requestToken extracts a presented API key from Authorization: Bearer,
+// X-API-Key, or a ?key= query parameter (for plain links like /download-csvs).
+func requestToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	if h := r.Header.Get("X-API-Key"); h != "" {
+		return strings.TrimSpace(h)
+	}
+	return r.URL.Query().Get("key")
+}
+
+// requestRole resolves the caller's role and a name for logs. Without any
+// configured keys or OIDC login auth is off and everyone is admin, as before
+// roles existed. A presented but unknown token is ok == false rather than
+// anonymous; without a token, a signed-in browser is its OIDC_ADMINS role.
+func requestRole(r *http.Request, c *Config) (role Role, actor string, ok bool) {
+	if len(c.APIKeys) == 0 && c.OIDCIssuer == "" {
+		return RoleAdmin, "", true
+	}
+	token := requestToken(r)
+	if token == "" {
+		if email, ok := requestSession(r, c); ok {
+			return max(oidcRole(c, email), c.AnonymousRole), "oidc:" + email, true
+		}
+		return c.AnonymousRole, "anonymous", true
+	}
+	for _, k := range c.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(k.Token)) == 1 {
+			return k.Role, k.Name, true
+		}
+	}
+	return RoleNone, "", false
+}
+
+// requireRole wraps a handler so it only runs for callers holding at least
+// min, and for admin handlers only from ADMIN_ALLOW's addresses. CORS
+// preflights pass through untouched so browsers can send the key.
+func requireRole(min Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			next(w, r)
+			return
+		}
+		cfg := requestConfig(r)
+		if min >= RoleAdmin && !adminAllowed(r, cfg) {
+			setCORS(w, r, "GET, POST, OPTIONS")
+			w.Header().Set("Content-Type", "application/json")
+			writeError(w, http.StatusForbidden, fmt.Errorf("admin endpoints are not open to %s", clientIP(r)))
+			return
+		}
+		role, actor, ok := requestRole(r, cfg)
+		if ok && role >= min {
+			next(w, r)
+			return
+		}
+
+		setCORS(w, r, "GET, POST, OPTIONS")
+		w.Header().Set("Content-Type", "application/json")
+		// A known key or a live session is who the caller is, just not
+		// enough of it: that's a 403, and signing in again won't help.
+		if !ok || actor == "anonymous" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gym"`)
+			writeError(w, http.StatusUnauthorized, errors.New("authentication required"))
+			return
+		}
+		writeError(w, http.StatusForbidden, fmt.Errorf("%s role required", min))
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseAPIKeys(t *testing.T) {
+	keys, err := parseAPIKeys("alice:s3cret:admin, tv:abc:viewer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].Role != RoleAdmin || keys[1].Name != "tv" || keys[1].Role != RoleViewer {
+		t.Errorf("got %+v", keys)
+	}
+	for _, bad := range []string{"alice:s3cret", "alice:s3cret:root", "alice::admin", "a:x:admin,a:y:viewer", "a:x:none"} {
+		if _, err := parseAPIKeys(bad); err == nil {
+			t.Errorf("parseAPIKeys(%q): expected error", bad)
+		}
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	keys, _ := parseAPIKeys("alice:admintoken:admin,tv:viewtoken:viewer")
+	old := currentConfig()
+	defer setConfig(old)
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) }
+	call := func(min Role, setup func(*http.Request)) int {
+		r := httptest.NewRequest("GET", "/x", nil)
+		setup(r)
+		w := httptest.NewRecorder()
+		requireRole(min, ok)(w, r)
+		return w.Code
+	}
+	none := func(*http.Request) {}
+	bearer := func(tok string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+tok) }
+	}
+
+	setConfig(&Config{})
+	if got := call(RoleAdmin, none); got != http.StatusTeapot {
+		t.Errorf("no keys configured: code %d, want open access", got)
+	}
+
+	setConfig(&Config{APIKeys: keys, AnonymousRole: RoleViewer})
+	cases := []struct {
+		name  string
+		min   Role
+		setup func(*http.Request)
+		want  int
+	}{
+		{"anonymous viewer reads", RoleViewer, none, http.StatusTeapot},
+		{"anonymous cannot admin", RoleAdmin, none, http.StatusUnauthorized},
+		{"viewer key cannot admin", RoleAdmin, bearer("viewtoken"), http.StatusForbidden},
+		{"admin key via bearer", RoleAdmin, bearer("admintoken"), http.StatusTeapot},
+		{"admin key via header", RoleAdmin, func(r *http.Request) { r.Header.Set("X-API-Key", "admintoken") }, http.StatusTeapot},
+		{"unknown key rejected", RoleViewer, bearer("nope"), http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		if got := call(c.min, c.setup); got != c.want {
+			t.Errorf("%s: code %d, want %d", c.name, got, c.want)
+		}
+	}
+
+	setConfig(&Config{APIKeys: keys, AnonymousRole: RoleNone})
+	if got := call(RoleViewer, none); got != http.StatusUnauthorized {
+		t.Errorf("anonymous role none: code %d, want 401", got)
+	}
+}
@@ -25,12 +25,12 @@ if [ ! -d "$BK/.git" ]; then
 fi
 
 cd "$BK"
-cp "$RT"/gym-stats-*.csv "$BK"/ 2>/dev/null || true
+cp "$RT"/gym-stats-*.csv "$RT"/gym-stats-*.csv.gz "$BK"/ 2>/dev/null || true
 git add -A
 if git diff --cached --quiet; then
   echo "backup: no changes"
 else
   git commit -q -m "Backup $(date '+%Y-%m-%d %H:%M')"
   git push -q -u origin data
-  echo "backup: pushed $(ls "$BK"/gym-stats-*.csv 2>/dev/null | wc -l | tr -d ' ') files"
+  echo "backup: pushed $(ls "$BK"/gym-stats-*.csv "$BK"/gym-stats-*.csv.gz 2>/dev/null | wc -l | tr -d ' ') files"
 fi
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// BandSlot is the spread of one time-of-day bucket over the historical days:
+// each day contributes its average for the bucket.
+type BandSlot struct {
+	Time string  `json:"time"` // bucket start, gym-local "HH:MM", or "+HH:MM" after opening
+	P10  float64 `json:"p10"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	Days int     `json:"days"`
+}
+
+type BandLocation struct {
+	Name  string      `json:"name"`
+	Bands []BandSlot  `json:"bands"`
+	Today []DataPoint `json:"today"`
+	// With align=open, Opens is the median opening time over the range and
+	// OpenedToday when the gym opened today, to place Today against Bands.
+	Opens       string `json:"opens,omitempty"`
+	OpenedToday string `json:"openedToday,omitempty"`
+}
+
+type BandsResponse struct {
+	From          string         `json:"from"`
+	To            string         `json:"to"`
+	Today         string         `json:"today"`
+	Metric        string         `json:"metric"`
+	Weekday       string         `json:"weekday,omitempty"`
+	BucketMinutes int            `json:"bucketMinutes"`
+	Align         string         `json:"align,omitempty"`
+	Locations     []BandLocation `json:"locations"`
+}
+
+// percentile interpolates linearly between the closest ranks of sorted.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := p * float64(len(sorted)-1)
+	lo := int(pos)
+	if lo+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lo] + (pos-float64(lo))*(sorted[lo+1]-sorted[lo])
+}
+
+// computeBands folds a series into per-day averages for each bucketMinutes
+// slot of the Tallinn day, keeping the days in [from, to) (and, when weekday
+// is set, only that weekday), and returns each slot's p10/p50/p90 across days.
+// Averaging within a day first means a day with more readings in a slot
+// weighs no more than one with fewer. With align, slots count from each
+// day's opening instead of midnight.
+func computeBands(s *gymdata.Series, bucketMinutes int, from, to time.Time, weekday *time.Weekday, align bool, tallinn *time.Location) []BandSlot {
+	type cell struct {
+		sum   float64
+		count int
+	}
+	slots := 24 * 60 / bucketMinutes
+	byDay := map[string][]cell{}
+	clock := wallClock(tallinn)
+	if align {
+		clock = openingClock(s, tallinn)
+	}
+	for _, p := range s.Points {
+		d, minute, ok := clock(p.At)
+		if !ok || d.Before(from) || !d.Before(to) || minute >= 24*60 || (weekday != nil && d.Weekday() != *weekday) {
+			continue
+		}
+		day := d.Format("2006-01-02")
+		cells := byDay[day]
+		if cells == nil {
+			cells = make([]cell, slots)
+			byDay[day] = cells
+		}
+		i := minute / bucketMinutes
+		cells[i].sum += p.Y
+		cells[i].count++
+	}
+
+	out := []BandSlot{}
+	values := make([]float64, 0, len(byDay))
+	for i := 0; i < slots; i++ {
+		values = values[:0]
+		for _, cells := range byDay {
+			if cells[i].count > 0 {
+				values = append(values, cells[i].sum/float64(cells[i].count))
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		sort.Float64s(values)
+		round := func(v float64) float64 { return math.Round(v*10) / 10 }
+		out = append(out, BandSlot{
+			Time: slotLabel(i*bucketMinutes, align),
+			P10:  round(percentile(values, 0.1)),
+			P50:  round(percentile(values, 0.5)),
+			P90:  round(percentile(values, 0.9)),
+			Days: len(values),
+		})
+	}
+	return out
+}
+
+// bandsHandler returns, per gym, the typical range of a metric through the day
+// over a historical range, alongside today's readings, so the dashboard can
+// shade "usual" behind the live line.
+//
+//	GET /api/bands[?from=YYYY-MM-DD&to=YYYY-MM-DD][&bucket=MIN][&metric=NAME][&weekday=same][&location=NAME][&align=clock|open]
+//
+// The range defaults to the 28 days before today and never includes today.
+// weekday=same keeps only days on today's weekday. align=open counts the
+// bands from each day's opening time; today's readings keep their times.
+func bandsHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	fail := func(msg string) {
+		writeError(w, http.StatusBadRequest, errors.New(msg))
+	}
+
+	tallinn := gymdata.Tallinn()
+	now := time.Now().In(tallinn)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, tallinn)
+
+	q := r.URL.Query()
+	to := today.AddDate(0, 0, -1)
+	if t, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(q.Get("to")), tallinn); err == nil && t.Before(today) {
+		to = t
+	}
+	from := to.AddDate(0, 0, -27)
+	if t, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(q.Get("from")), tallinn); err == nil {
+		from = t
+	}
+	if from.After(to) {
+		writeError(w, http.StatusBadRequest, withKind(ErrBadRange, errors.New("from is after to (the range ends yesterday at the latest)")))
+		return
+	}
+	bucketMinutes := 15
+	if s := q.Get("bucket"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 2 || n > 120 || 24*60%n != 0 {
+			fail("bucket must be 2-120 minutes and divide the day")
+			return
+		}
+		bucketMinutes = n
+	}
+	metric := gymdata.NormalizeMetrics([]string{q.Get("metric")})[0]
+	var weekday *time.Weekday
+	switch q.Get("weekday") {
+	case "", "all":
+	case "same":
+		wd := today.Weekday()
+		weekday = &wd
+	default:
+		fail("weekday must be all or same")
+		return
+	}
+	location := strings.TrimSpace(q.Get("location"))
+	align, err := parseAlign(q.Get("align"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cfg := requestConfig(r)
+	end := to.AddDate(0, 0, 1)
+	if align {
+		end = end.AddDate(0, 0, 1) // the last day's night counts towards it
+	}
+	history, err := loadWindow(cfg, from, end, []string{metric})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	todayFiles, err := recentFiles(cfg.csvDir(), today, now)
+	if err != nil {
+		todayFiles = nil
+	}
+	current, _, err := gymdata.Load(cfg.format(), todayFiles, []string{metric})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	current = gymdata.Trim(current, today.Unix())
+
+	resp := BandsResponse{
+		From:          from.Format("2006-01-02"),
+		To:            to.Format("2006-01-02"),
+		Today:         today.Format("2006-01-02"),
+		Metric:        metric,
+		BucketMinutes: bucketMinutes,
+		Locations:     []BandLocation{},
+	}
+	if weekday != nil {
+		resp.Weekday = weekday.String()
+	}
+	if align {
+		resp.Align = "open"
+	}
+	byName := map[string]*BandLocation{}
+	entry := func(name string) *BandLocation {
+		if byName[name] == nil {
+			byName[name] = &BandLocation{Name: name, Bands: []BandSlot{}, Today: []DataPoint{}}
+		}
+		return byName[name]
+	}
+	for _, s := range history {
+		if location != "" && !strings.EqualFold(s.Key.Location, location) {
+			continue
+		}
+		if bands := computeBands(s, bucketMinutes, from, to.AddDate(0, 0, 1), weekday, align, tallinn); len(bands) > 0 {
+			loc := entry(s.Key.Location)
+			loc.Bands = bands
+			if align {
+				loc.Opens = typicalOpening(s, from, to.AddDate(0, 0, 1), tallinn)
+			}
+		}
+	}
+	for _, s := range current {
+		if location != "" && !strings.EqualFold(s.Key.Location, location) {
+			continue
+		}
+		loc := entry(s.Key.Location)
+		if opened, ok := openings(s, tallinn)[resp.Today]; align && ok {
+			loc.OpenedToday = opened.open.Format(time.RFC3339)
+		}
+		for _, p := range s.Points {
+			loc.Today = append(loc.Today, DataPoint{X: time.Unix(p.At, 0).In(tallinn).Format(time.RFC3339), Y: p.Y})
+		}
+	}
+	for _, loc := range byName {
+		resp.Locations = append(resp.Locations, *loc)
+	}
+	sort.Slice(resp.Locations, func(i, j int) bool { return resp.Locations[i].Name < resp.Locations[j].Name })
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+func TestPercentile(t *testing.T) {
+	values := []float64{10, 20, 30, 40, 50}
+	for p, want := range map[float64]float64{0: 10, 0.1: 14, 0.5: 30, 0.9: 46, 1: 50} {
+		if got := percentile(values, p); got != want {
+			t.Errorf("percentile(%v) = %v, want %v", p, got, want)
+		}
+	}
+	if got := percentile([]float64{7}, 0.9); got != 7 {
+		t.Errorf("single value = %v, want 7", got)
+	}
+}
+
+func TestComputeBands(t *testing.T) {
+	tallinn := loadTallinn(t)
+	s := &gymdata.Series{Key: gymdata.Key{Location: "Hipodroom", Metric: gymdata.DefaultMetric}}
+	// Five days (Wed 1st to Sun 5th) with 10, 20, ... 50 people at 10:00.
+	// Day one has a second 10:00-bucket reading, which only moves its own
+	// day average; the 6th is outside the range.
+	for d := 1; d <= 6; d++ {
+		at := time.Date(2025, 10, d, 10, 0, 0, 0, tallinn)
+		s.Points = append(s.Points, gymdata.Point{At: at.Unix(), Y: float64(d * 10)})
+	}
+	s.Points = append(s.Points, gymdata.Point{At: time.Date(2025, 10, 1, 10, 10, 0, 0, tallinn).Unix(), Y: 10})
+	s.Points = append(s.Points, gymdata.Point{At: time.Date(2025, 10, 2, 18, 30, 0, 0, tallinn).Unix(), Y: 5})
+	from, to := time.Date(2025, 10, 1, 0, 0, 0, 0, tallinn), time.Date(2025, 10, 6, 0, 0, 0, 0, tallinn)
+
+	got := computeBands(s, 15, from, to, nil, false, tallinn)
+	want := []BandSlot{{Time: "10:00", P10: 14, P50: 30, P90: 46, Days: 5}, {Time: "18:30", P10: 5, P50: 5, P90: 5, Days: 1}}
+	if len(got) != len(want) {
+		t.Fatalf("bands = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("slot %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	thursday := time.Thursday
+	if got := computeBands(s, 60, from, to, &thursday, false, tallinn); len(got) != 2 || got[0].P50 != 20 || got[0].Days != 1 {
+		t.Errorf("Thursday-only bands = %+v", got)
+	}
+}
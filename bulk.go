@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// maxBulkRanges bounds one bulk request, which is answered in one go.
+const maxBulkRanges = 8
+
+// BulkRangeResponse answers a bulk /api/range or /generate-data-range: each range's reply,
+// as the single-range call would have sent it, under the range's key.
+// Success is whether every range built. A request refused as a whole is
+// answered with an APIError instead.
+type BulkRangeResponse struct {
+	Success bool                       `json:"success"`
+	Results map[string]json.RawMessage `json:"results,omitempty"`
+}
+
+// bulkRangeHandler answers a POST of a range whose body is an array of
+// ranges, building them one after another so ranges sharing days parse each
+// file once and share the range cache, and writing each to gym-data.json if
+// write is set. A range that fails to build has its error in its result; a
+// malformed one fails the request.
+func bulkRangeHandler(w http.ResponseWriter, r *http.Request, body []byte, write bool) {
+	fail := func(err error) { writeError(w, http.StatusBadRequest, err) }
+	var ranges []DateRangeRequest
+	if err := json.Unmarshal(body, &ranges); err != nil {
+		fail(errors.New("Invalid request body"))
+		return
+	}
+	if len(ranges) == 0 || len(ranges) > maxBulkRanges {
+		fail(fmt.Errorf("want 1 to %d ranges, got %d", maxBulkRanges, len(ranges)))
+		return
+	}
+	if r.URL.Query().Get("async") == "1" {
+		fail(errors.New("async=1 takes a single range"))
+		return
+	}
+	cfg := requestConfig(r)
+	keys := map[string]bool{}
+	for i := range ranges {
+		if err := checkRangeRequest(cfg, &ranges[i]); err != nil {
+			fail(fmt.Errorf("ranges[%d]: %w", i, err))
+			return
+		}
+		if ranges[i].Key == "" {
+			ranges[i].Key = ranges[i].Preset
+		}
+		if ranges[i].Key == "" {
+			ranges[i].Key = ranges[i].From + ".." + ranges[i].To
+		}
+		if keys[ranges[i].Key] {
+			fail(fmt.Errorf("ranges[%d]: key %q is taken, give each range its own", i, ranges[i].Key))
+			return
+		}
+		keys[ranges[i].Key] = true
+	}
+
+	out := BulkRangeResponse{Success: true, Results: map[string]json.RawMessage{}}
+	for _, dateRange := range ranges {
+		resp, list, pf, status, err := rangeResponse(r, dateRange, write)
+		var buf bytes.Buffer
+		if err != nil {
+			out.Success = false
+			json.NewEncoder(&buf).Encode(apiError(status, err))
+		} else if err := writeGenerateResponse(&buf, resp, list, pf); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		} else {
+			historyFor(r).noteChart(resp.Meta, list)
+		}
+		out.Results[dateRange.Key] = bytes.TrimSpace(buf.Bytes())
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// The results are charts, which don't flatten into one CSV table.
+	if negotiate(r) == asMsgpack {
+		setMediaType(w, asMsgpack)
+		transcodeMsgpack(w, data)
+		return
+	}
+	setMediaType(w, asJSON)
+	w.Write(append(data, '\n'))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBulkRange(t *testing.T) {
+	loadTallinn(t)
+	old := currentConfig()
+	defer setConfig(old)
+	dir := t.TempDir()
+	setConfig(&Config{DataDir: dir, AuditLog: "gym-audit.jsonl", AnnotationsFile: "gym-annotations.json", PrefsFile: "gym-prefs.json"})
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	writeCSV(t, dir, "gym-stats-20251001.csv", header+"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,{}\n")
+	writeCSV(t, dir, "gym-stats-20251002.csv", header+"2025-10-02 10:00:00,EEST,1,Hipodroom,20,success,{}\n")
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		generateDataRangeHandler(w, httptest.NewRequest("POST", "/generate-data-range", strings.NewReader(body)))
+		return w
+	}
+	w := post(`[{"key": "this", "from": "2025-10-02", "to": "2025-10-02"}, {"from": "2025-10-01", "to": "2025-10-02"}, {"from": "2025-09-01", "to": "2025-09-02"}]`)
+	var got struct {
+		Success bool
+		Results map[string]GenerateResponse
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != http.StatusOK || !got.Success {
+		t.Fatalf("code %d: %s", w.Code, w.Body)
+	}
+	this, both, none := got.Results["this"], got.Results["2025-10-01..2025-10-02"], got.Results["2025-09-01..2025-09-02"]
+	if len(got.Results) != 3 || len(this.Datasets) != 1 || len(this.Datasets[0].Data) != 1 || len(both.Datasets) != 1 || len(both.Datasets[0].Data) != 2 {
+		t.Errorf("results = %s", w.Body)
+	}
+	if !none.Success || len(none.Datasets) != 0 {
+		t.Errorf("empty range = %+v", none)
+	}
+
+	// A single range is answered as before.
+	w = post(`{"from": "2025-10-01", "to": "2025-10-01"}`)
+	var one GenerateResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &one); err != nil || !one.Success || len(one.Datasets) != 1 {
+		t.Errorf("single range: %s", w.Body)
+	}
+
+	for _, bad := range []string{
+		`[]`,
+		`[{"from": "2025-10-01", "to": "2025-10-01"}, {"from": "2025-10-01", "to": "2025-10-01"}]`,
+		`[{"from": "2025-10-01", "to": "2025-10-01", "outliers": "maybe"}]`,
+		`[{"from": "2025-10-01"}, 3]`,
+	} {
+		if w := post(bad); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: code %d, want 400", bad, w.Code)
+		}
+	}
+}
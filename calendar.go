@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// dayStat is one calendar day of a series: its readings' sum and count,
+// and its highest reading, the first if several tie.
+type dayStat struct {
+	sum   float64
+	count int
+	peak  gymdata.Point
+}
+
+func (d *dayStat) mean() float64 { return d.sum / float64(d.count) }
+
+// dailyStats folds a series into its days in loc, keyed YYYY-MM-DD.
+func dailyStats(s *gymdata.Series, loc *time.Location) map[string]*dayStat {
+	days := map[string]*dayStat{}
+	for _, p := range s.Points {
+		key := time.Unix(p.At, 0).In(loc).Format("2006-01-02")
+		d := days[key]
+		if d == nil {
+			d = &dayStat{peak: p}
+			days[key] = d
+		}
+		d.sum += p.Y
+		d.count++
+		if p.Y > d.peak.Y {
+			d.peak = p
+		}
+	}
+	return days
+}
+
+// CalendarDay is one day of a gym's calendar. Week and Weekday place it in
+// a grid of Monday-first columns, week 0 holding the period's first day.
+// Level is 0 for a day without readings or at zero, else which quarter of
+// the gym's highest day in the period it falls in, 1-4.
+type CalendarDay struct {
+	Date    string   `json:"date"`
+	Week    int      `json:"week"`
+	Weekday int      `json:"weekday"` // 0 Monday .. 6 Sunday
+	Value   *float64 `json:"value"`
+	Level   int      `json:"level"`
+}
+
+type CalendarLocation struct {
+	Name string        `json:"name"`
+	Max  float64       `json:"max"`
+	Days []CalendarDay `json:"days"`
+}
+
+type CalendarResponse struct {
+	Period    string             `json:"period"` // YYYY-MM or YYYY-Qn
+	From      string             `json:"from"`
+	To        string             `json:"to"`
+	Stat      string             `json:"stat"`
+	Metric    string             `json:"metric"`
+	Locations []CalendarLocation `json:"locations"`
+}
+
+// parseCalendarPeriod reads a month (YYYY-MM) or a quarter (YYYY-Qn) as its
+// first day and the day after its last, in loc.
+func parseCalendarPeriod(s string, loc *time.Location) (from, end time.Time, err error) {
+	var year, quarter int
+	if n, _ := fmt.Sscanf(s, "%4d-Q%1d", &year, &quarter); n == 2 && len(s) == 7 {
+		if quarter < 1 || quarter > 4 {
+			return from, end, fmt.Errorf("%s: quarters are Q1-Q4", s)
+		}
+		from = time.Date(year, time.Month(3*quarter-2), 1, 0, 0, 0, 0, loc)
+		return from, from.AddDate(0, 3, 0), nil
+	}
+	if from, err = time.ParseInLocation("2006-01", s, loc); err != nil {
+		return from, end, err
+	}
+	return from, from.AddDate(0, 1, 0), nil
+}
+
+// calendarDays lays out the days from from up to end with each day's stat
+// from days, nil where it has none.
+func calendarDays(days map[string]*dayStat, from, end time.Time, peak bool) (out []CalendarDay, top float64) {
+	offset := (int(from.Weekday()) + 6) % 7
+	for d, i := from, 0; d.Before(end); d, i = d.AddDate(0, 0, 1), i+1 {
+		date := d.Format("2006-01-02")
+		cd := CalendarDay{Date: date, Week: (i + offset) / 7, Weekday: (int(d.Weekday()) + 6) % 7}
+		if st := days[date]; st != nil {
+			v := st.peak.Y
+			if !peak {
+				v = math.Round(st.mean()*10) / 10
+			}
+			cd.Value = &v
+			top = math.Max(top, v)
+		}
+		out = append(out, cd)
+	}
+	for i := range out {
+		if v := out[i].Value; v != nil && *v > 0 {
+			out[i].Level = min(4, max(1, int(math.Ceil(*v/top*4))))
+		}
+	}
+	return out, top
+}
+
+// calendarHandler returns each gym's days over a month or a quarter, one
+// value a day, for a contribution-style heatmap.
+//
+//	GET /api/calendar[?period=YYYY-MM|YYYY-Qn][&stat=mean|peak][&metric=NAME][&location=NAME]
+//
+// The period defaults to this month. stat mean (the default) is each day's
+// average reading, peak its highest. Days without readings, such as those
+// still to come, are null.
+func calendarHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	tallinn := gymdata.Tallinn()
+	period := strings.TrimSpace(q.Get("period"))
+	if period == "" {
+		period = time.Now().In(tallinn).Format("2006-01")
+	}
+	from, end, err := parseCalendarPeriod(period, tallinn)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fieldErr("period", ErrBadRange, errors.New("period must be YYYY-MM or YYYY-Qn")))
+		return
+	}
+	stat := q.Get("stat")
+	switch stat {
+	case "":
+		stat = "mean"
+	case "mean", "peak":
+	default:
+		writeError(w, http.StatusBadRequest, fieldErr("stat", nil, errors.New("stat must be mean or peak")))
+		return
+	}
+	metric := gymdata.NormalizeMetrics([]string{q.Get("metric")})[0]
+
+	cfg := requestConfig(r)
+	list, err := loadWindow(cfg, from, end, []string{metric})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, withKind(ErrParse, err))
+		return
+	}
+
+	resp := CalendarResponse{
+		Period:    period,
+		From:      from.Format("2006-01-02"),
+		To:        end.AddDate(0, 0, -1).Format("2006-01-02"),
+		Stat:      stat,
+		Metric:    metric,
+		Locations: []CalendarLocation{},
+	}
+	location := strings.TrimSpace(q.Get("location"))
+	for _, s := range list {
+		if location != "" && !strings.EqualFold(s.Key.Location, location) {
+			continue
+		}
+		days, top := calendarDays(dailyStats(s, tallinn), from, end, stat == "peak")
+		resp.Locations = append(resp.Locations, CalendarLocation{Name: s.Key.Location, Max: top, Days: days})
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCalendarHandler(t *testing.T) {
+	loadTallinn(t)
+	old := currentConfig()
+	defer setConfig(old)
+	dir := t.TempDir()
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	row := func(ts, n string) string { return ts + ",EEST,1,Hipodroom," + n + ",success,{}\n" }
+	// October 2025 starts on a Wednesday.
+	writeCSV(t, dir, "gym-stats-20251001.csv", header+row("2025-10-01 10:00:00", "10")+row("2025-10-01 12:00:00", "30"))
+	writeCSV(t, dir, "gym-stats-20251002.csv", header+row("2025-10-02 10:00:00", "40"))
+	writeCSV(t, dir, "gym-stats-20251006.csv", header+row("2025-10-06 10:00:00", "0"))
+	setConfig(&Config{DataDir: dir})
+
+	get := func(query string) (int, CalendarResponse) {
+		w := httptest.NewRecorder()
+		calendarHandler(w, httptest.NewRequest("GET", "/api/calendar"+query, nil))
+		var resp CalendarResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+	code, resp := get("?period=2025-10")
+	if code != 200 || resp.From != "2025-10-01" || resp.To != "2025-10-31" || resp.Stat != "mean" || len(resp.Locations) != 1 {
+		t.Fatalf("code %d, %+v", code, resp)
+	}
+	cal := resp.Locations[0]
+	if len(cal.Days) != 31 || cal.Max != 40 {
+		t.Fatalf("%d days, max %v; want 31 and 40", len(cal.Days), cal.Max)
+	}
+	value := func(d CalendarDay) float64 {
+		if d.Value == nil {
+			return -1
+		}
+		return *d.Value
+	}
+	for i, want := range map[int]struct {
+		week, weekday, level int
+		value                float64
+	}{
+		0: {0, 2, 2, 20},
+		1: {0, 3, 4, 40},
+		2: {0, 4, 0, -1},
+		5: {1, 0, 0, 0},
+	} {
+		if d := cal.Days[i]; d.Week != want.week || d.Weekday != want.weekday || d.Level != want.level || value(d) != want.value {
+			t.Errorf("%s = week %d, weekday %d, level %d, value %v; want %+v", d.Date, d.Week, d.Weekday, d.Level, value(d), want)
+		}
+	}
+
+	_, resp = get("?period=2025-10&stat=peak")
+	if d := resp.Locations[0].Days[0]; value(d) != 30 || d.Level != 3 {
+		t.Errorf("peak October 1st = %+v, want 30 at level 3", d)
+	}
+	_, resp = get("?period=2025-Q4")
+	if resp.To != "2025-12-31" || len(resp.Locations[0].Days) != 92 {
+		t.Errorf("Q4 = %s..%s, %d days", resp.From, resp.To, len(resp.Locations[0].Days))
+	}
+	for _, q := range []string{"?period=2025-Q5", "?period=2025-13", "?period=2025-10&stat=max"} {
+		if code, _ := get(q); code != 400 {
+			t.Errorf("%s: code %d, want 400", q, code)
+		}
+	}
+}
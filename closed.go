@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// ClosedLocation is a gym that has shut, or is otherwise not to be shown:
+// charts, status and busyness leave it out unless a request asks for it.
+// Its readings stay in the files. Source is "config" for CLOSED_LOCATIONS,
+// which only a config change reopens, and "api" for the store's entries.
+type ClosedLocation struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason,omitempty"`
+	Closed string `json:"closed,omitempty"`
+	By     string `json:"by,omitempty"`
+	Source string `json:"source,omitempty"`
+}
+
+var closedMu sync.Mutex
+
+// readClosed loads the store; a missing file is an empty list.
+func readClosed(cfg *Config) ([]ClosedLocation, error) {
+	data, err := os.ReadFile(cfg.path(cfg.ClosedFile))
+	if os.IsNotExist(err) {
+		return []ClosedLocation{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := []ClosedLocation{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %v", cfg.ClosedFile, err)
+	}
+	return list, nil
+}
+
+// writeClosed saves the closures.
+func writeClosed(cfg *Config, list []ClosedLocation) error {
+	return writeFileAtomic(cfg.path(cfg.ClosedFile), list)
+}
+
+// closedList is CLOSED_LOCATIONS followed by the store, sorted by name.
+func closedList(cfg *Config) ([]ClosedLocation, error) {
+	closedMu.Lock()
+	stored, err := readClosed(cfg)
+	closedMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]ClosedLocation, 0, len(cfg.ClosedLocations)+len(stored))
+	for _, name := range cfg.ClosedLocations {
+		list = append(list, ClosedLocation{Name: name, Source: "config"})
+	}
+	for _, c := range stored {
+		c.Source = "api"
+		list = append(list, c)
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// closedSet holds the names of the closed gyms.
+type closedSet map[string]bool
+
+// closedLocations is the set to leave out of cfg's responses. A store that
+// can't be read leaves out only CLOSED_LOCATIONS, rather than failing every
+// chart over it.
+func closedLocations(cfg *Config) closedSet {
+	set := closedSet{}
+	for _, name := range cfg.ClosedLocations {
+		set[name] = true
+	}
+	closedMu.Lock()
+	stored, _ := readClosed(cfg)
+	closedMu.Unlock()
+	for _, c := range stored {
+		set[c.Name] = true
+	}
+	return set
+}
+
+// has reports whether location is closed, or is an area of a closed club.
+func (s closedSet) has(location string) bool {
+	if s[location] {
+		return true
+	}
+	club, area := gymdata.SplitArea(location)
+	return area != "" && s[club]
+}
+
+// requestClosed reads a request's closed option: exclude, the default,
+// leaves closed gyms out; include charts their history like any other.
+func requestClosed(closed string) (include bool, err error) {
+	switch strings.ToLower(strings.TrimSpace(closed)) {
+	case "", "exclude":
+		return false, nil
+	case "include":
+		return true, nil
+	}
+	return false, fmt.Errorf("closed must be exclude or include")
+}
+
+// withoutClosed returns list less the closed gyms' series. list itself,
+// which may be cached, is not changed.
+func withoutClosed(list []*gymdata.Series, closed closedSet) []*gymdata.Series {
+	if len(closed) == 0 {
+		return list
+	}
+	out := make([]*gymdata.Series, 0, len(list))
+	for _, s := range list {
+		if !closed.has(s.Key.Location) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// chartClosed is the set a chart request leaves out: none when it asks to
+// include closed gyms.
+func chartClosed(cfg *Config, include bool) closedSet {
+	if include {
+		return nil
+	}
+	return closedLocations(cfg)
+}
+
+// closedHandler serves the closed-gym list. Viewers read; changes are
+// admin-only and audited.
+//
+//	GET    /api/locations/closed
+//	POST   /api/locations/closed {name[, reason]}
+//	DELETE /api/locations/closed?name=NAME
+func closedHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "OPTIONS":
+		requireRole(RoleViewer, listClosedHandler)(w, r)
+	default:
+		requireRole(RoleAdmin, changeClosedHandler)(w, r)
+	}
+}
+
+func listClosedHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	list, err := closedList(requestConfig(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"closed": list})
+}
+
+func changeClosedHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" && r.Method != "DELETE" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	fail := func(status int, err error) {
+		writeError(w, status, err)
+	}
+
+	var in ClosedLocation
+	if r.Method == "POST" {
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&in); err != nil {
+			fail(http.StatusBadRequest, fmt.Errorf("invalid request body"))
+			return
+		}
+	} else {
+		in.Name = r.URL.Query().Get("name")
+	}
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		fail(http.StatusBadRequest, fmt.Errorf("name is required"))
+		return
+	}
+
+	cfg := requestConfig(r)
+	for _, name := range cfg.ClosedLocations {
+		if name == in.Name {
+			fail(http.StatusConflict, fmt.Errorf("%s is closed by CLOSED_LOCATIONS", in.Name))
+			return
+		}
+	}
+	closedMu.Lock()
+	defer closedMu.Unlock()
+	list, err := readClosed(cfg)
+	if err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	idx := -1
+	for i, c := range list {
+		if c.Name == in.Name {
+			idx = i
+		}
+	}
+
+	var action string
+	switch r.Method {
+	case "POST":
+		if idx != -1 {
+			fail(http.StatusConflict, fmt.Errorf("%s is already closed", in.Name))
+			return
+		}
+		action = "location-close"
+		_, actor, _ := requestRole(r, cfg)
+		in.Closed = time.Now().UTC().Format(time.RFC3339)
+		in.By = actor
+		in.Source = ""
+		list = append(list, in)
+	case "DELETE":
+		if idx == -1 {
+			fail(http.StatusNotFound, fmt.Errorf("%s is not closed", in.Name))
+			return
+		}
+		action = "location-reopen"
+		in = list[idx]
+		list = append(list[:idx], list[idx+1:]...)
+	}
+
+	err = writeClosed(cfg, list)
+	recordAudit(r, action, map[string]any{"name": in.Name, "reason": in.Reason}, err)
+	if err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	publishConfig() // pages show the gym as closed, or open again
+	in.Source = "api"
+	if r.Method == "POST" {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(in)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gym/internal/gymdata"
+)
+
+func TestClosedLocations(t *testing.T) {
+	tallinn := loadTallinn(t)
+	keys, _ := parseAPIKeys("alice:admintoken:admin,tv:viewtoken:viewer")
+	old := currentConfig()
+	defer setConfig(old)
+	dir := t.TempDir()
+	writeCSV(t, dir, "gym-stats-20251001.csv", "timestamp,timezone,location_id,location_name,user_count,status,response\n"+
+		"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,{}\n"+
+		"2025-10-01 10:00:00,EEST,2,Kristiine,30,success,{}\n"+
+		"2025-10-01 10:02:00,EEST,3,Tartu,5,success,{}\n")
+	cfg := &Config{
+		DataDir:         dir,
+		APIKeys:         keys,
+		AnonymousRole:   RoleViewer,
+		AuditLog:        filepath.Join(dir, "audit.jsonl"),
+		ClosedFile:      filepath.Join(dir, "closed.json"),
+		ClosedLocations: []string{"Tartu"},
+	}
+	setConfig(cfg)
+
+	call := func(method, path, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		closedHandler(w, r)
+		return w
+	}
+	if w := call("POST", "/api/locations/closed", "viewtoken", `{"name":"Kristiine"}`); w.Code != http.StatusForbidden {
+		t.Errorf("viewer close: code %d, want 403", w.Code)
+	}
+	if w := call("POST", "/api/locations/closed", "admintoken", `{"name":" Kristiine ","reason":"moved"}`); w.Code != http.StatusCreated {
+		t.Fatalf("close: code %d: %s", w.Code, w.Body)
+	}
+	if w := call("POST", "/api/locations/closed", "admintoken", `{"name":"Kristiine"}`); w.Code != http.StatusConflict {
+		t.Errorf("close twice: code %d, want 409", w.Code)
+	}
+	if w := call("DELETE", "/api/locations/closed?name=Tartu", "admintoken", ""); w.Code != http.StatusConflict {
+		t.Errorf("reopen config entry: code %d, want 409", w.Code)
+	}
+
+	w := call("GET", "/api/locations/closed", "", "")
+	var got struct{ Closed []ClosedLocation }
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if len(got.Closed) != 2 || got.Closed[0].Name != "Kristiine" || got.Closed[0].Source != "api" || got.Closed[0].By != "alice" || got.Closed[1].Source != "config" {
+		t.Errorf("list = %s", w.Body)
+	}
+
+	status := readLatestStatus(cfg, tallinn)
+	if len(status.Locations) != 1 || status.Locations[0].Name != "Hipodroom" || !strings.HasSuffix(status.Latest, "10:02:00+03:00") {
+		t.Errorf("status = %+v", status)
+	}
+
+	list := []*gymdata.Series{
+		{Key: gymdata.Key{Location: "Hipodroom"}},
+		{Key: gymdata.Key{Location: "Kristiine" + gymdata.AreaSeparator + "Pool"}},
+		{Key: gymdata.Key{Location: "Tartu"}},
+	}
+	include, _ := requestClosed("include")
+	if got := withoutClosed(list, chartClosed(cfg, include)); len(got) != 3 {
+		t.Errorf("include: %d series", len(got))
+	}
+	if got := withoutClosed(list, chartClosed(cfg, false)); len(got) != 1 || got[0] != list[0] {
+		t.Errorf("exclude: %d series", len(got))
+	}
+	if _, err := requestClosed("hide"); err == nil {
+		t.Error("closed=hide: expected error")
+	}
+
+	if w := call("DELETE", "/api/locations/closed?name=Kristiine", "admintoken", ""); w.Code != http.StatusOK {
+		t.Errorf("reopen: code %d: %s", w.Code, w.Body)
+	}
+	if status := readLatestStatus(cfg, tallinn); len(status.Locations) != 2 {
+		t.Errorf("after reopening: %+v", status.Locations)
+	}
+	entries, _ := readAudit(cfg, "", 10)
+	if len(entries) != 2 || entries[0].Action != "location-reopen" || entries[1].Action != "location-close" {
+		t.Errorf("audit = %+v", entries)
+	}
+}
@@ -0,0 +1,904 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// Config holds the server settings. They come from the same gym-config.env the
+// collector sources (KEY=VALUE lines; CONFIG_FILE overrides the path), so
+// systemd and launchd installs share one file; real environment variables win
+// over the file.
+type Config struct {
+	MQTTBroker          string
+	MQTTUsername        string
+	MQTTPassword        string
+	MQTTClientID        string
+	MQTTInterval        time.Duration
+	MQTTDiscoveryPrefix string
+	MQTTTopicPrefix     string
+
+	TelegramToken        string
+	TelegramAllowedChats []int64
+
+	// SLOSuccess and SLOFreshness are the collector's objectives, in percent
+	// over the last SLOWindowDays: of polls that succeed, and of time the
+	// newest reading is at most SLOMaxAge old. A stale reading is reported
+	// to SLOAlertURL and the SLOAlertChats (with TelegramToken's bot).
+	SLOSuccess    float64
+	SLOFreshness  float64
+	SLOMaxAge     time.Duration
+	SLOWindowDays int
+	SLOAlertURL   string
+	SLOAlertChats []int64
+
+	// The live check proxies the collector's upstream API (LIVE_API_URL,
+	// authenticated with the collector's own API_TOKEN).
+	LiveAPIURL      string
+	LiveAPIToken    string
+	LiveCacheTTL    time.Duration
+	LiveClientLimit int // requests per client per minute
+
+	APIKeys       []APIKey
+	AnonymousRole Role
+
+	// OIDCIssuer, when set, lets OIDCAdmins (emails, or @domains) sign in
+	// at /auth/login with that OpenID Connect provider and get an admin
+	// session cookie lasting SessionTTL.
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCAdmins       []string
+	SessionTTL       time.Duration
+
+	AuditLog        string
+	AnnotationsFile string
+	PrefsFile       string
+	GoalsFile       string
+	// ClosedFile stores the gyms closed through the API; ClosedLocations are
+	// closed by config. Both are left out of charts and status by default.
+	ClosedFile      string
+	ClosedLocations []string
+	// RecordsFile keeps the per-day summaries /api/records is worked out of.
+	RecordsFile string
+
+	// VAPIDKey, when set, signs the Web Push alerts kept in PushFile;
+	// VAPIDSubject is the contact push services are given.
+	VAPIDKey     *ecdsa.PrivateKey
+	VAPIDSubject string
+	PushFile     string
+	// PushHosts are the push services subscriptions may point at: a host,
+	// or *.domain for any of its subdomains.
+	PushHosts []string
+
+	// HistoryFile logs chart generation requests for /api/history.
+	HistoryFile string
+	// RollupDir keeps each daily file's headcount pre-aggregated at 10
+	// minutes, an hour and a day, for wide ranges to read instead of rows.
+	RollupDir string
+
+	CORSOrigins []string
+
+	// TrustedProxies are the reverse proxies (addresses or CIDRs) whose
+	// X-Forwarded-For is believed for the client's address. AdminAllow, if
+	// set, are the only addresses admin endpoints answer. AccessLog logs a
+	// line per request with that address.
+	TrustedProxies []netip.Prefix
+	AdminAllow     []netip.Prefix
+	AccessLog      bool
+
+	// GymColors and DashboardRefresh are the dashboard's, via /api/config.
+	GymColors        map[string]string
+	DashboardRefresh time.Duration
+	// AppName and AppShortName name the installed app, in the generated
+	// PWA manifest.
+	AppName      string
+	AppShortName string
+
+	// WebDir holds the pages and their assets, the only files served
+	// besides gym-data.json. Only the base config's is used.
+	WebDir string
+
+	CSVColumns    map[string]string // file header -> canonical name
+	CSVTimeLayout string
+	StatusPolicy  gymdata.StatusPolicy
+	AreaPattern   *regexp.Regexp // splits location names into club / area
+	// LocationAliases maps gyms' old names to their current ones, so a gym
+	// renamed upstream keeps one history.
+	LocationAliases map[string]string
+
+	// OutlierFilter is what the chart endpoints do with impossible readings
+	// unless a request asks otherwise; Capacities (lowercased gym -> people)
+	// times OutlierFactor is the most a gym can plausibly hold.
+	OutlierFilter outlierMode
+	Capacities    map[string]float64
+	OutlierFactor float64
+
+	// DerivedLocations are extra gyms computed from others' readings, and
+	// DerivedMetrics extra metrics computed from a gym's own, both served
+	// like any other series.
+	DerivedLocations []derivedSeries
+	DerivedMetrics   []derivedSeries
+
+	// PreloadDays is how many days, up to today, are parsed into memory at
+	// startup; 0 leaves the first requests to do it.
+	PreloadDays int
+	// CacheSnapshot is where the parsed files are saved on shutdown and
+	// restored from at startup, relative to DATA_DIR; empty for neither.
+	// Only the base config's is used: the cache is the process's.
+	CacheSnapshot string
+
+	// MaxHeavy is how many heavy requests (range charts, busyness and the
+	// like) may run at once, 0 for no limit; up to HeavyQueue more wait up
+	// to HeavyQueueWait for a turn. Only the base config's are used: memory
+	// is the process's.
+	MaxHeavy       int
+	HeavyQueue     int
+	HeavyQueueWait time.Duration
+	// HeavyTimeout is how long a heavy request may take once admitted, in
+	// place of WriteTimeout.
+	HeavyTimeout time.Duration
+
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout, IdleTimeout and
+	// MaxHeaderBytes are the HTTP server's limits, so slow or stalled clients
+	// can't hold connections open. Only the base config's are used, at
+	// startup: there is one listener.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+
+	// IntegrityCheck is what startup does about corrupt daily files: nothing,
+	// warn on /readyz, or refuse to start. IntegrityMaxBad is the percent of
+	// a file's rows that may be unparsable or out of order before it counts.
+	IntegrityCheck  integrityMode
+	IntegrityMaxBad float64
+
+	// TraceEndpoint is the OTLP/HTTP URL spans are posted to, with
+	// TraceHeaders (an API key, say); unset switches tracing off. Only the
+	// base config's are used: one process, one exporter.
+	TraceEndpoint string
+	TraceHeaders  map[string]string
+	ServiceName   string
+
+	// CSVSource, when set, is an s3://bucket[/prefix] the daily CSVs are read
+	// from instead of DataDir, with the S3_* settings saying how to reach it.
+	CSVSource   string
+	S3Endpoint  string
+	S3Region    string
+	S3AccessKey string
+	S3SecretKey string
+
+	// ShadowSource, when set, is a second store (a directory or s3:// bucket)
+	// the chart builds are also read from and compared against, logging
+	// differences to ShadowLog, to check a migration before cutting over.
+	ShadowSource string
+	ShadowLog    string
+
+	// ReplicaTarget, when set, is a directory, sftp:// server or s3://
+	// bucket that each finished day's CSV and ReplicaFiles are copied to
+	// every ReplicaInterval, ReplicaFile recording what was copied.
+	ReplicaTarget   string
+	ReplicaFile     string
+	ReplicaFiles    []string
+	ReplicaInterval time.Duration
+
+	// SheetsCredentials, when set, is a service account's JSON key that
+	// POST /api/import/sheets reads SheetsID's SheetsRange with, its header
+	// renamed per SheetsColumns (as CSV_COLUMNS).
+	SheetsCredentials string
+	SheetsID          string
+	SheetsRange       string
+	SheetsColumns     map[string]string
+
+	// IngestHook, when set, is a command every batch of ingested readings is
+	// piped through, as JSON, before it is queued; it may change, add or
+	// drop readings. IngestHookTimeout bounds each run.
+	IngestHook        []string
+	IngestHookTimeout time.Duration
+
+	// WeatherProvider, when set (open-meteo), is where the hourly weather at
+	// WeatherLatitude, WeatherLongitude comes from for chart overlays and
+	// /api/weather: WeatherURL for recent days, WeatherArchiveURL for older
+	// ones. Fetched days are kept in WeatherFile.
+	WeatherProvider   string
+	WeatherURL        string
+	WeatherArchiveURL string
+	WeatherLatitude   float64
+	WeatherLongitude  float64
+	WeatherFile       string
+
+	// ShareSecret, when set, signs share links: read-only views of a range
+	// and some gyms that anyone holding the link may open until it expires.
+	// Changing it revokes every link.
+	ShareSecret string
+
+	// DataDir holds the daily CSVs and the state files named above; "" is the
+	// working directory. The base config's is DATA_DIR, e.g. a container's
+	// volume.
+	DataDir string
+	Tenants map[string]*Config
+
+	// s3Sources are the buckets the settings read from or write to, made
+	// the ones their paths resolve to by registerSources.
+	s3Sources []*gymdata.S3Source
+}
+
+// csvDir is where the daily CSVs are listed from: CSVSource if set, else
+// DataDir.
+func (c *Config) csvDir() string {
+	if c.CSVSource != "" {
+		return c.CSVSource
+	}
+	return c.DataDir
+}
+
+// registerSources makes the config's S3 sources, and its tenants', the ones
+// their paths resolve to, replacing the running config's. Only a config that
+// has loaded and validated in full may: a rejected reload must leave the
+// running one's in place.
+func (c *Config) registerSources() {
+	for _, src := range c.s3Sources {
+		gymdata.RegisterS3Source(src)
+	}
+	for _, t := range c.Tenants {
+		t.registerSources()
+	}
+}
+
+// format is how the config's daily CSVs are to be read.
+func (c *Config) format() gymdata.Format {
+	return gymdata.Format{Columns: c.CSVColumns, TimeLayout: c.CSVTimeLayout, Policy: c.StatusPolicy, AreaPattern: c.AreaPattern, Aliases: c.LocationAliases}
+}
+
+// location is the location a row's readings go under, as gymdata reads
+// it: location_name, split into club and area by the file's area column
+// (areaIdx, -1 if none) or AREA_PATTERN.
+func (c *Config) location(record []string, locIdx, areaIdx int) string {
+	area := ""
+	if areaIdx != -1 && areaIdx < len(record) {
+		area = record[areaIdx]
+	}
+	return c.format().Location(record[locIdx], area)
+}
+
+// path resolves a file name the config refers to against its DataDir.
+func (c *Config) path(name string) string {
+	if c.DataDir == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(c.DataDir, name)
+}
+
+var (
+	config   atomic.Pointer[Config]
+	reloadMu sync.Mutex
+
+	// demoMode is set by --demo before serving; its config isn't read from
+	// gym-config.env, so a reload must not swap that in.
+	demoMode bool
+)
+
+func init() {
+	config.Store(&Config{})
+}
+
+// currentConfig is the active config. Callers should take it once per request
+// or loop iteration so a concurrent reload can't mix old and new values.
+func currentConfig() *Config {
+	return config.Load()
+}
+
+func setConfig(c *Config) {
+	config.Store(c)
+	select {
+	case configChanged <- struct{}{}:
+	default:
+	}
+	publishConfig()
+}
+
+// reloadConfig re-reads the config and swaps it in only if it loads and
+// validates; on any error the running config stays active.
+func reloadConfig() (*Config, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	if demoMode {
+		return nil, fmt.Errorf("demo mode: the config can't be reloaded")
+	}
+	c, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	c.registerSources()
+	setConfig(c)
+	return c, nil
+}
+
+// parseEnvFile reads KEY=VALUE lines, skipping blanks and # comments. It
+// accepts an optional "export " prefix and surrounding quotes so the file stays
+// valid for `source` in the collector script.
+func parseEnvFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, lineNo)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+// loadConfig builds a Config from the env file (if present) and the process
+// environment, which wins. A missing file is not an error; a malformed one
+// is. The file is DATA_DIR's gym-config.env unless CONFIG_FILE names one,
+// so the environment alone can configure everything.
+func loadConfig() (*Config, error) {
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		path = filepath.Join(os.Getenv("DATA_DIR"), "gym-config.env")
+	}
+	file, err := parseEnvFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var fileErr error
+	get := func(key, def string) string {
+		if v, ok := os.LookupEnv(key); ok {
+			return v
+		}
+		if v, ok := file[key]; ok {
+			return v
+		}
+		if name, ok := os.LookupEnv(key + "_FILE"); ok {
+			v, err := readSecretFile(name)
+			if err != nil && fileErr == nil {
+				fileErr = fmt.Errorf("%s_FILE: %v", key, err)
+			}
+			return v
+		}
+		return def
+	}
+
+	c, err := buildConfig(get)
+	if fileErr != nil {
+		return nil, fileErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.DataDir = strings.TrimSpace(get("DATA_DIR", "")); c.DataDir != "" {
+		if info, err := os.Stat(c.DataDir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("DATA_DIR: %q is not a directory", c.DataDir)
+		}
+	}
+	if c.Tenants, err = loadTenants(get("TENANTS", ""), get); err != nil {
+		return nil, fmt.Errorf("TENANTS: %v", err)
+	}
+	return c, nil
+}
+
+// readSecretFile reads a setting given as KEY_FILE, as Docker and
+// Kubernetes mount secrets, without the file's trailing newline.
+func readSecretFile(name string) (string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+var tenantName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// ownSettings are those a tenant never inherits from the base config: they
+// point at the base chain's data (its bucket, its upstream API).
+var ownSettings = map[string]bool{"CSV_SOURCE": true, "SHADOW_SOURCE": true, "LIVE_API_URL": true, "API_TOKEN": true,
+	"OIDC_ISSUER": true, "OIDC_CLIENT_ID": true, "OIDC_CLIENT_SECRET": true, "OIDC_REDIRECT_URL": true, "OIDC_ADMINS": true,
+	"SHEETS_CREDENTIALS": true, "SHEETS_ID": true}
+
+// loadTenants reads TENANTS: comma-separated name=dir pairs. Each tenant's
+// config is its dir's gym-config.env over the base settings, so a chain only
+// lists what differs (its API keys, CSV_COLUMNS...).
+func loadTenants(s string, base func(key, def string) string) (map[string]*Config, error) {
+	if s == "" {
+		return nil, nil
+	}
+	tenants := map[string]*Config{}
+	for _, pair := range splitList(s) {
+		name, dir, ok := strings.Cut(pair, "=")
+		name, dir = strings.TrimSpace(name), strings.TrimSpace(dir)
+		if !ok || dir == "" {
+			return nil, fmt.Errorf("%q: want name=dir", pair)
+		}
+		if !tenantName.MatchString(name) {
+			return nil, fmt.Errorf("%q: names are lowercase letters, digits and dashes", name)
+		}
+		if tenants[name] != nil {
+			return nil, fmt.Errorf("%q listed twice", name)
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("%s: %q is not a directory", name, dir)
+		}
+		file, err := parseEnvFile(filepath.Join(dir, "gym-config.env"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		get := func(key, def string) string {
+			if v, ok := file[key]; ok {
+				return v
+			}
+			if ownSettings[key] {
+				return def // the base chain's, not this tenant's
+			}
+			return base(key, def)
+		}
+		c, err := buildConfig(get)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		c.DataDir = dir
+		tenants[name] = c
+	}
+	return tenants, nil
+}
+
+// buildConfig parses and validates the settings get returns.
+func buildConfig(get func(key, def string) string) (*Config, error) {
+	var err error
+	c := &Config{
+		MQTTBroker:          get("MQTT_BROKER", ""),
+		MQTTUsername:        get("MQTT_USERNAME", ""),
+		MQTTPassword:        get("MQTT_PASSWORD", ""),
+		MQTTClientID:        get("MQTT_CLIENT_ID", "ronimis-gym-server"),
+		MQTTDiscoveryPrefix: get("MQTT_DISCOVERY_PREFIX", "homeassistant"),
+		MQTTTopicPrefix:     get("MQTT_TOPIC_PREFIX", "ronimis"),
+		AuditLog:            get("AUDIT_LOG", "gym-audit.jsonl"),
+		AnnotationsFile:     get("ANNOTATIONS_FILE", "gym-annotations.json"),
+		PrefsFile:           get("PREFS_FILE", "gym-prefs.json"),
+		GoalsFile:           get("GOALS_FILE", "gym-goals.json"),
+		ClosedFile:          get("CLOSED_FILE", "gym-closed.json"),
+		ClosedLocations:     splitList(get("CLOSED_LOCATIONS", "")),
+		RecordsFile:         get("RECORDS_FILE", "gym-records.json"),
+		PushFile:            get("PUSH_FILE", "gym-push.json"),
+		HistoryFile:         get("HISTORY_FILE", "gym-history.jsonl"),
+		RollupDir:           get("ROLLUP_DIR", "gym-rollups"),
+		VAPIDSubject:        strings.TrimSpace(get("VAPID_SUBJECT", "")),
+	}
+	if c.MQTTInterval, err = parseSeconds(get("MQTT_INTERVAL", "120")); err != nil {
+		return nil, fmt.Errorf("MQTT_INTERVAL: %v", err)
+	}
+	if c.APIKeys, err = parseAPIKeys(get("API_KEYS", "")); err != nil {
+		return nil, fmt.Errorf("API_KEYS: %v", err)
+	}
+	if c.AnonymousRole, err = parseRole(get("AUTH_ANONYMOUS_ROLE", "viewer")); err != nil {
+		return nil, fmt.Errorf("AUTH_ANONYMOUS_ROLE: %v", err)
+	}
+	c.OIDCIssuer = strings.TrimSpace(get("OIDC_ISSUER", ""))
+	c.OIDCClientID = strings.TrimSpace(get("OIDC_CLIENT_ID", ""))
+	c.OIDCClientSecret = get("OIDC_CLIENT_SECRET", "")
+	c.OIDCRedirectURL = strings.TrimSpace(get("OIDC_REDIRECT_URL", ""))
+	for _, a := range splitList(get("OIDC_ADMINS", "")) {
+		c.OIDCAdmins = append(c.OIDCAdmins, strings.ToLower(a))
+	}
+	sessionHours, err := strconv.Atoi(get("SESSION_HOURS", "12"))
+	if err != nil || sessionHours < 1 || sessionHours > 720 {
+		return nil, fmt.Errorf("SESSION_HOURS: want 1-720 hours")
+	}
+	c.SessionTTL = time.Duration(sessionHours) * time.Hour
+	c.TelegramToken = get("TELEGRAM_BOT_TOKEN", "")
+	if c.TelegramAllowedChats, err = parseChatIDs(get("TELEGRAM_ALLOWED_CHATS", "")); err != nil {
+		return nil, fmt.Errorf("TELEGRAM_ALLOWED_CHATS: %v", err)
+	}
+	if c.SLOSuccess, err = strconv.ParseFloat(get("SLO_SUCCESS", "99"), 64); err != nil || c.SLOSuccess <= 0 || c.SLOSuccess >= 100 {
+		return nil, fmt.Errorf("SLO_SUCCESS: want a percentage below 100")
+	}
+	if c.SLOFreshness, err = strconv.ParseFloat(get("SLO_FRESHNESS", "99"), 64); err != nil || c.SLOFreshness <= 0 || c.SLOFreshness >= 100 {
+		return nil, fmt.Errorf("SLO_FRESHNESS: want a percentage below 100")
+	}
+	if c.SLOMaxAge, err = parseSeconds(get("SLO_MAX_AGE_SECONDS", "600")); err != nil {
+		return nil, fmt.Errorf("SLO_MAX_AGE_SECONDS: %v", err)
+	}
+	if c.SLOWindowDays, err = strconv.Atoi(get("SLO_WINDOW_DAYS", "28")); err != nil || c.SLOWindowDays < 1 || c.SLOWindowDays > 90 {
+		return nil, fmt.Errorf("SLO_WINDOW_DAYS: want 1-90 days")
+	}
+	c.SLOAlertURL = strings.TrimSpace(get("SLO_ALERT_URL", ""))
+	if c.SLOAlertChats, err = parseChatIDs(get("SLO_ALERT_CHATS", "")); err != nil {
+		return nil, fmt.Errorf("SLO_ALERT_CHATS: %v", err)
+	}
+	c.CORSOrigins = splitList(get("CORS_ORIGINS", ""))
+	if c.PushHosts = splitList(get("PUSH_HOSTS", "")); len(c.PushHosts) == 0 {
+		c.PushHosts = defaultPushHosts
+	}
+	if c.TrustedProxies, err = parsePrefixes(get("TRUSTED_PROXIES", "")); err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXIES: %v", err)
+	}
+	if c.AdminAllow, err = parsePrefixes(get("ADMIN_ALLOW", "")); err != nil {
+		return nil, fmt.Errorf("ADMIN_ALLOW: %v", err)
+	}
+	if c.AccessLog, err = strconv.ParseBool(get("ACCESS_LOG", "false")); err != nil {
+		return nil, fmt.Errorf("ACCESS_LOG: want true or false")
+	}
+	if c.VAPIDKey, err = parseVAPIDKey(get("VAPID_PRIVATE_KEY", "")); err != nil {
+		return nil, fmt.Errorf("VAPID_PRIVATE_KEY: %v", err)
+	}
+	if c.GymColors, err = parseGymColors(get("GYM_COLORS", defaultGymColors)); err != nil {
+		return nil, fmt.Errorf("GYM_COLORS: %v", err)
+	}
+	if c.DashboardRefresh, err = parseSeconds(get("DASHBOARD_REFRESH_SECONDS", "60")); err != nil {
+		return nil, fmt.Errorf("DASHBOARD_REFRESH_SECONDS: %v", err)
+	}
+	c.AppName = strings.TrimSpace(get("APP_NAME", "Gym Occupancy"))
+	c.AppShortName = strings.TrimSpace(get("APP_SHORT_NAME", "Gym"))
+	c.WebDir = strings.TrimSpace(get("WEB_DIR", "web"))
+	c.LiveAPIURL = strings.TrimSpace(get("LIVE_API_URL", "https://ministeerium.codeventions.com/api/v01/openair/climbers_in_all"))
+	c.LiveAPIToken = get("API_TOKEN", "")
+	if c.LiveCacheTTL, err = parseSeconds(get("LIVE_CACHE_SECONDS", "60")); err != nil {
+		return nil, fmt.Errorf("LIVE_CACHE_SECONDS: %v", err)
+	}
+	if c.LiveClientLimit, err = strconv.Atoi(get("LIVE_CLIENT_LIMIT", "30")); err != nil || c.LiveClientLimit < 1 {
+		return nil, fmt.Errorf("LIVE_CLIENT_LIMIT: want a positive number of requests a minute")
+	}
+	if c.PreloadDays, err = strconv.Atoi(get("PRELOAD_DAYS", "0")); err != nil || c.PreloadDays < 0 || c.PreloadDays > 366 {
+		return nil, fmt.Errorf("PRELOAD_DAYS: want 0-366 days")
+	}
+	c.CacheSnapshot = strings.TrimSpace(get("CACHE_SNAPSHOT", "gym-cache.snapshot"))
+	if c.MaxHeavy, err = strconv.Atoi(get("MAX_HEAVY_REQUESTS", "4")); err != nil || c.MaxHeavy < 0 {
+		return nil, fmt.Errorf("MAX_HEAVY_REQUESTS: want a number of requests, 0 for no limit")
+	}
+	if c.HeavyQueue, err = strconv.Atoi(get("HEAVY_QUEUE", "16")); err != nil || c.HeavyQueue < 0 {
+		return nil, fmt.Errorf("HEAVY_QUEUE: want a number of requests")
+	}
+	if c.HeavyQueueWait, err = parseSeconds(get("HEAVY_QUEUE_SECONDS", "30")); err != nil {
+		return nil, fmt.Errorf("HEAVY_QUEUE_SECONDS: %v", err)
+	}
+	if c.HeavyTimeout, err = parseSeconds(get("HEAVY_TIMEOUT_SECONDS", "120")); err != nil {
+		return nil, fmt.Errorf("HEAVY_TIMEOUT_SECONDS: %v", err)
+	}
+	if c.ReadHeaderTimeout, err = parseSeconds(get("HTTP_READ_HEADER_SECONDS", "10")); err != nil {
+		return nil, fmt.Errorf("HTTP_READ_HEADER_SECONDS: %v", err)
+	}
+	if c.ReadTimeout, err = parseSeconds(get("HTTP_READ_SECONDS", "60")); err != nil {
+		return nil, fmt.Errorf("HTTP_READ_SECONDS: %v", err)
+	}
+	if c.WriteTimeout, err = parseSeconds(get("HTTP_WRITE_SECONDS", "60")); err != nil {
+		return nil, fmt.Errorf("HTTP_WRITE_SECONDS: %v", err)
+	}
+	if c.IdleTimeout, err = parseSeconds(get("HTTP_IDLE_SECONDS", "120")); err != nil {
+		return nil, fmt.Errorf("HTTP_IDLE_SECONDS: %v", err)
+	}
+	if c.MaxHeaderBytes, err = strconv.Atoi(get("HTTP_MAX_HEADER_BYTES", "65536")); err != nil || c.MaxHeaderBytes < 4096 {
+		return nil, fmt.Errorf("HTTP_MAX_HEADER_BYTES: want at least 4096 bytes")
+	}
+	if c.IntegrityCheck, err = parseIntegrityMode(get("INTEGRITY_CHECK", "warn")); err != nil {
+		return nil, fmt.Errorf("INTEGRITY_CHECK: %v", err)
+	}
+	if c.IntegrityMaxBad, err = strconv.ParseFloat(get("INTEGRITY_MAX_BAD", "5"), 64); err != nil || c.IntegrityMaxBad < 0 || c.IntegrityMaxBad > 100 {
+		return nil, fmt.Errorf("INTEGRITY_MAX_BAD: want 0-100 percent")
+	}
+	c.TraceEndpoint = get("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	if base := strings.TrimRight(get("OTEL_EXPORTER_OTLP_ENDPOINT", ""), "/"); c.TraceEndpoint == "" && base != "" {
+		c.TraceEndpoint = base + "/v1/traces"
+	}
+	if p := get("OTEL_EXPORTER_OTLP_PROTOCOL", "http/json"); p != "http/json" {
+		return nil, fmt.Errorf("OTEL_EXPORTER_OTLP_PROTOCOL: only http/json is supported, not %q", p)
+	}
+	if c.TraceHeaders, err = parseOTLPHeaders(get("OTEL_EXPORTER_OTLP_HEADERS", "")); err != nil {
+		return nil, fmt.Errorf("OTEL_EXPORTER_OTLP_HEADERS: %v", err)
+	}
+	c.ServiceName = get("OTEL_SERVICE_NAME", "gym-server")
+	if c.CSVColumns, err = gymdata.ParseColumnMap(get("CSV_COLUMNS", "")); err != nil {
+		return nil, fmt.Errorf("CSV_COLUMNS: %v", err)
+	}
+	c.CSVTimeLayout = get("CSV_TIMESTAMP_FORMAT", gymdata.DefaultTimeLayout)
+	if c.StatusPolicy, err = gymdata.ParseStatusPolicy(get("STATUS_POLICY", "")); err != nil {
+		return nil, fmt.Errorf("STATUS_POLICY: %v", err)
+	}
+	if c.AreaPattern, err = gymdata.ParseAreaPattern(get("AREA_PATTERN", "")); err != nil {
+		return nil, fmt.Errorf("AREA_PATTERN: %v", err)
+	}
+	if c.LocationAliases, err = gymdata.ParseAliases(get("LOCATION_ALIASES", "")); err != nil {
+		return nil, fmt.Errorf("LOCATION_ALIASES: %v", err)
+	}
+	if c.OutlierFilter, err = parseOutlierMode(get("OUTLIER_FILTER", "off")); err != nil {
+		return nil, fmt.Errorf("OUTLIER_FILTER: %v", err)
+	}
+	if c.Capacities, err = parseCapacities(get("CAPACITIES", "")); err != nil {
+		return nil, fmt.Errorf("CAPACITIES: %v", err)
+	}
+	if c.DerivedLocations, err = parseDerived(get("DERIVED_LOCATIONS", "")); err != nil {
+		return nil, fmt.Errorf("DERIVED_LOCATIONS: %v", err)
+	}
+	if c.DerivedMetrics, err = parseDerived(get("DERIVED_METRICS", "")); err != nil {
+		return nil, fmt.Errorf("DERIVED_METRICS: %v", err)
+	}
+	if c.OutlierFactor, err = strconv.ParseFloat(get("OUTLIER_FACTOR", "1.5"), 64); err != nil {
+		return nil, fmt.Errorf("OUTLIER_FACTOR: not a number")
+	}
+	c.CSVSource = strings.TrimSpace(get("CSV_SOURCE", ""))
+	c.S3Endpoint = get("S3_ENDPOINT", "")
+	c.S3Region = get("S3_REGION", "us-east-1")
+	c.S3AccessKey = get("S3_ACCESS_KEY", "")
+	c.S3SecretKey = get("S3_SECRET_KEY", "")
+	c.ShadowSource = strings.TrimSpace(get("SHADOW_SOURCE", ""))
+	c.ShadowLog = get("SHADOW_LOG", "gym-shadow.jsonl")
+	c.ReplicaFile = get("REPLICA_FILE", "gym-replica.json")
+	c.ReplicaFiles = splitList(get("REPLICA_FILES", ""))
+	if c.ReplicaInterval, err = parseSeconds(get("REPLICA_INTERVAL", "600")); err != nil {
+		return nil, fmt.Errorf("REPLICA_INTERVAL: %v", err)
+	}
+	c.SheetsCredentials = strings.TrimSpace(get("SHEETS_CREDENTIALS", ""))
+	c.SheetsID = strings.TrimSpace(get("SHEETS_ID", ""))
+	c.SheetsRange = strings.TrimSpace(get("SHEETS_RANGE", "A:Z"))
+	if c.SheetsColumns, err = gymdata.ParseColumnMap(get("SHEETS_COLUMNS", "")); err != nil {
+		return nil, fmt.Errorf("SHEETS_COLUMNS: %v", err)
+	}
+	c.IngestHook = strings.Fields(get("INGEST_HOOK", ""))
+	if c.IngestHookTimeout, err = parseSeconds(get("INGEST_HOOK_SECONDS", "10")); err != nil {
+		return nil, fmt.Errorf("INGEST_HOOK_SECONDS: %v", err)
+	}
+	c.WeatherProvider = strings.ToLower(strings.TrimSpace(get("WEATHER_PROVIDER", "")))
+	c.WeatherURL = strings.TrimSpace(get("WEATHER_URL", "https://api.open-meteo.com/v1/forecast"))
+	c.WeatherArchiveURL = strings.TrimSpace(get("WEATHER_ARCHIVE_URL", "https://archive-api.open-meteo.com/v1/archive"))
+	c.WeatherFile = get("WEATHER_FILE", "gym-weather.json")
+	// Tallinn, where the chain's gyms are.
+	if c.WeatherLatitude, err = strconv.ParseFloat(get("WEATHER_LATITUDE", "59.437"), 64); err != nil || math.Abs(c.WeatherLatitude) > 90 {
+		return nil, fmt.Errorf("WEATHER_LATITUDE: want -90 to 90 degrees")
+	}
+	if c.WeatherLongitude, err = strconv.ParseFloat(get("WEATHER_LONGITUDE", "24.754"), 64); err != nil || math.Abs(c.WeatherLongitude) > 180 {
+		return nil, fmt.Errorf("WEATHER_LONGITUDE: want -180 to 180 degrees")
+	}
+	c.ShareSecret = strings.TrimSpace(get("SHARE_SECRET", ""))
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	if c.CSVSource != "" {
+		src, err := gymdata.NewS3Source(c.CSVSource, c.S3Endpoint, c.S3Region, c.S3AccessKey, c.S3SecretKey)
+		if err != nil {
+			return nil, err
+		}
+		c.CSVSource = src.Root()
+		c.s3Sources = append(c.s3Sources, src)
+	}
+	if strings.HasPrefix(c.ShadowSource, "s3://") {
+		src, err := gymdata.NewS3Source(c.ShadowSource, c.S3Endpoint, c.S3Region, c.S3AccessKey, c.S3SecretKey)
+		if err != nil {
+			return nil, fmt.Errorf("SHADOW_SOURCE: %v", err)
+		}
+		c.ShadowSource = src.Root()
+		c.s3Sources = append(c.s3Sources, src)
+	}
+	if c.ShadowSource != "" && c.ShadowSource == c.csvDir() {
+		return nil, fmt.Errorf("SHADOW_SOURCE must differ from where the CSVs are read")
+	}
+	if c.ReplicaTarget, err = parseReplicaTarget(c, strings.TrimSpace(get("REPLICA_TARGET", ""))); err != nil {
+		return nil, fmt.Errorf("REPLICA_TARGET: %v", err)
+	}
+	if c.ReplicaTarget != "" && c.ReplicaTarget == c.csvDir() {
+		return nil, fmt.Errorf("REPLICA_TARGET must differ from where the CSVs are read")
+	}
+	return c, nil
+}
+
+// validate catches values that parse but could not work, so a reload never
+// swaps in a config that would break the running server.
+func (c *Config) validate() error {
+	if strings.TrimSpace(c.AuditLog) == "" {
+		return fmt.Errorf("AUDIT_LOG must not be empty")
+	}
+	if strings.TrimSpace(c.AnnotationsFile) == "" {
+		return fmt.Errorf("ANNOTATIONS_FILE must not be empty")
+	}
+	if strings.TrimSpace(c.PrefsFile) == "" {
+		return fmt.Errorf("PREFS_FILE must not be empty")
+	}
+	if strings.TrimSpace(c.GoalsFile) == "" {
+		return fmt.Errorf("GOALS_FILE must not be empty")
+	}
+	if strings.TrimSpace(c.ClosedFile) == "" {
+		return fmt.Errorf("CLOSED_FILE must not be empty")
+	}
+	if strings.TrimSpace(c.PushFile) == "" {
+		return fmt.Errorf("PUSH_FILE must not be empty")
+	}
+	if strings.TrimSpace(c.HistoryFile) == "" {
+		return fmt.Errorf("HISTORY_FILE must not be empty")
+	}
+	if strings.TrimSpace(c.RollupDir) == "" {
+		return fmt.Errorf("ROLLUP_DIR must not be empty")
+	}
+	if c.AppName == "" || c.AppShortName == "" {
+		return fmt.Errorf("APP_NAME and APP_SHORT_NAME must not be empty")
+	}
+	switch c.WeatherProvider {
+	case "", "open-meteo":
+	default:
+		return fmt.Errorf("WEATHER_PROVIDER: want open-meteo, or unset for none")
+	}
+	if c.WeatherProvider != "" && (c.WeatherURL == "" || c.WeatherArchiveURL == "" || strings.TrimSpace(c.WeatherFile) == "") {
+		return fmt.Errorf("WEATHER_URL, WEATHER_ARCHIVE_URL and WEATHER_FILE must not be empty")
+	}
+	if c.ShareSecret != "" && len(c.ShareSecret) < 16 {
+		return fmt.Errorf("SHARE_SECRET must be at least 16 characters")
+	}
+	if c.VAPIDKey != nil && !strings.HasPrefix(c.VAPIDSubject, "mailto:") && !strings.HasPrefix(c.VAPIDSubject, "https://") {
+		return fmt.Errorf("VAPID_SUBJECT must be a mailto: or https:// address push services can reach you at")
+	}
+	if strings.TrimSpace(c.RecordsFile) == "" {
+		return fmt.Errorf("RECORDS_FILE must not be empty")
+	}
+	if c.WebDir == "" {
+		return fmt.Errorf("WEB_DIR must not be empty")
+	}
+	if strings.TrimSpace(c.ShadowLog) == "" {
+		return fmt.Errorf("SHADOW_LOG must not be empty")
+	}
+	if strings.TrimSpace(c.ReplicaFile) == "" {
+		return fmt.Errorf("REPLICA_FILE must not be empty")
+	}
+	if c.SheetsCredentials != "" && c.SheetsRange == "" {
+		return fmt.Errorf("SHEETS_RANGE must not be empty")
+	}
+	if c.TraceEndpoint != "" {
+		if u, err := url.Parse(c.TraceEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("OTEL_EXPORTER_OTLP_ENDPOINT: %q is not an http(s) URL", c.TraceEndpoint)
+		}
+	}
+	if c.OIDCIssuer != "" {
+		for key, u := range map[string]string{"OIDC_ISSUER": c.OIDCIssuer, "OIDC_REDIRECT_URL": c.OIDCRedirectURL} {
+			if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+				return fmt.Errorf("%s %q is not an http(s) URL", key, u)
+			}
+		}
+		if c.OIDCClientID == "" || len(c.OIDCAdmins) == 0 {
+			return fmt.Errorf("OIDC_ISSUER needs OIDC_CLIENT_ID and OIDC_ADMINS")
+		}
+	}
+	if c.MQTTBroker != "" {
+		addr := strings.TrimPrefix(strings.TrimPrefix(c.MQTTBroker, "tcp://"), "mqtt://")
+		if host, _, err := net.SplitHostPort(addr); err == nil && host == "" {
+			return fmt.Errorf("MQTT_BROKER %q: missing host", c.MQTTBroker)
+		}
+	}
+	if c.SLOAlertURL != "" && !strings.HasPrefix(c.SLOAlertURL, "http://") && !strings.HasPrefix(c.SLOAlertURL, "https://") {
+		return fmt.Errorf("SLO_ALERT_URL %q is not an http(s) URL", c.SLOAlertURL)
+	}
+	if len(c.SLOAlertChats) > 0 && c.TelegramToken == "" {
+		return fmt.Errorf("SLO_ALERT_CHATS needs TELEGRAM_BOT_TOKEN")
+	}
+	if c.LiveAPIURL != "" && !strings.HasPrefix(c.LiveAPIURL, "http://") && !strings.HasPrefix(c.LiveAPIURL, "https://") {
+		return fmt.Errorf("LIVE_API_URL %q is not an http(s) URL", c.LiveAPIURL)
+	}
+	if c.LiveCacheTTL < 10*time.Second {
+		return fmt.Errorf("LIVE_CACHE_SECONDS must be at least 10, to keep within the upstream's rate limits")
+	}
+	if c.OutlierFactor < 1 {
+		return fmt.Errorf("OUTLIER_FACTOR must be at least 1, or real crowds count as outliers")
+	}
+	if c.ReadHeaderTimeout > c.ReadTimeout {
+		return fmt.Errorf("HTTP_READ_HEADER_SECONDS must not exceed HTTP_READ_SECONDS")
+	}
+	if err := checkDerivedMetrics(c.DerivedMetrics); err != nil {
+		return fmt.Errorf("DERIVED_METRICS: %v", err)
+	}
+	if err := gymdata.CheckTimeLayout(c.CSVTimeLayout); err != nil {
+		return fmt.Errorf("CSV_TIMESTAMP_FORMAT: %v", err)
+	}
+	for _, o := range c.CORSOrigins {
+		if o != "*" && !strings.HasPrefix(o, "http://") && !strings.HasPrefix(o, "https://") {
+			return fmt.Errorf("CORS_ORIGINS: %q is not * or an http(s) origin", o)
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma-separated value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// watchReloadSignal reloads the config whenever the process receives SIGHUP.
+func watchReloadSignal(signals <-chan os.Signal) {
+	for range signals {
+		if _, err := reloadConfig(); err != nil {
+			log.Printf("Config reload failed, keeping current config: %v", err)
+			appendAudit(currentConfig(), AuditEntry{Action: "config-reload", Actor: "SIGHUP", Error: err.Error()})
+			continue
+		}
+		log.Printf("Config reloaded (SIGHUP)")
+		appendAudit(currentConfig(), AuditEntry{Action: "config-reload", Actor: "SIGHUP"})
+	}
+}
+
+// reloadHandler re-reads the config on demand.
+//
+//	POST /api/admin/reload
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "POST, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Resolve the actor first: the reload may replace the key they used.
+	cfg := requestConfig(r)
+	_, actor, _ := requestRole(r, cfg)
+	entry := AuditEntry{Action: "config-reload", Actor: actor, IP: clientIP(r)}
+	if _, err := reloadConfig(); err != nil {
+		entry.Error = err.Error()
+		appendAudit(cfg, entry)
+		writeError(w, http.StatusUnprocessableEntity, withKind(ErrConfig, fmt.Errorf("config rejected, keeping current config: %v", err)))
+		return
+	}
+	appendAudit(cfg, entry)
+	log.Printf("Config reloaded (%s)", clientIP(r))
+	json.NewEncoder(w).Encode(GenerateResponse{Success: true, Message: "Config reloaded"})
+}
+
+// parseSeconds accepts a plain number of seconds or a Go duration ("2m").
+func parseSeconds(s string) (time.Duration, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 {
+			return 0, fmt.Errorf("must be positive, got %d", n)
+		}
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be positive, got %s", d)
+	}
+	return d, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gym-config.env")
+	content := "# tokens\nAPI_TOKEN=abc=def\n\nexport MQTT_BROKER=\"broker.lan:1883\"\nMQTT_USERNAME='ha'\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseEnvFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"API_TOKEN": "abc=def", "MQTT_BROKER": "broker.lan:1883", "MQTT_USERNAME": "ha"}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("NOT A PAIR\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseEnvFile(path); err == nil {
+		t.Error("expected error for a line without '='")
+	}
+}
+
+func TestParseSeconds(t *testing.T) {
+	for in, want := range map[string]time.Duration{"60": time.Minute, "2m": 2 * time.Minute, "90s": 90 * time.Second} {
+		got, err := parseSeconds(in)
+		if err != nil || got != want {
+			t.Errorf("parseSeconds(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"0", "-5", "soon"} {
+		if _, err := parseSeconds(in); err == nil {
+			t.Errorf("parseSeconds(%q): expected error", in)
+		}
+	}
+}
+
+func TestReloadConfigKeepsOldOnError(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+
+	path := filepath.Join(t.TempDir(), "gym-config.env")
+	t.Setenv("CONFIG_FILE", path)
+	if err := os.WriteFile(path, []byte("CORS_ORIGINS=https://gym.example\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	good, err := reloadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if currentConfig() != good || len(good.CORSOrigins) != 1 {
+		t.Fatalf("valid reload not applied: %+v", currentConfig())
+	}
+
+	for _, bad := range []string{"CORS_ORIGINS=gym.example\n", "MQTT_INTERVAL=never\n", "API_KEYS=alice:admin\n", "AREA_PATTERN=(.+) - (.+)\n", "LOCATION_ALIASES=A=B,B=C\n", "OTEL_EXPORTER_OTLP_PROTOCOL=grpc\n", "DERIVED_LOCATIONS=North=Kristiine +\n", "DERIVED_METRICS=pct=util * 100;util=count / capacity\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reloadConfig(); err == nil {
+			t.Errorf("%q: expected reload error", bad)
+		}
+		if currentConfig() != good {
+			t.Errorf("%q: config replaced despite error", bad)
+		}
+	}
+
+	// A rejected config's bucket is never made the one its paths resolve to.
+	bad := "CSV_SOURCE=s3://rejected/gym\nS3_ENDPOINT=http://127.0.0.1:1\nS3_ACCESS_KEY=key\nS3_SECRET_KEY=secret\nREPLICA_TARGET=s3://rejected/gym\n"
+	if err := os.WriteFile(path, []byte(bad), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloadConfig(); err == nil || !strings.Contains(err.Error(), "REPLICA_TARGET") {
+		t.Fatalf("bucket replicated to itself: err %v", err)
+	}
+	if _, err := gymdata.SourceFor("s3://rejected/gym/").Glob("s3://rejected/gym/", "*.csv"); err == nil || !strings.Contains(err.Error(), "no CSV_SOURCE") {
+		t.Errorf("rejected bucket registered: glob err %v", err)
+	}
+}
+
+func TestReloadHandlerRejected(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+	running := &Config{DataDir: t.TempDir(), AuditLog: "audit.jsonl"}
+	setConfig(running)
+
+	path := filepath.Join(t.TempDir(), "gym-config.env")
+	t.Setenv("CONFIG_FILE", path)
+	if err := os.WriteFile(path, []byte("MQTT_INTERVAL=never\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	reloadHandler(w, httptest.NewRequest("POST", "/api/admin/reload", nil))
+	var got APIError
+	json.NewDecoder(w.Body).Decode(&got)
+	if w.Code != http.StatusUnprocessableEntity || got.Success || got.Code != "invalid_config" || !strings.Contains(got.Error, "MQTT_INTERVAL") {
+		t.Errorf("rejected reload: %d %+v", w.Code, got)
+	}
+	if currentConfig() != running {
+		t.Error("config replaced despite error")
+	}
+}
+
+func TestLoadConfigFromEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("DATA_DIR", dir)
+	if err := os.WriteFile(filepath.Join(dir, "gym-config.env"), []byte("CORS_ORIGINS=https://gym.example\nAUDIT_LOG=audit.jsonl\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(t.TempDir(), "api_keys")
+	if err := os.WriteFile(secret, []byte("alice:long-random-token:admin\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("API_KEYS_FILE", secret)
+	t.Setenv("AUDIT_LOG", "env-audit.jsonl")
+
+	c, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.DataDir != dir || len(c.CORSOrigins) != 1 {
+		t.Errorf("DATA_DIR's gym-config.env not read: %+v", c)
+	}
+	if c.path(c.AuditLog) != filepath.Join(dir, "env-audit.jsonl") {
+		t.Errorf("audit log at %q, want the environment's name under DATA_DIR", c.path(c.AuditLog))
+	}
+	if len(c.APIKeys) != 1 {
+		t.Errorf("API_KEYS_FILE not read: %+v", c.APIKeys)
+	}
+
+	t.Setenv("API_KEYS_FILE", filepath.Join(dir, "missing"))
+	if _, err := loadConfig(); err == nil {
+		t.Error("missing API_KEYS_FILE: expected an error")
+	}
+	t.Setenv("API_KEYS_FILE", secret)
+	t.Setenv("DATA_DIR", filepath.Join(dir, "missing"))
+	if _, err := loadConfig(); err == nil {
+		t.Error("missing DATA_DIR: expected an error")
+	}
+}
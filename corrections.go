@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// CorrectionRequest deletes a gym's readings from From to To inclusive, or
+// sets their user_count to Value, in the stored CSVs.
+type CorrectionRequest struct {
+	Location string `json:"location"`
+	From     string `json:"from"`
+	To       string `json:"to,omitempty"` // defaults to From: one reading
+	Action   string `json:"action"`       // delete or set
+	Value    *int   `json:"value,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	DryRun   bool   `json:"dryRun,omitempty"`
+}
+
+type CorrectionResponse struct {
+	Location string       `json:"location"`
+	From     string       `json:"from"`
+	To       string       `json:"to"`
+	Action   string       `json:"action"`
+	Value    *int         `json:"value,omitempty"`
+	DryRun   bool         `json:"dryRun,omitempty"`
+	Rows     int          `json:"rows"`
+	Files    []MergedFile `json:"files"`
+}
+
+// correctRows returns data, a daily CSV, with location's rows timed from
+// from to to deleted, or with their user_count cells set to value if it is
+// not nil, and how many rows it changed. As with renameRows, other lines
+// and cells are left byte for byte.
+func correctRows(cfg *Config, data []byte, location string, from, to time.Time, value *int) ([]byte, int) {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	reader := csv.NewReader(bytes.NewReader(lines[0]))
+	reader.FieldsPerRecord = -1
+	headers, err := reader.Read()
+	if err != nil {
+		return data, 0
+	}
+	tsIdx, tzIdx, locIdx, cntIdx := -1, -1, -1, -1
+	for i, h := range gymdata.CanonicalHeaders(headers, cfg.CSVColumns) {
+		switch h {
+		case "timestamp":
+			tsIdx = i
+		case "timezone":
+			tzIdx = i
+		case "location_name":
+			locIdx = i
+		case "user_count":
+			cntIdx = i
+		}
+	}
+	if tsIdx == -1 || locIdx == -1 || cntIdx == -1 {
+		return data, 0
+	}
+	layout := cfg.CSVTimeLayout
+	if layout == "" {
+		layout = gymdata.DefaultTimeLayout
+	}
+	tallinn := gymdata.Tallinn()
+
+	var out bytes.Buffer
+	out.Write(lines[0])
+	changed := 0
+	for _, line := range lines[1:] {
+		if !bytes.Contains(line, []byte(location)) {
+			out.Write(line)
+			continue
+		}
+		reader := csv.NewReader(bytes.NewReader(line))
+		reader.LazyQuotes = true
+		reader.FieldsPerRecord = -1
+		record, err := reader.Read()
+		if err != nil || len(record) <= max(tsIdx, tzIdx, locIdx, cntIdx) || strings.TrimSpace(record[locIdx]) != location {
+			out.Write(line)
+			continue
+		}
+		tz := ""
+		if tzIdx != -1 {
+			tz = record[tzIdx]
+		}
+		at, ok := gymdata.LocalTime(record[tsIdx], tz, layout, tallinn)
+		if !ok || at.Before(from) || at.After(to) {
+			out.Write(line)
+			continue
+		}
+		if value == nil {
+			changed++
+			continue
+		}
+		replaced, ok := replaceCell(line, reader, record, cntIdx, strconv.Itoa(*value))
+		if !ok {
+			out.Write(line)
+			continue
+		}
+		out.Write(replaced)
+		changed++
+	}
+	return out.Bytes(), changed
+}
+
+// correctFile applies a correction to file, returning how many rows it
+// changed; like mergeLocation, it reads again if the collector appended.
+func correctFile(cfg *Config, file, location string, from, to time.Time, value *int, dryRun bool) (int, error) {
+	for range 3 {
+		info, err := os.Stat(file)
+		if err != nil {
+			return 0, err
+		}
+		f, err := gymdata.Open(file)
+		if err != nil {
+			return 0, err
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return 0, err
+		}
+		data, n := correctRows(cfg, data, location, from, to, value)
+		if n == 0 || dryRun {
+			return n, nil
+		}
+		if err := rewriteFile(file, data, info.Size()); err != errFileChanged {
+			return n, err
+		}
+	}
+	return 0, errFileChanged
+}
+
+// correctionsHandler deletes or corrects a gym's readings over a span, e.g.
+// an hour a stuck sensor reported 999, in the stored CSVs, plain and
+// gzipped. Charts built since are dropped so none serves the old values.
+// Ingestion waits while the files are rewritten.
+//
+//	POST /api/admin/corrections {location, from[, to], action: delete|set[, value][, reason][, dryRun]}
+func correctionsHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "POST, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := requestConfig(r)
+	if cfg.CSVSource != "" {
+		writeError(w, http.StatusConflict, fmt.Errorf("CSV files are read from %s; correct them there instead", cfg.CSVSource))
+		return
+	}
+	var req CorrectionRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body"))
+		return
+	}
+	req.Location = strings.TrimSpace(req.Location)
+	if req.Location == "" {
+		writeError(w, http.StatusBadRequest, fieldErr("location", nil, fmt.Errorf("location is required")))
+		return
+	}
+	from, err := parseAnnotationTime(req.From)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fieldErr("from", ErrBadRange, fmt.Errorf("from: %v", err)))
+		return
+	}
+	to := from
+	if strings.TrimSpace(req.To) != "" {
+		if to, err = parseAnnotationTime(req.To); err != nil {
+			writeError(w, http.StatusBadRequest, fieldErr("to", ErrBadRange, fmt.Errorf("to: %v", err)))
+			return
+		}
+	}
+	if to.Before(from) {
+		writeError(w, http.StatusBadRequest, fieldErr("to", ErrBadRange, fmt.Errorf("to is before from")))
+		return
+	}
+	switch {
+	case req.Action == "delete" && req.Value == nil:
+	case req.Action == "set" && req.Value != nil && *req.Value >= 0:
+	case req.Action == "set":
+		writeError(w, http.StatusBadRequest, fieldErr("value", nil, fmt.Errorf("value must be a count of 0 or more")))
+		return
+	default:
+		writeError(w, http.StatusBadRequest, fieldErr("action", nil, fmt.Errorf("action must be delete, or set with a value")))
+		return
+	}
+
+	files, err := windowFiles(cfg, from, to.Add(time.Nanosecond)) // to is included
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	ingestorsMu.Lock()
+	in := ingestors[cfg.DataDir]
+	ingestorsMu.Unlock()
+	if in != nil {
+		in.mu.Lock()
+	}
+	resp := CorrectionResponse{Location: req.Location, From: from.Format(time.RFC3339), To: to.Format(time.RFC3339),
+		Action: req.Action, Value: req.Value, DryRun: req.DryRun, Files: []MergedFile{}}
+	err = nil
+	for _, file := range files {
+		n, ferr := correctFile(cfg, file, req.Location, from, to, req.Value, req.DryRun)
+		if ferr != nil {
+			err = fmt.Errorf("%s: %v", gymdata.BaseName(file), ferr)
+			break
+		}
+		if n > 0 {
+			resp.Rows += n
+			resp.Files = append(resp.Files, MergedFile{File: filepath.Base(file), Rows: n})
+		}
+	}
+	if in != nil {
+		if req.Action == "delete" {
+			in.seen = map[string]map[string]bool{} // deleted times may be ingested again
+		}
+		in.mu.Unlock()
+	}
+
+	if !req.DryRun {
+		if resp.Rows > 0 {
+			dropBuilds(cfg)
+		}
+		params := map[string]any{"location": req.Location, "from": resp.From, "to": resp.To, "action": req.Action, "rows": resp.Rows, "files": len(resp.Files)}
+		if req.Value != nil {
+			params["value"] = *req.Value
+		}
+		if req.Reason != "" {
+			params["reason"] = req.Reason
+		}
+		recordAudit(r, "correct-readings", params, err)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
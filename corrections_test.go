@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gym/internal/gymdata"
+)
+
+func TestCorrections(t *testing.T) {
+	loadTallinn(t)
+	dir := t.TempDir()
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	file := writeCSV(t, dir, "gym-stats-20251002.csv", header+
+		"2025-10-02 10:00:00,EEST,1,Hipodroom,12,success,\"{\"total\":12}\"\n"+
+		"2025-10-02 10:02:00,EEST,1,Hipodroom,999,success,\"{}\"\n"+
+		"2025-10-02 10:04:00,EEST,1,Hipodroom,999,success,\"{}\"\n"+
+		"2025-10-02 10:04:00,EEST,2,T1,999,success,\"{}\"\n"+
+		"2025-10-02 10:06:00,EEST,1,Hipodroom,14,success,\"{}\"\n")
+
+	old := currentConfig()
+	defer setConfig(old)
+	cfg := &Config{DataDir: dir, AuditLog: "gym-audit.jsonl"}
+	setConfig(cfg)
+	correct := func(body string) (int, CorrectionResponse, APIError) {
+		w := httptest.NewRecorder()
+		correctionsHandler(w, httptest.NewRequest("POST", "/api/admin/corrections", strings.NewReader(body)))
+		var resp CorrectionResponse
+		var e APIError
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		json.Unmarshal(w.Body.Bytes(), &e)
+		return w.Code, resp, e
+	}
+	counts := func() []float64 {
+		list, _, err := gymdata.Load(cfg.format(), []string{file}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []float64
+		for _, p := range list[0].Points {
+			out = append(out, p.Y)
+		}
+		return out
+	}
+	if got := counts(); len(got) != 4 || got[1] != 999 {
+		t.Fatalf("before: %v", got)
+	}
+
+	for _, c := range []struct{ body, field string }{
+		{`{"from":"2025-10-02 10:02","action":"delete"}`, "location"},
+		{`{"location":"Hipodroom","from":"noon","action":"delete"}`, "from"},
+		{`{"location":"Hipodroom","from":"2025-10-02 10:04","to":"2025-10-02 10:02","action":"delete"}`, "to"},
+		{`{"location":"Hipodroom","from":"2025-10-02 10:02","action":"set"}`, "value"},
+		{`{"location":"Hipodroom","from":"2025-10-02 10:02","action":"zero"}`, "action"},
+	} {
+		if code, _, e := correct(c.body); code != 400 || e.Field != c.field {
+			t.Errorf("%s: %d %+v, want 400 on %s", c.body, code, e, c.field)
+		}
+	}
+
+	before, _ := os.ReadFile(file)
+	if code, resp, _ := correct(`{"location":"Hipodroom","from":"2025-10-02 10:02","to":"2025-10-02 10:04","action":"set","value":1300,"dryRun":true}`); code != 200 || resp.Rows != 2 {
+		t.Fatalf("dry run = %d %+v", code, resp)
+	}
+	if after, _ := os.ReadFile(file); string(after) != string(before) {
+		t.Fatal("dry run changed the file")
+	}
+
+	// Longer values grow the file: the cached parse must not be extended.
+	if code, resp, _ := correct(`{"location":"Hipodroom","from":"2025-10-02 10:02","to":"2025-10-02 10:04","action":"set","value":1300,"reason":"test"}`); code != 200 || resp.Rows != 2 || len(resp.Files) != 1 {
+		t.Fatalf("set = %d %+v", code, resp)
+	}
+	if got := counts(); len(got) != 4 || got[1] != 1300 || got[2] != 1300 || got[3] != 14 {
+		t.Errorf("after set: %v", got)
+	}
+	if code, resp, _ := correct(`{"location":"Hipodroom","from":"2025-10-02T07:02:00Z","to":"2025-10-02 10:05","action":"delete"}`); code != 200 || resp.Rows != 2 {
+		t.Fatalf("delete = %d %+v", code, resp)
+	}
+	data, _ := os.ReadFile(file)
+	want := header +
+		"2025-10-02 10:00:00,EEST,1,Hipodroom,12,success,\"{\"total\":12}\"\n" +
+		"2025-10-02 10:04:00,EEST,2,T1,999,success,\"{}\"\n" +
+		"2025-10-02 10:06:00,EEST,1,Hipodroom,14,success,\"{}\"\n"
+	if string(data) != want {
+		t.Errorf("file =\n%s\nwant\n%s", data, want)
+	}
+
+	entries, _ := readAudit(cfg, "correct-readings", 10)
+	if len(entries) != 2 || entries[1].Params["reason"] != "test" || entries[1].Params["value"] != float64(1300) || entries[0].Params["rows"] != float64(2) {
+		t.Errorf("audit = %+v", entries)
+	}
+}
+
+func TestCorrectionsFailWhenListingFails(t *testing.T) {
+	loadTallinn(t)
+	old := currentConfig()
+	defer setConfig(old)
+	// Corrections only edit local files, and a local listing fails only when
+	// the directory's name doesn't glob.
+	dir := filepath.Join(t.TempDir(), "data[")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	setConfig(&Config{DataDir: dir, AuditLog: "gym-audit.jsonl"})
+
+	w := httptest.NewRecorder()
+	body := `{"location":"Hipodroom","from":"2025-10-02 10:02","action":"delete"}`
+	correctionsHandler(w, httptest.NewRequest("POST", "/api/admin/corrections", strings.NewReader(body)))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500 when the CSV source can't be listed", w.Code)
+	}
+}
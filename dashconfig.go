@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"gym/internal/gymdata"
+)
+
+// defaultGymColors are GYM_COLORS unless set: the chain's gyms as the
+// dashboard has always drawn them.
+const defaultGymColors = "Hipodroom=#36A2EB,Mustika=#FF6384,T1=#FF9F40,Suur-Paala=#4BC0C0"
+
+// fallbackColors go, in turn, to gyms GYM_COLORS doesn't name.
+var fallbackColors = []string{"#9966FF", "#FFCD56", "#C9CBCF", "#8DD17E"}
+
+// parseGymColors reads GYM_COLORS: comma-separated gym=#RRGGBB pairs (or #RGB).
+func parseGymColors(s string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, pair := range splitList(s) {
+		name, color, ok := strings.Cut(pair, "=")
+		name, color = strings.TrimSpace(name), strings.TrimSpace(color)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%q: want gym=#RRGGBB", pair)
+		}
+		if !hexColor.MatchString(color) {
+			return nil, fmt.Errorf("%q: colors are #RRGGBB or #RGB", pair)
+		}
+		out[name] = color
+	}
+	return out, nil
+}
+
+// DashboardLocation is a gym as the dashboard shows it.
+type DashboardLocation struct {
+	Name     string  `json:"name"`
+	Color    string  `json:"color"`
+	Capacity float64 `json:"capacity,omitempty"`
+	Closed   bool    `json:"closed,omitempty"`
+}
+
+// DashboardFeatures says which optional parts of the dashboard the server
+// is configured for, so it can hide the rest.
+type DashboardFeatures struct {
+	Push    bool `json:"push"`    // VAPID_PRIVATE_KEY
+	Live    bool `json:"live"`    // API_TOKEN, for /api/live
+	Login   bool `json:"login"`   // OIDC_ISSUER
+	Ingest  bool `json:"ingest"`  // CSVs on disk, which corrections and ingestion need
+	Weather bool `json:"weather"` // WEATHER_PROVIDER
+	Share   bool `json:"share"`   // SHARE_SECRET, for share links
+}
+
+// DashboardConfig is the server config the pages draw with, from
+// GET /api/config.
+type DashboardConfig struct {
+	Locations      []DashboardLocation `json:"locations"`
+	RefreshSeconds int                 `json:"refreshSeconds"`
+	OutlierFilter  string              `json:"outlierFilter"`
+	Features       DashboardFeatures   `json:"features"`
+}
+
+// buildDashboardConfig lists the gyms in the newest file, then the closed
+// ones, with their colors and capacities.
+func buildDashboardConfig(cfg *Config) (DashboardConfig, error) {
+	out := DashboardConfig{
+		Locations:      []DashboardLocation{},
+		RefreshSeconds: int(cfg.DashboardRefresh.Seconds()),
+		OutlierFilter:  cfg.OutlierFilter.String(),
+		Features: DashboardFeatures{
+			Push:    cfg.VAPIDKey != nil,
+			Live:    cfg.LiveAPIToken != "",
+			Login:   cfg.OIDCIssuer != "",
+			Ingest:  cfg.CSVSource == "",
+			Weather: cfg.WeatherProvider != "",
+			Share:   cfg.ShareSecret != "",
+		},
+	}
+	closed, err := closedList(cfg)
+	if err != nil {
+		return out, err
+	}
+	seen := map[string]bool{}
+	add := func(name string, isClosed bool) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		color, ok := cfg.GymColors[name]
+		if !ok {
+			color = fallbackColors[(len(out.Locations))%len(fallbackColors)]
+		}
+		out.Locations = append(out.Locations, DashboardLocation{Name: name, Color: color, Capacity: cfg.Capacities[strings.ToLower(name)], Closed: isClosed})
+	}
+	for _, l := range readLatestStatus(cfg, gymdata.Tallinn()).Locations {
+		add(l.Name, false)
+	}
+	for _, c := range closed {
+		add(c.Name, true)
+	}
+	return out, nil
+}
+
+// dashboardConfigHandler serves the dashboard's settings with their hash as
+// ETag. After a reload, /api/stream sends a "config" event so open pages
+// fetch them again.
+//
+//	GET /api/config
+func dashboardConfigHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	dc, err := buildDashboardConfig(requestConfig(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	data, err := json.Marshal(dc)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(append(data, '\n'))
+}
+
+// configHub tells the open /api/stream connections that the config was
+// reloaded.
+var configHub struct {
+	sync.Mutex
+	subs map[chan struct{}]bool
+}
+
+// subscribeConfig returns a channel that gets a value after each reload,
+// and the func that ends the subscription.
+func subscribeConfig() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	configHub.Lock()
+	defer configHub.Unlock()
+	if configHub.subs == nil {
+		configHub.subs = map[chan struct{}]bool{}
+	}
+	configHub.subs[ch] = true
+	return ch, func() {
+		configHub.Lock()
+		defer configHub.Unlock()
+		delete(configHub.subs, ch)
+	}
+}
+
+func publishConfig() {
+	configHub.Lock()
+	defer configHub.Unlock()
+	for ch := range configHub.subs {
+		select {
+		case ch <- struct{}{}:
+		default: // one pending is as good as several
+		}
+	}
+}
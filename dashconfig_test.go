@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDashboardConfig(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+	dir := t.TempDir()
+	writeCSV(t, dir, "gym-stats-20251001.csv", "timestamp,timezone,location_id,location_name,user_count,status,response\n"+
+		"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,{}\n"+
+		"2025-10-01 10:00:00,EEST,2,Kristiine,30,success,{}\n")
+	colors, err := parseGymColors(defaultGymColors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setConfig(&Config{
+		DataDir:          dir,
+		ClosedFile:       filepath.Join(dir, "closed.json"),
+		ClosedLocations:  []string{"Tartu"},
+		GymColors:        colors,
+		Capacities:       map[string]float64{"hipodroom": 120},
+		DashboardRefresh: 30 * time.Second,
+		LiveAPIToken:     "token",
+	})
+
+	w := httptest.NewRecorder()
+	dashboardConfigHandler(w, httptest.NewRequest("GET", "/api/config", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var got DashboardConfig
+	json.Unmarshal(w.Body.Bytes(), &got)
+	want := []DashboardLocation{
+		{Name: "Hipodroom", Color: "#36A2EB", Capacity: 120},
+		{Name: "Kristiine", Color: fallbackColors[1]},
+		{Name: "Tartu", Color: fallbackColors[2], Closed: true},
+	}
+	if len(got.Locations) != len(want) {
+		t.Fatalf("locations = %+v, want %+v", got.Locations, want)
+	}
+	for i := range want {
+		if got.Locations[i] != want[i] {
+			t.Errorf("locations[%d] = %+v, want %+v", i, got.Locations[i], want[i])
+		}
+	}
+	if got.RefreshSeconds != 30 || !got.Features.Live || got.Features.Push || !got.Features.Ingest || got.OutlierFilter != "off" {
+		t.Errorf("got %+v", got)
+	}
+
+	r := httptest.NewRequest("GET", "/api/config", nil)
+	r.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	dashboardConfigHandler(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("unchanged config: status %d, want 304", w.Code)
+	}
+}
+
+func TestParseGymColors(t *testing.T) {
+	if got, err := parseGymColors(" T1 = #f94 ,Mustika=#FF6384"); err != nil || got["T1"] != "#f94" || got["Mustika"] != "#FF6384" {
+		t.Errorf("got %v, %v", got, err)
+	}
+	for _, bad := range []string{"T1", "T1=red", "=#FFFFFF", "T1=#FFFFF"} {
+		if _, err := parseGymColors(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestReloadPublishesConfig(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+	reloads, cancel := subscribeConfig()
+	defer cancel()
+	setConfig(&Config{})
+	setConfig(&Config{}) // coalesced with the first
+	select {
+	case <-reloads:
+	case <-time.After(time.Second):
+		t.Fatal("no config event after setConfig")
+	}
+}
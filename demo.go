@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"gym/internal/fixtures"
+	"gym/internal/gymdata"
+)
+
+// demoDays is how much history `gym-server demo` seeds by default: enough for
+// the month views, the busyness grid and the bands to have something to show.
+const demoDays = 30
+
+// seedDemo handles `gym-server demo [days]`: it fills cfg's data directory
+// with days of synthetic readings for the collector's gyms, ending now, so
+// the dashboard can be tried without a collector. A directory that already
+// holds daily CSVs is left alone.
+func seedDemo(cfg *Config, args []string, now time.Time) ([]string, error) {
+	days := demoDays
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 || n > 366 {
+			return nil, fmt.Errorf("days: want 1-366, got %q", args[0])
+		}
+		days = n
+	}
+	if cfg.CSVSource != "" {
+		return nil, fmt.Errorf("CSV_SOURCE is set; demo data is only written to a local directory")
+	}
+	existing, err := gymdata.ListFiles(cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		return nil, fmt.Errorf("%d daily CSVs already in the data directory; run the demo from an empty directory", len(existing))
+	}
+	tallinn := gymdata.Tallinn()
+	spec := fixtures.Spec{
+		Start: now.In(tallinn).AddDate(0, 0, 1-days),
+		Days:  days,
+		End:   now,
+		Zone:  tallinn,
+		Noise: 3,
+		Seed:  uint64(now.Unix()),
+	}
+	dir := cfg.DataDir
+	if dir == "" {
+		dir = "."
+	}
+	return spec.Write(dir)
+}
+
+// demoLocations are the made-up gyms --demo simulates, named so that nobody
+// takes the numbers for a real chain's.
+var demoLocations = []fixtures.Location{
+	{ID: 101, Name: "Demo Central", Peak: 80},
+	{ID: 102, Name: "Demo Harbour", Peak: 50},
+	{ID: 103, Name: "Demo Hillside", Peak: 30},
+}
+
+// demoInterval is how often --demo adds a reading, the collector's own pace.
+const demoInterval = 2 * time.Minute
+
+// startDemo sets up --demo: every setting at its default, so gym-config.env
+// can't point the demo at real data or real brokers, and a fresh temporary
+// data directory holding demoDays of history up to now. The spec is what
+// runDemo carries on from.
+func startDemo(now time.Time) (*Config, fixtures.Spec, error) {
+	cfg, err := buildConfig(func(_, def string) string { return def })
+	if err != nil {
+		return nil, fixtures.Spec{}, err
+	}
+	if cfg.DataDir, err = os.MkdirTemp("", "gym-demo-"); err != nil {
+		return nil, fixtures.Spec{}, err
+	}
+	tallinn := gymdata.Tallinn()
+	spec := fixtures.Spec{
+		Locations: demoLocations,
+		Start:     now.In(tallinn).AddDate(0, 0, 1-demoDays),
+		Days:      demoDays,
+		End:       now,
+		Interval:  demoInterval,
+		Zone:      tallinn,
+		Noise:     3,
+		Seed:      uint64(now.Unix()),
+	}
+	if _, err := spec.Write(cfg.DataDir); err != nil {
+		return nil, fixtures.Spec{}, err
+	}
+	return cfg, spec, nil
+}
+
+// runDemo appends a reading per demo gym to the day's file on every tick, as
+// the collector would, so the freshness badge, the live strip and the day
+// rollover all behave as they do on real data.
+func runDemo(cfg *Config, spec fixtures.Spec, tick <-chan time.Time) {
+	last := spec.End
+	for now := range tick {
+		at := now.Truncate(demoInterval)
+		if !at.After(last) {
+			continue
+		}
+		if err := appendDemoReadings(cfg, spec, at); err != nil {
+			log.Printf("Demo: %v", err)
+			continue
+		}
+		last = at
+	}
+}
+
+// appendDemoReadings writes the demo gyms' readings at at to that day's file,
+// starting it with the collector's header if it is new.
+func appendDemoReadings(cfg *Config, spec fixtures.Spec, at time.Time) error {
+	name := cfg.path("gym-stats-" + at.In(spec.Zone).Format("20060102") + ".csv")
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		w.Write(fixtures.Header)
+	}
+	for _, l := range spec.Locations {
+		w.Write(spec.Row(l, at))
+	}
+	w.Flush()
+	err = w.Error()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+func TestSeedDemo(t *testing.T) {
+	tallinn := loadTallinn(t)
+	dir := t.TempDir()
+	cfg := &Config{DataDir: dir}
+	now := time.Date(2025, 10, 15, 18, 0, 0, 0, tallinn)
+
+	files, err := seedDemo(cfg, []string{"7"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 7 || gymdata.BaseName(files[0]) != "gym-stats-20251009.csv" || gymdata.BaseName(files[6]) != "gym-stats-20251015.csv" {
+		t.Fatalf("files = %v, want 2025-10-09..15", files)
+	}
+	list, _, err := gymdata.Load(cfg.format(), files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 4 {
+		t.Fatalf("series = %d, want the 4 demo gyms", len(list))
+	}
+	if last := time.Unix(list[0].Points[len(list[0].Points)-1].At, 0); last.After(now) {
+		t.Errorf("last reading %v is after now", last)
+	}
+
+	if _, err := seedDemo(cfg, nil, now); err == nil {
+		t.Error("seeded a directory that already has data")
+	}
+	for _, arg := range []string{"0", "x", "400"} {
+		if _, err := seedDemo(&Config{DataDir: t.TempDir()}, []string{arg}, now); err == nil {
+			t.Errorf("days %q accepted", arg)
+		}
+	}
+}
+
+func TestRunDemo(t *testing.T) {
+	tallinn := loadTallinn(t)
+	now := time.Date(2025, 10, 15, 23, 57, 0, 0, tallinn)
+	cfg, spec, err := startDemo(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(cfg.DataDir) })
+	if cfg.DataDir == "" || len(cfg.APIKeys) != 0 {
+		t.Fatalf("config = %+v, want defaults in a temp dir", cfg)
+	}
+
+	tick := make(chan time.Time)
+	done := make(chan struct{})
+	go func() {
+		runDemo(cfg, spec, tick)
+		close(done)
+	}()
+	tick <- now.Add(30 * time.Second) // same slot as the seeded history
+	tick <- now.Add(time.Minute)      // 23:58
+	tick <- now.Add(3 * time.Minute)  // 00:00, the next day's file
+	close(tick)
+	<-done
+
+	files, err := gymdata.ListFiles(cfg.DataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != demoDays+1 {
+		t.Fatalf("files = %d, want %d days of history and the new day", len(files), demoDays+1)
+	}
+	list, _, err := gymdata.Load(cfg.format(), files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != len(demoLocations) {
+		t.Fatalf("series = %d, want %d", len(list), len(demoLocations))
+	}
+	for _, s := range list {
+		n := len(s.Points)
+		if last, prev := s.Points[n-1].At, s.Points[n-2].At; last != now.Add(3*time.Minute).Unix() || prev != now.Add(time.Minute).Unix() {
+			t.Errorf("%s: last readings at %v, %v; want 23:58 and 00:00", s.Key.Location, time.Unix(prev, 0).In(tallinn), time.Unix(last, 0).In(tallinn))
+		}
+	}
+
+	demoMode = true
+	defer func() { demoMode = false }()
+	if _, err := reloadConfig(); err == nil {
+		t.Error("reload allowed in demo mode")
+	}
+}
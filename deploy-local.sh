@@ -14,14 +14,15 @@ LOGS="$HOME/Library/Logs"
 mkdir -p "$RT" "$AGENTS" "$LOGS"
 
 echo "Building gym-server..."
-go build -o gym-server server.go
+go build -o gym-server .
 
 echo "Copying code + config to runtime ($RT)..."
-cp gym-server gym-stats-collector.sh gym-config.env dashboard.html busyness.html manifest.json icon.svg icon-192.png icon-512.png backup.sh "$RT"/
+cp gym-server gym-stats-collector.sh gym-config.env backup.sh "$RT"/
+cp -R web "$RT"/
 chmod +x "$RT/gym-stats-collector.sh" "$RT/backup.sh"
 
 # Seed existing CSVs on first install; never clobber live data on later runs.
-for f in gym-stats-*.csv; do [ -e "$RT/$f" ] || cp "$f" "$RT/" 2>/dev/null || true; done
+for f in gym-stats-*.csv gym-stats-*.csv.gz; do [ -e "$RT/$f" ] || cp "$f" "$RT/" 2>/dev/null || true; done
 
 echo "Writing LaunchAgents..."
 cat > "$AGENTS/com.ronimis.gym-stats-collector.plist" <<EOF
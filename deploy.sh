@@ -11,18 +11,20 @@ echo "Deploying to ${SERVER_USER}@${SERVER_IP}..."
 
 # Upload application files
 echo "Uploading application files..."
-scp server.go gym-stats-collector.sh dashboard.html busyness.html ${SERVER_USER}@${SERVER_IP}:/home/${SERVER_USER}/ronimis/
+scp -r *.go go.mod go.sum internal gym-stats-collector.sh web ${SERVER_USER}@${SERVER_IP}:/home/${SERVER_USER}/ronimis/
 
 # Upload service files
 echo "Uploading service files..."
-scp services/*.service ${SERVER_USER}@${SERVER_IP}:/tmp/
+scp services/*.service services/*.socket ${SERVER_USER}@${SERVER_IP}:/tmp/
 
 # Install services and restart
 echo "Installing services and restarting..."
 ssh -t ${SERVER_USER}@${SERVER_IP} "
-    sudo mv /tmp/*.service /etc/systemd/system/ && \
+    sudo mv /tmp/*.service /tmp/gym.socket /etc/systemd/system/ && \
     sudo systemctl daemon-reload && \
-    sudo systemctl restart gym.service gym-stats-collector.service && \
+    sudo systemctl enable gym.socket && \
+    sudo systemctl reload-or-restart gym.service && \
+    sudo systemctl restart gym-stats-collector.service && \
     echo 'Deployment complete!' && \
     sudo systemctl status gym.service --no-pager -l
 "
\ No newline at end of file
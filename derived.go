@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gym/internal/gymdata"
+)
+
+// derivedSeries is a series the config defines as an expression over
+// others: a gym made of gyms (DERIVED_LOCATIONS) or a metric made of a
+// gym's metrics (DERIVED_METRICS).
+type derivedSeries struct {
+	Name string
+	Expr *gymdata.Expr
+}
+
+// derivedCapacity and derivedCount are the names a derived metric may use
+// besides metric columns: the gym's CAPACITIES value and its headcount.
+const (
+	derivedCapacity = "capacity"
+	derivedCount    = "count"
+)
+
+// parseDerived reads DERIVED_LOCATIONS or DERIVED_METRICS: name=expression
+// pairs separated by semicolons, e.g. "Centre=Kristiine + Rocca al Mare;
+// East=\"Suur-Paala\" + Lasnamäe".
+func parseDerived(s string) ([]derivedSeries, error) {
+	var out []derivedSeries
+	for _, def := range strings.Split(s, ";") {
+		if strings.TrimSpace(def) == "" {
+			continue
+		}
+		name, src, ok := strings.Cut(def, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%q: want name=expression", def)
+		}
+		e, err := gymdata.ParseExpr(src)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		for _, d := range out {
+			if strings.EqualFold(d.Name, name) {
+				return nil, fmt.Errorf("%s is defined twice", name)
+			}
+		}
+		if slices.Contains(e.Names(), strings.ToLower(name)) {
+			return nil, fmt.Errorf("%s refers to itself", name)
+		}
+		out = append(out, derivedSeries{Name: name, Expr: e})
+	}
+	return out, nil
+}
+
+// checkDerivedMetrics rejects derived metrics that shadow the headcount,
+// use another derived metric, or use no metric at all.
+func checkDerivedMetrics(metrics []derivedSeries) error {
+	for _, d := range metrics {
+		if strings.EqualFold(d.Name, gymdata.DefaultMetric) || strings.EqualFold(d.Name, derivedCount) {
+			return fmt.Errorf("%s is a built-in metric", d.Name)
+		}
+		inputs := 0
+		for _, name := range d.Expr.Names() {
+			if name == derivedCapacity {
+				continue
+			}
+			if slices.ContainsFunc(metrics, func(o derivedSeries) bool { return strings.EqualFold(o.Name, name) }) {
+				return fmt.Errorf("%s uses %s, itself derived", d.Name, name)
+			}
+			inputs++
+		}
+		if inputs == 0 {
+			return fmt.Errorf("%s uses no metric", d.Name)
+		}
+	}
+	return nil
+}
+
+// derivedMetric is cfg's derived metric named name, or nil.
+func (c *Config) derivedMetric(name string) *derivedSeries {
+	for i, d := range c.DerivedMetrics {
+		if strings.EqualFold(d.Name, name) {
+			return &c.DerivedMetrics[i]
+		}
+	}
+	return nil
+}
+
+// loadMetrics is the metric columns to read for a request's metrics: a
+// derived metric is replaced by the columns it is computed from.
+func loadMetrics(cfg *Config, metrics []string) []string {
+	var out []string
+	add := func(m string) {
+		if !slices.Contains(out, m) {
+			out = append(out, m)
+		}
+	}
+	for _, m := range metrics {
+		d := cfg.derivedMetric(m)
+		if d == nil {
+			add(m)
+			continue
+		}
+		for _, name := range d.Expr.Names() {
+			switch name {
+			case derivedCapacity:
+			case derivedCount:
+				add(gymdata.DefaultMetric)
+			default:
+				add(name)
+			}
+		}
+	}
+	return gymdata.NormalizeMetrics(out)
+}
+
+// withDerived returns list as the request's metrics, in their order for
+// each gym, with the derived metrics computed and the columns read only to
+// compute them left out, followed by each derived location for each
+// metric. Like a Total, a derived series bridges a gap of its inputs up to
+// bridgeGap and leaves out instants it can't. list itself, which may be
+// cached, is not changed.
+func withDerived(list []*gymdata.Series, cfg *Config, metrics []string, bucketMinutes int) []*gymdata.Series {
+	if len(cfg.DerivedMetrics) == 0 && len(cfg.DerivedLocations) == 0 {
+		return list
+	}
+	maxGap := bridgeGap(bucketMinutes)
+	metrics = gymdata.NormalizeMetrics(metrics)
+	var locations []string
+	byLocation := map[string]map[string]*gymdata.Series{}
+	for _, s := range list {
+		m := byLocation[s.Key.Location]
+		if m == nil {
+			m = map[string]*gymdata.Series{}
+			byLocation[s.Key.Location] = m
+			locations = append(locations, s.Key.Location)
+		}
+		m[strings.ToLower(s.Key.Metric)] = s
+	}
+
+	out := make([]*gymdata.Series, 0, len(list))
+	for _, loc := range locations {
+		have := byLocation[loc]
+		for _, metric := range metrics {
+			d := cfg.derivedMetric(metric)
+			if d == nil {
+				if s := have[strings.ToLower(metric)]; s != nil {
+					out = append(out, s)
+				}
+				continue
+			}
+			inputs := map[string]*gymdata.Series{derivedCount: have[gymdata.DefaultMetric]}
+			for name, s := range have {
+				inputs[name] = s
+			}
+			consts := map[string]float64{}
+			if c, ok := cfg.Capacities[strings.ToLower(loc)]; ok {
+				consts[derivedCapacity] = c
+			}
+			if s := gymdata.Derive(d.Expr, gymdata.Key{Location: loc, Metric: d.Name}, inputs, consts, maxGap); s != nil && len(s.Points) > 0 {
+				out = append(out, s)
+			}
+		}
+	}
+
+	gyms := len(out)
+	for _, d := range cfg.DerivedLocations {
+		for _, metric := range metrics {
+			if dm := cfg.derivedMetric(metric); dm != nil {
+				metric = dm.Name
+			}
+			inputs := map[string]*gymdata.Series{}
+			for _, s := range out[:gyms] {
+				if s.Key.Metric == metric {
+					inputs[strings.ToLower(s.Key.Location)] = s
+				}
+			}
+			if s := gymdata.Derive(d.Expr, gymdata.Key{Location: d.Name, Metric: metric}, inputs, nil, maxGap); s != nil && len(s.Points) > 0 {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
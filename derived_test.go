@@ -0,0 +1,69 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"gym/internal/gymdata"
+)
+
+func TestWithDerived(t *testing.T) {
+	locations, err := parseDerived("Both = A + b; Half=A/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := parseDerived("pct=count / capacity * 100")
+	if err != nil || checkDerivedMetrics(metrics) != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{DerivedLocations: locations, DerivedMetrics: metrics, Capacities: map[string]float64{"a": 50, "b": 20}}
+	if got := loadMetrics(cfg, []string{"pct", "queue_length"}); !slices.Equal(got, []string{gymdata.DefaultMetric, "queue_length"}) {
+		t.Errorf("loadMetrics = %q", got)
+	}
+
+	series := func(loc, metric string, ys ...float64) *gymdata.Series {
+		s := &gymdata.Series{Key: gymdata.Key{Location: loc, Metric: metric}}
+		for i, y := range ys {
+			s.Points = append(s.Points, gymdata.Point{At: int64(i) * 120, Y: y})
+		}
+		return s
+	}
+	list := []*gymdata.Series{series("A", gymdata.DefaultMetric, 10, 20), series("B", gymdata.DefaultMetric, 4, 6)}
+	got := withDerived(list, cfg, []string{"pct"}, 2)
+	var keys []string
+	for _, s := range got {
+		keys = append(keys, s.Key.Location+"/"+s.Key.Metric)
+	}
+	if want := []string{"A/pct", "B/pct", "Both/pct", "Half/pct"}; !slices.Equal(keys, want) {
+		t.Fatalf("series %q, want %q", keys, want)
+	}
+	if p := got[1].Points[1]; p.Y != 30 {
+		t.Errorf("B's second pct = %v, want 30", p.Y)
+	}
+	if p := got[2].Points[0]; p.Y != 40 {
+		t.Errorf("Both's first pct = %v, want 20%% + 20%%", p.Y)
+	}
+	if len(list) != 2 || list[0].Key.Metric != gymdata.DefaultMetric {
+		t.Errorf("input list changed: %+v", list)
+	}
+
+	got = withDerived(list, cfg, nil, 2)
+	if len(got) != 4 || got[2].Key != (gymdata.Key{Location: "Both", Metric: gymdata.DefaultMetric}) || got[2].Points[1].Y != 26 {
+		t.Errorf("headcount = %+v, want A, B and their derived locations", got)
+	}
+	if got := withDerived(list, &Config{}, nil, 2); len(got) != 2 {
+		t.Errorf("nothing configured = %d series, want list as it is", len(got))
+	}
+
+	for _, bad := range []string{"Both", "=A", "x=(A", "x=A;X=B", "x=x+1"} {
+		if _, err := parseDerived(bad); err == nil {
+			t.Errorf("%q parsed, want an error", bad)
+		}
+	}
+	for _, bad := range []string{"user_count=count*2", "cap=capacity*2"} {
+		d, err := parseDerived(bad)
+		if err != nil || checkDerivedMetrics(d) == nil {
+			t.Errorf("%q passed, want an error", bad)
+		}
+	}
+}
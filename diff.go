@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"gym/internal/gymdata"
+)
+
+// diffSide is one side of a comparison: either a live date range or a snapshot
+// of datasets saved from an earlier generation (e.g. a copy of gym-data.json).
+type diffSide struct {
+	From     string    `json:"from,omitempty"`
+	To       string    `json:"to,omitempty"`
+	Metrics  []string  `json:"metrics,omitempty"`
+	Datasets []Dataset `json:"datasets,omitempty"`
+}
+
+func (s *diffSide) UnmarshalJSON(b []byte) error {
+	// A bare array is a snapshot; so is a saved generate response, whose
+	// datasets field decodes through the struct below.
+	if trimmed := strings.TrimSpace(string(b)); strings.HasPrefix(trimmed, "[") {
+		s.Datasets = []Dataset{}
+		return json.Unmarshal(b, &s.Datasets)
+	}
+	type plain diffSide
+	return json.Unmarshal(b, (*plain)(s))
+}
+
+type DiffSummary struct {
+	Source string `json:"source"`
+	Series int    `json:"series"`
+	Points int    `json:"points"`
+}
+
+type DiffChange struct {
+	Label  string `json:"label"`
+	Metric string `json:"metric,omitempty"`
+	A      int    `json:"a"`
+	B      int    `json:"b"`
+	Delta  int    `json:"delta"`
+}
+
+type DiffResponse struct {
+	A         DiffSummary  `json:"a"`
+	B         DiffSummary  `json:"b"`
+	Added     []string     `json:"added"`
+	Removed   []string     `json:"removed"`
+	Changed   []DiffChange `json:"changed"`
+	Unchanged int          `json:"unchanged"`
+}
+
+// diffCounts is a side reduced to what the diff compares: points per series
+// label, plus each label's metric.
+type diffCounts struct {
+	summary DiffSummary
+	points  map[string]int
+	metrics map[string]string
+}
+
+// countSide loads a side, reading the live CSVs for a range or taking a
+// snapshot's datasets as given. Ranges are counted raw, not bucketed.
+func countSide(cfg *Config, s diffSide) (diffCounts, error) {
+	c := diffCounts{points: map[string]int{}, metrics: map[string]string{}}
+	if s.Datasets != nil {
+		c.summary.Source = "snapshot"
+		for _, ds := range s.Datasets {
+			c.points[ds.Label] += len(ds.Data)
+			c.metrics[ds.Label] = ds.Metric
+		}
+	} else {
+		if s.From == "" || s.To == "" {
+			return c, fmt.Errorf("each side needs from and to, or datasets")
+		}
+		c.summary.Source = "range " + s.From + ".." + s.To
+		files, err := gymdata.InRange(cfg.csvDir(), s.From, s.To)
+		if err != nil {
+			return c, err
+		}
+		list, _, err := gymdata.Load(cfg.format(), files, s.Metrics)
+		if err != nil {
+			return c, err
+		}
+		for _, sr := range list {
+			label := sr.Key.Label()
+			c.points[label] += len(sr.Points)
+			c.metrics[label] = sr.Key.Metric
+		}
+	}
+	c.summary.Series = len(c.points)
+	for _, n := range c.points {
+		c.summary.Points += n
+	}
+	return c, nil
+}
+
+// buildDiff reports series only in b (added), only in a (removed) and those in
+// both whose point counts differ.
+func buildDiff(a, b diffCounts) DiffResponse {
+	resp := DiffResponse{A: a.summary, B: b.summary, Added: []string{}, Removed: []string{}, Changed: []DiffChange{}}
+	for label, nb := range b.points {
+		na, ok := a.points[label]
+		switch {
+		case !ok:
+			resp.Added = append(resp.Added, label)
+		case na != nb:
+			metric := b.metrics[label]
+			if metric == "" {
+				metric = a.metrics[label]
+			}
+			resp.Changed = append(resp.Changed, DiffChange{Label: label, Metric: metric, A: na, B: nb, Delta: nb - na})
+		default:
+			resp.Unchanged++
+		}
+	}
+	for label := range a.points {
+		if _, ok := b.points[label]; !ok {
+			resp.Removed = append(resp.Removed, label)
+		}
+	}
+	sort.Strings(resp.Added)
+	sort.Strings(resp.Removed)
+	sort.Slice(resp.Changed, func(i, j int) bool { return resp.Changed[i].Label < resp.Changed[j].Label })
+	return resp
+}
+
+// parseRangeParam reads "FROM..TO" (or a single day) into a range side.
+func parseRangeParam(v string) (diffSide, error) {
+	from, to, ok := strings.Cut(strings.TrimSpace(v), "..")
+	if !ok {
+		to = from
+	}
+	if from == "" || to == "" {
+		return diffSide{}, fmt.Errorf("want FROM..TO, got %q", v)
+	}
+	return diffSide{From: from, To: to}, nil
+}
+
+// diffHandler compares two date ranges or snapshots.
+//
+//	GET  /api/diff?a=FROM..TO&b=FROM..TO[&metrics=a,b]
+//	POST /api/diff {"a": <range or snapshot>, "b": <range or snapshot>}
+//
+// A range is {"from","to"[,"metrics"]}; a snapshot is a datasets array or a
+// saved /generate-data-range response.
+func diffHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, POST, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var req struct {
+		A diffSide `json:"a"`
+		B diffSide `json:"b"`
+	}
+	var err error
+	switch r.Method {
+	case "GET":
+		q := r.URL.Query()
+		if req.A, err = parseRangeParam(q.Get("a")); err == nil {
+			req.B, err = parseRangeParam(q.Get("b"))
+		}
+		if m := q.Get("metrics"); m != "" {
+			req.A.Metrics = strings.Split(m, ",")
+			req.B.Metrics = req.A.Metrics
+		}
+	case "POST":
+		r.Body = http.MaxBytesReader(w, r.Body, 64<<20)
+		err = json.NewDecoder(r.Body).Decode(&req)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a, err := countSide(requestConfig(r), req.A)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fieldErr("a", nil, fmt.Errorf("a: %w", err)))
+		return
+	}
+	b, err := countSide(requestConfig(r), req.B)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fieldErr("b", nil, fmt.Errorf("b: %w", err)))
+		return
+	}
+	json.NewEncoder(w).Encode(buildDiff(a, b))
+}
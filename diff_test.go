@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDiffSideDecodesSnapshotsAndRanges(t *testing.T) {
+	var req struct{ A, B, C diffSide }
+	body := `{"a":[{"label":"gym","data":[{"x":"t","y":1}]}],
+		"b":{"success":true,"datasets":[{"label":"gym","data":[]}]},
+		"c":{"from":"2025-10-01","to":"2025-10-02"}}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.A.Datasets) != 1 || req.B.Datasets == nil || req.C.Datasets != nil || req.C.From != "2025-10-01" {
+		t.Errorf("got %+v", req)
+	}
+}
+
+func TestBuildDiff(t *testing.T) {
+	a, _ := countSide(currentConfig(), diffSide{Datasets: []Dataset{
+		{Label: "Kept", Metric: "user_count", Data: make([]DataPoint, 10)},
+		{Label: "Shrunk", Metric: "user_count", Data: make([]DataPoint, 10)},
+		{Label: "Gone", Metric: "user_count", Data: make([]DataPoint, 3)},
+	}})
+	b, _ := countSide(currentConfig(), diffSide{Datasets: []Dataset{
+		{Label: "Kept", Metric: "user_count", Data: make([]DataPoint, 10)},
+		{Label: "Shrunk", Metric: "user_count", Data: make([]DataPoint, 7)},
+		{Label: "New", Metric: "user_count", Data: make([]DataPoint, 1)},
+	}})
+	d := buildDiff(a, b)
+	if len(d.Added) != 1 || d.Added[0] != "New" || len(d.Removed) != 1 || d.Removed[0] != "Gone" {
+		t.Errorf("added/removed = %v / %v", d.Added, d.Removed)
+	}
+	if len(d.Changed) != 1 || d.Changed[0].Label != "Shrunk" || d.Changed[0].Delta != -3 || d.Unchanged != 1 {
+		t.Errorf("changed = %+v, unchanged = %d", d.Changed, d.Unchanged)
+	}
+	if d.A.Points != 23 || d.B.Series != 3 {
+		t.Errorf("summaries = %+v / %+v", d.A, d.B)
+	}
+}
+
+func TestParseRangeParam(t *testing.T) {
+	if s, err := parseRangeParam("2025-10-01..2025-10-07"); err != nil || s.From != "2025-10-01" || s.To != "2025-10-07" {
+		t.Errorf("got %+v, %v", s, err)
+	}
+	if s, _ := parseRangeParam("2025-10-01"); s.To != "2025-10-01" {
+		t.Errorf("single day: got %+v", s)
+	}
+	if _, err := parseRangeParam(""); err == nil {
+		t.Error("expected error for empty range")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"gym/internal/gymdata"
+)
+
+// The kinds of failure clients are told apart by code rather than by
+// message. Give an error one with withKind, or with fieldErr when a request
+// field is at fault.
+var (
+	ErrNoData   = errors.New("no data")         // code no_data
+	ErrBadRange = errors.New("bad range")       // code bad_range
+	ErrParse    = errors.New("unreadable data") // code parse_error
+	ErrConfig   = errors.New("invalid config")  // code invalid_config
+)
+
+// APIError is the body of every JSON error reply. Code is machine-readable:
+// the error's kind if it has one, else named for the status (bad_request,
+// not_found...). Field names the request field at fault, if one is.
+type APIError struct {
+	Success bool   `json:"success"` // always false, as chart replies have it
+	Error   string `json:"error"`
+	Code    string `json:"code"`
+	Field   string `json:"field,omitempty"`
+}
+
+// kindError gives an error a kind without changing its message.
+type kindError struct{ kind, err error }
+
+func (e kindError) Error() string   { return e.err.Error() }
+func (e kindError) Unwrap() []error { return []error{e.kind, e.err} }
+
+func withKind(kind, err error) error {
+	return kindError{kind, err}
+}
+
+// FieldError is a bad value in one field of a request, of kind Kind if set.
+// Its message is Err's, which should name the field.
+type FieldError struct {
+	Field string
+	Kind  error
+	Err   error
+}
+
+func (e *FieldError) Error() string { return e.Err.Error() }
+
+func (e *FieldError) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+func fieldErr(field string, kind, err error) error {
+	return &FieldError{Field: field, Kind: kind, Err: err}
+}
+
+// statusCodes name the statuses errors are sent with.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusGone:                  "expired",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusUnprocessableEntity:   "unprocessable",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusBadGateway:            "upstream_error",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+// apiError is err as sent with status.
+func apiError(status int, err error) APIError {
+	out := APIError{Error: err.Error(), Code: statusCodes[status]}
+	switch {
+	case errors.Is(err, ErrNoData), errors.Is(err, gymdata.ErrNoFiles):
+		out.Code = "no_data"
+	case errors.Is(err, ErrBadRange):
+		out.Code = "bad_range"
+	case errors.Is(err, ErrParse):
+		out.Code = "parse_error"
+	case errors.Is(err, ErrConfig):
+		out.Code = "invalid_config"
+	case out.Code == "":
+		out.Code = "internal"
+	}
+	var fe *FieldError
+	if errors.As(err, &fe) {
+		out.Field = fe.Field
+	}
+	return out
+}
+
+// writeError sends err as an APIError with status.
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError(status, err))
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gym/internal/gymdata"
+)
+
+func TestAPIError(t *testing.T) {
+	for _, c := range []struct {
+		status      int
+		err         error
+		code, field string
+	}{
+		{http.StatusBadRequest, errors.New("Invalid request body"), "bad_request", ""},
+		{http.StatusNotFound, errors.New("no such job"), "not_found", ""},
+		{http.StatusTeapot, errors.New("odd"), "internal", ""},
+		{http.StatusNotFound, withKind(ErrNoData, errors.New("no readings")), "no_data", ""},
+		{http.StatusInternalServerError, fmt.Errorf("latest: %w", gymdata.ErrNoFiles), "no_data", ""},
+		{http.StatusInternalServerError, withKind(ErrParse, errors.New("Failed to convert CSV files")), "parse_error", ""},
+		{http.StatusBadRequest, fieldErr("location", nil, errors.New("location is required")), "bad_request", "location"},
+		{http.StatusBadRequest, fmt.Errorf("ranges[1]: %w", fieldErr("to", ErrBadRange, errors.New("to: bad"))), "bad_range", "to"},
+	} {
+		got := apiError(c.status, c.err)
+		if got.Success || got.Error != c.err.Error() || got.Code != c.code || got.Field != c.field {
+			t.Errorf("apiError(%d, %v) = %+v, want code %q field %q", c.status, c.err, got, c.code, c.field)
+		}
+	}
+}
+
+func TestRangeErrorCodes(t *testing.T) {
+	loadTallinn(t)
+	old := currentConfig()
+	defer setConfig(old)
+	setConfig(&Config{DataDir: t.TempDir(), AuditLog: "gym-audit.jsonl", AnnotationsFile: "gym-annotations.json", PrefsFile: "gym-prefs.json"})
+
+	for _, c := range []struct {
+		body, code, field string
+	}{
+		{`{"from": "yesterday", "to": "2025-10-01"}`, "bad_range", "from"},
+		{`{"from": "2025-10-01", "to": "10/02/2025"}`, "bad_range", "to"},
+		{`{"from": "2025-10-01T18:00:00Z", "to": "2025-10-01T17:00:00Z"}`, "bad_range", "to"},
+		{`{"from": `, "bad_request", ""},
+	} {
+		w := httptest.NewRecorder()
+		generateDataRangeHandler(w, httptest.NewRequest("POST", "/generate-data-range", strings.NewReader(c.body)))
+		var got APIError
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: code %d: %s", c.body, w.Code, w.Body)
+		}
+		if got.Success || got.Error == "" || got.Code != c.code || got.Field != c.field {
+			t.Errorf("%s: got %+v, want code %q field %q", c.body, got, c.code, c.field)
+		}
+	}
+}
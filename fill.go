@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gym/internal/gymdata"
+)
+
+// requestFill reads a request's fill option. Unset or none leaves each gym
+// on its own readings' times; null, previous or linear puts every gym on one
+// time axis per metric, filling the instants a gym has no reading at as
+// gymdata.Align says.
+func requestFill(fill string) (f gymdata.Fill, aligned bool, err error) {
+	switch strings.ToLower(strings.TrimSpace(fill)) {
+	case "", "none":
+		return 0, false, nil
+	case "null":
+		return gymdata.FillNull, true, nil
+	case "previous":
+		return gymdata.FillPrevious, true, nil
+	case "linear":
+		return gymdata.FillLinear, true, nil
+	}
+	return 0, false, fmt.Errorf("fill must be none, null, previous or linear")
+}
+
+// withFill returns list on a shared time axis when the request's fill option
+// asks for one, filling across gaps no longer than a total bridges. It runs
+// last, so the Total and rolled-up clubs share the axis too. list itself,
+// which may be cached, is not changed.
+func withFill(list []*gymdata.Series, fill string, bucketMinutes int) []*gymdata.Series {
+	f, aligned, _ := requestFill(fill) // checked with the request
+	if !aligned {
+		return list
+	}
+	return gymdata.Align(list, f, bridgeGap(bucketMinutes))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+func TestWithFill(t *testing.T) {
+	series := func(location string, points ...gymdata.Point) *gymdata.Series {
+		return &gymdata.Series{Key: gymdata.Key{Location: location, Metric: gymdata.DefaultMetric}, Points: points}
+	}
+	list := []*gymdata.Series{
+		series("Hipodroom", gymdata.Point{At: 0, Y: 10}, gymdata.Point{At: 7200, Y: 20}),
+		series("T1", gymdata.Point{At: 3600, Y: 5}),
+	}
+	if got := withFill(list, "", 60); len(got[0].Points) != 2 {
+		t.Errorf("no fill: %v, want the readings as they are", got[0].Points)
+	}
+	got := withFill(list, "linear", 60)
+	if len(got[0].Points) != 3 || got[0].Points[1].Y != 15 || len(got[1].Points) != 3 || !math.IsNaN(got[1].Points[0].Y) {
+		t.Fatalf("linear: %v and %v", got[0].Points, got[1].Points)
+	}
+
+	// Unfilled instants come back as null, or an empty CSV cell.
+	var js bytes.Buffer
+	writeDatasets(&js, got[1:], pointFormat{loc: time.UTC, pairs: true}, false)
+	if want := `[{"label":"T1","metric":"user_count","data":[["1970-01-01T00:00:00Z",null],["1970-01-01T01:00:00Z",5],["1970-01-01T02:00:00Z",null]]}]`; js.String() != want {
+		t.Errorf("JSON %s, want %s", js.String(), want)
+	}
+	var csv bytes.Buffer
+	writeDatasetsCSV(&csv, got[1:], pointFormat{loc: time.UTC})
+	if !strings.Contains(csv.String(), "T1,user_count,1970-01-01T00:00:00Z,,\n") {
+		t.Errorf("CSV %q, want an empty y for the gap", csv.String())
+	}
+
+	for _, bad := range []string{"zero", "carry"} {
+		if _, _, err := requestFill(bad); err == nil {
+			t.Errorf("fill=%s: expected an error", bad)
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// Goal is "go to Location when fewer than Below people are there"; an empty
+// Location means whichever gym the visit was to.
+type Goal struct {
+	ID       int    `json:"id"`
+	Location string `json:"location,omitempty"`
+	Below    int    `json:"below"`
+	Created  string `json:"created,omitempty"`
+}
+
+// Visit is a trip to a gym the user logged.
+type Visit struct {
+	ID       int    `json:"id"`
+	Location string `json:"location"`
+	At       string `json:"at"`
+}
+
+// goalBook is one owner's goals and visits as stored.
+type goalBook struct {
+	Goals   []Goal  `json:"goals"`
+	Visits  []Visit `json:"visits"`
+	NextID  int     `json:"nextId"`
+	Updated string  `json:"updated"`
+}
+
+// GoalProgress is how a goal is going: of the Visits it applies to that
+// have a reading, how many Hits were below the target, and the run of hits
+// up to the latest visit.
+type GoalProgress struct {
+	Goal
+	Visits     int     `json:"visits"`
+	Hits       int     `json:"hits"`
+	HitRate    float64 `json:"hitRate"`
+	Streak     int     `json:"streak"`
+	BestStreak int     `json:"bestStreak"`
+}
+
+// VisitResult is a visit with the gym's count at the time, null until the
+// collector has a reading within visitWindow of it, and the goals it met.
+type VisitResult struct {
+	Visit
+	Count *int  `json:"count"`
+	Met   []int `json:"met"`
+}
+
+type GoalsResponse struct {
+	Goals  []GoalProgress `json:"goals"`
+	Visits []VisitResult  `json:"visits"`
+}
+
+const (
+	maxGoals      = 20
+	maxVisits     = 1000 // per owner; the oldest go first
+	maxGoalOwners = 1000 // as for preferences, the least recently changed go first
+	// visitWindow is how far the reading a visit is judged by may be from it.
+	visitWindow = 10 * time.Minute
+)
+
+var goalsMu sync.Mutex
+
+// readGoals loads the store, owner -> book; a missing file is empty.
+func readGoals(cfg *Config) (map[string]*goalBook, error) {
+	data, err := os.ReadFile(cfg.path(cfg.GoalsFile))
+	if os.IsNotExist(err) {
+		return map[string]*goalBook{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	store := map[string]*goalBook{}
+	if err := json.Unmarshal(data, &store); err != nil {
+		return nil, fmt.Errorf("%s: %v", cfg.GoalsFile, err)
+	}
+	return store, nil
+}
+
+// writeGoals saves every user's goals.
+func writeGoals(cfg *Config, store map[string]*goalBook) error {
+	return writeFileAtomic(cfg.path(cfg.GoalsFile), store)
+}
+
+// visitCounts finds each visit's count: the reading at its gym nearest to it
+// within visitWindow. Only the days the visits fall on are read.
+func visitCounts(cfg *Config, visits []Visit) map[int]int {
+	tallinn := gymdata.Tallinn()
+	byDay := map[string][]Visit{}
+	for _, v := range visits {
+		at, err := time.Parse(time.RFC3339, v.At)
+		if err != nil {
+			continue
+		}
+		from := at.Add(-visitWindow).In(tallinn).Format("2006-01-02")
+		to := at.Add(visitWindow).In(tallinn).Format("2006-01-02")
+		byDay[from+".."+to] = append(byDay[from+".."+to], v)
+	}
+
+	counts := map[int]int{}
+	for days, list := range byDay {
+		from, to, _ := strings.Cut(days, "..")
+		files, err := gymdata.InRange(cfg.csvDir(), from, to)
+		if err != nil || len(files) == 0 {
+			continue
+		}
+		series, _, err := gymdata.Load(cfg.format(), files, nil)
+		if err != nil {
+			continue
+		}
+		byLocation := map[string][]gymdata.Point{}
+		for _, s := range series {
+			byLocation[s.Key.Location] = s.Points
+		}
+		for _, v := range list {
+			at, _ := time.Parse(time.RFC3339, v.At)
+			points := byLocation[v.Location]
+			i := sort.Search(len(points), func(i int) bool { return points[i].At >= at.Unix() })
+			best, bestGap := -1, int64(visitWindow/time.Second)+1
+			for _, j := range []int{i - 1, i} {
+				if j < 0 || j >= len(points) {
+					continue
+				}
+				gap := points[j].At - at.Unix()
+				if gap < 0 {
+					gap = -gap
+				}
+				if gap < bestGap {
+					best, bestGap = j, gap
+				}
+			}
+			if best >= 0 {
+				counts[v.ID] = int(points[best].Y)
+			}
+		}
+	}
+	return counts
+}
+
+// progress scores the book's visits against its goals, visits newest first.
+func (b *goalBook) progress(cfg *Config, loc *time.Location) GoalsResponse {
+	counts := visitCounts(cfg, b.Visits)
+	visits := make([]Visit, len(b.Visits))
+	copy(visits, b.Visits)
+	sort.SliceStable(visits, func(i, j int) bool { return visits[i].At < visits[j].At })
+
+	resp := GoalsResponse{Goals: []GoalProgress{}, Visits: []VisitResult{}}
+	for _, g := range b.Goals {
+		p := GoalProgress{Goal: g}
+		for _, v := range visits {
+			n, ok := counts[v.ID]
+			if !ok || (g.Location != "" && g.Location != v.Location) {
+				continue
+			}
+			p.Visits++
+			if n < g.Below {
+				p.Hits++
+				p.Streak++
+				p.BestStreak = max(p.BestStreak, p.Streak)
+			} else {
+				p.Streak = 0
+			}
+		}
+		if p.Visits > 0 {
+			p.HitRate = float64(p.Hits) / float64(p.Visits)
+		}
+		resp.Goals = append(resp.Goals, p)
+	}
+	for i := len(visits) - 1; i >= 0; i-- {
+		resp.Visits = append(resp.Visits, visitResult(visits[i], counts, b.Goals, loc))
+	}
+	return resp
+}
+
+func visitResult(v Visit, counts map[int]int, goals []Goal, loc *time.Location) VisitResult {
+	r := VisitResult{Visit: v, Met: []int{}}
+	if at, err := time.Parse(time.RFC3339, v.At); err == nil {
+		r.At = at.In(loc).Format(time.RFC3339)
+	}
+	n, ok := counts[v.ID]
+	if !ok {
+		return r
+	}
+	r.Count = &n
+	for _, g := range goals {
+		if (g.Location == "" || g.Location == v.Location) && n < g.Below {
+			r.Met = append(r.Met, g.ID)
+		}
+	}
+	return r
+}
+
+// goalsHandler serves the caller's goals and visits, owned like preferences
+// by the API key presented or the browser's X-Client-ID.
+//
+//	GET    /api/goals[?tz=ZONE]
+//	POST   /api/goals {below[, location]}
+//	DELETE /api/goals/ID
+//	POST   /api/goals/visits {location[, at]}
+//	DELETE /api/goals/visits/ID
+func goalsHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	fail := func(status int, err error) {
+		writeError(w, status, err)
+	}
+
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/goals"), "/")
+	kind, idText, _ := strings.Cut(rest, "/")
+	if kind != "visits" {
+		kind, idText = "goals", rest
+	}
+	id := 0
+	if idText != "" {
+		n, err := strconv.Atoi(idText)
+		if err != nil || n < 1 {
+			fail(http.StatusNotFound, fmt.Errorf("no %s %q", strings.TrimSuffix(kind, "s"), idText))
+			return
+		}
+		id = n
+	}
+	switch {
+	case r.Method == "GET" && kind == "goals" && id == 0:
+	case r.Method == "POST" && id == 0:
+	case r.Method == "DELETE" && id != 0:
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg := requestConfig(r)
+	owner := prefsOwner(r, cfg)
+	if owner == "" {
+		fail(http.StatusBadRequest, fmt.Errorf("goals are per API key or X-Client-ID (8-64 letters, digits, - or _)"))
+		return
+	}
+	outZone, err := requestZone(r.URL.Query().Get("tz"), gymdata.Tallinn())
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+
+	var goal Goal
+	var visit Visit
+	if r.Method == "POST" {
+		body := http.MaxBytesReader(w, r.Body, 16<<10)
+		if kind == "goals" {
+			err = json.NewDecoder(body).Decode(&goal)
+		} else {
+			err = json.NewDecoder(body).Decode(&visit)
+		}
+		if err != nil {
+			fail(http.StatusBadRequest, fmt.Errorf("invalid request body"))
+			return
+		}
+		if kind == "goals" {
+			err = goal.check()
+		} else {
+			err = visit.check(time.Now())
+		}
+		if err != nil {
+			fail(http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	goalsMu.Lock()
+	defer goalsMu.Unlock()
+	store, err := readGoals(cfg)
+	if err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	b := store[owner]
+	if b == nil {
+		b = &goalBook{Goals: []Goal{}, Visits: []Visit{}}
+	}
+	if r.Method == "GET" {
+		json.NewEncoder(w).Encode(b.progress(cfg, outZone))
+		return
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	var created any
+	switch {
+	case r.Method == "POST" && kind == "goals":
+		if len(b.Goals) >= maxGoals {
+			fail(http.StatusBadRequest, fmt.Errorf("at most %d goals; delete one first", maxGoals))
+			return
+		}
+		b.NextID++
+		goal.ID, goal.Created = b.NextID, now
+		b.Goals = append(b.Goals, goal)
+		created = goal
+	case r.Method == "POST":
+		b.NextID++
+		visit.ID = b.NextID
+		b.Visits = append(b.Visits, visit)
+		if len(b.Visits) > maxVisits {
+			sort.SliceStable(b.Visits, func(i, j int) bool { return b.Visits[i].At < b.Visits[j].At })
+			b.Visits = b.Visits[len(b.Visits)-maxVisits:]
+		}
+		created = visitResult(visit, visitCounts(cfg, []Visit{visit}), b.Goals, outZone)
+	case kind == "goals":
+		i := goalIndex(b.Goals, id)
+		if i < 0 {
+			fail(http.StatusNotFound, fmt.Errorf("no goal %d", id))
+			return
+		}
+		b.Goals = append(b.Goals[:i], b.Goals[i+1:]...)
+	default:
+		i := visitIndex(b.Visits, id)
+		if i < 0 {
+			fail(http.StatusNotFound, fmt.Errorf("no visit %d", id))
+			return
+		}
+		b.Visits = append(b.Visits[:i], b.Visits[i+1:]...)
+	}
+	b.Updated = now
+	store[owner] = b
+	if len(store) > maxGoalOwners {
+		owners := make([]string, 0, len(store))
+		for o := range store {
+			owners = append(owners, o)
+		}
+		sort.Slice(owners, func(i, j int) bool { return store[owners[i]].Updated < store[owners[j]].Updated })
+		for _, o := range owners[:len(store)-maxGoalOwners] {
+			delete(store, o)
+		}
+	}
+	if err := writeGoals(cfg, store); err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	if r.Method == "DELETE" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+func goalIndex(goals []Goal, id int) int {
+	for i, g := range goals {
+		if g.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func visitIndex(visits []Visit, id int) int {
+	for i, v := range visits {
+		if v.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// check rejects targets no count could meet or that name no plausible gym.
+func (g *Goal) check() error {
+	g.Location = strings.TrimSpace(g.Location)
+	if len(g.Location) > 100 {
+		return fmt.Errorf("location: at most 100 characters")
+	}
+	if g.Below < 1 || g.Below > 10000 {
+		return fmt.Errorf("below: want 1-10000 people")
+	}
+	return nil
+}
+
+// check normalises a visit's time to RFC 3339, defaulting to now, and
+// rejects visits still to come.
+func (v *Visit) check(now time.Time) error {
+	v.Location = strings.TrimSpace(v.Location)
+	if v.Location == "" {
+		return fmt.Errorf("location is required")
+	}
+	if len(v.Location) > 100 {
+		return fmt.Errorf("location: at most 100 characters")
+	}
+	at := now
+	if strings.TrimSpace(v.At) != "" {
+		t, err := parseAnnotationTime(v.At)
+		if err != nil {
+			return fmt.Errorf("at: %v", err)
+		}
+		if t.After(now.Add(5 * time.Minute)) {
+			return fmt.Errorf("at: visits can't be logged ahead")
+		}
+		at = t
+	}
+	v.At = at.UTC().Format(time.RFC3339)
+	return nil
+}
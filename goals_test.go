@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGoalsHandler(t *testing.T) {
+	loadTallinn(t)
+	old := currentConfig()
+	defer setConfig(old)
+	dir := t.TempDir()
+	setConfig(&Config{DataDir: dir, GoalsFile: "gym-goals.json"})
+	writeCSV(t, dir, "gym-stats-20251001.csv",
+		"timestamp,timezone,location_id,location_name,user_count,status,response\n"+
+			"2025-10-01 07:00:00,EEST,1,Hipodroom,8,success,{}\n"+
+			"2025-10-01 18:00:00,EEST,1,Hipodroom,60,success,{}\n"+
+			"2025-10-01 21:00:00,EEST,1,Hipodroom,12,success,{}\n"+
+			"2025-10-01 21:00:00,EEST,2,T1,30,success,{}\n")
+
+	browser := "3f2a9c1e-browser"
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("X-Client-ID", browser)
+		w := httptest.NewRecorder()
+		goalsHandler(w, r)
+		return w
+	}
+
+	if w := call("POST", "/api/goals", `{"below":0}`); w.Code != 400 {
+		t.Errorf("below 0: code %d, want 400", w.Code)
+	}
+	if w := call("POST", "/api/goals", `{"below":20}`); w.Code != 201 {
+		t.Fatalf("goal: %d %s", w.Code, w.Body)
+	}
+	if w := call("POST", "/api/goals", `{"below":10,"location":"Hipodroom"}`); w.Code != 201 {
+		t.Fatalf("Hipodroom goal: %d %s", w.Code, w.Body)
+	}
+	for _, at := range []string{"2025-10-01 07:03", "2025-10-01 18:00", "2025-10-01 21:05"} {
+		if w := call("POST", "/api/goals/visits", `{"location":"Hipodroom","at":"`+at+`"}`); w.Code != 201 {
+			t.Fatalf("visit %s: %d %s", at, w.Code, w.Body)
+		}
+	}
+	w := call("POST", "/api/goals/visits", `{"location":"T1","at":"2025-10-01T21:00:00+03:00"}`)
+	var v VisitResult
+	json.Unmarshal(w.Body.Bytes(), &v)
+	if w.Code != 201 || v.Count == nil || *v.Count != 30 || len(v.Met) != 0 {
+		t.Errorf("T1 visit = %d %s, want a count of 30 meeting nothing", w.Code, w.Body)
+	}
+	// No reading within 10 minutes: counted once the collector has one.
+	call("POST", "/api/goals/visits", `{"location":"Hipodroom","at":"2025-10-01 12:00"}`)
+	if w := call("POST", "/api/goals/visits", `{"location":"Hipodroom","at":"2999-01-01"}`); w.Code != 400 {
+		t.Errorf("future visit: code %d, want 400", w.Code)
+	}
+
+	var resp GoalsResponse
+	json.Unmarshal(call("GET", "/api/goals?tz=UTC", "").Body.Bytes(), &resp)
+	if len(resp.Goals) != 2 || len(resp.Visits) != 5 {
+		t.Fatalf("got %+v, want 2 goals and 5 visits", resp)
+	}
+	// Any gym under 20: 8 yes, 60 no, 12 yes, 30 no (by time: 07, 18, 21, 21).
+	if g := resp.Goals[0]; g.Visits != 4 || g.Hits != 2 || g.HitRate != 0.5 || g.BestStreak != 1 {
+		t.Errorf("any-gym goal = %+v", g)
+	}
+	// Hipodroom under 10: only the 8.
+	if g := resp.Goals[1]; g.Visits != 3 || g.Hits != 1 || g.Streak != 0 {
+		t.Errorf("Hipodroom goal = %+v", g)
+	}
+	if first := resp.Visits[0]; first.At != "2025-10-01T18:05:00Z" || first.Count == nil || *first.Count != 12 {
+		t.Errorf("newest visit = %+v, want 21:05 local in UTC with the 12", first)
+	}
+	if noon := resp.Visits[3]; noon.Count != nil {
+		t.Errorf("noon visit = %+v, want no count", noon)
+	}
+
+	if w := call("DELETE", "/api/goals/1", ""); w.Code != 204 {
+		t.Errorf("DELETE goal: code %d", w.Code)
+	}
+	if w := call("DELETE", "/api/goals/visits/99", ""); w.Code != 404 {
+		t.Errorf("DELETE missing visit: code %d, want 404", w.Code)
+	}
+	if w := call("GET", "/api/goals/visits", ""); w.Code != 405 {
+		t.Errorf("GET visits: code %d, want 405", w.Code)
+	}
+	browser = "another-browser"
+	resp = GoalsResponse{}
+	json.Unmarshal(call("GET", "/api/goals", "").Body.Bytes(), &resp)
+	if len(resp.Goals) != 0 || len(resp.Visits) != 0 {
+		t.Errorf("another browser sees %+v", resp)
+	}
+}
@@ -50,6 +50,57 @@ fi
 # Build cookies from config
 COOKIES="PHPSESSID=${PHPSESSID}; XSRF-TOKEN=${XSRF_TOKEN}; laravel_session=${LARAVEL_SESSION}"
 
+# Polling, backoff and circuit breaker settings (seconds, or failed polls)
+POLL_INTERVAL="${POLL_INTERVAL:-120}"
+BACKOFF_MAX="${BACKOFF_MAX_SECONDS:-1800}"
+BREAKER_FAILURES="${BREAKER_FAILURES:-5}"
+BREAKER_COOLDOWN="${BREAKER_COOLDOWN_SECONDS:-900}"
+
+# Response headers of the last request, for Retry-After
+HEADER_FILE="$(mktemp)"
+trap 'rm -f "$HEADER_FILE"' EXIT
+
+# HTTP status of the last upstream request (000 if it never answered)
+LAST_CODE=""
+
+# Seconds the upstream asked us to wait (Retry-After), empty if it didn't
+retry_after() {
+    grep -i '^retry-after:' "$HEADER_FILE" 2>/dev/null | tail -1 | tr -dc '0-9'
+}
+
+# Writes an error row for every location, so a poll that didn't happen shows
+# up in the CSV as a failed one rather than as a silent gap.
+record_error_rows() {
+    local status="$1" message="$2"
+    local timestamp timezone log_file
+    timestamp=$(date '+%Y-%m-%d %H:%M:%S')
+    timezone=$(date '+%Z')
+    log_file="$(get_log_file)"
+    ensure_csv_header "$log_file"
+    for location_pair in $LOCATIONS; do
+        echo "$timestamp,$timezone,${location_pair%%:*},${location_pair#*:},error,$status,\"$message\"" >> "$log_file"
+    done
+}
+
+# Delay before the next poll after the given number of failed polls in a row:
+# doubling from POLL_INTERVAL up to BACKOFF_MAX, give or take a quarter so
+# restarted collectors don't retry in step, and never less than Retry-After.
+backoff_delay() {
+    local failures="$1" delay="$POLL_INTERVAL" wait
+    for ((i = 0; i < failures && delay < BACKOFF_MAX; i++)); do
+        delay=$((delay * 2))
+    done
+    if [ "$delay" -gt "$BACKOFF_MAX" ]; then
+        delay=$BACKOFF_MAX
+    fi
+    delay=$((delay * 3 / 4 + RANDOM % (delay / 2 + 1)))
+    wait="$(retry_after)"
+    if [ -n "$wait" ] && [ "$wait" -gt "$delay" ]; then
+        delay=$wait
+    fi
+    echo "$delay"
+}
+
 # Primary: one request for all locations via the new API
 collect_data_api() {
     timestamp=$(date '+%Y-%m-%d %H:%M:%S')
@@ -61,13 +112,14 @@ collect_data_api() {
 
     echo "[$timestamp] Collecting data for all locations (primary API)"
 
-    response=$(curl -s -w "HTTPSTATUS:%{http_code}" "$API_URL" \
+    response=$(curl -s -D "$HEADER_FILE" -w "HTTPSTATUS:%{http_code}" "$API_URL" \
         -H "Authorization: Bearer $API_TOKEN" \
         -H 'Accept: application/json' \
         --max-time 30)
 
     http_code=$(echo "$response" | grep -o "HTTPSTATUS:[0-9]*" | cut -d: -f2)
     body=$(echo "$response" | sed 's/HTTPSTATUS:[0-9]*$//')
+    LAST_CODE="$http_code"
 
     if [ "$http_code" != "200" ]; then
         echo "  -> ERROR: HTTP $http_code"
@@ -106,16 +158,23 @@ collect_data_legacy() {
     # Ensure CSV header exists for today's file
     ensure_csv_header "$log_file"
 
-    # Loop through all locations
+    # Loop through all locations; succeeds if any of them answered
+    local collected=1
     for location_pair in $LOCATIONS; do
         location_id="${location_pair%:*}"
         location_name="$(get_location_name $location_id)"
         url="${BASE_URL}${location_id}"
 
+        # Once rate limited, don't ask about the remaining locations
+        if [ "$LAST_CODE" = "429" ]; then
+            echo "$timestamp,$timezone,$location_id,$location_name,error,429,\"skipped: rate limited\"" >> "$log_file"
+            continue
+        fi
+
         echo "[$timestamp] Collecting data for $location_name (ID: $location_id)"
 
         # Make request with error handling
-        response=$(curl -s -w "HTTPSTATUS:%{http_code}" "$url" \
+        response=$(curl -s -D "$HEADER_FILE" -w "HTTPSTATUS:%{http_code}" "$url" \
             -H 'User-Agent: Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:142.0) Gecko/20100101 Firefox/142.0' \
             -H 'Accept: application/json, text/plain, */*' \
             -H 'Accept-Language: en-US,en;q=0.5' \
@@ -131,8 +190,10 @@ collect_data_legacy() {
         # Extract HTTP status and body
         http_code=$(echo "$response" | grep -o "HTTPSTATUS:[0-9]*" | cut -d: -f2)
         body=$(echo "$response" | sed 's/HTTPSTATUS:[0-9]*$//')
+        LAST_CODE="$http_code"
 
         if [ "$http_code" = "200" ]; then
+            collected=0
             # Try to extract user count from JSON
             user_count=$(echo "$body" | python3 -c "import sys, json; data=json.load(sys.stdin); print(data.get('total', 'unknown'))" 2>/dev/null || echo "parse_error")
             echo "$timestamp,$timezone,$location_id,$location_name,$user_count,success,\"$body\"" >> "$log_file"
@@ -148,26 +209,84 @@ collect_data_legacy() {
         fi
 
     done
+    return $collected
 }
 
+# Polls the upstream once; fails if no location could be read.
 collect_data() {
+    LAST_CODE=""
+    : > "$HEADER_FILE"
     if [ -n "$API_TOKEN" ] && collect_data_api; then
-        return
+        return 0
+    fi
+    # A rate limit applies to the legacy API too; don't add to the load
+    if [ "$LAST_CODE" = "429" ]; then
+        echo "  -> Rate limited, skipping the legacy API"
+        record_error_rows 429 "rate limited"
+        return 1
     fi
     echo "  -> Falling back to legacy API"
     collect_data_legacy
 }
 
+# Copies today's file to the bucket when CSV_SOURCE is an s3:// source, so a
+# dashboard server on another host sees the new readings. curl signs the PUT.
+upload_log_file() {
+    local log_file="$1"
+    case "$CSV_SOURCE" in
+        s3://*) ;;
+        *) return ;;
+    esac
+    if [ -z "$S3_ENDPOINT" ] || [ ! -f "$log_file" ]; then
+        return
+    fi
+    local target="${CSV_SOURCE#s3://}"
+    target="${target%/}"
+    local auth=()
+    if [ -n "$S3_ACCESS_KEY" ]; then
+        auth=(--aws-sigv4 "aws:amz:${S3_REGION:-us-east-1}:s3" --user "$S3_ACCESS_KEY:$S3_SECRET_KEY")
+    fi
+    if ! curl -sf "${auth[@]}" -T "$log_file" "${S3_ENDPOINT%/}/$target/$log_file" > /dev/null; then
+        echo "  -> Upload of $log_file to $CSV_SOURCE failed"
+    fi
+}
+
 # Main loop
 echo "Starting gym stats collection (Ctrl+C to stop)"
 echo "Data will be logged to daily files: gym-stats-YYYYMMDD.csv"
 
+# Failed polls in a row, and until when (epoch seconds) the circuit breaker
+# keeps the upstream from being called after BREAKER_FAILURES of them
+failures=0
+open_until=0
+
 while true; do
     current_log="$(get_log_file)"
     echo "Current log file: $current_log"
 
-    collect_data
+    delay=$POLL_INTERVAL
+    now=$(date +%s)
+    if [ "$now" -lt "$open_until" ]; then
+        echo "  -> Circuit open for $((open_until - now))s more, not calling the API"
+        record_error_rows circuit_open "circuit open after $failures failed polls"
+    elif collect_data; then
+        if [ "$failures" -gt 0 ]; then
+            echo "  -> Upstream recovered after $failures failed polls"
+        fi
+        failures=0
+    else
+        # After the cooldown one poll is let through; if it fails too the
+        # circuit opens again straight away
+        failures=$((failures + 1))
+        if [ "$failures" -ge "$BREAKER_FAILURES" ]; then
+            open_until=$((now + BREAKER_COOLDOWN))
+            echo "  -> $failures failed polls in a row, pausing API calls for ${BREAKER_COOLDOWN}s"
+        else
+            delay=$(backoff_delay "$failures")
+            echo "  -> Retrying in ${delay}s"
+        fi
+    fi
+    upload_log_file "$current_log"
 
-    # 2 minute delay before next collection cycle
-    sleep $((60*2))
+    sleep "$delay"
 done
\ No newline at end of file
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// historyMaxBytes is when the history log rolls over: it is renamed to
+// HISTORY_FILE.1, replacing the one before, so two logs' worth are kept.
+const historyMaxBytes = 16 << 20
+
+// HistoryEntry is one chart generation request: what it asked for, what it
+// took and who asked. Ranges counts the charts in a bulk request.
+type HistoryEntry struct {
+	Time       string `json:"time"`
+	Endpoint   string `json:"endpoint"`
+	Status     int    `json:"status"`
+	DurationMs int64  `json:"durationMs"`
+	From       string `json:"from,omitempty"`
+	To         string `json:"to,omitempty"`
+	Ranges     int    `json:"ranges,omitempty"`
+	Files      int    `json:"files"`
+	Rows       int    `json:"rows"`
+	Series     int    `json:"series"`
+	Cached     bool   `json:"cached,omitempty"`
+	Async      bool   `json:"async,omitempty"`
+	Actor      string `json:"actor,omitempty"`
+	IP         string `json:"ip,omitempty"`
+	Client     string `json:"client,omitempty"` // X-Client-ID
+}
+
+// HistoryHour sums an hour's requests, for setting beside server load.
+type HistoryHour struct {
+	Hour       string `json:"hour"`
+	Requests   int    `json:"requests"`
+	Cached     int    `json:"cached"`
+	Errors     int    `json:"errors"`
+	DurationMs int64  `json:"durationMs"`
+	Rows       int    `json:"rows"`
+}
+
+type HistoryResponse struct {
+	Total   int            `json:"total"`
+	Hours   []HistoryHour  `json:"hours"`
+	Entries []HistoryEntry `json:"entries"`
+}
+
+type historyKey struct{}
+
+var historyMu sync.Mutex
+
+// withHistory records each request to h in the history log, timed, with
+// what h noted of it through historyFor.
+func withHistory(endpoint string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			h(w, r)
+			return
+		}
+		start := time.Now()
+		e := &HistoryEntry{Endpoint: endpoint}
+		rec := &statusRecorder{ResponseWriter: w}
+		h(rec, r.WithContext(context.WithValue(r.Context(), historyKey{}, e)))
+
+		cfg := requestConfig(r)
+		_, actor, _ := requestRole(r, cfg)
+		e.Time = start.UTC().Format(time.RFC3339)
+		e.DurationMs = time.Since(start).Milliseconds()
+		e.Status = rec.code
+		if e.Status == 0 {
+			e.Status = http.StatusOK
+		}
+		e.Actor, e.IP = actor, clientIP(r)
+		if id := r.Header.Get("X-Client-ID"); clientID.MatchString(id) {
+			e.Client = id
+		}
+		appendHistory(cfg, *e)
+	}
+}
+
+// historyFor is the entry r's request is being recorded under, or nil.
+func historyFor(r *http.Request) *HistoryEntry {
+	e, _ := r.Context().Value(historyKey{}).(*HistoryEntry)
+	return e
+}
+
+// noteChart adds a chart served from series built as meta says. A nil
+// entry ignores it, as for requests that aren't recorded.
+func (e *HistoryEntry) noteChart(meta *ResponseMeta, series []*gymdata.Series) {
+	if e == nil || meta == nil {
+		return
+	}
+	e.Ranges++
+	if e.Ranges == 1 {
+		e.From, e.To, e.Cached = meta.From, meta.To, meta.Cached
+	} else {
+		e.From, e.To, e.Cached = min(e.From, meta.From), max(e.To, meta.To), e.Cached && meta.Cached
+	}
+	e.Files += len(meta.Files)
+	e.Rows += meta.Rows
+	e.Series += len(series)
+}
+
+// appendHistory adds one line to cfg's history log, rolling it over once
+// it's big enough. Failures are logged, never the request's problem.
+func appendHistory(cfg *Config, e HistoryEntry) {
+	if e.Ranges == 1 {
+		e.Ranges = 0 // only worth saying for bulk requests
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("History: %v", err)
+		return
+	}
+	path := cfg.path(cfg.HistoryFile)
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		log.Printf("History: %v", err)
+		return
+	}
+	_, err = file.Write(append(line, '\n'))
+	info, serr := file.Stat()
+	file.Close()
+	if err != nil {
+		log.Printf("History: %v", err)
+		return
+	}
+	if serr == nil && info.Size() >= historyMaxBytes {
+		if err := os.Rename(path, path+".1"); err != nil {
+			log.Printf("History: %v", err)
+		}
+	}
+}
+
+// readHistory returns the entries kept, oldest first, that keep says to.
+func readHistory(cfg *Config, keep func(HistoryEntry) bool) ([]HistoryEntry, error) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	var out []HistoryEntry
+	path := cfg.path(cfg.HistoryFile)
+	for _, name := range []string{path + ".1", path} {
+		file, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var e HistoryEntry
+			if json.Unmarshal(scanner.Bytes(), &e) == nil && keep(e) {
+				out = append(out, e)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// summarizeHistory sums entries by hour in loc, oldest first.
+func summarizeHistory(entries []HistoryEntry, loc *time.Location) []HistoryHour {
+	byHour := map[string]*HistoryHour{}
+	for _, e := range entries {
+		t, err := time.Parse(time.RFC3339, e.Time)
+		if err != nil {
+			continue
+		}
+		hour := t.In(loc).Truncate(time.Hour).Format(time.RFC3339)
+		h := byHour[hour]
+		if h == nil {
+			h = &HistoryHour{Hour: hour}
+			byHour[hour] = h
+		}
+		h.Requests++
+		if e.Cached {
+			h.Cached++
+		}
+		if e.Status >= 400 {
+			h.Errors++
+		}
+		h.DurationMs += e.DurationMs
+		h.Rows += e.Rows
+	}
+	out := make([]HistoryHour, 0, len(byHour))
+	for _, h := range byHour {
+		out = append(out, *h)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Hour < out[j].Hour })
+	return out
+}
+
+// historyHandler serves the generation history to admins: the newest
+// entries, and every matching one summed by hour.
+//
+//	GET /api/history[?since=TIME][&until=TIME][&endpoint=PATH][&actor=NAME][&limit=N][&tz=ZONE]
+//
+// since and until take RFC 3339 or Tallinn YYYY-MM-DD[ HH:MM]; since
+// defaults to a week ago.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	loc, err := requestZone(q.Get("tz"), gymdata.Tallinn())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fieldErr("tz", nil, err))
+		return
+	}
+	since, until := time.Now().AddDate(0, 0, -7), time.Time{}
+	if s := q.Get("since"); s != "" {
+		if since, err = parseAnnotationTime(s); err != nil {
+			writeError(w, http.StatusBadRequest, fieldErr("since", ErrBadRange, err))
+			return
+		}
+	}
+	if s := q.Get("until"); s != "" {
+		if until, err = parseAnnotationTime(s); err != nil {
+			writeError(w, http.StatusBadRequest, fieldErr("until", ErrBadRange, err))
+			return
+		}
+		if until.Before(since) {
+			writeError(w, http.StatusBadRequest, fieldErr("until", ErrBadRange, errors.New("until is before since")))
+			return
+		}
+	}
+	limit := 100
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n >= 0 {
+		limit = min(n, 10000)
+	}
+	endpoint, actor := strings.TrimSpace(q.Get("endpoint")), strings.TrimSpace(q.Get("actor"))
+
+	entries, err := readHistory(requestConfig(r), func(e HistoryEntry) bool {
+		t, err := time.Parse(time.RFC3339, e.Time)
+		return err == nil && !t.Before(since) && (until.IsZero() || !t.After(until)) &&
+			(endpoint == "" || e.Endpoint == endpoint) && (actor == "" || e.Actor == actor)
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	resp := HistoryResponse{Total: len(entries), Hours: summarizeHistory(entries, loc), Entries: make([]HistoryEntry, 0, min(limit, len(entries)))}
+	for i := len(entries) - 1; i >= 0 && len(resp.Entries) < limit; i-- {
+		e := entries[i]
+		if t, err := time.Parse(time.RFC3339, e.Time); err == nil {
+			e.Time = t.In(loc).Format(time.RFC3339)
+		}
+		resp.Entries = append(resp.Entries, e)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
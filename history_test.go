@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+func TestHistoryRecordsCharts(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+	setConfig(&Config{HistoryFile: filepath.Join(t.TempDir(), "history.jsonl")})
+
+	chart := withHistory("/api/rate", func(w http.ResponseWriter, r *http.Request) {
+		historyFor(r).noteChart(&ResponseMeta{From: "2025-10-01", To: "2025-10-02", Files: []string{"a.csv", "b.csv"}, Rows: 720, Cached: true},
+			[]*gymdata.Series{{}, {}})
+		w.Write([]byte("{}"))
+	})
+	failing := withHistory("/generate-data-range", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusBadRequest, fieldErr("from", ErrBadRange, errors.New("bad from")))
+	})
+	r := httptest.NewRequest("GET", "/api/rate", nil)
+	r.Header.Set("X-Client-ID", "dashboard-1234")
+	chart(httptest.NewRecorder(), r)
+	failing(httptest.NewRecorder(), httptest.NewRequest("POST", "/generate-data-range", nil))
+	historyFor(httptest.NewRequest("GET", "/", nil)).noteChart(&ResponseMeta{}, nil) // unrecorded: no-op
+
+	w := httptest.NewRecorder()
+	historyHandler(w, httptest.NewRequest("GET", "/api/history", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var resp HistoryResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Total != 2 || len(resp.Entries) != 2 {
+		t.Fatalf("got %+v, want 2 entries", resp)
+	}
+	failed, served := resp.Entries[0], resp.Entries[1]
+	if failed.Endpoint != "/generate-data-range" || failed.Status != http.StatusBadRequest {
+		t.Errorf("newest = %+v, want the failed range", failed)
+	}
+	if served.From != "2025-10-01" || served.Files != 2 || served.Rows != 720 || served.Series != 2 || !served.Cached ||
+		served.Client != "dashboard-1234" || served.Status != http.StatusOK || served.Ranges != 0 {
+		t.Errorf("chart entry = %+v", served)
+	}
+	if len(resp.Hours) == 0 || resp.Hours[len(resp.Hours)-1].Requests+resp.Hours[0].Requests < 2 {
+		t.Errorf("hours = %+v, want both requests summed", resp.Hours)
+	}
+
+	w = httptest.NewRecorder()
+	historyHandler(w, httptest.NewRequest("GET", "/api/history?endpoint=/api/rate&limit=5", nil))
+	resp = HistoryResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Total != 1 || resp.Entries[0].Endpoint != "/api/rate" {
+		t.Errorf("filtered = %+v, want only /api/rate", resp)
+	}
+
+	w = httptest.NewRecorder()
+	historyHandler(w, httptest.NewRequest("GET", "/api/history?since="+time.Now().Add(time.Hour).Format(time.RFC3339), nil))
+	resp = HistoryResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Total != 0 {
+		t.Errorf("since the future = %+v, want none", resp)
+	}
+}
+
+func TestHistoryBulkSumsRanges(t *testing.T) {
+	e := &HistoryEntry{}
+	e.noteChart(&ResponseMeta{From: "2025-10-03", To: "2025-10-04", Rows: 10, Cached: true}, nil)
+	e.noteChart(&ResponseMeta{From: "2025-10-01", To: "2025-10-02", Rows: 5}, nil)
+	if e.Ranges != 2 || e.From != "2025-10-01" || e.To != "2025-10-04" || e.Rows != 15 || e.Cached {
+		t.Errorf("got %+v, want both ranges spanned and summed", e)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// hookReadings pipes readings through cfg's INGEST_HOOK, if any, and
+// returns what it prints: the command gets them as a JSON array on stdin
+// and writes the array to queue on stdout, changed, added to or with some
+// left out, so a site's quirks (a renamed gym, counts off by a fixed
+// amount, a second feed's format) are handled without changing the server.
+// It runs in the data directory, and a run that fails, times out or prints
+// anything but an array of readings fails the whole batch.
+func hookReadings(ctx context.Context, cfg *Config, readings []Reading) ([]Reading, error) {
+	if len(cfg.IngestHook) == 0 {
+		return readings, nil
+	}
+	in, err := json.Marshal(readings)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.IngestHookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, cfg.IngestHook[0], cfg.IngestHook[1:]...)
+	cmd.Dir = cfg.DataDir
+	cmd.Stdin = bytes.NewReader(in)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	cmd.WaitDelay = time.Second // for a child that outlives the hook holding its output
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", cfg.IngestHookTimeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, fmt.Errorf("INGEST_HOOK: %v", err)
+	}
+	var out []Reading
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return nil, fmt.Errorf("INGEST_HOOK: output is not an array of readings: %v", err)
+	}
+	return out, nil
+}
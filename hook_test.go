@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHookReadings(t *testing.T) {
+	dir := t.TempDir()
+	script := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	readings := []Reading{{Timestamp: "2025-10-01 10:00:00", LocationName: "Hipo", UserCount: "12"}}
+
+	cfg := &Config{DataDir: dir, IngestHookTimeout: 5 * time.Second}
+	if got, err := hookReadings(context.Background(), cfg, readings); err != nil || len(got) != 1 {
+		t.Fatalf("no hook = %+v, %v; want the readings as they are", got, err)
+	}
+
+	cfg.IngestHook = []string{script("rename.sh", `sed 's/"Hipo"/"Hipodroom"/'`)}
+	got, err := hookReadings(context.Background(), cfg, readings)
+	if err != nil || len(got) != 1 || got[0].LocationName != "Hipodroom" || got[0].UserCount != "12" {
+		t.Errorf("renamed = %+v, %v", got, err)
+	}
+	cfg.IngestHook = []string{script("drop.sh", "cat >/dev/null; echo '[]'")}
+	if got, err := hookReadings(context.Background(), cfg, readings); err != nil || len(got) != 0 {
+		t.Errorf("dropped = %+v, %v; want none", got, err)
+	}
+
+	cfg.IngestHook = []string{script("fail.sh", "echo 'no such gym' >&2; exit 3")}
+	if _, err := hookReadings(context.Background(), cfg, readings); err == nil || !strings.Contains(err.Error(), "no such gym") {
+		t.Errorf("failing hook: %v, want its stderr", err)
+	}
+	cfg.IngestHook = []string{script("garbage.sh", "echo ok")}
+	if _, err := hookReadings(context.Background(), cfg, readings); err == nil {
+		t.Error("non-JSON output: want an error")
+	}
+	cfg.IngestHook, cfg.IngestHookTimeout = []string{script("slow.sh", "sleep 5")}, 100*time.Millisecond
+	if _, err := hookReadings(context.Background(), cfg, readings); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("slow hook: %v, want a timeout", err)
+	}
+}
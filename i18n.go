@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // any IANA zone a client asks for, even on hosts without zoneinfo
+)
+
+// weekdayNames holds Monday-first short and full weekday names. Languages the
+// table doesn't know fall back to English.
+var weekdayNames = map[string][2][7]string{
+	"en": {
+		{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"},
+		{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"},
+	},
+	"et": {
+		{"E", "T", "K", "N", "R", "L", "P"},
+		{"esmaspäev", "teisipäev", "kolmapäev", "neljapäev", "reede", "laupäev", "pühapäev"},
+	},
+	"ru": {
+		{"Пн", "Вт", "Ср", "Чт", "Пт", "Сб", "Вс"},
+		{"понедельник", "вторник", "среда", "четверг", "пятница", "суббота", "воскресенье"},
+	},
+	"fi": {
+		{"ma", "ti", "ke", "to", "pe", "la", "su"},
+		{"maanantai", "tiistai", "keskiviikko", "torstai", "perjantai", "lauantai", "sunnuntai"},
+	},
+	"lv": {
+		{"Pr", "Ot", "Tr", "Ce", "Pk", "Se", "Sv"},
+		{"pirmdiena", "otrdiena", "trešdiena", "ceturtdiena", "piektdiena", "sestdiena", "svētdiena"},
+	},
+	"lt": {
+		{"Pr", "An", "Tr", "Ke", "Pn", "Še", "Se"},
+		{"pirmadienis", "antradienis", "trečiadienis", "ketvirtadienis", "penktadienis", "šeštadienis", "sekmadienis"},
+	},
+	"de": {
+		{"Mo", "Di", "Mi", "Do", "Fr", "Sa", "So"},
+		{"Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag", "Sonntag"},
+	},
+	"fr": {
+		{"lun.", "mar.", "mer.", "jeu.", "ven.", "sam.", "dim."},
+		{"lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi", "dimanche"},
+	},
+	"es": {
+		{"lun", "mar", "mié", "jue", "vie", "sáb", "dom"},
+		{"lunes", "martes", "miércoles", "jueves", "viernes", "sábado", "domingo"},
+	},
+	"sv": {
+		{"mån", "tis", "ons", "tor", "fre", "lör", "sön"},
+		{"måndag", "tisdag", "onsdag", "torsdag", "fredag", "lördag", "söndag"},
+	},
+}
+
+// langNames returns the short and full weekday names for a language tag such
+// as "et" or "et-EE".
+func langNames(lang string) (short, full [7]string) {
+	primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(lang)), "-")
+	primary, _, _ = strings.Cut(primary, "_")
+	names, ok := weekdayNames[primary]
+	if !ok {
+		names = weekdayNames["en"]
+	}
+	return names[0], names[1]
+}
+
+// localWeekday names t's weekday in lang.
+func localWeekday(t time.Time, lang string) string {
+	_, full := langNames(lang)
+	return full[(int(t.Weekday())+6)%7]
+}
+
+// requestZone resolves a client-supplied IANA zone name, defaulting to def
+// when empty.
+func requestZone(name string, def *time.Location) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return def, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+	return loc, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLangNames(t *testing.T) {
+	short, full := langNames("et-EE")
+	if short[0] != "E" || full[6] != "pühapäev" {
+		t.Errorf("et: got %q / %q", short[0], full[6])
+	}
+	short, _ = langNames("xx")
+	if short[0] != "Mon" {
+		t.Errorf("unknown language: got %q, want English fallback", short[0])
+	}
+	sunday := time.Date(2025, 10, 5, 12, 0, 0, 0, time.UTC)
+	if got := localWeekday(sunday, "de"); got != "Sonntag" {
+		t.Errorf("localWeekday = %q, want Sonntag", got)
+	}
+}
+
+func TestRequestZone(t *testing.T) {
+	ny, err := requestZone("America/New_York", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := testSeries(t, "2025-10-01T18:00:00+03:00", 5)
+	out := decodeSeries(t, in, ny)
+	if got, want := out[0].Data[0].X, "2025-10-01T11:00:00-04:00"; got != want {
+		t.Errorf("X = %q, want %q", got, want)
+	}
+
+	if _, err := requestZone("Mars/Olympus", nil); err == nil {
+		t.Error("expected error for unknown zone")
+	}
+	if loc, _ := requestZone("", time.UTC); loc != time.UTC {
+		t.Error("empty zone should return the default")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// quietWindow is a run of consecutive hours [start, end) expected to stay
+// under the threshold.
+type quietWindow struct {
+	start, end int
+	predicted  float64 // mean of the hourly averages
+	samples    int     // of the thinnest hour
+}
+
+// quietWindows merges the hours of one weekday row whose average is below
+// `below` into windows. As in rankQuietWindows, hours under 25% of the day's
+// peak count as closed rather than quiet, and hours without samples break a
+// window.
+func quietWindows(row [24]busyCell, below float64, earliest, latest int) []quietWindow {
+	var avg [24]float64
+	peak := 0.0
+	for h := 0; h < 24; h++ {
+		if row[h].count > 0 {
+			avg[h] = row[h].sum / float64(row[h].count)
+			if avg[h] > peak {
+				peak = avg[h]
+			}
+		}
+	}
+	var out []quietWindow
+	var cur *quietWindow
+	sum := 0.0
+	for h := earliest; h <= latest; h++ {
+		quiet := h < latest && row[h].count > 0 && avg[h] >= peak*0.25 && avg[h] < below
+		if quiet && cur == nil {
+			out = append(out, quietWindow{start: h, samples: row[h].count})
+			cur, sum = &out[len(out)-1], 0
+		}
+		if quiet {
+			sum += avg[h]
+			cur.samples = min(cur.samples, row[h].count)
+			continue
+		}
+		if cur != nil {
+			cur.end = h
+			cur.predicted = math.Round(sum/float64(cur.end-cur.start)*10) / 10
+			cur = nil
+		}
+	}
+	return out
+}
+
+// icalText escapes a TEXT value (RFC 5545 §3.3.11).
+func icalText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// icalLine folds a content line to 75 octets, without splitting a UTF-8
+// sequence, and ends it with CRLF.
+func icalLine(b *strings.Builder, line string) {
+	for len(line) > 75 {
+		cut := 75
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// buildQuietCalendar lays the weekday windows over the days from start, one
+// event per window. Times are UTC so no VTIMEZONE is needed; UIDs are stable
+// per location, day and hour so a refreshed feed updates events in place.
+func buildQuietCalendar(location string, grid *[7][24]busyCell, below float64, earliest, latest int, start time.Time, days int, now time.Time) string {
+	var b strings.Builder
+	icalLine(&b, "BEGIN:VCALENDAR")
+	icalLine(&b, "VERSION:2.0")
+	icalLine(&b, "PRODID:-//ronimis//gym quiet hours//EN")
+	icalLine(&b, "CALSCALE:GREGORIAN")
+	icalLine(&b, "X-WR-CALNAME:"+icalText(location+" quiet hours"))
+	stamp := now.UTC().Format("20060102T150405Z")
+	for d := 0; d < days; d++ {
+		day := start.AddDate(0, 0, d)
+		for _, w := range quietWindows(grid[(int(day.Weekday())+6)%7], below, earliest, latest) {
+			from := time.Date(day.Year(), day.Month(), day.Day(), w.start, 0, 0, 0, day.Location())
+			to := time.Date(day.Year(), day.Month(), day.Day(), w.end, 0, 0, 0, day.Location())
+			icalLine(&b, "BEGIN:VEVENT")
+			icalLine(&b, fmt.Sprintf("UID:quiet-%s-%s-%02d@ronimis", haSlug(location), day.Format("20060102"), w.start))
+			icalLine(&b, "DTSTAMP:"+stamp)
+			icalLine(&b, "DTSTART:"+from.UTC().Format("20060102T150405Z"))
+			icalLine(&b, "DTEND:"+to.UTC().Format("20060102T150405Z"))
+			icalLine(&b, "SUMMARY:"+icalText(fmt.Sprintf("%s quiet (< %s people expected)", location, strconv.FormatFloat(below, 'f', -1, 64))))
+			icalLine(&b, "DESCRIPTION:"+icalText(fmt.Sprintf("Typically %.1f people on %ss at these hours (%d samples in the thinnest hour).", w.predicted, day.Weekday(), w.samples)))
+			icalLine(&b, "TRANSP:TRANSPARENT")
+			icalLine(&b, "END:VEVENT")
+		}
+	}
+	icalLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// quietCalendarHandler serves a location's predicted quiet hours as an
+// iCalendar feed, from the weekday × hour averages. Calendar apps can't send
+// headers, so with API keys on subscribe with ?key=.
+//
+//	GET /api/quiet.ics?location=NAME[&below=30][&days=14][&between=HH-HH][&from=YYYY-MM-DD&to=YYYY-MM-DD]
+func quietCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	fail := func(status int, msg string) {
+		writeError(w, status, errors.New(msg))
+	}
+
+	tallinn := gymdata.Tallinn()
+	q := r.URL.Query()
+	location := strings.TrimSpace(q.Get("location"))
+	if location == "" {
+		writeError(w, http.StatusBadRequest, fieldErr("location", nil, errors.New("location is required")))
+		return
+	}
+	below := 30.0
+	if s := q.Get("below"); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v <= 0 {
+			fail(http.StatusBadRequest, "invalid below, want a positive number of people")
+			return
+		}
+		below = v
+	}
+	days := 14
+	if n, err := strconv.Atoi(q.Get("days")); err == nil && n > 0 {
+		days = min(n, 60)
+	}
+	earliest, latest := 0, 24
+	if s := strings.TrimSpace(q.Get("between")); s != "" {
+		var ok bool
+		if earliest, latest, ok = parseHourRange(s); !ok {
+			fail(http.StatusBadRequest, "invalid between, want HH-HH")
+			return
+		}
+	}
+	var fromPtr, toPtr *time.Time
+	if t, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(q.Get("from")), tallinn); err == nil {
+		fromPtr = &t
+	}
+	if t, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(q.Get("to")), tallinn); err == nil {
+		end := t.AddDate(0, 0, 1)
+		toPtr = &end
+	}
+
+	acc, _, _, err := collectBusyness(requestConfig(r), tallinn, fromPtr, toPtr, nil)
+	if err != nil {
+		fail(http.StatusInternalServerError, err.Error())
+		return
+	}
+	names := make([]string, 0, len(acc))
+	for n := range acc {
+		if strings.EqualFold(n, location) {
+			names = append(names, n)
+		}
+	}
+	if len(names) == 0 {
+		writeError(w, http.StatusNotFound, withKind(ErrNoData, fmt.Errorf("no data for location %q", location)))
+		return
+	}
+	sort.Strings(names)
+
+	now := time.Now().In(tallinn)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, tallinn)
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s-quiet.ics\"", haSlug(names[0])))
+	fmt.Fprint(w, buildQuietCalendar(names[0], acc[names[0]], below, earliest, latest, today, days, now))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuietWindows(t *testing.T) {
+	// 06:00 is below 25% of the 40-person peak (closed); 14:00 has no samples.
+	row := gridRow(map[int]float64{6: 2, 9: 20, 10: 12, 11: 15, 12: 35, 13: 25, 15: 20, 17: 40, 21: 11, 22: 10}, 30)
+	row[10].count, row[10].sum = 8, 12*8
+	got := quietWindows(row, 30, 0, 24)
+	want := []quietWindow{{9, 12, 15.7, 8}, {13, 14, 25, 30}, {15, 16, 20, 30}, {21, 23, 10.5, 30}}
+	if len(got) != len(want) {
+		t.Fatalf("windows = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("window %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if got := quietWindows(row, 30, 10, 22); len(got) != 4 || got[0].start != 10 || got[3].end != 22 {
+		t.Errorf("bounded windows = %+v, want 10:00 start and 22:00 end", got)
+	}
+}
+
+func TestBuildQuietCalendar(t *testing.T) {
+	tallinn := loadTallinn(t)
+	var grid [7][24]busyCell
+	grid[2] = gridRow(map[int]float64{9: 12, 10: 11, 17: 40}, 20) // Wednesday
+	start := time.Date(2025, 10, 1, 0, 0, 0, 0, tallinn)          // a Wednesday, EEST
+	now := time.Date(2025, 9, 30, 12, 0, 0, 0, time.UTC)
+
+	cal := buildQuietCalendar("Hipodroom; Pool, Spa", &grid, 30, 0, 24, start, 8, now)
+	for _, line := range strings.Split(strings.TrimSuffix(cal, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("unfolded line (%d octets): %q", len(line), line)
+		}
+	}
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"UID:quiet-hipodroom_pool_spa-20251001-09@ronimis\r\n",
+		"DTSTART:20251001T060000Z\r\nDTEND:20251001T080000Z\r\n",
+		"DTSTART:20251008T060000Z\r\n",
+		`SUMMARY:Hipodroom\; Pool\, Spa quiet (< 30 people expected)`,
+		"DTSTAMP:20250930T120000Z\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(cal, want) {
+			t.Errorf("calendar missing %q:\n%s", want, cal)
+		}
+	}
+	if n := strings.Count(cal, "BEGIN:VEVENT"); n != 2 {
+		t.Errorf("events = %d, want one per Wednesday", n)
+	}
+}
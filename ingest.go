@@ -0,0 +1,660 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+const (
+	ingestBatch    = 500              // commit early once this many are queued
+	ingestInterval = 5 * time.Second  // otherwise commit on this tick
+	ingestMaxAhead = 5 * time.Minute  // clock skew allowed into the future
+	ingestWAL      = "gym-ingest.wal" // queued readings, replayed on startup
+	ingestRejected = "gym-ingest-rejected.jsonl"
+)
+
+// collectorHeader is the column order the collector writes new files in.
+var collectorHeader = []string{"timestamp", "timezone", "location_id", "location_name", "user_count", "status", "response"}
+
+// Reading is one submitted row, named as the collector's CSV columns.
+type Reading struct {
+	Timestamp    string     `json:"timestamp"`
+	Timezone     string     `json:"timezone,omitempty"`
+	LocationID   flexString `json:"location_id,omitempty"`
+	LocationName string     `json:"location_name"`
+	UserCount    flexString `json:"user_count,omitempty"`
+	Status       string     `json:"status,omitempty"`
+	Response     string     `json:"response,omitempty"`
+}
+
+// flexString reads a JSON string or number, as collectors send counts and
+// IDs either way. It is kept as text so a bad count can be dead-lettered.
+type flexString string
+
+func (s *flexString) UnmarshalJSON(b []byte) error {
+	var str string
+	if json.Unmarshal(b, &str) == nil {
+		*s = flexString(str)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	*s = flexString(n)
+	return nil
+}
+
+// RejectedReading is a dead-letter entry: a reading that failed validation.
+type RejectedReading struct {
+	Time    string  `json:"time"`
+	Reason  string  `json:"reason"`
+	Reading Reading `json:"reading"`
+}
+
+type IngestStatus struct {
+	Pending    int    `json:"pending"`
+	Committed  int    `json:"committed"`
+	Duplicates int    `json:"duplicates"`
+	Rejected   int    `json:"rejected"`
+	LastCommit string `json:"lastCommit,omitempty"`
+	LastError  string `json:"lastError,omitempty"`
+}
+
+// ingestor is one data directory's queue. Readings are in the write-ahead log
+// from the moment they are accepted until a commit has appended them to their
+// day's CSV (or dead-lettered them), so a crash in between loses nothing.
+type ingestor struct {
+	mu      sync.Mutex
+	cfg     *Config
+	pending []Reading
+	seen    map[string]map[string]bool // day file -> stored "unix|location" keys
+	status  IngestStatus
+	wake    chan struct{}
+}
+
+var (
+	ingestorsMu sync.Mutex
+	ingestors   = map[string]*ingestor{}
+)
+
+// ingestorFor returns the queue for cfg's data directory, replaying its
+// write-ahead log and starting its worker on first use.
+func ingestorFor(cfg *Config) *ingestor {
+	ingestorsMu.Lock()
+	defer ingestorsMu.Unlock()
+	if in := ingestors[cfg.DataDir]; in != nil {
+		in.mu.Lock()
+		in.cfg = cfg // follow reloads
+		in.mu.Unlock()
+		return in
+	}
+	in := &ingestor{cfg: cfg, seen: map[string]map[string]bool{}, wake: make(chan struct{}, 1)}
+	pending, err := readWAL(cfg)
+	if err != nil {
+		log.Printf("ingest: %v", err)
+	}
+	in.pending = pending
+	ingestors[cfg.DataDir] = in
+	go in.run()
+	return in
+}
+
+// resumeIngest replays any write-ahead log left by a previous run, for the
+// base directory and every tenant's.
+func resumeIngest(c *Config) {
+	configs := []*Config{c}
+	for _, t := range c.Tenants {
+		configs = append(configs, t)
+	}
+	for _, cfg := range configs {
+		if _, err := os.Stat(cfg.path(ingestWAL)); err == nil {
+			ingestorFor(cfg)
+		}
+	}
+}
+
+func readWAL(cfg *Config) ([]Reading, error) {
+	data, err := os.ReadFile(cfg.path(ingestWAL))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []Reading
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var rd Reading
+		if len(line) > 0 && json.Unmarshal(line, &rd) == nil {
+			out = append(out, rd) // a torn last line is dropped
+		}
+	}
+	return out, nil
+}
+
+// writeWAL replaces the log with the readings still pending.
+func writeWAL(cfg *Config, readings []Reading) error {
+	path := cfg.path(ingestWAL)
+	if len(readings) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, rd := range readings {
+		line, _ := json.Marshal(rd)
+		buf.Write(append(line, '\n'))
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+func (in *ingestor) run() {
+	tick := time.NewTicker(ingestInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-in.wake:
+		}
+		in.commit(time.Now())
+	}
+}
+
+// submit logs the readings durably and queues them for the next commit.
+func (in *ingestor) submit(readings []Reading) (IngestStatus, error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, rd := range readings {
+		line, err := json.Marshal(rd)
+		if err != nil {
+			return in.status, err
+		}
+		buf.Write(append(line, '\n'))
+	}
+	file, err := os.OpenFile(in.cfg.path(ingestWAL), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return in.status, err
+	}
+	_, err = file.Write(buf.Bytes())
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return in.status, err
+	}
+
+	in.pending = append(in.pending, readings...)
+	if len(in.pending) >= ingestBatch {
+		select {
+		case in.wake <- struct{}{}:
+		default:
+		}
+	}
+	in.status.Pending = len(in.pending)
+	return in.status, nil
+}
+
+// ingestRow is a validated reading ready to append.
+type ingestRow struct {
+	reading Reading
+	file    string
+	key     string
+	values  map[string]string // canonical column -> cell
+}
+
+// checkReading validates rd and normalises it to the collector's form: a
+// Tallinn wall-clock timestamp in layout with its EET/EEST zone.
+func checkReading(cfg *Config, rd Reading, tallinn *time.Location, now time.Time) (ingestRow, error) {
+	name := strings.TrimSpace(rd.LocationName)
+	if name == "" {
+		return ingestRow{}, fmt.Errorf("location_name is required")
+	}
+	if strings.ContainsAny(name, "\r\n") {
+		return ingestRow{}, fmt.Errorf("location_name has a line break")
+	}
+	layout := cfg.CSVTimeLayout
+	if layout == "" {
+		layout = gymdata.DefaultTimeLayout
+	}
+	t, ok := gymdata.LocalTime(strings.TrimSpace(rd.Timestamp), strings.TrimSpace(rd.Timezone), layout, tallinn)
+	if !ok {
+		parsed, err := time.Parse(time.RFC3339, strings.TrimSpace(rd.Timestamp))
+		if err != nil {
+			return ingestRow{}, fmt.Errorf("unreadable timestamp %q", rd.Timestamp)
+		}
+		t = parsed.In(tallinn)
+	}
+	if t.After(now.Add(ingestMaxAhead)) {
+		return ingestRow{}, fmt.Errorf("timestamp %s is in the future", t.Format(time.RFC3339))
+	}
+	status := strings.TrimSpace(rd.Status)
+	if status == "" {
+		status = "success"
+	}
+	count := strings.TrimSpace(string(rd.UserCount))
+	if status == "success" {
+		if n, err := strconv.Atoi(count); err != nil || n < 0 {
+			return ingestRow{}, fmt.Errorf("user_count %q is not a whole number of people", count)
+		}
+	} else if count == "" {
+		count = "error" // what the collector writes for failed polls
+	}
+
+	day := "gym-stats-" + t.Format("20060102") + ".csv"
+	file := cfg.path(day)
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		if _, err := os.Stat(file + ".gz"); err == nil {
+			return ingestRow{}, fmt.Errorf("%s is already archived", day+".gz")
+		}
+	}
+	return ingestRow{
+		reading: rd,
+		file:    file,
+		key:     strconv.FormatInt(t.Unix(), 10) + "|" + name,
+		values: map[string]string{
+			"timestamp":     t.Format(layout),
+			"timezone":      t.Format("MST"),
+			"location_id":   strings.TrimSpace(string(rd.LocationID)),
+			"location_name": name,
+			"user_count":    count,
+			"status":        status,
+			"response":      rd.Response,
+		},
+	}, nil
+}
+
+// loadSeen reads the keys already stored in a day file, so a resent reading
+// is recognised as a duplicate.
+func loadSeen(cfg *Config, file string, tallinn *time.Location) map[string]bool {
+	seen := map[string]bool{}
+	f, err := os.Open(file)
+	if err != nil {
+		return seen
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	headers, err := reader.Read()
+	if err != nil {
+		return seen
+	}
+	idx := map[string]int{"timestamp": -1, "timezone": -1, "location_name": -1}
+	for i, h := range gymdata.CanonicalHeaders(headers, cfg.CSVColumns) {
+		if _, ok := idx[h]; ok {
+			idx[h] = i
+		}
+	}
+	if idx["timestamp"] < 0 || idx["location_name"] < 0 {
+		return seen
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil || len(record) <= max(idx["timestamp"], idx["location_name"]) {
+			continue
+		}
+		tz := ""
+		if i := idx["timezone"]; i >= 0 && i < len(record) {
+			tz = record[i]
+		}
+		if t, ok := gymdata.LocalTime(record[idx["timestamp"]], tz, cfg.CSVTimeLayout, tallinn); ok {
+			seen[strconv.FormatInt(t.Unix(), 10)+"|"+strings.TrimSpace(record[idx["location_name"]])] = true
+		}
+	}
+	return seen
+}
+
+// appendRows adds rows to a day file in that file's own column order,
+// creating it with the collector's header (renamed per CSV_COLUMNS) if new.
+func appendRows(cfg *Config, file string, rows []ingestRow) error {
+	var headers []string
+	if f, err := os.Open(file); err == nil {
+		reader := csv.NewReader(f)
+		reader.FieldsPerRecord = -1
+		headers, err = reader.Read()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: unreadable header: %v", file, err)
+		}
+		headers = gymdata.CanonicalHeaders(headers, cfg.CSVColumns)
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	if headers == nil {
+		headers = collectorHeader
+		mapped := map[string]string{}
+		for header, canonical := range cfg.CSVColumns {
+			mapped[canonical] = header
+		}
+		out := make([]string, len(headers))
+		for i, h := range headers {
+			out[i] = h
+			if m, ok := mapped[h]; ok {
+				out[i] = m
+			}
+		}
+		w.Write(out)
+	}
+	record := make([]string, len(headers))
+	for _, row := range rows {
+		for i, h := range headers {
+			record[i] = row.values[h]
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	err = w.Error()
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// commit validates and dedups the queue, appends what passes to the day
+// files, dead-letters what fails, and trims the write-ahead log to whatever
+// could not be written (kept for the next tick).
+func (in *ingestor) commit(now time.Time) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if len(in.pending) == 0 {
+		return
+	}
+	cfg := in.cfg
+	tallinn := gymdata.Tallinn()
+
+	var rejected []RejectedReading
+	byFile := map[string][]ingestRow{}
+	var order []string
+	duplicates := 0
+	for _, rd := range in.pending {
+		row, err := checkReading(cfg, rd, tallinn, now)
+		if err != nil {
+			rejected = append(rejected, RejectedReading{Time: now.UTC().Format(time.RFC3339), Reason: err.Error(), Reading: rd})
+			continue
+		}
+		seen := in.seen[row.file]
+		if seen == nil {
+			if len(in.seen) >= 8 {
+				in.seen = map[string]map[string]bool{} // only recent days are resent
+			}
+			seen = loadSeen(cfg, row.file, tallinn)
+			in.seen[row.file] = seen
+		}
+		if seen[row.key] {
+			duplicates++
+			continue
+		}
+		seen[row.key] = true
+		if byFile[row.file] == nil {
+			order = append(order, row.file)
+		}
+		byFile[row.file] = append(byFile[row.file], row)
+	}
+
+	var retry []Reading
+	committed := 0
+	in.status.LastError = ""
+	for _, file := range order {
+		rows := byFile[file]
+		if err := appendRows(cfg, file, rows); err != nil {
+			in.status.LastError = err.Error()
+			log.Printf("ingest: %v", err)
+			delete(in.seen, file) // unknown how much landed; re-read before dedup
+			for _, row := range rows {
+				retry = append(retry, row.reading)
+			}
+			continue
+		}
+		committed += len(rows)
+	}
+	if len(rejected) > 0 {
+		if err := appendRejected(cfg, rejected); err != nil {
+			log.Printf("ingest: %v", err)
+		}
+	}
+	if err := writeWAL(cfg, retry); err != nil {
+		log.Printf("ingest: %v", err)
+	}
+
+	in.pending = retry
+	in.status.Pending = len(retry)
+	in.status.Committed += committed
+	in.status.Duplicates += duplicates
+	in.status.Rejected += len(rejected)
+	in.status.LastCommit = now.UTC().Format(time.RFC3339)
+}
+
+var rejectedMu sync.Mutex
+
+func appendRejected(cfg *Config, entries []RejectedReading) error {
+	rejectedMu.Lock()
+	defer rejectedMu.Unlock()
+	file, err := os.OpenFile(cfg.path(ingestRejected), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := file.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRejected returns up to limit dead-letter entries, newest first.
+func readRejected(cfg *Config, limit int) ([]RejectedReading, error) {
+	rejectedMu.Lock()
+	defer rejectedMu.Unlock()
+	file, err := os.Open(cfg.path(ingestRejected))
+	if os.IsNotExist(err) {
+		return []RejectedReading{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var all []RejectedReading
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e RejectedReading
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			all = append(all, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	out := make([]RejectedReading, 0, min(limit, len(all)))
+	for i := len(all) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, all[i])
+	}
+	return out, nil
+}
+
+// readingsFromCSV reads an uploaded CSV, header included, in the collector's
+// layout or the one CSV_COLUMNS maps.
+func readingsFromCSV(r io.Reader, columns map[string]string) ([]Reading, error) {
+	reader := csv.NewReader(r)
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	headers, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("missing header: %v", err)
+	}
+	idx := map[string]int{}
+	for i, h := range gymdata.CanonicalHeaders(headers, columns) {
+		if h != "" {
+			idx[h] = i
+		}
+	}
+	if _, ok := idx["timestamp"]; !ok {
+		return nil, fmt.Errorf("no timestamp column")
+	}
+	var out []Reading
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		cell := func(name string) string {
+			if i, ok := idx[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		out = append(out, Reading{
+			Timestamp:    cell("timestamp"),
+			Timezone:     cell("timezone"),
+			LocationID:   flexString(strings.TrimSpace(cell("location_id"))),
+			LocationName: cell("location_name"),
+			UserCount:    flexString(strings.TrimSpace(cell("user_count"))),
+			Status:       cell("status"),
+			Response:     cell("response"),
+		})
+	}
+	return out, nil
+}
+
+// ingestHandler queues readings, as INGEST_HOOK leaves them, for the next
+// batch commit and reports the queue. A 202 means the readings are logged,
+// not yet validated: rejects show up at /api/ingest/rejected.
+//
+//	POST /api/ingest  [reading, ...] | reading | text/csv with a header row
+//	GET  /api/ingest
+func ingestHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, POST, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	fail := func(status int, err error) {
+		writeError(w, status, err)
+	}
+	cfg := requestConfig(r)
+	in := ingestorFor(cfg)
+
+	switch r.Method {
+	case "GET":
+		in.mu.Lock()
+		status := in.status
+		in.mu.Unlock()
+		json.NewEncoder(w).Encode(status)
+		return
+	case "POST":
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if cfg.CSVSource != "" {
+		// Ingest appends to the local daily files, which this server doesn't read.
+		fail(http.StatusConflict, fmt.Errorf("CSV files are read from %s; upload readings there instead", cfg.CSVSource))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 32<<20))
+	if err != nil {
+		fail(http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	var readings []Reading
+	switch trimmed := bytes.TrimSpace(body); {
+	case strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv"):
+		readings, err = readingsFromCSV(bytes.NewReader(body), cfg.CSVColumns)
+	case len(trimmed) > 0 && trimmed[0] == '[':
+		err = json.Unmarshal(trimmed, &readings)
+	default:
+		var one Reading
+		if err = json.Unmarshal(trimmed, &one); err == nil {
+			readings = []Reading{one}
+		}
+	}
+	if err != nil {
+		fail(http.StatusBadRequest, fmt.Errorf("invalid readings: %v", err))
+		return
+	}
+	if len(readings) == 0 {
+		fail(http.StatusBadRequest, fmt.Errorf("no readings"))
+		return
+	}
+
+	params := map[string]any{"readings": len(readings)}
+	if readings, err = hookReadings(r.Context(), cfg, readings); err != nil {
+		recordAudit(r, "ingest", params, err)
+		fail(http.StatusBadGateway, err)
+		return
+	}
+	status, err := in.submit(readings)
+	recordAudit(r, "ingest", params, err)
+	if err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]any{"queued": len(readings), "status": status})
+}
+
+// ingestRejectedHandler lists dead-lettered readings, newest first.
+//
+//	GET /api/ingest/rejected[?limit=N]
+func ingestRejectedHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	limit := 100
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = min(n, 10000)
+	}
+	entries, err := readRejected(requestConfig(r), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"rejected": entries})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+func TestCheckReading(t *testing.T) {
+	tallinn := loadTallinn(t)
+	dir := t.TempDir()
+	cfg := &Config{DataDir: dir}
+	now := time.Date(2025, 10, 1, 12, 0, 0, 0, tallinn)
+
+	row, err := checkReading(cfg, Reading{Timestamp: "2025-10-01T08:00:00Z", LocationName: " Hipodroom ", UserCount: "12"}, tallinn, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := row.values; v["timestamp"] != "2025-10-01 11:00:00" || v["timezone"] != "EEST" || v["status"] != "success" || v["location_name"] != "Hipodroom" {
+		t.Errorf("RFC 3339 reading = %v, want Tallinn wall clock", v)
+	}
+	if row.file != filepath.Join(dir, "gym-stats-20251001.csv") {
+		t.Errorf("file = %s", row.file)
+	}
+	if row, err := checkReading(cfg, Reading{Timestamp: "2025-10-01 10:00:00", Timezone: "EEST", LocationName: "T1", Status: "500"}, tallinn, now); err != nil || row.values["user_count"] != "error" {
+		t.Errorf("failed poll = %v, %v; want user_count error", row.values, err)
+	}
+
+	writeCSV(t, dir, "gym-stats-20250930.csv.gz", "")
+	for _, bad := range []Reading{
+		{Timestamp: "2025-10-01 10:00:00", Timezone: "EEST", UserCount: "1"},
+		{Timestamp: "yesterday", LocationName: "T1", UserCount: "1"},
+		{Timestamp: "2025-10-01 13:00:00", Timezone: "EEST", LocationName: "T1", UserCount: "1"},
+		{Timestamp: "2025-10-01 10:00:00", Timezone: "EEST", LocationName: "T1", UserCount: "-3"},
+		{Timestamp: "2025-10-01 10:00:00", Timezone: "EEST", LocationName: "T1"},
+		{Timestamp: "2025-09-30 10:00:00", Timezone: "EEST", LocationName: "T1", UserCount: "1"},
+	} {
+		if _, err := checkReading(cfg, bad, tallinn, now); err == nil {
+			t.Errorf("checkReading(%+v): expected error", bad)
+		}
+	}
+}
+
+func TestIngestCommit(t *testing.T) {
+	tallinn := loadTallinn(t)
+	dir := t.TempDir()
+	day := writeCSV(t, dir, "gym-stats-20251001.csv",
+		"timestamp,timezone,location_id,location_name,user_count,status,response\n"+
+			"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,\"{}\"\n")
+	cfg := &Config{DataDir: dir}
+	in := &ingestor{cfg: cfg, seen: map[string]map[string]bool{}, wake: make(chan struct{}, 1)}
+
+	readings := []Reading{
+		{Timestamp: "2025-10-01 10:00:00", Timezone: "EEST", LocationID: "1", LocationName: "Hipodroom", UserCount: "12"}, // already stored
+		{Timestamp: "2025-10-01 10:02:00", Timezone: "EEST", LocationID: "1", LocationName: "Hipodroom", UserCount: "14", Response: `{"a":"b"}`},
+		{Timestamp: "2025-10-01T07:02:00Z", LocationID: "1", LocationName: "Hipodroom", UserCount: "14"}, // the same reading resent in UTC
+		{Timestamp: "2025-10-02 09:00:00", Timezone: "EEST", LocationName: "T1", UserCount: "3"},
+		{Timestamp: "2025-10-01 10:02:00", Timezone: "EEST", LocationName: "T1", UserCount: "many"},
+	}
+	if _, err := in.submit(readings); err != nil {
+		t.Fatal(err)
+	}
+	if replay, err := readWAL(cfg); err != nil || len(replay) != len(readings) {
+		t.Fatalf("WAL holds %d readings, %v; want %d", len(replay), err, len(readings))
+	}
+
+	in.commit(time.Date(2025, 10, 2, 12, 0, 0, 0, tallinn))
+	if s := in.status; s.Committed != 2 || s.Duplicates != 2 || s.Rejected != 1 || s.Pending != 0 {
+		t.Errorf("status = %+v", s)
+	}
+	data, _ := os.ReadFile(day)
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 3 || lines[2] != `2025-10-01 10:02:00,EEST,1,Hipodroom,14,success,"{""a"":""b""}"` {
+		t.Errorf("day file =\n%s", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "gym-stats-20251002.csv")); err != nil {
+		t.Errorf("new day file not created: %v", err)
+	}
+	if _, err := os.Stat(cfg.path(ingestWAL)); !os.IsNotExist(err) {
+		t.Errorf("WAL left after commit: %v", err)
+	}
+	rejected, err := readRejected(cfg, 10)
+	if err != nil || len(rejected) != 1 || rejected[0].Reading.LocationName != "T1" || !strings.Contains(rejected[0].Reason, "user_count") {
+		t.Errorf("rejected = %+v, %v", rejected, err)
+	}
+
+	list, _, err := gymdata.Load(cfg.format(), []string{day}, nil)
+	if err != nil || len(list) != 1 || len(list[0].Points) != 2 {
+		t.Errorf("committed rows read back as %+v, %v", list, err)
+	}
+}
+
+func TestReadingsFromCSV(t *testing.T) {
+	columns, _ := gymdata.ParseColumnMap("location_name=Gym,user_count=Visitors")
+	got, err := readingsFromCSV(strings.NewReader("Time,Gym,Visitors\n2025-10-01 10:00:00,Hipodroom,12\n"), map[string]string{"Time": "timestamp", "Gym": columns["Gym"], "Visitors": columns["Visitors"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].LocationName != "Hipodroom" || got[0].UserCount != "12" || got[0].Timestamp != "2025-10-01 10:00:00" {
+		t.Errorf("got %+v", got)
+	}
+	if _, err := readingsFromCSV(strings.NewReader("a,b\n1,2\n"), nil); err == nil {
+		t.Error("expected error without a timestamp column")
+	}
+}
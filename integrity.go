@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// integrityMode is what the startup integrity check does about corrupt
+// daily files.
+type integrityMode int
+
+const (
+	integrityOff    integrityMode = iota
+	integrityWarn                 // serve, reporting them on /readyz
+	integrityStrict               // refuse to start
+)
+
+func parseIntegrityMode(s string) (integrityMode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "off":
+		return integrityOff, nil
+	case "warn":
+		return integrityWarn, nil
+	case "strict":
+		return integrityStrict, nil
+	}
+	return integrityOff, fmt.Errorf("unknown integrity mode %q (want off, warn or strict)", s)
+}
+
+// corruptFile is a daily file (or directory) the check found corrupt, and
+// why.
+type corruptFile struct {
+	file, problem string
+}
+
+// integrityReport sums up a check of every daily file.
+type integrityReport struct {
+	files, rows, unparsed, backwards int
+	corrupt                          []corruptFile
+}
+
+// integrityState is the last report, for /readyz; nil until a check is done.
+var integrityState struct {
+	sync.Mutex
+	report *integrityReport
+}
+
+// checkIntegrity reads every daily file of the configs' directories. A file
+// is corrupt if it can't be read, lacks the header the parse needs, or more
+// than maxBad percent of its rows don't parse or are dated before an
+// earlier row of their gym.
+func checkIntegrity(configs []*Config, maxBad float64) *integrityReport {
+	report := &integrityReport{}
+	for _, cfg := range configs {
+		files, err := gymdata.ListFiles(cfg.csvDir())
+		if err != nil {
+			report.corrupt = append(report.corrupt, corruptFile{cfg.csvDir(), err.Error()})
+			continue
+		}
+		for _, file := range files {
+			report.files++
+			c, err := gymdata.CheckFile(cfg.format(), file)
+			if err != nil {
+				report.corrupt = append(report.corrupt, corruptFile{file, err.Error()})
+				continue
+			}
+			report.rows += c.Rows
+			report.unparsed += c.Unparsed
+			report.backwards += c.Backwards
+			if c.Bad() > 0 && float64(c.Bad())*100 > maxBad*float64(c.Rows) {
+				problem := fmt.Sprintf("%d of %d rows bad (%d unparsed, %d out of order)", c.Bad(), c.Rows, c.Unparsed, c.Backwards)
+				report.corrupt = append(report.corrupt, corruptFile{file, problem})
+			}
+		}
+	}
+	return report
+}
+
+// logIntegrity writes the report's summary line and a line per corrupt
+// file.
+func logIntegrity(report *integrityReport, took time.Duration) {
+	log.Printf("Integrity: %d files, %d rows (%d unparsed, %d out of order), %d corrupt, in %v",
+		report.files, report.rows, report.unparsed, report.backwards, len(report.corrupt), took.Round(time.Millisecond))
+	for _, f := range report.corrupt {
+		log.Printf("Integrity: %s: %s", f.file, f.problem)
+	}
+}
+
+// startIntegrityCheck runs INTEGRITY_CHECK over the main directory and
+// every tenant's. In strict mode it checks before returning, and a corrupt
+// file is an error the server should not start with; in warn mode it checks
+// in the background and /readyz reports how many it finds.
+func startIntegrityCheck(c *Config) error {
+	if c.IntegrityCheck == integrityOff {
+		return nil
+	}
+	configs := []*Config{c}
+	for _, t := range c.Tenants {
+		configs = append(configs, t)
+	}
+	run := func() *integrityReport {
+		start := time.Now()
+		report := checkIntegrity(configs, c.IntegrityMaxBad)
+		logIntegrity(report, time.Since(start))
+		integrityState.Lock()
+		integrityState.report = report
+		integrityState.Unlock()
+		return report
+	}
+	if c.IntegrityCheck == integrityWarn {
+		go run()
+		return nil
+	}
+	if report := run(); len(report.corrupt) > 0 {
+		return fmt.Errorf("%d corrupt files (INTEGRITY_CHECK=strict)", len(report.corrupt))
+	}
+	return nil
+}
+
+// corruptFiles is how many corrupt files the last check found, for /readyz.
+func corruptFiles() int {
+	integrityState.Lock()
+	defer integrityState.Unlock()
+	if integrityState.report == nil {
+		return 0
+	}
+	return len(integrityState.report.corrupt)
+}
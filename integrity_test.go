@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIntegrityCheck(t *testing.T) {
+	dir := t.TempDir()
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	var rows strings.Builder
+	for m := 0; m < 40; m += 2 {
+		fmt.Fprintf(&rows, "2025-10-01 10:%02d:00,EEST,1,Hipodroom,12,success,\n", m)
+	}
+	for name, content := range map[string]string{
+		"gym-stats-20251001.csv": header + rows.String(),
+		// One bad row in 21 is under 5%.
+		"gym-stats-20251002.csv": header + rows.String() + "garbage\n",
+		// Three in 23 is over.
+		"gym-stats-20251003.csv": header + rows.String() + "garbage\n" + "2025-10-01 09:00:00,EEST,1,Hipodroom,12,success,\n" + "x,y\n",
+		"gym-stats-20251004.csv": "no header at all\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report := checkIntegrity([]*Config{{DataDir: dir, CSVTimeLayout: "2006-01-02 15:04:05"}}, 5)
+	if report.files != 4 || report.rows != 20+21+23 || report.unparsed != 3 || report.backwards != 1 {
+		t.Errorf("report %+v", report)
+	}
+	if len(report.corrupt) != 2 || filepath.Base(report.corrupt[0].file) != "gym-stats-20251003.csv" ||
+		report.corrupt[0].problem != "3 of 23 rows bad (2 unparsed, 1 out of order)" ||
+		filepath.Base(report.corrupt[1].file) != "gym-stats-20251004.csv" {
+		t.Errorf("corrupt %+v", report.corrupt)
+	}
+
+	defer func() {
+		integrityState.Lock()
+		integrityState.report = nil
+		integrityState.Unlock()
+	}()
+	cfg := &Config{DataDir: dir, CSVTimeLayout: "2006-01-02 15:04:05", IntegrityCheck: integrityStrict, IntegrityMaxBad: 5}
+	if err := startIntegrityCheck(cfg); err == nil || !strings.Contains(err.Error(), "2 corrupt files") {
+		t.Errorf("strict: %v", err)
+	}
+	if code, resp := readyz(t); code != http.StatusOK || !resp.Degraded || resp.CorruptFiles != 2 {
+		t.Errorf("readyz after the check: %d %+v", code, resp)
+	}
+	cfg.IntegrityMaxBad = 100
+	os.Remove(filepath.Join(dir, "gym-stats-20251004.csv"))
+	if err := startIntegrityCheck(cfg); err != nil {
+		t.Errorf("strict with nothing corrupt: %v", err)
+	}
+	if _, resp := readyz(t); resp.Degraded {
+		t.Errorf("readyz with nothing corrupt: %+v", resp)
+	}
+}
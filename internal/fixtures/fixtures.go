@@ -0,0 +1,218 @@
+// Package fixtures writes synthetic daily CSVs in the collector's format:
+// plausible weekday and weekend occupancy curves for a set of gyms, with
+// optional noise and collection gaps, day files cut at local midnight so DST
+// days come out 23 or 25 hours long. Tests load them instead of hand-written
+// rows, and the server's demo mode seeds a data directory with them.
+package fixtures
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Header is the collector's column order.
+var Header = []string{"timestamp", "timezone", "location_id", "location_name", "user_count", "status", "response"}
+
+// Location is one simulated gym; Peak is its typical weekday evening crowd.
+type Location struct {
+	ID   int
+	Name string
+	Peak float64
+}
+
+// DefaultLocations are the collector's four gyms.
+var DefaultLocations = []Location{
+	{ID: 1, Name: "Hipodroom", Peak: 70},
+	{ID: 3, Name: "T1", Peak: 45},
+	{ID: 9, Name: "Mustika", Peak: 35},
+	{ID: 10, Name: "Suur-Paala", Peak: 25},
+}
+
+// Gap is a stretch with no readings, as when the collector was down: rows
+// at or after From and before To are left out.
+type Gap struct {
+	From, To time.Time
+}
+
+// Spec describes the data to generate. The zero value is usable: one day of
+// the default gyms from 2025-10-01, every 2 minutes, without noise.
+type Spec struct {
+	Locations []Location // default DefaultLocations
+	Start     time.Time  // first day; only its date in Zone counts
+	Days      int        // default 1
+	End       time.Time  // readings after it are left out; zero writes whole days
+	Interval  time.Duration
+	Noise     float64 // standard deviation of each reading, in people
+	Gaps      []Gap
+	Zone      *time.Location // wall clock of the timestamps; default Europe/Tallinn
+	UTC       bool           // log in UTC, as the collector's oldest files did
+	Seed      uint64
+}
+
+func (s Spec) withDefaults() Spec {
+	if len(s.Locations) == 0 {
+		s.Locations = DefaultLocations
+	}
+	if s.Zone == nil {
+		s.Zone = tallinn()
+	}
+	if s.Start.IsZero() {
+		s.Start = time.Date(2025, 10, 1, 0, 0, 0, 0, s.Zone)
+	}
+	if s.Days < 1 {
+		s.Days = 1
+	}
+	if s.Interval <= 0 {
+		s.Interval = 2 * time.Minute
+	}
+	return s
+}
+
+// tallinn is the gyms' zone, with a fixed EET only for a binary without
+// tzdata.
+var tallinn = sync.OnceValue(func() *time.Location {
+	loc, err := time.LoadLocation("Europe/Tallinn")
+	if err != nil {
+		return time.FixedZone("EET", 2*3600)
+	}
+	return loc
+})
+
+// Write writes one gym-stats-YYYYMMDD.csv per day into dir and returns their
+// paths in date order.
+func (s Spec) Write(dir string) ([]string, error) {
+	s = s.withDefaults()
+	start := s.Start.In(s.Zone)
+	var files []string
+	for d := 0; d < s.Days; d++ {
+		day := time.Date(start.Year(), start.Month(), start.Day()+d, 0, 0, 0, 0, s.Zone)
+		if !s.End.IsZero() && day.After(s.End) {
+			break
+		}
+		path := filepath.Join(dir, "gym-stats-"+day.Format("20060102")+".csv")
+		f, err := os.Create(path)
+		if err != nil {
+			return files, err
+		}
+		err = s.WriteDay(f, day)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return files, fmt.Errorf("%s: %v", path, err)
+		}
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// WriteDay writes the header and every reading of the Zone day containing
+// day, from its midnight to the next.
+func (s Spec) WriteDay(w io.Writer, day time.Time) error {
+	s = s.withDefaults()
+	day = day.In(s.Zone)
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, s.Zone)
+	to := time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, s.Zone)
+	cw := csv.NewWriter(w)
+	if err := cw.Write(Header); err != nil {
+		return err
+	}
+	for at := from; at.Before(to); at = at.Add(s.Interval) {
+		if !s.End.IsZero() && at.After(s.End) {
+			break
+		}
+		if s.inGap(at) {
+			continue
+		}
+		for _, l := range s.Locations {
+			if err := cw.Write(s.Row(l, at)); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (s Spec) inGap(at time.Time) bool {
+	for _, g := range s.Gaps {
+		if !at.Before(g.From) && at.Before(g.To) {
+			return true
+		}
+	}
+	return false
+}
+
+// Row is l's reading at at as a CSV record, timestamped the way the
+// collector does: wall clock in Zone with its abbreviation, or UTC.
+func (s Spec) Row(l Location, at time.Time) []string {
+	s = s.withDefaults()
+	stamp := at.In(s.Zone)
+	if s.UTC {
+		stamp = at.UTC()
+	}
+	count := s.Count(l, at)
+	response, _ := json.Marshal(map[string]any{"location_id": l.ID, "location_name": l.Name, "total": count})
+	return []string{
+		stamp.Format("2006-01-02 15:04:05"),
+		stamp.Format("MST"),
+		strconv.Itoa(l.ID),
+		l.Name,
+		strconv.Itoa(count),
+		"success",
+		string(response),
+	}
+}
+
+// Count is l's simulated headcount at at. The noise is seeded by Seed, the
+// gym and the instant, so a reading comes out the same however the rows are
+// generated.
+func (s Spec) Count(l Location, at time.Time) int {
+	s = s.withDefaults()
+	local := at.In(s.Zone)
+	hour := float64(local.Hour()) + float64(local.Minute())/60
+	y := l.Peak * Profile(local.Weekday(), hour)
+	if s.Noise > 0 && y > 0 {
+		r := rand.New(rand.NewPCG(s.Seed^uint64(l.ID), uint64(at.Unix())))
+		y += r.NormFloat64() * s.Noise
+	}
+	return max(0, int(math.Round(y)))
+}
+
+// Profile is the share of a gym's peak crowd present at hour (0-24, local)
+// on weekday: closed overnight, a morning and a lunch bump and the after-work
+// peak on weekdays, one broad afternoon hump at weekends.
+func Profile(weekday time.Weekday, hour float64) float64 {
+	if hour < 6 || hour >= 23 {
+		return 0
+	}
+	bump := func(center, width, height float64) float64 {
+		d := (hour - center) / width
+		return height * math.Exp(-d*d/2)
+	}
+	if weekday == time.Saturday || weekday == time.Sunday {
+		return bump(14, 2.5, 0.75)
+	}
+	return bump(7.5, 0.8, 0.3) + bump(12.5, 1, 0.25) + bump(18.5, 1.6, 1)
+}
+
+// Transitions returns the local midnights of loc's days in year that aren't
+// 24 hours long, i.e. its DST days.
+func Transitions(year int, loc *time.Location) []time.Time {
+	var days []time.Time
+	for d := time.Date(year, 1, 1, 0, 0, 0, 0, loc); d.Year() == year; d = d.AddDate(0, 0, 1) {
+		if d.AddDate(0, 0, 1).Sub(d) != 24*time.Hour {
+			days = append(days, d)
+		}
+	}
+	return days
+}
@@ -0,0 +1,137 @@
+package fixtures
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func loadTallinn(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("Europe/Tallinn")
+	if err != nil {
+		t.Skipf("Europe/Tallinn tzdata unavailable: %v", err)
+	}
+	return loc
+}
+
+func readDay(t *testing.T, s Spec, day time.Time) [][]string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := s.WriteDay(&buf, day); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestWriteDay(t *testing.T) {
+	tallinn := loadTallinn(t)
+	day := time.Date(2025, 10, 1, 0, 0, 0, 0, tallinn) // a Wednesday
+
+	t.Run("collector format every interval", func(t *testing.T) {
+		records := readDay(t, Spec{Interval: 10 * time.Minute}, day)
+		if got, want := len(records), 1+24*6*len(DefaultLocations); got != want {
+			t.Fatalf("records = %d, want %d", got, want)
+		}
+		if records[0][0] != "timestamp" || records[0][6] != "response" {
+			t.Errorf("header = %v", records[0])
+		}
+		first := records[1]
+		if first[0] != "2025-10-01 00:00:00" || first[1] != "EEST" || first[3] != "Hipodroom" || first[5] != "success" {
+			t.Errorf("first row = %v", first)
+		}
+	})
+
+	t.Run("weekday evening peak", func(t *testing.T) {
+		s := Spec{}
+		l := DefaultLocations[0]
+		evening := s.Count(l, time.Date(2025, 10, 1, 18, 30, 0, 0, tallinn))
+		night := s.Count(l, time.Date(2025, 10, 1, 3, 0, 0, 0, tallinn))
+		if evening != int(l.Peak) || night != 0 {
+			t.Errorf("evening %d, night %d; want %v and 0", evening, night, l.Peak)
+		}
+	})
+
+	t.Run("noise is seeded", func(t *testing.T) {
+		s := Spec{Noise: 5, Seed: 7}
+		a, b, plain := readDay(t, s, day), readDay(t, s, day), readDay(t, Spec{}, day)
+		if len(a) != len(b) || len(a) != len(plain) {
+			t.Fatal("row counts differ")
+		}
+		differs := false
+		for i := range a {
+			if a[i][4] != b[i][4] {
+				t.Fatalf("row %d: %s vs %s with the same seed", i, a[i][4], b[i][4])
+			}
+			if a[i][4] != plain[i][4] {
+				differs = true
+			}
+		}
+		if !differs {
+			t.Error("noise changed nothing")
+		}
+	})
+
+	t.Run("gaps and end drop rows", func(t *testing.T) {
+		s := Spec{
+			Locations: DefaultLocations[:1],
+			Interval:  time.Hour,
+			Gaps:      []Gap{{From: day.Add(2 * time.Hour), To: day.Add(4 * time.Hour)}},
+			End:       day.Add(10 * time.Hour),
+		}
+		records := readDay(t, s, day)
+		// 00..10 inclusive is 11 readings, less 02 and 03.
+		if got := len(records) - 1; got != 9 {
+			t.Errorf("rows = %d, want 9", got)
+		}
+	})
+
+	t.Run("UTC timestamps", func(t *testing.T) {
+		records := readDay(t, Spec{UTC: true, Locations: DefaultLocations[:1]}, day)
+		if records[1][0] != "2025-09-30 21:00:00" || records[1][1] != "UTC" {
+			t.Errorf("first row = %v", records[1])
+		}
+	})
+}
+
+func TestDSTDays(t *testing.T) {
+	tallinn := loadTallinn(t)
+	days := Transitions(2025, tallinn)
+	if len(days) != 2 || days[0].Format("2006-01-02") != "2025-03-30" || days[1].Format("2006-01-02") != "2025-10-26" {
+		t.Fatalf("transitions = %v, want 2025-03-30 and 2025-10-26", days)
+	}
+	for i, want := range []int{23, 25} {
+		records := readDay(t, Spec{Locations: DefaultLocations[:1], Interval: time.Hour}, days[i])
+		if got := len(records) - 1; got != want {
+			t.Errorf("%s: rows = %d, want %d", days[i].Format("2006-01-02"), got, want)
+		}
+	}
+
+	// The repeated autumn hour is told apart by its zone, as the collector's is.
+	records := readDay(t, Spec{Locations: DefaultLocations[:1], Interval: time.Hour}, days[1])
+	if records[4][0] != "2025-10-26 03:00:00" || records[4][1] != "EEST" || records[5][0] != "2025-10-26 03:00:00" || records[5][1] != "EET" {
+		t.Errorf("rows 4-5 = %v %v, want 03:00 EEST then EET", records[4], records[5])
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tallinn := loadTallinn(t)
+	dir := t.TempDir()
+	files, err := Spec{Start: time.Date(2025, 10, 1, 12, 0, 0, 0, tallinn), Days: 3, End: time.Date(2025, 10, 2, 8, 0, 0, 0, tallinn)}.Write(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || filepath.Base(files[0]) != "gym-stats-20251001.csv" || filepath.Base(files[1]) != "gym-stats-20251002.csv" {
+		t.Fatalf("files = %v, want the 1st and the 2nd up to End", files)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "gym-stats-20251003.csv")); !os.IsNotExist(err) {
+		t.Errorf("a day after End was written: %v", err)
+	}
+}
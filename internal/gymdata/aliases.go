@@ -0,0 +1,43 @@
+package gymdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseAliases reads LOCATION_ALIASES: comma-separated old=new pairs naming
+// what a gym was called before it was renamed upstream, e.g.
+// "Suur Paala=Suur-Paala", so its history and new readings stay one series.
+// A name that is renamed can't also be a new name; map it straight to the
+// last one instead.
+func ParseAliases(s string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, pair := range splitList(s) {
+		old, name, ok := strings.Cut(pair, "=")
+		old, name = strings.TrimSpace(old), strings.TrimSpace(name)
+		if !ok || old == "" || name == "" {
+			return nil, fmt.Errorf("%q: want old=new", pair)
+		}
+		if old == name {
+			return nil, fmt.Errorf("%q renames a gym to itself", pair)
+		}
+		if _, dup := out[old]; dup {
+			return nil, fmt.Errorf("%q renamed twice", old)
+		}
+		out[old] = name
+	}
+	for old, name := range out {
+		if next, ok := out[name]; ok {
+			return nil, fmt.Errorf("%q is renamed to %q, itself renamed to %q; map it to %q directly", old, name, next, next)
+		}
+	}
+	return out, nil
+}
+
+// Canonical is the current name for a location_name, per Aliases.
+func (f Format) Canonical(name string) string {
+	if alias, ok := f.Aliases[strings.TrimSpace(name)]; ok {
+		return alias
+	}
+	return name
+}
@@ -0,0 +1,43 @@
+package gymdata
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLoadAliases(t *testing.T) {
+	loadTallinn(t)
+	dir := t.TempDir()
+	before := writeCSV(t, dir, "gym-stats-20251001.csv",
+		"timestamp,timezone,location_id,location_name,user_count,status,response\n"+
+			"2025-10-01 10:00:00,EEST,10,Suur Paala,5,success,\"{}\"\n"+
+			"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,\"{}\"\n")
+	after := writeCSV(t, dir, "gym-stats-20251002.csv",
+		"timestamp,timezone,location_id,location_name,user_count,status,response\n"+
+			"2025-10-02 10:00:00,EEST,10,Suur-Paala,6,success,\"{}\"\n")
+	aliases, err := ParseAliases("Suur Paala=Suur-Paala")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, _, err := Load(Format{Aliases: aliases}, []string{before, after}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range list {
+		got = append(got, s.Key.Location)
+	}
+	if want := []string{"Hipodroom", "Suur-Paala"}; !slices.Equal(got, want) {
+		t.Errorf("locations = %q, want %q", got, want)
+	}
+	if paala := list[1]; len(paala.Points) != 2 {
+		t.Errorf("Suur-Paala = %v, want a point under each name", paala.Points)
+	}
+
+	for _, bad := range []string{"Suur Paala", "=Suur-Paala", "A=A", "A=B,A=C", "A=B,B=C"} {
+		if _, err := ParseAliases(bad); err == nil {
+			t.Errorf("ParseAliases(%q) accepted", bad)
+		}
+	}
+}
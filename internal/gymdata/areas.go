@@ -0,0 +1,96 @@
+package gymdata
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// AreaSeparator joins a club and one of its areas (gym floor, pool,
+// classes) into one location, "Hipodroom / Pool", so an area is a location
+// of its own to everything that groups by location.
+const AreaSeparator = " / "
+
+// ParseAreaPattern reads AREA_PATTERN: a regular expression with club and
+// area groups that splits a location_name such as "Hipodroom - Pool" into
+// its club and area, e.g. `^(?P<club>.+?) - (?P<area>.+)$`. Names it doesn't
+// match stay whole clubs. "" turns the splitting off.
+func ParseAreaPattern(s string) (*regexp.Regexp, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, err
+	}
+	if re.SubexpIndex("club") < 0 || re.SubexpIndex("area") < 0 {
+		return nil, fmt.Errorf("want (?P<club>...) and (?P<area>...) groups")
+	}
+	return re, nil
+}
+
+// Location is the location a row's readings go under: its location_name,
+// renamed per Aliases, with the area column's value added when that isn't
+// blank, or otherwise split by AreaPattern when the name matches it.
+func (f Format) Location(name, area string) string {
+	name = f.Canonical(name)
+	area = strings.TrimSpace(area)
+	if area == "" && f.AreaPattern != nil {
+		if m := f.AreaPattern.FindStringSubmatch(name); m != nil {
+			name = m[f.AreaPattern.SubexpIndex("club")]
+			area = strings.TrimSpace(m[f.AreaPattern.SubexpIndex("area")])
+		}
+	}
+	if area == "" {
+		return name
+	}
+	return strings.TrimSpace(name) + AreaSeparator + area
+}
+
+// SplitArea splits a location into its club and area; area is "" for a
+// location that is a whole club.
+func SplitArea(location string) (club, area string) {
+	club, area, _ = strings.Cut(location, AreaSeparator)
+	return club, area
+}
+
+// RollUpAreas replaces each club's area series with their sum under the
+// club's name, per metric, interpolating across gaps as Total does. A club
+// that also has readings of its own keeps just those, since they already
+// count the whole club. list itself is not changed.
+func RollUpAreas(list []*Series, maxGap int64) []*Series {
+	own := map[Key]bool{}
+	areas := map[Key][]string{}
+	for _, s := range list {
+		club, area := SplitArea(s.Key.Location)
+		k := Key{Location: club, Metric: s.Key.Metric}
+		if area == "" {
+			own[k] = true
+		} else {
+			areas[k] = append(areas[k], s.Key.Location)
+		}
+	}
+	if len(areas) == 0 {
+		return list
+	}
+
+	out := make([]*Series, 0, len(list))
+	for _, s := range list {
+		club, area := SplitArea(s.Key.Location)
+		if area == "" {
+			out = append(out, s)
+			continue
+		}
+		k := Key{Location: club, Metric: s.Key.Metric}
+		if own[k] || areas[k] == nil {
+			continue
+		}
+		// Where the club's first area was: Load's order sorts "Hipodroom"
+		// just before "Hipodroom / ...".
+		if sum := Total(list, k.Metric, club, areas[k], maxGap); sum != nil {
+			out = append(out, sum)
+		}
+		areas[k] = nil
+	}
+	return out
+}
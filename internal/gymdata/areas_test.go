@@ -0,0 +1,83 @@
+package gymdata
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLoadAreas(t *testing.T) {
+	loadTallinn(t)
+	dir := t.TempDir()
+	withColumn := writeCSV(t, dir, "gym-stats-20251001.csv",
+		"timestamp,timezone,location_id,location_name,area,user_count,status,response\n"+
+			"2025-10-01 10:00:00,EEST,1,Hipodroom,Pool,12,success,\"{}\"\n"+
+			"2025-10-01 10:00:00,EEST,1,Hipodroom,Gym floor,30,success,\"{}\"\n"+
+			"2025-10-01 10:00:00,EEST,2,T1,,7,success,\"{}\"\n")
+	byName := writeCSV(t, dir, "gym-stats-20251002.csv",
+		"timestamp,timezone,location_id,location_name,user_count,status,response\n"+
+			"2025-10-02 10:00:00,EEST,1,Hipodroom - Pool,14,success,\"{}\"\n"+
+			"2025-10-02 10:00:00,EEST,3,Suur-Paala,5,success,\"{}\"\n")
+	pattern, err := ParseAreaPattern(`^(?P<club>.+?) - (?P<area>.+)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, _, err := Load(Format{AreaPattern: pattern}, []string{withColumn, byName}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range list {
+		got = append(got, s.Key.Location)
+	}
+	want := []string{"Hipodroom / Gym floor", "Hipodroom / Pool", "Suur-Paala", "T1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("locations = %q, want %q", got, want)
+	}
+	if pool := list[1]; len(pool.Points) != 2 {
+		t.Errorf("pool = %v, want a point from each file", pool.Points)
+	}
+
+	// Without the pattern the name is left alone; the column still splits.
+	list, _, _ = Load(Format{}, []string{byName}, nil)
+	if list[0].Key.Location != "Hipodroom - Pool" {
+		t.Errorf("no pattern: %q", list[0].Key.Location)
+	}
+	if got := Metrics(Format{}, []string{withColumn}); !slices.Equal(got, []string{DefaultMetric}) {
+		t.Errorf("metrics = %v, want the area column left out", got)
+	}
+
+	for _, bad := range []string{"(", `^(.+) - (.+)$`} {
+		if _, err := ParseAreaPattern(bad); err == nil {
+			t.Errorf("ParseAreaPattern(%q) accepted", bad)
+		}
+	}
+}
+
+func TestRollUpAreas(t *testing.T) {
+	series := func(location string, ys ...float64) *Series {
+		s := &Series{Key: Key{Location: location, Metric: DefaultMetric}}
+		for i, y := range ys {
+			s.Points = append(s.Points, Point{At: int64(i) * 120, Y: y})
+		}
+		return s
+	}
+	list := []*Series{
+		series("Hipodroom / Gym floor", 30, 31),
+		series("Hipodroom / Pool", 12, 14),
+		series("T1", 7, 8),
+		series("T1 / Classes", 5, 5),
+	}
+
+	got := RollUpAreas(list, 600)
+	if len(got) != 2 || len(list) != 4 {
+		t.Fatalf("got %d series (list now %d), want Hipodroom and T1", len(got), len(list))
+	}
+	if got[0].Key.Location != "Hipodroom" || !slices.Equal(got[0].Points, []Point{{At: 0, Y: 42}, {At: 120, Y: 45}}) {
+		t.Errorf("Hipodroom = %+v", got[0])
+	}
+	// T1 counts the whole club itself, classes included.
+	if got[1] != list[2] {
+		t.Errorf("T1 = %+v, want its own series", got[1])
+	}
+}
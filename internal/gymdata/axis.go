@@ -0,0 +1,72 @@
+package gymdata
+
+import (
+	"math"
+	"slices"
+)
+
+// Fill is how Align gives a series a value at an instant it has no reading
+// at. A value it can't give is NaN, which the chart replies write as null.
+type Fill int
+
+const (
+	FillNull     Fill = iota // always NaN
+	FillPrevious             // the reading before, carried forward
+	FillLinear               // interpolated between the readings either side
+)
+
+func (f Fill) String() string {
+	switch f {
+	case FillPrevious:
+		return "previous"
+	case FillLinear:
+		return "linear"
+	}
+	return "null"
+}
+
+// Align puts each metric's series on one time axis: every instant any of
+// them has a reading at, so the series can be stacked or divided point by
+// point. Missing values are filled as fill says, but never across a gap of
+// more than maxGap seconds, nor before a series' first reading or (except
+// carrying forward) after its last; those are NaN. Flags stay on the
+// readings they were on. list itself, which may be cached, is not changed.
+func Align(list []*Series, fill Fill, maxGap int64) []*Series {
+	axes := map[string][]int64{}
+	for _, s := range list {
+		for _, p := range s.Points {
+			axes[s.Key.Metric] = append(axes[s.Key.Metric], p.At)
+		}
+	}
+	for metric, axis := range axes {
+		slices.Sort(axis)
+		axes[metric] = slices.Compact(axis)
+	}
+
+	out := make([]*Series, 0, len(list))
+	for _, s := range list {
+		axis := axes[s.Key.Metric]
+		aligned := &Series{Key: s.Key, Points: make([]Point, 0, len(axis)), Flagged: s.Flagged}
+		j := 0 // s's first point at or after the instant
+		for _, at := range axis {
+			for j < len(s.Points) && s.Points[j].At < at {
+				j++
+			}
+			y := math.NaN()
+			switch {
+			case j < len(s.Points) && s.Points[j].At == at:
+				y = s.Points[j].Y
+			case j == 0:
+				// before the first reading
+			case fill == FillPrevious && at-s.Points[j-1].At <= maxGap:
+				y = s.Points[j-1].Y
+			case fill == FillLinear && j < len(s.Points) && s.Points[j].At-s.Points[j-1].At <= maxGap:
+				a, b := s.Points[j-1], s.Points[j]
+				y = math.Round((a.Y+(b.Y-a.Y)*float64(at-a.At)/float64(b.At-a.At))*10) / 10
+			}
+			aligned.Points = append(aligned.Points, Point{At: at, Y: y})
+		}
+		out = append(out, aligned)
+	}
+	return out
+}
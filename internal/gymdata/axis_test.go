@@ -0,0 +1,45 @@
+package gymdata
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAlign(t *testing.T) {
+	a := &Series{Key: Key{Location: "A", Metric: DefaultMetric}, Points: []Point{{At: 0, Y: 10}, {At: 120, Y: 20}, {At: 1200, Y: 5}}, Flagged: []int64{120}}
+	b := &Series{Key: Key{Location: "B", Metric: DefaultMetric}, Points: []Point{{At: 60, Y: 4}, {At: 180, Y: 7}}}
+	q := &Series{Key: Key{Location: "A", Metric: "queue_length"}, Points: []Point{{At: 90, Y: 3}}}
+	list := []*Series{a, b, q}
+
+	// The axis is 0, 60, 120, 180, 1200; 1200 is too far from A's 120 and
+	// B's 180 to fill at 600s.
+	for _, tc := range []struct {
+		fill Fill
+		a, b string
+	}{
+		{FillNull, "[10 NaN 20 NaN 5]", "[NaN 4 NaN 7 NaN]"},
+		{FillPrevious, "[10 10 20 20 5]", "[NaN 4 4 7 NaN]"},
+		{FillLinear, "[10 15 20 NaN 5]", "[NaN 4 5.5 7 NaN]"},
+	} {
+		got := Align(list, tc.fill, 600)
+		if len(got) != 3 {
+			t.Fatalf("%v: %d series, want 3", tc.fill, len(got))
+		}
+		ys := func(s *Series) string {
+			var y []float64
+			for _, p := range s.Points {
+				y = append(y, p.Y)
+			}
+			return fmt.Sprint(y)
+		}
+		if ys(got[0]) != tc.a || ys(got[1]) != tc.b {
+			t.Errorf("%v: A %s, B %s; want %s and %s", tc.fill, ys(got[0]), ys(got[1]), tc.a, tc.b)
+		}
+		if len(got[2].Points) != 1 || len(got[0].Flagged) != 1 {
+			t.Errorf("%v: queue %v, flags %v; want its own axis and A's flag kept", tc.fill, got[2].Points, got[0].Flagged)
+		}
+	}
+	if len(a.Points) != 3 || len(b.Points) != 2 {
+		t.Error("Align changed its input")
+	}
+}
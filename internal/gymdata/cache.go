@@ -0,0 +1,121 @@
+package gymdata
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCacheFiles is how many parsed files are kept unless SetCacheFiles
+// says otherwise: two months of days, so the month views and the recent
+// window stay warm.
+const DefaultCacheFiles = 64
+
+var (
+	cacheMu    sync.Mutex
+	cacheLimit = DefaultCacheFiles
+	cacheClock int64
+	cache      = map[string]*cacheEntry{}
+)
+
+// ParseObserver, when set, is told of each file Load reads: whether the
+// cache already had it and, if not, how many rows were parsed and how long
+// that took. Set it before the first Load.
+var ParseObserver func(hit bool, rows int, took time.Duration)
+
+// cacheEntry is a file's parse as of the size and mtime it had then; used
+// orders entries for eviction.
+type cacheEntry struct {
+	size    int64
+	modTime time.Time
+	parsed  *parsedFile
+	used    int64
+}
+
+// SetCacheFiles sets how many parsed files Load keeps in memory; 0 turns the
+// cache off. The least recently used go first once it is full.
+func SetCacheFiles(n int) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	cacheLimit = max(n, 0)
+	evict()
+}
+
+// cachedParse is parseFile, reusing the last parse of csvFile under the same
+// format and metrics while the file's size and mtime are unchanged. A file
+// the collector is still appending to has only its new lines parsed after
+// each poll; the old parse is replaced rather than kept beside the new one.
+func cachedParse(f Format, csvFile string, metrics []string) (*parsedFile, error) {
+	start := time.Now()
+	info, err := Stat(csvFile)
+	if err != nil {
+		parsed, err := parseFile(f, csvFile, metrics)
+		if err == nil && ParseObserver != nil {
+			ParseObserver(false, parsed.rows.Total(), time.Since(start))
+		}
+		return parsed, err
+	}
+	key := csvFile + "|" + strings.Join(metrics, ",") + "|" + formatKey(f)
+
+	cacheMu.Lock()
+	cacheClock++
+	e := cache[key]
+	if e != nil && e.size == info.Size && e.modTime.Equal(info.ModTime) {
+		e.used = cacheClock
+		cacheMu.Unlock()
+		if ParseObserver != nil {
+			ParseObserver(true, 0, 0)
+		}
+		return e.parsed, nil
+	}
+	cacheMu.Unlock()
+
+	var parsed *parsedFile
+	before := 0
+	if e != nil && info.Size > e.size && e.parsed.offset >= 0 {
+		before = e.parsed.rows.Total()
+		parsed, err = e.parsed.extend(f, csvFile, metrics)
+	} else {
+		parsed, err = parseFile(f, csvFile, metrics)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ParseObserver != nil {
+		ParseObserver(false, parsed.rows.Total()-before, time.Since(start))
+	}
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	if cacheLimit > 0 {
+		cache[key] = &cacheEntry{size: info.Size, modTime: info.ModTime, parsed: parsed, used: cacheClock}
+		evict()
+	}
+	return parsed, nil
+}
+
+// Forget drops the cached parses of csvFile, for a caller that has
+// rewritten it: a rewrite that grew the file would otherwise be read as
+// lines appended to the old parse.
+func Forget(csvFile string) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	for k := range cache {
+		if strings.HasPrefix(k, csvFile+"|") {
+			delete(cache, k)
+		}
+	}
+}
+
+// evict drops least recently used entries down to the limit. The scan is
+// linear, but the cache holds a few hundred files at most.
+func evict() {
+	for len(cache) > cacheLimit {
+		oldest := ""
+		for k, e := range cache {
+			if oldest == "" || e.used < cache[oldest].used {
+				oldest = k
+			}
+		}
+		delete(cache, oldest)
+	}
+}
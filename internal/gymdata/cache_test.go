@@ -0,0 +1,114 @@
+package gymdata
+
+import (
+	"os"
+	"slices"
+	"testing"
+
+	"gym/internal/fixtures"
+)
+
+func TestLoadCache(t *testing.T) {
+	loadTallinn(t)
+	dir := t.TempDir()
+	files, err := fixtures.Spec{Days: 3}.Write(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetCacheFiles(DefaultCacheFiles)
+	SetCacheFiles(2)
+
+	first, _, err := Load(Format{}, files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache) != 2 {
+		t.Errorf("cached files = %d, want the limit of 2", len(cache))
+	}
+	n := len(first[0].Points)
+	Bucket(first, 60, Tallinn()) // callers compact in place
+
+	again, rows, err := Load(Format{}, files[1:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(again[0].Points); got != n*2/3 || rows.Included != n*2/3*len(again) {
+		t.Fatalf("cached load: %d points, %d rows; want %d a series, unaffected by bucketing", got, rows.Included, n*2/3)
+	}
+
+	// A new reading changes the file's size, so its parse is replaced.
+	f, err := os.OpenFile(files[2], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("2025-10-03 23:59:00,EEST,99,Annex,5,success,{}\n")
+	f.Close()
+	grown, _, err := Load(Format{}, files[2:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grown) != len(fixtures.DefaultLocations)+1 {
+		t.Errorf("series = %d, want the appended gym too", len(grown))
+	}
+	if len(cache) != 2 {
+		t.Errorf("cached files = %d, want 2 after replacing a parse", len(cache))
+	}
+
+	SetCacheFiles(0)
+	if len(cache) != 0 {
+		t.Errorf("cached files = %d after turning the cache off", len(cache))
+	}
+}
+
+func TestLoadCacheExtends(t *testing.T) {
+	loadTallinn(t)
+	defer SetCacheFiles(DefaultCacheFiles)
+	SetCacheFiles(DefaultCacheFiles)
+	const header = "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	file := writeCSV(t, t.TempDir(), "gym-stats-20251001.csv", header+
+		"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,{}\n"+
+		"2025-10-01 10:02:00,EEST,1,Hipo")
+	load := func() []float64 {
+		t.Helper()
+		list, _, err := Load(Format{}, []string{file}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []float64
+		for _, s := range list {
+			for _, p := range s.Points {
+				got = append(got, p.Y)
+			}
+		}
+		return got
+	}
+	if got := load(); !slices.Equal(got, []float64{12}) {
+		t.Fatalf("first load = %v, want 12", got)
+	}
+
+	// The collector finishes the line: a parse that ended on half a line
+	// starts over.
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(header +
+		"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,{}\n" +
+		"2025-10-01 10:02:00,EEST,1,Hipodroom,14,success,{}\n")
+	if got := load(); !slices.Equal(got, []float64{12, 14}) {
+		t.Fatalf("after finishing the line = %v, want 12 14", got)
+	}
+
+	// From a whole line on, only what was appended is parsed: the 12 edited
+	// in place (same length) is not read again.
+	write(header +
+		"2025-10-01 10:00:00,EEST,1,Hipodroom,99,success,{}\n" +
+		"2025-10-01 10:02:00,EEST,1,Hipodroom,14,success,{}\n" +
+		"2025-10-01 10:04:00,EEST,1,Hipodroom,16,success,{}\n" +
+		"2025-10-01 10:04:00,EEST,2,T1,3,success,{}\n")
+	if got := load(); !slices.Equal(got, []float64{12, 14, 16, 3}) {
+		t.Errorf("after appending = %v, want 12 14 16 and T1's 3", got)
+	}
+}
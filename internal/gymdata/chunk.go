@@ -0,0 +1,150 @@
+package gymdata
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// ErrBadChunk is DecodeChunk's answer to bytes EncodeChunk didn't write.
+var ErrBadChunk = errors.New("corrupt point chunk")
+
+// Chunk value encodings, the chunk's first byte.
+const (
+	chunkWhole byte = iota // zigzag varint deltas of whole numbers
+	chunkFloat             // 8-byte IEEE 754, little-endian
+)
+
+// EncodeChunk packs a series' points (a location's day, say) Gorilla
+// style. Times are the first one, then the first gap, then each gap's
+// change from the one before (delta-of-delta), as zigzag varints: on the
+// collector's 2-minute grid every point after the second costs one byte.
+// Any order round-trips, but time order is what packs small. Values that
+// are all whole numbers, as headcounts are, are varint deltas from the
+// previous one, a byte or two each; any fraction or gap (NaN) stores them
+// all as plain 8-byte floats instead.
+func EncodeChunk(points []Point) []byte {
+	kind := chunkWhole
+	for _, p := range points {
+		if p.Y != math.Trunc(p.Y) || math.Abs(p.Y) > 1<<53 {
+			kind = chunkFloat // also NaN and ±Inf, which equal no Trunc
+			break
+		}
+	}
+	b := make([]byte, 0, 2+len(points)*3)
+	b = append(b, kind)
+	b = appendTimes(b, len(points), func(i int) int64 { return points[i].At })
+	var prev int64
+	for _, p := range points {
+		if kind == chunkFloat {
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(p.Y))
+			continue
+		}
+		v := int64(p.Y)
+		b = binary.AppendVarint(b, v-prev)
+		prev = v
+	}
+	return b
+}
+
+// DecodeChunk unpacks what EncodeChunk packed.
+func DecodeChunk(b []byte) ([]Point, error) {
+	if len(b) == 0 || b[0] > chunkFloat {
+		return nil, ErrBadChunk
+	}
+	kind := b[0]
+	times, b, err := readTimes(b[1:])
+	if err != nil {
+		return nil, err
+	}
+	points := make([]Point, len(times))
+	var prev int64
+	for i, at := range times {
+		points[i].At = at
+		if kind == chunkFloat {
+			if len(b) < 8 {
+				return nil, ErrBadChunk
+			}
+			points[i].Y = math.Float64frombits(binary.LittleEndian.Uint64(b))
+			b = b[8:]
+			continue
+		}
+		d, n := binary.Varint(b)
+		if n <= 0 {
+			return nil, ErrBadChunk
+		}
+		prev += d
+		points[i].Y = float64(prev)
+		b = b[n:]
+	}
+	if len(b) != 0 {
+		return nil, ErrBadChunk
+	}
+	return points, nil
+}
+
+// EncodeTimes packs Unix times as EncodeChunk packs its points'.
+func EncodeTimes(times []int64) []byte {
+	return appendTimes(nil, len(times), func(i int) int64 { return times[i] })
+}
+
+// DecodeTimes unpacks what EncodeTimes packed.
+func DecodeTimes(b []byte) ([]int64, error) {
+	times, rest, err := readTimes(b)
+	if err == nil && len(rest) != 0 {
+		err = ErrBadChunk
+	}
+	return times, err
+}
+
+// appendTimes writes a count and n times, the i-th from at(i).
+func appendTimes(b []byte, n int, at func(i int) int64) []byte {
+	b = binary.AppendUvarint(b, uint64(n))
+	var prev, delta int64
+	for i := 0; i < n; i++ {
+		t := at(i)
+		switch i {
+		case 0:
+			b = binary.AppendVarint(b, t)
+		case 1:
+			delta = t - prev
+			b = binary.AppendVarint(b, delta)
+		default:
+			d := t - prev
+			b = binary.AppendVarint(b, d-delta)
+			delta = d
+		}
+		prev = t
+	}
+	return b
+}
+
+// readTimes reads what appendTimes wrote and returns the bytes after it.
+func readTimes(b []byte) ([]int64, []byte, error) {
+	count, n := binary.Uvarint(b)
+	if n <= 0 || count > uint64(len(b)) { // every time takes a byte at least
+		return nil, nil, ErrBadChunk
+	}
+	b = b[n:]
+	times := make([]int64, count)
+	var prev, delta int64
+	for i := range times {
+		v, n := binary.Varint(b)
+		if n <= 0 {
+			return nil, nil, ErrBadChunk
+		}
+		b = b[n:]
+		switch i {
+		case 0:
+			prev = v
+		case 1:
+			delta = v
+			prev += delta
+		default:
+			delta += v
+			prev += delta
+		}
+		times[i] = prev
+	}
+	return times, b, nil
+}
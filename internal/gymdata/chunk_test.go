@@ -0,0 +1,61 @@
+package gymdata
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestChunk(t *testing.T) {
+	grid := make([]Point, 720) // a day of 2-minute headcounts
+	for i := range grid {
+		grid[i] = Point{At: 1_759_266_000 + int64(i)*120, Y: float64(40 + i%17 - i%5)}
+	}
+	grid[300].At += 120 // a missed poll
+	for _, tc := range []struct {
+		name   string
+		points []Point
+		max    int // bytes
+	}{
+		{"empty", nil, 2},
+		{"one", []Point{{At: 1_759_266_000, Y: 3}}, 8},
+		{"day of counts", grid, 3 * len(grid)},
+		{"fractions", []Point{{At: 10, Y: 0.25}, {At: 20, Y: -1.5}, {At: 15, Y: 7}}, 40},
+		{"gap", []Point{{At: 10, Y: 4}, {At: 20, Y: math.NaN()}}, 40},
+		{"negative", []Point{{At: -120, Y: -5}, {At: 0, Y: 1e15}}, 40},
+	} {
+		b := EncodeChunk(tc.points)
+		if len(b) > tc.max {
+			t.Errorf("%s: %d bytes, want at most %d", tc.name, len(b), tc.max)
+		}
+		got, err := DecodeChunk(b)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		same := len(got) == len(tc.points)
+		for i := 0; same && i < len(got); i++ {
+			want := tc.points[i]
+			same = got[i].At == want.At && (got[i].Y == want.Y || math.IsNaN(got[i].Y) && math.IsNaN(want.Y))
+		}
+		if !same {
+			t.Errorf("%s: round trip gave %v", tc.name, got)
+		}
+	}
+
+	times := []int64{1_759_266_000, 1_759_266_120, 1_759_266_600}
+	if got, err := DecodeTimes(EncodeTimes(times)); err != nil || !slices.Equal(got, times) {
+		t.Errorf("times: %v, %v", got, err)
+	}
+	good := EncodeChunk(grid)
+	for name, b := range map[string][]byte{
+		"empty":      nil,
+		"bad kind":   append([]byte{9}, good[1:]...),
+		"truncated":  good[:len(good)-1],
+		"trailing":   append(slices.Clone(good), 0),
+		"huge count": {chunkWhole, 0xff, 0xff, 0xff, 0x7f},
+	} {
+		if _, err := DecodeChunk(b); err != ErrBadChunk {
+			t.Errorf("%s: err %v", name, err)
+		}
+	}
+}
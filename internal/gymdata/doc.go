@@ -0,0 +1,7 @@
+// Package gymdata reads the collector's daily CSVs: it finds them in a
+// directory or S3-compatible bucket, parses their rows under a deployment's
+// column mapping, timestamp layout and status policy, and turns them into
+// per-gym series that can be bucketed and trimmed. The server builds its
+// responses on it; other tools can read the same files the same way. It
+// builds for js/wasm too, reading from a Source the caller registers.
+package gymdata
@@ -0,0 +1,257 @@
+package gymdata
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Expr is an arithmetic expression over named series and numbers, such as
+// "Kristiine + Rocca al Mare" or "user_count / capacity * 100": + - * /,
+// unary minus and parentheses. A name is any run of other characters, its
+// spaces trimmed, or one in double quotes, for names holding an operator
+// ("Suur-Paala"). Names match case-insensitively.
+type Expr struct {
+	src   string
+	root  *exprNode
+	names []string // lowercased, in order of first use
+}
+
+type exprNode struct {
+	op   byte // 0 for a number, 'n' for a name, else the operator; '~' negates l
+	num  float64
+	name int
+	l, r *exprNode
+}
+
+// ParseExpr parses an expression.
+func ParseExpr(s string) (*Expr, error) {
+	p := &exprParser{src: s, e: &Expr{src: strings.TrimSpace(s)}}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	root, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("%q: unexpected %q", s, p.toks[p.pos].text)
+	}
+	p.e.root = root
+	return p.e, nil
+}
+
+// Names lists the lowercased names e uses.
+func (e *Expr) Names() []string { return e.names }
+
+func (e *Expr) String() string { return e.src }
+
+type exprToken struct {
+	op   byte // one of +-*/() or 0 for a number or name
+	text string
+	name bool
+}
+
+type exprParser struct {
+	src  string
+	toks []exprToken
+	pos  int
+	e    *Expr
+}
+
+func (p *exprParser) lex() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case strings.IndexByte("+-*/()", c) >= 0:
+			p.toks = append(p.toks, exprToken{op: c, text: string(c)})
+			i++
+		case c == '"':
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				return fmt.Errorf("%q: unclosed quote", s)
+			}
+			if name := strings.TrimSpace(s[i+1 : i+1+end]); name != "" {
+				p.toks = append(p.toks, exprToken{text: name, name: true})
+			} else {
+				return fmt.Errorf("%q: empty name", s)
+			}
+			i += end + 2
+		default:
+			end := strings.IndexAny(s[i:], "+-*/()\"")
+			if end < 0 {
+				end = len(s) - i
+			}
+			word := strings.TrimSpace(s[i : i+end])
+			_, err := strconv.ParseFloat(word, 64)
+			p.toks = append(p.toks, exprToken{text: word, name: err != nil})
+			i += end
+		}
+	}
+	if len(p.toks) == 0 {
+		return fmt.Errorf("empty expression")
+	}
+	return nil
+}
+
+// sum := product {("+" | "-") product}
+func (p *exprParser) sum() (*exprNode, error) {
+	l, err := p.product()
+	for err == nil && p.pos < len(p.toks) && (p.toks[p.pos].op == '+' || p.toks[p.pos].op == '-') {
+		op := p.toks[p.pos].op
+		p.pos++
+		var r *exprNode
+		if r, err = p.product(); err == nil {
+			l = &exprNode{op: op, l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+// product := unary {("*" | "/") unary}
+func (p *exprParser) product() (*exprNode, error) {
+	l, err := p.unary()
+	for err == nil && p.pos < len(p.toks) && (p.toks[p.pos].op == '*' || p.toks[p.pos].op == '/') {
+		op := p.toks[p.pos].op
+		p.pos++
+		var r *exprNode
+		if r, err = p.unary(); err == nil {
+			l = &exprNode{op: op, l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+// unary := "-" unary | number | name | "(" sum ")"
+func (p *exprParser) unary() (*exprNode, error) {
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("%q ends early", p.src)
+	}
+	t := p.toks[p.pos]
+	p.pos++
+	switch {
+	case t.op == '-':
+		l, err := p.unary()
+		return &exprNode{op: '~', l: l}, err
+	case t.op == '(':
+		n, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.toks) || p.toks[p.pos].op != ')' {
+			return nil, fmt.Errorf("%q: missing )", p.src)
+		}
+		p.pos++
+		return n, nil
+	case t.op != 0:
+		return nil, fmt.Errorf("%q: unexpected %q", p.src, t.text)
+	case t.name:
+		name := strings.ToLower(t.text)
+		i := slices.Index(p.e.names, name)
+		if i < 0 {
+			i = len(p.e.names)
+			p.e.names = append(p.e.names, name)
+		}
+		return &exprNode{op: 'n', name: i}, nil
+	}
+	v, _ := strconv.ParseFloat(t.text, 64)
+	return &exprNode{num: v}, nil
+}
+
+func (n *exprNode) eval(vals []float64) float64 {
+	switch n.op {
+	case 0:
+		return n.num
+	case 'n':
+		return vals[n.name]
+	case '~':
+		return -n.l.eval(vals)
+	case '+':
+		return n.l.eval(vals) + n.r.eval(vals)
+	case '-':
+		return n.l.eval(vals) - n.r.eval(vals)
+	case '*':
+		return n.l.eval(vals) * n.r.eval(vals)
+	}
+	return n.l.eval(vals) / n.r.eval(vals)
+}
+
+// Derive evaluates e into a series under key, at every instant any series
+// it names has a reading, as Total sums: a series without a reading then is
+// interpolated across at most maxGap seconds, and an instant some series
+// can't be given a value at is left out, as is one dividing by zero. Names
+// are looked up, lowercased, in consts and then series. It returns nil if
+// a name is in neither, or e names no series.
+func Derive(e *Expr, key Key, series map[string]*Series, consts map[string]float64, maxGap int64) *Series {
+	vals := make([]float64, len(e.names))
+	var parts []*Series
+	var slots []int // parts[i] fills vals[slots[i]]
+	for i, name := range e.names {
+		if c, ok := consts[name]; ok {
+			vals[i] = c
+			continue
+		}
+		s := series[name]
+		if s == nil || len(s.Points) == 0 {
+			return nil
+		}
+		parts = append(parts, s)
+		slots = append(slots, i)
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+
+	var instants []int64
+	for _, s := range parts {
+		for _, p := range s.Points {
+			instants = append(instants, p.At)
+		}
+	}
+	slices.Sort(instants)
+	instants = slices.Compact(instants)
+
+	out := &Series{Key: key}
+	next := make([]int, len(parts))
+	for _, at := range instants {
+		ok := true
+		for i, s := range parts {
+			v, j, found := valueAt(s, next[i], at, maxGap)
+			next[i] = j
+			if !found {
+				ok = false
+				break
+			}
+			vals[slots[i]] = v
+		}
+		if !ok {
+			continue
+		}
+		if y := e.root.eval(vals); !math.IsNaN(y) && !math.IsInf(y, 0) {
+			out.Points = append(out.Points, Point{At: at, Y: y})
+		}
+	}
+	return out
+}
+
+// valueAt is s's reading at at, or its interpolation between readings at
+// most maxGap apart either side, searching from s.Points[j]. It returns
+// the index of the first point at or after at, to start the next search.
+func valueAt(s *Series, j int, at, maxGap int64) (float64, int, bool) {
+	for j < len(s.Points) && s.Points[j].At < at {
+		j++
+	}
+	switch {
+	case j < len(s.Points) && s.Points[j].At == at:
+		return s.Points[j].Y, j, true
+	case j > 0 && j < len(s.Points) && s.Points[j].At-s.Points[j-1].At <= maxGap:
+		a, b := s.Points[j-1], s.Points[j]
+		return a.Y + (b.Y-a.Y)*float64(at-a.At)/float64(b.At-a.At), j, true
+	}
+	return 0, j, false
+}
@@ -0,0 +1,59 @@
+package gymdata
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseExpr(t *testing.T) {
+	for src, want := range map[string][]string{
+		"Kristiine + Rocca al Mare":   {"kristiine", "rocca al mare"},
+		`"Suur-Paala" - 2 * (a + A)`:  {"suur-paala", "a"},
+		"user_count / capacity * 100": {"user_count", "capacity"},
+		"-queue_length":               {"queue_length"},
+		"1.5e1 + x":                   {"x"},
+	} {
+		e, err := ParseExpr(src)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if !slices.Equal(e.Names(), want) {
+			t.Errorf("%s: names %q, want %q", src, e.Names(), want)
+		}
+	}
+	for _, src := range []string{"", "a +", "(a + b", "a b)", `"a`, `""`, "* a"} {
+		if _, err := ParseExpr(src); err == nil {
+			t.Errorf("%q parsed, want an error", src)
+		}
+	}
+}
+
+func TestDerive(t *testing.T) {
+	a := &Series{Points: []Point{{At: 0, Y: 10}, {At: 120, Y: 20}, {At: 240, Y: 0}}}
+	b := &Series{Points: []Point{{At: 60, Y: 4}, {At: 120, Y: 6}}}
+	e, err := ParseExpr("(a + B) / cap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := Key{Location: "A+B", Metric: DefaultMetric}
+	got := Derive(e, key, map[string]*Series{"a": a, "b": b}, map[string]float64{"cap": 2}, 600)
+	// 0: b hasn't started. 60: a is 15. 240: b has stopped.
+	want := []Point{{At: 60, Y: 9.5}, {At: 120, Y: 13}}
+	if got.Key != key || !slices.Equal(got.Points, want) {
+		t.Errorf("derived = %+v, want %v", got, want)
+	}
+
+	// a's zero reading would divide by zero.
+	e, _ = ParseExpr("b / a")
+	if got := Derive(e, key, map[string]*Series{"a": a, "b": b}, nil, 600); !slices.Equal(got.Points, []Point{{At: 60, Y: 4.0 / 15}, {At: 120, Y: 0.3}}) {
+		t.Errorf("b / a = %v", got.Points)
+	}
+	if got := Derive(e, key, map[string]*Series{"a": a}, nil, 600); got != nil {
+		t.Errorf("missing b = %+v, want nil", got)
+	}
+	e, _ = ParseExpr("cap * 2")
+	if got := Derive(e, key, nil, map[string]float64{"cap": 2}, 600); got != nil {
+		t.Errorf("constants only = %+v, want nil", got)
+	}
+}
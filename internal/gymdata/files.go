@@ -0,0 +1,214 @@
+package gymdata
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoFiles is returned when a directory has no daily files at all.
+var ErrNoFiles = errors.New("no CSV files found matching gym-stats-*.csv")
+
+// Source is where the daily files live: a local directory, or an
+// S3-compatible bucket (s3://bucket/prefix). Files are named by path either
+// way, so callers pass them around as strings; a bucket's paths keep the
+// s3:// form and are routed back to it.
+type Source interface {
+	// Glob lists the files in dir whose base name matches pattern.
+	Glob(dir, pattern string) ([]string, error)
+	Open(path string) (io.ReadCloser, error)
+	Stat(path string) (FileInfo, error)
+}
+
+// FileInfo is the part of a file's metadata the caches key on.
+type FileInfo struct {
+	Size    int64
+	ModTime time.Time
+}
+
+var (
+	sourcesMu sync.Mutex
+	sources   = map[string]Source{} // root -> source
+)
+
+// RegisterSource makes src the source of every path under root, such as
+// s3://bucket/prefix/, replacing any registered there before. A path under
+// no registered root is a local file, or, in a js/wasm build, which has no
+// file system to read, an error.
+func RegisterSource(root string, src Source) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	sources[root] = src
+}
+
+// SourceFor picks the source a directory or file path belongs to: the
+// registered one with the longest matching root, if any.
+func SourceFor(path string) Source {
+	sourcesMu.Lock()
+	var best Source
+	bestLen := -1
+	for root, src := range sources {
+		if (strings.HasPrefix(path, root) || path+"/" == root) && len(root) > bestLen {
+			best, bestLen = src, len(root)
+		}
+	}
+	sourcesMu.Unlock()
+	if best != nil {
+		return best
+	}
+	if strings.HasPrefix(path, "s3://") {
+		return errSource{fmt.Errorf("%s: no CSV_SOURCE configured for this bucket", path)}
+	}
+	return localSource(path)
+}
+
+// errSource fails every call, for a path no source claims.
+type errSource struct{ err error }
+
+func (e errSource) Glob(string, string) ([]string, error) { return nil, e.err }
+func (e errSource) Open(string) (io.ReadCloser, error)    { return nil, e.err }
+func (e errSource) Stat(string) (FileInfo, error)         { return FileInfo{}, e.err }
+
+// Stat is os.Stat for a daily file from any source.
+func Stat(path string) (FileInfo, error) {
+	return SourceFor(path).Stat(path)
+}
+
+// ListFiles returns the daily files in dir, plain (gym-stats-YYYYMMDD.csv)
+// or gzipped (.csv.gz). If a day has both, as while gzip is still running,
+// only the plain file is listed so its rows are not read twice.
+func ListFiles(dir string) ([]string, error) {
+	src := SourceFor(dir)
+	plain, err := src.Glob(dir, "gym-stats-*.csv")
+	if err != nil {
+		return nil, err
+	}
+	gzipped, err := src.Glob(dir, "gym-stats-*.csv.gz")
+	if err != nil {
+		return nil, err
+	}
+	have := make(map[string]bool, len(plain))
+	for _, f := range plain {
+		have[f] = true
+	}
+	files := plain
+	for _, f := range gzipped {
+		if !have[strings.TrimSuffix(f, ".gz")] {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// BaseName is a daily file's name without .gz, so both forms compare and
+// slice alike.
+func BaseName(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), ".gz")
+}
+
+// Latest returns the most recently modified daily file in dir.
+func Latest(dir string) (string, error) {
+	files, err := ListFiles(dir)
+	if err != nil {
+		return "", err
+	}
+
+	if len(files) == 0 {
+		return "", ErrNoFiles
+	}
+
+	// Find the most recently modified file
+	var latestFile string
+	var latestTime time.Time
+
+	for _, file := range files {
+		info, err := Stat(file)
+		if err != nil {
+			continue
+		}
+
+		if info.ModTime.After(latestTime) {
+			latestTime = info.ModTime
+			latestFile = file
+		}
+	}
+
+	return latestFile, nil
+}
+
+// InRange returns the daily files in dir dated fromDate to toDate inclusive,
+// both YYYY-MM-DD.
+func InRange(dir, fromDate, toDate string) ([]string, error) {
+	files, err := ListFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, ErrNoFiles
+	}
+
+	// Parse date range
+	from, err := time.Parse("2006-01-02", fromDate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from date format: %v", err)
+	}
+	to, err := time.Parse("2006-01-02", toDate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid to date format: %v", err)
+	}
+
+	var filteredFiles []string
+	for _, file := range files {
+		// Extract date from filename (gym-stats-YYYYMMDD.csv[.gz])
+		basename := BaseName(file)
+		if len(basename) < 20 { // gym-stats-YYYYMMDD.csv = 20 chars minimum
+			continue
+		}
+
+		dateStr := basename[10:18] // Extract YYYYMMDD
+		fileDate, err := time.Parse("20060102", dateStr)
+		if err != nil {
+			continue
+		}
+
+		// Check if file date is within range (inclusive)
+		if (fileDate.Equal(from) || fileDate.After(from)) && (fileDate.Equal(to) || fileDate.Before(to.AddDate(0, 0, 1))) {
+			filteredFiles = append(filteredFiles, file)
+		}
+	}
+
+	return filteredFiles, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	file io.ReadCloser
+}
+
+func (g gzipFile) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+// Open opens a daily file for reading, decompressing .csv.gz on the fly.
+func Open(path string) (io.ReadCloser, error) {
+	file, err := SourceFor(path).Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return file, nil
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return gzipFile{Reader: gz, file: file}, nil
+}
@@ -0,0 +1,54 @@
+package gymdata
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+)
+
+func TestGzippedCSVs(t *testing.T) {
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	content := func(day string) string {
+		return "timestamp,timezone,location_id,location_name,user_count,status,response\n" +
+			day + " 10:00:00,EEST,1,Hipodroom,12,success,\"{}\"\n"
+	}
+	writeGz := func(name, content string) {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		gz := gzip.NewWriter(f)
+		io.WriteString(gz, content)
+		gz.Close()
+		f.Close()
+	}
+	writeGz("gym-stats-20251001.csv.gz", content("2025-10-01"))
+	writeGz("gym-stats-20251002.csv.gz", content("2025-10-02"))
+	writeCSV(t, dir, "gym-stats-20251002.csv", content("2025-10-02")) // mid-compression duplicate
+
+	files, err := InRange("", "2025-10-01", "2025-10-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	if want := []string{"gym-stats-20251001.csv.gz", "gym-stats-20251002.csv"}; !slices.Equal(files, want) {
+		t.Fatalf("files = %v, want %v", files, want)
+	}
+	list, _, err := Load(Format{}, files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || len(list[0].Points) != 2 {
+		t.Errorf("got %d series, want 1 with 2 points", len(list))
+	}
+}
@@ -0,0 +1,91 @@
+package gymdata
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// FileCheck is what CheckFile found in a daily file: its data rows, how many
+// of them couldn't be parsed (cut short, garbled or with an unreadable
+// timestamp) and how many are dated before an earlier row of the same
+// location.
+type FileCheck struct {
+	Rows      int
+	Unparsed  int
+	Backwards int
+}
+
+// Bad is the rows that are unparsed or run backwards.
+func (c FileCheck) Bad() int {
+	return c.Unparsed + c.Backwards
+}
+
+// CheckFile reads csvFile the way Load would, without keeping anything, to
+// see whether it is intact. A file that can't be opened or has no header
+// with the required columns is an error; the rest is counted. Statuses and
+// metrics are not looked at: a failed poll is a well-formed row.
+func CheckFile(f Format, csvFile string) (FileCheck, error) {
+	var c FileCheck
+	file, err := Open(csvFile)
+	if err != nil {
+		return c, fmt.Errorf("failed to open CSV file: %v", err)
+	}
+	defer file.Close()
+
+	reader := newLineReader(file)
+	headers, err := reader.Read()
+	if err == io.EOF {
+		return c, fmt.Errorf("empty file")
+	}
+	if err != nil && err != errUnterminatedQuote {
+		return c, fmt.Errorf("failed to read CSV headers: %v", err)
+	}
+	cols, err := findColumns(CanonicalHeaders(headers, f.Columns), nil)
+	if err != nil {
+		return c, err
+	}
+
+	tallinn := Tallinn()
+	last := map[string]int64{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		c.Rows++
+		if err == errUnterminatedQuote && len(record)-1 <= cols.max {
+			c.Unparsed++
+			continue
+		}
+		if err != nil && err != errUnterminatedQuote {
+			c.Unparsed++
+			break
+		}
+		if len(record) <= cols.max {
+			c.Unparsed++
+			continue
+		}
+		tzVal := ""
+		if cols.timezone != -1 {
+			tzVal = record[cols.timezone]
+		}
+		local, ok := LocalTime(record[cols.timestamp], tzVal, f.TimeLayout, tallinn)
+		if !ok {
+			c.Unparsed++
+			continue
+		}
+		at := local.Unix()
+		location := record[cols.location]
+		prev, seen := last[location]
+		if seen && at < prev {
+			c.Backwards++
+			continue
+		}
+		if !seen {
+			location = strings.Clone(location) // record's fields are reused
+		}
+		last[location] = at
+	}
+	return c, nil
+}
@@ -0,0 +1,42 @@
+package gymdata
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckFile(t *testing.T) {
+	dir := t.TempDir()
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	good := writeCSV(t, dir, "gym-stats-20251001.csv", header+
+		"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,\"{\"a\": 1}\"\n"+
+		"2025-10-01 10:00:00,EEST,2,T1,3,error,\"{\n"+ // a routine open quote in the response
+		"2025-10-01 10:02:00,EEST,1,Hipodroom,,success,\n")
+	c, err := CheckFile(Format{TimeLayout: DefaultTimeLayout}, good)
+	if err != nil || c != (FileCheck{Rows: 3}) {
+		t.Errorf("good file: %+v, %v", c, err)
+	}
+
+	bad := writeCSV(t, dir, "gym-stats-20251002.csv", header+
+		"2025-10-02 10:02:00,EEST,1,Hipodroom,12,success,\n"+
+		"2025-10-02 10:00:00,EEST,1,Hipodroom,11,success,\n"+ // back in time
+		"2025-10-02 10:00:00,EEST,2,T1,4,success,\n"+ // another gym's clock is its own
+		"yesterday,EEST,1,Hipodroom,11,success,\n"+
+		"2025-10-02 10:04:00,EEST,1\n")
+	c, err = CheckFile(Format{TimeLayout: DefaultTimeLayout}, bad)
+	if err != nil || c != (FileCheck{Rows: 5, Unparsed: 2, Backwards: 1}) || c.Bad() != 3 {
+		t.Errorf("bad file: %+v, %v", c, err)
+	}
+
+	for name, content := range map[string]string{
+		"gym-stats-20251003.csv": "",
+		"gym-stats-20251004.csv": "2025-10-04 10:00:00,EEST,1,Hipodroom,12,success,\n",
+	} {
+		if _, err := CheckFile(Format{}, writeCSV(t, dir, name, content)); err == nil {
+			t.Errorf("%s: no error for a file without a header", name)
+		}
+	}
+	if _, err := CheckFile(Format{}, dir+"/gym-stats-20251005.csv"); err == nil || !strings.Contains(err.Error(), "open") {
+		t.Errorf("missing file: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package gymdata
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// errUnterminatedQuote is what lineReader.Read reports for a line that ends
+// inside a quoted field.
+var errUnterminatedQuote = errors.New("unterminated quote")
+
+// lineReader splits CSV into records one physical line at a time. The
+// collector writes a reading per line but quotes the API's response body
+// without escaping it, so a body holding a newline or a stray quote, or a
+// line cut short by a crash, would make encoding/csv read on into the
+// following rows looking for the closing quote. Here the damage stays on
+// that line: the next record starts after the next newline whatever came
+// before it. Quoting otherwise follows csv.Reader with LazyQuotes: "" is a
+// quote inside a quoted field, and a quote that doesn't end one is kept.
+type lineReader struct {
+	br     *bufio.Reader
+	offset int64
+	ended  bool   // whether the last line read ended in a newline
+	line   []byte // a line longer than br's buffer, collected
+	rec    []byte // the record's unquoted fields, back to back
+	ends   []int  // where each field ends in rec
+	fields []string
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	return &lineReader{br: bufio.NewReader(r)}
+}
+
+// Read returns the next non-blank line's fields, which are only valid until
+// the next call. A line that ends inside a quoted field still yields its
+// fields, the open one closed at the end of the line, with
+// errUnterminatedQuote. At the end of the input it returns io.EOF.
+func (r *lineReader) Read() ([]string, error) {
+	for {
+		line, err := r.br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			r.line = append(r.line[:0], line...)
+			for err == bufio.ErrBufferFull {
+				line, err = r.br.ReadSlice('\n')
+				r.line = append(r.line, line...)
+			}
+			line = r.line
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(line) == 0 {
+			return nil, io.EOF
+		}
+		r.offset += int64(len(line))
+		r.ended = line[len(line)-1] == '\n'
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(line) == 0 {
+			continue
+		}
+		return r.split(line)
+	}
+}
+
+// InputOffset is how many bytes of input the records read so far took.
+func (r *lineReader) InputOffset() int64 {
+	return r.offset
+}
+
+// whole reports whether the input read so far ended with a whole line, so
+// that reading on from InputOffset starts a new one.
+func (r *lineReader) whole() bool {
+	return r.offset == 0 || r.ended
+}
+
+func (r *lineReader) split(line []byte) ([]string, error) {
+	var err error
+	r.rec, r.ends = r.rec[:0], r.ends[:0]
+	for {
+		if len(line) > 0 && line[0] == '"' {
+			line = line[1:]
+			for {
+				i := bytes.IndexByte(line, '"')
+				if i < 0 {
+					r.rec = append(r.rec, line...)
+					line = nil
+					err = errUnterminatedQuote
+					break
+				}
+				r.rec = append(r.rec, line[:i]...)
+				line = line[i+1:]
+				if len(line) == 0 || line[0] == ',' {
+					break
+				}
+				if line[0] == '"' {
+					line = line[1:]
+				}
+				r.rec = append(r.rec, '"')
+			}
+		} else {
+			i := bytes.IndexByte(line, ',')
+			if i < 0 {
+				i = len(line)
+			}
+			r.rec = append(r.rec, line[:i]...)
+			line = line[i:]
+		}
+		r.ends = append(r.ends, len(r.rec))
+		if len(line) == 0 {
+			break
+		}
+		line = line[1:] // the comma
+	}
+
+	// One string for the whole record, sliced per field, as csv.Reader does.
+	s := string(r.rec)
+	r.fields = r.fields[:0]
+	start := 0
+	for _, end := range r.ends {
+		r.fields = append(r.fields, s[start:end])
+		start = end
+	}
+	return r.fields, err
+}
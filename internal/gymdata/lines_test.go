@@ -0,0 +1,28 @@
+package gymdata
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLineReader(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want []string
+		err  error
+	}{
+		{`a,b,,c`, []string{"a", "b", "", "c"}, nil},
+		{`a,`, []string{"a", ""}, nil},
+		{`"a,b",c`, []string{"a,b", "c"}, nil},
+		{`"say ""hi""",x`, []string{`say "hi"`, "x"}, nil},
+		{`a,"{"total": 5}"`, []string{"a", `{"total": 5}`}, nil},
+		{`a b"c,d`, []string{`a b"c`, "d"}, nil},
+		{`a,"open`, []string{"a", "open"}, errUnterminatedQuote},
+	} {
+		got, err := newLineReader(strings.NewReader(tc.line + "\n")).Read()
+		if !slices.Equal(got, tc.want) || err != tc.err {
+			t.Errorf("%s: got %q, %v; want %q, %v", tc.line, got, err, tc.want, tc.err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+//go:build !js
+
+package gymdata
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Everything the package reads from or writes to the local file system is
+// here, so that a js/wasm build, which has none, can leave it out: see
+// local_js.go.
+
+// localSource reads daily files from the local file system.
+func localSource(string) Source {
+	return osSource{}
+}
+
+type osSource struct{}
+
+func (osSource) Glob(dir, pattern string) ([]string, error) {
+	return filepath.Glob(filepath.Join(dir, pattern))
+}
+
+func (osSource) Open(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+func (osSource) Stat(path string) (FileInfo, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return FileInfo{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// readRollupFile returns the rollup saved at path.
+func readRollupFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+// writeRollupFile saves a rollup at path, whole or not at all.
+func writeRollupFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,25 @@
+package gymdata
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A browser has no file system: daily files come from a source the caller
+// registers (a MemSource of the files it cached, say), and rollups are kept
+// in memory only. The parsed-file cache snapshot is left out altogether.
+
+var errNoFileSystem = errors.New("no local files in a js/wasm build")
+
+// localSource fails for any path no registered source claims.
+func localSource(path string) Source {
+	return errSource{fmt.Errorf("%s: %w; register a source for it", path, errNoFileSystem)}
+}
+
+func readRollupFile(string) ([]byte, error) {
+	return nil, errNoFileSystem
+}
+
+func writeRollupFile(string, []byte) error {
+	return nil
+}
@@ -0,0 +1,93 @@
+package gymdata
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemSource holds daily files in memory, under a root such as mem://gym/,
+// for a caller with no file system to read them from: a js/wasm build
+// crunching the files a browser cached, say. Register it with
+// RegisterSource and its paths load like any other.
+type MemSource struct {
+	root string
+
+	mu    sync.Mutex
+	files map[string]memFile // path -> contents
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemSource returns an empty source for paths under root, which gets a
+// trailing slash if it lacks one.
+func NewMemSource(root string) *MemSource {
+	if !strings.HasSuffix(root, "/") {
+		root += "/"
+	}
+	return &MemSource{root: root, files: map[string]memFile{}}
+}
+
+// Root is the root the source's paths start with.
+func (s *MemSource) Root() string {
+	return s.root
+}
+
+// Put stores data as the file name (gym-stats-YYYYMMDD.csv, or .csv.gz),
+// modified at modTime, replacing any there, and returns its path. The
+// caches see a new size or modTime as a changed file.
+func (s *MemSource) Put(name string, data []byte, modTime time.Time) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.root + name
+	s.files[p] = memFile{data: data, modTime: modTime}
+	return p
+}
+
+// Remove drops the file name, if there is one.
+func (s *MemSource) Remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, s.root+name)
+}
+
+func (s *MemSource) Glob(dir, pattern string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []string
+	for p := range s.files {
+		if ok, _ := path.Match(pattern, strings.TrimPrefix(p, s.root)); ok {
+			out = append(out, p)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (s *MemSource) Open(p string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[p]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+	return io.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+func (s *MemSource) Stat(p string) (FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[p]
+	if !ok {
+		return FileInfo{}, &fs.PathError{Op: "stat", Path: p, Err: fs.ErrNotExist}
+	}
+	return FileInfo{Size: int64(len(f.data)), ModTime: f.modTime}, nil
+}
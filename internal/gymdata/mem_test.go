@@ -0,0 +1,47 @@
+package gymdata
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMemSource(t *testing.T) {
+	src := NewMemSource("mem://test")
+	RegisterSource(src.Root(), src)
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	at := time.Date(2025, 10, 3, 0, 0, 0, 0, time.UTC) // after the rows, or they read as skewed
+	first := src.Put("gym-stats-20251001.csv", []byte(header+"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,\"{}\"\n"), at)
+	src.Put("gym-stats-20251002.csv", []byte(header+"2025-10-02 10:00:00,EEST,1,Hipodroom,20,success,\"{}\"\n"), at)
+	src.Put("notes.txt", []byte("not a daily file"), at)
+
+	files, err := InRange("mem://test", "2025-10-01", "2025-10-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"mem://test/gym-stats-20251001.csv", "mem://test/gym-stats-20251002.csv"}; !slices.Equal(files, want) {
+		t.Fatalf("files = %v, want %v", files, want)
+	}
+	list, rows, err := Load(Format{}, files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || len(list[0].Points) != 2 || rows.Total() != 2 {
+		t.Fatalf("got %+v (%d rows), want one series of 2 points", list, rows.Total())
+	}
+
+	// A file put again is read again, as a changed file on disk would be.
+	src.Put("gym-stats-20251001.csv", []byte(header+
+		"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,\"{}\"\n"+
+		"2025-10-01 10:02:00,EEST,1,Hipodroom,13,success,\"{}\"\n"), at.Add(time.Minute))
+	if list, _, err := Load(Format{}, []string{first}, nil); err != nil || len(list) != 1 || len(list[0].Points) != 2 {
+		t.Errorf("after Put: %+v, %v, want the new row too", list, err)
+	}
+
+	src.Remove("gym-stats-20251001.csv")
+	if _, err := Stat(first); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat of a removed file: %v, want not-exist", err)
+	}
+}
@@ -0,0 +1,160 @@
+package gymdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Action is what a row's status column means for the readers.
+type Action int
+
+const (
+	Exclude Action = iota // drop the row
+	Include               // use it like a success
+	Flag                  // use it, but mark the point
+)
+
+func parseStatusAction(s string) (Action, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "include":
+		return Include, nil
+	case "exclude":
+		return Exclude, nil
+	case "flag":
+		return Flag, nil
+	}
+	return Exclude, fmt.Errorf("unknown action %q (want include, exclude or flag)", s)
+}
+
+// StatusPolicy maps status values to actions. The zero value is the historic
+// behaviour: "success" is included and everything else excluded.
+type StatusPolicy struct {
+	actions  map[string]Action
+	fallback Action
+}
+
+// ParseStatusPolicy reads STATUS_POLICY: comma-separated status=action pairs,
+// e.g. "cached=include,stale=flag". "*" sets the action for unlisted statuses.
+func ParseStatusPolicy(s string) (StatusPolicy, error) {
+	p := StatusPolicy{actions: map[string]Action{}}
+	for _, pair := range splitList(s) {
+		status, action, ok := strings.Cut(pair, "=")
+		status = strings.TrimSpace(status)
+		if !ok || status == "" {
+			return StatusPolicy{}, fmt.Errorf("%q: want status=action", pair)
+		}
+		a, err := parseStatusAction(action)
+		if err != nil {
+			return StatusPolicy{}, fmt.Errorf("%q: %v", pair, err)
+		}
+		if status == "*" {
+			p.fallback = a
+			continue
+		}
+		p.actions[status] = a
+	}
+	return p, nil
+}
+
+func (p StatusPolicy) Action(status string) Action {
+	if a, ok := p.actions[status]; ok {
+		return a
+	}
+	if status == "success" {
+		return Include
+	}
+	return p.fallback
+}
+
+// RowCounts tallies how the policy treated the rows behind a response, so a
+// chart with holes can say how many rows it left out and why.
+type RowCounts struct {
+	Included int            `json:"included"`
+	Flagged  map[string]int `json:"flagged,omitempty"`
+	Excluded map[string]int `json:"excluded,omitempty"`
+	// Errors counts, by file and kind, the lines that couldn't be used in
+	// full: "short row", "bad timestamp", "bad number" (a metric cell that
+	// isn't blank but isn't a number either), "unterminated quote" (the row
+	// is still used) or "read error" (the rest of the file is lost).
+	Errors map[string]map[string]int `json:"errors,omitempty"`
+}
+
+// Add records one row. A nil *RowCounts ignores it, for callers that don't
+// report counts.
+func (c *RowCounts) Add(status string, a Action) {
+	if c == nil {
+		return
+	}
+	switch a {
+	case Include:
+		c.Included++
+	case Flag:
+		if c.Flagged == nil {
+			c.Flagged = map[string]int{}
+		}
+		c.Flagged[status]++
+	default:
+		if c.Excluded == nil {
+			c.Excluded = map[string]int{}
+		}
+		c.Excluded[status]++
+	}
+}
+
+// AddError records a line of file that couldn't be used in full. Like Add,
+// a nil *RowCounts ignores it.
+func (c *RowCounts) AddError(file, kind string) {
+	if c == nil {
+		return
+	}
+	if c.Errors == nil {
+		c.Errors = map[string]map[string]int{}
+	}
+	if c.Errors[file] == nil {
+		c.Errors[file] = map[string]int{}
+	}
+	c.Errors[file][kind]++
+}
+
+// Total is the number of rows read, whatever the policy did with them.
+func (c *RowCounts) Total() int {
+	n := c.Included
+	for _, v := range c.Flagged {
+		n += v
+	}
+	for _, v := range c.Excluded {
+		n += v
+	}
+	return n
+}
+
+// Merge adds o's counts to c's; a nil c ignores them, as Add does.
+func (c *RowCounts) Merge(o *RowCounts) {
+	if c == nil {
+		return
+	}
+	c.Included += o.Included
+	for status, n := range o.Flagged {
+		if c.Flagged == nil {
+			c.Flagged = map[string]int{}
+		}
+		c.Flagged[status] += n
+	}
+	for status, n := range o.Excluded {
+		if c.Excluded == nil {
+			c.Excluded = map[string]int{}
+		}
+		c.Excluded[status] += n
+	}
+	for file, kinds := range o.Errors {
+		if c.Errors == nil {
+			c.Errors = map[string]map[string]int{}
+		}
+		if c.Errors[file] == nil {
+			c.Errors[file] = map[string]int{}
+		}
+		for kind, n := range kinds {
+			c.Errors[file][kind] += n
+		}
+	}
+}
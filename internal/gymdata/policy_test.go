@@ -0,0 +1,56 @@
+package gymdata
+
+import "testing"
+
+func TestStatusPolicy(t *testing.T) {
+	var zero StatusPolicy
+	if zero.Action("success") != Include || zero.Action("cached") != Exclude {
+		t.Error("zero policy should include only success")
+	}
+
+	p, err := ParseStatusPolicy("cached=include, stale=flag, *=exclude")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Action("cached") != Include || p.Action("stale") != Flag || p.Action("500") != Exclude || p.Action("success") != Include {
+		t.Errorf("unexpected actions: %+v", p)
+	}
+	if p, _ := ParseStatusPolicy("*=flag"); p.Action("whatever") != Flag {
+		t.Error("* should set the fallback")
+	}
+	for _, bad := range []string{"cached", "cached=keep", "=include"} {
+		if _, err := ParseStatusPolicy(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestLoadStatusPolicy(t *testing.T) {
+	policy, _ := ParseStatusPolicy("cached=include,stale=flag")
+	tallinn := loadTallinn(t)
+	dir := t.TempDir()
+	file := writeCSV(t, dir, "gym-stats-20251001.csv",
+		"timestamp,timezone,location_id,location_name,user_count,status,response\n"+
+			"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,\"{}\"\n"+
+			"2025-10-01 10:02:00,EEST,1,Hipodroom,13,cached,\"{}\"\n"+
+			"2025-10-01 10:04:00,EEST,1,Hipodroom,13,stale,\"{}\"\n"+
+			"2025-10-01 10:06:00,EEST,1,Hipodroom,error,500,\"\"\n"+
+			"2025-10-01 10:08:00,EEST,1,Hipodroom,error,500,\"\"\n")
+	list, rows, err := Load(Format{Policy: policy}, []string{file}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows.Included != 2 || rows.Flagged["stale"] != 1 || rows.Excluded["500"] != 2 {
+		t.Errorf("rows = %+v", rows)
+	}
+	s := list[0]
+	if len(s.Points) != 3 || len(s.Flagged) != 1 || s.Flagged[0] != s.Points[2].At {
+		t.Fatalf("points = %+v, flagged %v, want the third flagged", s.Points, s.Flagged)
+	}
+
+	// A flagged reading marks the bucket it lands in.
+	Bucket(list, 60, tallinn)
+	if len(s.Points) != 1 || len(s.Flagged) != 1 || s.Flagged[0] != s.Points[0].At {
+		t.Errorf("bucketed = %+v, flagged %v, want one flagged point", s.Points, s.Flagged)
+	}
+}
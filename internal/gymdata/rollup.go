@@ -0,0 +1,257 @@
+package gymdata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// RollupResolutions are the bucket sizes, in minutes, kept pre-aggregated
+// for each daily file: 10-minute, hourly and daily.
+var RollupResolutions = []int{10, 60, 1440}
+
+// rollupBucket is a bucket's readings as a sum and a count, so buckets
+// combine into coarser ones, and across files, exactly.
+type rollupBucket struct {
+	At      int64   `json:"t"`
+	Sum     float64 `json:"s"`
+	N       int     `json:"n"`
+	Flagged bool    `json:"f,omitempty"`
+}
+
+type rollupSeries struct {
+	Location string                 `json:"location"`
+	Levels   map[int][]rollupBucket `json:"levels"` // resolution -> buckets
+}
+
+// rollupVersion is bumped when rollupFile changes, so older ones rebuild.
+const rollupVersion = 2
+
+// rollupFile is one daily file's headcount pre-aggregated in Tallinn, as
+// of the size, mtime and format it was built from. Strays counts readings
+// of other days than the file is named for, which its buckets can't be
+// stitched to their own day's file by.
+type rollupFile struct {
+	Version int            `json:"version"`
+	Size    int64          `json:"size"`
+	ModTime int64          `json:"modTime"` // UnixNano
+	Format  string         `json:"format"`
+	Strays  int            `json:"strays,omitempty"`
+	Rows    RowCounts      `json:"rows"`
+	Series  []rollupSeries `json:"series"`
+}
+
+var (
+	rollupMu    sync.Mutex
+	rollupCache = map[string]*rollupFile{} // rollup path -> decoded
+)
+
+// formatKey tells formats apart for the parse and rollup caches.
+func formatKey(f Format) string {
+	return fmt.Sprint(f.Columns, f.TimeLayout, f.Policy, f.AreaPattern, f.Aliases)
+}
+
+// RollupResolution is the coarsest kept resolution that buckets of
+// bucketMinutes are made of exactly, or 0 if there is none and the raw
+// readings must be read.
+func RollupResolution(bucketMinutes int) int {
+	best := 0
+	for _, r := range RollupResolutions {
+		if r <= bucketMinutes && bucketMinutes%r == 0 {
+			best = r
+		}
+	}
+	return best
+}
+
+// buildRollup aggregates csvFile's parse at every resolution.
+func buildRollup(csvFile string, parsed *parsedFile, info FileInfo, f Format) *rollupFile {
+	tallinn := Tallinn()
+	out := &rollupFile{Version: rollupVersion, Size: info.Size, ModTime: info.ModTime.UnixNano(), Format: formatKey(f), Rows: parsed.rows, Series: []rollupSeries{}}
+	start, end, dated := fileDay(csvFile)
+	for _, s := range parsed.series {
+		if s.Key.Metric != DefaultMetric {
+			continue
+		}
+		for _, p := range s.Points {
+			if dated && (p.At < start || p.At >= end) {
+				out.Strays++
+			}
+		}
+		rs := rollupSeries{Location: s.Key.Location, Levels: map[int][]rollupBucket{}}
+		flagged := map[int64]bool{}
+		for _, at := range s.Flagged {
+			flagged[at] = true
+		}
+		for _, res := range RollupResolutions {
+			var buckets []rollupBucket
+			for _, p := range s.Points {
+				b := bucketStart(p.At, res, tallinn)
+				if n := len(buckets); n == 0 || buckets[n-1].At != b {
+					buckets = append(buckets, rollupBucket{At: b})
+				}
+				last := &buckets[len(buckets)-1]
+				last.Sum += p.Y
+				last.N++
+				last.Flagged = last.Flagged || flagged[p.At]
+			}
+			rs.Levels[res] = buckets
+		}
+		out.Series = append(out.Series, rs)
+	}
+	return out
+}
+
+// rollupPath is where csvFile's rollup is kept in dir, or beside the file
+// if dir is empty.
+func rollupPath(dir, csvFile string) string {
+	if dir == "" {
+		dir = filepath.Dir(csvFile)
+	}
+	return filepath.Join(dir, BaseName(csvFile)+".json")
+}
+
+// Rollup brings csvFile's rollup in dir up to date with the file, as the
+// server does after each write so queries find it ready.
+func Rollup(f Format, csvFile, dir string) error {
+	_, err := currentRollup(f, csvFile, dir)
+	return err
+}
+
+// currentRollup returns csvFile's rollup, rebuilding and saving it if the
+// file or the format changed since it was built.
+func currentRollup(f Format, csvFile, dir string) (*rollupFile, error) {
+	info, err := Stat(csvFile)
+	if err != nil {
+		return nil, err
+	}
+	path := rollupPath(dir, csvFile)
+	current := func(r *rollupFile) bool {
+		return r != nil && r.Version == rollupVersion && r.Size == info.Size && r.ModTime == info.ModTime.UnixNano() && r.Format == formatKey(f)
+	}
+	rollupMu.Lock()
+	r := rollupCache[path]
+	rollupMu.Unlock()
+	if current(r) {
+		return r, nil
+	}
+	if data, err := readRollupFile(path); err == nil {
+		r = &rollupFile{}
+		if json.Unmarshal(data, r) != nil {
+			r = nil
+		}
+	}
+	if !current(r) {
+		parsed, err := cachedParse(f, csvFile, NormalizeMetrics(nil))
+		if err != nil {
+			return nil, err
+		}
+		r = buildRollup(csvFile, parsed, info, f)
+		if err := writeRollup(path, r); err != nil {
+			return nil, err
+		}
+	}
+	rollupMu.Lock()
+	defer rollupMu.Unlock()
+	if len(rollupCache) >= 4*DefaultCacheFiles {
+		rollupCache = map[string]*rollupFile{} // simple bound, as rangeCache has
+	}
+	rollupCache[path] = r
+	return r, nil
+}
+
+func writeRollup(path string, r *rollupFile) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return writeRollupFile(path, data)
+}
+
+// ErrNoRollup is LoadRollups' answer to a bucket size no kept resolution
+// makes up.
+var ErrNoRollup = errors.New("no rollup resolution for this bucket size")
+
+// LoadRollups is Load of the headcount followed by Bucket in Tallinn, read
+// from the files' rollups in dir (built or rebuilt as needed) rather than
+// their rows: the buckets and flags come out the same. It reports the
+// resolution it read, or 0 if a file holds other days' readings (see
+// Stitcher): those are read from the rows instead, until repaired. progress
+// is as for LoadProgress.
+func LoadRollups(f Format, csvFiles []string, dir string, bucketMinutes int, progress func(files int, rows *RowCounts)) ([]*Series, *RowCounts, int, error) {
+	res := RollupResolution(bucketMinutes)
+	if res == 0 {
+		return nil, nil, 0, ErrNoRollup
+	}
+	tallinn := Tallinn()
+	// Buckets merge as Bucket merges readings: in time order, a run at a
+	// time, so the hour repeated when clocks go back comes out alike.
+	type run struct {
+		s     *Series
+		start int64
+		sum   float64
+		n     int
+	}
+	flush := func(r *run) {
+		if r.n > 0 {
+			r.s.Points = append(r.s.Points, Point{At: r.start, Y: math.Round((r.sum/float64(r.n))*10) / 10})
+		}
+	}
+	rollups := make([]*rollupFile, len(csvFiles))
+	for i, csvFile := range csvFiles {
+		r, err := currentRollup(f, csvFile, dir)
+		if err != nil {
+			return nil, nil, res, fmt.Errorf("failed to process %s: %v", csvFile, err)
+		}
+		if r.Strays > 0 {
+			list, rows, err := LoadProgress(f, csvFiles, nil, progress)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			Bucket(list, bucketMinutes, tallinn)
+			return list, rows, 0, nil
+		}
+		rollups[i] = r
+	}
+	byLocation := map[string]*run{}
+	rows := &RowCounts{}
+	for i, r := range rollups {
+		for _, rs := range r.Series {
+			cur := byLocation[rs.Location]
+			if cur == nil {
+				cur = &run{s: &Series{Key: Key{Location: rs.Location, Metric: DefaultMetric}}}
+				byLocation[rs.Location] = cur
+			}
+			for _, b := range rs.Levels[res] {
+				at := bucketStart(b.At, bucketMinutes, tallinn)
+				if cur.n == 0 || at != cur.start {
+					flush(cur)
+					cur.start, cur.sum, cur.n = at, 0, 0
+				}
+				cur.sum += b.Sum
+				cur.n += b.N
+				if flagged := cur.s.Flagged; b.Flagged && (len(flagged) == 0 || flagged[len(flagged)-1] != at) {
+					cur.s.Flagged = append(flagged, at)
+				}
+			}
+		}
+		rows.Merge(&r.Rows)
+		if progress != nil {
+			progress(i+1, rows)
+		}
+	}
+
+	list := make([]*Series, 0, len(byLocation))
+	for _, cur := range byLocation {
+		flush(cur)
+		if len(cur.s.Points) > 0 {
+			list = append(list, cur.s)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key.Location < list[j].Key.Location })
+	return list, rows, res, nil
+}
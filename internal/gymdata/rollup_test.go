@@ -0,0 +1,78 @@
+package gymdata
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"gym/internal/fixtures"
+)
+
+func TestRollupResolution(t *testing.T) {
+	for bucket, want := range map[int]int{2: 0, 5: 0, 10: 10, 30: 10, 60: 60, 120: 60, 180: 60, 1440: 1440} {
+		if got := RollupResolution(bucket); got != want {
+			t.Errorf("RollupResolution(%d) = %d, want %d", bucket, got, want)
+		}
+	}
+}
+
+func TestLoadRollups(t *testing.T) {
+	tallinn := loadTallinn(t)
+	// Three days across the autumn clock change, with a gap.
+	start := time.Date(2025, 10, 25, 0, 0, 0, 0, tallinn)
+	spec := fixtures.Spec{Start: start, Days: 3, Noise: 2, Seed: 3, Gaps: []fixtures.Gap{{From: start.Add(30 * time.Hour), To: start.Add(33 * time.Hour)}}}
+	files, err := spec.Write(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	check := func(t *testing.T, bucket int) {
+		t.Helper()
+		want, wantRows, err := Load(Format{}, files, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		Bucket(want, bucket, tallinn)
+		got, rows, res, err := LoadRollups(Format{}, files, dir, bucket, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != RollupResolution(bucket) {
+			t.Errorf("resolution = %d, want %d", res, RollupResolution(bucket))
+		}
+		if rows.Included != wantRows.Included {
+			t.Errorf("rows = %d, want %d", rows.Included, wantRows.Included)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("series = %d, want %d", len(got), len(want))
+		}
+		for i := range want {
+			if got[i].Key != want[i].Key || !slices.Equal(got[i].Points, want[i].Points) || !slices.Equal(got[i].Flagged, want[i].Flagged) {
+				t.Errorf("%s: rollup differs from Load and Bucket", want[i].Key.Location)
+			}
+		}
+	}
+	for _, bucket := range []int{10, 30, 60, 120, 1440} {
+		check(t, bucket)
+	}
+
+	// A rewritten file's rollup is rebuilt, not read stale.
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(files[0], data[:len(data)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(files[0], later, later); err != nil {
+		t.Fatal(err)
+	}
+	check(t, 60)
+
+	if _, _, _, err := LoadRollups(Format{}, files, dir, 2, nil); err != ErrNoRollup {
+		t.Errorf("2-minute buckets: err = %v, want ErrNoRollup", err)
+	}
+}
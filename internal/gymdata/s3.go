@@ -0,0 +1,300 @@
+package gymdata
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// s3ListTTL lets the several listings and stats of one request share a
+	// bucket listing; a new reading shows up at most this much later.
+	s3ListTTL = 10 * time.Second
+	// emptySHA256 is the payload hash of a request without a body, which
+	// all but Put's are.
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Source reads the daily files from an S3-compatible bucket (AWS, MinIO,
+// Garage...) over its REST API with path-style URLs, signed with AWS
+// Signature V4. Without an access key requests go unsigned, for a public
+// bucket.
+type S3Source struct {
+	root      string // "s3://bucket/prefix/", what paths start with
+	endpoint  *url.URL
+	bucket    string
+	prefix    string // object key prefix, "" or ending in "/"
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+
+	mu       sync.Mutex
+	listed   map[string]FileInfo // path -> size, mtime
+	listedAt time.Time
+}
+
+// NewS3Source parses CSV_SOURCE's s3://bucket[/prefix] and the S3_* settings.
+func NewS3Source(source, endpoint, region, accessKey, secretKey string) (*S3Source, error) {
+	rest, ok := strings.CutPrefix(source, "s3://")
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if !ok || bucket == "" {
+		return nil, fmt.Errorf("CSV_SOURCE %q: want s3://bucket[/prefix]", source)
+	}
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		prefix += "/"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT %q: want an http(s) URL such as https://s3.eu-north-1.amazonaws.com", endpoint)
+	}
+	if (accessKey == "") != (secretKey == "") {
+		return nil, fmt.Errorf("S3_ACCESS_KEY and S3_SECRET_KEY go together")
+	}
+	return &S3Source{
+		root:      "s3://" + bucket + "/" + prefix,
+		endpoint:  u,
+		bucket:    bucket,
+		prefix:    prefix,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+// RegisterS3Source makes the source the one its paths resolve to, replacing
+// the previous config's after a reload.
+func RegisterS3Source(s *S3Source) {
+	RegisterSource(s.root, s)
+}
+
+// Root is the normalised s3://bucket/prefix/ the source's paths start with.
+func (s *S3Source) Root() string {
+	return s.root
+}
+
+func (s *S3Source) Glob(dir, pattern string) ([]string, error) {
+	listed, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for p := range listed {
+		if ok, _ := path.Match(pattern, strings.TrimPrefix(p, s.root)); ok {
+			out = append(out, p)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (s *S3Source) Stat(p string) (FileInfo, error) {
+	listed, err := s.list()
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if st, ok := listed[p]; ok {
+		return st, nil
+	}
+	resp, err := s.do("HEAD", s.key(p), nil, nil)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	resp.Body.Close()
+	mtime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return FileInfo{Size: resp.ContentLength, ModTime: mtime}, nil
+}
+
+func (s *S3Source) Open(p string) (io.ReadCloser, error) {
+	resp, err := s.do("GET", s.key(p), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Put uploads body as the object at path p, replacing any there.
+func (s *S3Source) Put(p string, body []byte) error {
+	if !strings.HasPrefix(p, s.root) {
+		return fmt.Errorf("%s is not under %s", p, s.root)
+	}
+	resp, err := s.do("PUT", s.key(p), nil, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	s.mu.Lock()
+	s.listed = nil // the next listing should show it
+	s.mu.Unlock()
+	return nil
+}
+
+// key is a path's object key.
+func (s *S3Source) key(p string) string {
+	return strings.TrimPrefix(p, "s3://"+s.bucket+"/")
+}
+
+// list returns the daily files directly under the prefix, from a listing at
+// most s3ListTTL old.
+func (s *S3Source) list() (map[string]FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listed != nil && time.Since(s.listedAt) < s3ListTTL {
+		return s.listed, nil
+	}
+	out := map[string]FileInfo{}
+	token := ""
+	for {
+		q := map[string]string{"list-type": "2", "prefix": s.prefix + "gym-stats-"}
+		if token != "" {
+			q["continuation-token"] = token
+		}
+		resp, err := s.do("GET", "", q, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string
+				LastModified time.Time
+				Size         int64
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("listing s3://%s/%s: %v", s.bucket, s.prefix, err)
+		}
+		for _, c := range page.Contents {
+			if name := strings.TrimPrefix(c.Key, s.prefix); !strings.Contains(name, "/") {
+				out[s.root+name] = FileInfo{Size: c.Size, ModTime: c.LastModified}
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			break
+		}
+		token = page.NextContinuationToken
+	}
+	s.listed, s.listedAt = out, time.Now()
+	return out, nil
+}
+
+// do sends a signed request for key ("" is the bucket itself), with body if
+// not nil, and returns the response if it succeeded.
+func (s *S3Source) do(method, key string, query map[string]string, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = s3Query(query)
+	var payload io.Reader
+	payloadHash := emptySHA256
+	if body != nil {
+		payload = bytes.NewReader(body)
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	req, err := http.NewRequest(method, u.String(), payload)
+	if err != nil {
+		return nil, err
+	}
+	if s.accessKey != "" {
+		signV4(req, s.region, s.accessKey, s.secretKey, payloadHash, time.Now())
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s %s", method, u.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// s3Escape percent-encodes everything but RFC 3986 unreserved characters,
+// as SigV4 wants: paths keep their slashes, query parts encode them too.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Query is the canonical query string: sorted, each part escaped. It is
+// also what gets sent, so the signed and the sent query can't differ.
+func s3Query(q map[string]string) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = s3Escape(k, true) + "=" + s3Escape(q[k], true)
+	}
+	return strings.Join(parts, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// signV4 adds AWS Signature V4 headers to a request whose body hashes to
+// payloadHash. Only host and the two x-amz headers are signed, which is all
+// S3 requires.
+func signV4(req *http.Request, region, accessKey, secretKey, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	escapedPath := req.URL.EscapedPath()
+	if escapedPath == "" {
+		escapedPath = "/"
+	}
+	const signed = "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		escapedPath,
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		signed,
+		payloadHash,
+	}, "\n")
+	sum := sha256.Sum256([]byte(canonical))
+	scope := day + "/" + region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+", SignedHeaders="+signed+", Signature="+signature)
+}
@@ -0,0 +1,99 @@
+package gymdata
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultTimeLayout is how the collector writes timestamps.
+const DefaultTimeLayout = "2006-01-02 15:04:05"
+
+// canonicalColumns are the header names the CSV readers look for.
+var canonicalColumns = map[string]bool{
+	"timestamp":     true,
+	"timezone":      true,
+	"location_id":   true,
+	"location_name": true,
+	"area":          true,
+	"user_count":    true,
+	"status":        true,
+}
+
+// ParseColumnMap reads CSV_COLUMNS: comma-separated canonical=Header pairs,
+// e.g. "location_name=Gym,user_count=Visitors". It returns the reverse,
+// header -> canonical, which is what reading a header row needs.
+func ParseColumnMap(s string) (map[string]string, error) {
+	out := map[string]string{}
+	seen := map[string]bool{}
+	for _, pair := range splitList(s) {
+		canonical, header, ok := strings.Cut(pair, "=")
+		canonical, header = strings.TrimSpace(canonical), strings.TrimSpace(header)
+		if !ok || header == "" {
+			return nil, fmt.Errorf("%q: want canonical=Header", pair)
+		}
+		if !canonicalColumns[canonical] {
+			return nil, fmt.Errorf("%q: unknown column %q", pair, canonical)
+		}
+		if seen[canonical] {
+			return nil, fmt.Errorf("%q mapped twice", canonical)
+		}
+		if _, dup := out[header]; dup {
+			return nil, fmt.Errorf("header %q mapped twice", header)
+		}
+		seen[canonical] = true
+		out[header] = canonical
+	}
+	return out, nil
+}
+
+// CanonicalHeaders renames a file's mapped header cells to their canonical
+// names, so every reader keeps matching on "timestamp", "user_count" and so
+// on. A canonical name the file also uses unmapped is hidden, so a mapped
+// column is never shadowed by a same-named one.
+func CanonicalHeaders(headers []string, columns map[string]string) []string {
+	if len(columns) == 0 {
+		return headers
+	}
+	mapped := map[string]bool{}
+	for _, canonical := range columns {
+		mapped[canonical] = true
+	}
+	out := make([]string, len(headers))
+	for i, h := range headers {
+		switch canonical, ok := columns[strings.TrimSpace(h)]; {
+		case ok:
+			out[i] = canonical
+		case mapped[h]:
+			out[i] = ""
+		default:
+			out[i] = h
+		}
+	}
+	return out
+}
+
+// CheckTimeLayout rejects a CSV_TIMESTAMP_FORMAT that cannot read back what
+// it writes, which is what a strftime-style "%Y-%m-%d" would do.
+func CheckTimeLayout(layout string) error {
+	ref := time.Date(2025, 10, 21, 18, 42, 0, 0, time.UTC)
+	back, err := time.Parse(layout, ref.Format(layout))
+	if err != nil {
+		return err
+	}
+	if !back.Equal(ref) {
+		return fmt.Errorf("%q does not keep the date and time to the minute (use Go's 2006-01-02 15:04:05 reference form)", layout)
+	}
+	return nil
+}
+
+// splitList splits a comma-separated value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
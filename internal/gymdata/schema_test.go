@@ -0,0 +1,63 @@
+package gymdata
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseColumnMap(t *testing.T) {
+	got, err := ParseColumnMap("location_name=Gym, user_count=Visitors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["Gym"] != "location_name" || got["Visitors"] != "user_count" || len(got) != 2 {
+		t.Errorf("got %v", got)
+	}
+	for _, bad := range []string{"gym=Gym", "user_count", "user_count=A,user_count=B", "status=X,timestamp=X"} {
+		if _, err := ParseColumnMap(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestCanonicalHeaders(t *testing.T) {
+	columns := map[string]string{"Visitors": "user_count"}
+	got := CanonicalHeaders([]string{"timestamp", "user_count", "Visitors"}, columns)
+	if got[0] != "timestamp" || got[1] != "" || got[2] != "user_count" {
+		t.Errorf("got %q, want the unmapped user_count hidden", got)
+	}
+}
+
+func TestCheckTimeLayout(t *testing.T) {
+	for _, ok := range []string{DefaultTimeLayout, "2006-01-02T15:04:05Z07:00", "02.01.2006 15:04"} {
+		if err := CheckTimeLayout(ok); err != nil {
+			t.Errorf("%q: %v", ok, err)
+		}
+	}
+	for _, bad := range []string{"%Y-%m-%d %H:%M:%S", "2006-01-02"} {
+		if err := CheckTimeLayout(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestLoadCustomSchema(t *testing.T) {
+	columns, _ := ParseColumnMap("timestamp=When,location_name=Gym,user_count=Visitors,status=Result")
+	f := Format{Columns: columns, TimeLayout: "2006-01-02T15:04:05Z07:00"}
+
+	dir := t.TempDir()
+	file := writeCSV(t, dir, "gym-stats-20251001.csv",
+		"When,Gym,Visitors,Result\n"+
+			"2025-10-01T07:00:00Z,Hipodroom,12,success\n"+
+			"2025-10-01T10:02:00+03:00,Hipodroom,14,success\n")
+	list, _, err := Load(f, []string{file}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Key.Location != "Hipodroom" || len(list[0].Points) != 2 {
+		t.Fatalf("got %+v", list)
+	}
+	if x := time.Unix(list[0].Points[0].At, 0).In(loadTallinn(t)).Format(time.RFC3339); x != "2025-10-01T10:00:00+03:00" {
+		t.Errorf("first point at %q, want the offset in the timestamp honoured", x)
+	}
+}
@@ -0,0 +1,555 @@
+package gymdata
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMetric is the headcount column every collector writes; it is the
+// only series returned unless a caller asks for others.
+const DefaultMetric = "user_count"
+
+// nonMetricColumns are the CSV columns that describe a reading rather than
+// measure something. Every other column is treated as a numeric metric.
+var nonMetricColumns = map[string]bool{
+	"timestamp":     true,
+	"timezone":      true,
+	"location_id":   true,
+	"location_name": true,
+	"area":          true,
+	"status":        true,
+	"response":      true,
+}
+
+// Format is how a deployment's daily files are written: another collector's
+// column names (CSV_COLUMNS), its timestamp layout (CSV_TIMESTAMP_FORMAT),
+// which statuses count (STATUS_POLICY), and how location names split into
+// club and area (AREA_PATTERN). The zero value reads the collector's own
+// files.
+type Format struct {
+	Columns     map[string]string // header -> canonical, from ParseColumnMap
+	TimeLayout  string
+	Policy      StatusPolicy
+	AreaPattern *regexp.Regexp    // from ParseAreaPattern; nil keeps names whole
+	Aliases     map[string]string // old name -> current, from ParseAliases
+}
+
+// Point is one reading held as a Unix time rather than an ISO string, so a
+// month of 2-minute samples costs 16 bytes a point until it is encoded.
+type Point struct {
+	At int64
+	Y  float64
+}
+
+// Key names a series.
+type Key struct {
+	Location string
+	Metric   string
+}
+
+// Label keeps the plain location name for the headcount so existing charts
+// are unchanged, and suffixes other metrics.
+func (k Key) Label() string {
+	if k.Metric == DefaultMetric {
+		return k.Location
+	}
+	return k.Location + " (" + k.Metric + ")"
+}
+
+// Series is one (location, metric) line of the chart. Flagged holds the
+// times of points from rows the status policy flags; it is usually empty, so
+// the marks are kept apart rather than widening every point.
+type Series struct {
+	Key     Key
+	Points  []Point
+	Flagged []int64
+}
+
+// NormalizeMetrics trims and de-duplicates the requested metric names,
+// falling back to the headcount when none are given.
+func NormalizeMetrics(metrics []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, m := range metrics {
+		m = strings.TrimSpace(m)
+		if m == "" || seen[m] {
+			continue
+		}
+		seen[m] = true
+		out = append(out, m)
+	}
+	if len(out) == 0 {
+		return []string{DefaultMetric}
+	}
+	return out
+}
+
+// Metrics lists the metric columns present in the headers of the given CSV
+// files, headcount first and the rest alphabetically.
+func Metrics(f Format, csvFiles []string) []string {
+	found := map[string]bool{}
+	for _, csvFile := range csvFiles {
+		file, err := Open(csvFile)
+		if err != nil {
+			continue
+		}
+		reader := csv.NewReader(file)
+		reader.LazyQuotes = true
+		reader.FieldsPerRecord = -1
+		headers, err := reader.Read()
+		file.Close()
+		if err != nil {
+			continue
+		}
+		for _, h := range CanonicalHeaders(headers, f.Columns) {
+			if h != "" && !nonMetricColumns[h] {
+				found[h] = true
+			}
+		}
+	}
+
+	metrics := make([]string, 0, len(found))
+	for m := range found {
+		if m != DefaultMetric {
+			metrics = append(metrics, m)
+		}
+	}
+	sort.Strings(metrics)
+	if found[DefaultMetric] {
+		metrics = append([]string{DefaultMetric}, metrics...)
+	}
+	return metrics
+}
+
+// Load reads the CSV files into one series per (location, metric), sorted
+// by location then requested metric order, points in time order, stitched
+// across midnight as Stitcher says. The counts
+// say how the status policy treated the rows read.
+func Load(f Format, csvFiles []string, metrics []string) ([]*Series, *RowCounts, error) {
+	return LoadProgress(f, csvFiles, metrics, nil)
+}
+
+// LoadProgress is Load reporting, after each file, how many files are done
+// and the row counts so far.
+func LoadProgress(f Format, csvFiles []string, metrics []string, progress func(files int, rows *RowCounts)) ([]*Series, *RowCounts, error) {
+	metrics = NormalizeMetrics(metrics)
+	st := NewStitcher()
+	rows := &RowCounts{}
+	for i, csvFile := range csvFiles {
+		if err := readFile(f, csvFile, metrics, st, rows); err != nil {
+			return nil, nil, fmt.Errorf("failed to process %s: %v", csvFile, err)
+		}
+		if progress != nil {
+			progress(i+1, rows)
+		}
+	}
+
+	metricOrder := make(map[string]int, len(metrics))
+	for i, m := range metrics {
+		metricOrder[m] = i
+	}
+	list := make([]*Series, 0, len(st.keys))
+	for _, s := range st.Series() {
+		if len(s.Points) == 0 {
+			continue // e.g. a metric column only other gyms' files have
+		}
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Key.Location != list[j].Key.Location {
+			return list[i].Key.Location < list[j].Key.Location
+		}
+		return metricOrder[list[i].Key.Metric] < metricOrder[list[j].Key.Metric]
+	})
+	return list, rows, nil
+}
+
+// readFile adds csvFile's readings to st and its row counts to rows,
+// parsing the file only if the cache has no copy of its current content.
+func readFile(f Format, csvFile string, metrics []string, st *Stitcher, rows *RowCounts) error {
+	parsed, err := cachedParse(f, csvFile, metrics)
+	if err != nil {
+		return err
+	}
+	// Copies, since callers bucket and trim what Load returns in place.
+	st.Add(csvFile, parsed.series)
+	rows.Merge(&parsed.rows)
+	return nil
+}
+
+// parsedFile is one daily file's series, in the order locations first
+// appear, and how the status policy treated its rows. cols and offset are
+// what extend needs to carry on once the collector appends: offset is where
+// the last line read ended, or -1 if the parse can't be continued (a .gz, or
+// a last line that hadn't been finished). skewed are the rows left out for
+// clock skew.
+type parsedFile struct {
+	series []*Series
+	rows   RowCounts
+	skewed []SkewedRow
+	cols   columns
+	offset int64
+}
+
+// columns are where a file's header put the columns the parse reads, -1 for
+// any it lacks. max is the widest of the fixed ones, last of all of them.
+// area is optional even within a file, so it counts towards neither.
+type columns struct {
+	timestamp, timezone, location, area, count, status int
+	metrics                                            []int
+	max, last                                          int
+}
+
+func findColumns(headers, metrics []string) (columns, error) {
+	c := columns{timestamp: -1, timezone: -1, location: -1, area: -1, count: -1, status: -1, metrics: make([]int, len(metrics))}
+	for i := range c.metrics {
+		c.metrics[i] = -1
+	}
+	for i, header := range headers {
+		switch header {
+		case "timestamp":
+			c.timestamp = i
+		case "timezone":
+			c.timezone = i
+		case "location_name":
+			c.location = i
+		case "area":
+			c.area = i
+		case "user_count":
+			c.count = i
+		case "status":
+			c.status = i
+		}
+		for m, name := range metrics {
+			if header == name {
+				c.metrics[m] = i
+			}
+		}
+	}
+	if c.timestamp == -1 || c.location == -1 || c.count == -1 || c.status == -1 {
+		return columns{}, fmt.Errorf("missing required columns in CSV")
+	}
+	c.max = max(c.timestamp, c.timezone, c.location, c.count, c.status)
+	c.last = c.max
+	for _, idx := range c.metrics {
+		c.last = max(c.last, idx)
+	}
+	return c, nil
+}
+
+// parseFile reads csvFile's successful readings into one series per
+// (location, metric), rounded down to the 2-minute collection grid.
+func parseFile(f Format, csvFile string, metrics []string) (*parsedFile, error) {
+	file, err := Open(csvFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV file: %v", err)
+	}
+	defer file.Close()
+
+	// A line at a time, so a broken row costs that row and no more
+	reader := newLineReader(file)
+
+	// Read header, renaming another collector's columns per CSV_COLUMNS
+	headers, err := reader.Read()
+	if err != nil && err != errUnterminatedQuote {
+		return nil, fmt.Errorf("failed to read CSV headers: %v", err)
+	}
+	cols, err := findColumns(CanonicalHeaders(headers, f.Columns), metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileSize int64 // unknown for .gz: the estimate needs uncompressed bytes
+	written := time.Now()
+	if info, err := Stat(csvFile); err == nil {
+		written = info.ModTime
+		if !strings.HasSuffix(csvFile, ".gz") {
+			fileSize = info.Size
+		}
+	}
+	parsed := &parsedFile{cols: cols, offset: -1}
+	parsed.readRows(f, reader, BaseName(csvFile), metrics, map[string][]*Series{}, newSkewCheck(written, nil), fileSize)
+	if _, ok := file.(io.Seeker); ok && reader.whole() {
+		parsed.offset = reader.InputOffset()
+	}
+	return parsed, nil
+}
+
+// extend is p with the lines appended to csvFile since p was parsed, for a
+// file that has only grown since, as the collector's and ingest's are. p is
+// shared through the cache, so the result is a new parsedFile whose series
+// are copied as they grow rather than appended to in place.
+func (p *parsedFile) extend(f Format, csvFile string, metrics []string) (*parsedFile, error) {
+	file, err := Open(csvFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV file: %v", err)
+	}
+	defer file.Close()
+	seeker, ok := file.(io.Seeker)
+	if !ok || p.offset < 0 {
+		return parseFile(f, csvFile, metrics)
+	}
+	if _, err := seeker.Seek(p.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	next := &parsedFile{series: make([]*Series, len(p.series)), skewed: slices.Clip(p.skewed), cols: p.cols, offset: -1}
+	next.rows.Merge(&p.rows)
+	byLocation := map[string][]*Series{}
+	for i, s := range p.series {
+		next.series[i] = &Series{Key: s.Key, Points: slices.Clip(s.Points), Flagged: slices.Clip(s.Flagged)}
+	}
+	for i := 0; len(metrics) > 0 && i < len(next.series); i += len(metrics) {
+		byLocation[next.series[i].Key.Location] = next.series[i : i+len(metrics)]
+	}
+	written := time.Now()
+	if info, err := Stat(csvFile); err == nil {
+		written = info.ModTime
+	}
+	reader := newLineReader(file)
+	next.readRows(f, reader, BaseName(csvFile), metrics, byLocation, newSkewCheck(written, p.series), 0)
+	if reader.whole() {
+		next.offset = p.offset + reader.InputOffset()
+	}
+	return next, nil
+}
+
+// readRows parses reader's lines into p, adding series for locations not in
+// byLocation yet and quarantining the rows skew finds. With fileSize known, the series are sized from the first
+// rows, once the row width and the set of locations are known, instead of
+// growing them by doubling.
+func (p *parsedFile) readRows(f Format, reader *lineReader, name string, metrics []string, byLocation map[string][]*Series, skew *skewCheck, fileSize int64) {
+	const sampleRows = 64
+	rows, cols := &p.rows, p.cols
+	tallinn := Tallinn()
+	for n := 0; ; n++ {
+		if n == sampleRows && fileSize > 0 && len(byLocation) > 0 {
+			perRow := float64(reader.InputOffset()) / sampleRows
+			want := int(float64(fileSize)/perRow)/len(byLocation) + 1
+			for _, list := range byLocation {
+				for _, sr := range list {
+					sr.Points = slices.Grow(sr.Points, want)
+				}
+			}
+		}
+
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err == errUnterminatedQuote && len(record)-1 <= cols.last {
+			// An open quote in a column read here: the line was garbled or
+			// cut short. One in the response column is routine, since the
+			// collector quotes the API's JSON without escaping it.
+			rows.AddError(name, "unterminated quote")
+			continue
+		}
+		if err != nil && err != errUnterminatedQuote {
+			// A truncated .gz, say: keep what was read.
+			rows.AddError(name, "read error")
+			break
+		}
+		if len(record) <= cols.max {
+			rows.AddError(name, "short row")
+			continue
+		}
+
+		// Skip what the status policy excludes (by default, non-success)
+		status := record[cols.status]
+		action := f.Policy.Action(status)
+		rows.Add(status, action)
+		if action == Exclude {
+			continue
+		}
+
+		tzVal := ""
+		if cols.timezone != -1 {
+			tzVal = record[cols.timezone]
+		}
+		local, ok := LocalTime(record[cols.timestamp], tzVal, f.TimeLayout, tallinn)
+		if !ok {
+			rows.AddError(name, "bad timestamp")
+			continue
+		}
+		// Round to the 2-minute grid. Zone offsets are whole hours, so this
+		// matches rounding the Tallinn wall clock the chart shows.
+		at := local.Unix() / 120 * 120
+
+		locationName := record[cols.location]
+		if cols.area != -1 || f.AreaPattern != nil || len(f.Aliases) > 0 {
+			area := ""
+			if cols.area != -1 && cols.area < len(record) {
+				area = record[cols.area]
+			}
+			locationName = f.Location(locationName, area)
+		}
+		if kind, ref := skew.check(locationName, at); kind != "" {
+			rows.AddError(name, kind)
+			p.skewed = append(p.skewed, SkewedRow{File: name, Location: strings.Clone(locationName),
+				Timestamp: strings.Clone(record[cols.timestamp]), At: at, Kind: kind, Ref: ref})
+			continue
+		}
+		list, ok := byLocation[locationName]
+		if !ok {
+			list = make([]*Series, len(metrics))
+			location := strings.Clone(locationName)
+			for m, name := range metrics {
+				list[m] = &Series{Key: Key{Location: location, Metric: name}}
+			}
+			p.series = append(p.series, list...)
+			byLocation[location] = list
+		}
+
+		// Each requested metric is its own series; a blank or non-numeric cell
+		// (e.g. a column an older file predates) drops only that metric's point.
+		for m, idx := range cols.metrics {
+			if idx == -1 || idx >= len(record) {
+				continue
+			}
+			cell := strings.TrimSpace(record[idx])
+			value, err := strconv.ParseFloat(cell, 64)
+			if err != nil {
+				if cell != "" {
+					rows.AddError(name, "bad number")
+				}
+				continue
+			}
+			list[m].Points = append(list[m].Points, Point{At: at, Y: value})
+			if action == Flag {
+				list[m].Flagged = append(list[m].Flagged, at)
+			}
+		}
+	}
+}
+
+// DefaultPoints is the point budget per series charts are bucketed to
+// unless a request sets its own.
+const DefaultPoints = 1200
+
+// bucketLadder are the bucket sizes chosen from, in minutes.
+var bucketLadder = []int{2, 5, 10, 15, 30, 60, 120, 180, 360, 720, 1440}
+
+// BucketMinutes chooses an aggregation interval so a wide range stays
+// readable (~1200 points per series) while short ranges keep raw 2-minute
+// detail.
+func BucketMinutes(from, to time.Time) int {
+	spanMinutes := to.Sub(from).Minutes()
+	if spanMinutes <= 0 {
+		return 2
+	}
+	target := spanMinutes / DefaultPoints
+	for _, step := range bucketLadder {
+		if float64(step) >= target {
+			return step
+		}
+	}
+	return bucketLadder[len(bucketLadder)-1]
+}
+
+// BucketMinutesFor chooses the finest interval that keeps a series over
+// [from, to) to at most points points, counting a part bucket at either
+// end. Past a day it is a whole number of days, so no range is too long.
+func BucketMinutesFor(from, to time.Time, points int) int {
+	spanMinutes := to.Sub(from).Minutes()
+	if spanMinutes <= 0 || points < 2 {
+		return 2
+	}
+	target := spanMinutes / float64(points-1)
+	for _, step := range bucketLadder {
+		if float64(step) >= target {
+			return step
+		}
+	}
+	return int(math.Ceil(target/1440)) * 1440
+}
+
+// weekEpoch is a Monday, so 7-day buckets are calendar weeks.
+var weekEpoch = time.Date(1970, 1, 5, 0, 0, 0, 0, time.UTC)
+
+// bucketStart floors at to its bucketMinutes bucket, aligned to midnight in
+// loc. Buckets of over a day are whole days counted from a Monday.
+func bucketStart(at int64, bucketMinutes int, loc *time.Location) int64 {
+	t := time.Unix(at, 0).In(loc)
+	if days := bucketMinutes / 1440; days > 1 {
+		n := int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Sub(weekEpoch).Hours() / 24)
+		n -= ((n % days) + days) % days
+		d := weekEpoch.AddDate(0, 0, n)
+		return time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, loc).Unix()
+	}
+	floored := ((t.Hour()*60 + t.Minute()) / bucketMinutes) * bucketMinutes
+	return time.Date(t.Year(), t.Month(), t.Day(), floored/60, floored%60, 0, 0, loc).Unix()
+}
+
+// Bucket averages each series into fixed buckets aligned to midnight in loc,
+// compacting the points in place. Empty buckets are dropped so gaps are
+// preserved; bucketMinutes <= 2 leaves the raw 2-minute readings alone.
+func Bucket(list []*Series, bucketMinutes int, loc *time.Location) {
+	if bucketMinutes <= 2 {
+		return
+	}
+	bucketOf := func(at int64) int64 { return bucketStart(at, bucketMinutes, loc) }
+	for _, s := range list {
+		out := s.Points[:0]
+		var start int64
+		var sum float64
+		count := 0
+		flush := func() {
+			if count > 0 {
+				out = append(out, Point{At: start, Y: math.Round((sum/float64(count))*10) / 10})
+			}
+		}
+		for _, p := range s.Points {
+			b := bucketOf(p.At)
+			if count == 0 || b != start {
+				flush()
+				start, sum, count = b, 0, 0
+			}
+			sum += p.Y
+			count++
+		}
+		flush()
+		s.Points = out
+
+		// A bucket is flagged when any reading in it was.
+		flagged := s.Flagged[:0]
+		for _, at := range s.Flagged {
+			if b := bucketOf(at); len(flagged) == 0 || flagged[len(flagged)-1] != b {
+				flagged = append(flagged, b)
+			}
+		}
+		s.Flagged = flagged
+	}
+}
+
+// Trim drops points (and flags) before from, and series left empty. Points
+// are in time order, so each series is cut at its first kept point.
+func Trim(list []*Series, from int64) []*Series {
+	return Window(list, from, math.MaxInt64)
+}
+
+// Window keeps the points (and flags) at or after from and before to, and
+// drops series left empty.
+func Window(list []*Series, from, to int64) []*Series {
+	out := list[:0]
+	for _, s := range list {
+		i := sort.Search(len(s.Points), func(i int) bool { return s.Points[i].At >= from })
+		j := sort.Search(len(s.Points), func(j int) bool { return s.Points[j].At >= to })
+		if i >= j {
+			continue
+		}
+		s.Points = s.Points[i:j]
+		i = sort.Search(len(s.Flagged), func(i int) bool { return s.Flagged[i] >= from })
+		j = sort.Search(len(s.Flagged), func(j int) bool { return s.Flagged[j] >= to })
+		s.Flagged = s.Flagged[i:j]
+		out = append(out, s)
+	}
+	return out
+}
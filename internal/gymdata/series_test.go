@@ -0,0 +1,383 @@
+package gymdata
+
+import (
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"gym/internal/fixtures"
+)
+
+func writeCSV(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testSeries builds a one-series list from ISO timestamps and values.
+func testSeries(t *testing.T, points ...any) []*Series {
+	t.Helper()
+	s := &Series{Key: Key{Location: "gym", Metric: DefaultMetric}}
+	for i := 0; i < len(points); i += 2 {
+		at, err := time.Parse("2006-01-02T15:04:05Z07:00", points[i].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Points = append(s.Points, Point{At: at.Unix(), Y: float64(points[i+1].(int))})
+	}
+	return []*Series{s}
+}
+
+func TestBucketMinutes(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("one day span stays raw", func(t *testing.T) {
+		got := BucketMinutes(base, base.AddDate(0, 0, 1))
+		if got != 2 {
+			t.Errorf("got %d, want 2", got)
+		}
+	})
+
+	t.Run("31 day span picks a mid ladder value", func(t *testing.T) {
+		got := BucketMinutes(base, base.AddDate(0, 0, 31))
+		if got <= 2 || got > 120 {
+			t.Errorf("got %d, want in (2, 120]", got)
+		}
+		if got != 60 {
+			t.Errorf("got %d, want 60", got)
+		}
+	})
+
+	t.Run("95 day span picks 120", func(t *testing.T) {
+		got := BucketMinutes(base, base.AddDate(0, 0, 95))
+		if got != 120 {
+			t.Errorf("got %d, want 120", got)
+		}
+	})
+
+	t.Run("five year span caps at 1440", func(t *testing.T) {
+		got := BucketMinutes(base, base.AddDate(5, 0, 0))
+		if got != 1440 {
+			t.Errorf("got %d, want 1440", got)
+		}
+	})
+
+	t.Run("negative span returns 2", func(t *testing.T) {
+		got := BucketMinutes(base.AddDate(0, 0, 1), base)
+		if got != 2 {
+			t.Errorf("got %d, want 2", got)
+		}
+	})
+
+	t.Run("zero span returns 2", func(t *testing.T) {
+		got := BucketMinutes(base, base)
+		if got != 2 {
+			t.Errorf("got %d, want 2", got)
+		}
+	})
+}
+
+func TestBucket(t *testing.T) {
+	plus3 := time.FixedZone("", 3*3600)
+	at := func(s *Series, i int) string {
+		return time.Unix(s.Points[i].At, 0).In(plus3).Format("2006-01-02T15:04:05Z07:00")
+	}
+
+	t.Run("bucketMinutes<=2 returns input unchanged", func(t *testing.T) {
+		for _, bm := range []int{0, 1, 2} {
+			in := testSeries(t, "2025-10-01T10:00:00+03:00", 6, "2025-10-01T10:20:00+03:00", 9)
+			Bucket(in, bm, plus3)
+			if len(in[0].Points) != 2 {
+				t.Errorf("bm=%d: points = %d, want 2", bm, len(in[0].Points))
+			}
+		}
+	})
+
+	t.Run("60 minute buckets average and preserve order", func(t *testing.T) {
+		in := testSeries(t,
+			"2025-10-01T10:00:00+03:00", 6,
+			"2025-10-01T10:20:00+03:00", 9,
+			"2025-10-01T10:40:00+03:00", 12,
+			"2025-10-01T11:10:00+03:00", 4)
+		Bucket(in, 60, plus3)
+		s := in[0]
+		if len(s.Points) != 2 {
+			t.Fatalf("points = %d, want 2", len(s.Points))
+		}
+		if got, want := at(s, 0), "2025-10-01T10:00:00+03:00"; got != want {
+			t.Errorf("points[0] at %q, want %q", got, want)
+		}
+		if math.Abs(s.Points[0].Y-9) > 1e-9 {
+			t.Errorf("points[0].Y = %v, want 9", s.Points[0].Y)
+		}
+		if got, want := at(s, 1), "2025-10-01T11:00:00+03:00"; got != want {
+			t.Errorf("points[1] at %q, want %q", got, want)
+		}
+		if math.Abs(s.Points[1].Y-4) > 1e-9 {
+			t.Errorf("points[1].Y = %v, want 4", s.Points[1].Y)
+		}
+	})
+
+	t.Run("averages rounded to one decimal", func(t *testing.T) {
+		in := testSeries(t,
+			"2025-10-01T10:00:00+03:00", 1,
+			"2025-10-01T10:30:00+03:00", 2,
+			"2025-10-01T10:50:00+03:00", 2)
+		Bucket(in, 60, plus3)
+		if len(in[0].Points) != 1 {
+			t.Fatalf("points = %d, want 1", len(in[0].Points))
+		}
+		// (1+2+2)/3 = 1.6666... rounds to 1.7
+		if math.Abs(in[0].Points[0].Y-1.7) > 1e-9 {
+			t.Errorf("Y = %v, want 1.7", in[0].Points[0].Y)
+		}
+	})
+
+	t.Run("buckets align to the requested zone", func(t *testing.T) {
+		// 10:30+03:00 is 03:30 in New York; 360-minute buckets start at 00:00 there.
+		ny, err := time.LoadLocation("America/New_York")
+		if err != nil {
+			t.Skipf("America/New_York tzdata unavailable: %v", err)
+		}
+		in := testSeries(t, "2025-10-01T10:30:00+03:00", 5)
+		Bucket(in, 360, ny)
+		if got, want := time.Unix(in[0].Points[0].At, 0).In(ny).Format(time.RFC3339), "2025-10-01T00:00:00-04:00"; got != want {
+			t.Errorf("at %q, want %q", got, want)
+		}
+	})
+
+	t.Run("7 day buckets are calendar weeks", func(t *testing.T) {
+		// 2025-10-01 is a Wednesday, 2025-10-06 the next Monday.
+		in := testSeries(t,
+			"2025-09-29T10:00:00+03:00", 2,
+			"2025-10-05T23:00:00+03:00", 4,
+			"2025-10-06T00:10:00+03:00", 8)
+		Bucket(in, 7*1440, plus3)
+		if len(in[0].Points) != 2 || at(in[0], 0) != "2025-09-29T00:00:00+03:00" || in[0].Points[0].Y != 3 || at(in[0], 1) != "2025-10-06T00:00:00+03:00" {
+			t.Errorf("points = %v, want 3 from Monday the 29th and 8 from the 6th", in[0].Points)
+		}
+	})
+}
+
+func TestBucketMinutesFor(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		span   time.Duration
+		points int
+		want   int
+	}{
+		{24 * time.Hour, 1200, 2},
+		{24 * time.Hour, 100, 15},
+		{31 * 24 * time.Hour, 1200, 60},
+		{5 * 365 * 24 * time.Hour, 1200, 2 * 1440},
+		{5 * 365 * 24 * time.Hour, 10, 203 * 1440},
+	} {
+		got := BucketMinutesFor(base, base.Add(tc.span), tc.points)
+		if got != tc.want {
+			t.Errorf("%v in %d points: got %d, want %d", tc.span, tc.points, got, tc.want)
+		}
+		// However the range falls against the buckets, it stays in budget.
+		if n := int(tc.span.Minutes())/got + 1; n > tc.points {
+			t.Errorf("%v in %d points: %d-minute buckets give up to %d", tc.span, tc.points, got, n)
+		}
+	}
+}
+
+func TestLoadMetrics(t *testing.T) {
+	loadTallinn(t)
+	dir := t.TempDir()
+	oldFile := writeCSV(t, dir, "gym-stats-20251001.csv",
+		"timestamp,timezone,location_id,location_name,user_count,status,response\n"+
+			"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,\"{}\"\n")
+	newFile := writeCSV(t, dir, "gym-stats-20251002.csv",
+		"timestamp,timezone,location_id,location_name,user_count,queue_length,status,response\n"+
+			"2025-10-02 10:00:00,EEST,1,Hipodroom,20,3,success,\"{}\"\n"+
+			"2025-10-02 10:02:00,EEST,1,Hipodroom,21,,success,\"{}\"\n")
+	files := []string{oldFile, newFile}
+
+	t.Run("default is headcount only", func(t *testing.T) {
+		list, _, err := Load(Format{}, files, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 || list[0].Key != (Key{Location: "Hipodroom", Metric: "user_count"}) {
+			t.Fatalf("got %+v, want one Hipodroom user_count series", list)
+		}
+		if len(list[0].Points) != 3 {
+			t.Errorf("points = %d, want 3", len(list[0].Points))
+		}
+	})
+
+	t.Run("extra metric skips blank cells and older files", func(t *testing.T) {
+		list, _, err := Load(Format{}, files, []string{"user_count", "queue_length"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 2 {
+			t.Fatalf("series = %d, want 2", len(list))
+		}
+		q := list[1]
+		if q.Key.Metric != "queue_length" || q.Key.Label() != "Hipodroom (queue_length)" {
+			t.Errorf("second series = %+v, want queue_length", q.Key)
+		}
+		if len(q.Points) != 1 || q.Points[0].Y != 3 {
+			t.Errorf("queue_length points = %+v, want one point of 3", q.Points)
+		}
+	})
+
+	t.Run("metrics discovered from headers", func(t *testing.T) {
+		got := Metrics(Format{}, files)
+		if len(got) != 2 || got[0] != "user_count" || got[1] != "queue_length" {
+			t.Errorf("got %v, want [user_count queue_length]", got)
+		}
+	})
+}
+
+func TestLoadRecovers(t *testing.T) {
+	loadTallinn(t)
+	dir := t.TempDir()
+	file := writeCSV(t, dir, "gym-stats-20251001.csv",
+		"timestamp,timezone,location_id,location_name,user_count,status,response\n"+
+			"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,\"{\"total\": 12}\"\n"+
+			// The legacy API's error page, its body quoted but not escaped:
+			// encoding/csv would read the rows below into its response.
+			"2025-10-01 10:02:00,EEST,1,Hipodroom,13,success,\"<html>\n"+
+			"<p class=\"x\">busy</p>\n"+
+			"2025-10-01 10:04:00,EEST,1,Hipodroom,parse_error,success,\"\"\n"+
+			"2025-10-01 1a:06:00,EEST,1,Hipodroom,15,success,\"{}\"\n"+
+			"2025-10-01 10:07:00,EEST,1,Hipodroom,\"16\n"+
+			"2025-10-01 10:08:00,EEST,1,Hipo\n"+
+			"\r\n"+
+			"2025-10-01 10:10:00,EEST,1,Hipodroom,17,success,\"{\"\"a\"\":1}\"\r\n")
+	list, rows, err := Load(Format{}, []string{file}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("series = %d, want 1", len(list))
+	}
+	var got []float64
+	for _, p := range list[0].Points {
+		got = append(got, p.Y)
+	}
+	if !slices.Equal(got, []float64{12, 13, 17}) {
+		t.Errorf("values = %v, want 12, 13 and 17", got)
+	}
+	want := map[string]int{"unterminated quote": 1, "short row": 2, "bad number": 1, "bad timestamp": 1}
+	if e := rows.Errors["gym-stats-20251001.csv"]; !maps.Equal(e, want) {
+		t.Errorf("errors = %v, want %v", rows.Errors, want)
+	}
+}
+
+func TestLoadDSTDays(t *testing.T) {
+	tallinn := loadTallinn(t)
+	for _, day := range fixtures.Transitions(2025, tallinn) {
+		for _, utc := range []bool{false, true} {
+			spec := fixtures.Spec{Start: day, Zone: tallinn, UTC: utc, Noise: 2, Seed: 1}
+			files, err := spec.Write(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			list, rows, err := Load(Format{}, files, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Every 2-minute reading of the 23- or 25-hour day, once each.
+			want := int(day.AddDate(0, 0, 1).Sub(day) / (2 * time.Minute))
+			if len(list) != len(fixtures.DefaultLocations) || rows.Included != want*len(list) {
+				t.Fatalf("%s utc=%v: %d series, %d rows; want %d of %d", day.Format("2006-01-02"), utc, len(list), rows.Included, len(fixtures.DefaultLocations), want)
+			}
+			for _, s := range list {
+				at := day.Unix()
+				for i, p := range s.Points {
+					if p.At != at {
+						t.Fatalf("%s utc=%v %s: point %d at %v, want %v", day.Format("2006-01-02"), utc, s.Key.Location, i,
+							time.Unix(p.At, 0).In(tallinn), time.Unix(at, 0).In(tallinn))
+					}
+					at += 120
+				}
+			}
+		}
+	}
+}
+
+func TestTrim(t *testing.T) {
+	list := append(testSeries(t, "2025-10-01T10:00:00Z", 1, "2025-10-01T11:00:00Z", 2, "2025-10-01T12:00:00Z", 3),
+		testSeries(t, "2025-10-01T09:00:00Z", 4)...)
+	list[0].Flagged = []int64{list[0].Points[0].At, list[0].Points[2].At}
+	cut := list[0].Points[1].At
+
+	got := Trim(list, cut)
+	if len(got) != 1 || len(got[0].Points) != 2 || got[0].Points[0].Y != 2 {
+		t.Fatalf("trimmed = %+v, want the first series from 11:00", got)
+	}
+	if len(got[0].Flagged) != 1 || got[0].Flagged[0] != got[0].Points[1].At {
+		t.Errorf("flagged = %v, want only 12:00", got[0].Flagged)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	list := append(testSeries(t, "2025-10-01T10:00:00Z", 1, "2025-10-01T11:00:00Z", 2, "2025-10-01T12:00:00Z", 3),
+		testSeries(t, "2025-10-01T13:00:00Z", 4)...)
+	list[0].Flagged = []int64{list[0].Points[0].At, list[0].Points[1].At}
+	from, to := list[0].Points[1].At, list[0].Points[2].At
+
+	got := Window(list, from, to)
+	if len(got) != 1 || len(got[0].Points) != 1 || got[0].Points[0].Y != 2 {
+		t.Fatalf("windowed = %+v, want the first series' 11:00 reading, to left out", got)
+	}
+	if len(got[0].Flagged) != 1 || got[0].Flagged[0] != from {
+		t.Errorf("flagged = %v, want only 11:00", got[0].Flagged)
+	}
+}
+
+// writeMonthCSVs writes days of collector output (4 gyms every 2 minutes) to a
+// temp dir.
+func writeMonthCSVs(b *testing.B, days int) []string {
+	b.Helper()
+	files, err := fixtures.Spec{Days: days, Noise: 3}.Write(b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	return files
+}
+
+func BenchmarkLoadMonth(b *testing.B) {
+	files := writeMonthCSVs(b, 30)
+	SetCacheFiles(0) // time the parse, not the cache
+	defer SetCacheFiles(DefaultCacheFiles)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := Load(Format{}, files, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBucketMonth(b *testing.B) {
+	loaded, _, err := Load(Format{}, writeMonthCSVs(b, 30), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	bucket := BucketMinutes(time.Time{}, time.Time{}.AddDate(0, 0, 30))
+	tallinn := Tallinn()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		list := make([]*Series, len(loaded))
+		for j, s := range loaded {
+			list[j] = &Series{Key: s.Key, Points: slices.Clone(s.Points)}
+		}
+		b.StartTimer()
+		Bucket(list, bucket, tallinn)
+	}
+}
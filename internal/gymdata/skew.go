@@ -0,0 +1,88 @@
+package gymdata
+
+import (
+	"slices"
+	"time"
+)
+
+// SkewTolerance is how far a row's timestamp may run ahead of its file's
+// last write, or behind the gym's latest reading, before the row is taken
+// for the collector's clock being wrong.
+const SkewTolerance = 10 * time.Minute
+
+// The kinds of clock-skewed row, as counted in RowCounts.Errors.
+const (
+	SkewFuture    = "future timestamp"
+	SkewBackwards = "backwards timestamp"
+)
+
+// SkewedRow is a row quarantined for an impossible timestamp: stamped
+// after its file was last written (SkewFuture), or going back over time
+// its gym already has readings for (SkewBackwards; backfilling a gap is
+// fine). Ref is the file's write time or the gym's latest reading.
+type SkewedRow struct {
+	File      string `json:"file"`
+	Location  string `json:"location"`
+	Timestamp string `json:"timestamp"` // as written
+	At        int64  `json:"at"`
+	Kind      string `json:"kind"`
+	Ref       int64  `json:"ref"`
+}
+
+// skewCheck follows one file's rows to tell skewed ones.
+type skewCheck struct {
+	written int64
+	latest  map[string]int64
+	seen    map[string]map[int64]bool
+}
+
+// newSkewCheck starts a file last written at written, carrying on from
+// series already read from it.
+func newSkewCheck(written time.Time, series []*Series) *skewCheck {
+	c := &skewCheck{written: written.Unix(), latest: map[string]int64{}, seen: map[string]map[int64]bool{}}
+	for _, s := range series {
+		for _, p := range s.Points {
+			c.note(s.Key.Location, p.At)
+		}
+	}
+	return c
+}
+
+func (c *skewCheck) note(location string, at int64) {
+	if c.seen[location] == nil {
+		c.seen[location] = map[int64]bool{}
+	}
+	c.seen[location][at] = true
+	c.latest[location] = max(c.latest[location], at)
+}
+
+// check says whether a row of location at at (on the 2-minute grid) is
+// skewed, and what against; rows that pass are noted.
+func (c *skewCheck) check(location string, at int64) (kind string, ref int64) {
+	tolerance := int64(SkewTolerance / time.Second)
+	if at > c.written+tolerance {
+		return SkewFuture, c.written
+	}
+	if latest, ok := c.latest[location]; ok && at < latest-tolerance {
+		seen := c.seen[location]
+		if seen[at-120] || seen[at] || seen[at+120] {
+			return SkewBackwards, latest
+		}
+	}
+	c.note(location, at)
+	return "", 0
+}
+
+// Skewed lists the rows of csvFiles quarantined for clock skew, in file
+// order. It shares Load's parse cache.
+func Skewed(f Format, csvFiles []string) ([]SkewedRow, error) {
+	var out []SkewedRow
+	for _, csvFile := range csvFiles {
+		parsed, err := cachedParse(f, csvFile, NormalizeMetrics(nil))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, parsed.skewed...)
+	}
+	return slices.Clip(out), nil
+}
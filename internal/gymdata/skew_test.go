@@ -0,0 +1,69 @@
+package gymdata
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSkewedRows(t *testing.T) {
+	dir := t.TempDir()
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	path := writeCSV(t, dir, "gym-stats-20251001.csv", header+
+		"2025-10-01 10:00:00,UTC,1,A,10,success,{}\n"+
+		"2025-10-01 10:02:00,UTC,1,A,11,success,{}\n"+
+		"2025-10-01 10:04:00,UTC,1,A,12,success,{}\n"+
+		"2025-10-01 12:00:00,UTC,1,A,20,success,{}\n"+
+		// The clock steps back an hour and a half, over readings A has...
+		"2025-10-01 10:02:00,UTC,1,A,99,success,{}\n"+
+		// ...but a backfill of the gap it had is fine, as is B's first reading.
+		"2025-10-01 11:00:00,UTC,1,A,15,success,{}\n"+
+		"2025-10-01 10:02:00,UTC,2,B,5,success,{}\n"+
+		// A day after the file was written.
+		"2025-10-02 12:00:00,UTC,1,A,77,success,{}\n")
+	written := time.Date(2025, 10, 1, 12, 5, 0, 0, time.UTC)
+	if err := os.Chtimes(path, written, written); err != nil {
+		t.Fatal(err)
+	}
+
+	list, rows, err := Load(Format{}, []string{path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range list {
+		for _, p := range s.Points {
+			if p.Y == 99 || p.Y == 77 {
+				t.Errorf("%s: skewed reading %v plotted", s.Key.Location, p)
+			}
+		}
+	}
+	if len(list) != 2 || len(list[0].Points) != 5 {
+		t.Errorf("A has %d points, want 5", len(list[0].Points))
+	}
+	errs := rows.Errors["gym-stats-20251001.csv"]
+	if errs[SkewBackwards] != 1 || errs[SkewFuture] != 1 {
+		t.Errorf("errors = %v, want one of each kind", errs)
+	}
+
+	skewed, err := Skewed(Format{}, []string{path})
+	if err != nil || len(skewed) != 2 {
+		t.Fatalf("Skewed = %+v, %v", skewed, err)
+	}
+	back, future := skewed[0], skewed[1]
+	if back.Kind != SkewBackwards || back.Location != "A" || back.Timestamp != "2025-10-01 10:02:00" || back.Ref != time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC).Unix() {
+		t.Errorf("backwards row = %+v", back)
+	}
+	if future.Kind != SkewFuture || future.Ref != written.Unix() {
+		t.Errorf("future row = %+v", future)
+	}
+
+	// Appended rows are checked against what the file already had.
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString("2025-10-01 10:04:00,UTC,2,B,6,success,{}\n2025-10-01 10:00:00,UTC,1,A,98,success,{}\n")
+	f.Close()
+	later := written.Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if skewed, _ := Skewed(Format{}, []string{path}); len(skewed) != 3 || skewed[2].Location != "A" || skewed[2].Kind != SkewBackwards {
+		t.Errorf("after append: %+v", skewed)
+	}
+}
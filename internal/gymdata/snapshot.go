@@ -0,0 +1,150 @@
+//go:build !js
+
+package gymdata
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"time"
+)
+
+// snapshotVersion changes whenever what a snapshot holds does; a snapshot
+// of another version is not read.
+const snapshotVersion = 2
+
+// snapshotEntry is a cacheEntry with its parse spelled out in exported
+// fields, for gob.
+type snapshotEntry struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+	Used    int64
+
+	Series []snapshotSeries
+	Rows   RowCounts
+	Skewed []SkewedRow
+	Cols   snapshotColumns
+	Offset int64
+}
+
+// snapshotSeries is a Series with its points and flags packed as chunks,
+// a few bytes a point rather than the 16 they take in memory.
+type snapshotSeries struct {
+	Key     Key
+	Points  []byte // EncodeChunk
+	Flagged []byte // EncodeTimes
+}
+
+type snapshotColumns struct {
+	Timestamp, Timezone, Location, Area, Count, Status int
+	Metrics                                            []int
+	Max, Last                                          int
+}
+
+type snapshot struct {
+	Version int
+	Entries []snapshotEntry
+}
+
+// SaveCacheSnapshot writes the parsed-file cache to path, for
+// LoadCacheSnapshot to restore after a restart, and returns how many files
+// it holds. The entries keep the size and mtime they were parsed at, so a
+// file changed in between is parsed again (or, if it only grew, extended)
+// as it would have been.
+func SaveCacheSnapshot(path string) (int, error) {
+	cacheMu.Lock()
+	snap := snapshot{Version: snapshotVersion, Entries: make([]snapshotEntry, 0, len(cache))}
+	for key, e := range cache {
+		p := e.parsed
+		c := p.cols
+		series := make([]snapshotSeries, len(p.series))
+		for i, s := range p.series {
+			series[i] = snapshotSeries{Key: s.Key, Points: EncodeChunk(s.Points), Flagged: EncodeTimes(s.Flagged)}
+		}
+		snap.Entries = append(snap.Entries, snapshotEntry{
+			Key: key, Size: e.size, ModTime: e.modTime, Used: e.used,
+			Series: series, Rows: p.rows, Skewed: p.skewed, Offset: p.offset,
+			Cols: snapshotColumns{c.timestamp, c.timezone, c.location, c.area, c.count, c.status, c.metrics, c.max, c.last},
+		})
+	}
+	cacheMu.Unlock()
+
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	bw := bufio.NewWriter(file)
+	if err := gob.NewEncoder(bw).Encode(snap); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := bw.Flush(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return len(snap.Entries), os.Rename(tmp, path)
+}
+
+// LoadCacheSnapshot fills the parsed-file cache from a snapshot
+// SaveCacheSnapshot wrote, keeping any file already parsed since, and
+// returns how many files it added. A missing snapshot adds none and is not an error. Entries are
+// checked against their files as they are used, like any other; the cache
+// is trimmed to its limit on the next parse or SetCacheFiles.
+func LoadCacheSnapshot(path string) (int, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var snap snapshot
+	if err := gob.NewDecoder(bufio.NewReader(file)).Decode(&snap); err != nil {
+		return 0, fmt.Errorf("reading %s: %v", path, err)
+	}
+	if snap.Version != snapshotVersion {
+		return 0, fmt.Errorf("%s is a version %d snapshot, want %d", path, snap.Version, snapshotVersion)
+	}
+
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	added := 0
+	for _, se := range snap.Entries {
+		if _, ok := cache[se.Key]; ok || cacheLimit == 0 {
+			continue
+		}
+		series := make([]*Series, len(se.Series))
+		for i, ss := range se.Series {
+			points, err := DecodeChunk(ss.Points)
+			if err != nil {
+				return added, fmt.Errorf("reading %s: %v", path, err)
+			}
+			flagged, err := DecodeTimes(ss.Flagged)
+			if err != nil {
+				return added, fmt.Errorf("reading %s: %v", path, err)
+			}
+			if len(flagged) == 0 {
+				flagged = nil
+			}
+			series[i] = &Series{Key: ss.Key, Points: points, Flagged: flagged}
+		}
+		c := se.Cols
+		cache[se.Key] = &cacheEntry{size: se.Size, modTime: se.ModTime, used: se.Used, parsed: &parsedFile{
+			series: series, rows: se.Rows, skewed: se.Skewed, offset: se.Offset,
+			cols: columns{c.Timestamp, c.Timezone, c.Location, c.Area, c.Count, c.Status, c.Metrics, c.Max, c.Last},
+		}}
+		cacheClock = max(cacheClock, se.Used)
+		added++
+	}
+	return added, nil
+}
@@ -0,0 +1,88 @@
+//go:build !js
+
+package gymdata
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"gym/internal/fixtures"
+)
+
+func TestCacheSnapshot(t *testing.T) {
+	loadTallinn(t)
+	dir := t.TempDir()
+	files, err := fixtures.Spec{Days: 2}.Write(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetCacheFiles(DefaultCacheFiles)
+	SetCacheFiles(0) // empty it
+	SetCacheFiles(DefaultCacheFiles)
+	want, wantRows, err := Load(Format{}, files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "cache.snapshot")
+	if n, err := SaveCacheSnapshot(path); err != nil || n != 2 {
+		t.Fatalf("save: %d, %v", n, err)
+	}
+	SetCacheFiles(0) // as after a restart
+	SetCacheFiles(DefaultCacheFiles)
+	if n, err := LoadCacheSnapshot(path); err != nil || n != 2 {
+		t.Fatalf("load: %d, %v", n, err)
+	}
+
+	var hits, parsed int
+	ParseObserver = func(hit bool, rows int, _ time.Duration) {
+		if hit {
+			hits++
+		}
+		parsed += rows
+	}
+	defer func() { ParseObserver = nil }()
+	got, rows, err := Load(Format{}, files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hits != 2 || parsed != 0 {
+		t.Errorf("%d hits, %d rows parsed; want both files from the snapshot", hits, parsed)
+	}
+	if len(got) != len(want) || rows.Total() != wantRows.Total() {
+		t.Fatalf("%d series, %d rows; want %d, %d", len(got), rows.Total(), len(want), wantRows.Total())
+	}
+	for i := range got {
+		if got[i].Key != want[i].Key || !slices.Equal(got[i].Points, want[i].Points) {
+			t.Errorf("series %d differs after the snapshot", i)
+		}
+	}
+
+	// A file that changed since is parsed again; the appended row extends
+	// the restored parse from its offset.
+	f, err := os.OpenFile(files[1], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("2025-10-02 23:59:00,EEST,99,Annex,5,success,{}\n")
+	f.Close()
+	hits, parsed = 0, 0
+	grown, _, err := Load(Format{}, files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hits != 1 || parsed != 1 || len(grown) != len(want)+1 {
+		t.Errorf("after an append: %d hits, %d rows parsed, %d series", hits, parsed, len(grown))
+	}
+
+	if n, err := LoadCacheSnapshot(filepath.Join(dir, "missing")); n != 0 || err != nil {
+		t.Errorf("missing snapshot: %d, %v", n, err)
+	}
+	os.WriteFile(path, []byte("not a snapshot"), 0o644)
+	if _, err := LoadCacheSnapshot(path); err == nil {
+		t.Error("no error for a corrupt snapshot")
+	}
+}
@@ -0,0 +1,118 @@
+package gymdata
+
+import (
+	"cmp"
+	"slices"
+	"sort"
+	"time"
+)
+
+// fileDay is the Tallinn day a daily file is named for, as Unix seconds
+// [start, end); ok is false for a name without a date.
+func fileDay(csvFile string) (start, end int64, ok bool) {
+	base := BaseName(csvFile)
+	if len(base) < 18 {
+		return 0, 0, false
+	}
+	d, err := time.ParseInLocation("20060102", base[10:18], Tallinn())
+	if err != nil {
+		return 0, 0, false
+	}
+	return d.Unix(), d.AddDate(0, 0, 1).Unix(), true
+}
+
+// stray is a reading from a file named for another day than its own, as
+// when the collector writes past midnight into yesterday's file.
+type stray struct {
+	Point
+	flagged bool
+	file    int
+}
+
+// Stitcher merges daily files' series into one per key, as Load does.
+// Around midnight two files can each hold a reading for the same 2-minute
+// slot: the one a file was written with past midnight, and its next day's
+// own, or a row both files got. A slot keeps one reading, the one from the
+// file named for its day, or else from the first file added that has it,
+// so no bucket across the boundary counts it twice.
+type Stitcher struct {
+	keys   []Key
+	home   map[Key]*Series
+	strays map[Key][]stray
+	files  int
+}
+
+func NewStitcher() *Stitcher {
+	return &Stitcher{home: map[Key]*Series{}, strays: map[Key][]stray{}}
+}
+
+// Add takes csvFile's series, copying their points.
+func (st *Stitcher) Add(csvFile string, series []*Series) {
+	start, end, dated := fileDay(csvFile)
+	st.files++
+	for _, s := range series {
+		dst := st.home[s.Key]
+		if dst == nil {
+			dst = &Series{Key: s.Key}
+			st.home[s.Key] = dst
+			st.keys = append(st.keys, s.Key)
+		}
+		inDay := func(at int64) bool { return !dated || at >= start && at < end }
+		if !slices.ContainsFunc(s.Points, func(p Point) bool { return !inDay(p.At) }) {
+			dst.Points = append(dst.Points, s.Points...)
+			dst.Flagged = append(dst.Flagged, s.Flagged...)
+			continue
+		}
+		strayFlags := map[int64]bool{}
+		for _, at := range s.Flagged {
+			if inDay(at) {
+				dst.Flagged = append(dst.Flagged, at)
+			} else {
+				strayFlags[at] = true
+			}
+		}
+		for _, p := range s.Points {
+			if inDay(p.At) {
+				dst.Points = append(dst.Points, p)
+			} else {
+				st.strays[s.Key] = append(st.strays[s.Key], stray{p, strayFlags[p.At], st.files})
+			}
+		}
+	}
+}
+
+// Series returns the merged series in the order their keys were first
+// added, points and flags in time order. Series may be empty.
+func (st *Stitcher) Series() []*Series {
+	list := make([]*Series, 0, len(st.keys))
+	for _, k := range st.keys {
+		s := st.home[k]
+		byTime := func(a, b Point) int { return cmp.Compare(a.At, b.At) }
+		if !slices.IsSortedFunc(s.Points, byTime) {
+			slices.SortStableFunc(s.Points, byTime)
+		}
+		if strays := st.strays[k]; len(strays) > 0 {
+			// Stable, so a slot's strays stay in the order their files came.
+			sort.SliceStable(strays, func(i, j int) bool { return strays[i].At < strays[j].At })
+			home := s.Points
+			kept, first := false, 0
+			for i, sr := range strays {
+				if i == 0 || strays[i-1].At != sr.At {
+					n := sort.Search(len(home), func(j int) bool { return home[j].At >= sr.At })
+					kept, first = n == len(home) || home[n].At != sr.At, sr.file
+				}
+				if !kept || sr.file != first {
+					continue
+				}
+				s.Points = append(s.Points, sr.Point)
+				if sr.flagged {
+					s.Flagged = append(s.Flagged, sr.At)
+				}
+			}
+			slices.SortStableFunc(s.Points, byTime)
+		}
+		slices.Sort(s.Flagged)
+		list = append(list, s)
+	}
+	return list
+}
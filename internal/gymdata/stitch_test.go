@@ -0,0 +1,72 @@
+package gymdata
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLoadStitchesMidnight(t *testing.T) {
+	tallinn := loadTallinn(t)
+	dir := t.TempDir()
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	row := func(ts, name, n string) string { return ts + ",EEST,1," + name + "," + n + ",success,{}\n" }
+	// Past midnight the collector kept writing October 1st's file: its
+	// 00:00:30 rounds to the slot October 2nd's own 00:01:10 does, and both
+	// files got 00:02. Kristiine's 00:00 is only in October 1st's.
+	files := []string{
+		writeCSV(t, dir, "gym-stats-20251001.csv", header+
+			row("2025-10-01 23:58:00", "Hipodroom", "10")+
+			row("2025-10-02 00:00:30", "Hipodroom", "11")+
+			row("2025-10-02 00:02:00", "Hipodroom", "12")+
+			row("2025-10-01 23:58:00", "Kristiine", "5")+
+			row("2025-10-02 00:00:00", "Kristiine", "4")),
+		writeCSV(t, dir, "gym-stats-20251002.csv", header+
+			row("2025-10-02 00:01:10", "Hipodroom", "20")+
+			row("2025-10-02 00:02:00", "Hipodroom", "12")+
+			row("2025-10-02 00:04:00", "Hipodroom", "14")+
+			row("2025-10-02 00:02:00", "Kristiine", "3")),
+	}
+	at := func(clock string) int64 {
+		ts, _ := time.ParseInLocation("2006-01-02 15:04", clock, tallinn)
+		return ts.Unix()
+	}
+
+	for _, order := range [][]string{files, {files[1], files[0]}} {
+		list, _, err := Load(Format{}, order, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 2 {
+			t.Fatalf("series = %d, want 2", len(list))
+		}
+		want := []Point{{at("2025-10-01 23:58"), 10}, {at("2025-10-02 00:00"), 20}, {at("2025-10-02 00:02"), 12}, {at("2025-10-02 00:04"), 14}}
+		if !slices.Equal(list[0].Points, want) {
+			t.Errorf("Hipodroom = %v, want %v: one reading a slot, October 2nd's own", list[0].Points, want)
+		}
+		want = []Point{{at("2025-10-01 23:58"), 5}, {at("2025-10-02 00:00"), 4}, {at("2025-10-02 00:02"), 3}}
+		if !slices.Equal(list[1].Points, want) {
+			t.Errorf("Kristiine = %v, want %v: a slot only the day before has is kept", list[1].Points, want)
+		}
+	}
+
+	// A 10-minute bucket across the boundary counts each slot once, and the
+	// rollups, which can't tell the slots apart, read the rows instead.
+	want, _, _ := Load(Format{}, files, nil)
+	Bucket(want, 10, tallinn)
+	if p := want[0].Points; len(p) != 2 || p[1] != (Point{at("2025-10-02 00:00"), 15.3}) {
+		t.Errorf("10-minute buckets = %v, want 00:00 the mean of 20, 12 and 14", p)
+	}
+	got, _, res, err := LoadRollups(Format{}, files, t.TempDir(), 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != 0 {
+		t.Errorf("resolution = %d, want 0 for rows read", res)
+	}
+	for i := range want {
+		if !slices.Equal(got[i].Points, want[i].Points) {
+			t.Errorf("%s: rollups %v, want %v", want[i].Key.Location, got[i].Points, want[i].Points)
+		}
+	}
+}
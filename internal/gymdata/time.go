@@ -0,0 +1,86 @@
+package gymdata
+
+import (
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // container images often have no zoneinfo
+)
+
+var (
+	tallinnOnce sync.Once
+	tallinnLoc  *time.Location
+
+	// The abbreviations fix the offset, which the wall clock alone can't in
+	// the hour repeated when summer time ends.
+	eest = time.FixedZone("EEST", 3*3600)
+	eet  = time.FixedZone("EET", 2*3600)
+)
+
+// Tallinn is the gyms' own zone, which timestamps default to. It is loaded
+// once, from the system's zoneinfo or else the copy built in, so neither the
+// host's TZ nor its tzdata matter.
+func Tallinn() *time.Location {
+	tallinnOnce.Do(func() {
+		loc, err := time.LoadLocation("Europe/Tallinn")
+		if err != nil {
+			loc = time.FixedZone("EET", 2*3600)
+		}
+		tallinnLoc = loc
+	})
+	return tallinnLoc
+}
+
+// ParseTimestamp reads the collector's fixed "2006-01-02 15:04:05" layout
+// by hand, falling back to time.Parse for anything unusual or for a custom
+// CSV_TIMESTAMP_FORMAT. It runs once per CSV row, where time.Parse's general
+// layout handling dominates.
+func ParseTimestamp(ts, layout string, loc *time.Location) (time.Time, bool) {
+	if layout == "" {
+		layout = DefaultTimeLayout // a zero Format, as in tests
+	}
+	if layout == DefaultTimeLayout && len(ts) == 19 && ts[4] == '-' && ts[7] == '-' && ts[10] == ' ' && ts[13] == ':' && ts[16] == ':' {
+		n := func(i, j int) int {
+			v := 0
+			for ; i < j; i++ {
+				c := ts[i] - '0'
+				if c > 9 {
+					return -1
+				}
+				v = v*10 + int(c)
+			}
+			return v
+		}
+		y, mo, d, h, mi, sec := n(0, 4), n(5, 7), n(8, 10), n(11, 13), n(14, 16), n(17, 19)
+		// Days past the 28th need a month-length check; leave those to time.Parse.
+		if y >= 0 && mo >= 1 && mo <= 12 && d >= 1 && d <= 28 && h >= 0 && h < 24 && mi >= 0 && mi < 60 && sec >= 0 && sec < 60 {
+			return time.Date(y, time.Month(mo), d, h, mi, sec, 0, loc), true
+		}
+	}
+	t, err := time.ParseInLocation(layout, ts, loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// LocalTime converts a logged (timestamp, timezone) pair to Tallinn local
+// time. Historic rows are logged in UTC; recent ones carry EEST/EET, which
+// are Tallinn's own summer/winter zones, so their wall-clock is already
+// local. A layout that carries its own offset wins over the timezone column.
+func LocalTime(tsStr, tzStr, layout string, tallinn *time.Location) (time.Time, bool) {
+	loc := tallinn
+	switch tzStr {
+	case "EEST": // what the collector writes today; skip normalising
+		loc = eest
+	case "EET":
+		loc = eet
+	default:
+		switch strings.ToUpper(strings.TrimSpace(tzStr)) {
+		case "", "UTC", "GMT", "Z":
+			loc = time.UTC
+		}
+	}
+	t, ok := ParseTimestamp(tsStr, layout, loc)
+	return t.In(tallinn), ok
+}
@@ -0,0 +1,133 @@
+package gymdata
+
+import (
+	"testing"
+	"time"
+)
+
+func loadTallinn(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("Europe/Tallinn")
+	if err != nil {
+		t.Skipf("Europe/Tallinn tzdata unavailable: %v", err)
+	}
+	return loc
+}
+
+func TestLocalTime(t *testing.T) {
+	tallinn := loadTallinn(t)
+
+	t.Run("UTC summer converts to UTC+3", func(t *testing.T) {
+		got, ok := LocalTime("2025-07-01 09:00:00", "UTC", DefaultTimeLayout, tallinn)
+		if !ok {
+			t.Fatal("expected ok == true")
+		}
+		if got.Hour() != 12 {
+			t.Errorf("hour = %d, want 12", got.Hour())
+		}
+		wantInstant := time.Date(2025, 7, 1, 9, 0, 0, 0, time.UTC)
+		if !got.Equal(wantInstant) {
+			t.Errorf("instant = %v, want %v", got.UTC(), wantInstant)
+		}
+	})
+
+	t.Run("UTC winter converts to UTC+2", func(t *testing.T) {
+		got, ok := LocalTime("2025-12-01 09:00:00", "UTC", DefaultTimeLayout, tallinn)
+		if !ok {
+			t.Fatal("expected ok == true")
+		}
+		if got.Hour() != 11 {
+			t.Errorf("hour = %d, want 11", got.Hour())
+		}
+	})
+
+	t.Run("EEST wall-clock preserved as local", func(t *testing.T) {
+		got, ok := LocalTime("2026-07-18 16:35:39", "EEST", DefaultTimeLayout, tallinn)
+		if !ok {
+			t.Fatal("expected ok == true")
+		}
+		if got.Hour() != 16 || got.Minute() != 35 {
+			t.Errorf("wall-clock = %02d:%02d, want 16:35", got.Hour(), got.Minute())
+		}
+		if got.Location() != tallinn {
+			t.Errorf("location = %v, want Europe/Tallinn", got.Location())
+		}
+	})
+
+	t.Run("EET wall-clock preserved as local", func(t *testing.T) {
+		got, ok := LocalTime("2025-12-01 09:00:00", "EET", DefaultTimeLayout, tallinn)
+		if !ok {
+			t.Fatal("expected ok == true")
+		}
+		if got.Hour() != 9 {
+			t.Errorf("hour = %d, want 9 (wall-clock preserved)", got.Hour())
+		}
+	})
+
+	t.Run("repeated autumn hour told apart by zone", func(t *testing.T) {
+		summer, ok1 := LocalTime("2025-10-26 03:30:00", "EEST", DefaultTimeLayout, tallinn)
+		winter, ok2 := LocalTime("2025-10-26 03:30:00", "EET", DefaultTimeLayout, tallinn)
+		if !ok1 || !ok2 {
+			t.Fatal("expected ok == true")
+		}
+		if got := winter.Sub(summer); got != time.Hour {
+			t.Errorf("EET - EEST = %v, want 1h", got)
+		}
+	})
+
+	t.Run("empty tz treated as UTC", func(t *testing.T) {
+		got, ok := LocalTime("2025-07-01 09:00:00", "", DefaultTimeLayout, tallinn)
+		if !ok {
+			t.Fatal("expected ok == true")
+		}
+		if got.Hour() != 12 {
+			t.Errorf("hour = %d, want 12", got.Hour())
+		}
+	})
+
+	t.Run("GMT and Z aliases and case-insensitivity", func(t *testing.T) {
+		for _, tz := range []string{"GMT", "Z", "  utc  ", "z"} {
+			got, ok := LocalTime("2025-07-01 09:00:00", tz, DefaultTimeLayout, tallinn)
+			if !ok {
+				t.Fatalf("tz %q: expected ok == true", tz)
+			}
+			if got.Hour() != 12 {
+				t.Errorf("tz %q: hour = %d, want 12", tz, got.Hour())
+			}
+		}
+	})
+
+	t.Run("invalid timestamp returns false", func(t *testing.T) {
+		got, ok := LocalTime("not-a-timestamp", "UTC", DefaultTimeLayout, tallinn)
+		if ok {
+			t.Errorf("expected ok == false, got %v", got)
+		}
+		if !got.IsZero() {
+			t.Errorf("expected zero time, got %v", got)
+		}
+	})
+}
+
+func TestParseTimestamp(t *testing.T) {
+	for _, ts := range []string{"2025-10-01 18:42:07", "2024-02-29 23:59:59", "2025-12-31 00:00:00"} {
+		want, _ := time.ParseInLocation("2006-01-02 15:04:05", ts, time.UTC)
+		got, ok := ParseTimestamp(ts, DefaultTimeLayout, time.UTC)
+		if !ok || !got.Equal(want) {
+			t.Errorf("%s: got %v, %v; want %v", ts, got, ok, want)
+		}
+	}
+	for _, ts := range []string{"2025-02-30 10:00:00", "2025-13-01 10:00:00", "2025-10-01 1a:00:00", "2025-10-01T10:00:00", ""} {
+		if _, ok := ParseTimestamp(ts, DefaultTimeLayout, time.UTC); ok {
+			t.Errorf("%q should not parse", ts)
+		}
+	}
+}
+
+func BenchmarkLocalTime(b *testing.B) {
+	tallinn := Tallinn()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		LocalTime("2025-10-01 18:42:00", "EEST", DefaultTimeLayout, tallinn)
+		LocalTime("2025-03-01 18:42:00", "UTC", DefaultTimeLayout, tallinn)
+	}
+}
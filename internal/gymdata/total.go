@@ -0,0 +1,85 @@
+package gymdata
+
+import (
+	"math"
+	"slices"
+)
+
+// Total sums the metric's series at the given locations (all of them if none
+// are given) into one series under location, at every instant any of them
+// has a reading, so the chain's whole load can be charted. A gym without a
+// reading at that instant is interpolated from its readings either side
+// when they are at most maxGap seconds apart. Instants some gym can't be
+// given a value at, because it hasn't started, has stopped or is in a longer
+// gap, are left out rather than summing fewer gyms. It returns nil when no
+// series matches.
+func Total(list []*Series, metric, location string, locations []string, maxGap int64) *Series {
+	var parts []*Series
+	for _, s := range list {
+		if s.Key.Metric == metric && (len(locations) == 0 || slices.Contains(locations, s.Key.Location)) && len(s.Points) > 0 {
+			parts = append(parts, s)
+		}
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+
+	var instants []int64
+	for _, s := range parts {
+		for _, p := range s.Points {
+			instants = append(instants, p.At)
+		}
+	}
+	slices.Sort(instants)
+	instants = slices.Compact(instants)
+
+	total := &Series{Key: Key{Location: location, Metric: metric}}
+	next := make([]int, len(parts)) // each part's first point at or after the instant
+	for _, at := range instants {
+		sum, ok := 0.0, true
+		for i, s := range parts {
+			v, j, found := valueAt(s, next[i], at, maxGap)
+			next[i] = j
+			sum += v
+			ok = ok && found
+		}
+		if ok {
+			total.Points = append(total.Points, Point{At: at, Y: sum})
+		}
+	}
+	return total
+}
+
+// Share gives each series its percentage of its metric's sum, instant by
+// instant, for a 100%-stacked chart of where people are. The series are put
+// on one axis first, a gym missing an instant interpolated as Align's
+// FillLinear does; one that can't be is left out of that instant's sum and
+// its share is NaN, as every share is when the sum is 0. Shares are rounded
+// to 0.1. list itself is not changed.
+func Share(list []*Series, maxGap int64) []*Series {
+	aligned := Align(list, FillLinear, maxGap)
+	sums := map[string][]float64{}
+	for _, s := range aligned {
+		sum := sums[s.Key.Metric]
+		if sum == nil {
+			sum = make([]float64, len(s.Points))
+			sums[s.Key.Metric] = sum
+		}
+		for i, p := range s.Points {
+			if !math.IsNaN(p.Y) {
+				sum[i] += p.Y
+			}
+		}
+	}
+	for _, s := range aligned {
+		sum := sums[s.Key.Metric]
+		for i := range s.Points {
+			if sum[i] > 0 {
+				s.Points[i].Y = math.Round(s.Points[i].Y/sum[i]*1000) / 10
+			} else {
+				s.Points[i].Y = math.NaN()
+			}
+		}
+	}
+	return aligned
+}
@@ -0,0 +1,53 @@
+package gymdata
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestTotal(t *testing.T) {
+	a := &Series{Key: Key{Location: "A", Metric: DefaultMetric}, Points: []Point{{At: 0, Y: 10}, {At: 120, Y: 20}, {At: 240, Y: 30}, {At: 1200, Y: 5}}}
+	b := &Series{Key: Key{Location: "B", Metric: DefaultMetric}, Points: []Point{{At: 60, Y: 4}, {At: 180, Y: 6}, {At: 300, Y: 8}}}
+	q := &Series{Key: Key{Location: "A", Metric: "queue_length"}, Points: []Point{{At: 120, Y: 99}}}
+	list := []*Series{a, b, q}
+
+	total := Total(list, DefaultMetric, "Total", nil, 600)
+	if total.Key != (Key{Location: "Total", Metric: DefaultMetric}) {
+		t.Errorf("key = %+v", total.Key)
+	}
+	// 0: B hasn't started. 60: A is 15 between 10 and 20. 120: B is 5 between
+	// 4 and 6. 180: A is 25. 240: B is 7. 300: A's next reading is too far
+	// off. 1200: B has stopped.
+	want := []Point{{At: 60, Y: 19}, {At: 120, Y: 25}, {At: 180, Y: 31}, {At: 240, Y: 37}}
+	if !slices.Equal(total.Points, want) {
+		t.Errorf("points = %v, want %v", total.Points, want)
+	}
+
+	// A's 240..1200 gap is too long to bridge at 600s, but one gym alone
+	// needs no bridging.
+	if only := Total(list, DefaultMetric, "Total", []string{"A"}, 600); len(only.Points) != 4 {
+		t.Errorf("A alone = %v, want its 4 readings", only.Points)
+	}
+	if none := Total(list, DefaultMetric, "Total", []string{"Nowhere"}, 600); none != nil {
+		t.Errorf("no match = %+v, want nil", none)
+	}
+}
+
+func TestShare(t *testing.T) {
+	a := &Series{Key: Key{Location: "A", Metric: DefaultMetric}, Points: []Point{{At: 0, Y: 30}, {At: 120, Y: 10}, {At: 240, Y: 0}}}
+	b := &Series{Key: Key{Location: "B", Metric: DefaultMetric}, Points: []Point{{At: 60, Y: 10}, {At: 120, Y: 30}, {At: 240, Y: 0}}}
+	got := Share([]*Series{a, b}, 600)
+	// 0: B hasn't started, so A is all of it. 60: A is 20 between 30 and 10.
+	// 240: nobody in, no shares.
+	want := []Point{{At: 0, Y: 100}, {At: 60, Y: 66.7}, {At: 120, Y: 25}}
+	if !slices.Equal(got[0].Points[:3], want) || !math.IsNaN(got[0].Points[3].Y) {
+		t.Errorf("A = %v, want %v then NaN", got[0].Points, want)
+	}
+	if !math.IsNaN(got[1].Points[0].Y) || got[1].Points[1].Y != 33.3 || got[1].Points[2].Y != 75 {
+		t.Errorf("B = %v", got[1].Points)
+	}
+	if a.Points[0].Y != 30 {
+		t.Error("Share changed its input")
+	}
+}
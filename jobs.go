@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+const (
+	jobsDir  = "gym-jobs"     // <id>.json per job, and <id>.result.json once done
+	jobsKeep = 24 * time.Hour // finished jobs are pruned after this
+
+	// Unfinished jobs a data directory, and one submitter, may have: each is
+	// a file on disk that outlives a restart.
+	jobsMaxQueued   = 32
+	jobsMaxPerActor = 4
+)
+
+// Job is a queued /api/range or /generate-data-range build, writing
+// gym-data.json if Write is set (the latter, for admins). Its record is
+// rewritten as it goes, so progress and results survive a restart;
+// unfinished jobs are queued again on startup.
+type Job struct {
+	ID         string           `json:"id"`
+	Status     string           `json:"status"` // queued, running, done, failed
+	Request    DateRangeRequest `json:"request"`
+	Write      bool             `json:"write,omitempty"`
+	Files      int              `json:"files"`
+	FilesDone  int              `json:"filesDone"`
+	RowsParsed int              `json:"rowsParsed"`
+	Result     string           `json:"result,omitempty"`
+	Error      string           `json:"error,omitempty"`
+	Code       string           `json:"code,omitempty"` // the error's, as APIError has it
+	Created    string           `json:"created"`
+	Started    string           `json:"started,omitempty"`
+	Finished   string           `json:"finished,omitempty"`
+	Actor      string           `json:"actor,omitempty"` // for the audit entry; not returned
+	IP         string           `json:"ip,omitempty"`
+}
+
+// jobRunner is one data directory's job queue. Jobs run one at a time: each
+// already reads every file of its range, so running two at once only
+// doubles the memory without finishing either sooner.
+type jobRunner struct {
+	mu    sync.Mutex
+	cfg   *Config
+	dir   string // fixed per runner, so read without mu
+	jobs  map[string]*Job
+	queue []string
+	wake  chan struct{}
+}
+
+var (
+	jobRunnersMu sync.Mutex
+	jobRunners   = map[string]*jobRunner{}
+)
+
+// jobsFor returns the job queue for cfg's data directory, loading its job
+// records and starting its worker on first use.
+func jobsFor(cfg *Config) *jobRunner {
+	jobRunnersMu.Lock()
+	defer jobRunnersMu.Unlock()
+	if jr := jobRunners[cfg.DataDir]; jr != nil {
+		jr.mu.Lock()
+		jr.cfg = cfg // follow reloads
+		jr.mu.Unlock()
+		return jr
+	}
+	jr := &jobRunner{cfg: cfg, dir: cfg.path(jobsDir), jobs: map[string]*Job{}, wake: make(chan struct{}, 1)}
+	jr.load(time.Now())
+	jobRunners[cfg.DataDir] = jr
+	go jr.run()
+	if len(jr.queue) > 0 {
+		jr.wake <- struct{}{}
+	}
+	return jr
+}
+
+// resumeJobs picks up jobs a previous run left queued or running, for the
+// base directory and every tenant's.
+func resumeJobs(c *Config) {
+	configs := []*Config{c}
+	for _, t := range c.Tenants {
+		configs = append(configs, t)
+	}
+	for _, cfg := range configs {
+		if _, err := os.Stat(cfg.path(jobsDir)); err == nil {
+			jobsFor(cfg)
+		}
+	}
+}
+
+func (jr *jobRunner) path(name string) string {
+	return filepath.Join(jr.dir, name)
+}
+
+// load reads the job records, drops those finished more than jobsKeep ago
+// along with their results, and queues the unfinished ones oldest first.
+func (jr *jobRunner) load(now time.Time) {
+	names, _ := filepath.Glob(jr.path("*.json"))
+	var unfinished []*Job
+	for _, name := range names {
+		if strings.HasSuffix(name, ".result.json") {
+			continue
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		var j Job
+		if err := json.Unmarshal(data, &j); err != nil || j.ID == "" {
+			log.Printf("jobs: skipping %s: %v", name, err)
+			continue
+		}
+		if finished, err := time.Parse(time.RFC3339, j.Finished); err == nil && now.Sub(finished) > jobsKeep {
+			os.Remove(name)
+			os.Remove(jr.path(j.ID + ".result.json"))
+			continue
+		}
+		jr.jobs[j.ID] = &j
+		if j.Status == "queued" || j.Status == "running" {
+			j.Status, j.FilesDone, j.RowsParsed = "queued", 0, 0
+			unfinished = append(unfinished, &j)
+		}
+	}
+	sort.Slice(unfinished, func(a, b int) bool { return unfinished[a].Created < unfinished[b].Created })
+	for _, j := range unfinished {
+		jr.queue = append(jr.queue, j.ID)
+	}
+}
+
+// save writes j's record; callers hold jr.mu.
+func (jr *jobRunner) save(j *Job) {
+	if err := os.MkdirAll(jr.dir, 0o755); err != nil {
+		log.Printf("jobs: %v", err)
+		return
+	}
+	if err := writeFileAtomic(jr.path(j.ID+".json"), j); err != nil {
+		log.Printf("jobs: %v", err)
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// submit records and queues a range build. It is errBusy when the
+// directory already has jobsMaxQueued unfinished jobs, or the submitter
+// jobsMaxPerActor: the actor, or for callers without a name their address.
+func (jr *jobRunner) submit(req DateRangeRequest, write bool, actor, ip string) (Job, error) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	same := func(j *Job) bool {
+		if actor == "" || actor == "anonymous" {
+			return j.Actor == actor && j.IP == ip
+		}
+		return j.Actor == actor
+	}
+	queued, mine := 0, 0
+	for _, j := range jr.jobs {
+		if j.Status != "queued" && j.Status != "running" {
+			continue
+		}
+		queued++
+		if same(j) {
+			mine++
+		}
+	}
+	if queued >= jobsMaxQueued || mine >= jobsMaxPerActor {
+		return Job{}, errBusy
+	}
+	j := &Job{
+		ID:      newJobID(),
+		Status:  "queued",
+		Request: req,
+		Write:   write,
+		Created: time.Now().UTC().Format(time.RFC3339),
+		Actor:   actor,
+		IP:      ip,
+	}
+	jr.jobs[j.ID] = j
+	jr.queue = append(jr.queue, j.ID)
+	jr.save(j)
+	select {
+	case jr.wake <- struct{}{}:
+	default:
+	}
+	return *j, nil
+}
+
+func (jr *jobRunner) get(id string) (Job, bool) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	j, ok := jr.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+func (jr *jobRunner) run() {
+	for range jr.wake {
+		for {
+			jr.mu.Lock()
+			if len(jr.queue) == 0 {
+				jr.mu.Unlock()
+				break
+			}
+			id := jr.queue[0]
+			jr.queue = jr.queue[1:]
+			jr.mu.Unlock()
+			jr.process(id)
+		}
+	}
+}
+
+// update applies fn to the job and saves it.
+func (jr *jobRunner) update(id string, fn func(j *Job)) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	if j := jr.jobs[id]; j != nil {
+		fn(j)
+		jr.save(j)
+	}
+}
+
+// process builds one job's range through buildRange, so it shares (and
+// fills) rangeCache with the synchronous endpoint, and writes the response
+// the endpoint would have sent to the job's result file. It waits for a
+// MAX_HEAVY_REQUESTS slot first, as a synchronous build would, so jobs of
+// every data directory count against the one process-wide limit.
+func (jr *jobRunner) process(id string) {
+	done := admitJob(currentConfig())
+	defer done()
+	jr.mu.Lock()
+	cfg, j := jr.cfg, *jr.jobs[id]
+	jr.mu.Unlock()
+	jr.update(id, func(j *Job) {
+		j.Status, j.Started = "running", time.Now().UTC().Format(time.RFC3339)
+	})
+
+	ctx, span := startTrace(context.Background(), "job generate-data-range", "")
+	span.set("job.id", j.ID)
+	err := jr.build(ctx, cfg, j)
+	span.finish(err)
+	jr.update(id, func(j *Job) {
+		j.Finished = time.Now().UTC().Format(time.RFC3339)
+		if err != nil {
+			j.Status, j.Error, j.Code = "failed", err.Error(), apiError(http.StatusInternalServerError, err).Code
+			return
+		}
+		j.Status, j.Result = "done", "/api/jobs/"+j.ID+"/result"
+	})
+}
+
+func (jr *jobRunner) build(ctx context.Context, cfg *Config, j Job) error {
+	req := j.Request
+	outZone, err := requestZone(req.TZ, gymdata.Tallinn())
+	if err != nil {
+		return err
+	}
+	pf, err := requestPointFormat(outZone, req.Timestamps, req.Points)
+	if err != nil {
+		return err
+	}
+	rollUp, err := requestAreas(req.Areas)
+	if err != nil {
+		return err
+	}
+	if _, _, err := requestFill(req.Fill); err != nil {
+		return err
+	}
+	if _, err := requestValues(req.Values); err != nil {
+		return err
+	}
+	includeClosed, err := requestClosed(req.Closed)
+	if err != nil {
+		return err
+	}
+	window, err := parseRangeWindow(req.From, req.To, outZone)
+	if err != nil {
+		return err
+	}
+	csvFiles, err := traceDiscover(ctx, cfg.csvDir(), window.fromDay, window.toDay)
+	if err != nil {
+		return err
+	}
+	jr.update(j.ID, func(j *Job) { j.Files = len(csvFiles) })
+
+	resp := GenerateResponse{
+		Success:     true,
+		Message:     "Date range data generated successfully",
+		Annotations: annotationsBetween(cfg, window.from, window.to, outZone),
+	}
+	var list []*gymdata.Series
+	if len(csvFiles) == 0 {
+		resp.Message = fmt.Sprintf("No data for %s to %s", req.From, req.To)
+		resp.Meta = emptyMeta(req, outZone)
+	} else {
+		metrics := gymdata.NormalizeMetrics(req.Metrics)
+		res, bucketMinutes, hit, err := buildRange(ctx, cfg, req, csvFiles, outZone, j.Write, func(files int, rows *gymdata.RowCounts) {
+			jr.update(j.ID, func(j *Job) { j.FilesDone, j.RowsParsed = files, rows.Total() })
+		})
+		if j.Write && !hit {
+			entry := AuditEntry{Action: "generate-data-range", Actor: j.Actor, IP: j.IP,
+				Params: map[string]any{"from": req.From, "to": req.To, "metrics": metrics, "files": len(csvFiles), "job": j.ID}}
+			if err != nil {
+				entry.Error = err.Error()
+			}
+			appendAudit(cfg, entry)
+		}
+		if err != nil {
+			return err
+		}
+		jr.update(j.ID, func(j *Job) { j.FilesDone, j.RowsParsed = len(csvFiles), res.rows.Total() })
+		resp.Snapshot = res.snapshot
+		list, resp.Rows, resp.Outliers = withFill(withDerived(withTotal(withShare(withAreas(withoutClosed(res.list, chartClosed(cfg, includeClosed)), rollUp, bucketMinutes), req.Values, bucketMinutes), req.Total, bucketMinutes), cfg, metrics, bucketMinutes), req.Fill, bucketMinutes), res.rows, res.outliers
+		resp.Weather = chartWeather(cfg, req.Weather, list, bucketMinutes, pf)
+		resp.Meta = chartMeta(csvFiles, res.rows, res.list, bucketMinutes, res.built, hit, outZone)
+		resp.Meta.From, resp.Meta.To, resp.Meta.ResolutionMinutes = req.From, req.To, res.resolution
+		resp.Output = fmt.Sprintf("Generated from %d files (%s to %s) in job %s\nFound %d locations with data (bucket: %d min)",
+			len(csvFiles), req.From, req.To, j.ID, len(res.list), bucketMinutes)
+	}
+
+	path := jr.path(j.ID + ".result.json")
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if err := writeGenerateResponse(file, resp, list, pf); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// submitRangeJob answers POST /api/range?async=1, or /generate-data-range's
+// with write set: the range is checked and queued, and the reply points at
+// the job to poll.
+func submitRangeJob(w http.ResponseWriter, r *http.Request, dateRange DateRangeRequest, write bool) {
+	fail := func(err error) { writeError(w, http.StatusBadRequest, err) }
+	outZone, err := requestZone(dateRange.TZ, gymdata.Tallinn())
+	if err != nil {
+		fail(err)
+		return
+	}
+	window, err := parseRangeWindow(dateRange.From, dateRange.To, outZone)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if window.fromDay > window.toDay {
+		fail(fieldErr("to", ErrBadRange, errors.New("from must not be after to")))
+		return
+	}
+	cfg := requestConfig(r)
+	_, actor, _ := requestRole(r, cfg)
+	j, err := jobsFor(cfg).submit(dateRange, write, actor, clientIP(r))
+	if err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter(currentConfig())))
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	if e := historyFor(r); e != nil {
+		e.Async, e.From, e.To = true, dateRange.From, dateRange.To
+	}
+	w.Header().Set("Location", "/api/jobs/"+j.ID)
+	w.WriteHeader(http.StatusAccepted)
+	j.Actor, j.IP = "", ""
+	json.NewEncoder(w).Encode(j)
+}
+
+// jobsHandler reports a job's progress, and serves its result once done.
+//
+//	GET /api/jobs/ID
+//	GET /api/jobs/ID/result
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, sub, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/jobs"), "/"), "/")
+	jr := jobsFor(requestConfig(r))
+	j, ok := jr.get(id)
+	if !ok || (sub != "" && sub != "result") {
+		writeError(w, http.StatusNotFound, errors.New("no such job"))
+		return
+	}
+	if sub == "" {
+		j.Actor, j.IP = "", ""
+		json.NewEncoder(w).Encode(j)
+		return
+	}
+	if j.Status != "done" {
+		writeError(w, http.StatusConflict, errors.New("job is "+j.Status))
+		return
+	}
+	t := negotiate(r)
+	if t == asJSON {
+		setMediaType(w, t)
+		http.ServeFile(w, r, jr.path(j.ID+".result.json"))
+		return
+	}
+	data, err := os.ReadFile(jr.path(j.ID + ".result.json"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	setMediaType(w, t)
+	if t == asCSV {
+		transcodeChartCSV(w, data)
+	} else {
+		transcodeMsgpack(w, data)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRangeJob(t *testing.T) {
+	dir := t.TempDir()
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	writeCSV(t, dir, "gym-stats-20251001.csv", header+"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,\"{}\"\n2025-10-01 10:02:00,EEST,1,Hipodroom,14,error,\"{}\"\n")
+	writeCSV(t, dir, "gym-stats-20251002.csv", header+"2025-10-02 10:00:00,EEST,1,Hipodroom,20,success,\"{}\"\n")
+	cfg := &Config{DataDir: dir, AuditLog: "gym-audit.jsonl"}
+
+	jr := jobsFor(cfg)
+	j, err := jr.submit(DateRangeRequest{From: "2025-10-01", To: "2025-10-02"}, true, "alice", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for j.Status != "done" && j.Status != "failed" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		j, _ = jr.get(j.ID)
+	}
+	if j.Status != "done" || j.Files != 2 || j.FilesDone != 2 || j.RowsParsed != 3 || j.Result != "/api/jobs/"+j.ID+"/result" {
+		t.Fatalf("job = %+v", j)
+	}
+
+	data, err := os.ReadFile(jr.path(j.ID + ".result.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp GenerateResponse
+	if err := json.Unmarshal(data, &resp); err != nil || !resp.Success || len(resp.Datasets) != 1 || len(resp.Datasets[0].Data) != 2 {
+		t.Errorf("result = %s, %v", data, err)
+	}
+	audit, _ := readAudit(cfg, "generate-data-range", 10)
+	if len(audit) != 1 || audit[0].Actor != "alice" || audit[0].Params["job"] != j.ID {
+		t.Errorf("audit = %+v, want the build attributed to the submitter", audit)
+	}
+}
+
+func TestRangeJobWaitsForSlot(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+	setConfig(&Config{MaxHeavy: 1, HeavyQueue: 1, HeavyQueueWait: time.Second})
+	held, err := admit(httptest.NewRequest("GET", "/", nil), currentConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeCSV(t, dir, "gym-stats-20251001.csv", "timestamp,timezone,location_id,location_name,user_count,status,response\n2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,\"{}\"\n")
+	jr := jobsFor(&Config{DataDir: dir, AuditLog: "gym-audit.jsonl"})
+	j, err := jr.submit(DateRangeRequest{From: "2025-10-01", To: "2025-10-01"}, true, "alice", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if j, _ = jr.get(j.ID); j.Status != "queued" {
+		t.Fatalf("job = %+v, want queued while the only slot is held", j)
+	}
+
+	held()
+	deadline := time.Now().Add(5 * time.Second)
+	for j.Status != "done" && j.Status != "failed" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		j, _ = jr.get(j.ID)
+	}
+	if j.Status != "done" {
+		t.Errorf("job = %+v, want done once the slot was free", j)
+	}
+}
+
+func TestRangeJobsCapped(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+	cfg := &Config{DataDir: t.TempDir()}
+	setConfig(cfg)
+	// A runner with no worker, so submitted jobs stay queued.
+	jr := &jobRunner{cfg: cfg, dir: cfg.path(jobsDir), jobs: map[string]*Job{}, wake: make(chan struct{}, 1)}
+	jobRunnersMu.Lock()
+	jobRunners[cfg.DataDir] = jr
+	jobRunnersMu.Unlock()
+	req := DateRangeRequest{From: "2025-10-01", To: "2025-10-31"}
+
+	for i := 0; i < jobsMaxPerActor; i++ {
+		if _, err := jr.submit(req, true, "alice", "10.0.0.1"); err != nil {
+			t.Fatalf("alice's job %d: %v", i+1, err)
+		}
+	}
+	if _, err := jr.submit(req, true, "alice", "10.0.0.2"); err != errBusy {
+		t.Errorf("alice over her cap: %v, want errBusy", err)
+	}
+	// Callers without a name are told apart by address.
+	for i := 0; i < jobsMaxPerActor; i++ {
+		if _, err := jr.submit(req, true, "anonymous", "10.0.0.3"); err != nil {
+			t.Fatalf("anonymous job %d: %v", i+1, err)
+		}
+	}
+	if _, err := jr.submit(req, true, "anonymous", "10.0.0.4"); err != nil {
+		t.Errorf("another anonymous caller: %v", err)
+	}
+	for i := len(jr.queue); i < jobsMaxQueued; i++ {
+		jr.jobs[strconv.Itoa(i)] = &Job{ID: strconv.Itoa(i), Status: "queued"}
+	}
+	if _, err := jr.submit(req, true, "bob", "10.0.0.5"); err != errBusy {
+		t.Errorf("full directory: %v, want errBusy", err)
+	}
+
+	w := httptest.NewRecorder()
+	generateDataRangeHandler(w, httptest.NewRequest("POST", "/generate-data-range?async=1", strings.NewReader(`{"from":"2025-10-01","to":"2025-10-31"}`)))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" || !strings.Contains(w.Body.String(), `"code":"unavailable"`) {
+		t.Errorf("full queue: %d %q %s", w.Code, w.Header().Get("Retry-After"), w.Body)
+	}
+	// Finished jobs don't count.
+	for _, j := range jr.jobs {
+		j.Status = "done"
+	}
+	if _, err := jr.submit(req, true, "alice", "10.0.0.1"); err != nil {
+		t.Errorf("after the queue drained: %v", err)
+	}
+}
+
+func TestJobsLoadRequeues(t *testing.T) {
+	dir := t.TempDir()
+	jobs := filepath.Join(dir, jobsDir)
+	if err := os.Mkdir(jobs, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 10, 2, 12, 0, 0, 0, time.UTC)
+	for _, j := range []Job{
+		{ID: "b", Status: "running", Created: "2025-10-02T11:00:00Z", FilesDone: 40},
+		{ID: "a", Status: "queued", Created: "2025-10-02T10:00:00Z"},
+		{ID: "old", Status: "done", Created: "2025-09-30T10:00:00Z", Finished: "2025-09-30T10:01:00Z"},
+		{ID: "recent", Status: "done", Created: "2025-10-02T09:00:00Z", Finished: "2025-10-02T09:01:00Z"},
+	} {
+		data, _ := json.Marshal(j)
+		os.WriteFile(filepath.Join(jobs, j.ID+".json"), data, 0o644)
+	}
+	os.WriteFile(filepath.Join(jobs, "old.result.json"), []byte("{}"), 0o644)
+
+	jr := &jobRunner{dir: jobs, jobs: map[string]*Job{}}
+	jr.load(now)
+	if strings.Join(jr.queue, ",") != "a,b" {
+		t.Errorf("queue = %v, want unfinished jobs oldest first", jr.queue)
+	}
+	if j := jr.jobs["b"]; j == nil || j.Status != "queued" || j.FilesDone != 0 {
+		t.Errorf("interrupted job = %+v, want queued from scratch", j)
+	}
+	if jr.jobs["old"] != nil || jr.jobs["recent"] == nil {
+		t.Errorf("jobs = %v, want only the day-old one pruned", jr.jobs)
+	}
+	if _, err := os.Stat(filepath.Join(jobs, "old.result.json")); !os.IsNotExist(err) {
+		t.Errorf("pruned job's result left behind: %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// latestViewTTL is how long a /api/recent view is kept built after it was
+// last asked for.
+const latestViewTTL = time.Hour
+
+// latestFile is one daily file's headcount as the latest window holds it,
+// as of the size and mtime it had then.
+type latestFile struct {
+	series []*gymdata.Series
+	rows   *gymdata.RowCounts
+	size   int64
+	mtime  time.Time
+}
+
+// recentView is a /api/recent request's build options, as buildRecent takes
+// them.
+type recentView struct {
+	hours     int
+	metrics   []string
+	tz        string
+	mode      outlierMode
+	maxPoints int
+	used      time.Time
+}
+
+// latestWindow is one data directory's last maxRecentHours of files, kept
+// loaded as the collector writes, and the /api/recent views asked for in
+// the last latestViewTTL, rebuilt after each write and each collection
+// interval. The dashboard's landing view is then served from recentCache,
+// built before it was asked for; one the window can't serve is loaded as
+// before.
+type latestWindow struct {
+	files map[string]*latestFile
+	views map[string]recentView
+}
+
+var (
+	latestMu      sync.Mutex
+	latestWindows = map[string]*latestWindow{} // by DataDir
+	// latestRefresh keeps the watcher's refreshes and runLatest's apart.
+	latestRefresh sync.Mutex
+)
+
+// key names a view by its options.
+func (v recentView) key() string {
+	return strconv.Itoa(v.hours) + "|" + strings.Join(v.metrics, ",") + "|" + v.tz + "|" + v.mode.String() + "|" + strconv.Itoa(v.maxPoints)
+}
+
+// noteRecentView records that cfg's /api/recent was asked for v, so the
+// window keeps it built.
+func noteRecentView(cfg *Config, v recentView) {
+	latestMu.Lock()
+	defer latestMu.Unlock()
+	w := latestWindows[cfg.DataDir]
+	if w == nil {
+		w = &latestWindow{files: map[string]*latestFile{}, views: map[string]recentView{}}
+		latestWindows[cfg.DataDir] = w
+	}
+	v.used = time.Now()
+	w.views[v.key()] = v
+}
+
+// latestLoad is gymdata.Load of the headcount from files, from cfg's window
+// if it holds every one of them as they are now. ok is false if it doesn't.
+func latestLoad(cfg *Config, files []string) (list []*gymdata.Series, rows *gymdata.RowCounts, ok bool) {
+	latestMu.Lock()
+	w := latestWindows[cfg.DataDir]
+	held := make([]*latestFile, len(files))
+	for i, file := range files {
+		if w != nil {
+			held[i] = w.files[file]
+		}
+	}
+	latestMu.Unlock()
+
+	rows = &gymdata.RowCounts{}
+	st := gymdata.NewStitcher()
+	for i, file := range files {
+		lf := held[i]
+		if lf == nil {
+			return nil, nil, false
+		}
+		if info, err := gymdata.Stat(file); err != nil || info.Size != lf.size || !info.ModTime.Equal(lf.mtime) {
+			return nil, nil, false
+		}
+		// Copies, as Load's are: callers bucket and trim in place.
+		st.Add(file, lf.series)
+		rows.Merge(lf.rows)
+	}
+	// In Load's order: by gym, each series by time.
+	list = st.Series()
+	slices.SortFunc(list, func(a, b *gymdata.Series) int { return strings.Compare(a.Key.Location, b.Key.Location) })
+	return list, rows, true
+}
+
+// refreshLatest brings c's window up to date with its files, loading only
+// those that changed (the parse cache has already read most of their
+// lines), and rebuilds the views asked for lately. It does nothing for a
+// directory whose /api/recent nobody has asked for.
+func refreshLatest(c *Config, now time.Time) {
+	latestRefresh.Lock()
+	defer latestRefresh.Unlock()
+
+	latestMu.Lock()
+	w := latestWindows[c.DataDir]
+	var views []recentView
+	held := map[string]*latestFile{}
+	if w != nil {
+		for k, v := range w.views {
+			if now.Sub(v.used) > latestViewTTL {
+				delete(w.views, k)
+				continue
+			}
+			views = append(views, v)
+		}
+		for file, lf := range w.files {
+			held[file] = lf
+		}
+	}
+	latestMu.Unlock()
+	if len(views) == 0 {
+		return
+	}
+
+	files, err := recentFiles(c.csvDir(), now.Add(-maxRecentHours*time.Hour), now)
+	if err != nil {
+		files = nil
+	}
+	current := map[string]*latestFile{}
+	for _, file := range files {
+		info, err := gymdata.Stat(file)
+		if err != nil {
+			continue
+		}
+		if lf := held[file]; lf != nil && lf.size == info.Size && lf.mtime.Equal(info.ModTime) {
+			current[file] = lf
+			continue
+		}
+		list, rows, err := gymdata.Load(c.format(), []string{file}, nil)
+		if err != nil {
+			log.Printf("Latest: %s: %v", file, err)
+			continue
+		}
+		current[file] = &latestFile{series: list, rows: rows, size: info.Size, mtime: info.ModTime}
+	}
+	latestMu.Lock()
+	if w := latestWindows[c.DataDir]; w != nil {
+		w.files = current
+	}
+	latestMu.Unlock()
+
+	for _, v := range views {
+		outZone, err := requestZone(v.tz, gymdata.Tallinn())
+		if err != nil {
+			continue
+		}
+		if _, _, _, err := buildRecent(context.Background(), c, v.hours, v.metrics, v.tz, outZone, v.mode, v.maxPoints); err != nil {
+			log.Printf("Latest: %d hours: %v", v.hours, err)
+		}
+	}
+}
+
+// runLatest refreshes every directory's window as each collection interval
+// begins, when the views' windows move on; writes the watcher sees refresh
+// them in between.
+func runLatest() {
+	for {
+		now := time.Now()
+		time.Sleep(now.Truncate(2 * time.Minute).Add(2 * time.Minute).Sub(now))
+		cfg := currentConfig()
+		refreshLatest(cfg, time.Now())
+		for _, t := range cfg.Tenants {
+			refreshLatest(t, time.Now())
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+func TestLatestWindow(t *testing.T) {
+	tallinn := loadTallinn(t)
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	recentCacheMu.Lock()
+	clear(recentCache)
+	recentCacheMu.Unlock()
+	defer func() {
+		recentCacheMu.Lock()
+		clear(recentCache)
+		recentCacheMu.Unlock()
+	}()
+	latestMu.Lock()
+	saved := latestWindows
+	latestWindows = map[string]*latestWindow{}
+	latestMu.Unlock()
+	defer func() {
+		latestMu.Lock()
+		latestWindows = saved
+		latestMu.Unlock()
+	}()
+
+	now := time.Now().In(tallinn)
+	row := func(at time.Time, n int) string {
+		return at.In(time.UTC).Format("2006-01-02 15:04:05") + ",,1,Hipodroom," + strconv.Itoa(n) + ",success,\"{}\"\n"
+	}
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	hourAgo := now.Add(-time.Hour)
+	file := writeCSV(t, dir, "gym-stats-"+hourAgo.Format("20060102")+".csv", header+row(hourAgo, 1))
+
+	get := func() GenerateResponse {
+		w := httptest.NewRecorder()
+		recentHandler(w, httptest.NewRequest("GET", "/api/recent", nil))
+		var resp GenerateResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp
+	}
+	if resp := get(); resp.Meta == nil || resp.Meta.Cached {
+		t.Fatalf("first meta = %+v, want a fresh build", resp.Meta)
+	}
+
+	// The collector writes a row; the window rebuilds the view before
+	// anyone asks for it again.
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(row(now.Add(-time.Minute), 2))
+	f.Close()
+	later := time.Now().Add(time.Second) // past the mtime the first build saw
+	os.Chtimes(file, later, later)
+	refreshLatest(currentConfig(), time.Now())
+
+	resp := get()
+	if resp.Meta == nil || !resp.Meta.Cached {
+		t.Errorf("meta after refresh = %+v, want the window's build, from cache", resp.Meta)
+	}
+	if len(resp.Datasets) != 1 || len(resp.Datasets[0].Data) != 2 {
+		t.Errorf("datasets = %+v, want both readings", resp.Datasets)
+	}
+
+	files, err := recentFiles(currentConfig().csvDir(), now.Add(-24*time.Hour), now)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("recentFiles = %v, %v", files, err)
+	}
+	held, rows, ok := latestLoad(currentConfig(), files)
+	if !ok {
+		t.Fatal("latestLoad: the window doesn't hold the file")
+	}
+	want, wantRows, err := gymdata.Load(currentConfig().format(), files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(held, want) || rows.Total() != wantRows.Total() {
+		t.Errorf("latestLoad = %+v (%d rows), want Load's %+v (%d rows)", held, rows.Total(), want, wantRows.Total())
+	}
+
+	// A file changed since the window read it is loaded as before.
+	f, _ = os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0o644)
+	f.WriteString(row(now, 3))
+	f.Close()
+	if _, _, ok := latestLoad(currentConfig(), files); ok {
+		t.Error("latestLoad served a file that has grown since")
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// LiveResponse is /status read straight from the chain's occupancy API
+// rather than the CSVs, for when the collector is behind. Cache says whether
+// the counts came from the proxy's cache ("hit"), a fresh upstream call
+// ("miss"), or a cached copy served because upstream failed ("stale").
+type LiveResponse struct {
+	StatusResponse
+	Cache string `json:"cache"`
+}
+
+// liveCache holds one upstream's last good answer. mu is held for the
+// upstream call, so concurrent misses wait for one request instead of each
+// sending their own.
+type liveCache struct {
+	mu        sync.Mutex
+	resp      StatusResponse
+	fetched   time.Time // last good answer
+	attempted time.Time // last upstream call, good or not
+}
+
+// clientBucket is a token bucket refilled at LIVE_CLIENT_LIMIT per minute.
+type clientBucket struct {
+	tokens float64
+	seen   time.Time
+}
+
+var (
+	liveMu      sync.Mutex
+	liveCaches  = map[string]*liveCache{} // data dir + upstream URL -> cache
+	liveClients = map[string]*clientBucket{}
+)
+
+// maxLiveClients bounds the per-client buckets; past it, full ones (idle
+// clients) are forgotten.
+const maxLiveClients = 10000
+
+// allowLiveClient takes a token from ip's bucket, or says how long until one
+// is back.
+func allowLiveClient(ip string, perMinute int, now time.Time) (bool, time.Duration) {
+	liveMu.Lock()
+	defer liveMu.Unlock()
+	rate := float64(perMinute) / 60 // tokens per second
+	b := liveClients[ip]
+	if b == nil {
+		if len(liveClients) >= maxLiveClients {
+			for k, old := range liveClients {
+				if old.tokens+now.Sub(old.seen).Seconds()*rate >= float64(perMinute) {
+					delete(liveClients, k)
+				}
+			}
+		}
+		b = &clientBucket{tokens: float64(perMinute), seen: now}
+		liveClients[ip] = b
+	}
+	b.tokens = math.Min(float64(perMinute), b.tokens+now.Sub(b.seen).Seconds()*rate)
+	b.seen = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+func liveCacheFor(cfg *Config) *liveCache {
+	liveMu.Lock()
+	defer liveMu.Unlock()
+	key := cfg.DataDir + "|" + cfg.LiveAPIURL
+	c := liveCaches[key]
+	if c == nil {
+		c = &liveCache{}
+		liveCaches[key] = c
+	}
+	return c
+}
+
+var liveClient = &http.Client{Timeout: 10 * time.Second}
+
+// fetchLive calls the upstream API: the collector's primary endpoint, one
+// JSON array of {location_id, location_name, total} for every gym. Names are
+// mapped to the CSVs' by location ID, since the collector writes its own
+// short names ("T1") rather than the API's.
+func fetchLive(cfg *Config, now time.Time) (StatusResponse, error) {
+	req, err := http.NewRequest("GET", cfg.LiveAPIURL, nil)
+	if err != nil {
+		return StatusResponse{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.LiveAPIToken)
+	req.Header.Set("Accept", "application/json")
+	resp, err := liveClient.Do(req)
+	if err != nil {
+		return StatusResponse{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return StatusResponse{}, fmt.Errorf("upstream: %s", resp.Status)
+	}
+	var gyms []struct {
+		ID    any     `json:"location_id"`
+		Name  string  `json:"location_name"`
+		Total float64 `json:"total"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&gyms); err != nil {
+		return StatusResponse{}, fmt.Errorf("upstream: %v", err)
+	}
+	names := csvLocationNames(cfg)
+	closed := closedLocations(cfg)
+	at := now.In(gymdata.Tallinn()).Format(time.RFC3339)
+	out := StatusResponse{Latest: at, Locations: []StatusLocation{}}
+	for _, g := range gyms {
+		name := g.Name
+		if n, ok := names[fmt.Sprint(g.ID)]; ok {
+			name = n
+		}
+		if closed.has(name) {
+			continue
+		}
+		out.Locations = append(out.Locations, StatusLocation{Name: name, Count: int(g.Total), At: at})
+	}
+	return out, nil
+}
+
+// csvLocationNames maps location_id to location_name as the newest CSV has
+// them. A file without the ID column gives an empty map.
+func csvLocationNames(cfg *Config) map[string]string {
+	names := map[string]string{}
+	csvFile, err := gymdata.Latest(cfg.csvDir())
+	if err != nil {
+		return names
+	}
+	file, err := gymdata.Open(csvFile)
+	if err != nil {
+		return names
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	headers, err := reader.Read()
+	if err != nil {
+		return names
+	}
+	idIdx, locIdx := -1, -1
+	for i, h := range gymdata.CanonicalHeaders(headers, cfg.CSVColumns) {
+		switch h {
+		case "location_id":
+			idIdx = i
+		case "location_name":
+			locIdx = i
+		}
+	}
+	if idIdx == -1 || locIdx == -1 {
+		return names
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err == nil && len(record) > max2(idIdx, locIdx) {
+			names[record[idIdx]] = cfg.format().Canonical(record[locIdx])
+		}
+	}
+	return names
+}
+
+// liveHandler proxies the dashboard's live check to the chain's occupancy
+// API, so browsers don't call it directly (it doesn't allow cross-origin
+// calls, and needs the collector's API_TOKEN). Answers are cached for
+// LIVE_CACHE_SECONDS, which also caps upstream calls at one per that
+// interval however many viewers there are; each client may ask
+// LIVE_CLIENT_LIMIT times a minute. A failed upstream call is not retried
+// until the interval passes, and the last good answer is served meanwhile.
+//
+//	GET /api/live
+func liveHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	fail := func(status int, err error) {
+		writeError(w, status, err)
+	}
+	cfg := requestConfig(r)
+	if cfg.LiveAPIURL == "" || cfg.LiveAPIToken == "" {
+		fail(http.StatusServiceUnavailable, fmt.Errorf("live check not configured (LIVE_API_URL and API_TOKEN)"))
+		return
+	}
+	now := time.Now()
+	if ok, wait := allowLiveClient(clientIP(r), cfg.LiveClientLimit, now); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		fail(http.StatusTooManyRequests, fmt.Errorf("live check rate limit: %d a minute", cfg.LiveClientLimit))
+		return
+	}
+
+	c := liveCacheFor(cfg)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := LiveResponse{StatusResponse: c.resp, Cache: "hit"}
+	if now.Sub(c.attempted) >= cfg.LiveCacheTTL {
+		c.attempted = now
+		resp, err := fetchLive(cfg, now)
+		switch {
+		case err == nil:
+			c.resp, c.fetched = resp, now
+			out = LiveResponse{StatusResponse: resp, Cache: "miss"}
+		case c.fetched.IsZero():
+			fail(http.StatusBadGateway, err)
+			return
+		default:
+			out.Cache = "stale"
+		}
+	} else if c.fetched.IsZero() {
+		fail(http.StatusBadGateway, fmt.Errorf("upstream failed; next try in %s", (cfg.LiveCacheTTL-now.Sub(c.attempted)).Round(time.Second)))
+		return
+	} else if c.fetched.Before(c.attempted) {
+		out.Cache = "stale"
+	}
+	out.AgeSeconds = int64(now.Sub(c.fetched).Seconds())
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int((cfg.LiveCacheTTL-now.Sub(c.attempted)).Seconds())))
+	json.NewEncoder(w).Encode(out)
+}
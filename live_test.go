@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLiveHandler(t *testing.T) {
+	calls, failing := 0, false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if failing || r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`[{"location_id":3,"location_name":"Ronimiskeskus T1","total":17},{"location_id":11,"location_name":"New Gym","total":4}]`))
+	}))
+	defer upstream.Close()
+
+	old := currentConfig()
+	defer setConfig(old)
+	dir := t.TempDir()
+	writeCSV(t, dir, "gym-stats-20251001.csv", "timestamp,timezone,location_id,location_name,user_count,status,response\n2025-10-01 10:00:00,EEST,3,T1,12,success,\"{}\"\n")
+	setConfig(&Config{DataDir: dir, LiveAPIURL: upstream.URL, LiveAPIToken: "tok", LiveCacheTTL: time.Minute, LiveClientLimit: 3})
+
+	get := func(ip string) (*httptest.ResponseRecorder, LiveResponse) {
+		r := httptest.NewRequest("GET", "/api/live", nil)
+		r.RemoteAddr = ip + ":5000"
+		w := httptest.NewRecorder()
+		liveHandler(w, r)
+		var resp LiveResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+
+	w, resp := get("198.51.100.1")
+	if w.Code != 200 || resp.Cache != "miss" || len(resp.Locations) != 2 || resp.Locations[0].Name != "T1" || resp.Locations[0].Count != 17 || resp.Locations[1].Name != "New Gym" {
+		t.Fatalf("first call: %d %s", w.Code, w.Body)
+	}
+	if _, resp := get("198.51.100.2"); resp.Cache != "hit" || calls != 1 {
+		t.Errorf("second client: cache %q after %d upstream calls, want a hit", resp.Cache, calls)
+	}
+	get("198.51.100.1")
+	get("198.51.100.1")
+	if w, _ := get("198.51.100.1"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("fourth call in a minute: %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Once the interval is up, a failing upstream is asked once and the last
+	// good answer served.
+	c := liveCacheFor(currentConfig())
+	c.mu.Lock()
+	c.attempted = c.attempted.Add(-time.Minute)
+	c.mu.Unlock()
+	failing = true
+	if w, resp := get("198.51.100.3"); w.Code != 200 || resp.Cache != "stale" || resp.Locations[0].Count != 17 {
+		t.Errorf("upstream down: %d %s", w.Code, w.Body)
+	}
+	if _, resp := get("198.51.100.3"); resp.Cache != "stale" || calls != 2 {
+		t.Errorf("retried upstream within the interval: %d calls, cache %q", calls, resp.Cache)
+	}
+}
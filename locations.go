@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gym/internal/gymdata"
+)
+
+// MergeRequest renames a gym in the stored CSVs: every location_name cell
+// that is From becomes To, merging its history into To's.
+type MergeRequest struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	DryRun bool   `json:"dryRun,omitempty"`
+}
+
+type MergedFile struct {
+	File string `json:"file"`
+	Rows int    `json:"rows"`
+}
+
+type MergeResponse struct {
+	From   string       `json:"from"`
+	To     string       `json:"to"`
+	DryRun bool         `json:"dryRun,omitempty"`
+	Rows   int          `json:"rows"`
+	Files  []MergedFile `json:"files"`
+}
+
+// csvField is s as a CSV field, quoted only if it has to be.
+func csvField(s string) string {
+	if strings.ContainsAny(s, ",\"\r\n") {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+	return s
+}
+
+// renameRows returns data, a daily CSV, with the location_name cells that
+// are from replaced by to, and how many it replaced. Only those cells
+// change: the collector's response column isn't always valid CSV, and
+// reading and writing each row back would "fix" it.
+func renameRows(cfg *Config, data []byte, from, to string) ([]byte, int) {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	reader := csv.NewReader(bytes.NewReader(lines[0]))
+	reader.FieldsPerRecord = -1
+	headers, err := reader.Read()
+	if err != nil {
+		return data, 0
+	}
+	locIdx := -1
+	for i, h := range gymdata.CanonicalHeaders(headers, cfg.CSVColumns) {
+		if h == "location_name" {
+			locIdx = i
+		}
+	}
+	if locIdx == -1 {
+		return data, 0
+	}
+
+	var out bytes.Buffer
+	out.Write(lines[0])
+	renamed := 0
+	for _, line := range lines[1:] {
+		if !bytes.Contains(line, []byte(from)) {
+			out.Write(line)
+			continue
+		}
+		reader := csv.NewReader(bytes.NewReader(line))
+		reader.LazyQuotes = true
+		reader.FieldsPerRecord = -1
+		record, err := reader.Read()
+		if err != nil || len(record) <= locIdx || strings.TrimSpace(record[locIdx]) != from {
+			out.Write(line)
+			continue
+		}
+		replaced, ok := replaceCell(line, reader, record, locIdx, to)
+		if !ok {
+			out.Write(line)
+			continue
+		}
+		out.Write(replaced)
+		renamed++
+	}
+	return out.Bytes(), renamed
+}
+
+// replaceCell returns line with field idx of record, which reader read from
+// it, replaced by value, leaving every other byte as it was. It reports
+// false if the field's text isn't where reader says it is.
+func replaceCell(line []byte, reader *csv.Reader, record []string, idx int, value string) ([]byte, bool) {
+	_, col := reader.FieldPos(idx)
+	start := col - 1
+	raw := record[idx]
+	if quoted := `"` + strings.ReplaceAll(raw, `"`, `""`) + `"`; bytes.HasPrefix(line[start:], []byte(quoted)) {
+		raw = quoted
+	} else if !bytes.HasPrefix(line[start:], []byte(raw)) {
+		return nil, false
+	}
+	out := make([]byte, 0, len(line)+len(value))
+	out = append(out, line[:start]...)
+	out = append(out, csvField(value)...)
+	return append(out, line[start+len(raw):]...), true
+}
+
+// rewriteFile replaces file with data, through a temporary file so a reader
+// never sees half of it, gzipping it again if it was. It gives up if file
+// grew since it was read (the collector appended); the caller reads again.
+func rewriteFile(file string, data []byte, size int64) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".merge-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	var w io.Writer = tmp
+	var zw *gzip.Writer
+	if strings.HasSuffix(file, ".gz") {
+		zw = gzip.NewWriter(tmp)
+		w = zw
+	}
+	_, err = w.Write(data)
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if info.Size() != size {
+		return errFileChanged
+	}
+	os.Chmod(tmp.Name(), info.Mode())
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return err
+	}
+	gymdata.Forget(file)
+	return nil
+}
+
+var errFileChanged = fmt.Errorf("file changed while it was rewritten")
+
+// mergeLocation renames from to to in file, returning how many rows it
+// changed.
+func mergeLocation(cfg *Config, file, from, to string, dryRun bool) (int, error) {
+	for range 3 {
+		info, err := os.Stat(file)
+		if err != nil {
+			return 0, err
+		}
+		f, err := gymdata.Open(file)
+		if err != nil {
+			return 0, err
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return 0, err
+		}
+		data, n := renameRows(cfg, data, from, to)
+		if n == 0 || dryRun {
+			return n, nil
+		}
+		if err := rewriteFile(file, data, info.Size()); err != errFileChanged {
+			return n, err
+		}
+	}
+	return 0, errFileChanged
+}
+
+// mergeLocationsHandler renames a gym throughout the stored CSVs, plain and
+// gzipped, so history recorded under an old name joins the current one for
+// good; LOCATION_ALIASES does the same at read time without touching files.
+// Ingestion waits while the files are rewritten.
+//
+//	POST /api/admin/locations/merge {from, to[, dryRun]}
+func mergeLocationsHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "POST, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	fail := func(status int, err error) {
+		writeError(w, status, err)
+	}
+	cfg := requestConfig(r)
+	if cfg.CSVSource != "" {
+		fail(http.StatusConflict, fmt.Errorf("CSV files are read from %s; rename them there instead", cfg.CSVSource))
+		return
+	}
+	var req MergeRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		fail(http.StatusBadRequest, fmt.Errorf("invalid request body"))
+		return
+	}
+	req.From, req.To = strings.TrimSpace(req.From), strings.TrimSpace(req.To)
+	if req.From == "" || req.To == "" || req.From == req.To {
+		fail(http.StatusBadRequest, fmt.Errorf("from and to must be two different names"))
+		return
+	}
+	files, err := gymdata.ListFiles(cfg.csvDir())
+	if err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+
+	ingestorsMu.Lock()
+	in := ingestors[cfg.DataDir]
+	ingestorsMu.Unlock()
+	if in != nil {
+		in.mu.Lock()
+	}
+	resp := MergeResponse{From: req.From, To: req.To, DryRun: req.DryRun, Files: []MergedFile{}}
+	for _, file := range files {
+		n, ferr := mergeLocation(cfg, file, req.From, req.To, req.DryRun)
+		if ferr != nil {
+			err = fmt.Errorf("%s: %v", gymdata.BaseName(file), ferr)
+			break
+		}
+		if n > 0 {
+			resp.Rows += n
+			resp.Files = append(resp.Files, MergedFile{File: filepath.Base(file), Rows: n})
+		}
+	}
+	if in != nil {
+		in.seen = map[string]map[string]bool{} // keyed by the old names
+		in.mu.Unlock()
+	}
+
+	if !req.DryRun {
+		recordAudit(r, "merge-locations", map[string]any{"from": req.From, "to": req.To, "rows": resp.Rows, "files": len(resp.Files)}, err)
+	}
+	if err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
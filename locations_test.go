@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"gym/internal/gymdata"
+)
+
+func TestMergeLocations(t *testing.T) {
+	dir := t.TempDir()
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	plain := writeCSV(t, dir, "gym-stats-20251002.csv", header+
+		"2025-10-02 10:00:00,EEST,10,Suur Paala,5,success,\"{\"total\":5,\"location_name\":\"Suur Paala\"}\"\n"+
+		"2025-10-02 10:00:00,EEST,1,Hipodroom,12,success,\"{}\"\n"+
+		"2025-10-02 10:02:00,EEST,10,\"Suur Paala\",6,success,\"{}\"")
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(header + "2025-10-01 10:00:00,EEST,10,Suur Paala,4,success,\"{}\"\n"))
+	zw.Close()
+	gzipped := writeCSV(t, dir, "gym-stats-20251001.csv.gz", gz.String())
+	untouched := writeCSV(t, dir, "gym-stats-20251003.csv", header+"2025-10-03 10:00:00,EEST,1,Hipodroom,3,success,\"{}\"\n")
+	stat, _ := os.Stat(untouched)
+
+	old := currentConfig()
+	defer setConfig(old)
+	setConfig(&Config{DataDir: dir, AuditLog: "gym-audit.jsonl"})
+	merge := func(body string) (int, MergeResponse) {
+		w := httptest.NewRecorder()
+		mergeLocationsHandler(w, httptest.NewRequest("POST", "/api/admin/locations/merge", strings.NewReader(body)))
+		var resp MergeResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	before, _ := os.ReadFile(plain)
+	if code, resp := merge(`{"from":"Suur Paala","to":"Suur-Paala","dryRun":true}`); code != 200 || resp.Rows != 3 || len(resp.Files) != 2 {
+		t.Fatalf("dry run = %d %+v", code, resp)
+	}
+	if after, _ := os.ReadFile(plain); !bytes.Equal(before, after) {
+		t.Fatal("dry run changed the file")
+	}
+
+	if code, resp := merge(`{"from":"Suur Paala","to":"Suur, Paala"}`); code != 200 || resp.Rows != 3 {
+		t.Fatalf("merge = %d %+v", code, resp)
+	}
+	data, _ := os.ReadFile(plain)
+	want := header +
+		"2025-10-02 10:00:00,EEST,10,\"Suur, Paala\",5,success,\"{\"total\":5,\"location_name\":\"Suur Paala\"}\"\n" +
+		"2025-10-02 10:00:00,EEST,1,Hipodroom,12,success,\"{}\"\n" +
+		"2025-10-02 10:02:00,EEST,10,\"Suur, Paala\",6,success,\"{}\""
+	if string(data) != want {
+		t.Errorf("plain file =\n%s\nwant\n%s", data, want)
+	}
+	f, _ := gymdata.Open(gzipped)
+	data, _ = io.ReadAll(f)
+	f.Close()
+	if !strings.Contains(string(data), ",10,\"Suur, Paala\",4,") {
+		t.Errorf("gzipped file =\n%s", data)
+	}
+	if now, _ := os.Stat(untouched); !now.ModTime().Equal(stat.ModTime()) {
+		t.Error("file without the name was rewritten")
+	}
+
+	for _, bad := range []string{`{"from":"A","to":"A"}`, `{"from":"","to":"B"}`, `nope`} {
+		if code, _ := merge(bad); code != 400 {
+			t.Errorf("%s: code %d, want 400", bad, code)
+		}
+	}
+}
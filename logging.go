@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// jsonLogs is set when LOG_FORMAT=json, for the startup banner to follow.
+var jsonLogs bool
+
+// setupLogging sends the log to stderr as LOG_FORMAT says: "text" (the
+// default, log's own lines) or "json", one object per line with time, level
+// and msg for a container's log collector. It is read from the environment
+// only, before the config, so config errors are logged the same way.
+func setupLogging(format string) error {
+	switch format {
+	case "", "text":
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+		jsonLogs = true
+	default:
+		return fmt.Errorf("LOG_FORMAT: want text or json, not %q", format)
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+type ManifestLocation struct {
+	Name  string `json:"name"`
+	First string `json:"first"`
+	Last  string `json:"last"`
+	Rows  int    `json:"rows"`
+	Hash  string `json:"hash"`
+}
+
+type Manifest struct {
+	Latest    string             `json:"latest"`
+	DataStart string             `json:"dataStart"`
+	DataEnd   string             `json:"dataEnd"`
+	Days      int                `json:"days"` // daily files on disk
+	Locations []ManifestLocation `json:"locations"`
+	ETag      string             `json:"etag"`
+
+	days map[string]uint64 // YYYY-MM-DD -> dayHash, for /api/days
+}
+
+// fileSummary is what the manifest needs from one daily file, kept until the
+// file's size or mtime changes; in practice only today's file is rescanned.
+type fileSummary struct {
+	size      int64
+	mtime     time.Time
+	locations map[string]*locationSummary
+}
+
+type locationSummary struct {
+	first, last int64
+	rows        int
+	hash        uint64 // FNV-1a over the location's rows in file order
+}
+
+var (
+	manifestMu    sync.Mutex
+	manifestFiles = map[string]map[string]fileSummary{} // data dir -> file -> summary
+)
+
+// summarizeCSVFile hashes each location's rows in csvFile and notes their
+// count and first and last reading times.
+func summarizeCSVFile(cfg *Config, csvFile string, tallinn *time.Location) (map[string]*locationSummary, error) {
+	file, err := gymdata.Open(csvFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	headers, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	headers = gymdata.CanonicalHeaders(headers, cfg.CSVColumns)
+	tsIdx, tzIdx, locIdx := -1, -1, -1
+	for i, h := range headers {
+		switch h {
+		case "timestamp":
+			tsIdx = i
+		case "timezone":
+			tzIdx = i
+		case "location_name":
+			locIdx = i
+		}
+	}
+	if tsIdx == -1 || locIdx == -1 {
+		return nil, fmt.Errorf("missing required columns in CSV")
+	}
+
+	out := map[string]*locationSummary{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil || len(record) <= max2(max2(tsIdx, tzIdx), locIdx) {
+			continue
+		}
+		tzVal := ""
+		if tzIdx != -1 {
+			tzVal = record[tzIdx]
+		}
+		local, ok := gymdata.LocalTime(record[tsIdx], tzVal, cfg.CSVTimeLayout, tallinn)
+		if !ok {
+			continue
+		}
+		name := record[locIdx]
+		loc := out[name]
+		if loc == nil {
+			loc = &locationSummary{first: local.Unix(), hash: fnvOffset}
+			out[name] = loc
+		}
+		loc.first = min(loc.first, local.Unix())
+		loc.last = max(loc.last, local.Unix())
+		loc.rows++
+		for _, field := range record {
+			loc.hash = fnvAdd(loc.hash, field)
+			loc.hash = fnvAdd(loc.hash, "\x1f")
+		}
+	}
+	return out, nil
+}
+
+const fnvOffset, fnvPrime = 14695981039346656037, 1099511628211
+
+// fnvAdd folds s into an FNV-1a hash without allocating per field.
+func fnvAdd(h uint64, s string) uint64 {
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= fnvPrime
+	}
+	return h
+}
+
+// dayHash folds one file's location hashes into one that, like them,
+// changes whenever any of the file's rows do.
+func dayHash(locs map[string]*locationSummary) uint64 {
+	names := make([]string, 0, len(locs))
+	for name := range locs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := uint64(fnvOffset)
+	for _, name := range names {
+		h = fnvAdd(h, fmt.Sprintf("%s=%016x\n", name, locs[name].hash))
+	}
+	return h
+}
+
+// buildManifest summarizes the data on disk: the date span of the daily
+// files, the newest reading, and per location a hash that changes whenever
+// any of its rows does (and only then: gzipping a day keeps it).
+func buildManifest(cfg *Config, tallinn *time.Location) (Manifest, error) {
+	m := Manifest{Locations: []ManifestLocation{}, days: map[string]uint64{}}
+	files, err := gymdata.ListFiles(cfg.csvDir())
+	if err != nil {
+		return m, err
+	}
+	sort.Slice(files, func(i, j int) bool { return gymdata.BaseName(files[i]) < gymdata.BaseName(files[j]) })
+
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	type agg struct {
+		first, last int64
+		rows        int
+		hash        uint64
+	}
+	byName := map[string]*agg{}
+	cached := manifestFiles[cfg.DataDir]
+	// Rebuilt from the files present, so removed or renamed (gzipped) ones
+	// drop out.
+	summaries := make(map[string]fileSummary, len(files))
+	for _, f := range files {
+		info, err := gymdata.Stat(f)
+		if err != nil {
+			continue
+		}
+		sum, ok := cached[f]
+		if !ok || sum.size != info.Size || !sum.mtime.Equal(info.ModTime) {
+			locs, err := summarizeCSVFile(cfg, f, tallinn)
+			if err != nil {
+				continue
+			}
+			sum = fileSummary{size: info.Size, mtime: info.ModTime, locations: locs}
+		}
+		summaries[f] = sum
+		day := gymdata.BaseName(f)
+		if len(day) >= 18 {
+			date := day[10:14] + "-" + day[14:16] + "-" + day[16:18]
+			if m.DataStart == "" {
+				m.DataStart = date
+			}
+			m.DataEnd = date
+			m.days[date] = dayHash(sum.locations)
+		}
+		m.Days++
+		for name, loc := range sum.locations {
+			a := byName[name]
+			if a == nil {
+				a = &agg{first: loc.first, hash: fnvOffset}
+				byName[name] = a
+			}
+			a.first = min(a.first, loc.first)
+			a.last = max(a.last, loc.last)
+			a.rows += loc.rows
+			a.hash = fnvAdd(a.hash, fmt.Sprintf("%s:%x;", day, loc.hash))
+		}
+	}
+	manifestFiles[cfg.DataDir] = summaries
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	etag := uint64(fnvOffset)
+	var latest int64
+	for _, name := range names {
+		a := byName[name]
+		m.Locations = append(m.Locations, ManifestLocation{
+			Name:  name,
+			First: time.Unix(a.first, 0).In(tallinn).Format(time.RFC3339),
+			Last:  time.Unix(a.last, 0).In(tallinn).Format(time.RFC3339),
+			Rows:  a.rows,
+			Hash:  fmt.Sprintf("%016x", a.hash),
+		})
+		latest = max(latest, a.last)
+		etag = fnvAdd(etag, fmt.Sprintf("%s=%016x\n", name, a.hash))
+	}
+	if latest > 0 {
+		m.Latest = time.Unix(latest, 0).In(tallinn).Format(time.RFC3339)
+	}
+	etag = fnvAdd(etag, m.DataStart+".."+m.DataEnd)
+	m.ETag = fmt.Sprintf("%016x", etag)
+	return m, nil
+}
+
+// manifestHandler serves the data manifest with its hash as ETag, so a
+// client polling it gets a 304 until something on disk changes, and can tell
+// from the per-location hashes which gyms' data to refetch.
+//
+//	GET /api/manifest
+func manifestHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	m, err := buildManifest(requestConfig(r), gymdata.Tallinn())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	etag := `"` + m.ETag + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache") // always revalidate
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	json.NewEncoder(w).Encode(m)
+}
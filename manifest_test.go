@@ -0,0 +1,69 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestBuildManifest(t *testing.T) {
+	tallinn := loadTallinn(t)
+	dir := t.TempDir()
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	writeCSV(t, dir, "gym-stats-20251001.csv", header+"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,\"{}\"\n2025-10-01 10:00:00,EEST,2,T1,3,success,\"{}\"\n")
+	day2 := writeCSV(t, dir, "gym-stats-20251002.csv", header+"2025-10-02 10:00:00,EEST,1,Hipodroom,20,success,\"{}\"\n")
+	cfg := &Config{DataDir: dir}
+
+	m, err := buildManifest(cfg, tallinn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.DataStart != "2025-10-01" || m.DataEnd != "2025-10-02" || m.Days != 2 || m.Latest != "2025-10-02T10:00:00+03:00" || len(m.Locations) != 2 {
+		t.Fatalf("manifest = %+v", m)
+	}
+	if h := m.Locations[0]; h.Name != "Hipodroom" || h.Rows != 2 || h.First != "2025-10-01T10:00:00+03:00" {
+		t.Errorf("Hipodroom = %+v", h)
+	}
+
+	// Gzipping a day changes the file but not the data.
+	data, _ := os.ReadFile(day2)
+	f, _ := os.Create(day2 + ".gz")
+	gz := gzip.NewWriter(f)
+	gz.Write(data)
+	gz.Close()
+	f.Close()
+	os.Remove(day2)
+	again, _ := buildManifest(cfg, tallinn)
+	if again.ETag != m.ETag || again.Locations[0].Hash != m.Locations[0].Hash {
+		t.Errorf("hashes changed by gzip: %+v vs %+v", again, m)
+	}
+
+	writeCSV(t, dir, "gym-stats-20251003.csv", header+"2025-10-03 10:00:00,EEST,2,T1,4,success,\"{}\"\n")
+	grown, _ := buildManifest(cfg, tallinn)
+	if grown.ETag == m.ETag || grown.Locations[0].Hash != m.Locations[0].Hash || grown.Locations[1].Hash == m.Locations[1].Hash {
+		t.Errorf("new T1 row: etag %s -> %s, want only T1's hash to change", m.ETag, grown.ETag)
+	}
+}
+
+func TestManifestHandlerNotModified(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+	dir := t.TempDir()
+	writeCSV(t, dir, "gym-stats-20251001.csv", "timestamp,timezone,location_id,location_name,user_count,status,response\n2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,\"{}\"\n")
+	setConfig(&Config{DataDir: dir})
+
+	w := httptest.NewRecorder()
+	manifestHandler(w, httptest.NewRequest("GET", "/api/manifest", nil))
+	etag := w.Header().Get("ETag")
+	if w.Code != 200 || etag == "" {
+		t.Fatalf("code %d, etag %q", w.Code, etag)
+	}
+	r := httptest.NewRequest("GET", "/api/manifest", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	manifestHandler(w, r)
+	if w.Code != 304 || w.Body.Len() != 0 {
+		t.Errorf("revalidation: code %d, %d bytes; want an empty 304", w.Code, w.Body.Len())
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// ResponseMeta says where a chart response's data came from: the range asked
+// for, the files and rows read, and when its series were built and whether
+// they came from cache, so a client can show provenance and freshness.
+type ResponseMeta struct {
+	From          string   `json:"from"`
+	To            string   `json:"to"`
+	Files         []string `json:"files"`
+	Rows          int      `json:"rows"`
+	BucketMinutes int      `json:"bucketMinutes,omitempty"`
+	// ResolutionMinutes is the pre-aggregated rollup the buckets were made
+	// from, if not the raw rows.
+	ResolutionMinutes int    `json:"resolutionMinutes,omitempty"`
+	Latest            string `json:"latest,omitempty"` // newest reading charted
+	GeneratedAt       string `json:"generatedAt"`
+	Cached            bool   `json:"cached"`
+}
+
+// chartMeta describes series built from files at built, in loc. The caller
+// fills in the range, which each endpoint states its own way.
+func chartMeta(files []string, rows *gymdata.RowCounts, list []*gymdata.Series, bucketMinutes int, built time.Time, cached bool, loc *time.Location) *ResponseMeta {
+	m := &ResponseMeta{
+		Files:         make([]string, len(files)),
+		Rows:          rows.Total(),
+		BucketMinutes: bucketMinutes,
+		GeneratedAt:   built.In(loc).Format(time.RFC3339),
+		Cached:        cached,
+	}
+	for i, f := range files {
+		m.Files[i] = gymdata.BaseName(f)
+	}
+	var latest int64
+	for _, s := range list {
+		if n := len(s.Points); n > 0 {
+			latest = max(latest, s.Points[n-1].At)
+		}
+	}
+	if latest > 0 {
+		m.Latest = time.Unix(latest, 0).In(loc).Format(time.RFC3339)
+	}
+	return m
+}
+
+// emptyMeta describes a range with no files to read.
+func emptyMeta(dateRange DateRangeRequest, loc *time.Location) *ResponseMeta {
+	return &ResponseMeta{
+		From:        dateRange.From,
+		To:          dateRange.To,
+		Files:       []string{},
+		GeneratedAt: time.Now().In(loc).Format(time.RFC3339),
+	}
+}
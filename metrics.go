@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// latencyBuckets are the histogram bounds, in seconds, for request and parse
+// times: from a cached chart to a year of CSVs read cold.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram counts observations per bucket, Prometheus style: counts[i] is
+// how many fell at or below latencyBuckets[i], before summing.
+type histogram struct {
+	counts []uint64
+	sum    float64
+	n      uint64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	if i, _ := slices.BinarySearch(latencyBuckets, v); i < len(latencyBuckets) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.n++
+}
+
+// write appends h in the text exposition format, labels being the series'
+// other labels ("" for none).
+func (h *histogram) write(b *strings.Builder, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var cum uint64
+	for i, le := range latencyBuckets {
+		if h.counts != nil {
+			cum += h.counts[i]
+		}
+		fmt.Fprintf(b, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, strconv.FormatFloat(le, 'g', -1, 64), cum)
+	}
+	fmt.Fprintf(b, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.n)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(b, "%s_sum%s %s\n%s_count%s %d\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64), name, labels, h.n)
+}
+
+// requestKey is a route's count for one status code.
+type requestKey struct {
+	route string
+	code  int
+}
+
+// cacheKey is a cache's hits or misses.
+type cacheKey struct {
+	cache string
+	hit   bool
+}
+
+var (
+	metricsMu      sync.Mutex
+	requestCounts  = map[requestKey]uint64{}
+	requestLatency = map[string]*histogram{}
+	cacheCounts    = map[cacheKey]uint64{}
+	parseLatency   histogram
+	parsedRows     uint64
+)
+
+func init() {
+	gymdata.ParseObserver = func(hit bool, rows int, took time.Duration) {
+		metricsMu.Lock()
+		defer metricsMu.Unlock()
+		cacheCounts[cacheKey{"csv", hit}]++
+		if !hit {
+			parseLatency.observe(took.Seconds())
+			parsedRows += uint64(rows)
+		}
+	}
+}
+
+// countCache records a lookup in one of the chart caches.
+func countCache(cache string, hit bool) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	cacheCounts[cacheKey{cache, hit}]++
+}
+
+// statusRecorder remembers the status a handler replied with. It passes
+// Flush on, which the event streams and streamed charts need.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.code == 0 {
+		s.code = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.code == 0 {
+		s.code = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+// withMetrics counts each request and times it under the mux pattern it
+// matched, so paths with IDs in them don't each become a series.
+func withMetrics(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		mux.ServeHTTP(rec, r)
+		took := time.Since(start).Seconds()
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+
+		metricsMu.Lock()
+		defer metricsMu.Unlock()
+		requestCounts[requestKey{route, rec.code}]++
+		h := requestLatency[route]
+		if h == nil {
+			h = &histogram{}
+			requestLatency[route] = h
+		}
+		h.observe(took)
+	})
+}
+
+// metricsHandler serves the counters in Prometheus' text format.
+//
+//	GET /metrics
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	metricsMu.Lock()
+	b.WriteString("# HELP gym_http_requests_total Requests served, by route and status code.\n# TYPE gym_http_requests_total counter\n")
+	keys := make([]requestKey, 0, len(requestCounts))
+	for k := range requestCounts {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b requestKey) int {
+		if c := strings.Compare(a.route, b.route); c != 0 {
+			return c
+		}
+		return a.code - b.code
+	})
+	for _, k := range keys {
+		fmt.Fprintf(&b, "gym_http_requests_total{route=%q,code=\"%d\"} %d\n", k.route, k.code, requestCounts[k])
+	}
+
+	b.WriteString("# HELP gym_http_request_duration_seconds Time to serve a request, by route.\n# TYPE gym_http_request_duration_seconds histogram\n")
+	routes := make([]string, 0, len(requestLatency))
+	for route := range requestLatency {
+		routes = append(routes, route)
+	}
+	slices.Sort(routes)
+	for _, route := range routes {
+		requestLatency[route].write(&b, "gym_http_request_duration_seconds", fmt.Sprintf("route=%q", route))
+	}
+
+	b.WriteString("# HELP gym_cache_lookups_total Cache lookups: csv is parsed files, range, recent and latest built charts.\n# TYPE gym_cache_lookups_total counter\n")
+	for _, cache := range []string{"csv", "range", "recent", "latest"} {
+		fmt.Fprintf(&b, "gym_cache_lookups_total{cache=%q,result=\"hit\"} %d\n", cache, cacheCounts[cacheKey{cache, true}])
+		fmt.Fprintf(&b, "gym_cache_lookups_total{cache=%q,result=\"miss\"} %d\n", cache, cacheCounts[cacheKey{cache, false}])
+	}
+
+	b.WriteString("# HELP gym_csv_parse_duration_seconds Time to parse a CSV file the cache didn't have.\n# TYPE gym_csv_parse_duration_seconds histogram\n")
+	parseLatency.write(&b, "gym_csv_parse_duration_seconds", "")
+	b.WriteString("# HELP gym_csv_rows_parsed_total CSV rows parsed.\n# TYPE gym_csv_rows_parsed_total counter\n")
+	fmt.Fprintf(&b, "gym_csv_rows_parsed_total %d\n", parsedRows)
+	metricsMu.Unlock()
+
+	admission.Lock()
+	b.WriteString("# HELP gym_heavy_requests_running Heavy requests running now.\n# TYPE gym_heavy_requests_running gauge\n")
+	fmt.Fprintf(&b, "gym_heavy_requests_running %d\n", admission.running)
+	b.WriteString("# HELP gym_heavy_requests_queued Heavy requests waiting for a turn.\n# TYPE gym_heavy_requests_queued gauge\n")
+	fmt.Fprintf(&b, "gym_heavy_requests_queued %d\n", admission.queued)
+	b.WriteString("# HELP gym_heavy_requests_rejected_total Heavy requests refused with 503 while saturated.\n# TYPE gym_heavy_requests_rejected_total counter\n")
+	fmt.Fprintf(&b, "gym_heavy_requests_rejected_total %d\n", admission.rejected)
+	admission.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
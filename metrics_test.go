@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gym/internal/gymdata"
+)
+
+func TestMetrics(t *testing.T) {
+	loadTallinn(t)
+	dir := t.TempDir()
+	file := writeCSV(t, dir, "gym-stats-20251001.csv", "timestamp,timezone,location_id,location_name,user_count,status,response\n"+
+		"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,{}\n"+
+		"2025-10-01 10:02:00,EEST,1,Hipodroom,13,success,{}\n")
+	for range 2 {
+		if _, _, err := gymdata.Load(gymdata.Format{TimeLayout: gymdata.DefaultTimeLayout}, []string{file}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/jobs/", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) })
+	mux.HandleFunc("/metrics", metricsHandler)
+	h := withMetrics(mux)
+	for _, path := range []string{"/api/jobs/a", "/api/jobs/b", "/metrics"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`gym_http_requests_total{route="/api/jobs/",code="404"} 2`,
+		`gym_http_requests_total{route="/metrics",code="200"} 1`,
+		`gym_http_request_duration_seconds_bucket{route="/api/jobs/",le="+Inf"} 2`,
+		`gym_cache_lookups_total{cache="csv",result="hit"} `,
+		"gym_csv_parse_duration_seconds_count ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "gym_csv_rows_parsed_total 0\n") || strings.Contains(body, `{cache="csv",result="hit"} 0`) {
+		t.Errorf("parses not counted:\n%s", body)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+const (
+	// mobileStep is the sparkline's resolution: 12 points over 3 hours.
+	mobileStep = 15 * 60
+	// mobileWeeks is how many past weeks' same hour the next-hour forecast
+	// averages.
+	mobileWeeks = 4
+	// mobileSlack is how far either side of an hour a past week's readings
+	// may be to count for it.
+	mobileSlack = 15 * time.Minute
+)
+
+// MobileGym is one gym on the PWA's home screen. Pct is the count as a
+// share of CAPACITIES, left out for gyms without one. Spark is the last 3
+// hours in 15-minute averages, oldest first, null where there were no
+// readings. Peak is today's highest count so far, at PeakAt (HH:MM,
+// Tallinn), which is left out before today's first reading. Next is the
+// count forecast an hour from now and Trend its direction, both left out
+// without enough history.
+type MobileGym struct {
+	Name   string     `json:"name"`
+	Count  int        `json:"count"`
+	At     string     `json:"at"`
+	Pct    *int       `json:"pct,omitempty"`
+	Spark  []*float64 `json:"spark"`
+	Peak   int        `json:"peak"`
+	PeakAt string     `json:"peakAt,omitempty"`
+	Next   *int       `json:"next,omitempty"`
+	Trend  string     `json:"trend,omitempty"` // up, down or flat
+}
+
+type MobileResponse struct {
+	GeneratedAt string      `json:"generatedAt"`
+	Gyms        []MobileGym `json:"gyms"`
+}
+
+// mobileAverage is the mean of points within mobileSlack of at; ok is false
+// if there are none.
+func mobileAverage(points []gymdata.Point, at time.Time) (avg float64, ok bool) {
+	from, to := at.Add(-mobileSlack).Unix(), at.Add(mobileSlack).Unix()
+	i := sort.Search(len(points), func(i int) bool { return points[i].At >= from })
+	sum, n := 0.0, 0
+	for ; i < len(points) && points[i].At <= to; i++ {
+		sum += points[i].Y
+		n++
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return sum / float64(n), true
+}
+
+// mobileForecast predicts the count an hour after now from count and the
+// change the same hour brought on the same weekday in the last mobileWeeks
+// weeks (past holds their readings). ok is false if no week has readings at
+// both ends. Less than a person, or 5% of the count, either way is flat, as
+// widgetTrend has it.
+func mobileForecast(past []gymdata.Point, count float64, now time.Time) (next int, trend string, ok bool) {
+	sum, weeks := 0.0, 0
+	for k := 1; k <= mobileWeeks; k++ {
+		then := now.AddDate(0, 0, -7*k)
+		a, okA := mobileAverage(past, then)
+		b, okB := mobileAverage(past, then.Add(time.Hour))
+		if okA && okB {
+			sum += b - a
+			weeks++
+		}
+	}
+	if weeks == 0 {
+		return 0, "", false
+	}
+	change := sum / float64(weeks)
+	trend = "flat"
+	switch limit := math.Max(1, 0.05*count); {
+	case change >= limit:
+		trend = "up"
+	case change <= -limit:
+		trend = "down"
+	}
+	return int(math.Round(math.Max(0, count+change))), trend, true
+}
+
+// mobilePast loads the readings around the next hour on the same weekday
+// in the last mobileWeeks weeks, by gym. A week with no files is skipped.
+func mobilePast(r *http.Request, cfg *Config, now time.Time) (map[string][]gymdata.Point, error) {
+	var files []string
+	seen := map[string]bool{}
+	for k := 1; k <= mobileWeeks; k++ {
+		then := now.AddDate(0, 0, -7*k)
+		week, err := recentFiles(cfg.csvDir(), then.Add(-mobileSlack), then.Add(time.Hour+mobileSlack))
+		if err != nil {
+			continue
+		}
+		for _, f := range week {
+			if !seen[f] {
+				seen[f] = true
+				files = append(files, f)
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	list, _, err := traceLoad(r.Context(), cfg, files, []string{gymdata.DefaultMetric}, nil)
+	if err != nil {
+		return nil, err
+	}
+	past := map[string][]gymdata.Point{}
+	for _, s := range list {
+		past[s.Key.Location] = s.Points
+	}
+	return past, nil
+}
+
+// mobileHandler serves every open gym's current count, share of capacity,
+// 3-hour sparkline, today's peak and next-hour forecast in one small reply,
+// all the PWA's home screen shows. The counts come from /api/recent's
+// cached build of the last 24 hours, which the latest window keeps built;
+// the forecast reads the same hour of the past few weeks. Replies may be
+// cached for a minute.
+//
+//	GET /api/mobile
+func mobileHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg := requestConfig(r)
+	tallinn := gymdata.Tallinn()
+	mode, _ := requestOutlierMode(cfg, "")
+	metrics := []string{gymdata.DefaultMetric}
+	noteRecentView(cfg, recentView{hours: 24, metrics: metrics, mode: mode})
+	res, _, _, err := buildRecent(r.Context(), cfg, 24, metrics, "", tallinn, mode, 0)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	now := time.Now().In(tallinn)
+	past, err := mobilePast(r, cfg, now)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, withKind(ErrParse, err))
+		return
+	}
+
+	y, m, d := now.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, tallinn).Unix()
+	out := MobileResponse{GeneratedAt: now.Format(time.RFC3339), Gyms: []MobileGym{}}
+	for _, s := range withoutClosed(res.list, closedLocations(cfg)) {
+		if len(s.Points) == 0 {
+			continue
+		}
+		last := s.Points[len(s.Points)-1]
+		g := MobileGym{
+			Name:  s.Key.Location,
+			Count: int(math.Round(last.Y)),
+			At:    time.Unix(last.At, 0).In(tallinn).Format(time.RFC3339),
+			Spark: widgetSpark(s.Points, res.to.Add(-widgetHours*time.Hour), res.to, mobileStep),
+		}
+		peak := -1
+		for i, p := range s.Points {
+			if p.At >= midnight && (peak < 0 || p.Y > s.Points[peak].Y) {
+				peak = i
+			}
+		}
+		if peak >= 0 {
+			g.Peak = int(math.Round(s.Points[peak].Y))
+			g.PeakAt = time.Unix(s.Points[peak].At, 0).In(tallinn).Format("15:04")
+		}
+		if c, ok := cfg.Capacities[strings.ToLower(s.Key.Location)]; ok && c > 0 {
+			pct := int(math.Round(last.Y / c * 100))
+			g.Pct = &pct
+		}
+		if next, trend, ok := mobileForecast(past[s.Key.Location], last.Y, now); ok {
+			g.Next, g.Trend = &next, trend
+		}
+		out.Gyms = append(out.Gyms, g)
+	}
+	w.Header().Set("Cache-Control", "max-age=60")
+	writeNegotiated(w, r, out, nil)
+}
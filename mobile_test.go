@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMobileHandler(t *testing.T) {
+	tallinn := loadTallinn(t)
+	old := currentConfig()
+	defer setConfig(old)
+	dir := t.TempDir()
+	cfg := &Config{DataDir: dir, AnonymousRole: RoleViewer, Capacities: map[string]float64{"hipodroom": 80}}
+	setConfig(cfg)
+
+	// Hipodroom climbs through the last hour, and on each of the last four
+	// weeks the next hour brought 10 more; Kristiine last reported 4 hours
+	// ago and has no history.
+	days := map[string]string{}
+	add := func(at time.Time, name string, n int) {
+		local := at.In(tallinn)
+		file := "gym-stats-" + local.Format("20060102") + ".csv"
+		if days[file] == "" {
+			days[file] = "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+		}
+		days[file] += fmt.Sprintf("%s,%s,1,%s,%d,success,{}\n", local.Format("2006-01-02 15:04:05"), local.Format("MST"), name, n)
+	}
+	now := time.Now().In(tallinn).Truncate(2 * time.Minute)
+	for k := 4; k >= 1; k-- {
+		then := now.AddDate(0, 0, -7*k)
+		add(then, "Hipodroom", 20)
+		add(then.Add(time.Hour), "Hipodroom", 30)
+	}
+	add(now.Add(-4*time.Hour), "Kristiine", 10)
+	for i := 30; i >= 1; i-- {
+		add(now.Add(-time.Duration(i)*2*time.Minute), "Hipodroom", 60-i)
+	}
+	for file, content := range days {
+		writeCSV(t, dir, file, content)
+	}
+
+	w := httptest.NewRecorder()
+	mobileHandler(w, httptest.NewRequest("GET", "/api/mobile", nil))
+	var got MobileResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("code %d: %s", w.Code, w.Body)
+	}
+	if w.Body.Len() > 5<<10 {
+		t.Errorf("reply is %d bytes, want under 5 KB", w.Body.Len())
+	}
+	if len(got.Gyms) != 2 {
+		t.Fatalf("gyms = %+v, want Hipodroom and Kristiine", got.Gyms)
+	}
+	h, k := got.Gyms[0], got.Gyms[1]
+	if h.Name != "Hipodroom" || h.Count != 59 || h.Pct == nil || *h.Pct != 74 {
+		t.Errorf("Hipodroom = %+v", h)
+	}
+	if len(h.Spark) != 12 || h.Spark[0] != nil || h.Spark[11] == nil {
+		t.Errorf("spark = %v, want 12 points, the last hour's filled", h.Spark)
+	}
+	if h.Next == nil || *h.Next != 69 || h.Trend != "up" {
+		t.Errorf("forecast = %v %q, want 69 and up", h.Next, h.Trend)
+	}
+	if now.Add(-time.Hour).Day() == now.Day() && (h.Peak != 59 || h.PeakAt != now.Add(-2*time.Minute).Format("15:04")) {
+		t.Errorf("peak = %d at %s, want the last reading", h.Peak, h.PeakAt)
+	}
+	if k.Name != "Kristiine" || k.Count != 10 || k.Pct != nil || k.Next != nil || k.Trend != "" {
+		t.Errorf("Kristiine = %+v, want its count and no capacity or forecast", k)
+	}
+	if w.Header().Get("Cache-Control") != "max-age=60" {
+		t.Errorf("headers = %v", w.Header())
+	}
+	w = httptest.NewRecorder()
+	mobileHandler(w, httptest.NewRequest("POST", "/api/mobile", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: code %d, want 405", w.Code)
+	}
+}
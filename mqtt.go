@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// A minimal MQTT 3.1.1 publisher: CONNECT (with a retained "offline" will),
+// QoS 0 PUBLISH and DISCONNECT are all Home Assistant needs from us.
+
+const (
+	mqttConnect = 0x10
+	mqttConnack = 0x20
+	mqttPublish = 0x30
+)
+
+type mqttClient struct {
+	conn net.Conn
+	w    *bufio.Writer
+}
+
+// mqttRemainingLength encodes n with MQTT's 7-bits-per-byte varint.
+func mqttRemainingLength(n int) []byte {
+	var out []byte
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if n == 0 {
+			return out
+		}
+	}
+}
+
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+func mqttPacket(header byte, body []byte) []byte {
+	pkt := append([]byte{header}, mqttRemainingLength(len(body))...)
+	return append(pkt, body...)
+}
+
+// mqttConnectPacket builds a clean-session CONNECT with a retained will on
+// willTopic, so HA marks the sensors unavailable if the server dies.
+func mqttConnectPacket(clientID, username, password, willTopic, willPayload string, keepAlive time.Duration) []byte {
+	flags := byte(0x02)  // clean session
+	flags |= 0x04 | 0x20 // will flag, will retain (QoS 0)
+	if username != "" {
+		flags |= 0x80
+		if password != "" {
+			flags |= 0x40
+		}
+	}
+	ka := int(keepAlive / time.Second)
+	if ka > 0xFFFF {
+		ka = 0xFFFF
+	}
+
+	body := mqttString("MQTT")
+	body = append(body, 0x04, flags, byte(ka>>8), byte(ka))
+	body = append(body, mqttString(clientID)...)
+	body = append(body, mqttString(willTopic)...)
+	body = append(body, mqttString(willPayload)...)
+	if username != "" {
+		body = append(body, mqttString(username)...)
+		if password != "" {
+			body = append(body, mqttString(password)...)
+		}
+	}
+	return mqttPacket(mqttConnect, body)
+}
+
+func mqttPublishPacket(topic string, payload []byte, retain bool) []byte {
+	header := byte(mqttPublish)
+	if retain {
+		header |= 0x01
+	}
+	body := append(mqttString(topic), payload...)
+	return mqttPacket(header, body)
+}
+
+// mqttDial connects to broker ("host:port", optionally "tcp://" or "mqtt://"
+// prefixed) and waits for a successful CONNACK.
+func mqttDial(broker, clientID, username, password, willTopic string, keepAlive time.Duration) (*mqttClient, error) {
+	addr := strings.TrimPrefix(strings.TrimPrefix(broker, "tcp://"), "mqtt://")
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "1883")
+	}
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c := &mqttClient{conn: conn, w: bufio.NewWriter(conn)}
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := c.write(mqttConnectPacket(clientID, username, password, willTopic, "offline", keepAlive)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reading CONNACK: %v", err)
+	}
+	if ack[0] != mqttConnack || ack[3] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("broker refused connection (code %d)", ack[3])
+	}
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func (c *mqttClient) write(pkt []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.w.Write(pkt); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+func (c *mqttClient) publish(topic string, payload []byte, retain bool) error {
+	return c.write(mqttPublishPacket(topic, payload, retain))
+}
+
+// haSlug turns a location name into a topic/ID-safe token ("Suur-Paala" →
+// "suur_paala").
+func haSlug(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		default:
+			if b.Len() > 0 && !strings.HasSuffix(b.String(), "_") {
+				b.WriteByte('_')
+			}
+		}
+	}
+	return strings.TrimSuffix(b.String(), "_")
+}
+
+// haDiscoveryConfig is the retained sensor config Home Assistant picks up
+// from <discovery prefix>/sensor/<object id>/config.
+func haDiscoveryConfig(c *Config, location string) (topic string, payload []byte) {
+	slug := haSlug(location)
+	objectID := c.MQTTTopicPrefix + "_" + slug
+	stateTopic := c.MQTTTopicPrefix + "/" + slug + "/state"
+	payload, _ = json.Marshal(map[string]any{
+		"name":                  "Occupancy",
+		"unique_id":             objectID + "_occupancy",
+		"object_id":             objectID + "_occupancy",
+		"state_topic":           stateTopic,
+		"value_template":        "{{ value_json.count }}",
+		"json_attributes_topic": stateTopic,
+		"availability_topic":    c.MQTTTopicPrefix + "/status",
+		"unit_of_measurement":   "people",
+		"state_class":           "measurement",
+		"icon":                  "mdi:account-group",
+		"device": map[string]any{
+			"identifiers":  []string{objectID},
+			"name":         location,
+			"manufacturer": "ronimis",
+			"model":        "Gym occupancy",
+		},
+	})
+	return c.MQTTDiscoveryPrefix + "/sensor/" + objectID + "/config", payload
+}
+
+// sameMQTTSession reports whether a connection opened under a can keep
+// serving b; any change to the broker, credentials or topics reconnects (which
+// also re-announces discovery under the new topics).
+func sameMQTTSession(a, b *Config) bool {
+	return a.MQTTBroker == b.MQTTBroker && a.MQTTUsername == b.MQTTUsername &&
+		a.MQTTPassword == b.MQTTPassword && a.MQTTClientID == b.MQTTClientID &&
+		a.MQTTDiscoveryPrefix == b.MQTTDiscoveryPrefix && a.MQTTTopicPrefix == b.MQTTTopicPrefix
+}
+
+// runMQTTPublisher pushes the latest per-location counts to the broker every
+// MQTTInterval, announcing each new location via HA discovery first. Broker
+// errors drop the connection and it is re-established on the next tick. It
+// idles while MQTT_BROKER is unset and follows config reloads.
+func runMQTTPublisher() {
+	tallinn := gymdata.Tallinn()
+
+	var client *mqttClient
+	var session *Config
+	announced := map[string]bool{}
+	publish := func(c *Config) error {
+		availability := c.MQTTTopicPrefix + "/status"
+		if client == nil {
+			cl, err := mqttDial(c.MQTTBroker, c.MQTTClientID, c.MQTTUsername, c.MQTTPassword, availability, 2*c.MQTTInterval)
+			if err != nil {
+				return err
+			}
+			client, session = cl, c
+			announced = map[string]bool{} // re-announce after a reconnect
+			log.Printf("MQTT: publishing to %s every %s", c.MQTTBroker, c.MQTTInterval)
+			if err := client.publish(availability, []byte("online"), true); err != nil {
+				return err
+			}
+		}
+
+		status := readLatestStatus(c, tallinn)
+		for _, loc := range status.Locations {
+			if !announced[loc.Name] {
+				topic, payload := haDiscoveryConfig(c, loc.Name)
+				if err := client.publish(topic, payload, true); err != nil {
+					return err
+				}
+				announced[loc.Name] = true
+			}
+			state, _ := json.Marshal(map[string]any{
+				"count":       loc.Count,
+				"at":          loc.At,
+				"age_seconds": status.AgeSeconds,
+			})
+			if err := client.publish(c.MQTTTopicPrefix+"/"+haSlug(loc.Name)+"/state", state, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for {
+		c := currentConfig()
+		if client != nil && !sameMQTTSession(session, c) {
+			client.conn.Close()
+			client = nil
+		}
+		if c.MQTTBroker == "" {
+			time.Sleep(30 * time.Second)
+			continue
+		}
+		if err := publish(c); err != nil {
+			log.Printf("MQTT: %v", err)
+			if client != nil {
+				client.conn.Close()
+				client = nil
+			}
+		}
+		time.Sleep(c.MQTTInterval)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMQTTRemainingLength(t *testing.T) {
+	cases := []struct {
+		n    int
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7F}},
+		{128, []byte{0x80, 0x01}},
+		{16383, []byte{0xFF, 0x7F}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+	}
+	for _, c := range cases {
+		if got := mqttRemainingLength(c.n); !bytes.Equal(got, c.want) {
+			t.Errorf("mqttRemainingLength(%d) = % x, want % x", c.n, got, c.want)
+		}
+	}
+}
+
+func TestMQTTConnectPacket(t *testing.T) {
+	pkt := mqttConnectPacket("id", "", "", "r/status", "offline", 240*time.Second)
+	if pkt[0] != mqttConnect {
+		t.Fatalf("type = %#x, want CONNECT", pkt[0])
+	}
+	if int(pkt[1]) != len(pkt)-2 {
+		t.Errorf("remaining length = %d, want %d", pkt[1], len(pkt)-2)
+	}
+	// fixed header(2) + "MQTT"(6) + level(1) → flags, then keep-alive
+	if flags := pkt[9]; flags != 0x26 {
+		t.Errorf("flags = %#x, want clean session + retained will (0x26)", flags)
+	}
+	if ka := int(pkt[10])<<8 | int(pkt[11]); ka != 240 {
+		t.Errorf("keep-alive = %d, want 240", ka)
+	}
+
+	withAuth := mqttConnectPacket("id", "user", "pw", "r/status", "offline", time.Minute)
+	if flags := withAuth[9]; flags&0xC0 != 0xC0 {
+		t.Errorf("flags = %#x, want username and password bits", flags)
+	}
+}
+
+func TestHASlug(t *testing.T) {
+	for in, want := range map[string]string{
+		"Suur-Paala":    "suur_paala",
+		"T1":            "t1",
+		"SQ Kristiine":  "sq_kristiine",
+		" -Hipodroom- ": "hipodroom",
+	} {
+		if got := haSlug(in); got != want {
+			t.Errorf("haSlug(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// mediaType is a representation the data endpoints can reply in.
+type mediaType int
+
+const (
+	asJSON mediaType = iota
+	asMsgpack
+	asCSV
+)
+
+// mediaTypes are the Accept values each representation answers to, the
+// first being the Content-Type sent back.
+var mediaTypes = [][]string{
+	asJSON:    {"application/json"},
+	asMsgpack: {"application/msgpack", "application/vnd.msgpack", "application/x-msgpack"},
+	asCSV:     {"text/csv"},
+}
+
+// negotiate picks the representation the request's Accept header prefers:
+// highest q first, then a type named outright over one matched by a
+// wildcard, then the order the client listed them. JSON is what a request
+// without the header, or accepting nothing we have, gets, error replies
+// included.
+func negotiate(r *http.Request) mediaType {
+	type score struct {
+		q        float64
+		specific bool
+		pos      int
+	}
+	best := make([]score, len(mediaTypes))
+	for pos, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok && strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		for t, names := range mediaTypes {
+			major, _, _ := strings.Cut(names[0], "/")
+			var s score
+			switch {
+			case slices.Contains(names, name):
+				s = score{q, true, pos}
+			case name == "*/*" || name == major+"/*":
+				s = score{q, false, pos}
+			default:
+				continue
+			}
+			if cur := best[t]; cur.q == 0 || (s.specific && !cur.specific) {
+				best[t] = s
+			}
+		}
+	}
+	chosen := asJSON
+	for t := range mediaTypes {
+		s, c := best[t], best[chosen]
+		if s.q > c.q || (s.q == c.q && s.q > 0 && (s.specific && !c.specific || s.specific == c.specific && s.pos < c.pos)) {
+			chosen = mediaType(t)
+		}
+	}
+	return chosen
+}
+
+// setMediaType sets the reply's Content-Type for t. The body depends on
+// Accept, so caches are told so.
+func setMediaType(w http.ResponseWriter, t mediaType) {
+	w.Header().Add("Vary", "Accept")
+	switch t {
+	case asCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	default:
+		w.Header().Set("Content-Type", mediaTypes[t][0])
+	}
+}
+
+// writeNegotiated writes v in the representation the request asks for. CSV
+// is table's rows, header first; with no table the reply is JSON.
+func writeNegotiated(w http.ResponseWriter, r *http.Request, v any, table func() [][]string) error {
+	t := negotiate(r)
+	if t == asCSV && table == nil {
+		t = asJSON
+	}
+	setMediaType(w, t)
+	switch t {
+	case asMsgpack:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return transcodeMsgpack(w, data)
+	case asCSV:
+		cw := csv.NewWriter(w)
+		cw.WriteAll(table())
+		return cw.Error()
+	}
+	return json.NewEncoder(w).Encode(v)
+}
+
+// msgpackWriter writes MessagePack, always in the smallest form a value
+// fits.
+type msgpackWriter struct {
+	*bufio.Writer
+	buf [9]byte
+}
+
+func (m *msgpackWriter) head(b byte, n int, size int) {
+	m.buf[0] = b
+	switch size {
+	case 1:
+		m.buf[1] = byte(n)
+	case 2:
+		binary.BigEndian.PutUint16(m.buf[1:], uint16(n))
+	case 4:
+		binary.BigEndian.PutUint32(m.buf[1:], uint32(n))
+	case 8:
+		binary.BigEndian.PutUint64(m.buf[1:], uint64(n))
+	}
+	m.Write(m.buf[:1+size])
+}
+
+func (m *msgpackWriter) null() { m.WriteByte(0xc0) }
+
+func (m *msgpackWriter) bool(b bool) {
+	if b {
+		m.WriteByte(0xc3)
+	} else {
+		m.WriteByte(0xc2)
+	}
+}
+
+func (m *msgpackWriter) int(n int64) {
+	switch {
+	case n >= 0 && n < 128:
+		m.WriteByte(byte(n))
+	case n < 0 && n >= -32:
+		m.WriteByte(byte(n))
+	case n >= 0 && n <= math.MaxUint8:
+		m.head(0xcc, int(n), 1)
+	case n >= 0 && n <= math.MaxUint16:
+		m.head(0xcd, int(n), 2)
+	case n >= 0 && n <= math.MaxUint32:
+		m.head(0xce, int(n), 4)
+	case n >= math.MinInt8 && n < 0:
+		m.head(0xd0, int(n), 1)
+	case n >= math.MinInt16 && n < 0:
+		m.head(0xd1, int(n), 2)
+	case n >= math.MinInt32 && n < 0:
+		m.head(0xd2, int(n), 4)
+	default:
+		m.head(0xd3, int(n), 8)
+	}
+}
+
+// float writes f, as an integer when it is one: headcounts nearly always
+// are, and a small int is one byte instead of nine.
+func (m *msgpackWriter) float(f float64) {
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		m.int(int64(f))
+		return
+	}
+	m.buf[0] = 0xcb
+	binary.BigEndian.PutUint64(m.buf[1:], math.Float64bits(f))
+	m.Write(m.buf[:9])
+}
+
+func (m *msgpackWriter) str(s string) {
+	switch n := len(s); {
+	case n < 32:
+		m.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		m.head(0xd9, n, 1)
+	case n <= math.MaxUint16:
+		m.head(0xda, n, 2)
+	default:
+		m.head(0xdb, n, 4)
+	}
+	m.WriteString(s)
+}
+
+func (m *msgpackWriter) array(n int) {
+	switch {
+	case n < 16:
+		m.WriteByte(0x90 | byte(n))
+	case n <= math.MaxUint16:
+		m.head(0xdc, n, 2)
+	default:
+		m.head(0xdd, n, 4)
+	}
+}
+
+func (m *msgpackWriter) mapHead(n int) {
+	switch {
+	case n < 16:
+		m.WriteByte(0x80 | byte(n))
+	case n <= math.MaxUint16:
+		m.head(0xde, n, 2)
+	default:
+		m.head(0xdf, n, 4)
+	}
+}
+
+// value writes v, a value decoded from JSON with UseNumber. Object keys are
+// written sorted, so a reply is the same bytes each time.
+func (m *msgpackWriter) value(v any) {
+	switch v := v.(type) {
+	case nil:
+		m.null()
+	case bool:
+		m.bool(v)
+	case string:
+		m.str(v)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			m.int(n)
+		} else {
+			f, _ := v.Float64()
+			m.float(f)
+		}
+	case []any:
+		m.array(len(v))
+		for _, e := range v {
+			m.value(e)
+		}
+	case map[string]any:
+		m.mapHead(len(v))
+		for _, k := range sortedKeys(v) {
+			m.str(k)
+			m.value(v[k])
+		}
+	}
+}
+
+func sortedKeys(obj map[string]any) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// decodeJSON decodes data keeping numbers as written.
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	err := dec.Decode(&v)
+	return v, err
+}
+
+// transcodeMsgpack writes the JSON document data as MessagePack.
+func transcodeMsgpack(w io.Writer, data []byte) error {
+	v, err := decodeJSON(data)
+	if err != nil {
+		return err
+	}
+	m := &msgpackWriter{Writer: bufio.NewWriter(w)}
+	m.value(v)
+	return m.Flush()
+}
+
+// writeChartResponse writes a chart endpoint's reply in the representation
+// the request asks for: JSON as writeGenerateResponse does, MessagePack with
+// the same fields, or CSV of just the points (see datasetsCSVHeader). It is
+// sent no-cache unless the caller set a Cache-Control of its own.
+func writeChartResponse(w http.ResponseWriter, r *http.Request, resp GenerateResponse, list []*gymdata.Series, pf pointFormat) error {
+	historyFor(r).noteChart(resp.Meta, list)
+	t := negotiate(r)
+	setMediaType(w, t)
+	if w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", "no-cache") // snapshots are the copies to keep
+	}
+	_, span := startSpan(r.Context(), "encode")
+	span.set("format", mediaTypes[t][0])
+	span.set("series", len(list))
+	var err error
+	switch t {
+	case asMsgpack:
+		err = writeGenerateMsgpack(w, resp, list, pf)
+	case asCSV:
+		err = writeDatasetsCSV(w, list, pf)
+	default:
+		err = writeGenerateResponse(w, resp, list, pf)
+	}
+	span.finish(err)
+	return err
+}
+
+// writeGenerateMsgpack is writeGenerateResponse in MessagePack, the series
+// written straight from list as writeDatasets does. Keys are sorted as
+// value sorts them, so the bytes are those of the JSON reply transcoded.
+func writeGenerateMsgpack(w io.Writer, resp GenerateResponse, list []*gymdata.Series, pf pointFormat) error {
+	resp.Datasets = nil
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	v, err := decodeJSON(data)
+	if err != nil {
+		return err
+	}
+	head := v.(map[string]any)
+	head["datasets"] = nil // placeholder, so it sorts into place
+	m := &msgpackWriter{Writer: bufio.NewWriter(w)}
+	m.mapHead(len(head))
+	for _, k := range sortedKeys(head) {
+		m.str(k)
+		if k == "datasets" {
+			m.datasets(list, pf)
+		} else {
+			m.value(head[k])
+		}
+	}
+	return m.Flush()
+}
+
+// datasets writes the series as writeDatasets' array, keys sorted.
+func (m *msgpackWriter) datasets(list []*gymdata.Series, pf pointFormat) {
+	x := func(at int64) {
+		if pf.millis {
+			m.int(at * 1000)
+		} else {
+			m.str(time.Unix(at, 0).In(pf.loc).Format("2006-01-02T15:04:05Z07:00"))
+		}
+	}
+	m.array(len(list))
+	for _, s := range list {
+		flagged := make(map[int64]bool, len(s.Flagged))
+		for _, at := range s.Flagged {
+			flagged[at] = true
+		}
+		listFlagged := pf.pairs && len(flagged) > 0
+		fields := 2
+		if s.Key.Metric != "" {
+			fields++
+		}
+		if listFlagged {
+			fields++
+		}
+		m.mapHead(fields)
+		m.str("data")
+		m.array(len(s.Points))
+		for _, p := range s.Points {
+			switch {
+			case pf.pairs:
+				m.array(2)
+			case flagged[p.At]:
+				m.mapHead(3)
+				m.str("flagged")
+				m.bool(true)
+				m.str("x")
+			default:
+				m.mapHead(2)
+				m.str("x")
+			}
+			x(p.At)
+			if !pf.pairs {
+				m.str("y")
+			}
+			if math.IsNaN(p.Y) {
+				m.null()
+			} else {
+				m.float(p.Y)
+			}
+		}
+		if listFlagged {
+			m.str("flagged")
+			n := 0
+			for _, p := range s.Points {
+				if flagged[p.At] {
+					n++
+				}
+			}
+			m.array(n)
+			for _, p := range s.Points {
+				if flagged[p.At] {
+					x(p.At)
+				}
+			}
+		}
+		m.str("label")
+		m.str(s.Key.Label())
+		if s.Key.Metric != "" {
+			m.str("metric")
+			m.str(s.Key.Metric)
+		}
+	}
+}
+
+// datasetsCSVHeader heads the CSV of a chart: a row per point, x as the
+// request's timestamps option has it.
+var datasetsCSVHeader = []string{"label", "metric", "x", "y", "flagged"}
+
+// writeDatasetsCSV writes the series' points as CSV.
+func writeDatasetsCSV(w io.Writer, list []*gymdata.Series, pf pointFormat) error {
+	cw := csv.NewWriter(w)
+	cw.Write(datasetsCSVHeader)
+	num := make([]byte, 0, 32)
+	for _, s := range list {
+		flagged := map[int64]bool{}
+		for _, at := range s.Flagged {
+			flagged[at] = true
+		}
+		label := s.Key.Label()
+		for _, p := range s.Points {
+			x := time.Unix(p.At, 0).In(pf.loc).Format("2006-01-02T15:04:05Z07:00")
+			if pf.millis {
+				x = strconv.FormatInt(p.At*1000, 10)
+			}
+			mark := ""
+			if flagged[p.At] {
+				mark = "true"
+			}
+			y := ""
+			if !math.IsNaN(p.Y) {
+				y = string(jsonFloat(num[:0], p.Y))
+			}
+			cw.Write([]string{label, s.Key.Metric, x, y, mark})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// transcodeChartCSV writes the points of a stored chart reply, such as a
+// job's result, as writeDatasetsCSV would have.
+func transcodeChartCSV(w io.Writer, data []byte) error {
+	var stored struct {
+		Datasets []struct {
+			Label   string            `json:"label"`
+			Metric  string            `json:"metric"`
+			Data    []json.RawMessage `json:"data"`
+			Flagged []json.RawMessage `json:"flagged"`
+		} `json:"datasets"`
+	}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	text := func(raw json.RawMessage) string {
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			return s
+		}
+		return string(raw)
+	}
+	cw := csv.NewWriter(w)
+	cw.Write(datasetsCSVHeader)
+	for _, d := range stored.Datasets {
+		flagged := map[string]bool{}
+		for _, raw := range d.Flagged {
+			flagged[text(raw)] = true
+		}
+		for _, raw := range d.Data {
+			var x, y string
+			mark := ""
+			var pair []json.RawMessage
+			var obj struct {
+				X       json.RawMessage `json:"x"`
+				Y       json.RawMessage `json:"y"`
+				Flagged bool            `json:"flagged"`
+			}
+			switch {
+			case json.Unmarshal(raw, &pair) == nil && len(pair) == 2:
+				x, y = text(pair[0]), string(pair[1])
+			case json.Unmarshal(raw, &obj) == nil:
+				x, y = text(obj.X), string(obj.Y)
+				if obj.Flagged {
+					mark = "true"
+				}
+			default:
+				continue
+			}
+			if flagged[x] {
+				mark = "true"
+			}
+			if y == "null" {
+				y = "" // an unfilled gap, as writeDatasetsCSV leaves it
+			}
+			cw.Write([]string{d.Label, d.Metric, x, y, mark})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNegotiate(t *testing.T) {
+	for accept, want := range map[string]mediaType{
+		"":                                      asJSON,
+		"*/*":                                   asJSON,
+		"text/html":                             asJSON,
+		"text/csv":                              asCSV,
+		"text/*":                                asCSV,
+		"application/msgpack":                   asMsgpack,
+		"application/x-msgpack, */*;q=0.1":      asMsgpack,
+		"application/msgpack, application/json": asMsgpack,
+		"application/json, application/msgpack": asJSON,
+		"application/json;q=0.5, text/csv":      asCSV,
+		"application/*, application/msgpack":    asMsgpack,
+		"application/vnd.msgpack;q=0, */*":      asJSON,
+	} {
+		r := httptest.NewRequest("GET", "/status", nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		if got := negotiate(r); got != want {
+			t.Errorf("Accept %q: got %v, want %v", accept, got, want)
+		}
+	}
+}
+
+func TestMsgpack(t *testing.T) {
+	var buf bytes.Buffer
+	if err := transcodeMsgpack(&buf, []byte(`{"b":[true,null,"x",1.5,-3,300],"a":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x96, 0xc3, 0xc0, 0xa1, 'x',
+		0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0, 0xfd, 0xcd, 0x01, 0x2c}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got % x\nwant % x", buf.Bytes(), want)
+	}
+
+	// Streamed straight from the series, a chart is the same bytes as its
+	// JSON reply transcoded.
+	list := testSeries(t, "2025-10-01T10:00:00+03:00", 6, "2025-10-01T10:02:00+03:00", 7)
+	list[0].Flagged = []int64{list[0].Points[1].At}
+	for _, points := range []string{"objects", "pairs"} {
+		pf, _ := requestPointFormat(time.UTC, "ms", points)
+		resp := GenerateResponse{Success: true, Message: "ok"}
+		var js, native, transcoded bytes.Buffer
+		writeGenerateResponse(&js, resp, list, pf)
+		if err := writeGenerateMsgpack(&native, resp, list, pf); err != nil {
+			t.Fatal(err)
+		}
+		transcodeMsgpack(&transcoded, js.Bytes())
+		if !bytes.Equal(native.Bytes(), transcoded.Bytes()) {
+			t.Errorf("%s: native % x\ntranscoded % x", points, native.Bytes(), transcoded.Bytes())
+		}
+		if native.Len() >= js.Len() {
+			t.Errorf("%s: MessagePack %d bytes, JSON %d", points, native.Len(), js.Len())
+		}
+	}
+}
+
+func TestChartCSV(t *testing.T) {
+	list := testSeries(t, "2025-10-01T10:00:00+03:00", 6, "2025-10-01T10:02:00+03:00", 7)
+	list[0].Flagged = []int64{list[0].Points[1].At}
+	want := "label,metric,x,y,flagged\n" +
+		"gym,user_count,2025-10-01T07:00:00Z,6,\n" +
+		"gym,user_count,2025-10-01T07:02:00Z,7,true\n"
+
+	r := httptest.NewRequest("GET", "/api/recent", nil)
+	r.Header.Set("Accept", "text/csv")
+	w := httptest.NewRecorder()
+	writeChartResponse(w, r, GenerateResponse{Success: true}, list, pointFormat{loc: time.UTC})
+	if got := w.Body.String(); got != want || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") || w.Header().Get("Vary") != "Accept" {
+		t.Errorf("got %q (%v), want %q", got, w.Header(), want)
+	}
+
+	// A stored reply, as a job's result is, gives the same rows.
+	for _, points := range []string{"objects", "pairs"} {
+		pf, _ := requestPointFormat(time.UTC, "", points)
+		var js, out bytes.Buffer
+		writeGenerateResponse(&js, GenerateResponse{Success: true}, list, pf)
+		if err := transcodeChartCSV(&out, js.Bytes()); err != nil || out.String() != want {
+			t.Errorf("%s: got %q, %v", points, out.String(), err)
+		}
+	}
+}
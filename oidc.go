@@ -0,0 +1,396 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionCookie carries an OpenID Connect login's session ID.
+const sessionCookie = "gym_session"
+
+// oidcLoginTTL is how long a login may take at the provider.
+const oidcLoginTTL = 10 * time.Minute
+
+var oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+// oidcProvider is an issuer's endpoints, from its discovery document, and
+// its signing keys by key ID.
+type oidcProvider struct {
+	Issuer        string `json:"issuer"`
+	AuthEndpoint  string `json:"authorization_endpoint"`
+	TokenEndpoint string `json:"token_endpoint"`
+	JWKSURI       string `json:"jwks_uri"`
+
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time // when keys were last read
+}
+
+// oidcLogin is a login waiting for the provider to send the browser back.
+type oidcLogin struct {
+	verifier, nonce, next, dir string
+	expires                    time.Time
+}
+
+// session is a signed-in browser. The role isn't kept: it is looked up in
+// OIDC_ADMINS on each request, so a reload that drops someone takes effect
+// at once. dir ties it to the tenant it signed in to.
+type session struct {
+	email, dir string
+	expires    time.Time
+}
+
+var (
+	oidcMu        sync.Mutex // guards the maps below and providers' keys
+	oidcProviders = map[string]*oidcProvider{}
+	oidcLogins    = map[string]oidcLogin{}
+	sessions      = map[string]session{}
+)
+
+// randomToken is 32 random bytes, base64url-encoded.
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// oidcRole is the role OIDC_ADMINS gives an email: admin for a listed
+// address or one under a listed @domain, none otherwise.
+func oidcRole(c *Config, email string) Role {
+	email = strings.ToLower(email)
+	for _, a := range c.OIDCAdmins {
+		if a == email || strings.HasPrefix(a, "@") && strings.HasSuffix(email, a) {
+			return RoleAdmin
+		}
+	}
+	return RoleNone
+}
+
+// requestSession returns the email of the browser's live session with c.
+func requestSession(r *http.Request, c *Config) (string, bool) {
+	if c.OIDCIssuer == "" {
+		return "", false
+	}
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", false
+	}
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	s, ok := sessions[cookie.Value]
+	if !ok || s.dir != c.DataDir || time.Now().After(s.expires) {
+		return "", false
+	}
+	return s.email, true
+}
+
+// provider returns c's issuer, reading its discovery document the first
+// time.
+func provider(c *Config) (*oidcProvider, error) {
+	oidcMu.Lock()
+	p := oidcProviders[c.OIDCIssuer]
+	oidcMu.Unlock()
+	if p != nil {
+		return p, nil
+	}
+	p = &oidcProvider{}
+	if err := getJSON(strings.TrimSuffix(c.OIDCIssuer, "/")+"/.well-known/openid-configuration", p); err != nil {
+		return nil, fmt.Errorf("discovery: %v", err)
+	}
+	if p.Issuer != c.OIDCIssuer || p.AuthEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery: issuer %q, want %q with all endpoints", p.Issuer, c.OIDCIssuer)
+	}
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	oidcProviders[c.OIDCIssuer] = p
+	return p, nil
+}
+
+func getJSON(u string, v any) error {
+	res, err := oidcClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", u, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// key returns the provider's RSA key kid, re-reading the key set when it
+// doesn't know it, as after a rotation, but at most once a minute.
+func (p *oidcProvider) key(kid string) (*rsa.PublicKey, error) {
+	oidcMu.Lock()
+	k, fresh := p.keys[kid], time.Since(p.fetched) < time.Minute
+	oidcMu.Unlock()
+	if k != nil {
+		return k, nil
+	}
+	if fresh {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty, Kid, N, E string
+		} `json:"keys"`
+	}
+	if err := getJSON(p.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("keys: %v", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, j := range set.Keys {
+		n, errN := base64.RawURLEncoding.DecodeString(j.N)
+		e, errE := base64.RawURLEncoding.DecodeString(j.E)
+		if j.Kty != "RSA" || errN != nil || errE != nil || len(e) > 4 {
+			continue
+		}
+		keys[j.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	oidcMu.Lock()
+	p.keys, p.fetched = keys, time.Now()
+	oidcMu.Unlock()
+	if k = keys[kid]; k == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return k, nil
+}
+
+// idClaims are the ID token claims a login needs.
+type idClaims struct {
+	Iss           string          `json:"iss"`
+	Aud           json.RawMessage `json:"aud"` // a string or a list
+	Exp           int64           `json:"exp"`
+	Nonce         string          `json:"nonce"`
+	Email         string          `json:"email"`
+	EmailVerified *bool           `json:"email_verified"`
+}
+
+// verifyIDToken checks an RS256 ID token's signature and that it was issued
+// by p, to c's client, for this login, and hasn't expired.
+func verifyIDToken(p *oidcProvider, c *Config, token, nonce string) (*idClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed ID token")
+	}
+	var header struct{ Alg, Kid string }
+	if raw, err := base64.RawURLEncoding.DecodeString(parts[0]); err != nil || json.Unmarshal(raw, &header) != nil {
+		return nil, fmt.Errorf("malformed ID token header")
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("ID token signed with %q, want RS256", header.Alg)
+	}
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, fmt.Errorf("bad ID token signature")
+	}
+
+	var claims idClaims
+	if raw, err := base64.RawURLEncoding.DecodeString(parts[1]); err != nil || json.Unmarshal(raw, &claims) != nil {
+		return nil, fmt.Errorf("malformed ID token claims")
+	}
+	var aud []string
+	if json.Unmarshal(claims.Aud, &aud) != nil {
+		aud = []string{""}
+		json.Unmarshal(claims.Aud, &aud[0])
+	}
+	switch {
+	case claims.Iss != p.Issuer:
+		return nil, fmt.Errorf("ID token from %q", claims.Iss)
+	case !slices.Contains(aud, c.OIDCClientID):
+		return nil, fmt.Errorf("ID token not for this client")
+	case time.Now().After(time.Unix(claims.Exp, 0).Add(time.Minute)):
+		return nil, fmt.Errorf("ID token expired")
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("ID token for another login")
+	case claims.Email == "" || claims.EmailVerified != nil && !*claims.EmailVerified:
+		return nil, fmt.Errorf("no verified email in ID token (ask for the email scope)")
+	}
+	return &claims, nil
+}
+
+// safeNext keeps a post-login redirect on this site.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// oidcLoginHandler sends the browser to the provider to sign in, with PKCE
+// and a nonce, and remembers where to return it.
+//
+//	GET /auth/login[?next=/dashboard.html]
+func oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := requestConfig(r)
+	if cfg.OIDCIssuer == "" {
+		http.NotFound(w, r)
+		return
+	}
+	p, err := provider(cfg)
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		http.Error(w, "sign-in provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	state, login := randomToken(), oidcLogin{
+		verifier: randomToken(),
+		nonce:    randomToken(),
+		next:     safeNext(r.URL.Query().Get("next")),
+		dir:      cfg.DataDir,
+		expires:  time.Now().Add(oidcLoginTTL),
+	}
+	oidcMu.Lock()
+	for k, l := range oidcLogins {
+		if time.Now().After(l.expires) {
+			delete(oidcLogins, k)
+		}
+	}
+	oidcLogins[state] = login
+	oidcMu.Unlock()
+
+	challenge := sha256.Sum256([]byte(login.verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {cfg.OIDCClientID},
+		"redirect_uri":          {cfg.OIDCRedirectURL},
+		"scope":                 {"openid email"},
+		"state":                 {state},
+		"nonce":                 {login.nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.AuthEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, p.AuthEndpoint+sep+q.Encode(), http.StatusFound)
+}
+
+// oidcCallbackHandler is where the provider sends the browser back: the code
+// is exchanged for an ID token, and an admin gets a session cookie.
+//
+//	GET /auth/callback?code=...&state=...
+func oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := requestConfig(r)
+	if cfg.OIDCIssuer == "" {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	oidcMu.Lock()
+	login, ok := oidcLogins[q.Get("state")]
+	delete(oidcLogins, q.Get("state"))
+	oidcMu.Unlock()
+	if !ok || time.Now().After(login.expires) || login.dir != cfg.DataDir {
+		http.Error(w, "sign-in expired; try again", http.StatusBadRequest)
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		http.Error(w, "sign-in refused: "+e, http.StatusForbidden)
+		return
+	}
+
+	p, err := provider(cfg)
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		http.Error(w, "sign-in provider unavailable", http.StatusBadGateway)
+		return
+	}
+	res, err := oidcClient.PostForm(p.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {q.Get("code")},
+		"redirect_uri":  {cfg.OIDCRedirectURL},
+		"client_id":     {cfg.OIDCClientID},
+		"client_secret": {cfg.OIDCClientSecret},
+		"code_verifier": {login.verifier},
+	})
+	if err != nil {
+		log.Printf("oidc: token: %v", err)
+		http.Error(w, "sign-in provider unavailable", http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+	var tok struct {
+		IDToken string `json:"id_token"`
+	}
+	if res.StatusCode != http.StatusOK || json.NewDecoder(res.Body).Decode(&tok) != nil || tok.IDToken == "" {
+		log.Printf("oidc: token: %s", res.Status)
+		http.Error(w, "sign-in failed", http.StatusBadGateway)
+		return
+	}
+	claims, err := verifyIDToken(p, cfg, tok.IDToken, login.nonce)
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		http.Error(w, "sign-in failed", http.StatusForbidden)
+		return
+	}
+	entry := AuditEntry{Action: "login", Actor: "oidc:" + claims.Email, IP: clientIP(r)}
+	if oidcRole(cfg, claims.Email) == RoleNone {
+		entry.Error = "not in OIDC_ADMINS"
+		appendAudit(cfg, entry)
+		http.Error(w, claims.Email+" is not an admin here", http.StatusForbidden)
+		return
+	}
+	appendAudit(cfg, entry)
+
+	id := randomToken()
+	expires := time.Now().Add(cfg.SessionTTL)
+	oidcMu.Lock()
+	for k, s := range sessions {
+		if time.Now().After(s.expires) {
+			delete(sessions, k)
+		}
+	}
+	sessions[id] = session{email: claims.Email, dir: cfg.DataDir, expires: expires}
+	oidcMu.Unlock()
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.OIDCRedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, login.next, http.StatusFound)
+}
+
+// oidcLogoutHandler ends the browser's session.
+//
+//	POST /auth/logout[?next=/]
+func oidcLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		oidcMu.Lock()
+		delete(sessions, cookie.Value)
+		oidcMu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1, HttpOnly: true})
+	http.Redirect(w, r, safeNext(r.URL.Query().Get("next")), http.StatusSeeOther)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOIDCLogin(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	var challenge, nonce, email string // what the provider saw at /authorize
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": srv.URL, "authorization_endpoint": srv.URL + "/authorize",
+			"token_endpoint": srv.URL + "/token", "jwks_uri": srv.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{"kty": "RSA", "kid": "k1",
+			"n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes())}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if r.PostFormValue("code") != "good" || b64(sum[:]) != challenge || r.PostFormValue("client_secret") != "s3cret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+		claims, _ := json.Marshal(map[string]any{"iss": srv.URL, "aud": "gym", "exp": time.Now().Add(time.Hour).Unix(),
+			"nonce": nonce, "email": email, "email_verified": true})
+		signed := b64(header) + "." + b64(claims)
+		digest := sha256.Sum256([]byte(signed))
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed + "." + b64(sig)})
+	})
+
+	old := currentConfig()
+	defer setConfig(old)
+	cfg := &Config{DataDir: t.TempDir(), AuditLog: "gym-audit.jsonl", AnonymousRole: RoleViewer,
+		OIDCIssuer: srv.URL, OIDCClientID: "gym", OIDCClientSecret: "s3cret", OIDCRedirectURL: "https://gym.example/auth/callback",
+		OIDCAdmins: []string{"bob@elsewhere.example", "@team.example"}, SessionTTL: time.Hour}
+	setConfig(cfg)
+
+	// signIn goes through /auth/login and back, returning the callback's reply.
+	signIn := func(as string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		oidcLoginHandler(w, httptest.NewRequest("GET", "/auth/login?next=/dashboard.html", nil))
+		to, err := url.Parse(w.Header().Get("Location"))
+		if w.Code != http.StatusFound || err != nil || !strings.HasPrefix(to.String(), srv.URL+"/authorize?") {
+			t.Fatalf("login: %d to %q", w.Code, w.Header().Get("Location"))
+		}
+		q := to.Query()
+		challenge, nonce, email = q.Get("code_challenge"), q.Get("nonce"), as
+		w = httptest.NewRecorder()
+		oidcCallbackHandler(w, httptest.NewRequest("GET", "/auth/callback?code=good&state="+q.Get("state"), nil))
+		if w.Code == http.StatusFound {
+			// The same state can't be used twice.
+			again := httptest.NewRecorder()
+			oidcCallbackHandler(again, httptest.NewRequest("GET", "/auth/callback?code=good&state="+q.Get("state"), nil))
+			if again.Code != http.StatusBadRequest {
+				t.Errorf("replayed state: code %d", again.Code)
+			}
+		}
+		return w
+	}
+
+	w := signIn("Alice@team.example")
+	cookies := w.Result().Cookies()
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/dashboard.html" || len(cookies) != 1 || !cookies[0].Secure {
+		t.Fatalf("callback: %d %v %s", w.Code, cookies, w.Body)
+	}
+	r := httptest.NewRequest("POST", "/api/admin/reload", nil)
+	r.AddCookie(cookies[0])
+	if role, actor, ok := requestRole(r, cfg); role != RoleAdmin || actor != "oidc:Alice@team.example" || !ok {
+		t.Errorf("with session: %v %q %v", role, actor, ok)
+	}
+	if role, _, _ := requestRole(httptest.NewRequest("GET", "/", nil), cfg); role != RoleViewer {
+		t.Errorf("without session: %v, want anonymous viewer", role)
+	}
+
+	// Signed in, but no longer an admin: forbidden, not asked to sign in.
+	demoted := *cfg
+	demoted.OIDCAdmins = []string{"bob@elsewhere.example"}
+	setConfig(&demoted)
+	w = httptest.NewRecorder()
+	requireRole(RoleAdmin, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })(w, r)
+	if w.Code != http.StatusForbidden || w.Header().Get("WWW-Authenticate") != "" {
+		t.Errorf("demoted session: code %d, want 403", w.Code)
+	}
+	setConfig(cfg)
+
+	if w := signIn("mallory@team.example.evil"); w.Code != http.StatusForbidden || len(w.Result().Cookies()) != 0 {
+		t.Errorf("non-admin: %d %v", w.Code, w.Result().Cookies())
+	}
+
+	w = httptest.NewRecorder()
+	oidcLogoutHandler(w, r)
+	if role, _, _ := requestRole(r, cfg); w.Code != http.StatusSeeOther || role != RoleViewer {
+		t.Errorf("after logout: %d, role %v", w.Code, role)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// outlierMode is what the outlier filter does with an impossible reading.
+type outlierMode int
+
+const (
+	outliersOff   outlierMode = iota
+	outliersDrop              // remove the point
+	outliersClamp             // replace it with the nearest plausible value
+)
+
+func parseOutlierMode(s string) (outlierMode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "off":
+		return outliersOff, nil
+	case "drop":
+		return outliersDrop, nil
+	case "clamp":
+		return outliersClamp, nil
+	}
+	return outliersOff, fmt.Errorf("unknown outlier mode %q (want off, drop or clamp)", s)
+}
+
+func (m outlierMode) String() string {
+	switch m {
+	case outliersDrop:
+		return "drop"
+	case outliersClamp:
+		return "clamp"
+	}
+	return "off"
+}
+
+// requestOutlierMode is the mode a request asks for, or OUTLIER_FILTER when
+// it names none.
+func requestOutlierMode(cfg *Config, s string) (outlierMode, error) {
+	if strings.TrimSpace(s) == "" {
+		return cfg.OutlierFilter, nil
+	}
+	return parseOutlierMode(s)
+}
+
+// parseCapacities reads CAPACITIES: comma-separated gym=people pairs. Names
+// match case-insensitively.
+func parseCapacities(s string) (map[string]float64, error) {
+	out := map[string]float64{}
+	for _, pair := range splitList(s) {
+		name, n, ok := strings.Cut(pair, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%q: want gym=people", pair)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("%q: capacity must be a positive number", pair)
+		}
+		out[name] = v
+	}
+	return out, nil
+}
+
+const (
+	// maxOutlierDecisions bounds the decisions listed in a response; the
+	// counts cover all of them.
+	maxOutlierDecisions = 100
+	// spikeGap is how far apart a reading's neighbours may be for it to count
+	// as a single-sample spike: three collection intervals.
+	spikeGap = 6 * 60
+	// spikeMinJump is the smallest jump, in people, that can be a spike, so
+	// small quiet-hour wobbles never are.
+	spikeMinJump = 15
+)
+
+// OutlierDecision is one reading the filter dropped or clamped.
+type OutlierDecision struct {
+	Location string   `json:"location"`
+	Metric   string   `json:"metric"`
+	At       string   `json:"at"`
+	Value    float64  `json:"value"`
+	Reason   string   `json:"reason"` // negative, over-capacity or spike
+	Action   string   `json:"action"` // dropped or clamped
+	To       *float64 `json:"to,omitempty"`
+}
+
+// OutlierReport says what the filter did to a response's readings.
+type OutlierReport struct {
+	Mode      string            `json:"mode"`
+	Factor    float64           `json:"factor,omitempty"` // of capacity, when any gym has one
+	Dropped   int               `json:"dropped"`
+	Clamped   int               `json:"clamped"`
+	Reasons   map[string]int    `json:"reasons"`
+	Decisions []OutlierDecision `json:"decisions"` // the first maxOutlierDecisions
+}
+
+func (rep *OutlierReport) record(s *gymdata.Series, p gymdata.Point, reason string, to *float64, loc *time.Location) {
+	d := OutlierDecision{Location: s.Key.Location, Metric: s.Key.Metric, At: time.Unix(p.At, 0).In(loc).Format(time.RFC3339), Value: p.Y, Reason: reason, Action: "dropped"}
+	if to != nil {
+		d.Action, d.To = "clamped", to
+		rep.Clamped++
+	} else {
+		rep.Dropped++
+	}
+	rep.Reasons[reason]++
+	if len(rep.Decisions) < maxOutlierDecisions {
+		rep.Decisions = append(rep.Decisions, d)
+	}
+}
+
+// filterOutliers drops or clamps the readings no gym could produce: negative
+// counts, counts above the gym's CAPACITIES entry times OUTLIER_FACTOR, and
+// single readings that jump away from both neighbours and straight back
+// (typically a glitch reporting 0 for one poll). It runs on the raw points,
+// before bucketing, drops series left empty, and reports nil when mode is off.
+func filterOutliers(list []*gymdata.Series, mode outlierMode, cfg *Config, loc *time.Location) ([]*gymdata.Series, *OutlierReport) {
+	if mode == outliersOff {
+		return list, nil
+	}
+	rep := &OutlierReport{Mode: mode.String(), Reasons: map[string]int{}, Decisions: []OutlierDecision{}}
+	if len(cfg.Capacities) > 0 {
+		rep.Factor = cfg.OutlierFactor
+	}
+	kept := list[:0]
+	for _, s := range list {
+		limit := math.Inf(1)
+		if c, ok := cfg.Capacities[strings.ToLower(s.Key.Location)]; ok {
+			limit = c * cfg.OutlierFactor
+		}
+		// In clamp mode a reading may be clamped to a bound and then, still
+		// out of line, to its neighbours; it is reported once, with why it
+		// was first out of range and the value it ended up with.
+		type mark struct {
+			reason string
+			value  float64
+		}
+		var dropped []int64
+		marks := make([]mark, 0, len(s.Points))
+		out := s.Points[:0]
+		for _, p := range s.Points {
+			m := mark{value: p.Y}
+			switch {
+			case p.Y < 0:
+				m.reason, p.Y = "negative", 0
+			case p.Y > limit:
+				m.reason, p.Y = "over-capacity", math.Floor(limit)
+			}
+			if m.reason != "" && mode == outliersDrop {
+				rep.record(s, gymdata.Point{At: p.At, Y: m.value}, m.reason, nil, loc)
+				dropped = append(dropped, p.At)
+				continue
+			}
+			out = append(out, p)
+			marks = append(marks, m)
+		}
+		s.Points = out
+
+		// Spikes are judged against the neighbours left by the checks above,
+		// and a clamped spike is the neighbour the next reading is judged by.
+		out = s.Points[:0]
+		for i, p := range s.Points {
+			m := marks[i]
+			if len(out) > 0 && i+1 < len(s.Points) {
+				prev, next := out[len(out)-1], s.Points[i+1]
+				jump := max(p.Y-max(prev.Y, next.Y), min(prev.Y, next.Y)-p.Y)
+				if p.At-prev.At <= spikeGap && next.At-p.At <= spikeGap && jump > max(spikeMinJump, max(prev.Y, next.Y)/2) {
+					if m.reason == "" {
+						m.reason = "spike"
+					}
+					if mode == outliersDrop {
+						rep.record(s, p, m.reason, nil, loc)
+						dropped = append(dropped, p.At)
+						continue
+					}
+					p.Y = math.Round((prev.Y + next.Y) / 2)
+				}
+			}
+			if m.reason != "" {
+				to := p.Y
+				rep.record(s, gymdata.Point{At: p.At, Y: m.value}, m.reason, &to, loc)
+			}
+			out = append(out, p)
+		}
+		s.Points = out
+		s.Flagged = dropFlags(s.Flagged, dropped)
+		if len(s.Points) > 0 {
+			kept = append(kept, s)
+		}
+	}
+	return kept, rep
+}
+
+// dropFlags removes one flag per dropped reading's time.
+func dropFlags(flagged, dropped []int64) []int64 {
+	for _, at := range dropped {
+		if i := sort.Search(len(flagged), func(i int) bool { return flagged[i] >= at }); i < len(flagged) && flagged[i] == at {
+			flagged = append(flagged[:i], flagged[i+1:]...)
+		}
+	}
+	return flagged
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+func TestFilterOutliers(t *testing.T) {
+	cfg := &Config{Capacities: map[string]float64{"hipodroom": 100}, OutlierFactor: 1.5}
+	newList := func() []*gymdata.Series {
+		s := &gymdata.Series{Key: gymdata.Key{Location: "Hipodroom", Metric: "user_count"}, Flagged: []int64{240}}
+		for i, y := range []float64{40, 42, -3, 44, 0, 45, 900, 46, 30, 31} {
+			s.Points = append(s.Points, gymdata.Point{At: int64(i) * 120, Y: y})
+		}
+		// Neighbours an hour apart: a jump, not a spike.
+		s.Points = append(s.Points, gymdata.Point{At: 3600 * 5, Y: 90}, gymdata.Point{At: 3600 * 6, Y: 20})
+		return []*gymdata.Series{s}
+	}
+
+	list, rep := filterOutliers(newList(), outliersDrop, cfg, time.UTC)
+	var ys []float64
+	for _, p := range list[0].Points {
+		ys = append(ys, p.Y)
+	}
+	if want := []float64{40, 42, 44, 45, 46, 30, 31, 90, 20}; !slices.Equal(ys, want) {
+		t.Errorf("dropped to %v, want %v", ys, want)
+	}
+	if rep.Dropped != 3 || rep.Reasons["negative"] != 1 || rep.Reasons["over-capacity"] != 1 || rep.Reasons["spike"] != 1 || len(list[0].Flagged) != 0 {
+		t.Errorf("report = %+v, flags %v", rep, list[0].Flagged)
+	}
+	if d := rep.Decisions[0]; d.Reason != "negative" || d.At != "1970-01-01T00:04:00Z" || d.Action != "dropped" {
+		t.Errorf("first decision = %+v", d)
+	}
+
+	list, rep = filterOutliers(newList(), outliersClamp, cfg, time.UTC)
+	ys = ys[:0]
+	for _, p := range list[0].Points[:8] {
+		ys = append(ys, p.Y)
+	}
+	// -3 clamps to 0 and 900 to 150, both still out of line with their
+	// neighbours, so they end up between them like the 0 spike does.
+	if want := []float64{40, 42, 43, 44, 45, 45, 46, 46}; !slices.Equal(ys, want) {
+		t.Errorf("clamped to %v, want %v", ys, want)
+	}
+	if rep.Clamped != 3 || rep.Reasons["negative"] != 1 || rep.Reasons["spike"] != 1 || len(list[0].Flagged) != 1 {
+		t.Errorf("report = %+v", rep)
+	}
+	if d := rep.Decisions[2]; d.Value != 900 || d.Reason != "over-capacity" || *d.To != 46 {
+		t.Errorf("900 reading = %+v, to %v", d, *d.To)
+	}
+
+	if list, rep := filterOutliers(newList(), outliersOff, cfg, time.UTC); rep != nil || len(list[0].Points) != 12 {
+		t.Errorf("off: %d points, report %+v", len(list[0].Points), rep)
+	}
+}
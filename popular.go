@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// popularLevels are the classes an hour of the week falls in, quietest
+// first, split at the 40th, 75th and 90th percentiles of the gym's hours.
+var popularLevels = []string{"quiet", "moderate", "busy", "packed"}
+
+var popularPercentiles = []float64{0.4, 0.75, 0.9}
+
+// PopularHour is one hour of a weekday: Avg is the mean over the days of
+// each day's own average for the hour.
+type PopularHour struct {
+	Hour  int     `json:"hour"`
+	Avg   float64 `json:"avg"`
+	Level string  `json:"level"`
+	Days  int     `json:"days"`
+}
+
+type PopularDay struct {
+	Day   string        `json:"day"` // "Mon"
+	Hours []PopularHour `json:"hours"`
+}
+
+type PopularLocation struct {
+	Name string `json:"name"`
+	// Thresholds are where moderate, busy and packed start.
+	Thresholds []float64    `json:"thresholds"`
+	Max        float64      `json:"max"`
+	Days       []PopularDay `json:"days"` // Monday first
+}
+
+type PopularResponse struct {
+	From      string            `json:"from"`
+	To        string            `json:"to"`
+	Metric    string            `json:"metric"`
+	Levels    []string          `json:"levels"`
+	Locations []PopularLocation `json:"locations"`
+}
+
+// popularLiveStatus folds /api/usual's levels into a live count's status.
+var popularLiveStatus = map[string]string{
+	"much-quieter": "quieter",
+	"quieter":      "quieter",
+	"usual":        "usual",
+	"busier":       "busier",
+	"much-busier":  "busier",
+}
+
+// PopularWeek is one gym's popular times as Google Maps draws them: each
+// weekday's 24 hours, scaled so the busiest hour of the week is 100.
+type PopularWeek struct {
+	Name   string           `json:"name"`
+	From   string           `json:"from"`
+	To     string           `json:"to"`
+	Metric string           `json:"metric"`
+	Days   []PopularWeekDay `json:"days"` // Monday first
+	Live   *PopularLive     `json:"live,omitempty"`
+}
+
+type PopularWeekDay struct {
+	Day    string `json:"day"`
+	Values []int  `json:"values"` // by hour, 0 when closed or unknown
+}
+
+// PopularLive is the gym's newest reading against the usual for its hour,
+// as /api/usual has it with hour buckets over its own 12 weeks, whatever
+// range the week is drawn from. Usual is those days' median, Delta the
+// percentage over it (negative under), and Status /api/usual's level, so
+// busier never comes with a Delta below 0, nor quieter above. Without
+// enough of those days, or when the gym is usually closed then, there is
+// nothing to compare with: Delta is left out and Status is "usual".
+type PopularLive struct {
+	At     string  `json:"at"`
+	Count  int     `json:"count"`
+	Value  int     `json:"value"` // scaled as the days' values, so above 100 on a record day
+	Usual  float64 `json:"usual"`
+	Delta  *int    `json:"delta,omitempty"`
+	Status string  `json:"status"` // "busier", "usual" or "quieter"
+}
+
+// popularWeek scales loc's hours to 0-100.
+func popularWeek(loc PopularLocation) []PopularWeekDay {
+	days := make([]PopularWeekDay, len(loc.Days))
+	for d, day := range loc.Days {
+		days[d] = PopularWeekDay{Day: day.Day, Values: make([]int, 24)}
+		for _, h := range day.Hours {
+			days[d].Values[h.Hour] = int(math.Round(h.Avg / loc.Max * 100))
+		}
+	}
+	return days
+}
+
+// popularLive compares the gym's newest reading with the usual for that
+// weekday and hour, scaling it as loc's week. It is nil without a reading
+// in the last SLO_MAX_AGE_SECONDS, when the collector can't be said to be
+// live.
+func popularLive(cfg *Config, loc PopularLocation, now time.Time, tallinn *time.Location) *PopularLive {
+	for _, l := range readLatestStatus(cfg, tallinn).Locations {
+		if l.Name != loc.Name {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, l.At)
+		if err != nil || now.Sub(at) > cfg.SLOMaxAge {
+			return nil
+		}
+		live := &PopularLive{At: l.At, Count: l.Count, Value: int(math.Round(float64(l.Count) / loc.Max * 100)), Status: "usual"}
+		usual, err := buildUsual(cfg, loc.Name, 60, now)
+		if err != nil || len(usual.Locations) == 0 {
+			return live
+		}
+		u := usual.Locations[0]
+		live.Usual, live.Status = u.P50, popularLiveStatus[u.Level]
+		if u.P50 > 0 {
+			delta := int(math.Round((float64(l.Count) - u.P50) / u.P50 * 100))
+			live.Delta = &delta
+		}
+		return live
+	}
+	return nil
+}
+
+// buildPopularWeek is the gym name's week over the days from from to to,
+// both included, from list, with its live reading as of now. It is false
+// when the gym has no readings there.
+func buildPopularWeek(cfg *Config, list []*gymdata.Series, name string, from, to time.Time, metric string, now time.Time) (PopularWeek, bool) {
+	var s *gymdata.Series
+	for _, c := range list {
+		if strings.EqualFold(c.Key.Location, name) && (s == nil || c.Key.Location == name) {
+			s = c
+		}
+	}
+	if s == nil {
+		return PopularWeek{}, false
+	}
+	tallinn := gymdata.Tallinn()
+	loc, ok := computePopular(s, from, to.AddDate(0, 0, 1), tallinn)
+	if !ok {
+		return PopularWeek{}, false
+	}
+	week := PopularWeek{
+		Name:   loc.Name,
+		From:   from.Format("2006-01-02"),
+		To:     to.Format("2006-01-02"),
+		Metric: metric,
+		Days:   popularWeek(loc),
+	}
+	if metric == gymdata.DefaultMetric { // the collector's live counts are user_count
+		week.Live = popularLive(cfg, loc, now, tallinn)
+	}
+	return week, true
+}
+
+// popularLevel classifies v against thresholds, ascending.
+func popularLevel(v float64, thresholds []float64) string {
+	i := sort.Search(len(thresholds), func(i int) bool { return v < thresholds[i] })
+	return popularLevels[i]
+}
+
+// computePopular folds a series into per-day averages for each Tallinn hour
+// of the days in [from, to), and classifies each hour of the week by its
+// mean over those days against percentiles of all the day-hours the gym
+// was open. Hours it is always closed (an average of 0) are left out, as
+// are gyms with no open hours.
+func computePopular(s *gymdata.Series, from, to time.Time, tallinn *time.Location) (PopularLocation, bool) {
+	type cell struct {
+		sum   float64
+		count int
+	}
+	byDay := map[string]*[24]cell{}
+	weekday := map[string]int{} // Monday is 0
+	clock := wallClock(tallinn)
+	for _, p := range s.Points {
+		d, minute, _ := clock(p.At)
+		if d.Before(from) || !d.Before(to) {
+			continue
+		}
+		day := d.Format("2006-01-02")
+		cells := byDay[day]
+		if cells == nil {
+			cells = &[24]cell{}
+			byDay[day] = cells
+			weekday[day] = (int(d.Weekday()) + 6) % 7
+		}
+		cells[minute/60].sum += p.Y
+		cells[minute/60].count++
+	}
+
+	var grid [7][24]struct {
+		sum  float64
+		days int
+	}
+	var open []float64
+	for day, cells := range byDay {
+		for h, c := range cells {
+			if c.count == 0 {
+				continue
+			}
+			v := c.sum / float64(c.count)
+			g := &grid[weekday[day]][h]
+			g.sum += v
+			g.days++
+			if v > 0 {
+				open = append(open, v)
+			}
+		}
+	}
+	if len(open) == 0 {
+		return PopularLocation{}, false
+	}
+	sort.Float64s(open)
+	round := func(v float64) float64 { return math.Round(v*10) / 10 }
+	loc := PopularLocation{Name: s.Key.Location, Days: make([]PopularDay, 7)}
+	for _, p := range popularPercentiles {
+		loc.Thresholds = append(loc.Thresholds, round(percentile(open, p)))
+	}
+	names := []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+	for d := range grid {
+		loc.Days[d] = PopularDay{Day: names[d], Hours: []PopularHour{}}
+		for h, g := range grid[d] {
+			if g.days == 0 || g.sum == 0 {
+				continue
+			}
+			avg := round(g.sum / float64(g.days))
+			loc.Days[d].Hours = append(loc.Days[d].Hours, PopularHour{Hour: h, Avg: avg, Level: popularLevel(avg, loc.Thresholds), Days: g.days})
+			loc.Max = max(loc.Max, avg)
+		}
+	}
+	return loc, true
+}
+
+// popularTimesHandler returns, per gym, how busy each hour of the week
+// usually is, as Google Maps' "popular times" show it. With a location in
+// the path it returns that gym's week scaled to 0-100, with its live count
+// against the usual.
+//
+//	GET /api/popular-times[?from=YYYY-MM-DD&to=YYYY-MM-DD][&metric=NAME][&location=NAME]
+//	GET /api/popular-times/{location}[?from=YYYY-MM-DD&to=YYYY-MM-DD][&metric=NAME]
+//
+// The range defaults to the 12 weeks before today and never includes today,
+// so a week's every weekday counts as often.
+func popularTimesHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	tallinn := gymdata.Tallinn()
+	now := time.Now().In(tallinn)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, tallinn)
+
+	q := r.URL.Query()
+	to := today.AddDate(0, 0, -1)
+	if t, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(q.Get("to")), tallinn); err == nil && t.Before(today) {
+		to = t
+	}
+	from := to.AddDate(0, 0, -83)
+	if t, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(q.Get("from")), tallinn); err == nil {
+		from = t
+	}
+	if from.After(to) {
+		writeError(w, http.StatusBadRequest, withKind(ErrBadRange, errors.New("from is after to (the range ends yesterday at the latest)")))
+		return
+	}
+	metric := gymdata.NormalizeMetrics([]string{q.Get("metric")})[0]
+	location := strings.TrimSpace(q.Get("location"))
+
+	cfg := requestConfig(r)
+	list, err := loadWindow(cfg, from, to.AddDate(0, 0, 1), []string{metric})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if name := strings.TrimSpace(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/popular-times"), "/")); name != "" {
+		week, ok := buildPopularWeek(cfg, list, name, from, to, metric, now)
+		if !ok {
+			writeError(w, http.StatusNotFound, withKind(ErrNoData, fmt.Errorf("no data for location %q", name)))
+			return
+		}
+		json.NewEncoder(w).Encode(week)
+		return
+	}
+
+	resp := PopularResponse{
+		From:      from.Format("2006-01-02"),
+		To:        to.Format("2006-01-02"),
+		Metric:    metric,
+		Levels:    popularLevels,
+		Locations: []PopularLocation{},
+	}
+	for _, s := range list {
+		if location != "" && !strings.EqualFold(s.Key.Location, location) {
+			continue
+		}
+		if loc, ok := computePopular(s, from, to.AddDate(0, 0, 1), tallinn); ok {
+			resp.Locations = append(resp.Locations, loc)
+		}
+	}
+	sort.Slice(resp.Locations, func(i, j int) bool { return resp.Locations[i].Name < resp.Locations[j].Name })
+	json.NewEncoder(w).Encode(resp)
+}
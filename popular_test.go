@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+func TestComputePopular(t *testing.T) {
+	tallinn := loadTallinn(t)
+	monday := time.Date(2025, 10, 6, 0, 0, 0, 0, tallinn)
+	// Two weeks where each open hour, 06-21, reads its own hour; a third
+	// week past the range would have made everything packed.
+	s := &gymdata.Series{Key: gymdata.Key{Location: "A", Metric: gymdata.DefaultMetric}}
+	for d := 0; d < 21; d++ {
+		for h := 0; h < 24; h++ {
+			y := 0.0
+			if h >= 6 && h < 22 {
+				y = float64(h)
+			}
+			if d >= 14 {
+				y = 100
+			}
+			at := monday.AddDate(0, 0, d).Add(time.Duration(h)*time.Hour + 30*time.Minute)
+			s.Points = append(s.Points, gymdata.Point{At: at.Unix(), Y: y})
+		}
+	}
+	loc, ok := computePopular(s, monday, monday.AddDate(0, 0, 14), tallinn)
+	if !ok {
+		t.Fatal("no popular times")
+	}
+	if !slices.Equal(loc.Thresholds, []float64{12, 17.3, 20}) || loc.Max != 21 {
+		t.Errorf("thresholds %v max %v", loc.Thresholds, loc.Max)
+	}
+	if len(loc.Days) != 7 || loc.Days[0].Day != "Mon" || loc.Days[6].Day != "Sun" {
+		t.Fatalf("days = %+v", loc.Days)
+	}
+	sat := loc.Days[5].Hours
+	if len(sat) != 16 || sat[0].Hour != 6 || sat[0].Days != 2 {
+		t.Fatalf("Saturday = %+v, want 06-21 over 2 days", sat)
+	}
+	for _, h := range sat {
+		want := "quiet"
+		switch {
+		case h.Hour >= 20:
+			want = "packed"
+		case h.Hour >= 18:
+			want = "busy"
+		case h.Hour >= 12:
+			want = "moderate"
+		}
+		if h.Level != want {
+			t.Errorf("%02d:00 = %s, want %s", h.Hour, h.Level, want)
+		}
+	}
+
+	closed := &gymdata.Series{Points: []gymdata.Point{{At: monday.Unix(), Y: 0}}}
+	if _, ok := computePopular(closed, monday, monday.AddDate(0, 0, 1), tallinn); ok {
+		t.Error("a gym never open has popular times")
+	}
+}
+
+func TestPopularTimesHandlerRange(t *testing.T) {
+	w := httptest.NewRecorder()
+	popularTimesHandler(w, httptest.NewRequest("GET", "/api/popular-times?from=2025-10-10&to=2025-10-01", nil))
+	if w.Code != 400 {
+		t.Errorf("from after to: status %d, want 400", w.Code)
+	}
+}
+
+func TestPopularTimesLocation(t *testing.T) {
+	tallinn := loadTallinn(t)
+	dir := t.TempDir()
+	saved := currentConfig()
+	defer setConfig(saved)
+	cfg := &Config{DataDir: dir, SLOMaxAge: 10 * time.Minute}
+	setConfig(cfg)
+
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	row := func(at time.Time, n int) string {
+		return at.UTC().Format("2006-01-02 15:04:05") + ",,1,Hipodroom," + strconv.Itoa(n) + ",success,\"{}\"\n"
+	}
+	write := func(day time.Time, rows string, mtime time.Time) {
+		f := writeCSV(t, dir, "gym-stats-"+day.Format("20060102")+".csv", header+rows)
+		os.Chtimes(f, mtime, mtime) // the newest file is the last written, and none of its rows skewed
+	}
+	// The Mondays before read 10 at noon and 20 at one; the week is drawn
+	// from the last, the usual taken from all of them.
+	week := time.Date(2026, 2, 23, 0, 0, 0, 0, tallinn)
+	for w := usualMinDays - 1; w >= 0; w-- {
+		day := week.AddDate(0, 0, -7*w)
+		write(day, row(day.Add(12*time.Hour), 10)+row(day.Add(13*time.Hour), 20), day.AddDate(0, 0, 1))
+	}
+	monday := week.AddDate(0, 0, 7)
+	load := func() []*gymdata.Series {
+		list, err := loadWindow(cfg, week, monday, []string{gymdata.DefaultMetric})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return list
+	}
+
+	// At 12:30 it reads 15.
+	now := monday.Add(12*time.Hour + 30*time.Minute)
+	write(monday, row(now.Add(-time.Minute), 15), now)
+	resp, ok := buildPopularWeek(cfg, load(), "hipodroom", week, monday.AddDate(0, 0, -1), gymdata.DefaultMetric, now)
+	if !ok || resp.Name != "Hipodroom" || len(resp.Days) != 7 {
+		t.Fatalf("week = %+v, %v", resp, ok)
+	}
+	if values := resp.Days[0].Values; len(values) != 24 || values[12] != 50 || values[13] != 100 || values[14] != 0 {
+		t.Errorf("Monday = %v, want 50 at 12 and 100 at 13", values)
+	}
+	live := resp.Live
+	if live == nil || live.Count != 15 || live.Usual != 10 || live.Value != 75 || live.Delta == nil || *live.Delta != 50 || live.Status != "busier" {
+		t.Errorf("live = %+v", live)
+	}
+
+	// At 15:30, an hour it is usually closed, there is no usual to compare with.
+	now = monday.Add(15*time.Hour + 30*time.Minute)
+	write(monday, row(now.Add(-time.Minute), 7), now)
+	resp, _ = buildPopularWeek(cfg, load(), "Hipodroom", week, monday.AddDate(0, 0, -1), gymdata.DefaultMetric, now)
+	if live := resp.Live; live == nil || live.Usual != 0 || live.Delta != nil || live.Status != "usual" {
+		t.Errorf("live with no usual = %+v", live)
+	}
+	// An hour later the reading is too old to be live.
+	if resp, _ := buildPopularWeek(cfg, load(), "Hipodroom", week, monday.AddDate(0, 0, -1), gymdata.DefaultMetric, now.Add(time.Hour)); resp.Live != nil {
+		t.Errorf("stale live = %+v", resp.Live)
+	}
+
+	w := httptest.NewRecorder()
+	popularTimesHandler(w, httptest.NewRequest("GET", "/api/popular-times/Nowhere", nil))
+	if w.Code != 404 {
+		t.Errorf("unknown gym: status %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Preferences is how one viewer likes the chart drawn: gyms in Order first
+// (the rest after, as the server sends them), Hidden ones toggled off, and
+// Colors overriding the built-in palette.
+type Preferences struct {
+	Order   []string          `json:"order"`
+	Hidden  []string          `json:"hidden"`
+	Colors  map[string]string `json:"colors"`
+	Updated string            `json:"updated,omitempty"`
+}
+
+const (
+	// maxPrefsOwners bounds the store; anonymous browser IDs cost nothing to
+	// make up, so past this the least recently saved entries are dropped.
+	maxPrefsOwners = 1000
+	maxPrefsNames  = 64
+)
+
+var (
+	prefsMu  sync.Mutex
+	clientID = regexp.MustCompile(`^[A-Za-z0-9_-]{8,64}$`)
+	hexColor = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+)
+
+// prefsOwner names whose preferences a request reads and writes: the API key
+// it presents, or without one the browser ID the dashboard keeps in local
+// storage and sends as X-Client-ID. "" means neither was given.
+func prefsOwner(r *http.Request, cfg *Config) string {
+	if len(cfg.APIKeys) > 0 && requestToken(r) != "" {
+		if _, actor, ok := requestRole(r, cfg); ok {
+			return "key:" + actor
+		}
+		return ""
+	}
+	if id := r.Header.Get("X-Client-ID"); clientID.MatchString(id) {
+		return "browser:" + id
+	}
+	return ""
+}
+
+// readPrefs loads the store, owner -> preferences; a missing file is empty.
+func readPrefs(cfg *Config) (map[string]Preferences, error) {
+	data, err := os.ReadFile(cfg.path(cfg.PrefsFile))
+	if os.IsNotExist(err) {
+		return map[string]Preferences{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	store := map[string]Preferences{}
+	if err := json.Unmarshal(data, &store); err != nil {
+		return nil, fmt.Errorf("%s: %v", cfg.PrefsFile, err)
+	}
+	return store, nil
+}
+
+// writePrefs saves every user's preferences.
+func writePrefs(cfg *Config, store map[string]Preferences) error {
+	return writeFileAtomic(cfg.path(cfg.PrefsFile), store)
+}
+
+// check trims and dedupes the gym names and rejects colors the chart could
+// not use.
+func (p *Preferences) check() error {
+	clean := func(field string, names []string) ([]string, error) {
+		out := []string{}
+		seen := map[string]bool{}
+		for _, n := range names {
+			if n = strings.TrimSpace(n); n != "" && !seen[n] {
+				seen[n] = true
+				out = append(out, n)
+			}
+		}
+		if len(out) > maxPrefsNames {
+			return nil, fmt.Errorf("%s: at most %d gyms", field, maxPrefsNames)
+		}
+		return out, nil
+	}
+	var err error
+	if p.Order, err = clean("order", p.Order); err != nil {
+		return err
+	}
+	if p.Hidden, err = clean("hidden", p.Hidden); err != nil {
+		return err
+	}
+	colors := map[string]string{}
+	for name, c := range p.Colors {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if !hexColor.MatchString(c) {
+			return fmt.Errorf("colors: %q for %s is not #rgb or #rrggbb", c, name)
+		}
+		colors[name] = strings.ToLower(c)
+	}
+	if len(colors) > maxPrefsNames {
+		return fmt.Errorf("colors: at most %d gyms", maxPrefsNames)
+	}
+	p.Colors = colors
+	return nil
+}
+
+// prefsFor is the caller's saved preferences for the chart endpoints to send
+// along with their datasets, or nil if they have none.
+func prefsFor(r *http.Request, cfg *Config) *Preferences {
+	owner := prefsOwner(r, cfg)
+	if owner == "" {
+		return nil
+	}
+	prefsMu.Lock()
+	store, err := readPrefs(cfg)
+	prefsMu.Unlock()
+	p, ok := store[owner]
+	if err != nil || !ok {
+		return nil
+	}
+	return &p
+}
+
+// prefsHandler reads and saves the caller's chart preferences.
+//
+//	GET    /api/prefs
+//	PUT    /api/prefs {order, hidden, colors}
+//	DELETE /api/prefs
+func prefsHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, PUT, DELETE, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	fail := func(status int, err error) {
+		writeError(w, status, err)
+	}
+	if r.Method != "GET" && r.Method != "PUT" && r.Method != "DELETE" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := requestConfig(r)
+	owner := prefsOwner(r, cfg)
+	if owner == "" {
+		fail(http.StatusBadRequest, fmt.Errorf("preferences are per API key or X-Client-ID (8-64 letters, digits, - or _)"))
+		return
+	}
+
+	var in Preferences
+	if r.Method == "PUT" {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&in); err != nil {
+			fail(http.StatusBadRequest, fmt.Errorf("invalid request body"))
+			return
+		}
+		if err := in.check(); err != nil {
+			fail(http.StatusBadRequest, err)
+			return
+		}
+		in.Updated = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	prefsMu.Lock()
+	defer prefsMu.Unlock()
+	store, err := readPrefs(cfg)
+	if err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		p, ok := store[owner]
+		if !ok {
+			p = Preferences{Order: []string{}, Hidden: []string{}, Colors: map[string]string{}}
+		}
+		json.NewEncoder(w).Encode(p)
+		return
+	case "PUT":
+		store[owner] = in
+		if len(store) > maxPrefsOwners {
+			owners := make([]string, 0, len(store))
+			for o := range store {
+				owners = append(owners, o)
+			}
+			sort.Slice(owners, func(i, j int) bool { return store[owners[i]].Updated < store[owners[j]].Updated })
+			for _, o := range owners[:len(store)-maxPrefsOwners] {
+				delete(store, o)
+			}
+		}
+	case "DELETE":
+		if _, ok := store[owner]; !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		delete(store, owner)
+	}
+	if err := writePrefs(cfg, store); err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	if r.Method == "DELETE" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	json.NewEncoder(w).Encode(in)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrefsHandler(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+	dir := t.TempDir()
+	setConfig(&Config{DataDir: dir, PrefsFile: "gym-prefs.json", APIKeys: []APIKey{{Name: "alice", Token: "a-token", Role: RoleViewer}}, AnonymousRole: RoleViewer})
+
+	call := func(method, body string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/api/prefs", strings.NewReader(body))
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		prefsHandler(w, r)
+		return w
+	}
+	browser := map[string]string{"X-Client-ID": "3f2a9c1e-browser"}
+	alice := map[string]string{"X-API-Key": "a-token", "X-Client-ID": "3f2a9c1e-browser"}
+
+	if w := call("GET", "", nil); w.Code != 400 {
+		t.Errorf("no identity: code %d, want 400", w.Code)
+	}
+	if w := call("PUT", `{"colors":{"T1":"orange"}}`, browser); w.Code != 400 {
+		t.Errorf("named color: code %d, want 400", w.Code)
+	}
+	w := call("PUT", `{"order":["T1"," Hipodroom","T1"],"hidden":["Mustika"],"colors":{"T1":"#FF9900"}}`, browser)
+	if w.Code != 200 {
+		t.Fatalf("PUT: %d %s", w.Code, w.Body)
+	}
+
+	var got Preferences
+	json.Unmarshal(call("GET", "", browser).Body.Bytes(), &got)
+	if strings.Join(got.Order, ",") != "T1,Hipodroom" || len(got.Hidden) != 1 || got.Colors["T1"] != "#ff9900" {
+		t.Errorf("browser prefs = %+v", got)
+	}
+	// A presented key owns its own preferences, whatever browser sends it.
+	got = Preferences{}
+	json.Unmarshal(call("GET", "", alice).Body.Bytes(), &got)
+	if len(got.Order) != 0 || got.Colors == nil {
+		t.Errorf("alice's prefs = %+v, want empty defaults", got)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Client-ID", "3f2a9c1e-browser")
+	if p := prefsFor(r, currentConfig()); p == nil || p.Hidden[0] != "Mustika" {
+		t.Errorf("prefsFor = %+v", p)
+	}
+	if w := call("DELETE", "", browser); w.Code != 204 {
+		t.Errorf("DELETE: code %d", w.Code)
+	}
+	if p := prefsFor(r, currentConfig()); p != nil {
+		t.Errorf("after DELETE prefsFor = %+v, want nil", p)
+	}
+}
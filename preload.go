@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// preloadState is how far the startup preload has got, for /readyz.
+var preloadState struct {
+	sync.Mutex
+	running     bool
+	files, done int
+}
+
+// preloadBatch is one data directory's files to parse.
+type preloadBatch struct {
+	cfg   *Config
+	files []string
+}
+
+// startPreload parses the last PreloadDays days of the main directory and
+// every tenant's into gymdata's file cache in the background, so the first
+// dashboard loads find them parsed. /readyz answers 503 until it is done.
+func startPreload(c *Config, now time.Time) {
+	if c.PreloadDays == 0 {
+		return
+	}
+	configs := []*Config{c}
+	for _, t := range c.Tenants {
+		configs = append(configs, t)
+	}
+	// Room for the preloaded days on top of what requests keep warm.
+	gymdata.SetCacheFiles(gymdata.DefaultCacheFiles + c.PreloadDays*len(configs))
+
+	tallinn := gymdata.Tallinn()
+	to := now.In(tallinn)
+	from := to.AddDate(0, 0, 1-c.PreloadDays)
+	var batches []preloadBatch
+	total := 0
+	for _, cfg := range configs {
+		files, err := gymdata.InRange(cfg.csvDir(), from.Format("2006-01-02"), to.Format("2006-01-02"))
+		if err != nil {
+			log.Printf("Preload: listing %s: %v", cfg.csvDir(), err)
+			continue
+		}
+		batches = append(batches, preloadBatch{cfg: cfg, files: files})
+		total += len(files)
+	}
+
+	preloadState.Lock()
+	preloadState.running, preloadState.files, preloadState.done = true, total, 0
+	preloadState.Unlock()
+	log.Printf("Preload: parsing %d files (%d days from %s)", total, c.PreloadDays, from.Format("2006-01-02"))
+	go runPreload(batches, total)
+}
+
+// runPreload parses the batches one directory at a time, logging progress
+// every 10 files. A file that fails to parse ends its directory's batch; the
+// requests that need it will report the error.
+func runPreload(batches []preloadBatch, total int) {
+	start := time.Now()
+	rows := 0
+	base := 0
+	for _, b := range batches {
+		_, counts, err := gymdata.LoadProgress(b.cfg.format(), b.files, nil, func(files int, _ *gymdata.RowCounts) {
+			preloadState.Lock()
+			preloadState.done = base + files
+			done := preloadState.done
+			preloadState.Unlock()
+			if done%10 == 0 && done < total {
+				log.Printf("Preload: %d/%d files", done, total)
+			}
+		})
+		if err != nil {
+			log.Printf("Preload: %v", err)
+		} else {
+			rows += counts.Total()
+		}
+		base += len(b.files)
+	}
+
+	preloadState.Lock()
+	preloadState.running, preloadState.done = false, total
+	preloadState.Unlock()
+	log.Printf("Preload: %d files (%d rows) ready in %v", total, rows, time.Since(start).Round(time.Millisecond))
+}
+
+// restoreParseCache loads the parsed files the last run saved, so a restart
+// finds them parsed; the preload then only parses what changed since.
+func restoreParseCache(c *Config) {
+	if c.CacheSnapshot == "" {
+		return
+	}
+	start := time.Now()
+	n, err := gymdata.LoadCacheSnapshot(c.path(c.CacheSnapshot))
+	if err != nil {
+		log.Printf("Cache snapshot: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Cache snapshot: restored %d parsed files in %v", n, time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// saveParseCache writes the parsed files out for the next start's
+// restoreParseCache.
+func saveParseCache(c *Config) {
+	if c.CacheSnapshot == "" {
+		return
+	}
+	start := time.Now()
+	n, err := gymdata.SaveCacheSnapshot(c.path(c.CacheSnapshot))
+	if err != nil {
+		log.Printf("Cache snapshot: %v", err)
+		return
+	}
+	log.Printf("Cache snapshot: saved %d parsed files in %v", n, time.Since(start).Round(time.Millisecond))
+}
+
+// ReadyResponse reports whether the server has finished its startup preload.
+// Degraded says the integrity check found CorruptFiles; the server still
+// serves, and the log names them.
+type ReadyResponse struct {
+	Ready        bool `json:"ready"`
+	Files        int  `json:"files"`
+	FilesDone    int  `json:"filesDone"`
+	Degraded     bool `json:"degraded,omitempty"`
+	CorruptFiles int  `json:"corruptFiles,omitempty"`
+}
+
+// readyHandler answers 200 once the startup preload is done (at once when
+// PRELOAD_DAYS is 0) and 503 with its progress until then, so a load
+// balancer or orchestrator can hold traffic back. It needs no key: probes
+// don't carry one, and it says nothing about the data beyond how many files
+// the integrity check found corrupt.
+//
+//	GET /readyz
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	preloadState.Lock()
+	resp := ReadyResponse{Ready: !preloadState.running, Files: preloadState.files, FilesDone: preloadState.done}
+	preloadState.Unlock()
+	resp.CorruptFiles = corruptFiles()
+	resp.Degraded = resp.CorruptFiles > 0
+	if !resp.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gym/internal/fixtures"
+	"gym/internal/gymdata"
+)
+
+func readyz(t *testing.T) (int, ReadyResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	readyHandler(rec, httptest.NewRequest("GET", "/readyz", nil))
+	var resp ReadyResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, resp
+}
+
+func TestPreload(t *testing.T) {
+	tallinn := loadTallinn(t)
+	now := time.Date(2025, 10, 15, 12, 0, 0, 0, tallinn)
+	dir := t.TempDir()
+	if _, err := (fixtures.Spec{Start: now.AddDate(0, 0, -9), Days: 10, End: now}).Write(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer gymdata.SetCacheFiles(gymdata.DefaultCacheFiles)
+
+	if code, resp := readyz(t); code != http.StatusOK || !resp.Ready {
+		t.Fatalf("before any preload: %d %+v, want ready", code, resp)
+	}
+
+	preloadState.Lock()
+	preloadState.running, preloadState.files, preloadState.done = true, 4, 1
+	preloadState.Unlock()
+	if code, resp := readyz(t); code != http.StatusServiceUnavailable || resp.Ready || resp.FilesDone != 1 || resp.Files != 4 {
+		t.Errorf("while preloading: %d %+v, want 503 at 1/4", code, resp)
+	}
+
+	startPreload(&Config{DataDir: dir, PreloadDays: 4}, now)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		code, resp := readyz(t)
+		if code == http.StatusOK {
+			if resp.Files != 4 || resp.FilesDone != 4 {
+				t.Errorf("done: %+v, want the 4 days up to today", resp)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("preload not done: %+v", resp)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
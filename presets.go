@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// presetNames are the named ranges, in the order /api/presets lists them.
+var presetNames = []string{"today", "yesterday", "this-week", "last-week", "last-7-days", "last-30-days", "this-month", "last-month", "all"}
+
+// Preset is a named range resolved to days. Available is false when no day of
+// it has data; From and To are then the days it names.
+type Preset struct {
+	Name      string `json:"name"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Available bool   `json:"available"`
+}
+
+// PresetsResponse is the named ranges as of Today, in the gyms' zone, and
+// the first and last days with data.
+type PresetsResponse struct {
+	Timezone string   `json:"timezone"`
+	Today    string   `json:"today"`
+	First    string   `json:"first,omitempty"`
+	Last     string   `json:"last,omitempty"`
+	Presets  []Preset `json:"presets"`
+}
+
+// dataDays returns the first and last days, YYYY-MM-DD, that dir has a daily
+// file for; both "" if it has none.
+func dataDays(dir string) (first, last string, err error) {
+	files, err := gymdata.ListFiles(dir)
+	if err != nil {
+		return "", "", err
+	}
+	for _, f := range files {
+		name := gymdata.BaseName(f)
+		if len(name) < 20 {
+			continue
+		}
+		date, err := time.Parse("20060102", name[10:18])
+		if err != nil {
+			continue
+		}
+		day := date.Format("2006-01-02")
+		if first == "" || day < first {
+			first = day
+		}
+		if day > last {
+			last = day
+		}
+	}
+	return first, last, nil
+}
+
+// resolvePreset turns a preset name into days as of now, weeks starting on
+// Monday, in the gyms' own zone since that is how the daily files are cut.
+// The range is then narrowed to the days with data, first to last, so "last
+// 30 days" on a new install doesn't open on an empty month.
+func resolvePreset(name string, now time.Time, first, last string) (Preset, error) {
+	now = now.In(gymdata.Tallinn())
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monday := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	month := today.AddDate(0, 0, 1-today.Day())
+	var from, to time.Time
+	switch name {
+	case "today":
+		from, to = today, today
+	case "yesterday":
+		from = today.AddDate(0, 0, -1)
+		to = from
+	case "this-week":
+		from, to = monday, today
+	case "last-week":
+		from, to = monday.AddDate(0, 0, -7), monday.AddDate(0, 0, -1)
+	case "last-7-days":
+		from, to = today.AddDate(0, 0, -6), today
+	case "last-30-days":
+		from, to = today.AddDate(0, 0, -29), today
+	case "this-month":
+		from, to = month, today
+	case "last-month":
+		from, to = month.AddDate(0, -1, 0), month.AddDate(0, 0, -1)
+	case "all":
+		if first == "" {
+			return Preset{Name: name, From: today.Format("2006-01-02"), To: today.Format("2006-01-02")}, nil
+		}
+		return Preset{Name: name, From: first, To: last, Available: true}, nil
+	default:
+		return Preset{}, fmt.Errorf("unknown preset %q: want one of %v", name, presetNames)
+	}
+
+	p := Preset{Name: name, From: from.Format("2006-01-02"), To: to.Format("2006-01-02")}
+	narrowed := p
+	if first > narrowed.From {
+		narrowed.From = first
+	}
+	if last != "" && last < narrowed.To {
+		narrowed.To = last
+	}
+	if first == "" || narrowed.From > narrowed.To {
+		return p, nil
+	}
+	narrowed.Available = true
+	return narrowed, nil
+}
+
+// presetsHandler lists the named ranges /generate-data-range accepts as
+// "preset", resolved as of now.
+//
+//	GET /api/presets
+func presetsHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	first, last, err := dataDays(requestConfig(r).csvDir())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	now := time.Now()
+	resp := PresetsResponse{
+		Timezone: gymdata.Tallinn().String(),
+		Today:    now.In(gymdata.Tallinn()).Format("2006-01-02"),
+		First:    first,
+		Last:     last,
+		Presets:  make([]Preset, 0, len(presetNames)),
+	}
+	for _, name := range presetNames {
+		p, _ := resolvePreset(name, now, first, last)
+		resp.Presets = append(resp.Presets, p)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// applyPreset fills in a range request's days from its preset, if it names
+// one instead of giving them.
+func applyPreset(cfg *Config, dateRange *DateRangeRequest, now time.Time) error {
+	if dateRange.Preset == "" {
+		return nil
+	}
+	if dateRange.From != "" || dateRange.To != "" {
+		return fmt.Errorf("give either a preset or from and to, not both")
+	}
+	first, last, err := dataDays(cfg.csvDir())
+	if err != nil {
+		return err
+	}
+	p, err := resolvePreset(dateRange.Preset, now, first, last)
+	dateRange.From, dateRange.To = p.From, p.To
+	return err
+}
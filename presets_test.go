@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolvePreset(t *testing.T) {
+	tallinn := loadTallinn(t)
+	// Wednesday 2025-10-15, 00:30 in Tallinn but still the 14th in UTC.
+	now := time.Date(2025, 10, 14, 21, 30, 0, 0, time.UTC)
+	if now.In(tallinn).Day() != 15 {
+		t.Fatal("setup: want the 15th in Tallinn")
+	}
+	for _, tc := range []struct {
+		name, first, last, from, to string
+		available                   bool
+	}{
+		{"today", "2025-01-01", "2025-10-15", "2025-10-15", "2025-10-15", true},
+		{"yesterday", "2025-01-01", "2025-10-15", "2025-10-14", "2025-10-14", true},
+		{"this-week", "2025-01-01", "2025-10-15", "2025-10-13", "2025-10-15", true},
+		{"last-week", "2025-01-01", "2025-10-15", "2025-10-06", "2025-10-12", true},
+		{"last-7-days", "2025-01-01", "2025-10-15", "2025-10-09", "2025-10-15", true},
+		{"last-month", "2025-01-01", "2025-10-15", "2025-09-01", "2025-09-30", true},
+		{"this-month", "2025-10-10", "2025-10-15", "2025-10-10", "2025-10-15", true}, // data only since the 10th
+		{"last-30-days", "2025-01-01", "2025-10-12", "2025-09-16", "2025-10-12", true},
+		{"today", "2025-01-01", "2025-10-12", "2025-10-15", "2025-10-15", false}, // collector down since the 12th
+		{"last-month", "", "", "2025-09-01", "2025-09-30", false},
+		{"all", "2025-01-01", "2025-10-12", "2025-01-01", "2025-10-12", true},
+	} {
+		p, err := resolvePreset(tc.name, now, tc.first, tc.last)
+		if err != nil || p.From != tc.from || p.To != tc.to || p.Available != tc.available {
+			t.Errorf("%s with data %s..%s = %+v, %v; want %s..%s available %v", tc.name, tc.first, tc.last, p, err, tc.from, tc.to, tc.available)
+		}
+	}
+	if _, err := resolvePreset("fortnight", now, "", ""); err == nil {
+		t.Error("unknown preset: expected error")
+	}
+
+	dir := t.TempDir()
+	writeCSV(t, dir, "gym-stats-20251013.csv", "")
+	writeCSV(t, dir, "gym-stats-20251014.csv.gz", "")
+	cfg := &Config{DataDir: dir}
+	req := DateRangeRequest{Preset: "this-week"}
+	if err := applyPreset(cfg, &req, now); err != nil || req.From != "2025-10-13" || req.To != "2025-10-14" {
+		t.Errorf("applyPreset = %+v, %v", req, err)
+	}
+	if err := applyPreset(cfg, &DateRangeRequest{Preset: "today", From: "2025-10-01"}, now); err == nil {
+		t.Error("preset and from: expected error")
+	}
+}
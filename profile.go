@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// ProfileSlot is one time-of-day bucket of a typical day: the mean over the
+// days of each day's own average for the bucket.
+type ProfileSlot struct {
+	Time string  `json:"time"` // bucket start, gym-local "HH:MM", or "+HH:MM" after opening
+	Avg  float64 `json:"avg"`
+	Days int     `json:"days"`
+}
+
+type ProfileLocation struct {
+	Name string `json:"name"`
+	// Opens is the median opening time over the range, with align=open.
+	Opens   string        `json:"opens,omitempty"`
+	Weekday []ProfileSlot `json:"weekday"`
+	Weekend []ProfileSlot `json:"weekend"`
+}
+
+type ProfileResponse struct {
+	From          string            `json:"from"`
+	To            string            `json:"to"`
+	Metric        string            `json:"metric"`
+	BucketMinutes int               `json:"bucketMinutes"`
+	Align         string            `json:"align,omitempty"`
+	Locations     []ProfileLocation `json:"locations"`
+}
+
+// computeProfile folds a series into per-day averages for each bucketMinutes
+// slot of the Tallinn day, keeping the days in [from, to), and averages those
+// separately over Monday-Friday and over weekends. As with the bands, a day
+// weighs the same however many readings it has in a slot. With align, slots
+// count from each day's opening instead of midnight.
+func computeProfile(s *gymdata.Series, bucketMinutes int, from, to time.Time, align bool, tallinn *time.Location) (weekday, weekend []ProfileSlot) {
+	type cell struct {
+		sum   float64
+		count int
+	}
+	slots := 24 * 60 / bucketMinutes
+	byDay := map[string][]cell{}
+	isWeekend := map[string]bool{}
+	clock := wallClock(tallinn)
+	if align {
+		clock = openingClock(s, tallinn)
+	}
+	for _, p := range s.Points {
+		d, minute, ok := clock(p.At)
+		if !ok || d.Before(from) || !d.Before(to) || minute >= 24*60 {
+			continue
+		}
+		day := d.Format("2006-01-02")
+		cells := byDay[day]
+		if cells == nil {
+			cells = make([]cell, slots)
+			byDay[day] = cells
+			isWeekend[day] = d.Weekday() == time.Saturday || d.Weekday() == time.Sunday
+		}
+		i := minute / bucketMinutes
+		cells[i].sum += p.Y
+		cells[i].count++
+	}
+
+	curve := func(weekendDays bool) []ProfileSlot {
+		out := []ProfileSlot{}
+		for i := 0; i < slots; i++ {
+			sum, days := 0.0, 0
+			for day, cells := range byDay {
+				if isWeekend[day] == weekendDays && cells[i].count > 0 {
+					sum += cells[i].sum / float64(cells[i].count)
+					days++
+				}
+			}
+			if days == 0 {
+				continue
+			}
+			out = append(out, ProfileSlot{
+				Time: slotLabel(i*bucketMinutes, align),
+				Avg:  math.Round(sum/float64(days)*10) / 10,
+				Days: days,
+			})
+		}
+		return out
+	}
+	return curve(false), curve(true)
+}
+
+// profileHandler returns each gym's typical day, as one curve for weekdays
+// and one for weekends, averaged over a range.
+//
+//	GET /api/profile[?from=YYYY-MM-DD&to=YYYY-MM-DD][&bucket=MIN][&metric=NAME][&location=NAME][&align=clock|open]
+//
+// The range defaults to the 28 days before today; to may be today at the
+// latest, whose partial day then counts like any other. align=open lines
+// the gyms up at their opening times, so ones with different hours compare.
+func profileHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	fail := func(msg string) {
+		writeError(w, http.StatusBadRequest, errors.New(msg))
+	}
+
+	tallinn := gymdata.Tallinn()
+	now := time.Now().In(tallinn)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, tallinn)
+
+	q := r.URL.Query()
+	to := today.AddDate(0, 0, -1)
+	if t, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(q.Get("to")), tallinn); err == nil && !t.After(today) {
+		to = t
+	}
+	from := to.AddDate(0, 0, -27)
+	if t, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(q.Get("from")), tallinn); err == nil {
+		from = t
+	}
+	if from.After(to) {
+		writeError(w, http.StatusBadRequest, withKind(ErrBadRange, errors.New("from is after to (the range ends today at the latest)")))
+		return
+	}
+	bucketMinutes := 30
+	if s := q.Get("bucket"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 2 || n > 120 || 24*60%n != 0 {
+			fail("bucket must be 2-120 minutes and divide the day")
+			return
+		}
+		bucketMinutes = n
+	}
+	metric := gymdata.NormalizeMetrics([]string{q.Get("metric")})[0]
+	location := strings.TrimSpace(q.Get("location"))
+	align, err := parseAlign(q.Get("align"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cfg := requestConfig(r)
+	end := to.AddDate(0, 0, 1)
+	if align {
+		end = end.AddDate(0, 0, 1) // the last day's night counts towards it
+	}
+	list, err := loadWindow(cfg, from, end, []string{metric})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp := ProfileResponse{
+		From:          from.Format("2006-01-02"),
+		To:            to.Format("2006-01-02"),
+		Metric:        metric,
+		BucketMinutes: bucketMinutes,
+		Locations:     []ProfileLocation{},
+	}
+	if align {
+		resp.Align = "open"
+	}
+	for _, s := range list {
+		if location != "" && !strings.EqualFold(s.Key.Location, location) {
+			continue
+		}
+		weekday, weekend := computeProfile(s, bucketMinutes, from, to.AddDate(0, 0, 1), align, tallinn)
+		if len(weekday) == 0 && len(weekend) == 0 {
+			continue
+		}
+		loc := ProfileLocation{Name: s.Key.Location, Weekday: weekday, Weekend: weekend}
+		if align {
+			loc.Opens = typicalOpening(s, from, to.AddDate(0, 0, 1), tallinn)
+		}
+		resp.Locations = append(resp.Locations, loc)
+	}
+	sort.Slice(resp.Locations, func(i, j int) bool { return resp.Locations[i].Name < resp.Locations[j].Name })
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"gym/internal/fixtures"
+)
+
+func TestProfileHandler(t *testing.T) {
+	tallinn := loadTallinn(t)
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// Two noiseless weeks, Monday 2025-10-06 to Sunday the 19th, with a
+	// collection gap on the first Monday evening.
+	monday := time.Date(2025, 10, 6, 0, 0, 0, 0, tallinn)
+	spec := fixtures.Spec{
+		Locations: fixtures.DefaultLocations[:2],
+		Start:     monday,
+		Days:      14,
+		Zone:      tallinn,
+		Gaps:      []fixtures.Gap{{From: monday.Add(18 * time.Hour), To: monday.Add(19 * time.Hour)}},
+	}
+	if _, err := spec.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(query string) (int, ProfileResponse) {
+		w := httptest.NewRecorder()
+		profileHandler(w, httptest.NewRequest("GET", "/api/profile"+query, nil))
+		var resp ProfileResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	code, resp := get("?from=2025-10-06&to=2025-10-19&bucket=60")
+	if code != 200 || len(resp.Locations) != 2 || resp.BucketMinutes != 60 {
+		t.Fatalf("code %d, %+v", code, resp)
+	}
+	hipodroom := resp.Locations[0]
+	if hipodroom.Name != "Hipodroom" {
+		t.Fatalf("first location = %q, want Hipodroom", hipodroom.Name)
+	}
+	slot := func(slots []ProfileSlot, at string) ProfileSlot {
+		for _, s := range slots {
+			if s.Time == at {
+				return s
+			}
+		}
+		t.Fatalf("no %s slot in %+v", at, slots)
+		return ProfileSlot{}
+	}
+	if s := slot(hipodroom.Weekday, "18:00"); s.Days != 9 || s.Avg < 60 {
+		t.Errorf("weekday 18:00 = %+v, want a near-peak average over 9 days (one lost to the gap)", s)
+	}
+	if s := slot(hipodroom.Weekend, "14:00"); s.Days != 4 || s.Avg < 45 || s.Avg > 55 {
+		t.Errorf("weekend 14:00 = %+v, want the midday hump over 4 days", s)
+	}
+	if s := slot(hipodroom.Weekend, "18:00"); s.Avg >= slot(hipodroom.Weekday, "18:00").Avg {
+		t.Errorf("weekend evening %v not quieter than weekday evening", s.Avg)
+	}
+
+	if _, resp := get("?from=2025-10-11&to=2025-10-12&location=t1"); len(resp.Locations) != 1 || len(resp.Locations[0].Weekday) != 0 || resp.Locations[0].Name != "T1" {
+		t.Errorf("weekend-only range for T1 = %+v", resp.Locations)
+	}
+	for _, bad := range []string{"?from=2025-10-10&to=2025-10-01", "?bucket=7", "?bucket=x"} {
+		if code, _ := get(bad); code != 400 {
+			t.Errorf("%s: code %d, want 400", bad, code)
+		}
+	}
+}
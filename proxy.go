@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// parsePrefixes reads a comma-separated list of addresses and CIDRs, such
+// as TRUSTED_PROXIES; a bare address is a prefix of just itself.
+func parsePrefixes(s string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, item := range splitList(s) {
+		if strings.Contains(item, "/") {
+			p, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not an address or CIDR", item)
+			}
+			out = append(out, p.Masked())
+			continue
+		}
+		a, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an address or CIDR", item)
+		}
+		a = a.Unmap()
+		out = append(out, netip.PrefixFrom(a, a.BitLen()))
+	}
+	return out, nil
+}
+
+func inPrefixes(a netip.Addr, list []netip.Prefix) bool {
+	for _, p := range list {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHop reads one X-Forwarded-For entry, which some proxies write with
+// a port.
+func parseHop(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if a, err := netip.ParseAddr(s); err == nil {
+		return a.Unmap(), true
+	}
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// clientIP is the caller's address without the port. Behind a proxy in
+// TRUSTED_PROXIES it is read from X-Forwarded-For instead: the nearest
+// address in it that isn't a trusted proxy, as everything further along
+// may have been written by the client itself.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, ok := parseHop(host)
+	if !ok {
+		return host
+	}
+	trusted := requestConfig(r).TrustedProxies
+	if !inPrefixes(addr, trusted) {
+		return addr.String()
+	}
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHop(hops[i])
+		if !ok {
+			break // nothing further can be trusted either
+		}
+		addr = hop
+		if !inPrefixes(hop, trusted) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+// adminAllowed says whether r comes from an address ADMIN_ALLOW lets reach
+// the admin endpoints; with none set, every address may.
+func adminAllowed(r *http.Request, c *Config) bool {
+	if len(c.AdminAllow) == 0 {
+		return true
+	}
+	addr, ok := parseHop(clientIP(r))
+	return ok && inPrefixes(addr, c.AdminAllow)
+}
+
+// withAccessLog logs each request with ACCESS_LOG on: the client's address
+// (as clientIP reads it), method, path, status and time taken.
+func withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requestConfig(r).AccessLog {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+		log.Printf("%s %s %s %d %dms", clientIP(r), r.Method, r.URL.Path, rec.code, time.Since(start).Milliseconds())
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParsePrefixes(t *testing.T) {
+	got, err := parsePrefixes("127.0.0.1, 10.0.0.0/8 ,::1,192.168.1.7/24")
+	if err != nil || len(got) != 4 || got[0].String() != "127.0.0.1/32" || got[2].String() != "::1/128" || got[3].String() != "192.168.1.0/24" {
+		t.Fatalf("%v, %v", got, err)
+	}
+	for _, bad := range []string{"localhost", "10.0.0.0/33", "1.2.3"} {
+		if _, err := parsePrefixes(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+	trusted, _ := parsePrefixes("127.0.0.1, 10.0.0.0/8")
+	setConfig(&Config{TrustedProxies: trusted})
+
+	for _, tc := range []struct {
+		name, remote string
+		forwarded    []string
+		want         string
+	}{
+		{"direct", "203.0.113.5:4000", nil, "203.0.113.5"},
+		{"untrusted peer's header ignored", "203.0.113.5:4000", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"through the proxy", "127.0.0.1:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed entries before the client", "127.0.0.1:5000", []string{"1.1.1.1, 198.51.100.1"}, "198.51.100.1"},
+		{"two trusted hops", "127.0.0.1:5000", []string{"198.51.100.1, 10.1.2.3"}, "198.51.100.1"},
+		{"headers split over lines", "127.0.0.1:5000", []string{"198.51.100.1", "10.1.2.3"}, "198.51.100.1"},
+		{"hop with a port", "127.0.0.1:5000", []string{"198.51.100.1:6000"}, "198.51.100.1"},
+		{"ipv6 client", "[::ffff:127.0.0.1]:5000", []string{"2001:db8::1"}, "2001:db8::1"},
+		{"garbage stops the walk", "127.0.0.1:5000", []string{"198.51.100.1, junk"}, "127.0.0.1"},
+		{"only proxies", "127.0.0.1:5000", []string{"10.0.0.1"}, "10.0.0.1"},
+		{"no header", "127.0.0.1:5000", nil, "127.0.0.1"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tc.remote
+		for _, h := range tc.forwarded {
+			r.Header.Add("X-Forwarded-For", h)
+		}
+		if got := clientIP(r); got != tc.want {
+			t.Errorf("%s: %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAdminAllow(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+	trusted, _ := parsePrefixes("127.0.0.1")
+	allow, _ := parsePrefixes("192.168.0.0/16")
+	setConfig(&Config{TrustedProxies: trusted, AdminAllow: allow})
+
+	call := func(min Role, client string) int {
+		h := requireRole(min, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+		r := httptest.NewRequest("GET", "/api/admin/audit", nil)
+		r.RemoteAddr = "127.0.0.1:5000"
+		r.Header.Set("X-Forwarded-For", client)
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code
+	}
+	if got := call(RoleAdmin, "192.168.1.20"); got != http.StatusTeapot {
+		t.Errorf("allowed address: %d", got)
+	}
+	if got := call(RoleAdmin, "198.51.100.1"); got != http.StatusForbidden {
+		t.Errorf("other address: %d, want 403", got)
+	}
+	if got := call(RoleViewer, "198.51.100.1"); got != http.StatusTeapot {
+		t.Errorf("viewer endpoint from another address: %d", got)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+	trusted, _ := parsePrefixes("127.0.0.1")
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+
+	h := withAccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) }))
+	serve := func() {
+		r := httptest.NewRequest("GET", "/api/records", nil)
+		r.RemoteAddr = "127.0.0.1:5000"
+		r.Header.Set("X-Forwarded-For", "198.51.100.1")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	setConfig(&Config{TrustedProxies: trusted})
+	serve()
+	if buf.Len() != 0 {
+		t.Errorf("logged with ACCESS_LOG off: %q", buf.String())
+	}
+	setConfig(&Config{TrustedProxies: trusted, AccessLog: true})
+	serve()
+	if !strings.Contains(buf.String(), "198.51.100.1 GET /api/records 404 ") {
+		t.Errorf("log %q", buf.String())
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pushBrowser is a browser's side of a subscription: its keys, and what
+// its push service has been sent.
+type pushBrowser struct {
+	key    *ecdh.PrivateKey
+	auth   []byte
+	srv    *httptest.Server
+	status int
+	got    chan *http.Request
+	bodies chan []byte
+}
+
+func newPushBrowser(t *testing.T) *pushBrowser {
+	b := &pushBrowser{auth: make([]byte, 16), status: http.StatusCreated, got: make(chan *http.Request, 10), bodies: make(chan []byte, 10)}
+	b.key, _ = ecdh.P256().GenerateKey(rand.Reader)
+	rand.Read(b.auth)
+	b.srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(b.status)
+		b.got <- r
+		b.bodies <- body
+	}))
+	t.Cleanup(b.srv.Close)
+	return b
+}
+
+func (b *pushBrowser) subscription() string {
+	return fmt.Sprintf(`{"endpoint":%q,"keys":{"p256dh":%q,"auth":%q}}`,
+		b.srv.URL+"/push/abc", b64.EncodeToString(b.key.PublicKey().Bytes()), b64.EncodeToString(b.auth))
+}
+
+// decrypt undoes encryptPush as the browser would (RFC 8291).
+func (b *pushBrowser) decrypt(t *testing.T, body []byte) []byte {
+	t.Helper()
+	salt, rs, idlen := body[:16], binary.BigEndian.Uint32(body[16:20]), int(body[20])
+	if rs != 4096 || idlen != 65 {
+		t.Fatalf("header rs %d idlen %d", rs, idlen)
+	}
+	asRaw := body[21 : 21+idlen]
+	as, err := ecdh.P256().NewPublicKey(asRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, _ := b.key.ECDH(as)
+	prk, _ := hkdf.Extract(sha256.New, shared, b.auth)
+	ikm, _ := hkdf.Expand(sha256.New, prk, "WebPush: info\x00"+string(b.key.PublicKey().Bytes())+string(asRaw), 32)
+	prk, _ = hkdf.Extract(sha256.New, ikm, salt)
+	cek, _ := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	nonce, _ := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plain, err := gcm.Open(nil, nonce, body[21+idlen:], nil)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if plain[len(plain)-1] != 2 {
+		t.Fatalf("padding delimiter %d, want 2", plain[len(plain)-1])
+	}
+	return plain[:len(plain)-1]
+}
+
+// checkVAPID verifies the Authorization header's JWT against the server's key.
+func checkVAPID(t *testing.T, header string, key *ecdsa.PrivateKey, aud string) {
+	t.Helper()
+	var jwt, k string
+	if _, err := fmt.Sscanf(header, "vapid t=%s k=%s", &jwt, &k); err != nil {
+		t.Fatalf("Authorization %q: %v", header, err)
+	}
+	jwt = strings.TrimSuffix(jwt, ",")
+	if k != vapidPublicKey(key) {
+		t.Errorf("k = %s, want the server's key", k)
+	}
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		t.Fatalf("JWT %q", jwt)
+	}
+	sig, _ := b64.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if len(sig) != 64 || !ecdsa.Verify(&key.PublicKey, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		t.Fatal("JWT signature does not verify")
+	}
+	var claims struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+		Sub string `json:"sub"`
+	}
+	raw, _ := b64.DecodeString(parts[1])
+	json.Unmarshal(raw, &claims)
+	if claims.Aud != aud || claims.Sub != "mailto:ops@example.com" || claims.Exp <= time.Now().Unix() {
+		t.Errorf("claims = %+v, want aud %s", claims, aud)
+	}
+}
+
+func TestParseVAPIDKey(t *testing.T) {
+	s, err := newVAPIDKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := parseVAPIDKey(s)
+	if err != nil || k == nil || !k.Curve.IsOnCurve(k.X, k.Y) {
+		t.Fatalf("parseVAPIDKey(%s) = %v, %v", s, k, err)
+	}
+	if k, err := parseVAPIDKey(""); k != nil || err != nil {
+		t.Errorf("empty key = %v, %v; want none", k, err)
+	}
+	if _, err := parseVAPIDKey("c2hvcnQ"); err == nil {
+		t.Error("short key accepted")
+	}
+	env := map[string]string{"VAPID_PRIVATE_KEY": s}
+	get := func(key, def string) string {
+		if v, ok := env[key]; ok {
+			return v
+		}
+		return def
+	}
+	if _, err := buildConfig(get); err == nil {
+		t.Error("VAPID key without VAPID_SUBJECT accepted")
+	}
+}
+
+func TestPushHostAllowed(t *testing.T) {
+	for host, want := range map[string]bool{
+		"fcm.googleapis.com":                  true,
+		"FCM.googleapis.com.":                 true,
+		"updates.push.services.mozilla.com":   true,
+		"wns2-par02p.notify.windows.com":      true,
+		"web.push.apple.com":                  true,
+		"push.apple.com":                      false,
+		"evil-fcm.googleapis.com":             false,
+		"notify.windows.com.attacker.example": false,
+		"localhost":                           false,
+		"127.0.0.1":                           false,
+		"10.0.0.1":                            false,
+	} {
+		if got := pushHostAllowed(host, defaultPushHosts); got != want {
+			t.Errorf("%s: allowed %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestEncryptPushLeavesPayloadAlone(t *testing.T) {
+	b := newPushBrowser(t)
+	s := PushSubscription{Endpoint: b.srv.URL}
+	s.Keys.P256dh = b64.EncodeToString(b.key.PublicKey().Bytes())
+	s.Keys.Auth = b64.EncodeToString(b.auth)
+	buf := []byte("hello, world")
+	payload := buf[:5] // spare capacity the delimiter must not land in
+	body, err := encryptPush(s, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello, world" {
+		t.Errorf("caller's array = %q, want it untouched", buf)
+	}
+	if got := b.decrypt(t, body); string(got) != "hello" {
+		t.Errorf("decrypted %q, want hello", got)
+	}
+}
+
+func TestPushAlerts(t *testing.T) {
+	loadTallinn(t)
+	old, oldClient := currentConfig(), pushClient
+	defer func() { setConfig(old); pushClient = oldClient }()
+	s, _ := newVAPIDKey()
+	key, _ := parseVAPIDKey(s)
+	dir := t.TempDir()
+	cfg := &Config{DataDir: dir, PushFile: "gym-push.json", VAPIDKey: key, VAPIDSubject: "mailto:ops@example.com", PushHosts: []string{"127.0.0.1"}}
+	setConfig(cfg)
+	now := time.Now().UTC().Truncate(time.Second)
+	reading := func(count int) {
+		writeCSV(t, dir, "gym-stats-"+now.Format("20060102")+".csv",
+			"timestamp,timezone,location_id,location_name,user_count,status,response\n"+
+				fmt.Sprintf("%s,UTC,1,Hipodroom,%d,success,{}\n", now.Format("2006-01-02 15:04:05"), count)+
+				fmt.Sprintf("%s,UTC,2,T1,40,success,{}\n", now.Format("2006-01-02 15:04:05")))
+	}
+	reading(50)
+
+	browser := newPushBrowser(t)
+	pushClient = browser.srv.Client()
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("X-Client-ID", "3f2a9c1e-browser")
+		w := httptest.NewRecorder()
+		pushHandler(w, r)
+		return w
+	}
+
+	for _, c := range []struct{ body, field string }{
+		{`{"subscription":{"endpoint":"http://push.example/x"},"location":"Hipo","below":20}`, "subscription"},
+		{`{"subscription":` + strings.Replace(browser.subscription(), "127.0.0.1", "169.254.169.254", 1) + `,"location":"Hipo","below":20}`, "subscription"},
+		{`{"subscription":` + browser.subscription() + `,"location":"Hipo","below":0}`, "below"},
+		{`{"subscription":` + browser.subscription() + `,"location":"Kristiine","below":20}`, "location"},
+	} {
+		w := call("POST", "/api/push", c.body)
+		var e APIError
+		json.Unmarshal(w.Body.Bytes(), &e)
+		if w.Code != 400 || e.Field != c.field {
+			t.Errorf("%s: %d %s, want 400 on %s", c.body, w.Code, w.Body, c.field)
+		}
+	}
+	w := call("POST", "/api/push", `{"subscription":`+browser.subscription()+`,"location":"hipo","below":20}`)
+	var a PushAlert
+	json.Unmarshal(w.Body.Bytes(), &a)
+	if w.Code != 201 || a.ID != 1 || a.Location != "Hipodroom" {
+		t.Fatalf("add: %d %s", w.Code, w.Body)
+	}
+	var list PushResponse
+	json.Unmarshal(call("GET", "/api/push", "").Body.Bytes(), &list)
+	if list.PublicKey != vapidPublicKey(key) || len(list.Alerts) != 1 {
+		t.Errorf("list = %+v", list)
+	}
+
+	status := func() StatusResponse { return readLatestStatus(cfg, time.UTC) }
+	checkPushAlerts(cfg, status())
+	select {
+	case <-browser.got:
+		t.Fatal("sent while the gym was busy")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	reading(12)
+	checkPushAlerts(cfg, status())
+	r, body := <-browser.got, <-browser.bodies
+	checkVAPID(t, r.Header.Get("Authorization"), key, browser.srv.URL)
+	if r.Header.Get("Content-Encoding") != "aes128gcm" || r.Header.Get("TTL") == "" {
+		t.Errorf("headers %v", r.Header)
+	}
+	var msg map[string]string
+	if err := json.Unmarshal(browser.decrypt(t, body), &msg); err != nil || msg["title"] != "Hipodroom: 12 people" {
+		t.Errorf("payload %v, %v", msg, err)
+	}
+
+	// Still quiet: no second alert until it has been busy again.
+	checkPushAlerts(cfg, status())
+	reading(30)
+	checkPushAlerts(cfg, status())
+	select {
+	case <-browser.got:
+		t.Fatal("sent twice for one quiet spell")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The browser has unsubscribed: the push service says so and the alert goes.
+	browser.status = http.StatusGone
+	reading(5)
+	checkPushAlerts(cfg, status())
+	<-browser.got
+	<-browser.bodies
+	for i := 0; ; i++ {
+		json.Unmarshal(call("GET", "/api/push", "").Body.Bytes(), &list)
+		if len(list.Alerts) == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("alert kept after 410: %+v", list.Alerts)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if w := call("DELETE", "/api/push/7", ""); w.Code != 404 {
+		t.Errorf("delete unknown: %d", w.Code)
+	}
+	setConfig(&Config{DataDir: dir, PushFile: "gym-push.json"})
+	if w := call("GET", "/api/push", ""); w.Code != 503 {
+		t.Errorf("without a key: %d, want 503", w.Code)
+	}
+}
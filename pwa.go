@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// dayFormat versions /api/day's replies along with the data they're built
+// from: bump it whenever what they hold changes, or clients keep the old
+// shape cached for good.
+const dayFormat = "1"
+
+// WebManifest is the PWA's app manifest. Its URLs are relative to it, so a
+// tenant's /t/NAME/manifest.json installs that tenant's dashboard.
+type WebManifest struct {
+	ID              string            `json:"id"`
+	Name            string            `json:"name"`
+	ShortName       string            `json:"short_name"`
+	Description     string            `json:"description"`
+	StartURL        string            `json:"start_url"`
+	Scope           string            `json:"scope"`
+	Display         string            `json:"display"`
+	BackgroundColor string            `json:"background_color"`
+	ThemeColor      string            `json:"theme_color"`
+	Icons           []WebManifestIcon `json:"icons"`
+}
+
+type WebManifestIcon struct {
+	Src     string `json:"src"`
+	Sizes   string `json:"sizes"`
+	Type    string `json:"type"`
+	Purpose string `json:"purpose"`
+}
+
+// DayVersion is a day of data and the URL its chart data is served under
+// until the day's rows change.
+type DayVersion struct {
+	Date    string `json:"date"`
+	Version string `json:"version"`
+	URL     string `json:"url"`
+}
+
+type DaysIndex struct {
+	Days []DayVersion `json:"days"`
+}
+
+// webManifestHandler serves the app manifest, named by APP_NAME and
+// APP_SHORT_NAME.
+//
+//	GET /manifest.json
+func webManifestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := requestConfig(r)
+	m := WebManifest{
+		ID:              "dashboard.html",
+		Name:            cfg.AppName,
+		ShortName:       cfg.AppShortName,
+		Description:     "Climbing gym occupancy — live and typical",
+		StartURL:        "dashboard.html",
+		Scope:           "./",
+		Display:         "standalone",
+		BackgroundColor: "#0f0f10",
+		ThemeColor:      "#007bff",
+		Icons: []WebManifestIcon{
+			{Src: "icon-192.png", Sizes: "192x192", Type: "image/png", Purpose: "any"},
+			{Src: "icon-512.png", Sizes: "512x512", Type: "image/png", Purpose: "any maskable"},
+		},
+	}
+	body, _ := json.Marshal(m)
+	etag := fmt.Sprintf(`"%016x"`, fnvAdd(fnvOffset, string(body)))
+	w.Header().Set("Content-Type", "application/manifest+json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", etag)
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(body)
+}
+
+// dayVersions maps each day on disk (YYYY-MM-DD) to its data's version: a
+// hash of its rows, as the data manifest keeps them, the outlier filter
+// and dayFormat. Gzipping a day keeps it.
+func dayVersions(cfg *Config) (map[string]string, error) {
+	m, err := buildManifest(cfg, gymdata.Tallinn())
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(m.days))
+	for date, h := range m.days {
+		out[date] = fmt.Sprintf("%016x", fnvAdd(h, dayFormat+"|"+cfg.OutlierFilter.String()))
+	}
+	return out, nil
+}
+
+// daysHandler lists every day of data, oldest first, with the versioned URL
+// of its chart data. A service worker keeps what it fetched from those
+// URLs, which never change content, and reads this list to know which
+// copies are still current. Served with an ETag.
+//
+//	GET /api/days
+func daysHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	versions, err := dayVersions(requestConfig(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	out := DaysIndex{Days: make([]DayVersion, 0, len(versions))}
+	for date, v := range versions {
+		out.Days = append(out.Days, DayVersion{Date: date, Version: v, URL: "api/day/" + date + "?v=" + v})
+	}
+	sort.Slice(out.Days, func(i, j int) bool { return out.Days[i].Date < out.Days[j].Date })
+	etag := uint64(fnvOffset)
+	for _, d := range out.Days {
+		etag = fnvAdd(etag, d.Date+"="+d.Version+"\n")
+	}
+	tag := fmt.Sprintf(`"%016x"`, etag)
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+// dayHandler serves one day's chart data, every gym's including closed
+// ones, under a URL that names its version: the reply may be kept for good,
+// as a day whose rows change gets a new version. A request without the
+// current version is redirected to it. Annotations and preferences, which
+// change apart from the data, are left out.
+//
+//	GET /api/day/{YYYY-MM-DD}?v=VERSION
+func dayHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	date := strings.TrimPrefix(r.URL.Path, "/api/day/")
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		writeError(w, http.StatusBadRequest, fieldErr("date", ErrBadRange, fmt.Errorf("date %q: want YYYY-MM-DD", date)))
+		return
+	}
+	cfg := requestConfig(r)
+	versions, err := dayVersions(cfg)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	version, ok := versions[date]
+	if !ok {
+		writeError(w, http.StatusNotFound, withKind(ErrNoData, fmt.Errorf("no data for %s", date)))
+		return
+	}
+	if r.URL.Query().Get("v") != version {
+		// Relative, so a /t/NAME/ tenant's stays under its prefix.
+		w.Header().Set("Location", date+"?v="+version)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusFound)
+		return
+	}
+	etag := `"` + version + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	resp, list, pf, status, err := rangeResponse(r, DateRangeRequest{From: date, To: date, Closed: "include"}, false)
+	if err != nil {
+		w.Header().Del("Cache-Control")
+		writeError(w, status, err)
+		return
+	}
+	resp.Annotations, resp.Preferences = nil, nil
+	writeChartResponse(w, r, resp, list, pf)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestWebManifestHandler(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+	setConfig(&Config{AppName: "Ronimisseinad", AppShortName: "Ronimine"})
+
+	w := httptest.NewRecorder()
+	webManifestHandler(w, httptest.NewRequest("GET", "/manifest.json", nil))
+	var m WebManifest
+	if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil || w.Code != 200 {
+		t.Fatalf("code %d: %s", w.Code, w.Body)
+	}
+	if m.Name != "Ronimisseinad" || m.ShortName != "Ronimine" || m.StartURL != "dashboard.html" || m.Scope != "./" || len(m.Icons) != 2 {
+		t.Errorf("manifest = %+v", m)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/manifest+json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	r := httptest.NewRequest("GET", "/manifest.json", nil)
+	r.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	webManifestHandler(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("revalidation: code %d, want 304", w.Code)
+	}
+}
+
+func TestDayHandlers(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+	dir := t.TempDir()
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	writeCSV(t, dir, "gym-stats-20251001.csv", header+"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,\"{}\"\n")
+	day2 := writeCSV(t, dir, "gym-stats-20251002.csv", header+"2025-10-02 10:00:00,EEST,1,Hipodroom,20,success,\"{}\"\n")
+	setConfig(&Config{DataDir: dir, ClosedLocations: []string{"Hipodroom"}})
+
+	days := func() []DayVersion {
+		w := httptest.NewRecorder()
+		daysHandler(w, httptest.NewRequest("GET", "/api/days", nil))
+		var idx DaysIndex
+		if err := json.Unmarshal(w.Body.Bytes(), &idx); err != nil || w.Code != 200 || w.Header().Get("ETag") == "" {
+			t.Fatalf("days: code %d: %s", w.Code, w.Body)
+		}
+		return idx.Days
+	}
+	list := days()
+	if len(list) != 2 || list[0].Date != "2025-10-01" || list[1].URL != "api/day/2025-10-02?v="+list[1].Version {
+		t.Fatalf("days = %+v", list)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		dayHandler(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	w := get("/" + list[0].URL)
+	var resp GenerateResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != 200 {
+		t.Fatalf("day: code %d: %s", w.Code, w.Body)
+	}
+	if len(resp.Datasets) != 1 || len(resp.Datasets[0].Data) != 1 || resp.Datasets[0].Data[0].Y != 12 {
+		t.Errorf("datasets = %+v, want the closed gym's reading too", resp.Datasets)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "private, max-age=31536000, immutable" {
+		t.Errorf("Cache-Control = %q", cc)
+	}
+	if w := get("/api/day/2025-10-01?v=stale"); w.Code != http.StatusFound || w.Header().Get("Location") != "2025-10-01?v="+list[0].Version {
+		t.Errorf("stale version: code %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := get("/api/day/2025-09-30"); w.Code != http.StatusNotFound {
+		t.Errorf("a day without data: code %d, want 404", w.Code)
+	}
+	if w := get("/api/day/yesterday"); w.Code != http.StatusBadRequest {
+		t.Errorf("a bad date: code %d, want 400", w.Code)
+	}
+
+	// A new row moves only its day to a new URL.
+	f, _ := os.OpenFile(day2, os.O_APPEND|os.O_WRONLY, 0o644)
+	f.WriteString("2025-10-02 10:02:00,EEST,1,Hipodroom,21,success,\"{}\"\n")
+	f.Close()
+	again := days()
+	if again[0].Version != list[0].Version || again[1].Version == list[1].Version {
+		t.Errorf("versions %+v -> %+v, want only 2025-10-02's changed", list, again)
+	}
+}
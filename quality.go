@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+type QualityLocation struct {
+	Name              string  `json:"name"`
+	Expected          int     `json:"expected"`
+	Actual            int     `json:"actual"`
+	Completeness      float64 `json:"completeness"`
+	Errors            int     `json:"errors"`
+	ErrorRate         float64 `json:"errorRate"`
+	LongestGapMinutes int     `json:"longestGapMinutes"`
+	LongestGapStart   string  `json:"longestGapStart,omitempty"`
+}
+
+type QualityDay struct {
+	Date         string            `json:"date"`
+	Completeness float64           `json:"completeness"`
+	Locations    []QualityLocation `json:"locations"`
+}
+
+type QualityResponse struct {
+	From            string            `json:"from"`
+	To              string            `json:"to"`
+	IntervalMinutes int               `json:"intervalMinutes"`
+	Days            []QualityDay      `json:"days"`
+	Summary         []QualityLocation `json:"summary"`
+}
+
+// qualityCell gathers one location's rows for one local day.
+type qualityCell struct {
+	times  []time.Time
+	errors int
+	failed []time.Time // when the errors were, for the SLO's burn rates
+}
+
+// scanQualityRows records every row of csvFile under its Tallinn-local day.
+// Non-success rows (and success rows without a usable count) are errors.
+func scanQualityRows(cfg *Config, csvFile string, tallinn *time.Location, cells map[string]map[string]*qualityCell) {
+	file, err := gymdata.Open(csvFile)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	headers, err := reader.Read()
+	if err != nil {
+		return
+	}
+	headers = gymdata.CanonicalHeaders(headers, cfg.CSVColumns)
+	tsIdx, tzIdx, locIdx, cntIdx, stIdx := -1, -1, -1, -1, -1
+	for i, h := range headers {
+		switch h {
+		case "timestamp":
+			tsIdx = i
+		case "timezone":
+			tzIdx = i
+		case "location_name":
+			locIdx = i
+		case "user_count":
+			cntIdx = i
+		case "status":
+			stIdx = i
+		}
+	}
+	if tsIdx == -1 || locIdx == -1 || cntIdx == -1 || stIdx == -1 {
+		return
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			continue
+		}
+		maxIdx := max2(max2(max2(tsIdx, tzIdx), max2(locIdx, cntIdx)), stIdx)
+		if len(record) <= maxIdx {
+			continue
+		}
+		tzVal := ""
+		if tzIdx != -1 {
+			tzVal = record[tzIdx]
+		}
+		local, ok := gymdata.LocalTime(record[tsIdx], tzVal, cfg.CSVTimeLayout, tallinn)
+		if !ok {
+			continue
+		}
+		day := local.Format("2006-01-02")
+		if cells[day] == nil {
+			cells[day] = map[string]*qualityCell{}
+		}
+		name := record[locIdx]
+		cell := cells[day][name]
+		if cell == nil {
+			cell = &qualityCell{}
+			cells[day][name] = cell
+		}
+		if _, err := strconv.Atoi(record[cntIdx]); cfg.StatusPolicy.Action(record[stIdx]) == gymdata.Exclude || err != nil {
+			cell.errors++
+			cell.failed = append(cell.failed, local)
+			continue
+		}
+		cell.times = append(cell.times, local)
+	}
+}
+
+// longestGap finds the longest stretch without a reading inside [start, end),
+// counting the edges so a missing morning or evening shows up too.
+func longestGap(times []time.Time, start, end time.Time) (time.Duration, time.Time) {
+	sorted := append([]time.Time(nil), times...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+
+	best, bestStart := time.Duration(0), time.Time{}
+	prev := start
+	for _, t := range sorted {
+		if t.Before(start) || !t.Before(end) {
+			continue
+		}
+		if gap := t.Sub(prev); gap > best {
+			best, bestStart = gap, prev
+		}
+		prev = t
+	}
+	if gap := end.Sub(prev); gap > best {
+		best, bestStart = gap, prev
+	}
+	return best, bestStart
+}
+
+func ratio(n, d int) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return math.Round(float64(n)/float64(d)*1000) / 1000
+}
+
+// buildQuality turns the per-day cells into the report. Every location seen
+// anywhere in the range is listed on every day, so a day it went unrecorded
+// shows 0% rather than vanishing.
+func buildQuality(cells map[string]map[string]*qualityCell, from, to time.Time, interval time.Duration, now time.Time) QualityResponse {
+	names := map[string]bool{}
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		for n := range cells[d.Format("2006-01-02")] {
+			names[n] = true
+		}
+	}
+	sortedNames := make([]string, 0, len(names))
+	for n := range names {
+		sortedNames = append(sortedNames, n)
+	}
+	sort.Strings(sortedNames)
+
+	type total struct{ expected, actual, errors, gap int }
+	totals := map[string]*total{}
+	gapStarts := map[string]string{}
+	resp := QualityResponse{
+		From:            from.Format("2006-01-02"),
+		To:              to.Format("2006-01-02"),
+		IntervalMinutes: int(interval / time.Minute),
+		Days:            []QualityDay{},
+		Summary:         []QualityLocation{},
+	}
+
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		dayStart := d
+		dayEnd := d.AddDate(0, 0, 1)
+		if dayStart.After(now) {
+			break
+		}
+		if dayEnd.After(now) {
+			dayEnd = now // today only counts up to now
+		}
+		expected := int(dayEnd.Sub(dayStart) / interval)
+
+		day := QualityDay{Date: d.Format("2006-01-02"), Locations: make([]QualityLocation, 0, len(sortedNames))}
+		sumCompleteness := 0.0
+		for _, name := range sortedNames {
+			cell := cells[day.Date][name]
+			if cell == nil {
+				cell = &qualityCell{}
+			}
+			gap, gapStart := longestGap(cell.times, dayStart, dayEnd)
+			actual := min(len(cell.times), expected)
+			loc := QualityLocation{
+				Name:              name,
+				Expected:          expected,
+				Actual:            actual,
+				Completeness:      ratio(actual, expected),
+				Errors:            cell.errors,
+				ErrorRate:         ratio(cell.errors, cell.errors+len(cell.times)),
+				LongestGapMinutes: int(gap / time.Minute),
+				LongestGapStart:   gapStart.Format("2006-01-02T15:04:05Z07:00"),
+			}
+			day.Locations = append(day.Locations, loc)
+			sumCompleteness += loc.Completeness
+
+			t := totals[name]
+			if t == nil {
+				t = &total{}
+				totals[name] = t
+			}
+			t.expected += expected
+			t.actual += actual
+			t.errors += cell.errors
+			if loc.LongestGapMinutes > t.gap {
+				t.gap = loc.LongestGapMinutes
+				gapStarts[name] = loc.LongestGapStart
+			}
+		}
+		if len(sortedNames) > 0 {
+			day.Completeness = math.Round(sumCompleteness/float64(len(sortedNames))*1000) / 1000
+		}
+		resp.Days = append(resp.Days, day)
+	}
+
+	for _, name := range sortedNames {
+		t := totals[name]
+		if t == nil {
+			continue
+		}
+		resp.Summary = append(resp.Summary, QualityLocation{
+			Name:              name,
+			Expected:          t.expected,
+			Actual:            t.actual,
+			Completeness:      ratio(t.actual, t.expected),
+			Errors:            t.errors,
+			ErrorRate:         ratio(t.errors, t.errors+t.actual),
+			LongestGapMinutes: t.gap,
+			LongestGapStart:   gapStarts[name],
+		})
+	}
+	return resp
+}
+
+// qualityHandler reports per-day, per-location collection completeness.
+//
+//	GET /api/quality[?from=YYYY-MM-DD&to=YYYY-MM-DD][&interval=MINUTES]
+func qualityHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	tallinn := gymdata.Tallinn()
+	now := time.Now().In(tallinn)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, tallinn)
+
+	q := r.URL.Query()
+	to := today
+	if t, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(q.Get("to")), tallinn); err == nil {
+		to = t
+	}
+	from := to.AddDate(0, 0, -29) // default: the 30 days ending at 'to'
+	if t, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(q.Get("from")), tallinn); err == nil {
+		from = t
+	}
+	if from.After(to) {
+		writeError(w, http.StatusBadRequest, withKind(ErrBadRange, errors.New("from is after to")))
+		return
+	}
+	interval := 2 * time.Minute
+	if n, err := strconv.Atoi(q.Get("interval")); err == nil && n > 0 {
+		interval = time.Duration(n) * time.Minute
+	}
+
+	// Rows are bucketed by their own timestamp, whichever file they sit in.
+	cfg := requestConfig(r)
+	files, err := windowFiles(cfg, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	cells := map[string]map[string]*qualityCell{}
+	for _, f := range files {
+		scanQualityRows(cfg, f, tallinn, cells)
+	}
+
+	json.NewEncoder(w).Encode(buildQuality(cells, from, to, interval, now))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// brokenCSVSource registers an S3 source under bucket whose listing always
+// fails, and returns its root for Config.CSVSource.
+func brokenCSVSource(t *testing.T, bucket string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(srv.Close)
+	src, err := gymdata.NewS3Source("s3://"+bucket+"/gym", srv.URL, "", "key", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gymdata.RegisterS3Source(src)
+	return src.Root()
+}
+
+func TestLongestGapCountsDayEdges(t *testing.T) {
+	start := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+	at := func(h, m int) time.Time { return start.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute) }
+
+	gap, from := longestGap([]time.Time{at(12, 0), at(6, 0), at(6, 2)}, start, end)
+	if gap != 12*time.Hour || !from.Equal(at(12, 0)) {
+		t.Errorf("got %v from %v, want trailing 12h from noon", gap, from)
+	}
+	gap, from = longestGap([]time.Time{at(8, 0), at(23, 0)}, start, end)
+	if gap != 15*time.Hour || !from.Equal(at(8, 0)) {
+		t.Errorf("got %v from %v, want 15h from 08:00", gap, from)
+	}
+	if gap, _ := longestGap(nil, start, end); gap != 24*time.Hour {
+		t.Errorf("empty day gap = %v, want 24h", gap)
+	}
+}
+
+func TestBuildQuality(t *testing.T) {
+	day := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	var times []time.Time
+	for i := 0; i < 360; i++ { // first half of the day at 2-minute spacing
+		times = append(times, day.Add(time.Duration(i)*2*time.Minute))
+	}
+	cells := map[string]map[string]*qualityCell{
+		"2026-03-02": {"A": {times: times, errors: 40}},
+		"2026-03-03": {"B": {times: times[:1]}},
+	}
+	now := day.Add(36 * time.Hour) // noon on the 3rd
+	resp := buildQuality(cells, day, day.AddDate(0, 0, 2), 2*time.Minute, now)
+
+	if len(resp.Days) != 2 {
+		t.Fatalf("days = %d, want 2 (future day dropped)", len(resp.Days))
+	}
+	a := resp.Days[0].Locations[0]
+	if a.Name != "A" || a.Expected != 720 || a.Actual != 360 || a.Completeness != 0.5 {
+		t.Errorf("A day 1 = %+v", a)
+	}
+	if a.ErrorRate != 0.1 || a.LongestGapMinutes != 722 {
+		t.Errorf("A day 1 errors/gap = %v / %d", a.ErrorRate, a.LongestGapMinutes)
+	}
+	if b := resp.Days[0].Locations[1]; b.Name != "B" || b.Actual != 0 || b.LongestGapMinutes != 1440 {
+		t.Errorf("B day 1 should be listed as empty, got %+v", b)
+	}
+	if got := resp.Days[1].Locations[0]; got.Expected != 360 {
+		t.Errorf("today expected = %d, want 360 (up to now)", got.Expected)
+	}
+	if s := resp.Summary[0]; s.Expected != 1080 || s.Actual != 360 {
+		t.Errorf("summary A = %+v", s)
+	}
+}
+
+func TestQualityFailsWhenListingFails(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+	setConfig(&Config{DataDir: t.TempDir(), CSVSource: brokenCSVSource(t, "broken-quality")})
+
+	w := httptest.NewRecorder()
+	qualityHandler(w, httptest.NewRequest("GET", "/api/quality?from=2025-10-01&to=2025-10-02", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500 when the CSV source can't be listed", w.Code)
+	}
+}
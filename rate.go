@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+const (
+	// rateMetric tags the derived series; Key.Label turns it into
+	// "Hipodroom (user_count_rate)".
+	rateMetric = "user_count_rate"
+	// rateSpan is what a rate is expressed per: people per 10 minutes.
+	rateSpan = 10 * 60
+	// rateMaxGap splits a series where the collector missed more than a few
+	// polls, so an outage doesn't read as a rush or an exodus.
+	rateMaxGap = 10 * 60
+)
+
+// rateSeries turns headcounts into their rate of change in people per 10
+// minutes. Counts are first smoothed with a trailing mean over smoothMinutes
+// (a single noisy poll otherwise shows as a spike and its mirror image), then
+// each point is compared with the smoothed value ~10 minutes earlier. Points
+// with under 5 minutes of history since a gap are left out.
+func rateSeries(points []gymdata.Point, smoothMinutes int) []gymdata.Point {
+	out := []gymdata.Point{}
+	for start := 0; start < len(points); {
+		end := start + 1
+		for end < len(points) && points[end].At-points[end-1].At <= rateMaxGap {
+			end++
+		}
+		seg := points[start:end]
+		start = end
+
+		smooth := make([]float64, len(seg))
+		sum, lo := 0.0, 0
+		for i, p := range seg {
+			sum += p.Y
+			for seg[lo].At <= p.At-int64(smoothMinutes*60) {
+				sum -= seg[lo].Y
+				lo++
+			}
+			smooth[i] = sum / float64(i-lo+1)
+		}
+		back := 0
+		for i, p := range seg {
+			for seg[back].At < p.At-rateSpan {
+				back++
+			}
+			dt := p.At - seg[back].At
+			if dt < rateSpan/2 {
+				continue
+			}
+			rate := (smooth[i] - smooth[back]) / float64(dt) * rateSpan
+			out = append(out, gymdata.Point{At: p.At, Y: math.Round(rate*10) / 10})
+		}
+	}
+	return out
+}
+
+// rateHandler serves each gym's headcount rate of change (people per 10
+// minutes), smoothed, as chart datasets, so the build-up of a rush shows and
+// not only its peak. Either the last `hours` (default 24) or whole days.
+//
+//	GET /api/rate[?hours=24 | ?from=YYYY-MM-DD&to=YYYY-MM-DD][&smooth=MIN][&tz=ZONE]
+func rateHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+		return
+	}
+	fail := func(status int, err error) { writeError(w, status, err) }
+
+	q := r.URL.Query()
+	smooth := 10
+	if s := strings.TrimSpace(q.Get("smooth")); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 2 || n > 120 {
+			fail(http.StatusBadRequest, fmt.Errorf("smooth must be 2-120 minutes"))
+			return
+		}
+		smooth = n
+	}
+	outZone, err := requestZone(q.Get("tz"), gymdata.Tallinn())
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+	pf, err := requestPointFormat(outZone, q.Get("timestamps"), q.Get("points"))
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+
+	cfg := requestConfig(r)
+	tallinn := gymdata.Tallinn()
+	var from, to time.Time
+	var files []string
+	if q.Get("from") != "" || q.Get("to") != "" {
+		f, err1 := time.ParseInLocation("2006-01-02", strings.TrimSpace(q.Get("from")), tallinn)
+		t, err2 := time.ParseInLocation("2006-01-02", strings.TrimSpace(q.Get("to")), tallinn)
+		if err1 != nil || err2 != nil || f.After(t) {
+			fail(http.StatusBadRequest, withKind(ErrBadRange, errors.New("from and to must be YYYY-MM-DD, from not after to")))
+			return
+		}
+		from, to = f, t.AddDate(0, 0, 1)
+		// The day before is read too, so the first readings have history.
+		files, err = gymdata.InRange(cfg.csvDir(), f.AddDate(0, 0, -1).Format("2006-01-02"), t.Format("2006-01-02"))
+	} else {
+		hours := 24
+		if h := strings.TrimSpace(q.Get("hours")); h != "" {
+			n, err := strconv.Atoi(h)
+			if err != nil || n < 1 || n > maxRecentHours {
+				fail(http.StatusBadRequest, fmt.Errorf("hours must be 1-%d", maxRecentHours))
+				return
+			}
+			hours = n
+		}
+		to = time.Now().Truncate(2 * time.Minute)
+		from = to.Add(-time.Duration(hours) * time.Hour)
+		files, err = recentFiles(cfg.csvDir(), from.Add(-rateSpan*time.Second), to)
+	}
+	if err != nil {
+		files = nil // no CSVs at all: an empty chart
+	}
+
+	list, _, err := gymdata.Load(cfg.format(), files, nil)
+	if err != nil {
+		fail(http.StatusInternalServerError, withKind(ErrParse, fmt.Errorf("Failed to convert CSV files: %v", err)))
+		return
+	}
+	out := list[:0]
+	for _, s := range list {
+		s.Key.Metric = rateMetric
+		s.Flagged = nil
+		s.Points = rateSeries(s.Points, smooth)
+		kept := s.Points[:0]
+		for _, p := range s.Points {
+			if p.At >= from.Unix() && p.At < to.Unix() {
+				kept = append(kept, p)
+			}
+		}
+		if s.Points = kept; len(kept) > 0 {
+			out = append(out, s)
+		}
+	}
+	bucketMinutes := gymdata.BucketMinutes(from, to)
+	gymdata.Bucket(out, bucketMinutes, outZone)
+
+	writeChartResponse(w, r, GenerateResponse{
+		Success: true,
+		Message: "People per 10 minutes",
+		Output: fmt.Sprintf("Rate of change from %d files, smoothed over %d min\nFound %d locations with data (bucket: %d min)",
+			len(files), smooth, len(out), bucketMinutes),
+	}, out, pf)
+}
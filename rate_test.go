@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"gym/internal/gymdata"
+)
+
+func TestRateSeries(t *testing.T) {
+	// One person more every poll for an hour (5 per 10 minutes), then a
+	// 40-minute outage and a flat 80.
+	var points []gymdata.Point
+	for i := int64(0); i <= 30; i++ {
+		points = append(points, gymdata.Point{At: i * 120, Y: float64(i)})
+	}
+	for i := int64(0); i <= 10; i++ {
+		points = append(points, gymdata.Point{At: 6000 + i*120, Y: 80})
+	}
+
+	got := rateSeries(points, 10)
+	byAt := map[int64]float64{}
+	for _, p := range got {
+		byAt[p.At] = p.Y
+	}
+	for _, at := range []int64{1800, 3000, 3600} {
+		if v, ok := byAt[at]; !ok || v != 5 {
+			t.Errorf("rate at %ds = %v (present %v), want 5", at, v, ok)
+		}
+	}
+	for _, at := range []int64{0, 240, 6000, 6240} {
+		if _, ok := byAt[at]; ok {
+			t.Errorf("rate at %ds present without 5 minutes of history", at)
+		}
+	}
+	if v, ok := byAt[6000+10*120]; !ok || v != 0 {
+		t.Errorf("rate after the outage = %v (present %v), want 0, not a jump", v, ok)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// maxRecentHours bounds /api/recent to a week, i.e. at most eight daily files.
+const maxRecentHours = 168
+
+var (
+	recentCacheMu sync.Mutex
+	recentCache   = map[string]recentResult{}
+)
+
+// recentResult is a cached /api/recent build: the trimmed, bucketed series
+// and the window they were cut to.
+type recentResult struct {
+	list     []*gymdata.Series
+	rows     *gymdata.RowCounts
+	outliers *OutlierReport
+	from, to time.Time
+	built    time.Time
+	bucket   int // minutes
+}
+
+// recentFiles returns the daily files that can hold readings from [from, to]:
+// those dated from's Tallinn day through to's, so a 24-hour window reads one
+// or two files however many days are on disk.
+func recentFiles(dir string, from, to time.Time) ([]string, error) {
+	tallinn := gymdata.Tallinn()
+	files, err := gymdata.InRange(dir, from.In(tallinn).Format("2006-01-02"), to.In(tallinn).Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// recentHandler serves the last N hours across every gym, the dashboard's
+// landing view. Only the newest files are read, and the build is cached until
+// a file changes or the window moves on by a collection interval.
+//
+//	GET /api/recent[?hours=24][&maxPoints=N][&metrics=a,b][&tz=ZONE][&outliers=off|drop|clamp][&areas=split|club][&total=all|A,B][&fill=none|null|previous|linear][&values=count|share]
+func recentHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+		return
+	}
+	fail := func(status int, err error) { writeError(w, status, err) }
+
+	q := r.URL.Query()
+	hours := 24
+	if h := strings.TrimSpace(q.Get("hours")); h != "" {
+		n, err := strconv.Atoi(h)
+		if err != nil || n < 1 || n > maxRecentHours {
+			fail(http.StatusBadRequest, fmt.Errorf("hours must be 1-%d", maxRecentHours))
+			return
+		}
+		hours = n
+	}
+	var metrics []string
+	if m := q.Get("metrics"); m != "" {
+		metrics = strings.Split(m, ",")
+	}
+	metrics = gymdata.NormalizeMetrics(metrics)
+	outZone, err := requestZone(q.Get("tz"), gymdata.Tallinn())
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+	pf, err := requestPointFormat(outZone, q.Get("timestamps"), q.Get("points"))
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+	cfg := requestConfig(r)
+	mode, err := requestOutlierMode(cfg, q.Get("outliers"))
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+	rollUp, err := requestAreas(q.Get("areas"))
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+	includeClosed, err := requestClosed(q.Get("closed"))
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+	maxPoints, err := requestMaxPoints(q.Get("maxPoints"))
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+	if _, _, err := requestFill(q.Get("fill")); err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+	if _, err := requestValues(q.Get("values")); err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+	weather, err := requestWeather(q.Get("weather"))
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+
+	load := loadMetrics(cfg, metrics)
+	noteRecentView(cfg, recentView{hours: hours, metrics: load, tz: q.Get("tz"), mode: mode, maxPoints: maxPoints})
+	cached, files, ok, err := buildRecent(r.Context(), cfg, hours, load, q.Get("tz"), outZone, mode, maxPoints)
+	if err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	bucketMinutes := cached.bucket
+
+	output := fmt.Sprintf("Last %d hours from %d files\nFound %d locations with data (bucket: %d min)",
+		hours, len(files), len(cached.list), bucketMinutes)
+	meta := chartMeta(files, cached.rows, cached.list, bucketMinutes, cached.built, ok, outZone)
+	meta.From, meta.To = cached.from.In(outZone).Format(time.RFC3339), cached.to.In(outZone).Format(time.RFC3339)
+	list := withFill(withDerived(withTotal(withShare(withAreas(withoutClosed(cached.list, chartClosed(cfg, includeClosed)), rollUp, bucketMinutes), q.Get("values"), bucketMinutes), q.Get("total"), bucketMinutes), cfg, metrics, bucketMinutes), q.Get("fill"), bucketMinutes)
+	writeChartResponse(w, r, GenerateResponse{
+		Success:     true,
+		Message:     fmt.Sprintf("Last %d hours", hours),
+		Output:      output,
+		Annotations: annotationsBetween(cfg, cached.from, cached.to, outZone),
+		Preferences: prefsFor(r, cfg),
+		Rows:        cached.rows,
+		Outliers:    cached.outliers,
+		Meta:        meta,
+		Weather:     chartWeather(cfg, weather, list, bucketMinutes, pf),
+	}, list, pf)
+}
+
+// buildRecent returns the last hours across every gym, trimmed, filtered
+// and bucketed, the files read, and whether the build came from the cache,
+// where it stays until a file changes or the window moves on by a collection
+// interval.
+func buildRecent(ctx context.Context, cfg *Config, hours int, metrics []string, tz string, outZone *time.Location, mode outlierMode, maxPoints int) (recentResult, []string, bool, error) {
+	// Anchoring the window to the collection grid lets requests within the
+	// same two minutes share one build.
+	to := time.Now().Truncate(2 * time.Minute)
+	from := to.Add(-time.Duration(hours) * time.Hour)
+	files, err := recentFiles(cfg.csvDir(), from, to)
+	if err != nil {
+		files = nil // no CSVs at all: an empty chart, like an empty range
+	}
+	var maxMtime int64
+	for _, f := range files {
+		if info, err := gymdata.Stat(f); err == nil && info.ModTime.Unix() > maxMtime {
+			maxMtime = info.ModTime.Unix()
+		}
+	}
+	key := cfg.DataDir + "|" + strconv.Itoa(hours) + "|" + strings.Join(metrics, ",") + "|" + tz + "|" +
+		strconv.FormatInt(to.Unix(), 10) + "|" + strconv.FormatInt(maxMtime, 10) + "|" + mode.String() + "|" + strconv.Itoa(maxPoints)
+	bucketMinutes := bucketFor(from, to, maxPoints)
+
+	recentCacheMu.Lock()
+	defer recentCacheMu.Unlock()
+	cached, ok := recentCache[key]
+	countCache("recent", ok)
+	if !ok {
+		// The headcount comes from the latest window when it holds these
+		// files, parsed as they were written.
+		var list []*gymdata.Series
+		var rows *gymdata.RowCounts
+		held := false
+		if len(metrics) == 1 && metrics[0] == gymdata.DefaultMetric {
+			list, rows, held = latestLoad(cfg, files)
+		}
+		if !held {
+			if list, rows, err = traceLoad(ctx, cfg, files, metrics, nil); err != nil {
+				return recentResult{}, nil, false, withKind(ErrParse, fmt.Errorf("Failed to convert CSV files: %v", err))
+			}
+		}
+		list = gymdata.Trim(list, from.Unix())
+		list, report := filterOutliers(list, mode, cfg, outZone)
+		gymdata.Bucket(list, bucketMinutes, outZone)
+		// Entries for an earlier window are never hit again.
+		for k, v := range recentCache {
+			if !v.to.Equal(to) {
+				delete(recentCache, k)
+			}
+		}
+		cached = recentResult{list: list, rows: rows, outliers: report, from: from, to: to, built: time.Now(), bucket: bucketMinutes}
+		recentCache[key] = cached
+		tallinn := gymdata.Tallinn()
+		verifyShadow(cfg, "recent", from.In(tallinn).Format("2006-01-02"), to.In(tallinn).Format("2006-01-02"), metrics, list, func(shadow []*gymdata.Series) []*gymdata.Series {
+			shadow, _ = filterOutliers(gymdata.Trim(shadow, from.Unix()), mode, cfg, outZone)
+			gymdata.Bucket(shadow, bucketMinutes, outZone)
+			return shadow
+		})
+	}
+	return cached, files, ok, nil
+}
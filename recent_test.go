@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRecentHandler(t *testing.T) {
+	tallinn := loadTallinn(t)
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// A reading an hour ago, one 47 hours ago in its own day's file (always
+	// two days back, outside the files the last 24 hours need), and an old
+	// day that must not be read at all.
+	now := time.Now().In(tallinn)
+	row := func(at time.Time, n int) string {
+		return at.Format("2006-01-02 15:04:05") + ",,1,Hipodroom," + strconv.Itoa(n) + ",success,\"{}\"\n"
+	}
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	write := func(at time.Time, rows string) {
+		writeCSV(t, dir, "gym-stats-"+at.Format("20060102")+".csv", header+rows)
+	}
+	hourAgo, longAgo := now.Add(-time.Hour), now.Add(-47*time.Hour)
+	write(hourAgo, row(hourAgo.In(time.UTC), 1))
+	write(longAgo, row(longAgo.In(time.UTC), 2))
+	writeCSV(t, dir, "gym-stats-20200101.csv", "not,a,csv\n\"")
+
+	get := func(query string) (int, GenerateResponse) {
+		w := httptest.NewRecorder()
+		recentHandler(w, httptest.NewRequest("GET", "/api/recent"+query, nil))
+		var resp GenerateResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	code, resp := get("")
+	if code != 200 || !resp.Success {
+		t.Fatalf("default: code %d, %+v", code, resp)
+	}
+	if len(resp.Datasets) != 1 || len(resp.Datasets[0].Data) != 1 || resp.Datasets[0].Data[0].Y != 1 {
+		t.Errorf("24h = %+v, want only the reading an hour ago", resp.Datasets)
+	}
+	if m := resp.Meta; m == nil || len(m.Files) != 1 || m.Rows != 1 || m.Cached || m.Latest == "" {
+		t.Errorf("meta = %+v, want one uncached file of one row", resp.Meta)
+	}
+	if _, resp := get("?hours=48"); len(resp.Datasets) != 1 || len(resp.Datasets[0].Data) != 2 {
+		t.Errorf("48h = %+v, want both readings", resp.Datasets)
+	}
+	if _, again := get(""); again.Meta == nil || !again.Meta.Cached || again.Meta.GeneratedAt != resp.Meta.GeneratedAt {
+		t.Errorf("repeat meta = %+v, want the first build's, from cache", again.Meta)
+	}
+	if _, resp := get("?hours=168&maxPoints=50"); resp.Meta == nil || resp.Meta.BucketMinutes != 360 {
+		t.Errorf("a week in 50 points: meta = %+v, want 360-minute buckets", resp.Meta)
+	}
+	for _, bad := range []string{"?hours=0", "?hours=169", "?hours=x", "?tz=Nowhere/Else", "?maxPoints=5", "?maxPoints=x"} {
+		if code, _ := get(bad); code != 400 {
+			t.Errorf("%s: code %d, want 400", bad, code)
+		}
+	}
+}
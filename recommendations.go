@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+type RecommendationSlot struct {
+	Start      string  `json:"start"`
+	End        string  `json:"end"`
+	Predicted  float64 `json:"predicted"`
+	Samples    int     `json:"samples"`
+	Confidence float64 `json:"confidence"`
+}
+
+type RecommendationResponse struct {
+	Location string               `json:"location"`
+	Day      string               `json:"day"`
+	Weekday  string               `json:"weekday"`
+	Window   int                  `json:"window"`
+	From     string               `json:"from"`
+	To       string               `json:"to"`
+	Slots    []RecommendationSlot `json:"slots"`
+}
+
+// slotConfidence maps a sample count to 0..1. 30 samples is one hour of
+// 2-minute readings, so a slot seen on a single day scores 0.5 and it takes
+// several weeks of history to approach 1.
+func slotConfidence(samples int) float64 {
+	if samples <= 0 {
+		return 0
+	}
+	c := float64(samples) / float64(samples+30)
+	return math.Round(c*100) / 100
+}
+
+// rankQuietWindows scores every run of `window` consecutive hours inside
+// [earliest, latest) in one weekday row of the busyness grid and returns up to
+// top non-overlapping runs, quietest first. Like the dashboard's "best time to
+// go", hours below 25% of the day's busiest hour count as closed, so a window
+// never covers dead-of-night hours. A window's samples are those of its
+// thinnest hour.
+func rankQuietWindows(row [24]busyCell, window, top, earliest, latest int) []RecommendationSlot {
+	var avg [24]float64
+	peak := 0.0
+	for h := 0; h < 24; h++ {
+		if row[h].count > 0 {
+			avg[h] = row[h].sum / float64(row[h].count)
+			if avg[h] > peak {
+				peak = avg[h]
+			}
+		}
+	}
+	if peak <= 0 {
+		return []RecommendationSlot{}
+	}
+	threshold := peak * 0.25
+
+	type candidate struct {
+		start     int
+		predicted float64
+		samples   int
+	}
+	var cands []candidate
+	for start := earliest; start+window <= latest; start++ {
+		sum := 0.0
+		samples := -1
+		ok := true
+		for h := start; h < start+window; h++ {
+			if row[h].count == 0 || avg[h] < threshold {
+				ok = false
+				break
+			}
+			sum += avg[h]
+			if samples == -1 || row[h].count < samples {
+				samples = row[h].count
+			}
+		}
+		if ok {
+			cands = append(cands, candidate{start: start, predicted: sum / float64(window), samples: samples})
+		}
+	}
+	sort.SliceStable(cands, func(i, j int) bool {
+		if cands[i].predicted != cands[j].predicted {
+			return cands[i].predicted < cands[j].predicted
+		}
+		return cands[i].samples > cands[j].samples
+	})
+
+	var taken [24]bool
+	slots := []RecommendationSlot{}
+	for _, c := range cands {
+		if len(slots) >= top {
+			break
+		}
+		free := true
+		for h := c.start; h < c.start+window; h++ {
+			if taken[h] {
+				free = false
+				break
+			}
+		}
+		if !free {
+			continue
+		}
+		for h := c.start; h < c.start+window; h++ {
+			taken[h] = true
+		}
+		slots = append(slots, RecommendationSlot{
+			Start:      fmt.Sprintf("%02d:00", c.start),
+			End:        fmt.Sprintf("%02d:00", (c.start+window)%24),
+			Predicted:  math.Round(c.predicted*10) / 10,
+			Samples:    c.samples,
+			Confidence: slotConfidence(c.samples),
+		})
+	}
+	return slots
+}
+
+func weekdayLabel(day time.Time, lang string) string {
+	if lang == "" {
+		return day.Weekday().String()
+	}
+	return localWeekday(day, lang)
+}
+
+var errUnknownLocation = errors.New("unknown location")
+
+// recommendationQuery is shared by the HTTP endpoint and the Telegram bot.
+// An empty Location means every location.
+type recommendationQuery struct {
+	Location string
+	Day      time.Time
+	Top      int
+	Window   int
+	Earliest int // first hour a window may start
+	Latest   int // hour by which a window must end (exclusive)
+	From, To *time.Time
+	Lang     string // weekday label language; empty is English
+}
+
+// queryRecommendations builds the busyness grid for the query's history range
+// and ranks the target weekday's windows per location, sorted by name.
+func queryRecommendations(cfg *Config, tallinn *time.Location, q recommendationQuery) ([]RecommendationResponse, error) {
+	acc, _, span, err := collectBusyness(cfg, tallinn, q.From, q.To, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for n := range acc {
+		if q.Location == "" || strings.EqualFold(n, q.Location) {
+			names = append(names, n)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%w %q", errUnknownLocation, q.Location)
+	}
+	sort.Strings(names)
+
+	effFrom, effTo := "", ""
+	if !span[0].IsZero() {
+		effFrom = span[0].Format("2006-01-02")
+		effTo = span[1].Format("2006-01-02")
+	}
+	if q.From != nil {
+		effFrom = q.From.Format("2006-01-02")
+	}
+	if q.To != nil {
+		effTo = q.To.AddDate(0, 0, -1).Format("2006-01-02")
+	}
+
+	dayIdx := (int(q.Day.Weekday()) + 6) % 7 // Mon=0 ... Sun=6
+	out := make([]RecommendationResponse, 0, len(names))
+	for _, name := range names {
+		out = append(out, RecommendationResponse{
+			Location: name,
+			Day:      q.Day.Format("2006-01-02"),
+			Weekday:  weekdayLabel(q.Day, q.Lang),
+			Window:   q.Window,
+			From:     effFrom,
+			To:       effTo,
+			Slots:    rankQuietWindows(acc[name][dayIdx], q.Window, q.Top, q.Earliest, q.Latest),
+		})
+	}
+	return out, nil
+}
+
+// parseHourRange parses "18-20" into [18, 20); "18" alone means 18-24.
+func parseHourRange(s string) (int, int, bool) {
+	a, b, hasEnd := strings.Cut(s, "-")
+	earliest, err := strconv.Atoi(strings.TrimSpace(a))
+	if err != nil || earliest < 0 || earliest > 23 {
+		return 0, 0, false
+	}
+	latest := 24
+	if hasEnd {
+		latest, err = strconv.Atoi(strings.TrimSpace(b))
+		if err != nil || latest <= earliest || latest > 24 {
+			return 0, 0, false
+		}
+	}
+	return earliest, latest, true
+}
+
+// recommendationsHandler ranks the hour slots of a target day by the
+// historical weekday × hour average and returns the quietest windows.
+//
+//	GET /api/recommendations?location=NAME[&day=YYYY-MM-DD][&top=N][&window=H][&between=HH-HH][&from=YYYY-MM-DD&to=YYYY-MM-DD][&lang=xx]
+func recommendationsHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	tallinn := gymdata.Tallinn()
+
+	q := r.URL.Query()
+	location := strings.TrimSpace(q.Get("location"))
+	if location == "" {
+		writeError(w, http.StatusBadRequest, fieldErr("location", nil, errors.New("location is required")))
+		return
+	}
+
+	day := time.Now().In(tallinn)
+	if s := strings.TrimSpace(q.Get("day")); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, tallinn)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fieldErr("day", ErrBadRange, errors.New("invalid day, want YYYY-MM-DD")))
+			return
+		}
+		day = t
+	}
+
+	top := 3
+	if n, err := strconv.Atoi(q.Get("top")); err == nil && n > 0 {
+		top = min(n, 24)
+	}
+	window := 1
+	if n, err := strconv.Atoi(q.Get("window")); err == nil && n > 0 {
+		window = min(n, 6)
+	}
+	earliest, latest := 0, 24
+	if s := strings.TrimSpace(q.Get("between")); s != "" {
+		var ok bool
+		if earliest, latest, ok = parseHourRange(s); !ok {
+			writeError(w, http.StatusBadRequest, errors.New("invalid between, want HH-HH"))
+			return
+		}
+	}
+
+	var fromPtr, toPtr *time.Time
+	if t, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(q.Get("from")), tallinn); err == nil {
+		fromPtr = &t
+	}
+	if t, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(q.Get("to")), tallinn); err == nil {
+		end := t.AddDate(0, 0, 1) // exclusive upper bound: include the whole 'to' day
+		toPtr = &end
+	}
+
+	results, err := queryRecommendations(requestConfig(r), tallinn, recommendationQuery{
+		Location: location,
+		Day:      day,
+		Top:      top,
+		Window:   window,
+		Earliest: earliest,
+		Latest:   latest,
+		From:     fromPtr,
+		To:       toPtr,
+		Lang:     q.Get("lang"),
+	})
+	if errors.Is(err, errUnknownLocation) {
+		writeError(w, http.StatusNotFound, withKind(ErrNoData, fmt.Errorf("no data for location %q", location)))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(results[0])
+}
@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func gridRow(avgs map[int]float64, samples int) [24]busyCell {
+	var row [24]busyCell
+	for h, v := range avgs {
+		row[h] = busyCell{sum: v * float64(samples), count: samples}
+	}
+	return row
+}
+
+func TestParseHourRange(t *testing.T) {
+	for in, want := range map[string][2]int{"18-20": {18, 20}, "7": {7, 24}, " 6 - 9 ": {6, 9}, "0-24": {0, 24}} {
+		a, b, ok := parseHourRange(in)
+		if !ok || a != want[0] || b != want[1] {
+			t.Errorf("parseHourRange(%q) = %d, %d, %v; want %v", in, a, b, ok, want)
+		}
+	}
+	for _, in := range []string{"20-18", "25", "x-2", "5-5", ""} {
+		if _, _, ok := parseHourRange(in); ok {
+			t.Errorf("parseHourRange(%q): expected failure", in)
+		}
+	}
+}
+
+func TestRankQuietWindows(t *testing.T) {
+	t.Run("quietest active hours first, closed hours skipped", func(t *testing.T) {
+		// 06:00 is below 25% of the 40-person peak, so it counts as closed.
+		row := gridRow(map[int]float64{6: 2, 10: 12, 11: 15, 17: 40, 18: 35, 21: 11}, 30)
+		got := rankQuietWindows(row, 1, 3, 0, 24)
+		if len(got) != 3 {
+			t.Fatalf("slots = %d, want 3", len(got))
+		}
+		want := []string{"21:00", "10:00", "11:00"}
+		for i, w := range want {
+			if got[i].Start != w {
+				t.Errorf("slot %d start = %s, want %s", i, got[i].Start, w)
+			}
+		}
+		if got[0].Confidence != 0.5 {
+			t.Errorf("confidence = %v, want 0.5 for 30 samples", got[0].Confidence)
+		}
+	})
+
+	t.Run("multi-hour windows do not overlap", func(t *testing.T) {
+		row := gridRow(map[int]float64{10: 10, 11: 10, 12: 11, 13: 30, 14: 30}, 10)
+		got := rankQuietWindows(row, 2, 3, 0, 24)
+		if len(got) != 2 {
+			t.Fatalf("slots = %d, want 2: %+v", len(got), got)
+		}
+		if got[0].Start != "10:00" || got[0].End != "12:00" {
+			t.Errorf("first window = %s-%s, want 10:00-12:00", got[0].Start, got[0].End)
+		}
+		if got[1].Start != "12:00" && got[1].Start != "13:00" {
+			t.Errorf("second window starts %s, overlaps the first", got[1].Start)
+		}
+	})
+
+	t.Run("hour bounds limit the candidates", func(t *testing.T) {
+		row := gridRow(map[int]float64{10: 5, 17: 30, 18: 20, 19: 25}, 30)
+		got := rankQuietWindows(row, 1, 3, 18, 20)
+		if len(got) != 2 || got[0].Start != "18:00" || got[1].Start != "19:00" {
+			t.Errorf("got %+v, want 18:00 then 19:00", got)
+		}
+	})
+
+	t.Run("empty row returns no slots", func(t *testing.T) {
+		got := rankQuietWindows([24]busyCell{}, 1, 3, 0, 24)
+		if got == nil || len(got) != 0 {
+			t.Errorf("got %v, want empty slice", got)
+		}
+	})
+}
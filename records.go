@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// Records are worked out from a summary of each gym's days, kept per daily
+// file in RECORDS_FILE. Only files whose size or mtime changed since are
+// read again, so keeping up with the collector costs one file a write.
+const (
+	// recordDaySamples is a whole day of readings at the collector's
+	// 2-minute interval; a day is full with fullDayShare of them.
+	recordDaySamples = 24 * 60 / 2
+	fullDayShare     = 0.9
+)
+
+// recordWindows are the periods records are kept over, ending today; 0 is
+// all time.
+var recordWindows = []struct {
+	name string
+	days int
+}{{"all", 0}, {"365d", 365}, {"30d", 30}, {"7d", 7}}
+
+// daySummary is one gym's readings on one Tallinn day in one file.
+type daySummary struct {
+	Peak    float64 `json:"peak"`
+	PeakAt  int64   `json:"peakAt"` // unix seconds
+	Sum     float64 `json:"sum"`
+	Samples int     `json:"samples"`
+}
+
+func (d *daySummary) add(o *daySummary) {
+	if d.Samples == 0 || o.Peak > d.Peak || o.Peak == d.Peak && o.PeakAt < d.PeakAt {
+		d.Peak, d.PeakAt = o.Peak, o.PeakAt
+	}
+	d.Sum += o.Sum
+	d.Samples += o.Samples
+}
+
+// recordFile is what one daily file contributed, by day then gym, as of
+// its Size and ModTime.
+type recordFile struct {
+	Size    int64                             `json:"size"`
+	ModTime int64                             `json:"modTime"` // unix nanoseconds
+	Days    map[string]map[string]*daySummary `json:"days"`
+}
+
+type PeakRecord struct {
+	Count float64 `json:"count"`
+	At    string  `json:"at"`
+}
+
+type DayRecord struct {
+	Date    string  `json:"date"`
+	Average float64 `json:"average"`
+	Peak    float64 `json:"peak"`
+}
+
+// RecordSet is one gym's records over a window. Busiest and quietest are
+// by average count, over full days that have ended.
+type RecordSet struct {
+	Peak        *PeakRecord `json:"peak,omitempty"`
+	BusiestDay  *DayRecord  `json:"busiestDay,omitempty"`
+	QuietestDay *DayRecord  `json:"quietestDay,omitempty"`
+	Days        int         `json:"days"`     // with readings
+	FullDays    int         `json:"fullDays"` // ended, and full
+}
+
+type LocationRecords struct {
+	Name string `json:"name"`
+	// Today is today's peak so far; NewPeak says it is the highest ever.
+	Today   *PeakRecord          `json:"today,omitempty"`
+	NewPeak bool                 `json:"newPeak,omitempty"`
+	Windows map[string]RecordSet `json:"windows"`
+}
+
+type RecordsResponse struct {
+	Windows     []string          `json:"windows"`
+	Locations   []LocationRecords `json:"locations"`
+	GeneratedAt string            `json:"generatedAt"`
+}
+
+var recordsMu sync.Mutex
+
+// readRecords loads the store; a missing file is an empty one.
+func readRecords(cfg *Config) (map[string]*recordFile, error) {
+	data, err := os.ReadFile(cfg.path(cfg.RecordsFile))
+	if os.IsNotExist(err) {
+		return map[string]*recordFile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	store := map[string]*recordFile{}
+	if err := json.Unmarshal(data, &store); err != nil {
+		return nil, fmt.Errorf("%s: %v", cfg.RecordsFile, err)
+	}
+	return store, nil
+}
+
+// writeRecords saves the store, unindented: it holds every gym's records.
+func writeRecords(cfg *Config, store map[string]*recordFile) error {
+	data, err := json.Marshal(store)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(cfg.path(cfg.RecordsFile), append(data, '\n'))
+}
+
+// summarizeFile reads one daily file's headcounts into day summaries.
+func summarizeFile(cfg *Config, file string) (map[string]map[string]*daySummary, error) {
+	list, _, err := gymdata.Load(cfg.format(), []string{file}, nil)
+	if err != nil {
+		return nil, err
+	}
+	tallinn := gymdata.Tallinn()
+	days := map[string]map[string]*daySummary{}
+	for _, s := range list {
+		for _, p := range s.Points {
+			day := time.Unix(p.At, 0).In(tallinn).Format("2006-01-02")
+			if days[day] == nil {
+				days[day] = map[string]*daySummary{}
+			}
+			if days[day][s.Key.Location] == nil {
+				days[day][s.Key.Location] = &daySummary{}
+			}
+			days[day][s.Key.Location].add(&daySummary{Peak: p.Y, PeakAt: p.At, Sum: p.Y, Samples: 1})
+		}
+	}
+	return days, nil
+}
+
+// refreshRecords brings the store up to date with the daily files: new and
+// changed files are summarized, and those gone (or gzipped, under a new
+// name) dropped. It returns the store.
+func refreshRecords(cfg *Config) (map[string]*recordFile, error) {
+	recordsMu.Lock()
+	defer recordsMu.Unlock()
+	store, err := readRecords(cfg)
+	if err != nil {
+		return nil, err
+	}
+	files, err := gymdata.ListFiles(cfg.csvDir())
+	if err != nil {
+		return nil, err
+	}
+	changed := false
+	present := map[string]bool{}
+	for _, f := range files {
+		name := filepath.Base(f)
+		present[name] = true
+		info, err := gymdata.Stat(f)
+		if err != nil {
+			continue
+		}
+		if old := store[name]; old != nil && old.Size == info.Size && old.ModTime == info.ModTime.UnixNano() {
+			continue
+		}
+		days, err := summarizeFile(cfg, f)
+		if err != nil {
+			log.Printf("Records: %s: %v", name, err)
+			continue
+		}
+		store[name] = &recordFile{Size: info.Size, ModTime: info.ModTime.UnixNano(), Days: days}
+		changed = true
+	}
+	for name := range store {
+		if !present[name] {
+			delete(store, name)
+			changed = true
+		}
+	}
+	if changed {
+		if err := writeRecords(cfg, store); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// buildRecords works each gym's records out of the store as of now, times
+// given in loc.
+func buildRecords(store map[string]*recordFile, now time.Time, loc *time.Location) RecordsResponse {
+	// A day's rows can sit in two files (around midnight), so add them up.
+	byGym := map[string]map[string]*daySummary{}
+	for _, f := range store {
+		for day, gyms := range f.Days {
+			for gym, d := range gyms {
+				if byGym[gym] == nil {
+					byGym[gym] = map[string]*daySummary{}
+				}
+				if byGym[gym][day] == nil {
+					byGym[gym][day] = &daySummary{}
+				}
+				byGym[gym][day].add(d)
+			}
+		}
+	}
+	tallinn := gymdata.Tallinn()
+	today := now.In(tallinn).Format("2006-01-02")
+	out := RecordsResponse{Locations: []LocationRecords{}, GeneratedAt: now.In(loc).Format(time.RFC3339)}
+	for _, w := range recordWindows {
+		out.Windows = append(out.Windows, w.name)
+	}
+	peak := func(d *daySummary) *PeakRecord {
+		return &PeakRecord{Count: d.Peak, At: time.Unix(d.PeakAt, 0).In(loc).Format(time.RFC3339)}
+	}
+	gyms := make([]string, 0, len(byGym))
+	for gym := range byGym {
+		gyms = append(gyms, gym)
+	}
+	sort.Strings(gyms)
+	for _, gym := range gyms {
+		days := byGym[gym]
+		dates := make([]string, 0, len(days))
+		for day := range days {
+			dates = append(dates, day)
+		}
+		sort.Strings(dates)
+		lr := LocationRecords{Name: gym, Windows: map[string]RecordSet{}}
+		if d := days[today]; d != nil {
+			lr.Today = peak(d)
+		}
+		for _, w := range recordWindows {
+			first := ""
+			if w.days > 0 {
+				y, m, d := now.In(tallinn).Date()
+				first = time.Date(y, m, d-(w.days-1), 0, 0, 0, 0, tallinn).Format("2006-01-02")
+			}
+			var rs RecordSet
+			var top *daySummary
+			for _, day := range dates {
+				if day < first || day > today {
+					continue
+				}
+				d := days[day]
+				rs.Days++
+				if top == nil || d.Peak > top.Peak {
+					top = d
+				}
+				if day == today || float64(d.Samples) < fullDayShare*recordDaySamples {
+					continue
+				}
+				rs.FullDays++
+				rec := &DayRecord{Date: day, Average: math.Round(d.Sum/float64(d.Samples)*10) / 10, Peak: d.Peak}
+				if rs.BusiestDay == nil || rec.Average > rs.BusiestDay.Average {
+					rs.BusiestDay = rec
+				}
+				if rs.QuietestDay == nil || rec.Average < rs.QuietestDay.Average {
+					rs.QuietestDay = rec
+				}
+			}
+			if top != nil {
+				rs.Peak = peak(top)
+				if w.days == 0 && days[today] == top && rs.Days > 1 {
+					lr.NewPeak = true
+				}
+			}
+			lr.Windows[w.name] = rs
+		}
+		out.Locations = append(out.Locations, lr)
+	}
+	return out
+}
+
+// recordsHandler reports each gym's records: its highest count, busiest
+// and quietest full day, all time and over the last 365, 30 and 7 days,
+// and today's peak so far.
+//
+//	GET /api/records[?location=NAME][&tz=ZONE]
+func recordsHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	loc, err := requestZone(q.Get("tz"), gymdata.Tallinn())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fieldErr("tz", nil, err))
+		return
+	}
+	store, err := refreshRecords(requestConfig(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	resp := buildRecords(store, time.Now(), loc)
+	if name := strings.TrimSpace(q.Get("location")); name != "" {
+		var only []LocationRecords
+		for _, lr := range resp.Locations {
+			if strings.EqualFold(lr.Name, name) {
+				only = append(only, lr)
+			}
+		}
+		if only == nil {
+			writeError(w, http.StatusNotFound, withKind(ErrNoData, fmt.Errorf("no data for location %q", name)))
+			return
+		}
+		resp.Locations = only
+	}
+	json.NewEncoder(w).Encode(resp)
+}
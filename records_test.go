@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecords(t *testing.T) {
+	tallinn := loadTallinn(t)
+	dir := t.TempDir()
+	cfg := &Config{DataDir: dir, RecordsFile: "gym-records.json"}
+	now := time.Date(2025, 10, 10, 12, 0, 0, 0, tallinn)
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	// day writes n readings 2 minutes apart from Tallinn midnight, count
+	// each but one of peak at 10:00. Rows without a zone are UTC.
+	day := func(ago, n int, count, peak int) string {
+		start := time.Date(now.Year(), now.Month(), now.Day()-ago, 0, 0, 0, 0, tallinn)
+		var b strings.Builder
+		b.WriteString(header)
+		for i := range n {
+			at := start.Add(time.Duration(i) * 2 * time.Minute)
+			c := count
+			if at.Hour() == 10 && at.Minute() == 0 {
+				c = peak
+			}
+			fmt.Fprintf(&b, "%s,,1,Hipodroom,%d,success,{}\n", at.UTC().Format("2006-01-02 15:04:05"), c)
+		}
+		return writeCSV(t, dir, "gym-stats-"+start.Format("20060102")+".csv", b.String())
+	}
+	day(40, 720, 50, 60)  // busiest of all time, outside 30 days
+	day(3, 320, 100, 100) // the highest count, on a partial day
+	day(2, 720, 10, 50)
+	day(1, 720, 20, 30)
+	today := day(0, 301, 5, 5)
+
+	store, err := refreshRecords(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := buildRecords(store, now, tallinn)
+	if len(got.Locations) != 1 || got.Locations[0].Name != "Hipodroom" {
+		t.Fatalf("locations = %+v", got.Locations)
+	}
+	hip := got.Locations[0]
+	all, month, week := hip.Windows["all"], hip.Windows["30d"], hip.Windows["7d"]
+	if all.Peak == nil || all.Peak.Count != 100 || all.Days != 5 || all.FullDays != 3 {
+		t.Errorf("all = %+v", all)
+	}
+	if all.BusiestDay == nil || all.BusiestDay.Date != "2025-08-31" || month.BusiestDay == nil || month.BusiestDay.Date != "2025-10-09" {
+		t.Errorf("busiest: all %+v, 30d %+v", all.BusiestDay, month.BusiestDay)
+	}
+	if q := week.QuietestDay; q == nil || q.Date != "2025-10-08" || q.Average != 10.1 || q.Peak != 50 {
+		t.Errorf("quietest = %+v", q)
+	}
+	if hip.Today == nil || hip.Today.Count != 5 || hip.NewPeak || week.Days != 4 {
+		t.Errorf("today = %+v, new %v, 7d = %+v", hip.Today, hip.NewPeak, week)
+	}
+
+	// Only changed files are read again: a file rewritten with its old size
+	// and mtime keeps its summary; today's new peak is picked up.
+	partial := filepath.Join(dir, "gym-stats-20251007.csv")
+	info, _ := os.Stat(partial)
+	data, _ := os.ReadFile(partial)
+	os.WriteFile(partial, []byte(strings.ReplaceAll(string(data), ",100,", ",900,")), 0o644)
+	os.Chtimes(partial, info.ModTime(), info.ModTime())
+	f, _ := os.OpenFile(today, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString("2025-10-10 08:00:00,,1,Hipodroom,150,success,{}\n")
+	f.Close()
+	if store, err = refreshRecords(cfg); err != nil {
+		t.Fatal(err)
+	}
+	hip = buildRecords(store, now, tallinn).Locations[0]
+	if hip.Windows["all"].Peak.Count != 150 || !hip.NewPeak || hip.Today.Count != 150 {
+		t.Errorf("after new peak: %+v, today %+v", hip.Windows["all"].Peak, hip.Today)
+	}
+	if saved, err := readRecords(cfg); err != nil || len(saved) != 5 {
+		t.Errorf("saved store: %d files, %v", len(saved), err)
+	}
+}
+
+func TestRecordsHandler(t *testing.T) {
+	loadTallinn(t)
+	old := currentConfig()
+	defer setConfig(old)
+	dir := t.TempDir()
+	setConfig(&Config{DataDir: dir, RecordsFile: "gym-records.json"})
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	writeCSV(t, dir, "gym-stats-20251001.csv", header+"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,{}\n2025-10-01 10:00:00,EEST,2,Lasnamäe,7,success,{}\n")
+
+	get := func(query string) (int, RecordsResponse) {
+		w := httptest.NewRecorder()
+		recordsHandler(w, httptest.NewRequest("GET", "/api/records"+query, nil))
+		var resp RecordsResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+	if code, resp := get(""); code != 200 || len(resp.Locations) != 2 || len(resp.Windows) != 4 {
+		t.Errorf("all: code %d, %+v", code, resp)
+	}
+	code, resp := get("?location=hipodroom&tz=UTC")
+	if code != 200 || len(resp.Locations) != 1 || resp.Locations[0].Windows["all"].Peak.At != "2025-10-01T07:00:00Z" {
+		t.Errorf("one: code %d, %+v", code, resp)
+	}
+	for query, want := range map[string]int{"?location=Nowhere": 404, "?tz=Mars/Base": 400} {
+		if code, _ := get(query); code != want {
+			t.Errorf("%s: code %d, want %d", query, code, want)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// repairChange is what repair did, or would do, about one misdated file:
+// Rows of its rows belong to Target's day. Renamed is true if the file was
+// all Target's and simply took its name.
+type repairChange struct {
+	File, Target string
+	Rows         int
+	Renamed      bool
+}
+
+func (c repairChange) String() string {
+	if c.Renamed {
+		return fmt.Sprintf("%s: renamed to %s (all %d rows are that day's)", filepath.Base(c.File), filepath.Base(c.Target), c.Rows)
+	}
+	return fmt.Sprintf("%s: moved %d rows to %s", filepath.Base(c.File), c.Rows, filepath.Base(c.Target))
+}
+
+// rowDater returns a func giving a data row's Tallinn day (YYYYMMDD), under
+// the columns of header, a daily CSV's first line; ok is false for a row
+// it can't date. It returns nil if header lacks a timestamp column.
+func rowDater(cfg *Config, header []byte) func(line []byte) (day string, at time.Time, ok bool) {
+	reader := csv.NewReader(bytes.NewReader(header))
+	reader.FieldsPerRecord = -1
+	headers, err := reader.Read()
+	if err != nil {
+		return nil
+	}
+	tsIdx, tzIdx := -1, -1
+	for i, h := range gymdata.CanonicalHeaders(headers, cfg.CSVColumns) {
+		switch h {
+		case "timestamp":
+			tsIdx = i
+		case "timezone":
+			tzIdx = i
+		}
+	}
+	if tsIdx == -1 {
+		return nil
+	}
+	layout := cfg.CSVTimeLayout
+	if layout == "" {
+		layout = gymdata.DefaultTimeLayout
+	}
+	tallinn := gymdata.Tallinn()
+	return func(line []byte) (string, time.Time, bool) {
+		reader := csv.NewReader(bytes.NewReader(line))
+		reader.LazyQuotes = true
+		reader.FieldsPerRecord = -1
+		record, err := reader.Read()
+		if err != nil || len(record) <= max(tsIdx, tzIdx) {
+			return "", time.Time{}, false
+		}
+		tz := ""
+		if tzIdx != -1 {
+			tz = record[tzIdx]
+		}
+		at, ok := gymdata.LocalTime(record[tsIdx], tz, layout, tallinn)
+		if !ok {
+			return "", time.Time{}, false
+		}
+		return at.In(tallinn).Format("20060102"), at, true
+	}
+}
+
+// splitRows returns a daily CSV's header and data rows, each ending in a
+// newline; blank lines are dropped.
+func splitRows(data []byte) (header []byte, rows [][]byte) {
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if !bytes.HasSuffix(line, []byte("\n")) {
+			line = append(slices.Clip(line), '\n')
+		}
+		if header == nil {
+			header = line
+		} else {
+			rows = append(rows, line)
+		}
+	}
+	return header, rows
+}
+
+// mergeRows adds rows to existing, leaving out any already there, in time
+// order: the skew check would quarantine a reading appended after later
+// ones. Rows that can't be dated keep their place after the row before.
+func mergeRows(date func([]byte) (string, time.Time, bool), existing, rows [][]byte) [][]byte {
+	have := make(map[string]bool, len(existing))
+	for _, row := range existing {
+		have[string(row)] = true
+	}
+	type dated struct {
+		row []byte
+		at  time.Time
+	}
+	var all []dated
+	var last time.Time
+	add := func(row []byte) {
+		if _, at, ok := date(row); ok {
+			last = at
+		}
+		all = append(all, dated{row, last})
+	}
+	for _, row := range existing {
+		add(row)
+	}
+	last = time.Time{}
+	for _, row := range rows {
+		if !have[string(row)] {
+			have[string(row)] = true
+			add(row)
+		}
+	}
+	slices.SortStableFunc(all, func(a, b dated) int { return a.at.Compare(b.at) })
+	out := make([][]byte, len(all))
+	for i, d := range all {
+		out[i] = d.row
+	}
+	return out
+}
+
+// dayFile is where dir keeps day's rows: its plain file, else its gzipped
+// one, else a plain file yet to be made (exists false).
+func dayFile(dir, day string) (file string, exists bool) {
+	plain := filepath.Join(dir, "gym-stats-"+day+".csv")
+	for _, f := range []string{plain, plain + ".gz"} {
+		if _, err := os.Stat(f); err == nil {
+			return f, true
+		}
+	}
+	return plain, false
+}
+
+// readDaily reads a daily file, plain or gzipped, with its size on disk.
+func readDaily(file string) ([]byte, int64, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := gymdata.Open(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	return data, info.Size(), err
+}
+
+// writeNewFile makes file with data, failing if it already exists (the
+// collector got there first; the caller reads again).
+func writeNewFile(file string, data []byte) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		return errFileChanged
+	}
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// repairFile moves file's rows dated another Tallinn day than its name says
+// into that day's file, as when the collector wrote past midnight into
+// yesterday's, and returns what it moved. A file all of one other day whose
+// file doesn't exist is renamed to it; one left with no rows is removed.
+// Like mergeLocation, it reads again if the collector appended meanwhile;
+// rows already in a target are not added twice, so a repair cut short can
+// simply be run again.
+func repairFile(cfg *Config, file string, dryRun bool) ([]repairChange, error) {
+	base := gymdata.BaseName(file)
+	if len(base) < 18 {
+		return nil, nil
+	}
+	day, dir := base[10:18], filepath.Dir(file)
+	for range 3 {
+		data, size, err := readDaily(file)
+		if err != nil {
+			return nil, err
+		}
+		header, rows := splitRows(data)
+		date := rowDater(cfg, header)
+		if date == nil {
+			return nil, nil
+		}
+		var keep [][]byte
+		moved := map[string][][]byte{}
+		for _, row := range rows {
+			if d, _, ok := date(row); ok && d != day {
+				moved[d] = append(moved[d], row)
+			} else {
+				keep = append(keep, row)
+			}
+		}
+		if len(moved) == 0 {
+			return nil, nil
+		}
+		days := make([]string, 0, len(moved))
+		for d := range moved {
+			days = append(days, d)
+		}
+		sort.Strings(days)
+		var changes []repairChange
+		for _, d := range days {
+			target, exists := dayFile(dir, d)
+			if len(keep) == 0 && len(days) == 1 && !exists {
+				if strings.HasSuffix(file, ".gz") {
+					target += ".gz"
+				}
+				changes = append(changes, repairChange{File: file, Target: target, Rows: len(moved[d]), Renamed: true})
+			} else {
+				changes = append(changes, repairChange{File: file, Target: target, Rows: len(moved[d])})
+			}
+		}
+		if dryRun {
+			return changes, nil
+		}
+		if changes[0].Renamed {
+			if err := os.Rename(file, changes[0].Target); err != nil {
+				return nil, err
+			}
+			gymdata.Forget(file)
+			return changes, nil
+		}
+
+		// The rows go into their days' files first, so a failure part way
+		// loses none: they are still in file for the next run.
+		retry := false
+		for _, c := range changes {
+			d := gymdata.BaseName(c.Target)[10:18]
+			existing, targetSize, err := readDaily(c.Target)
+			if errors.Is(err, os.ErrNotExist) {
+				err = writeNewFile(c.Target, bytes.Join(append([][]byte{header}, mergeRows(date, nil, moved[d])...), nil))
+			} else if err == nil {
+				targetHeader, targetRows := splitRows(existing)
+				if !bytes.Equal(bytes.TrimSpace(targetHeader), bytes.TrimSpace(header)) {
+					return nil, fmt.Errorf("%s has other columns than %s; move its rows by hand", filepath.Base(c.Target), filepath.Base(file))
+				}
+				err = rewriteFile(c.Target, bytes.Join(append([][]byte{header}, mergeRows(date, targetRows, moved[d])...), nil), targetSize)
+			}
+			if err == errFileChanged {
+				retry = true
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if retry {
+			continue
+		}
+		if len(keep) == 0 {
+			info, err := os.Stat(file)
+			if err != nil {
+				return nil, err
+			}
+			if info.Size() != size {
+				continue
+			}
+			if err := os.Remove(file); err != nil {
+				return nil, err
+			}
+			gymdata.Forget(file)
+			return changes, nil
+		}
+		if err := rewriteFile(file, bytes.Join(append([][]byte{header}, keep...), nil), size); err != errFileChanged {
+			return changes, err
+		}
+	}
+	return nil, errFileChanged
+}
+
+// repairData runs repairFile over every daily file of c's directory and its
+// tenants', oldest first.
+func repairData(c *Config, dryRun bool) ([]repairChange, error) {
+	configs := []*Config{c}
+	for _, t := range c.Tenants {
+		configs = append(configs, t)
+	}
+	var changes []repairChange
+	for _, cfg := range configs {
+		if cfg.CSVSource != "" {
+			return changes, fmt.Errorf("CSV_SOURCE is set; repair the files where they are stored")
+		}
+		files, err := gymdata.ListFiles(cfg.csvDir())
+		if err != nil {
+			return changes, err
+		}
+		slices.SortFunc(files, func(a, b string) int { return cmp.Compare(gymdata.BaseName(a), gymdata.BaseName(b)) })
+		for _, file := range files {
+			done, err := repairFile(cfg, file, dryRun)
+			if err != nil {
+				return changes, fmt.Errorf("%s: %v", filepath.Base(file), err)
+			}
+			changes = append(changes, done...)
+		}
+	}
+	return changes, nil
+}
+
+// runRepair is the repair command: it moves misdated rows to their days'
+// files and prints what it changed, or with -n what it would.
+func runRepair(c *Config, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("repair", flag.ContinueOnError)
+	dryRun := fs.Bool("n", false, "only report what would change")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	changes, err := repairData(c, *dryRun)
+	for _, ch := range changes {
+		fmt.Fprintln(out, ch)
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(changes) == 0:
+		fmt.Fprintln(out, "Every file's rows match its date")
+	case *dryRun:
+		fmt.Fprintf(out, "%d changes to make; run without -n to make them\n", len(changes))
+	default:
+		fmt.Fprintf(out, "%d changes made\n", len(changes))
+	}
+	return nil
+}
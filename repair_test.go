@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunRepair(t *testing.T) {
+	dir := t.TempDir()
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	row := func(ts string, n string) string {
+		return ts + ",EEST,1,Hipodroom," + n + ",success,\"{}\"\n"
+	}
+	// The collector wrote two readings past midnight into October 1st's
+	// file; October 5th's holds only the 6th's readings.
+	writeCSV(t, dir, "gym-stats-20251001.csv", header+row("2025-10-01 23:58:00", "5")+row("2025-10-02 00:00:00", "4")+row("2025-10-02 00:02:00", "3"))
+	writeCSV(t, dir, "gym-stats-20251002.csv", header+row("2025-10-02 00:04:00", "2"))
+	writeCSV(t, dir, "gym-stats-20251003.csv", header+row("2025-10-03 10:00:00", "9"))
+	writeCSV(t, dir, "gym-stats-20251005.csv", header+row("2025-10-06 10:00:00", "7"))
+	cfg := &Config{DataDir: dir}
+	read := func(name string) string {
+		data, _ := os.ReadFile(filepath.Join(dir, name))
+		return string(data)
+	}
+
+	var out strings.Builder
+	if err := runRepair(cfg, []string{"-n"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := "gym-stats-20251001.csv: moved 2 rows to gym-stats-20251002.csv\n" +
+		"gym-stats-20251005.csv: renamed to gym-stats-20251006.csv (all 1 rows are that day's)\n" +
+		"2 changes to make; run without -n to make them\n"
+	if out.String() != want {
+		t.Errorf("dry run:\n%s\nwant\n%s", out.String(), want)
+	}
+	if read("gym-stats-20251002.csv") != header+row("2025-10-02 00:04:00", "2") {
+		t.Error("the dry run changed a file")
+	}
+
+	out.Reset()
+	if err := runRepair(cfg, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(out.String(), "2 changes made\n") {
+		t.Errorf("repair output:\n%s", out.String())
+	}
+	if got := read("gym-stats-20251001.csv"); got != header+row("2025-10-01 23:58:00", "5") {
+		t.Errorf("October 1st:\n%s", got)
+	}
+	if got, want := read("gym-stats-20251002.csv"), header+row("2025-10-02 00:00:00", "4")+row("2025-10-02 00:02:00", "3")+row("2025-10-02 00:04:00", "2"); got != want {
+		t.Errorf("October 2nd:\n%s\nwant, in time order,\n%s", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "gym-stats-20251005.csv")); !os.IsNotExist(err) {
+		t.Errorf("October 5th's file is still there: %v", err)
+	}
+	if got := read("gym-stats-20251006.csv"); got != header+row("2025-10-06 10:00:00", "7") {
+		t.Errorf("October 6th:\n%s", got)
+	}
+
+	out.Reset()
+	if err := runRepair(cfg, nil, &out); err != nil || out.String() != "Every file's rows match its date\n" {
+		t.Errorf("second run: %v\n%s", err, out.String())
+	}
+}
+
+func TestMergeRowsSkipsDuplicates(t *testing.T) {
+	cfg := &Config{}
+	header := []byte("timestamp,timezone,location_id,location_name,user_count,status,response\n")
+	date := rowDater(cfg, header)
+	a := []byte("2025-10-02 00:00:00,EEST,1,Hipodroom,4,success,{}\n")
+	b := []byte("2025-10-02 00:02:00,EEST,1,Hipodroom,3,success,{}\n")
+	// A repair cut short after writing the target leaves a there; running
+	// again must not add it twice.
+	got := mergeRows(date, [][]byte{a, b}, [][]byte{a})
+	if len(got) != 2 || string(got[0]) != string(a) || string(got[1]) != string(b) {
+		t.Errorf("got %q", got)
+	}
+}
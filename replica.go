@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// ReplicaCopy is a file as last copied to REPLICA_TARGET and read back
+// intact. Size and ModTime are the local file's then, so an unchanged file
+// is skipped without reading it.
+type ReplicaCopy struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	ModTime string `json:"modTime"`
+	SHA256  string `json:"sha256"`
+	Copied  string `json:"copied"`
+}
+
+// replicaTarget is where the copies go: a directory, an SFTP server or a
+// bucket. put must leave either the whole file or the old one.
+type replicaTarget interface {
+	put(name string, data []byte) error
+	get(name string) ([]byte, error)
+}
+
+// replicaRun is how the last replication of a directory went.
+type replicaRun struct {
+	Finished string   `json:"finished"`
+	Copied   []string `json:"copied"`
+	Pending  int      `json:"pending"` // files that still differ from the copy
+	Error    string   `json:"error,omitempty"`
+}
+
+var (
+	replicaMu   sync.Mutex // one run at a time; guards replicaRuns and the stores
+	replicaRuns = map[string]*replicaRun{}
+)
+
+// readReplica loads the copies made so far; a missing file is none.
+func readReplica(cfg *Config) (map[string]ReplicaCopy, error) {
+	data, err := os.ReadFile(cfg.path(cfg.ReplicaFile))
+	if os.IsNotExist(err) {
+		return map[string]ReplicaCopy{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []ReplicaCopy
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %v", cfg.ReplicaFile, err)
+	}
+	out := make(map[string]ReplicaCopy, len(list))
+	for _, c := range list {
+		out[c.Name] = c
+	}
+	return out, nil
+}
+
+// writeReplica saves the copies, by name.
+func writeReplica(cfg *Config, copies map[string]ReplicaCopy) error {
+	list := make([]ReplicaCopy, 0, len(copies))
+	for _, c := range copies {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return writeFileAtomic(cfg.path(cfg.ReplicaFile), list)
+}
+
+// parseReplicaTarget reads REPLICA_TARGET: a directory,
+// sftp://[user@]host[:port]/path, or s3://bucket[/prefix] reached with the
+// S3_* settings. A directory is returned resolved, an s3:// target
+// normalised.
+func parseReplicaTarget(c *Config, target string) (string, error) {
+	switch {
+	case target == "":
+		return "", nil
+	case strings.HasPrefix(target, "sftp://"):
+		u, err := url.Parse(target)
+		if err != nil || u.Hostname() == "" || u.Path == "" || u.Path == "/" {
+			return "", fmt.Errorf("%q: want sftp://[user@]host[:port]/path", target)
+		}
+		if _, ok := u.User.Password(); ok {
+			return "", fmt.Errorf("%q: passwords can't be used, set up an SSH key", u.Redacted())
+		}
+		return target, nil
+	case strings.HasPrefix(target, "s3://"):
+		src, err := gymdata.NewS3Source(target, c.S3Endpoint, c.S3Region, c.S3AccessKey, c.S3SecretKey)
+		if err != nil {
+			return "", err
+		}
+		c.s3Sources = append(c.s3Sources, src)
+		return src.Root(), nil
+	}
+	dir := c.path(target)
+	if abs, err := filepath.Abs(dir); err == nil {
+		if own, err := filepath.Abs(c.path(".")); err == nil && abs == own {
+			return "", fmt.Errorf("%q is the data directory itself", target)
+		}
+	}
+	return dir, nil
+}
+
+// replicaTargetFor is the config's REPLICA_TARGET, as parsed by
+// parseReplicaTarget.
+func replicaTargetFor(c *Config) replicaTarget {
+	switch t := c.ReplicaTarget; {
+	case strings.HasPrefix(t, "sftp://"):
+		u, _ := url.Parse(t)
+		return sftpTarget{u}
+	case strings.HasPrefix(t, "s3://"):
+		return s3Target(t)
+	}
+	return dirTarget(c.ReplicaTarget)
+}
+
+type dirTarget string
+
+func (d dirTarget) put(name string, data []byte) error {
+	if err := os.MkdirAll(string(d), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(string(d), name), data)
+}
+
+func (d dirTarget) get(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(string(d), name))
+}
+
+// s3Target is a bucket's normalised s3://bucket/prefix/ root.
+type s3Target string
+
+func (s s3Target) source() (*gymdata.S3Source, error) {
+	src, ok := gymdata.SourceFor(string(s)).(*gymdata.S3Source)
+	if !ok {
+		return nil, fmt.Errorf("%s: no S3 settings for this bucket", s)
+	}
+	return src, nil
+}
+
+func (s s3Target) put(name string, data []byte) error {
+	src, err := s.source()
+	if err != nil {
+		return err
+	}
+	return src.Put(string(s)+name, data)
+}
+
+func (s s3Target) get(name string) ([]byte, error) {
+	src, err := s.source()
+	if err != nil {
+		return nil, err
+	}
+	body, err := src.Open(string(s) + name)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// sftpTarget runs the OpenSSH sftp client in batch mode, so it authenticates
+// with the server's own keys and known_hosts; it never prompts.
+type sftpTarget struct{ u *url.URL }
+
+func (s sftpTarget) run(batch string) error {
+	args := []string{"-b", "-", "-o", "BatchMode=yes"}
+	if port := s.u.Port(); port != "" {
+		args = append(args, "-P", port)
+	}
+	host := s.u.Hostname()
+	if s.u.User != nil {
+		host = s.u.User.Username() + "@" + host
+	}
+	cmd := exec.Command("sftp", append(args, host)...)
+	cmd.Stdin = strings.NewReader(batch)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("sftp %s: %v: %s", host, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// remote is name's path on the server; quoted for a batch file.
+func (s sftpTarget) remote(name string) string {
+	return fmt.Sprintf("%q", path.Join(s.u.Path, name))
+}
+
+func (s sftpTarget) put(name string, data []byte) error {
+	local, err := os.CreateTemp("", "gym-replica-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(local.Name())
+	_, err = local.Write(data)
+	if cerr := local.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	// A leading - lets a command fail: the directory may exist, the file
+	// may not yet. The upload is renamed into place only once complete.
+	part := s.remote(name + ".part")
+	return s.run(fmt.Sprintf("-mkdir %q\nput %q %s\n-rm %s\nrename %s %s\n",
+		s.u.Path, local.Name(), part, s.remote(name), part, s.remote(name)))
+}
+
+func (s sftpTarget) get(name string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "gym-replica-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, name)
+	if err := s.run(fmt.Sprintf("get %s %q\n", s.remote(name), local)); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(local)
+}
+
+// replicaFiles are the files to mirror: every daily CSV of a day that has
+// ended in Tallinn, and REPLICA_FILES.
+func replicaFiles(c *Config, now time.Time) ([]string, error) {
+	files, err := gymdata.ListFiles(c.csvDir())
+	if err != nil {
+		return nil, err
+	}
+	today := "gym-stats-" + now.In(gymdata.Tallinn()).Format("20060102")
+	var out []string
+	for _, f := range files {
+		if gymdata.BaseName(f) < today {
+			out = append(out, f)
+		}
+	}
+	for _, f := range c.ReplicaFiles {
+		if _, err := os.Stat(c.path(f)); err == nil {
+			out = append(out, c.path(f))
+		}
+	}
+	return out, nil
+}
+
+// replicate copies the files that are new or changed since their last copy
+// to REPLICA_TARGET, reads each back, and records it only if the copy's
+// checksum matches. A file that fails is retried on the next run.
+func replicate(c *Config) *replicaRun {
+	replicaMu.Lock()
+	defer replicaMu.Unlock()
+	run := &replicaRun{Copied: []string{}}
+	defer func() {
+		run.Finished = time.Now().UTC().Format(time.RFC3339)
+		replicaRuns[c.DataDir] = run
+	}()
+	fail := func(err error) *replicaRun {
+		run.Error = err.Error()
+		log.Printf("Replica %s: %v", c.ReplicaTarget, err)
+		return run
+	}
+
+	copies, err := readReplica(c)
+	if err != nil {
+		return fail(err)
+	}
+	files, err := replicaFiles(c, time.Now())
+	if err != nil {
+		return fail(err)
+	}
+	target := replicaTargetFor(c)
+	var failed error
+	for _, f := range files {
+		name := filepath.Base(f)
+		info, err := gymdata.Stat(f)
+		if err != nil {
+			failed = err
+			run.Pending++
+			continue
+		}
+		mtime := info.ModTime.UTC().Format(time.RFC3339Nano)
+		if prev, ok := copies[name]; ok && prev.Size == info.Size && prev.ModTime == mtime {
+			continue
+		}
+		copied, err := replicateFile(target, f, name)
+		if err != nil {
+			failed = fmt.Errorf("%s: %v", name, err)
+			run.Pending++
+			continue
+		}
+		copied.ModTime = mtime
+		copies[name] = copied
+		run.Copied = append(run.Copied, name)
+	}
+	if len(run.Copied) > 0 {
+		if err := writeReplica(c, copies); err != nil {
+			return fail(err)
+		}
+		log.Printf("Replica %s: copied %s", c.ReplicaTarget, strings.Join(run.Copied, ", "))
+	}
+	if failed != nil {
+		return fail(failed)
+	}
+	return run
+}
+
+// replicateFile copies one file and checks the copy reads back the same.
+func replicateFile(target replicaTarget, file, name string) (ReplicaCopy, error) {
+	body, err := gymdata.SourceFor(file).Open(file)
+	if err != nil {
+		return ReplicaCopy{}, err
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return ReplicaCopy{}, err
+	}
+	if err := target.put(name, data); err != nil {
+		return ReplicaCopy{}, err
+	}
+	back, err := target.get(name)
+	if err != nil {
+		return ReplicaCopy{}, fmt.Errorf("reading back: %v", err)
+	}
+	if !bytes.Equal(back, data) {
+		return ReplicaCopy{}, fmt.Errorf("copy differs from the original (%d bytes, want %d)", len(back), len(data))
+	}
+	sum := sha256.Sum256(data)
+	return ReplicaCopy{
+		Name:   name,
+		Size:   int64(len(data)),
+		SHA256: hex.EncodeToString(sum[:]),
+		Copied: time.Now().UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// runReplica mirrors the main directory's and every tenant's files each
+// REPLICA_INTERVAL. Like the other integrations it idles while unconfigured.
+func runReplica() {
+	last := map[string]time.Time{} // data dir -> last run
+	for {
+		cfg := currentConfig()
+		configs := []*Config{cfg}
+		for _, t := range cfg.Tenants {
+			configs = append(configs, t)
+		}
+		for _, c := range configs {
+			if c.ReplicaTarget != "" && time.Since(last[c.DataDir]) >= c.ReplicaInterval {
+				replicate(c)
+				last[c.DataDir] = time.Now()
+			}
+		}
+		time.Sleep(time.Minute)
+	}
+}
+
+// replicaHandler reports what has been copied to REPLICA_TARGET and how the
+// last run went; a POST runs it now.
+//
+//	GET  /api/admin/replica
+//	POST /api/admin/replica
+func replicaHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, POST, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" && r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	fail := func(status int, err error) {
+		writeError(w, status, err)
+	}
+	cfg := requestConfig(r)
+	if cfg.ReplicaTarget == "" {
+		fail(http.StatusNotFound, fmt.Errorf("REPLICA_TARGET is not set"))
+		return
+	}
+
+	if r.Method == "POST" {
+		replicate(cfg)
+	}
+	copies, err := readReplica(cfg)
+	if err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	files := make([]ReplicaCopy, 0, len(copies))
+	for _, c := range copies {
+		files = append(files, c)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	replicaMu.Lock()
+	last := replicaRuns[cfg.DataDir]
+	replicaMu.Unlock()
+	json.NewEncoder(w).Encode(map[string]any{
+		"target":   cfg.ReplicaTarget,
+		"interval": cfg.ReplicaInterval.String(),
+		"lastRun":  last,
+		"files":    files,
+	})
+}
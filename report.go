@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// maxReportMonths bounds a report's range: each month is read from its
+// files' rows.
+const maxReportMonths = 120
+
+// ReportMonth is one calendar month of a gym in Tallinn. Mean is the mean
+// over its days with readings of each day's own average, as /api/weeks
+// has it; MoM and YoY are the percent change of Mean from the month before
+// and from the same month a year before, null without one to compare.
+type ReportMonth struct {
+	Month   string   `json:"month"` // YYYY-MM
+	Days    int      `json:"days"`
+	Mean    float64  `json:"mean"`
+	Peak    float64  `json:"peak"`
+	PeakAt  string   `json:"peakAt"` // RFC 3339, Tallinn
+	MoM     *float64 `json:"mom"`
+	YoY     *float64 `json:"yoy"`
+	Partial bool     `json:"partial,omitempty"`
+}
+
+// ReportMonthOfYear is a calendar month over the years: the mean of its
+// whole months' means, and its rank among the months, 1 the busiest.
+type ReportMonthOfYear struct {
+	Month int     `json:"month"` // 1-12
+	Name  string  `json:"name"`
+	Mean  float64 `json:"mean"`
+	Years int     `json:"years"`
+	Rank  int     `json:"rank"`
+}
+
+type ReportLocation struct {
+	Name        string              `json:"name"`
+	Months      []ReportMonth       `json:"months"`
+	MonthOfYear []ReportMonthOfYear `json:"monthOfYear"`
+}
+
+type MonthlyReport struct {
+	From        string           `json:"from"` // YYYY-MM
+	To          string           `json:"to"`
+	Metric      string           `json:"metric"`
+	GeneratedAt string           `json:"generatedAt"`
+	Locations   []ReportLocation `json:"locations"`
+}
+
+// reportChange is the percent change from prev to cur, rounded to a tenth.
+func reportChange(cur, prev float64) *float64 {
+	if prev <= 0 {
+		return nil
+	}
+	v := math.Round((cur-prev)/prev*1000) / 10
+	return &v
+}
+
+// firstDataMonth is the month of cfg's oldest daily file.
+func firstDataMonth(cfg *Config) (time.Time, error) {
+	files, err := gymdata.ListFiles(cfg.csvDir())
+	if err != nil {
+		return time.Time{}, err
+	}
+	var first time.Time
+	for _, file := range files {
+		base := gymdata.BaseName(file)
+		if len(base) < 18 {
+			continue
+		}
+		d, err := time.ParseInLocation("20060102", base[10:18], gymdata.Tallinn())
+		if err == nil && (first.IsZero() || d.Before(first)) {
+			first = d
+		}
+	}
+	if first.IsZero() {
+		return first, gymdata.ErrNoFiles
+	}
+	return time.Date(first.Year(), first.Month(), 1, 0, 0, 0, 0, first.Location()), nil
+}
+
+// reportMonthStats reads the month starting at start and returns each
+// open gym's stats, without MoM and YoY.
+func reportMonthStats(cfg *Config, start time.Time, metric string) (map[string]ReportMonth, error) {
+	end := start.AddDate(0, 1, 0)
+	list, err := loadWindow(cfg, start, end, []string{metric})
+	if err != nil {
+		return nil, err
+	}
+
+	tallinn := gymdata.Tallinn()
+	out := make(map[string]ReportMonth, len(list))
+	for _, s := range list {
+		days := dailyStats(s, tallinn)
+		sum := 0.0
+		peak := s.Points[0]
+		for _, d := range days {
+			sum += d.mean()
+			if d.peak.Y > peak.Y || d.peak.Y == peak.Y && d.peak.At < peak.At {
+				peak = d.peak
+			}
+		}
+		out[s.Key.Location] = ReportMonth{
+			Month:  start.Format("2006-01"),
+			Days:   len(days),
+			Mean:   math.Round(sum/float64(len(days))*10) / 10,
+			Peak:   peak.Y,
+			PeakAt: time.Unix(peak.At, 0).In(tallinn).Format(time.RFC3339),
+		}
+	}
+	return out, nil
+}
+
+// buildReport compares each gym's months from the month of from to that of
+// to, a month at a time so a range of years never holds more than one
+// month's rows. location, if set, keeps only that gym. The month holding
+// now is Partial and left out of the month-of-year means.
+func buildReport(cfg *Config, from, to time.Time, metric, location string, now time.Time) (*MonthlyReport, error) {
+	byLocation := map[string][]ReportMonth{}
+	for m := from; !m.After(to); m = m.AddDate(0, 1, 0) {
+		stats, err := reportMonthStats(cfg, m, metric)
+		if err != nil {
+			return nil, err
+		}
+		for name, st := range stats {
+			if location != "" && !strings.EqualFold(name, location) {
+				continue
+			}
+			st.Partial = m.AddDate(0, 1, 0).After(now)
+			byLocation[name] = append(byLocation[name], st)
+		}
+	}
+
+	report := &MonthlyReport{
+		From:        from.Format("2006-01"),
+		To:          to.Format("2006-01"),
+		Metric:      metric,
+		GeneratedAt: now.UTC().Format(time.RFC3339),
+		Locations:   []ReportLocation{},
+	}
+	for name, months := range byLocation {
+		index := make(map[string]int, len(months))
+		for i, m := range months {
+			index[m.Month] = i
+		}
+		type acc struct {
+			sum   float64
+			years int
+		}
+		var moy [12]acc
+		for i := range months {
+			m := &months[i]
+			t, _ := time.Parse("2006-01", m.Month)
+			if j, ok := index[t.AddDate(0, -1, 0).Format("2006-01")]; ok {
+				m.MoM = reportChange(m.Mean, months[j].Mean)
+			}
+			if j, ok := index[t.AddDate(-1, 0, 0).Format("2006-01")]; ok {
+				m.YoY = reportChange(m.Mean, months[j].Mean)
+			}
+			if !m.Partial {
+				moy[t.Month()-1].sum += m.Mean
+				moy[t.Month()-1].years++
+			}
+		}
+		loc := ReportLocation{Name: name, Months: months, MonthOfYear: []ReportMonthOfYear{}}
+		for i, a := range moy {
+			if a.years > 0 {
+				loc.MonthOfYear = append(loc.MonthOfYear, ReportMonthOfYear{
+					Month: i + 1,
+					Name:  time.Month(i + 1).String(),
+					Mean:  math.Round(a.sum/float64(a.years)*10) / 10,
+					Years: a.years,
+				})
+			}
+		}
+		ranked := make([]*ReportMonthOfYear, len(loc.MonthOfYear))
+		for i := range loc.MonthOfYear {
+			ranked[i] = &loc.MonthOfYear[i]
+		}
+		sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Mean > ranked[j].Mean })
+		for i, m := range ranked {
+			m.Rank = i + 1
+		}
+		report.Locations = append(report.Locations, loc)
+	}
+	sort.Slice(report.Locations, func(i, j int) bool { return report.Locations[i].Name < report.Locations[j].Name })
+	return report, nil
+}
+
+// parseReportRange reads a report's from and to months (YYYY-MM, Tallinn),
+// defaulting to the first month with data and the current month.
+func parseReportRange(cfg *Config, fromStr, toStr string, now time.Time) (from, to time.Time, err error) {
+	tallinn := gymdata.Tallinn()
+	now = now.In(tallinn)
+	to = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, tallinn)
+	if s := strings.TrimSpace(toStr); s != "" {
+		if to, err = time.ParseInLocation("2006-01", s, tallinn); err != nil {
+			return from, to, fieldErr("to", ErrBadRange, errors.New("to must be YYYY-MM"))
+		}
+	}
+	if s := strings.TrimSpace(fromStr); s != "" {
+		if from, err = time.ParseInLocation("2006-01", s, tallinn); err != nil {
+			return from, to, fieldErr("from", ErrBadRange, errors.New("from must be YYYY-MM"))
+		}
+	} else if from, err = firstDataMonth(cfg); err != nil {
+		from = to
+	}
+	if from.After(to) {
+		return from, to, withKind(ErrBadRange, errors.New("from is after to"))
+	}
+	if (to.Year()-from.Year())*12+int(to.Month()-from.Month()) >= maxReportMonths {
+		return from, to, withKind(ErrBadRange, fmt.Errorf("the range is at most %d months", maxReportMonths))
+	}
+	return from, to, nil
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"pct": func(v *float64) string {
+		if v == nil {
+			return "–"
+		}
+		return fmt.Sprintf("%+.1f%%", *v)
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Monthly occupancy {{.From}} – {{.To}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { padding: 0.25em 0.75em; border-bottom: 1px solid #ddd; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.partial { color: #888; }
+</style>
+</head>
+<body>
+<h1>Monthly occupancy, {{.From}} to {{.To}}</h1>
+<p>Mean of each day's average {{.Metric}}; changes are from the month before (MoM) and the same month a year before (YoY).</p>
+{{range .Locations}}
+<h2>{{.Name}}</h2>
+{{if .MonthOfYear}}<table>
+<tr><th>Month</th><th>Mean</th><th>Years</th><th>Rank</th></tr>
+{{range .MonthOfYear}}<tr><td>{{.Name}}</td><td>{{printf "%.1f" .Mean}}</td><td>{{.Years}}</td><td>{{.Rank}}</td></tr>
+{{end}}</table>{{end}}
+<table>
+<tr><th>Month</th><th>Days</th><th>Mean</th><th>Peak</th><th>MoM</th><th>YoY</th></tr>
+{{range .Months}}<tr{{if .Partial}} class="partial"{{end}}><td>{{.Month}}{{if .Partial}} (so far){{end}}</td><td>{{.Days}}</td><td>{{printf "%.1f" .Mean}}</td><td>{{.Peak}}</td><td>{{pct .MoM}}</td><td>{{pct .YoY}}</td></tr>
+{{end}}</table>
+{{else}}
+<p>No data in this range.</p>
+{{end}}
+<p class="partial">Generated {{.GeneratedAt}}</p>
+</body>
+</html>
+`))
+
+// reportHandler compares each gym's monthly mean and peak across months
+// and years, for a yearly write-up: as JSON, or with format=html as a page.
+//
+//	GET /api/report[?from=YYYY-MM&to=YYYY-MM][&metric=NAME][&location=NAME][&format=html]
+//
+// The range defaults to the first month with data through this one.
+func reportHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format != "" && format != "json" && format != "html" {
+		writeError(w, http.StatusBadRequest, fieldErr("format", nil, errors.New("format must be json or html")))
+		return
+	}
+	cfg := requestConfig(r)
+	now := time.Now()
+	from, to, err := parseReportRange(cfg, q.Get("from"), q.Get("to"), now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	metric := gymdata.NormalizeMetrics([]string{q.Get("metric")})[0]
+	report, err := buildReport(cfg, from, to, metric, strings.TrimSpace(q.Get("location")), now)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, withKind(ErrParse, err))
+		return
+	}
+	if format == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		reportTemplate.Execute(w, report)
+		return
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// runReport is the report command: it prints the monthly comparison as
+// JSON, or with -html as a page.
+func runReport(c *Config, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	from := fs.String("from", "", "first month, YYYY-MM (default: the first with data)")
+	to := fs.String("to", "", "last month, YYYY-MM (default: this one)")
+	metric := fs.String("metric", "", "metric column (default: user_count)")
+	location := fs.String("location", "", "only this gym")
+	html := fs.Bool("html", false, "print an HTML page instead of JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	now := time.Now()
+	start, end, err := parseReportRange(c, *from, *to, now)
+	if err != nil {
+		return err
+	}
+	report, err := buildReport(c, start, end, gymdata.NormalizeMetrics([]string{*metric})[0], *location, now)
+	if err != nil {
+		return err
+	}
+	if *html {
+		return reportTemplate.Execute(out, report)
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReportHandler(t *testing.T) {
+	loadTallinn(t)
+	old := currentConfig()
+	defer setConfig(old)
+	dir := t.TempDir()
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	row := func(ts, n string) string { return ts + ",EET,1,Hipodroom," + n + ",success,{}\n" }
+	// January 2024 averages 15 and 30 over its two days; December is 40 and
+	// the next January 30.
+	writeCSV(t, dir, "gym-stats-20240110.csv", header+row("2024-01-10 10:00:00", "10")+row("2024-01-10 12:00:00", "20"))
+	writeCSV(t, dir, "gym-stats-20240111.csv", header+row("2024-01-11 10:00:00", "30"))
+	writeCSV(t, dir, "gym-stats-20241205.csv", header+row("2024-12-05 10:00:00", "40"))
+	writeCSV(t, dir, "gym-stats-20250108.csv", header+row("2025-01-08 10:00:00", "30"))
+	cfg := &Config{DataDir: dir}
+	setConfig(cfg)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		reportHandler(w, httptest.NewRequest("GET", "/api/report"+query, nil))
+		return w
+	}
+	w := get("?to=2025-01")
+	var report MonthlyReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || w.Code != 200 {
+		t.Fatalf("code %d: %s", w.Code, w.Body)
+	}
+	if report.From != "2024-01" || len(report.Locations) != 1 || len(report.Locations[0].Months) != 3 {
+		t.Fatalf("report = %+v, want from the first month with data", report)
+	}
+	months := report.Locations[0].Months
+	jan, dec, jan2 := months[0], months[1], months[2]
+	if jan.Month != "2024-01" || jan.Days != 2 || jan.Mean != 22.5 || jan.Peak != 30 || jan.PeakAt != "2024-01-11T10:00:00+02:00" || jan.MoM != nil || jan.YoY != nil {
+		t.Errorf("January 2024 = %+v", jan)
+	}
+	if dec.MoM != nil || dec.YoY != nil {
+		t.Errorf("December 2024 = %+v, want no month before or year before to compare", dec)
+	}
+	if jan2.MoM == nil || *jan2.MoM != -25 || jan2.YoY == nil || *jan2.YoY != 33.3 {
+		t.Errorf("January 2025 = %+v, want -25%% on December and +33.3%% on the year before", jan2)
+	}
+	moy := report.Locations[0].MonthOfYear
+	if len(moy) != 2 || moy[0].Name != "January" || moy[0].Mean != 26.3 || moy[0].Years != 2 || moy[0].Rank != 2 || moy[1].Month != 12 || moy[1].Rank != 1 {
+		t.Errorf("month of year = %+v, want December the busiest", moy)
+	}
+
+	w = get("?from=2024-12&to=2025-01&format=html")
+	if ct := w.Header().Get("Content-Type"); w.Code != 200 || !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("html: code %d, Content-Type %q", w.Code, ct)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "<h2>Hipodroom</h2>") || !strings.Contains(body, "-25.0%") {
+		t.Errorf("html page:\n%s", body)
+	}
+	for _, q := range []string{"?from=2025-02&to=2025-01", "?from=2024-1", "?format=pdf", "?from=2000-01&to=2025-01"} {
+		if w := get(q); w.Code != 400 {
+			t.Errorf("%s: code %d, want 400", q, w.Code)
+		}
+	}
+
+	var out strings.Builder
+	if err := runReport(cfg, []string{"-from", "2024-12", "-to", "2025-01"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	report = MonthlyReport{}
+	if err := json.Unmarshal([]byte(out.String()), &report); err != nil || len(report.Locations[0].Months) != 2 {
+		t.Errorf("report command: %v\n%s", err, out.String())
+	}
+}
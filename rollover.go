@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// rolloverInterval is how often the daily files are checked for a new day.
+// The collector starts the day's file on its first poll after midnight.
+const rolloverInterval = 30 * time.Second
+
+// newestDailyFile is the daily file with the latest date in its name. Unlike
+// findLatestCSV it ignores mtimes, which a late gzip of yesterday's file or a
+// restore from backup can bump.
+func newestDailyFile(dir string) (string, error) {
+	files, err := gymdata.ListFiles(dir)
+	if err != nil {
+		return "", err
+	}
+	newest := ""
+	for _, f := range files {
+		if newest == "" || gymdata.BaseName(f) > gymdata.BaseName(newest) {
+			newest = f
+		}
+	}
+	return newest, nil
+}
+
+// runRollover watches the main directory and every tenant's for the
+// collector starting a new day's file, and handles each rollover once. While
+// runWatcher is up it only checks the directories read from S3, which can't
+// be watched.
+func runRollover() {
+	seen := map[string]string{} // data dir -> newest daily file's base name
+	for {
+		cfg := currentConfig()
+		configs := []*Config{cfg}
+		for _, t := range cfg.Tenants {
+			configs = append(configs, t)
+		}
+		for _, c := range configs {
+			if watching.Load() && c.CSVSource == "" {
+				delete(seen, c.DataDir) // start afresh should the watcher stop
+				continue
+			}
+			checkRollover(c, seen)
+		}
+		time.Sleep(rolloverInterval)
+	}
+}
+
+// checkRollover compares the newest daily file with the one seen last time
+// and rolls over when a later day has appeared. The first check of a
+// directory only records what is there: a restart is not a rollover.
+func checkRollover(c *Config, seen map[string]string) bool {
+	file, err := newestDailyFile(c.csvDir())
+	if err != nil || file == "" {
+		return false
+	}
+	name := gymdata.BaseName(file)
+	if len(name) < 20 { // not gym-stats-YYYYMMDD.csv
+		return false
+	}
+	prev, ok := seen[c.DataDir]
+	seen[c.DataDir] = name
+	if !ok || name <= prev {
+		return false
+	}
+	rollover(c, file)
+	return true
+}
+
+// dropBuilds forgets the directory's built range and recent charts.
+func dropBuilds(c *Config) {
+	prefix := c.DataDir + "|"
+	rangeCacheMu.Lock()
+	for k := range rangeCache {
+		if strings.HasPrefix(k, prefix) {
+			delete(rangeCache, k)
+		}
+	}
+	rangeCacheMu.Unlock()
+	recentCacheMu.Lock()
+	for k := range recentCache {
+		if strings.HasPrefix(k, prefix) {
+			delete(recentCache, k)
+		}
+	}
+	recentCacheMu.Unlock()
+}
+
+// rollover drops the directory's range and recent builds, which were keyed
+// to yesterday's files, and rebuilds today's range, the view the dashboard
+// opens on, so gym-data.json and the first morning load show the new day.
+func rollover(c *Config, file string) {
+	dropBuilds(c)
+
+	name := gymdata.BaseName(file)
+	day := name[10:14] + "-" + name[14:16] + "-" + name[16:18]
+	params := map[string]any{"file": file, "day": day}
+	today := DateRangeRequest{From: day, To: day}
+	files, err := gymdata.InRange(c.csvDir(), day, day)
+	if err == nil {
+		_, _, _, err = buildRange(context.Background(), c, today, files, gymdata.Tallinn(), true, nil)
+	}
+	entry := AuditEntry{Action: "rollover", Actor: "scheduler", Params: params}
+	if err != nil {
+		entry.Error = err.Error()
+		log.Printf("Rollover to %s: regenerating failed: %v", day, err)
+	} else {
+		log.Printf("Rollover to %s: caches cleared, gym-data.json regenerated", day)
+	}
+	appendAudit(c, entry)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckRollover(t *testing.T) {
+	dir := t.TempDir()
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	writeCSV(t, dir, "gym-stats-20251001.csv", header+"2025-10-01 23:58:00,EEST,1,Hipodroom,12,success,\"{}\"\n")
+	cfg := &Config{DataDir: dir, AuditLog: "gym-audit.jsonl"}
+	seen := map[string]string{}
+
+	if checkRollover(cfg, seen) {
+		t.Fatal("first check rolled over; it should only note the newest file")
+	}
+	rangeCacheMu.Lock()
+	rangeCache[dir+"|2025-10-01|2025-10-01|user_count||1"] = rangeResult{}
+	rangeCache["/elsewhere|2025-10-01|2025-10-01|user_count||1"] = rangeResult{}
+	rangeCacheMu.Unlock()
+	defer func() {
+		rangeCacheMu.Lock()
+		delete(rangeCache, "/elsewhere|2025-10-01|2025-10-01|user_count||1")
+		rangeCacheMu.Unlock()
+	}()
+
+	writeCSV(t, dir, "gym-stats-20251002.csv", header+"2025-10-02 00:00:00,EEST,1,Hipodroom,3,success,\"{}\"\n")
+	if !checkRollover(cfg, seen) {
+		t.Fatal("new day's file not detected")
+	}
+	if checkRollover(cfg, seen) {
+		t.Error("same day rolled over twice")
+	}
+
+	rangeCacheMu.Lock()
+	_, stale := rangeCache[dir+"|2025-10-01|2025-10-01|user_count||1"]
+	_, other := rangeCache["/elsewhere|2025-10-01|2025-10-01|user_count||1"]
+	rangeCacheMu.Unlock()
+	if stale || !other {
+		t.Errorf("stale entry kept: %v, other directory's dropped: %v", stale, !other)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "gym-data.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var datasets []Dataset
+	if err := json.Unmarshal(data, &datasets); err != nil || len(datasets) != 1 || len(datasets[0].Data) != 1 || datasets[0].Data[0].Y != 3 {
+		t.Errorf("gym-data.json = %s, %v; want only the new day", data, err)
+	}
+	audit, _ := readAudit(cfg, "rollover", 10)
+	if len(audit) != 1 || audit[0].Params["day"] != "2025-10-02" || audit[0].Error != "" {
+		t.Errorf("audit = %+v", audit)
+	}
+}
@@ -2,48 +2,120 @@ package main
 
 import (
 	"archive/zip"
+	"bytes"
+	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"math"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+
+	"gym/internal/fixtures"
+	"gym/internal/gymdata"
 )
 
 var (
 	rangeCacheMu sync.Mutex
-	rangeCache   = map[string][]Dataset{}
+	rangeCache   = map[string]rangeResult{}
 )
 
+// rangeResult is a cached /generate-data-range build.
+type rangeResult struct {
+	list     []*gymdata.Series
+	rows     *gymdata.RowCounts
+	outliers *OutlierReport
+	built    time.Time
+	key      string // what gym-data.json is noted as holding once written
+	snapshot string // a closed range's snapshot URL, if saved
+	// resolution is the rollup read, in minutes, or 0 for the raw rows.
+	resolution int
+	// wrote says this call, not an earlier one, wrote gym-data.json. It is
+	// set on the copy returned, never on the cached build.
+	wrote bool
+}
+
 type DataPoint struct {
-	X string  `json:"x"`
-	Y float64 `json:"y"`
+	X       string  `json:"x"`
+	Y       float64 `json:"y"`
+	Flagged bool    `json:"flagged,omitempty"`
 }
 
 type Dataset struct {
-	Label string      `json:"label"`
-	Data  []DataPoint `json:"data"`
+	Label  string      `json:"label"`
+	Metric string      `json:"metric,omitempty"`
+	Data   []DataPoint `json:"data"`
 }
 
 type GenerateResponse struct {
-	Success  bool      `json:"success"`
-	Message  string    `json:"message"`
-	Output   string    `json:"output,omitempty"`
-	Error    string    `json:"error,omitempty"`
-	Datasets []Dataset `json:"datasets,omitempty"`
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Output  string `json:"output,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// UpToDate says gym-data.json already held this build, so it was
+	// neither rebuilt nor written again.
+	UpToDate bool `json:"upToDate,omitempty"`
+	// Snapshot is where a range of past days' gym-data.json can also be
+	// fetched from, under a name that changes with its content.
+	Snapshot    string             `json:"snapshot,omitempty"`
+	Datasets    []Dataset          `json:"datasets,omitempty"`
+	Rows        *gymdata.RowCounts `json:"rows,omitempty"`
+	Annotations []Annotation       `json:"annotations,omitempty"`
+	Preferences *Preferences       `json:"preferences,omitempty"`
+	Outliers    *OutlierReport     `json:"outliers,omitempty"`
+	Meta        *ResponseMeta      `json:"meta,omitempty"`
+	// Weather is the temperature and precipitation over the chart's
+	// buckets, when asked for and WEATHER_PROVIDER is set.
+	Weather []WeatherPoint `json:"weather,omitempty"`
+	// Share is the scope of the share link a reply was opened with.
+	Share *ShareScope `json:"share,omitempty"`
 }
 
 type DateRangeRequest struct {
-	From string `json:"from"`
-	To   string `json:"to"`
+	From     string   `json:"from"`
+	To       string   `json:"to"`
+	Metrics  []string `json:"metrics,omitempty"`
+	TZ       string   `json:"tz,omitempty"`
+	Outliers string   `json:"outliers,omitempty"` // off, drop or clamp; default OUTLIER_FILTER
+	// Timestamps is how points' x comes back: iso (default) or ms.
+	Timestamps string `json:"timestamps,omitempty"`
+	// Points is how points come back: objects (default) or [x, y] pairs.
+	Points string `json:"points,omitempty"`
+	// Total adds a summed "Total" series: "all" gyms or a comma-separated few.
+	Total string `json:"total,omitempty"`
+	// Areas is split (default) or club, rolling gyms' areas up per club.
+	Areas string `json:"areas,omitempty"`
+	// Fill is none (default), or null, previous or linear to put every gym
+	// on one time axis, filling the gaps that way.
+	Fill string `json:"fill,omitempty"`
+	// Values is count (default) or share, each gym's percentage of the total.
+	Values string `json:"values,omitempty"`
+	// Weather adds the weather over the chart's buckets (see /api/weather).
+	Weather bool `json:"weather,omitempty"`
+	// Preset names the range instead of from and to (see /api/presets).
+	Preset string `json:"preset,omitempty"`
+	// Closed is exclude (default) or include, charting closed gyms too.
+	Closed string `json:"closed,omitempty"`
+	// Key names the range's result in a bulk request; it defaults to the
+	// preset, or from..to.
+	Key string `json:"key,omitempty"`
+	// MaxPoints, if set, is the most points per series the range is
+	// bucketed to, however long it is.
+	MaxPoints int `json:"maxPoints,omitempty"`
 }
 
 type busyCell struct {
@@ -70,300 +142,7 @@ type BusynessResponse struct {
 	From        string             `json:"from"`
 	To          string             `json:"to"`
 	Readings    int                `json:"readings"`
-}
-
-func findLatestCSV() (string, error) {
-	files, err := filepath.Glob("gym-stats-*.csv")
-	if err != nil {
-		return "", err
-	}
-
-	if len(files) == 0 {
-		return "", fmt.Errorf("no CSV files found matching gym-stats-*.csv")
-	}
-
-	// Find the most recently modified file
-	var latestFile string
-	var latestTime time.Time
-
-	for _, file := range files {
-		info, err := os.Stat(file)
-		if err != nil {
-			continue
-		}
-
-		if info.ModTime().After(latestTime) {
-			latestTime = info.ModTime()
-			latestFile = file
-		}
-	}
-
-	return latestFile, nil
-}
-
-func findCSVFilesInRange(fromDate, toDate string) ([]string, error) {
-	files, err := filepath.Glob("gym-stats-*.csv")
-	if err != nil {
-		return nil, err
-	}
-
-	if len(files) == 0 {
-		return nil, fmt.Errorf("no CSV files found matching gym-stats-*.csv")
-	}
-
-	// Parse date range
-	from, err := time.Parse("2006-01-02", fromDate)
-	if err != nil {
-		return nil, fmt.Errorf("invalid from date format: %v", err)
-	}
-	to, err := time.Parse("2006-01-02", toDate)
-	if err != nil {
-		return nil, fmt.Errorf("invalid to date format: %v", err)
-	}
-
-	var filteredFiles []string
-	for _, file := range files {
-		// Extract date from filename (gym-stats-YYYYMMDD.csv)
-		basename := filepath.Base(file)
-		if len(basename) < 20 { // gym-stats-YYYYMMDD.csv = 20 chars minimum
-			continue
-		}
-
-		dateStr := basename[10:18] // Extract YYYYMMDD
-		fileDate, err := time.Parse("20060102", dateStr)
-		if err != nil {
-			continue
-		}
-
-		// Check if file date is within range (inclusive)
-		if (fileDate.Equal(from) || fileDate.After(from)) && (fileDate.Equal(to) || fileDate.Before(to.AddDate(0, 0, 1))) {
-			filteredFiles = append(filteredFiles, file)
-		}
-	}
-
-	return filteredFiles, nil
-}
-
-func convertCSVFilesToJSON(csvFiles []string) ([]Dataset, error) {
-	dataByLocation := make(map[string][]DataPoint)
-
-	for _, csvFile := range csvFiles {
-		err := processCSVFile(csvFile, dataByLocation)
-		if err != nil {
-			return nil, fmt.Errorf("failed to process %s: %v", csvFile, err)
-		}
-	}
-
-	// Convert to datasets
-	var datasets []Dataset
-	for locationName, dataPoints := range dataByLocation {
-		// Sort by timestamp
-		sort.Slice(dataPoints, func(i, j int) bool {
-			return dataPoints[i].X < dataPoints[j].X
-		})
-
-		datasets = append(datasets, Dataset{
-			Label: locationName,
-			Data:  dataPoints,
-		})
-	}
-
-	// Sort datasets by location name for consistent ordering
-	sort.Slice(datasets, func(i, j int) bool {
-		return datasets[i].Label < datasets[j].Label
-	})
-
-	return datasets, nil
-}
-
-func convertCSVToJSON(csvFile string) ([]Dataset, error) {
-	return convertCSVFilesToJSON([]string{csvFile})
-}
-
-// pickBucketMinutes chooses an aggregation interval so a wide range stays readable
-// (~1200 points per series) while short ranges keep raw 2-minute detail.
-func pickBucketMinutes(from, to time.Time) int {
-	spanMinutes := to.Sub(from).Minutes()
-	if spanMinutes <= 0 {
-		return 2
-	}
-	target := spanMinutes / 1200.0
-	ladder := []int{2, 5, 10, 15, 30, 60, 120, 180, 360, 720, 1440}
-	for _, step := range ladder {
-		if float64(step) >= target {
-			return step
-		}
-	}
-	return ladder[len(ladder)-1]
-}
-
-// downsampleDatasets averages each series into fixed buckets aligned to local
-// midnight. Empty buckets are dropped so gaps are preserved. bucketMinutes <= 2
-// returns the data unchanged (raw 2-minute readings).
-func downsampleDatasets(datasets []Dataset, bucketMinutes int) []Dataset {
-	if bucketMinutes <= 2 {
-		return datasets
-	}
-
-	out := make([]Dataset, 0, len(datasets))
-	for _, ds := range datasets {
-		type agg struct {
-			sum   float64
-			count int
-			order int
-		}
-		buckets := make(map[string]*agg)
-		var keys []string
-
-		for _, p := range ds.Data {
-			t, err := time.Parse("2006-01-02T15:04:05Z07:00", p.X)
-			if err != nil {
-				continue
-			}
-			minuteOfDay := t.Hour()*60 + t.Minute()
-			floored := (minuteOfDay / bucketMinutes) * bucketMinutes
-			bucketStart := time.Date(t.Year(), t.Month(), t.Day(), floored/60, floored%60, 0, 0, t.Location())
-			key := bucketStart.Format("2006-01-02T15:04:05Z07:00")
-
-			b := buckets[key]
-			if b == nil {
-				b = &agg{order: len(keys)}
-				buckets[key] = b
-				keys = append(keys, key)
-			}
-			b.sum += p.Y
-			b.count++
-		}
-
-		points := make([]DataPoint, 0, len(keys))
-		for _, key := range keys {
-			b := buckets[key]
-			points = append(points, DataPoint{
-				X: key,
-				Y: math.Round((b.sum/float64(b.count))*10) / 10,
-			})
-		}
-		out = append(out, Dataset{Label: ds.Label, Data: points})
-	}
-	return out
-}
-
-func processCSVFile(csvFile string, dataByLocation map[string][]DataPoint) error {
-	file, err := os.Open(csvFile)
-	if err != nil {
-		return fmt.Errorf("failed to open CSV file: %v", err)
-	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	reader.LazyQuotes = true    // Handle malformed quotes more gracefully
-	reader.FieldsPerRecord = -1 // Variable number of fields per record
-
-	// Read header
-	headers, err := reader.Read()
-	if err != nil {
-		return fmt.Errorf("failed to read CSV headers: %v", err)
-	}
-
-	// Find column indices
-	var timestampIdx, timezoneIdx, locationNameIdx, userCountIdx, statusIdx int = -1, -1, -1, -1, -1
-	for i, header := range headers {
-		switch header {
-		case "timestamp":
-			timestampIdx = i
-		case "timezone":
-			timezoneIdx = i
-		case "location_name":
-			locationNameIdx = i
-		case "user_count":
-			userCountIdx = i
-		case "status":
-			statusIdx = i
-		}
-	}
-
-	if timestampIdx == -1 || timezoneIdx == -1 || locationNameIdx == -1 || userCountIdx == -1 || statusIdx == -1 {
-		return fmt.Errorf("missing required columns in CSV")
-	}
-
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			continue
-		}
-
-		maxIdx := max2(max2(max2(timestampIdx, timezoneIdx), max2(locationNameIdx, userCountIdx)), statusIdx)
-		if len(record) <= maxIdx {
-			continue
-		}
-
-		// Skip non-success records
-		if record[statusIdx] != "success" {
-			continue
-		}
-
-		// Parse timestamp from CSV
-		timestamp := record[timestampIdx]
-		t, err := time.Parse("2006-01-02 15:04:05", timestamp)
-		if err != nil {
-			continue
-		}
-
-		// Load Estonia/Tallinn timezone for display (gyms are in Tallinn)
-		tallinnTZ, err := time.LoadLocation("Europe/Tallinn")
-		if err != nil {
-			// Fallback to fixed offset if timezone loading fails
-			tallinnTZ = time.FixedZone("EET", 2*3600) // UTC+2 as fallback
-		}
-
-		var sourceTime time.Time
-
-		// Handle timezone field if available, otherwise assume UTC (for legacy files)
-		if timezoneIdx != -1 && len(record) > timezoneIdx {
-			timezoneStr := record[timezoneIdx]
-
-			// Parse timestamp with its original timezone
-			timestampWithTZ := timestamp + " " + timezoneStr
-			sourceTime, err = time.Parse("2006-01-02 15:04:05 MST", timestampWithTZ)
-			if err != nil {
-				// Fallback: treat as UTC if timezone parsing fails
-				sourceTime = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
-			}
-		} else {
-			// Legacy files without timezone field - assume UTC
-			sourceTime = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
-		}
-
-		// Always convert to Tallinn timezone for display (since gyms are in Tallinn)
-		tallinnTime := sourceTime.In(tallinnTZ)
-
-		// Round to a nearest 2-minute interval
-		minute := tallinnTime.Minute()
-		roundedMinute := (minute / 2) * 2
-		tallinnTime = time.Date(tallinnTime.Year(), tallinnTime.Month(), tallinnTime.Day(),
-			tallinnTime.Hour(), roundedMinute, 0, 0, tallinnTZ)
-
-		// Format as ISO timestamp with timezone for proper JavaScript parsing
-		isoTimestamp := tallinnTime.Format("2006-01-02T15:04:05Z07:00")
-
-		// Parse user count
-		userCount, err := strconv.Atoi(record[userCountIdx])
-		if err != nil {
-			continue
-		}
-
-		locationName := record[locationNameIdx]
-
-		dataByLocation[locationName] = append(dataByLocation[locationName], DataPoint{
-			X: isoTimestamp,
-			Y: float64(userCount),
-		})
-	}
-
-	return nil
+	Rows        *gymdata.RowCounts `json:"rows"`
 }
 
 func max2(a, b int) int {
@@ -373,25 +152,8 @@ func max2(a, b int) int {
 	return b
 }
 
-// busynessLocalTime converts a logged (timestamp, timezone) pair to Tallinn local
-// time. Historic rows are logged in UTC; recent ones carry EEST/EET, which are
-// Tallinn's own summer/winter zones, so their wall-clock is already local.
-func busynessLocalTime(tsStr, tzStr string, tallinn *time.Location) (time.Time, bool) {
-	t, err := time.Parse("2006-01-02 15:04:05", tsStr)
-	if err != nil {
-		return time.Time{}, false
-	}
-	switch strings.ToUpper(strings.TrimSpace(tzStr)) {
-	case "", "UTC", "GMT", "Z":
-		src := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
-		return src.In(tallinn), true
-	default:
-		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, tallinn), true
-	}
-}
-
-func accumulateBusyness(csvFile string, acc map[string]*[7][24]busyCell, tallinn *time.Location, from, to *time.Time, span *[2]time.Time, months map[string]bool) {
-	file, err := os.Open(csvFile)
+func accumulateBusyness(cfg *Config, csvFile string, acc map[string]*[7][24]busyCell, tallinn *time.Location, from, to *time.Time, span *[2]time.Time, months map[string]bool, rows *gymdata.RowCounts) {
+	file, err := gymdata.Open(csvFile)
 	if err != nil {
 		return
 	}
@@ -405,8 +167,9 @@ func accumulateBusyness(csvFile string, acc map[string]*[7][24]busyCell, tallinn
 	if err != nil {
 		return
 	}
+	headers = gymdata.CanonicalHeaders(headers, cfg.CSVColumns)
 
-	tsIdx, tzIdx, locIdx, cntIdx, stIdx := -1, -1, -1, -1, -1
+	tsIdx, tzIdx, locIdx, areaIdx, cntIdx, stIdx := -1, -1, -1, -1, -1, -1
 	for i, header := range headers {
 		switch header {
 		case "timestamp":
@@ -415,6 +178,8 @@ func accumulateBusyness(csvFile string, acc map[string]*[7][24]busyCell, tallinn
 			tzIdx = i
 		case "location_name":
 			locIdx = i
+		case "area":
+			areaIdx = i
 		case "user_count":
 			cntIdx = i
 		case "status":
@@ -440,12 +205,10 @@ func accumulateBusyness(csvFile string, acc map[string]*[7][24]busyCell, tallinn
 			continue
 		}
 
-		if record[stIdx] != "success" {
-			continue
-		}
-
+		status := record[stIdx]
+		action := cfg.StatusPolicy.Action(status)
 		count, err := strconv.Atoi(record[cntIdx])
-		if err != nil {
+		if err != nil && action != gymdata.Exclude {
 			continue
 		}
 
@@ -454,10 +217,17 @@ func accumulateBusyness(csvFile string, acc map[string]*[7][24]busyCell, tallinn
 			tzVal = record[tzIdx]
 		}
 
-		local, ok := busynessLocalTime(record[tsIdx], tzVal, tallinn)
+		local, ok := gymdata.LocalTime(record[tsIdx], tzVal, cfg.CSVTimeLayout, tallinn)
 		if !ok {
 			continue
 		}
+		inRange := (from == nil || !local.Before(*from)) && (to == nil || local.Before(*to))
+		if action == gymdata.Exclude {
+			if inRange {
+				rows.Add(status, action)
+			}
+			continue
+		}
 
 		if span[0].IsZero() || local.Before(span[0]) {
 			span[0] = local
@@ -467,17 +237,15 @@ func accumulateBusyness(csvFile string, acc map[string]*[7][24]busyCell, tallinn
 		}
 		months[local.Format("2006-01")] = true
 
-		if from != nil && local.Before(*from) {
-			continue
-		}
-		if to != nil && !local.Before(*to) {
+		if !inRange {
 			continue
 		}
+		rows.Add(status, action)
 
 		dayIdx := (int(local.Weekday()) + 6) % 7 // Mon=0 ... Sun=6
 		hour := local.Hour()
 
-		name := record[locIdx]
+		name := cfg.location(record, locIdx, areaIdx)
 		grid := acc[name]
 		if grid == nil {
 			grid = &[7][24]busyCell{}
@@ -488,10 +256,28 @@ func accumulateBusyness(csvFile string, acc map[string]*[7][24]busyCell, tallinn
 	}
 }
 
+// collectBusyness accumulates the weekday × hour grid of every CSV, limited to
+// [from, to) when set. months and span always cover all data so callers can
+// offer the full choice of periods.
+// rows, when non-nil, tallies how the status policy treated the rows in range.
+func collectBusyness(cfg *Config, tallinn *time.Location, from, to *time.Time, rows *gymdata.RowCounts) (map[string]*[7][24]busyCell, map[string]bool, [2]time.Time, error) {
+	acc := make(map[string]*[7][24]busyCell)
+	months := make(map[string]bool)
+	var span [2]time.Time
+
+	files, err := gymdata.ListFiles(cfg.csvDir())
+	if err != nil {
+		return nil, nil, span, err
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		accumulateBusyness(cfg, f, acc, tallinn, from, to, &span, months, rows)
+	}
+	return acc, months, span, nil
+}
+
 func busynessDataHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	setCORS(w, r, "GET, OPTIONS")
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method == "OPTIONS" {
@@ -503,12 +289,24 @@ func busynessDataHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tallinn, err := time.LoadLocation("Europe/Tallinn")
-	if err != nil {
-		tallinn = time.FixedZone("EET", 2*3600)
-	}
+	tallinn := gymdata.Tallinn()
 
 	q := r.URL.Query()
+	outZone, err := requestZone(q.Get("tz"), tallinn)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	days := []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+	if lang := q.Get("lang"); lang != "" {
+		short, _ := langNames(lang)
+		days = short[:]
+	}
+	includeClosed, err := requestClosed(q.Get("closed"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
 	monthStr := strings.TrimSpace(q.Get("month"))
 	fromStr := strings.TrimSpace(q.Get("from"))
 	toStr := strings.TrimSpace(q.Get("to"))
@@ -529,24 +327,20 @@ func busynessDataHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	files, err := filepath.Glob("gym-stats-*.csv")
+	rows := &gymdata.RowCounts{}
+	cfg := requestConfig(r)
+	acc, months, span, err := collectBusyness(cfg, tallinn, fromPtr, toPtr, rows)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
-	sort.Strings(files)
-
-	acc := make(map[string]*[7][24]busyCell)
-	months := make(map[string]bool)
-	var span [2]time.Time
-	for _, f := range files {
-		accumulateBusyness(f, acc, tallinn, fromPtr, toPtr, &span, months)
-	}
 
+	closed := chartClosed(cfg, includeClosed)
 	names := make([]string, 0, len(acc))
 	for n := range acc {
-		names = append(names, n)
+		if !closed.has(n) {
+			names = append(names, n)
+		}
 	}
 	sort.Strings(names)
 
@@ -611,19 +405,39 @@ func busynessDataHandler(w http.ResponseWriter, r *http.Request) {
 		effTo = toPtr.AddDate(0, 0, -1).Format("2006-01-02")
 	}
 
-	json.NewEncoder(w).Encode(BusynessResponse{
-		Days:        []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"},
+	resp := BusynessResponse{
+		Days:        days,
 		Hours:       hours,
 		Locations:   locations,
 		GlobalMax:   globalMax,
-		GeneratedAt: time.Now().In(tallinn).Format("2006-01-02 15:04:05 MST"),
+		GeneratedAt: time.Now().In(outZone).Format("2006-01-02 15:04:05 MST"),
 		DataStart:   dataStart,
 		DataEnd:     dataEnd,
 		Months:      monthList,
 		From:        effFrom,
 		To:          effTo,
 		Readings:    readings,
-	})
+		Rows:        rows,
+	}
+	writeNegotiated(w, r, resp, resp.table)
+}
+
+// table is the grids as CSV, a row per location, day and hour; hours
+// without readings have an empty avg.
+func (b BusynessResponse) table() [][]string {
+	rows := [][]string{{"location", "day", "hour", "avg", "samples"}}
+	for _, l := range b.Locations {
+		for d, day := range b.Days {
+			for h := range l.Avg[d] {
+				avg := ""
+				if l.Samples[d][h] > 0 {
+					avg = strconv.FormatFloat(l.Avg[d][h], 'f', -1, 64)
+				}
+				rows = append(rows, []string{l.Name, day, strconv.Itoa(h), avg, strconv.Itoa(l.Samples[d][h])})
+			}
+		}
+	}
+	return rows
 }
 
 type StatusLocation struct {
@@ -638,12 +452,19 @@ type StatusResponse struct {
 	Locations  []StatusLocation `json:"locations"`
 }
 
+// table is the locations as CSV.
+func (s StatusResponse) table() [][]string {
+	rows := [][]string{{"name", "count", "at"}}
+	for _, l := range s.Locations {
+		rows = append(rows, []string{l.Name, strconv.Itoa(l.Count), l.At})
+	}
+	return rows
+}
+
 // statusHandler reports the most recent reading and its age, reading only the
 // latest CSV file so it is cheap to poll for a "data freshness" indicator.
 func statusHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	setCORS(w, r, "GET, OPTIONS")
 	w.Header().Set("Content-Type", "application/json")
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
@@ -654,22 +475,53 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tallinn, err := time.LoadLocation("Europe/Tallinn")
+	tallinn := gymdata.Tallinn()
+
+	outZone, err := requestZone(r.URL.Query().Get("tz"), tallinn)
 	if err != nil {
-		tallinn = time.FixedZone("EET", 2*3600)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	status := readLatestStatus(requestConfig(r), tallinn)
+	if outZone != tallinn {
+		status = statusInZone(status, outZone)
+	}
+	writeNegotiated(w, r, status, status.table)
+}
+
+// statusInZone re-expresses the status timestamps in loc.
+func statusInZone(s StatusResponse, loc *time.Location) StatusResponse {
+	conv := func(v string) string {
+		t, err := time.Parse("2006-01-02T15:04:05Z07:00", v)
+		if err != nil {
+			return v
+		}
+		return t.In(loc).Format("2006-01-02T15:04:05Z07:00")
+	}
+	s.Latest = conv(s.Latest)
+	locs := make([]StatusLocation, len(s.Locations))
+	for i, l := range s.Locations {
+		l.At = conv(l.At)
+		locs[i] = l
 	}
+	s.Locations = locs
+	return s
+}
 
+// readLatestStatus scans the latest CSV for each location's newest reading,
+// leaving closed gyms out. With no usable data it returns AgeSeconds -1 and
+// no locations.
+func readLatestStatus(cfg *Config, tallinn *time.Location) StatusResponse {
 	resp := StatusResponse{AgeSeconds: -1, Locations: []StatusLocation{}}
 
-	csvFile, err := findLatestCSV()
+	csvFile, err := gymdata.Latest(cfg.csvDir())
 	if err != nil {
-		json.NewEncoder(w).Encode(resp)
-		return
+		return resp
 	}
-	file, err := os.Open(csvFile)
+	file, err := gymdata.Open(csvFile)
 	if err != nil {
-		json.NewEncoder(w).Encode(resp)
-		return
+		return resp
 	}
 	defer file.Close()
 
@@ -678,10 +530,10 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 	reader.FieldsPerRecord = -1
 	headers, err := reader.Read()
 	if err != nil {
-		json.NewEncoder(w).Encode(resp)
-		return
+		return resp
 	}
-	tsIdx, tzIdx, locIdx, cntIdx, stIdx := -1, -1, -1, -1, -1
+	headers = gymdata.CanonicalHeaders(headers, cfg.CSVColumns)
+	tsIdx, tzIdx, locIdx, areaIdx, cntIdx, stIdx := -1, -1, -1, -1, -1, -1
 	for i, h := range headers {
 		switch h {
 		case "timestamp":
@@ -690,6 +542,8 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 			tzIdx = i
 		case "location_name":
 			locIdx = i
+		case "area":
+			areaIdx = i
 		case "user_count":
 			cntIdx = i
 		case "status":
@@ -697,8 +551,7 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	if tsIdx == -1 || locIdx == -1 || cntIdx == -1 || stIdx == -1 {
-		json.NewEncoder(w).Encode(resp)
-		return
+		return resp
 	}
 
 	type latest struct {
@@ -707,6 +560,7 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	byLoc := map[string]latest{}
 	var maxInstant time.Time
+	closed := closedLocations(cfg)
 
 	for {
 		record, err := reader.Read()
@@ -720,7 +574,7 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 		if len(record) <= maxIdx {
 			continue
 		}
-		if record[stIdx] != "success" {
+		if cfg.StatusPolicy.Action(record[stIdx]) == gymdata.Exclude {
 			continue
 		}
 		count, err := strconv.Atoi(record[cntIdx])
@@ -731,22 +585,24 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 		if tzIdx != -1 {
 			tzVal = record[tzIdx]
 		}
-		inst, ok := busynessLocalTime(record[tsIdx], tzVal, tallinn)
+		inst, ok := gymdata.LocalTime(record[tsIdx], tzVal, cfg.CSVTimeLayout, tallinn)
 		if !ok {
 			continue
 		}
-		name := record[locIdx]
-		if cur, exists := byLoc[name]; !exists || inst.After(cur.at) {
-			byLoc[name] = latest{count: count, at: inst}
-		}
 		if maxInstant.IsZero() || inst.After(maxInstant) {
 			maxInstant = inst
 		}
+		name := cfg.location(record, locIdx, areaIdx)
+		if closed.has(name) {
+			continue // still counts towards the collector's freshness
+		}
+		if cur, exists := byLoc[name]; !exists || inst.After(cur.at) {
+			byLoc[name] = latest{count: count, at: inst}
+		}
 	}
 
 	if maxInstant.IsZero() {
-		json.NewEncoder(w).Encode(resp)
-		return
+		return resp
 	}
 
 	names := make([]string, 0, len(byLoc))
@@ -760,18 +616,49 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 		locs = append(locs, StatusLocation{Name: n, Count: l.count, At: l.at.Format("2006-01-02T15:04:05Z07:00")})
 	}
 
-	json.NewEncoder(w).Encode(StatusResponse{
+	return StatusResponse{
 		Latest:     maxInstant.Format("2006-01-02T15:04:05Z07:00"),
 		AgeSeconds: int64(time.Since(maxInstant).Seconds()),
 		Locations:  locs,
-	})
+	}
+}
+
+type MetricsResponse struct {
+	Metrics []string `json:"metrics"`
+	Default string   `json:"default"`
+}
+
+// metricListHandler lists the metric columns found across all CSV headers,
+// then the configured derived metrics, so clients know which series they can
+// request.
+func metricListHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg := requestConfig(r)
+	files, err := gymdata.ListFiles(cfg.csvDir())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	metrics := gymdata.Metrics(cfg.format(), files)
+	for _, d := range cfg.DerivedMetrics {
+		metrics = append(metrics, d.Name)
+	}
+	json.NewEncoder(w).Encode(MetricsResponse{Metrics: metrics, Default: gymdata.DefaultMetric})
 }
 
 func generateDataHandler(w http.ResponseWriter, r *http.Request) {
 	// Enable CORS
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	setCORS(w, r, "GET, POST, OPTIONS")
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method == "OPTIONS" {
@@ -780,75 +667,163 @@ func generateDataHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method != "POST" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(GenerateResponse{
-			Success: false,
-			Error:   "Method not allowed",
-		})
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
 		return
 	}
 
 	// Find latest CSV file
-	csvFile, err := findLatestCSV()
+	cfg := requestConfig(r)
+	csvFile, err := gymdata.Latest(cfg.csvDir())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(GenerateResponse{
-			Success: false,
-			Error:   err.Error(),
-		})
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
 	// Convert CSV to JSON
-	datasets, err := convertCSVToJSON(csvFile)
+	var metrics []string
+	if m := r.URL.Query().Get("metrics"); m != "" {
+		metrics = strings.Split(m, ",")
+	}
+	outZone, err := requestZone(r.URL.Query().Get("tz"), nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(GenerateResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to convert CSV: %v", err),
-		})
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
-
-	// Write to gym-data.json
-	jsonFile, err := os.Create("gym-data.json")
+	if outZone == nil {
+		outZone = gymdata.Tallinn()
+	}
+	pf, err := requestPointFormat(outZone, r.URL.Query().Get("timestamps"), r.URL.Query().Get("points"))
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(GenerateResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to create JSON file: %v", err),
-		})
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
-	defer jsonFile.Close()
-
-	encoder := json.NewEncoder(jsonFile)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(datasets); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(GenerateResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to write JSON: %v", err),
-		})
+	mode, err := requestOutlierMode(cfg, r.URL.Query().Get("outliers"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	rollUp, err := requestAreas(r.URL.Query().Get("areas"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	includeClosed, err := requestClosed(r.URL.Query().Get("closed"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	maxPoints, err := requestMaxPoints(r.URL.Query().Get("maxPoints"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, _, err := requestFill(r.URL.Query().Get("fill")); err != nil {
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
+	if _, err := requestValues(r.URL.Query().Get("values")); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	weather, err := requestWeather(r.URL.Query().Get("weather"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// The day's raw 2-minute readings, unless maxPoints asks for fewer.
+	bucketMinutes := 2
+	if maxPoints > 0 {
+		var day time.Time
+		bucketMinutes = bucketFor(day, day.Add(24*time.Hour), maxPoints)
+	}
+	// The refresh button regenerates the same file over and over; while it
+	// and the options are unchanged, the last build is served as it is and
+	// gym-data.json left alone.
+	auditParams := map[string]any{"file": csvFile, "metrics": gymdata.NormalizeMetrics(metrics)}
+	var newest time.Time
+	key := cfg.DataDir + "|latest|" + csvFile + "|" + strings.Join(loadMetrics(cfg, metrics), ",") + "|" + r.URL.Query().Get("tz") + "|" + mode.String() + "|" + strconv.Itoa(maxPoints)
+	if info, err := gymdata.Stat(csvFile); err == nil {
+		newest = info.ModTime
+		key += "|" + strconv.FormatInt(info.Size, 10) + "|" + strconv.FormatInt(newest.UnixNano(), 10)
+	}
+	rangeCacheMu.Lock()
+	res, hit := rangeCache[key]
+	rangeCacheMu.Unlock()
+	countCache("latest", hit)
+	upToDate := hit && dataFileCurrent(cfg, key, newest)
+	if !hit {
+		list, rows, err := traceLoad(r.Context(), cfg, []string{csvFile}, loadMetrics(cfg, metrics), nil)
+		if err != nil {
+			recordAudit(r, "generate-data", auditParams, err)
+			writeError(w, http.StatusInternalServerError, withKind(ErrParse, fmt.Errorf("Failed to convert CSV: %v", err)))
+			return
+		}
+		list, report := filterOutliers(list, mode, cfg, outZone)
+		gymdata.Bucket(list, bucketMinutes, outZone)
+		res = rangeResult{list: list, rows: rows, outliers: report, built: time.Now(), key: key}
+	}
 
-	// Success response
-	output := fmt.Sprintf("Successfully generated gym-data.json from %s\nFound %d locations with data", csvFile, len(datasets))
+	// Write to gym-data.json
+	if !upToDate {
+		if err := writeDataFile(cfg, key, res.list, outZone); err != nil {
+			recordAudit(r, "generate-data", auditParams, err)
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("Failed to write JSON: %v", err))
+			return
+		}
+	}
+	if !hit {
+		rangeCacheMu.Lock()
+		if len(rangeCache) > 64 {
+			rangeCache = map[string]rangeResult{}
+		}
+		rangeCache[key] = res
+		rangeCacheMu.Unlock()
+	}
 
-	json.NewEncoder(w).Encode(GenerateResponse{
-		Success:  true,
-		Message:  "Data generated successfully",
-		Output:   output,
-		Datasets: datasets,
-	})
+	auditParams["upToDate"] = upToDate
+	recordAudit(r, "generate-data", auditParams, nil)
+
+	// Success response
+	message := "Data generated successfully"
+	output := fmt.Sprintf("Successfully generated gym-data.json from %s\nFound %d locations with data", csvFile, len(res.list))
+	if upToDate {
+		message = "Already up to date"
+		output = fmt.Sprintf("gym-data.json is already up to date with %s\nFound %d locations with data", csvFile, len(res.list))
+	}
+
+	today := time.Now().In(gymdata.Tallinn()).Format("2006-01-02")
+	meta := chartMeta([]string{csvFile}, res.rows, res.list, bucketMinutes, res.built, hit, outZone)
+	meta.From, meta.To = today, today
+	list := withFill(withDerived(withTotal(withShare(withAreas(withoutClosed(res.list, chartClosed(cfg, includeClosed)), rollUp, bucketMinutes), r.URL.Query().Get("values"), bucketMinutes), r.URL.Query().Get("total"), bucketMinutes), cfg, metrics, bucketMinutes), r.URL.Query().Get("fill"), bucketMinutes)
+	writeChartResponse(w, r, GenerateResponse{
+		Success:     true,
+		Message:     message,
+		Output:      output,
+		UpToDate:    upToDate,
+		Rows:        res.rows,
+		Annotations: annotationsForDays(cfg, today, today, outZone),
+		Preferences: prefsFor(r, cfg),
+		Outliers:    res.outliers,
+		Meta:        meta,
+		Weather:     chartWeather(cfg, weather, list, bucketMinutes, pf),
+	}, list, pf)
 }
 
+// generateDataRangeHandler builds a range and writes it to gym-data.json,
+// for admins; rangeHandler is the same for viewers, leaving the file alone.
 func generateDataRangeHandler(w http.ResponseWriter, r *http.Request) {
+	serveRange(w, r, true)
+}
+
+func rangeHandler(w http.ResponseWriter, r *http.Request) {
+	serveRange(w, r, false)
+}
+
+// serveRange answers a POST of one range, or an array of them, writing each
+// built range to gym-data.json if write is set.
+func serveRange(w http.ResponseWriter, r *http.Request, write bool) {
 	// Enable CORS
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	setCORS(w, r, "GET, POST, OPTIONS")
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method == "OPTIONS" {
@@ -857,149 +832,303 @@ func generateDataRangeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method != "POST" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(GenerateResponse{
-			Success: false,
-			Error:   "Method not allowed",
-		})
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
 		return
 	}
 
-	// Parse request body
+	// Parse request body: one range, or an array of them
+	body, err := io.ReadAll(r.Body)
+	if err == nil && bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		bulkRangeHandler(w, r, body, write)
+		return
+	}
 	var dateRange DateRangeRequest
-	if err := json.NewDecoder(r.Body).Decode(&dateRange); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(GenerateResponse{
-			Success: false,
-			Error:   "Invalid request body",
-		})
+	if err != nil || json.Unmarshal(body, &dateRange) != nil {
+		writeError(w, http.StatusBadRequest, errors.New("Invalid request body"))
+		return
+	}
+	if err := checkRangeRequest(requestConfig(r), &dateRange); err != nil {
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	// Find CSV files in date range
-	csvFiles, err := findCSVFilesInRange(dateRange.From, dateRange.To)
+	// ?async=1 queues the build as a job and answers at once; a range of
+	// months can otherwise outlast the browser's timeout.
+	if r.URL.Query().Get("async") == "1" {
+		submitRangeJob(w, r, dateRange, write)
+		return
+	}
+
+	resp, list, pf, status, err := rangeResponse(r, dateRange, write)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(GenerateResponse{
-			Success: false,
-			Error:   err.Error(),
-		})
+		writeError(w, status, err)
 		return
 	}
+	writeChartResponse(w, r, resp, list, pf)
+}
+
+// checkRangeRequest rejects a range's bad options and resolves its preset
+// into from and to.
+func checkRangeRequest(cfg *Config, dateRange *DateRangeRequest) error {
+	if _, err := parseOutlierMode(dateRange.Outliers); err != nil {
+		return err
+	}
+	if _, err := requestPointFormat(nil, dateRange.Timestamps, dateRange.Points); err != nil {
+		return err
+	}
+	if _, err := requestAreas(dateRange.Areas); err != nil {
+		return err
+	}
+	if _, _, err := requestFill(dateRange.Fill); err != nil {
+		return err
+	}
+	if _, err := requestValues(dateRange.Values); err != nil {
+		return err
+	}
+	if _, err := requestClosed(dateRange.Closed); err != nil {
+		return err
+	}
+	if err := checkMaxPoints(dateRange.MaxPoints); err != nil {
+		return err
+	}
+	return applyPreset(cfg, dateRange, time.Now())
+}
+
+// rangeResponse builds the reply to a checked range: the response, its
+// series and how to write them, or the error and the status to send it with.
+// The build is written to gym-data.json only if write is set.
+func rangeResponse(r *http.Request, dateRange DateRangeRequest, write bool) (GenerateResponse, []*gymdata.Series, pointFormat, int, error) {
+	fail := func(status int, err error) (GenerateResponse, []*gymdata.Series, pointFormat, int, error) {
+		return GenerateResponse{}, nil, pointFormat{}, status, err
+	}
+	rollUp, _ := requestAreas(dateRange.Areas)          // checked by checkRangeRequest
+	includeClosed, _ := requestClosed(dateRange.Closed) // likewise
+	outZone, err := requestZone(dateRange.TZ, gymdata.Tallinn())
+	if err != nil {
+		return fail(http.StatusBadRequest, err)
+	}
+	window, err := parseRangeWindow(dateRange.From, dateRange.To, outZone)
+	if err != nil {
+		return fail(http.StatusBadRequest, err)
+	}
+	pf, _ := requestPointFormat(outZone, dateRange.Timestamps, dateRange.Points)
+
+	// Find CSV files in date range
+	cfg := requestConfig(r)
+	csvFiles, err := traceDiscover(r.Context(), cfg.csvDir(), window.fromDay, window.toDay)
+	if err != nil {
+		return fail(http.StatusInternalServerError, err)
+	}
+
+	// Annotations are read fresh rather than cached, so an edit shows on the
+	// next load without waiting for the CSVs to change.
+	annotations := annotationsBetween(cfg, window.from, window.to, outZone)
 
 	if len(csvFiles) == 0 {
 		// An empty range is a normal outcome (e.g. stepping to a day before
 		// collection started), not an error — return an empty result.
-		json.NewEncoder(w).Encode(GenerateResponse{
-			Success:  true,
-			Message:  fmt.Sprintf("No data for %s to %s", dateRange.From, dateRange.To),
-			Datasets: []Dataset{},
-		})
-		return
-	}
+		return GenerateResponse{
+			Success:     true,
+			Message:     fmt.Sprintf("No data for %s to %s", dateRange.From, dateRange.To),
+			Annotations: annotations,
+			Preferences: prefsFor(r, cfg),
+			Meta:        emptyMeta(dateRange, outZone),
+		}, nil, pf, http.StatusOK, nil
+	}
+	metrics := gymdata.NormalizeMetrics(dateRange.Metrics)
+
+	// Only misses that rewrite gym-data.json are audited.
+	auditParams := map[string]any{"from": dateRange.From, "to": dateRange.To, "metrics": metrics, "files": len(csvFiles)}
+	res, bucketMinutes, hit, err := buildRange(r.Context(), cfg, dateRange, csvFiles, outZone, write, nil)
+	if err != nil {
+		if write {
+			recordAudit(r, "generate-data-range", auditParams, err)
+		}
+		return fail(http.StatusInternalServerError, err)
+	}
+	output := fmt.Sprintf("Served %d files (%s to %s) from cache\nFound %d locations with data (bucket: %d min)",
+		len(csvFiles), dateRange.From, dateRange.To, len(res.list), bucketMinutes)
+	upToDate := write && hit && !res.wrote
+	switch {
+	case write && !hit:
+		recordAudit(r, "generate-data-range", auditParams, nil)
+		output = fmt.Sprintf("Successfully generated gym-data.json from %d files (%s to %s)\nFound %d locations with data (bucket: %d min)",
+			len(csvFiles), dateRange.From, dateRange.To, len(res.list), bucketMinutes)
+	case !hit:
+		output = fmt.Sprintf("Built %d files (%s to %s)\nFound %d locations with data (bucket: %d min)",
+			len(csvFiles), dateRange.From, dateRange.To, len(res.list), bucketMinutes)
+	}
+
+	meta := chartMeta(csvFiles, res.rows, res.list, bucketMinutes, res.built, hit, outZone)
+	meta.From, meta.To, meta.ResolutionMinutes = dateRange.From, dateRange.To, res.resolution
+	list := withFill(withDerived(withTotal(withShare(withAreas(withoutClosed(res.list, chartClosed(cfg, includeClosed)), rollUp, bucketMinutes), dateRange.Values, bucketMinutes), dateRange.Total, bucketMinutes), cfg, dateRange.Metrics, bucketMinutes), dateRange.Fill, bucketMinutes)
+	return GenerateResponse{
+		Success:     true,
+		Message:     "Date range data generated successfully",
+		Output:      output,
+		UpToDate:    upToDate,
+		Snapshot:    res.snapshot,
+		Rows:        res.rows,
+		Annotations: annotations,
+		Preferences: prefsFor(r, cfg),
+		Outliers:    res.outliers,
+		Meta:        meta,
+		Weather:     chartWeather(cfg, dateRange.Weather, list, bucketMinutes, pf),
+	}, list, pf, http.StatusOK, nil
+}
+
+// buildRange returns the chart series for a date range and its bucket size.
+// A range whose files are unchanged since the last build is served from
+// rangeCache (hit); otherwise the files are read and downsampled. With write
+// set the build is written to gym-data.json, unless it already holds it.
+// progress, if set, is called after each file.
+// useRollups says whether a range can be read from the pre-aggregated
+// rollups: they hold the headcount of whole days, bucketed in Tallinn and
+// unfiltered, at resolutions that must make up the bucket size.
+func useRollups(metrics []string, mode outlierMode, window rangeWindow, outZone *time.Location, bucketMinutes int) bool {
+	return len(metrics) == 1 && metrics[0] == gymdata.DefaultMetric && mode == outliersOff && !window.timed &&
+		outZone.String() == gymdata.Tallinn().String() && gymdata.RollupResolution(bucketMinutes) > 0
+}
 
+func buildRange(ctx context.Context, cfg *Config, dateRange DateRangeRequest, csvFiles []string, outZone *time.Location, write bool, progress func(files int, rows *gymdata.RowCounts)) (rangeResult, int, bool, error) {
 	// Compute newest modification time across the in-range files so the cache
 	// key auto-invalidates whenever any underlying file changes (e.g. today's
 	// still-growing file gets a new reading appended).
 	var maxMtime int64
 	for _, f := range csvFiles {
-		info, statErr := os.Stat(f)
+		info, statErr := gymdata.Stat(f)
 		if statErr != nil {
 			continue
 		}
-		if m := info.ModTime().Unix(); m > maxMtime {
+		if m := info.ModTime.Unix(); m > maxMtime {
 			maxMtime = m
 		}
 	}
-	key := dateRange.From + "|" + dateRange.To + "|" + strconv.FormatInt(maxMtime, 10)
+	metrics := loadMetrics(cfg, dateRange.Metrics)
+	mode, err := requestOutlierMode(cfg, dateRange.Outliers)
+	if err != nil {
+		return rangeResult{}, 0, false, err
+	}
+	key := cfg.DataDir + "|" + dateRange.From + "|" + dateRange.To + "|" + strings.Join(metrics, ",") + "|" + dateRange.TZ + "|" + strconv.FormatInt(maxMtime, 10) + "|" + mode.String() + "|" + strconv.Itoa(dateRange.MaxPoints)
 
-	bucketMinutes := 2
-	fromDate, fromErr := time.Parse("2006-01-02", dateRange.From)
-	toDate, toErr := time.Parse("2006-01-02", dateRange.To)
-	if fromErr == nil && toErr == nil {
-		bucketMinutes = pickBucketMinutes(fromDate, toDate.AddDate(0, 0, 1))
+	window, err := parseRangeWindow(dateRange.From, dateRange.To, outZone)
+	if err != nil {
+		return rangeResult{}, 0, false, err
 	}
+	bucketMinutes := window.bucketMinutes(dateRange.MaxPoints)
 
 	rangeCacheMu.Lock()
 	defer rangeCacheMu.Unlock()
 
-	// Cache HIT: serve the prebuilt datasets, skipping the CSV read, downsample
-	// and the gym-data.json write entirely.
-	if cached, ok := rangeCache[key]; ok {
-		output := fmt.Sprintf("Served %d files (%s to %s) from cache\nFound %d locations with data (bucket: %d min)",
-			len(csvFiles), dateRange.From, dateRange.To, len(cached), bucketMinutes)
-		json.NewEncoder(w).Encode(GenerateResponse{
-			Success:  true,
-			Message:  "Date range data generated successfully",
-			Output:   output,
-			Datasets: cached,
-		})
-		return
-	}
-
-	// Cache MISS: build from CSV files.
-	datasets, err := convertCSVFilesToJSON(csvFiles)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(GenerateResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to convert CSV files: %v", err),
-		})
-		return
+	// writeFile writes a build to gym-data.json, unless it still holds it
+	// from an earlier write, and says whether it did.
+	writeFile := func(list []*gymdata.Series) (bool, error) {
+		if !write || dataFileCurrent(cfg, key, time.Unix(maxMtime, 0)) {
+			return false, nil
+		}
+		_, span := startSpan(ctx, "write gym-data.json")
+		err := writeDataFile(cfg, key, list, outZone)
+		span.finish(err)
+		if err != nil {
+			return false, fmt.Errorf("Failed to write JSON: %v", err)
+		}
+		return true, nil
 	}
 
-	// Downsample wide ranges so the chart stays readable and fast
-	if fromErr == nil && toErr == nil {
-		datasets = downsampleDatasets(datasets, bucketMinutes)
+	// Cache HIT: serve the prebuilt datasets, skipping the CSV read and
+	// downsample; one a viewer built is written now if asked.
+	cached, ok := rangeCache[key]
+	countCache("range", ok)
+	if ok {
+		if cached.wrote, err = writeFile(cached.list); err != nil {
+			return rangeResult{}, bucketMinutes, true, err
+		}
+		return cached, bucketMinutes, true, nil
 	}
 
-	// Write to gym-data.json
-	jsonFile, err := os.Create("gym-data.json")
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(GenerateResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to create JSON file: %v", err),
-		})
-		return
-	}
-	defer jsonFile.Close()
+	// Cache MISS: build from the rollups when they hold these buckets, else
+	// from the CSV files.
+	var list []*gymdata.Series
+	var rows *gymdata.RowCounts
+	var report *OutlierReport
+	resolution := 0
+	if useRollups(metrics, mode, window, outZone, bucketMinutes) {
+		list, rows, resolution, err = traceRollups(ctx, cfg, csvFiles, bucketMinutes, progress)
+		if err != nil {
+			return rangeResult{}, bucketMinutes, false, withKind(ErrParse, fmt.Errorf("Failed to read rollups: %v", err))
+		}
+	} else {
+		list, rows, err = traceLoad(ctx, cfg, csvFiles, metrics, progress)
+		if err != nil {
+			return rangeResult{}, bucketMinutes, false, withKind(ErrParse, fmt.Errorf("Failed to convert CSV files: %v", err))
+		}
+		_, span := startSpan(ctx, "bucket")
+		list, report = filterOutliers(window.cut(list), mode, cfg, outZone)
 
-	encoder := json.NewEncoder(jsonFile)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(datasets); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(GenerateResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to write JSON: %v", err),
-		})
-		return
+		// Downsample wide ranges so the chart stays readable and fast. Buckets
+		// align to midnight in the zone the client reads timestamps in.
+		gymdata.Bucket(list, bucketMinutes, outZone)
+		span.set("bucketMinutes", bucketMinutes)
+		span.finish(nil)
 	}
 
 	// Store in the cache under the mtime-keyed entry. Bound growth with a simple
 	// reset since keys accumulate across ranges and data mutations.
 	if len(rangeCache) > 64 {
-		rangeCache = map[string][]Dataset{}
+		rangeCache = map[string]rangeResult{}
 	}
-	rangeCache[key] = datasets
-
-	// Success response
-	output := fmt.Sprintf("Successfully generated gym-data.json from %d files (%s to %s)\nFound %d locations with data (bucket: %d min)",
-		len(csvFiles), dateRange.From, dateRange.To, len(datasets), bucketMinutes)
-
-	json.NewEncoder(w).Encode(GenerateResponse{
-		Success:  true,
-		Message:  "Date range data generated successfully",
-		Output:   output,
-		Datasets: datasets,
+	wrote, err := writeFile(list)
+	if err != nil {
+		return rangeResult{}, bucketMinutes, false, err
+	}
+	res := rangeResult{list: list, rows: rows, outliers: report, built: time.Now(), key: key, resolution: resolution}
+	if rangeClosed(window, time.Now()) {
+		if res.snapshot, err = writeSnapshot(cfg, list, outZone); err != nil {
+			log.Printf("Snapshot %s..%s: %v", dateRange.From, dateRange.To, err)
+		}
+	}
+	rangeCache[key] = res
+	verifyShadow(cfg, "generate-data-range", window.fromDay, window.toDay, metrics, list, func(shadow []*gymdata.Series) []*gymdata.Series {
+		shadow, _ = filterOutliers(window.cut(shadow), mode, cfg, outZone)
+		gymdata.Bucket(shadow, bucketMinutes, outZone)
+		return shadow
 	})
+	res.wrote = wrote
+	return res, bucketMinutes, false, nil
+}
+
+// windowFiles lists the daily CSVs that can hold readings in [from, to):
+// the Tallinn days from from's through the last one's, and one more either
+// side, since rows near midnight can sit in the neighbouring day's file.
+// With no CSVs at all there are none.
+func windowFiles(cfg *Config, from, to time.Time) ([]string, error) {
+	tallinn := gymdata.Tallinn()
+	last := to.Add(-time.Nanosecond).In(tallinn)
+	files, err := gymdata.InRange(cfg.csvDir(), from.In(tallinn).AddDate(0, 0, -1).Format("2006-01-02"), last.AddDate(0, 0, 1).Format("2006-01-02"))
+	if errors.Is(err, gymdata.ErrNoFiles) {
+		return nil, nil
+	}
+	return files, err
+}
+
+// loadWindow reads metrics over [from, to) from windowFiles, trimmed to
+// the window and without closed gyms.
+func loadWindow(cfg *Config, from, to time.Time, metrics []string) ([]*gymdata.Series, error) {
+	files, err := windowFiles(cfg, from, to)
+	if err != nil {
+		return nil, err
+	}
+	list, _, err := gymdata.Load(cfg.format(), files, metrics)
+	if err != nil {
+		return nil, err
+	}
+	list = gymdata.Window(list, from.Unix(), to.Unix())
+	return withoutClosed(list, closedLocations(cfg)), nil
 }
 
 func downloadCSVsHandler(w http.ResponseWriter, r *http.Request) {
 	// Enable CORS
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	setCORS(w, r, "GET, OPTIONS")
 
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
@@ -1012,7 +1141,7 @@ func downloadCSVsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Find all CSV files
-	files, err := filepath.Glob("gym-stats-*.csv")
+	files, err := gymdata.ListFiles(requestConfig(r).csvDir())
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Error finding CSV files"))
@@ -1036,6 +1165,12 @@ func downloadCSVsHandler(w http.ResponseWriter, r *http.Request) {
 		now.Minute(),
 		now.Second())
 
+	// Zipping every file can outlast the server's write timeout; it gets a
+	// heavy request's time.
+	if d := currentConfig().HeavyTimeout; d > 0 {
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d))
+	}
+
 	// Set headers for ZIP download
 	w.Header().Set("Content-Type", "application/zip")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
@@ -1054,26 +1189,21 @@ func downloadCSVsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// addFileToZip stores filePath under its base name. Gzipped days go in
+// decompressed, so the archive is plain CSVs however the server keeps them.
 func addFileToZip(zipWriter *zip.Writer, filePath string) error {
-	file, err := os.Open(filePath)
+	info, err := gymdata.Stat(filePath)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	// Get file info for the header
-	info, err := file.Stat()
+	file, err := gymdata.Open(filePath)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
 	// Create ZIP file header
-	header, err := zip.FileInfoHeader(info)
-	if err != nil {
-		return err
-	}
-	header.Name = filepath.Base(filePath)
-	header.Method = zip.Deflate
+	header := &zip.FileHeader{Name: gymdata.BaseName(filePath), Method: zip.Deflate, Modified: info.ModTime}
 
 	// Create the file in the ZIP
 	writer, err := zipWriter.CreateHeader(header)
@@ -1086,11 +1216,29 @@ func addFileToZip(zipWriter *zip.Writer, filePath string) error {
 	return err
 }
 
+// setCORS writes the CORS headers for a response. With CORS_ORIGINS unset any
+// origin is allowed; otherwise only listed origins are echoed back.
+func setCORS(w http.ResponseWriter, r *http.Request, methods string) {
+	origins := requestConfig(r).CORSOrigins
+	if len(origins) == 0 {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		for _, o := range origins {
+			if o == "*" || o == origin {
+				w.Header().Set("Access-Control-Allow-Origin", o)
+				break
+			}
+		}
+	}
+	w.Header().Set("Access-Control-Allow-Methods", methods)
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Tenant, X-Client-ID")
+}
+
 func corsHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		setCORS(w, r, "GET, POST, OPTIONS")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -1102,30 +1250,226 @@ func corsHandler(next http.Handler) http.Handler {
 }
 
 func main() {
-	port := "8002"
-	if len(os.Args) > 1 {
-		port = os.Args[1]
+	demo := flag.Bool("demo", false, "serve simulated readings for a few made-up gyms from a temporary directory, ignoring gym-config.env")
+	flag.Parse()
+	if err := setupLogging(os.Getenv("LOG_FORMAT")); err != nil {
+		log.Fatal(err)
+	}
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8002"
+	}
+	if flag.NArg() > 0 {
+		port = flag.Arg(0)
+	}
+	if port == "vapid-keys" {
+		key, err := newVAPIDKey()
+		if err != nil {
+			log.Fatal("VAPID: ", err)
+		}
+		fmt.Printf("VAPID_PRIVATE_KEY=%s\n", key)
+		fmt.Printf("VAPID_SUBJECT=mailto:you@example.com\n")
+		return
+	}
+
+	var loaded *Config
+	var err error
+	if *demo {
+		var spec fixtures.Spec
+		if loaded, spec, err = startDemo(time.Now()); err != nil {
+			log.Fatal("Demo: ", err)
+		}
+		demoMode = true
+		log.Printf("Demo: simulating %d gyms in %s", len(spec.Locations), loaded.DataDir)
+		go runDemo(loaded, spec, time.NewTicker(demoInterval).C)
+	} else if loaded, err = loadConfig(); err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	loaded.registerSources()
+	if port == "demo" && !*demo {
+		files, err := seedDemo(loaded, flag.Args()[1:], time.Now())
+		if err != nil {
+			log.Fatal("Demo: ", err)
+		}
+		fmt.Printf("Wrote %d days of sample data (%s .. %s)\n", len(files), filepath.Base(files[0]), filepath.Base(files[len(files)-1]))
+		fmt.Printf("Start the server here with ./gym-server and open http://localhost:8002/dashboard.html\n")
+		return
+	}
+	if port == "repair" {
+		if err := runRepair(loaded, flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatal("Repair: ", err)
+		}
+		return
+	}
+	if port == "report" {
+		if err := runReport(loaded, flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatal("Report: ", err)
+		}
+		return
 	}
+	setConfig(loaded)
+
+	if len(loaded.APIKeys) == 0 {
+		log.Printf("Auth: no API_KEYS configured, all endpoints are open")
+	}
+	for name, t := range loaded.Tenants {
+		log.Printf("Tenant %s: data in %s", name, t.DataDir)
+	}
+
+	// INTEGRITY_CHECK=strict checks the data before anything else starts.
+	if err := startIntegrityCheck(loaded); err != nil {
+		log.Fatal("Integrity: ", err)
+	}
+
+	// Background integrations pick up config changes on their next cycle and
+	// idle while unconfigured, so a reload can switch them on or off.
+	go runMQTTPublisher()
+	go runTelegramBot()
+	resumeIngest(loaded)
+	resumeJobs(loaded)
+	restoreParseCache(loaded)
+	startPreload(loaded, time.Now())
+	go runLatest()
+	go runWatcher()
+	go runRollover()
+	go runTraceExporter()
+	go runReplica()
+	go runFreshnessAlerts()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go watchReloadSignal(hup)
+
+	// Routes live on our own mux: net/http/pprof registers itself on the
+	// default one, which must not be reachable without the admin role.
+	mux := http.NewServeMux()
 
 	// Static file server
-	fs := http.FileServer(http.Dir("."))
-	http.Handle("/", corsHandler(fs))
-
-	// Data generation endpoints
-	http.HandleFunc("/generate-data", generateDataHandler)
-	http.HandleFunc("/generate-data-range", generateDataRangeHandler)
-	http.HandleFunc("/download-csvs", downloadCSVsHandler)
-	http.HandleFunc("/busyness-data", busynessDataHandler)
-	http.HandleFunc("/status", statusHandler)
-
-	fmt.Printf("Server running at http://localhost:%s/\n", port)
-	fmt.Printf("Dashboard: http://localhost:%s/dashboard.html\n", port)
-	fmt.Printf("Busyness: http://localhost:%s/busyness.html\n", port)
-	fmt.Printf("Generate data: POST to http://localhost:%s/generate-data\n", port)
-	fmt.Printf("Generate data range: POST to http://localhost:%s/generate-data-range\n", port)
-	fmt.Printf("Download CSVs: GET http://localhost:%s/download-csvs\n", port)
-
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatal("Server failed to start:", err)
+	mux.Handle("/", corsHandler(staticFiles()))
+	mux.HandleFunc("/manifest.json", webManifestHandler) // generated, in place of WEB_DIR's
+	mux.HandleFunc("/data/", requireRole(RoleViewer, snapshotHandler))
+	mux.HandleFunc("/readyz", readyHandler)         // probes carry no key
+	mux.HandleFunc("/auth/login", oidcLoginHandler) // signing in needs no key
+	mux.HandleFunc("/auth/callback", oidcCallbackHandler)
+	mux.HandleFunc("/auth/logout", oidcLogoutHandler)
+	mux.HandleFunc("/api/shared", withAdmission(sharedHandler)) // the link is the grant
+
+	// Data generation endpoints. /api/range is how the dashboard reads chart
+	// data, so viewers may call it; writing gym-data.json, from a range or
+	// today's file, is admin-only.
+	// Those that parse a range of CSVs go through withAdmission, so only
+	// MAX_HEAVY_REQUESTS of them hold their data in memory at once.
+	mux.HandleFunc("/generate-data", requireRole(RoleAdmin, withAdmission(withHistory("/generate-data", generateDataHandler))))
+	mux.HandleFunc("/generate-data-range", requireRole(RoleAdmin, withAdmission(withHistory("/generate-data-range", generateDataRangeHandler))))
+	mux.HandleFunc("/api/range", requireRole(RoleViewer, withAdmission(withHistory("/api/range", rangeHandler))))
+	mux.HandleFunc("/download-csvs", requireRole(RoleViewer, downloadCSVsHandler))
+	mux.HandleFunc("/busyness-data", requireRole(RoleViewer, withAdmission(busynessDataHandler)))
+	mux.HandleFunc("/status", requireRole(RoleViewer, statusHandler))
+	mux.HandleFunc("/api/stream", requireRole(RoleViewer, streamHandler))
+	mux.HandleFunc("/api/live", requireRole(RoleViewer, liveHandler))
+	mux.HandleFunc("/api/recommendations", requireRole(RoleViewer, withAdmission(recommendationsHandler)))
+	mux.HandleFunc("/api/quiet.ics", requireRole(RoleViewer, withAdmission(quietCalendarHandler)))
+	mux.HandleFunc("/api/metrics", requireRole(RoleViewer, metricListHandler))
+	mux.HandleFunc("/api/presets", requireRole(RoleViewer, presetsHandler))
+	mux.HandleFunc("/api/manifest", requireRole(RoleViewer, manifestHandler))
+	mux.HandleFunc("/api/days", requireRole(RoleViewer, daysHandler))
+	mux.HandleFunc("/api/day/", requireRole(RoleViewer, withAdmission(dayHandler)))
+	mux.HandleFunc("/api/config", requireRole(RoleViewer, dashboardConfigHandler))
+	mux.HandleFunc("/api/quality", requireRole(RoleViewer, withAdmission(qualityHandler)))
+	mux.HandleFunc("/api/slo", requireRole(RoleViewer, withAdmission(sloHandler)))
+	mux.HandleFunc("/api/records", requireRole(RoleViewer, withAdmission(recordsHandler)))
+	mux.HandleFunc("/api/skew", requireRole(RoleViewer, skewHandler))
+	mux.HandleFunc("/api/recent", requireRole(RoleViewer, withAdmission(withHistory("/api/recent", recentHandler))))
+	mux.HandleFunc("/api/widget/", requireRole(RoleViewer, widgetHandler))
+	mux.HandleFunc("/api/mobile", requireRole(RoleViewer, withAdmission(mobileHandler)))
+	mux.HandleFunc("/api/bands", requireRole(RoleViewer, withAdmission(bandsHandler)))
+	mux.HandleFunc("/api/rate", requireRole(RoleViewer, withAdmission(withHistory("/api/rate", rateHandler))))
+	mux.HandleFunc("/api/profile", requireRole(RoleViewer, withAdmission(profileHandler)))
+	mux.HandleFunc("/api/popular-times", requireRole(RoleViewer, withAdmission(popularTimesHandler)))
+	mux.HandleFunc("/api/popular-times/", requireRole(RoleViewer, withAdmission(popularTimesHandler)))
+	mux.HandleFunc("/api/usual", requireRole(RoleViewer, withAdmission(usualHandler)))
+	mux.HandleFunc("/api/weeks", requireRole(RoleViewer, withAdmission(weeksHandler)))
+	mux.HandleFunc("/api/report", requireRole(RoleViewer, withAdmission(reportHandler)))
+	mux.HandleFunc("/api/calendar", requireRole(RoleViewer, withAdmission(calendarHandler)))
+	mux.HandleFunc("/api/weather", requireRole(RoleViewer, withAdmission(weatherHandler)))
+	mux.HandleFunc("/api/jobs/", requireRole(RoleViewer, jobsHandler))
+	mux.HandleFunc("/api/diff", requireRole(RoleViewer, withAdmission(diffHandler))) // POST only reads the body
+	mux.HandleFunc("/api/prefs", requireRole(RoleViewer, prefsHandler))
+	mux.HandleFunc("/api/goals", requireRole(RoleViewer, goalsHandler))
+	mux.HandleFunc("/api/push", requireRole(RoleViewer, pushHandler))
+	mux.HandleFunc("/api/push/", requireRole(RoleViewer, pushHandler))
+	mux.HandleFunc("/api/goals/", requireRole(RoleViewer, goalsHandler))
+	mux.HandleFunc("/api/annotations", annotationsHandler) // viewers read, admins write
+	mux.HandleFunc("/api/annotations/", annotationsHandler)
+	mux.HandleFunc("/api/locations/closed", closedHandler) // viewers read, admins write
+	mux.HandleFunc("/api/ingest", requireRole(RoleAdmin, ingestHandler))
+	mux.HandleFunc("/api/ingest/rejected", requireRole(RoleAdmin, ingestRejectedHandler))
+	mux.HandleFunc("/api/import/sheets", requireRole(RoleAdmin, sheetsImportHandler))
+	mux.HandleFunc("/api/share-links", requireRole(RoleAdmin, shareLinksHandler))
+	mux.HandleFunc("/api/admin/audit", requireRole(RoleAdmin, auditHandler))
+	mux.HandleFunc("/api/history", requireRole(RoleAdmin, historyHandler)) // shows who asked
+	mux.HandleFunc("/api/admin/reload", requireRole(RoleAdmin, reloadHandler))
+	mux.HandleFunc("/api/admin/shadow", requireRole(RoleAdmin, shadowHandler))
+	mux.HandleFunc("/api/admin/replica", requireRole(RoleAdmin, replicaHandler))
+	mux.HandleFunc("/api/admin/locations/merge", requireRole(RoleAdmin, mergeLocationsHandler))
+	mux.HandleFunc("/api/admin/corrections", requireRole(RoleAdmin, correctionsHandler))
+
+	// Profiling, for diagnosing slow parsing or aggregation in production
+	mux.HandleFunc("/debug/pprof/", requireRole(RoleAdmin, pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", requireRole(RoleAdmin, pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", requireRole(RoleAdmin, pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", requireRole(RoleAdmin, pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", requireRole(RoleAdmin, pprof.Trace))
+	mux.HandleFunc("/metrics", requireRole(RoleViewer, metricsHandler))
+
+	listener, err := upgradeListener()
+	if err != nil {
+		log.Fatal("Upgrade: ", err)
+	}
+	passed := "Upgrade: serving on the old process's socket %s"
+	if listener == nil {
+		if listener, err = systemdListener(); err != nil {
+			log.Fatal("systemd: ", err)
+		}
+		passed = "systemd: serving on the passed socket %s"
+	}
+	if listener == nil {
+		if listener, err = net.Listen("tcp", ":"+port); err != nil {
+			log.Fatal("Server failed to start:", err)
+		}
+	} else {
+		log.Printf(passed, listener.Addr())
+		if _, p, err := net.SplitHostPort(listener.Addr().String()); err == nil {
+			port = p
+		}
+	}
+
+	if jsonLogs {
+		log.Printf("Server listening on port %s", port)
+	} else {
+		fmt.Printf("Server running at http://localhost:%s/\n", port)
+		fmt.Printf("Dashboard: http://localhost:%s/dashboard.html\n", port)
+		fmt.Printf("Busyness: http://localhost:%s/busyness.html\n", port)
+		fmt.Printf("Generate data: POST to http://localhost:%s/generate-data\n", port)
+		fmt.Printf("Generate data range: POST to http://localhost:%s/generate-data-range\n", port)
+		fmt.Printf("Download CSVs: GET http://localhost:%s/download-csvs\n", port)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt, syscall.SIGUSR2)
+	go notifyReady()
+	srv := &http.Server{
+		Handler:           withAccessLog(withTenant(withTracing(mux, withMetrics(mux)))),
+		ReadHeaderTimeout: loaded.ReadHeaderTimeout,
+		ReadTimeout:       loaded.ReadTimeout,
+		WriteTimeout:      loaded.WriteTimeout,
+		IdleTimeout:       loaded.IdleTimeout,
+		MaxHeaderBytes:    loaded.MaxHeaderBytes,
+	}
+	srv.RegisterOnShutdown(func() { close(shuttingDown) })
+	err = serve(srv, listener, stop)
+	saveParseCache(currentConfig())
+	if err != nil {
+		log.Fatal("Server failed:", err)
 	}
 }
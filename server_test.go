@@ -1,9 +1,20 @@
 package main
 
 import (
-	"math"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
+
+	"gym/internal/gymdata"
 )
 
 func loadTallinn(t *testing.T) *time.Location {
@@ -15,229 +26,316 @@ func loadTallinn(t *testing.T) *time.Location {
 	return loc
 }
 
-func TestBusynessLocalTime(t *testing.T) {
+// testSeries builds a one-series list from ISO timestamps and values.
+func testSeries(t *testing.T, points ...any) []*gymdata.Series {
+	t.Helper()
+	s := &gymdata.Series{Key: gymdata.Key{Location: "gym", Metric: gymdata.DefaultMetric}}
+	for i := 0; i < len(points); i += 2 {
+		at, err := time.Parse("2006-01-02T15:04:05Z07:00", points[i].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Points = append(s.Points, gymdata.Point{At: at.Unix(), Y: float64(points[i+1].(int))})
+	}
+	return []*gymdata.Series{s}
+}
+
+// decodeSeries runs the streaming encoder and decodes its output, so tests
+// see exactly what clients receive.
+func decodeSeries(t *testing.T, list []*gymdata.Series, loc *time.Location) []Dataset {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := writeDatasets(&buf, list, pointFormat{loc: loc}, false); err != nil {
+		t.Fatal(err)
+	}
+	var out []Dataset
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("invalid JSON %q: %v", buf.String(), err)
+	}
+	return out
+}
+
+func TestWriteDatasetsMatchesEncodingJSON(t *testing.T) {
 	tallinn := loadTallinn(t)
+	list := testSeries(t, "2025-10-01T10:00:00+03:00", 6, "2025-10-01T10:02:00+03:00", 7)
+	list = append(list, &gymdata.Series{Key: gymdata.Key{Location: "Kristiine <&>", Metric: "queue_length"}})
+	list[0].Points[1].Y = 0.25
+	want := []Dataset{
+		{Label: "gym", Metric: "user_count", Data: []DataPoint{
+			{X: "2025-10-01T10:00:00+03:00", Y: 6},
+			{X: "2025-10-01T10:02:00+03:00", Y: 0.25},
+		}},
+		{Label: "Kristiine <&> (queue_length)", Metric: "queue_length", Data: []DataPoint{}},
+	}
 
-	t.Run("UTC summer converts to UTC+3", func(t *testing.T) {
-		got, ok := busynessLocalTime("2025-07-01 09:00:00", "UTC", tallinn)
-		if !ok {
-			t.Fatal("expected ok == true")
+	for _, indent := range []bool{false, true} {
+		var got, exp bytes.Buffer
+		if err := writeDatasets(&got, list, pointFormat{loc: tallinn}, indent); err != nil {
+			t.Fatal(err)
 		}
-		if got.Hour() != 12 {
-			t.Errorf("hour = %d, want 12", got.Hour())
+		enc := json.NewEncoder(&exp)
+		if indent {
+			enc.SetIndent("", "  ")
 		}
-		wantInstant := time.Date(2025, 7, 1, 9, 0, 0, 0, time.UTC)
-		if !got.Equal(wantInstant) {
-			t.Errorf("instant = %v, want %v", got.UTC(), wantInstant)
+		enc.Encode(want)
+		if got.String()+"\n" != exp.String() {
+			t.Errorf("indent=%v:\ngot  %s\nwant %s", indent, got.String(), exp.String())
 		}
-	})
+	}
 
-	t.Run("UTC winter converts to UTC+2", func(t *testing.T) {
-		got, ok := busynessLocalTime("2025-12-01 09:00:00", "UTC", tallinn)
-		if !ok {
-			t.Fatal("expected ok == true")
-		}
-		if got.Hour() != 11 {
-			t.Errorf("hour = %d, want 11", got.Hour())
-		}
-	})
+	if got, want := string(jsonFloat(nil, 1e-7)), "1e-7"; got != want {
+		t.Errorf("jsonFloat(1e-7) = %q, want %q", got, want)
+	}
+}
 
-	t.Run("EEST wall-clock preserved as local", func(t *testing.T) {
-		got, ok := busynessLocalTime("2026-07-18 16:35:39", "EEST", tallinn)
-		if !ok {
-			t.Fatal("expected ok == true")
-		}
-		if got.Hour() != 16 || got.Minute() != 35 {
-			t.Errorf("wall-clock = %02d:%02d, want 16:35", got.Hour(), got.Minute())
-		}
-		if got.Location() != tallinn {
-			t.Errorf("location = %v, want Europe/Tallinn", got.Location())
-		}
-	})
+func TestWriteGenerateResponse(t *testing.T) {
+	var buf bytes.Buffer
+	list := testSeries(t, "2025-10-01T10:00:00+03:00", 6)
+	if err := writeGenerateResponse(&buf, GenerateResponse{Success: true, Message: "ok"}, list, pointFormat{loc: time.UTC}); err != nil {
+		t.Fatal(err)
+	}
+	var resp GenerateResponse
+	if err := json.Unmarshal(buf.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON %q: %v", buf.String(), err)
+	}
+	if !resp.Success || resp.Message != "ok" || len(resp.Datasets) != 1 || resp.Datasets[0].Data[0].X != "2025-10-01T07:00:00Z" {
+		t.Errorf("got %+v", resp)
+	}
+}
 
-	t.Run("EET wall-clock preserved as local", func(t *testing.T) {
-		got, ok := busynessLocalTime("2025-12-01 09:00:00", "EET", tallinn)
-		if !ok {
-			t.Fatal("expected ok == true")
-		}
-		if got.Hour() != 9 {
-			t.Errorf("hour = %d, want 9 (wall-clock preserved)", got.Hour())
-		}
-	})
+// flushRecorder notes how much had been written at each Flush.
+type flushRecorder struct {
+	bytes.Buffer
+	flushes []string
+}
 
-	t.Run("empty tz treated as UTC", func(t *testing.T) {
-		got, ok := busynessLocalTime("2025-07-01 09:00:00", "", tallinn)
-		if !ok {
-			t.Fatal("expected ok == true")
-		}
-		if got.Hour() != 12 {
-			t.Errorf("hour = %d, want 12", got.Hour())
-		}
-	})
-
-	t.Run("GMT and Z aliases and case-insensitivity", func(t *testing.T) {
-		for _, tz := range []string{"GMT", "Z", "  utc  ", "z"} {
-			got, ok := busynessLocalTime("2025-07-01 09:00:00", tz, tallinn)
-			if !ok {
-				t.Fatalf("tz %q: expected ok == true", tz)
-			}
-			if got.Hour() != 12 {
-				t.Errorf("tz %q: hour = %d, want 12", tz, got.Hour())
-			}
-		}
-	})
+func (f *flushRecorder) Flush() { f.flushes = append(f.flushes, f.String()) }
+
+func TestWriteDatasetsFlushesPerLocation(t *testing.T) {
+	list := testSeries(t, "2025-10-01T10:00:00+03:00", 6)
+	list = append(list,
+		&gymdata.Series{Key: gymdata.Key{Location: "gym", Metric: "queue_length"}},
+		&gymdata.Series{Key: gymdata.Key{Location: "T1", Metric: "user_count"}})
+	var w flushRecorder
+	if err := writeGenerateResponse(&w, GenerateResponse{Success: true}, list, pointFormat{loc: time.UTC}); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.flushes) != 2 {
+		t.Fatalf("%d flushes, want one per location: %q", len(w.flushes), w.flushes)
+	}
+	if !strings.HasSuffix(w.flushes[0], `"label":"gym (queue_length)","metric":"queue_length","data":[]}`) || !strings.HasPrefix(w.flushes[0], `{"success":true`) {
+		t.Errorf("first flush %q, want up to the end of gym's datasets", w.flushes[0])
+	}
+	if !strings.HasSuffix(w.flushes[1], `"label":"T1","metric":"user_count","data":[]}`) {
+		t.Errorf("second flush %q", w.flushes[1])
+	}
+	var resp GenerateResponse
+	if err := json.Unmarshal(w.Bytes(), &resp); err != nil || len(resp.Datasets) != 3 {
+		t.Errorf("%s: %v", w.String(), err)
+	}
+}
 
-	t.Run("invalid timestamp returns false", func(t *testing.T) {
-		got, ok := busynessLocalTime("not-a-timestamp", "UTC", tallinn)
-		if ok {
-			t.Errorf("expected ok == false, got %v", got)
+func TestWriteDatasetsMillis(t *testing.T) {
+	pf, err := requestPointFormat(time.UTC, "ms", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := writeDatasets(&buf, testSeries(t, "2025-10-01T10:00:00+03:00", 6), pf, false); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `[{"label":"gym","metric":"user_count","data":[{"x":1759302000000,"y":6}]}]`; got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	for _, ok := range []string{"", "iso", " MS "} {
+		if _, err := requestPointFormat(time.UTC, ok, ok); err == nil && ok != "" {
+			t.Errorf("%q accepted as both options", ok)
 		}
-		if !got.IsZero() {
-			t.Errorf("expected zero time, got %v", got)
+		if _, err := requestPointFormat(time.UTC, ok, ""); err != nil {
+			t.Errorf("%q: %v", ok, err)
 		}
-	})
+	}
+	if _, err := requestPointFormat(time.UTC, "unix", ""); err == nil {
+		t.Error("unix accepted")
+	}
 }
 
-func TestPickBucketMinutes(t *testing.T) {
-	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
-
-	t.Run("one day span stays raw", func(t *testing.T) {
-		got := pickBucketMinutes(base, base.AddDate(0, 0, 1))
-		if got != 2 {
-			t.Errorf("got %d, want 2", got)
+func TestWriteDatasetsPairs(t *testing.T) {
+	list := testSeries(t, "2025-10-01T10:00:00+03:00", 6, "2025-10-01T10:10:00+03:00", 90)
+	list[0].Flagged = []int64{list[0].Points[1].At}
+	tallinn := loadTallinn(t)
+	for _, tc := range []struct{ timestamps, want string }{
+		{"", `[{"label":"gym","metric":"user_count","data":[["2025-10-01T10:00:00+03:00",6],["2025-10-01T10:10:00+03:00",90]],"flagged":["2025-10-01T10:10:00+03:00"]}]`},
+		{"ms", `[{"label":"gym","metric":"user_count","data":[[1759302000000,6],[1759302600000,90]],"flagged":[1759302600000]}]`},
+	} {
+		pf, err := requestPointFormat(tallinn, tc.timestamps, "pairs")
+		if err != nil {
+			t.Fatal(err)
 		}
-	})
-
-	t.Run("31 day span picks a mid ladder value", func(t *testing.T) {
-		got := pickBucketMinutes(base, base.AddDate(0, 0, 31))
-		if got <= 2 || got > 120 {
-			t.Errorf("got %d, want in (2, 120]", got)
+		var buf bytes.Buffer
+		if err := writeDatasets(&buf, list, pf, false); err != nil {
+			t.Fatal(err)
 		}
-		if got != 60 {
-			t.Errorf("got %d, want 60", got)
+		if got := buf.String(); got != tc.want {
+			t.Errorf("timestamps %q:\ngot  %s\nwant %s", tc.timestamps, got, tc.want)
 		}
-	})
+	}
+}
 
-	t.Run("95 day span picks 120", func(t *testing.T) {
-		got := pickBucketMinutes(base, base.AddDate(0, 0, 95))
-		if got != 120 {
-			t.Errorf("got %d, want 120", got)
-		}
-	})
+func writeCSV(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
 
-	t.Run("five year span caps at 1440", func(t *testing.T) {
-		got := pickBucketMinutes(base, base.AddDate(5, 0, 0))
-		if got != 1440 {
-			t.Errorf("got %d, want 1440", got)
-		}
-	})
+// monthSeries builds days of collector-shaped series (4 gyms every 2 minutes)
+// in memory, so encoder benchmarks don't measure parsing.
+func monthSeries(days int) []*gymdata.Series {
+	start := time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)
+	var list []*gymdata.Series
+	for _, g := range []string{"Hipodroom", "Kristiine", "Mustika", "Ülemiste"} {
+		s := &gymdata.Series{Key: gymdata.Key{Location: g, Metric: gymdata.DefaultMetric}}
+		for m := 0; m < days*24*60; m += 2 {
+			at := start.Add(time.Duration(m) * time.Minute).Unix()
+			s.Points = append(s.Points, gymdata.Point{At: at, Y: float64(m % 50)})
+		}
+		list = append(list, s)
+	}
+	return list
+}
 
-	t.Run("negative span returns 2", func(t *testing.T) {
-		got := pickBucketMinutes(base.AddDate(0, 0, 1), base)
-		if got != 2 {
-			t.Errorf("got %d, want 2", got)
+func BenchmarkWriteDatasetsMonth(b *testing.B) {
+	list := monthSeries(30)
+	tallinn := gymdata.Tallinn()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := writeDatasets(io.Discard, list, pointFormat{loc: tallinn}, false); err != nil {
+			b.Fatal(err)
 		}
-	})
+	}
+}
 
-	t.Run("zero span returns 2", func(t *testing.T) {
-		got := pickBucketMinutes(base, base)
-		if got != 2 {
-			t.Errorf("got %d, want 2", got)
-		}
-	})
+func TestGenerateDataUpToDate(t *testing.T) {
+	loadTallinn(t)
+	dir := t.TempDir()
+	file := writeCSV(t, dir, "gym-stats-20251001.csv", "timestamp,timezone,location_id,location_name,user_count,status,response\n"+
+		"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,{}\n")
+	old := currentConfig()
+	defer setConfig(old)
+	setConfig(&Config{DataDir: dir, AuditLog: "gym-audit.jsonl", AnnotationsFile: "gym-annotations.json", PrefsFile: "gym-prefs.json"})
+
+	generate := func() GenerateResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		generateDataHandler(w, httptest.NewRequest("POST", "/generate-data", nil))
+		var resp GenerateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || !resp.Success || len(resp.Datasets) != 1 {
+			t.Fatalf("generate: %d %+v %v", w.Code, resp, err)
+		}
+		return resp
+	}
+	if resp := generate(); resp.UpToDate {
+		t.Error("first build reported up to date")
+	}
+	if resp := generate(); !resp.UpToDate || resp.Message != "Already up to date" {
+		t.Errorf("repeat = %q, upToDate %v", resp.Message, resp.UpToDate)
+	}
+
+	// A removed gym-data.json, or a new reading, means building it again.
+	os.Remove(filepath.Join(dir, "gym-data.json"))
+	if resp := generate(); resp.UpToDate {
+		t.Error("missing gym-data.json reported up to date")
+	}
+	f, _ := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString("2025-10-01 10:02:00,EEST,1,Hipodroom,13,success,{}\n")
+	f.Close()
+	if resp := generate(); resp.UpToDate || len(resp.Datasets[0].Data) != 2 {
+		t.Errorf("after a new reading: upToDate %v, %d points", resp.UpToDate, len(resp.Datasets[0].Data))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "gym-data.json")); err != nil {
+		t.Error(err)
+	}
 }
 
-func TestDownsampleDatasets(t *testing.T) {
-	t.Run("bucketMinutes<=2 returns input unchanged", func(t *testing.T) {
-		in := []Dataset{{
-			Label: "gym",
-			Data: []DataPoint{
-				{X: "2025-10-01T10:00:00+03:00", Y: 6},
-				{X: "2025-10-01T10:20:00+03:00", Y: 9},
-			},
-		}}
-		for _, bm := range []int{0, 1, 2} {
-			out := downsampleDatasets(in, bm)
-			if len(out) != len(in) {
-				t.Fatalf("bm=%d: len = %d, want %d", bm, len(out), len(in))
-			}
-			if len(out[0].Data) != len(in[0].Data) {
-				t.Errorf("bm=%d: points = %d, want %d", bm, len(out[0].Data), len(in[0].Data))
-			}
-		}
-	})
-
-	t.Run("60 minute buckets average and preserve order", func(t *testing.T) {
-		in := []Dataset{{
-			Label: "gym",
-			Data: []DataPoint{
-				{X: "2025-10-01T10:00:00+03:00", Y: 6},
-				{X: "2025-10-01T10:20:00+03:00", Y: 9},
-				{X: "2025-10-01T10:40:00+03:00", Y: 12},
-				{X: "2025-10-01T11:10:00+03:00", Y: 4},
-			},
-		}}
-		out := downsampleDatasets(in, 60)
-		if len(out) != 1 {
-			t.Fatalf("datasets = %d, want 1", len(out))
-		}
-		pts := out[0].Data
-		if len(pts) != 2 {
-			t.Fatalf("points = %d, want 2", len(pts))
-		}
+func TestRangeWriteIsAdminOnly(t *testing.T) {
+	loadTallinn(t)
+	dir := t.TempDir()
+	writeCSV(t, dir, "gym-stats-20251001.csv", "timestamp,timezone,location_id,location_name,user_count,status,response\n"+
+		"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,{}\n")
+	keys, _ := parseAPIKeys("alice:admintoken:admin,tv:viewtoken:viewer")
+	old := currentConfig()
+	defer setConfig(old)
+	setConfig(&Config{DataDir: dir, APIKeys: keys, AuditLog: "gym-audit.jsonl", AnnotationsFile: "gym-annotations.json", PrefsFile: "gym-prefs.json"})
 
-		if got, want := pts[0].X, "2025-10-01T10:00:00+03:00"; got != want {
-			t.Errorf("pts[0].X = %q, want %q", got, want)
-		}
-		if math.Abs(pts[0].Y-9) > 1e-9 {
-			t.Errorf("pts[0].Y = %v, want 9", pts[0].Y)
-		}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/generate-data-range", requireRole(RoleAdmin, generateDataRangeHandler))
+	mux.HandleFunc("/api/range", requireRole(RoleViewer, rangeHandler))
+	post := func(path, token string) (int, GenerateResponse) {
+		r := httptest.NewRequest("POST", path, strings.NewReader(`{"from":"2025-10-01","to":"2025-10-01"}`))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		var resp GenerateResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+	written := func() bool {
+		_, err := os.Stat(filepath.Join(dir, "gym-data.json"))
+		return err == nil
+	}
 
-		if got, want := pts[1].X, "2025-10-01T11:00:00+03:00"; got != want {
-			t.Errorf("pts[1].X = %q, want %q", got, want)
-		}
-		if math.Abs(pts[1].Y-4) > 1e-9 {
-			t.Errorf("pts[1].Y = %v, want 4", pts[1].Y)
-		}
+	if code, _ := post("/generate-data-range", "viewtoken"); code != http.StatusForbidden {
+		t.Errorf("viewer generating: code %d, want 403", code)
+	}
+	if code, resp := post("/api/range", "viewtoken"); code != 200 || len(resp.Datasets) != 1 || resp.UpToDate {
+		t.Errorf("viewer reading: %d %+v", code, resp)
+	}
+	if written() {
+		t.Error("a viewer's range wrote gym-data.json")
+	}
+	// The viewer's build is cached, and written when an admin asks.
+	if code, resp := post("/generate-data-range", "admintoken"); code != 200 || len(resp.Datasets) != 1 || resp.UpToDate || !written() {
+		t.Errorf("admin generating: %d %+v, written %v", code, resp, written())
+	}
+	if _, resp := post("/generate-data-range", "admintoken"); !resp.UpToDate {
+		t.Errorf("repeat = %+v, want up to date", resp)
+	}
+}
 
-		if pts[0].X >= pts[1].X {
-			t.Errorf("chronological order not preserved: %q then %q", pts[0].X, pts[1].X)
-		}
-	})
-
-	t.Run("averages rounded to one decimal", func(t *testing.T) {
-		in := []Dataset{{
-			Label: "gym",
-			Data: []DataPoint{
-				{X: "2025-10-01T10:00:00+03:00", Y: 1},
-				{X: "2025-10-01T10:30:00+03:00", Y: 2},
-				{X: "2025-10-01T10:50:00+03:00", Y: 2},
-			},
-		}}
-		out := downsampleDatasets(in, 60)
-		if len(out[0].Data) != 1 {
-			t.Fatalf("points = %d, want 1", len(out[0].Data))
-		}
-		// (1+2+2)/3 = 1.6666... rounds to 1.7
-		if math.Abs(out[0].Data[0].Y-1.7) > 1e-9 {
-			t.Errorf("Y = %v, want 1.7", out[0].Data[0].Y)
-		}
-	})
-
-	t.Run("invalid X points are skipped", func(t *testing.T) {
-		in := []Dataset{{
-			Label: "gym",
-			Data: []DataPoint{
-				{X: "garbage", Y: 100},
-				{X: "2025-10-01T10:00:00+03:00", Y: 6},
-			},
-		}}
-		out := downsampleDatasets(in, 60)
-		if len(out[0].Data) != 1 {
-			t.Fatalf("points = %d, want 1", len(out[0].Data))
-		}
-		if math.Abs(out[0].Data[0].Y-6) > 1e-9 {
-			t.Errorf("Y = %v, want 6", out[0].Data[0].Y)
+func TestWindowFiles(t *testing.T) {
+	tallinn := loadTallinn(t)
+	dir := t.TempDir()
+	for _, day := range []string{"20251005", "20251006", "20251007", "20251008", "20251009"} {
+		writeCSV(t, dir, "gym-stats-"+day+".csv", "")
+	}
+	cfg := &Config{DataDir: dir}
+	day := time.Date(2025, 10, 7, 0, 0, 0, 0, tallinn)
+	for _, tc := range []struct {
+		from, to time.Time
+		want     []string
+	}{
+		{day, day.AddDate(0, 0, 1), []string{"20251006", "20251007", "20251008"}},
+		{day.Add(time.Hour), day.Add(2 * time.Hour), []string{"20251006", "20251007", "20251008"}},
+		{day, day.AddDate(0, 0, 2), []string{"20251006", "20251007", "20251008", "20251009"}},
+	} {
+		files, err := windowFiles(cfg, tc.from, tc.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range files {
+			got = append(got, gymdata.BaseName(f)[10:18])
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s to %s: files %v, want %v", tc.from, tc.to, got, tc.want)
 		}
-	})
+	}
+	if files, err := windowFiles(&Config{DataDir: t.TempDir()}, day, day.AddDate(0, 0, 1)); files != nil || err != nil {
+		t.Errorf("no CSVs = %v, %v, want none", files, err)
+	}
 }
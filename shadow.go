@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// ShadowDiff is a dual-read check that found the shadow store
+// (SHADOW_SOURCE) disagreeing with the files a chart was served from, or
+// couldn't read it.
+type ShadowDiff struct {
+	Time     string       `json:"time"`
+	Endpoint string       `json:"endpoint"`
+	From     string       `json:"from"`
+	To       string       `json:"to"`
+	Metrics  []string     `json:"metrics"`
+	Error    string       `json:"error,omitempty"`
+	Missing  []string     `json:"missing,omitempty"` // series only the served files have
+	Extra    []string     `json:"extra,omitempty"`   // series only the shadow has
+	Series   []SeriesDiff `json:"series,omitempty"`
+}
+
+// SeriesDiff is how one series read from both stores differs: Differ counts
+// the instants either lacks or that have another value, First is the
+// earliest of them.
+type SeriesDiff struct {
+	Label  string `json:"label"`
+	Points int    `json:"points"`
+	Shadow int    `json:"shadowPoints"`
+	Differ int    `json:"differ"`
+	First  string `json:"first"`
+}
+
+// shadowCounts tallies a shadow store's checks since startup.
+type shadowCounts struct {
+	Checks     int `json:"checks"`
+	Mismatches int `json:"mismatches"`
+	Errors     int `json:"errors"`
+	Skipped    int `json:"skipped"` // another check was still running
+}
+
+var (
+	shadowMu    sync.Mutex // guards shadowStats and the shadow logs
+	shadowStats = map[string]*shadowCounts{}
+	// shadowBusy holds one check at a time, so a burst of cache misses
+	// doesn't double the load on both stores.
+	shadowBusy = make(chan struct{}, 1)
+)
+
+// verifyShadow, with SHADOW_SOURCE set, reads the days fromDay..toDay from
+// the shadow store in the background, puts them through prep (what the
+// served series went through after loading), and records any difference
+// from list. list must not change afterwards, as cached builds don't.
+func verifyShadow(cfg *Config, endpoint, fromDay, toDay string, metrics []string, list []*gymdata.Series, prep func([]*gymdata.Series) []*gymdata.Series) {
+	if cfg.ShadowSource == "" {
+		return
+	}
+	select {
+	case shadowBusy <- struct{}{}:
+	default:
+		countShadow(cfg, func(c *shadowCounts) { c.Skipped++ })
+		return
+	}
+	go func() {
+		defer func() { <-shadowBusy }()
+		diff := checkShadow(cfg, endpoint, fromDay, toDay, metrics, list, prep)
+		countShadow(cfg, func(c *shadowCounts) {
+			c.Checks++
+			switch {
+			case diff == nil:
+			case diff.Error != "":
+				c.Errors++
+			default:
+				c.Mismatches++
+			}
+		})
+		switch {
+		case diff == nil:
+			return
+		case diff.Error != "":
+			log.Printf("shadow: %s %s..%s: %s", endpoint, fromDay, toDay, diff.Error)
+		default:
+			log.Printf("shadow: %s %s..%s differs in %s: %d series missing, %d extra, %d with other points",
+				endpoint, fromDay, toDay, cfg.ShadowSource, len(diff.Missing), len(diff.Extra), len(diff.Series))
+		}
+		appendShadow(cfg, diff)
+	}()
+}
+
+func countShadow(cfg *Config, f func(*shadowCounts)) {
+	shadowMu.Lock()
+	defer shadowMu.Unlock()
+	c := shadowStats[cfg.ShadowSource]
+	if c == nil {
+		c = &shadowCounts{}
+		shadowStats[cfg.ShadowSource] = c
+	}
+	f(c)
+}
+
+// checkShadow is one dual read: nil if the shadow store gives the same
+// series as list.
+func checkShadow(cfg *Config, endpoint, fromDay, toDay string, metrics []string, list []*gymdata.Series, prep func([]*gymdata.Series) []*gymdata.Series) *ShadowDiff {
+	diff := &ShadowDiff{Endpoint: endpoint, From: fromDay, To: toDay, Metrics: metrics}
+	files, err := gymdata.InRange(cfg.ShadowSource, fromDay, toDay)
+	var shadow []*gymdata.Series
+	if err == nil {
+		shadow, _, err = gymdata.Load(cfg.format(), files, metrics)
+	}
+	if err != nil {
+		diff.Error = err.Error()
+		return diff
+	}
+	diff.Missing, diff.Extra, diff.Series = diffSeries(list, prep(shadow), gymdata.Tallinn())
+	if len(diff.Missing) == 0 && len(diff.Extra) == 0 && len(diff.Series) == 0 {
+		return nil
+	}
+	return diff
+}
+
+// diffSeries compares two builds series by series, point by point.
+func diffSeries(primary, shadow []*gymdata.Series, loc *time.Location) (missing, extra []string, series []SeriesDiff) {
+	byKey := map[gymdata.Key]*gymdata.Series{}
+	for _, s := range shadow {
+		byKey[s.Key] = s
+	}
+	for _, p := range primary {
+		s, ok := byKey[p.Key]
+		if !ok {
+			missing = append(missing, p.Key.Label())
+			continue
+		}
+		delete(byKey, p.Key)
+		d := SeriesDiff{Label: p.Key.Label(), Points: len(p.Points), Shadow: len(s.Points)}
+		first := int64(math.MaxInt64)
+		i, j := 0, 0
+		for i < len(p.Points) || j < len(s.Points) {
+			var at int64
+			switch {
+			case j == len(s.Points) || i < len(p.Points) && p.Points[i].At < s.Points[j].At:
+				at = p.Points[i].At
+				i++
+			case i == len(p.Points) || s.Points[j].At < p.Points[i].At:
+				at = s.Points[j].At
+				j++
+			default:
+				at = p.Points[i].At
+				same := math.Abs(p.Points[i].Y-s.Points[j].Y) < 1e-9
+				i, j = i+1, j+1
+				if same {
+					continue
+				}
+			}
+			d.Differ++
+			first = min(first, at)
+		}
+		if d.Differ > 0 {
+			d.First = time.Unix(first, 0).In(loc).Format(time.RFC3339)
+			series = append(series, d)
+		}
+	}
+	for _, s := range shadow {
+		if _, ok := byKey[s.Key]; ok {
+			extra = append(extra, s.Key.Label())
+		}
+	}
+	return missing, extra, series
+}
+
+// appendShadow adds a difference to cfg's shadow log as one JSON line.
+func appendShadow(cfg *Config, diff *ShadowDiff) {
+	diff.Time = time.Now().UTC().Format(time.RFC3339)
+	line, err := json.Marshal(diff)
+	if err != nil {
+		log.Printf("shadow: %v", err)
+		return
+	}
+	shadowMu.Lock()
+	defer shadowMu.Unlock()
+	file, err := os.OpenFile(cfg.path(cfg.ShadowLog), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		log.Printf("shadow: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Printf("shadow: %v", err)
+	}
+}
+
+// readShadow returns up to limit logged differences, newest first.
+func readShadow(cfg *Config, limit int) ([]ShadowDiff, error) {
+	shadowMu.Lock()
+	defer shadowMu.Unlock()
+	file, err := os.Open(cfg.path(cfg.ShadowLog))
+	if os.IsNotExist(err) {
+		return []ShadowDiff{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var all []ShadowDiff
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var d ShadowDiff
+		if json.Unmarshal(scanner.Bytes(), &d) == nil {
+			all = append(all, d)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	out := make([]ShadowDiff, 0, min(limit, len(all)))
+	for i := len(all) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, all[i])
+	}
+	return out, nil
+}
+
+// shadowHandler reports how the shadow store has compared so far.
+//
+//	GET /api/admin/shadow[?limit=N]
+func shadowHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := requestConfig(r)
+	if cfg.ShadowSource == "" {
+		writeError(w, http.StatusNotFound, errors.New("SHADOW_SOURCE is not set"))
+		return
+	}
+
+	limit := 100
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = min(n, 10000)
+	}
+	entries, err := readShadow(cfg, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var counts shadowCounts
+	countShadow(cfg, func(c *shadowCounts) { counts = *c })
+	json.NewEncoder(w).Encode(map[string]any{
+		"source":  cfg.ShadowSource,
+		"primary": cfg.csvDir(),
+		"counts":  counts,
+		"entries": entries,
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"gym/internal/gymdata"
+)
+
+func TestShadow(t *testing.T) {
+	loadTallinn(t)
+	primary, shadow := t.TempDir(), t.TempDir()
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	writeCSV(t, primary, "gym-stats-20251001.csv", header+
+		"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,{}\n"+
+		"2025-10-01 10:02:00,EEST,1,Hipodroom,13,success,{}\n")
+	writeCSV(t, shadow, "gym-stats-20251001.csv", header+
+		"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,{}\n"+
+		"2025-10-01 10:02:00,EEST,1,Hipodroom,14,success,{}\n"+
+		"2025-10-01 10:04:00,EEST,1,Hipodroom,15,success,{}\n"+
+		"2025-10-01 10:00:00,EEST,2,T1,3,success,{}\n")
+	cfg := &Config{DataDir: primary, ShadowSource: shadow, ShadowLog: "gym-shadow.jsonl"}
+	old := currentConfig()
+	defer setConfig(old)
+	setConfig(cfg)
+
+	files, _ := gymdata.InRange(primary, "2025-10-01", "2025-10-01")
+	list, _, err := gymdata.Load(cfg.format(), files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	same := func(l []*gymdata.Series) []*gymdata.Series { return l }
+
+	diff := checkShadow(cfg, "recent", "2025-10-01", "2025-10-01", nil, list, same)
+	if diff == nil || len(diff.Extra) != 1 || diff.Extra[0] != "T1" || len(diff.Missing) != 0 {
+		t.Fatalf("diff = %+v, want T1 extra", diff)
+	}
+	if d := diff.Series; len(d) != 1 || d[0].Points != 2 || d[0].Shadow != 3 || d[0].Differ != 2 || d[0].First != "2025-10-01T10:02:00+03:00" {
+		t.Errorf("series = %+v, want 10:02 and 10:04 differing", d)
+	}
+	if d := checkShadow(cfg, "recent", "2025-10-02", "2025-10-02", nil, nil, same); d != nil {
+		t.Errorf("both empty: %+v", d)
+	}
+
+	verifyShadow(cfg, "generate-data-range", "2025-10-01", "2025-10-01", nil, list, same)
+	shadowBusy <- struct{}{} // wait for the check
+	<-shadowBusy
+	w := httptest.NewRecorder()
+	shadowHandler(w, httptest.NewRequest("GET", "/api/admin/shadow", nil))
+	var resp struct {
+		Counts  shadowCounts
+		Entries []ShadowDiff
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Counts.Checks != 1 || resp.Counts.Mismatches != 1 || len(resp.Entries) != 1 || resp.Entries[0].Endpoint != "generate-data-range" {
+		t.Errorf("handler = %d %+v", w.Code, resp)
+	}
+}
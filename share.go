@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gym/internal/gymdata"
+)
+
+// requestValues reads a request's values option: count, the default, charts
+// headcounts; share charts each gym's percentage of the chain's total per
+// bucket, as gymdata.Share does.
+func requestValues(values string) (share bool, err error) {
+	switch strings.ToLower(strings.TrimSpace(values)) {
+	case "", "count":
+		return false, nil
+	case "share":
+		return true, nil
+	}
+	return false, fmt.Errorf("values must be count or share")
+}
+
+// withShare returns list as shares of the total when the request's values
+// option asks for them, interpolating across gaps no longer than a total
+// bridges. It runs before withTotal, so a requested Total reads 100. list
+// itself, which may be cached, is not changed.
+func withShare(list []*gymdata.Series, values string, bucketMinutes int) []*gymdata.Series {
+	if share, _ := requestValues(values); !share { // checked with the request
+		return list
+	}
+	return gymdata.Share(list, bridgeGap(bucketMinutes))
+}
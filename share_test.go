@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRangeShare(t *testing.T) {
+	loadTallinn(t)
+	old := currentConfig()
+	defer setConfig(old)
+	dir := t.TempDir()
+	setConfig(&Config{DataDir: dir, AuditLog: "gym-audit.jsonl", AnnotationsFile: "gym-annotations.json", PrefsFile: "gym-prefs.json"})
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	writeCSV(t, dir, "gym-stats-20251001.csv", header+
+		"2025-10-01 10:00:00,EEST,1,Hipodroom,30,success,{}\n"+
+		"2025-10-01 10:00:00,EEST,2,T1,10,success,{}\n"+
+		"2025-10-01 10:02:00,EEST,1,Hipodroom,0,success,{}\n"+
+		"2025-10-01 10:02:00,EEST,2,T1,0,success,{}\n")
+
+	post := func(body string) (int, GenerateResponse) {
+		w := httptest.NewRecorder()
+		generateDataRangeHandler(w, httptest.NewRequest("POST", "/generate-data-range", strings.NewReader(body)))
+		var resp GenerateResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	code, resp := post(`{"from": "2025-10-01", "to": "2025-10-01", "values": "share", "total": "all"}`)
+	if code != 200 || len(resp.Datasets) != 3 {
+		t.Fatalf("code %d, %+v", code, resp)
+	}
+	// Nobody in at 10:02 gives no shares.
+	for i, want := range []float64{75, 25, 100} {
+		if d := resp.Datasets[i].Data; len(d) != 2 || d[0].Y != want {
+			t.Errorf("%s = %+v, want %v then null", resp.Datasets[i].Label, d, want)
+		}
+	}
+	if code, _ := post(`{"from": "2025-10-01", "to": "2025-10-01", "values": "percent"}`); code != 400 {
+		t.Errorf("values=percent: code %d, want 400", code)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// ShareScope is what a share link shows: the range from..to (as
+// /generate-data-range takes them) of the named gyms, or all of them,
+// until Expires.
+type ShareScope struct {
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	Locations []string `json:"locations,omitempty"`
+	Label     string   `json:"label,omitempty"`
+	Expires   int64    `json:"exp"` // Unix seconds
+}
+
+const (
+	defaultShareDays = 7
+	maxShareDays     = 366
+	maxShareGyms     = 16
+)
+
+var (
+	errShareInvalid = errors.New("this share link is not valid")
+	errShareExpired = errors.New("this share link has expired")
+)
+
+// shareMAC signs a scope's encoding for cfg. The data directory is signed
+// with it, so a link works only on the tenant it was made on.
+func shareMAC(cfg *Config, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(cfg.ShareSecret))
+	io.WriteString(mac, cfg.DataDir+"\n"+payload)
+	return mac.Sum(nil)
+}
+
+// signShare encodes scope as a link token: the scope's JSON and its MAC,
+// each base64url, joined by a dot. Nothing is stored; the token is the
+// grant.
+func signShare(cfg *Config, scope ShareScope) string {
+	data, _ := json.Marshal(scope)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(shareMAC(cfg, payload))
+}
+
+// parseShare checks a token's signature and expiry and returns its scope.
+func parseShare(cfg *Config, token string, now time.Time) (ShareScope, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if !ok || err != nil || !hmac.Equal(got, shareMAC(cfg, payload)) {
+		return ShareScope{}, errShareInvalid
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return ShareScope{}, errShareInvalid
+	}
+	var scope ShareScope
+	if err := json.Unmarshal(data, &scope); err != nil {
+		return ShareScope{}, errShareInvalid
+	}
+	if now.Unix() >= scope.Expires {
+		return ShareScope{}, errShareExpired
+	}
+	return scope, nil
+}
+
+// ShareRequest is the body of POST /api/share-links: a range as
+// /generate-data-range takes it (from and to, or a preset, fixed to its
+// dates now), the gyms to show (default all), a label for the page and how
+// many days the link lasts.
+type ShareRequest struct {
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	Preset    string   `json:"preset,omitempty"`
+	Locations []string `json:"locations,omitempty"`
+	Label     string   `json:"label,omitempty"`
+	Days      int      `json:"days,omitempty"`
+}
+
+// ShareResponse is a minted link. URL is relative to the server, as the
+// dashboard's own pages are, so it works behind a tenant prefix too.
+type ShareResponse struct {
+	Token   string     `json:"token"`
+	URL     string     `json:"url"`
+	Expires string     `json:"expires"`
+	Scope   ShareScope `json:"scope"`
+}
+
+// newShareScope checks a request and fixes its scope as of now.
+func newShareScope(cfg *Config, req ShareRequest, now time.Time) (ShareScope, error) {
+	dateRange := DateRangeRequest{From: strings.TrimSpace(req.From), To: strings.TrimSpace(req.To), Preset: strings.TrimSpace(req.Preset)}
+	if err := applyPreset(cfg, &dateRange, now); err != nil {
+		return ShareScope{}, fieldErr("preset", ErrBadRange, err)
+	}
+	window, err := parseRangeWindow(dateRange.From, dateRange.To, gymdata.Tallinn())
+	if err != nil {
+		return ShareScope{}, err
+	}
+	if !window.from.Before(window.to) {
+		return ShareScope{}, fieldErr("to", ErrBadRange, errors.New("from must be before to"))
+	}
+	days := req.Days
+	if days == 0 {
+		days = defaultShareDays
+	}
+	if days < 1 || days > maxShareDays {
+		return ShareScope{}, fieldErr("days", nil, fmt.Errorf("days must be 1 to %d", maxShareDays))
+	}
+	scope := ShareScope{From: dateRange.From, To: dateRange.To, Label: strings.TrimSpace(req.Label), Expires: now.AddDate(0, 0, days).Unix()}
+	if len(scope.Label) > 200 {
+		return ShareScope{}, fieldErr("label", nil, errors.New("label is at most 200 characters"))
+	}
+	for _, name := range req.Locations {
+		if name = strings.TrimSpace(name); name != "" {
+			scope.Locations = append(scope.Locations, name)
+		}
+	}
+	if len(scope.Locations) > maxShareGyms {
+		return ShareScope{}, fieldErr("locations", nil, fmt.Errorf("a link shows at most %d gyms", maxShareGyms))
+	}
+	return scope, nil
+}
+
+// shareLinksHandler mints a share link (POST, admin).
+func shareLinksHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "POST, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := requestConfig(r)
+	if cfg.ShareSecret == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("SHARE_SECRET is not set"))
+		return
+	}
+	var req ShareRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body"))
+		return
+	}
+	scope, err := newShareScope(cfg, req, time.Now())
+	params := map[string]any{"from": scope.From, "to": scope.To, "locations": scope.Locations, "days": req.Days}
+	if err != nil {
+		recordAudit(r, "share-link", params, err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	recordAudit(r, "share-link", params, nil)
+	token := signShare(cfg, scope)
+	json.NewEncoder(w).Encode(ShareResponse{
+		Token:   token,
+		URL:     "shared.html?t=" + token,
+		Expires: time.Unix(scope.Expires, 0).In(gymdata.Tallinn()).Format(time.RFC3339),
+		Scope:   scope,
+	})
+}
+
+// sharedHandler serves a share link's chart to anyone holding it, with no
+// key or login: the range's series of its gyms, their annotations and the
+// scope, and nothing else of the dashboard's.
+//
+//	GET /api/shared?t=TOKEN[&maxPoints=N][&timestamps=ms]
+func sharedHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := requestConfig(r)
+	if cfg.ShareSecret == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("SHARE_SECRET is not set"))
+		return
+	}
+	q := r.URL.Query()
+	scope, err := parseShare(cfg, q.Get("t"), time.Now())
+	if errors.Is(err, errShareExpired) {
+		writeError(w, http.StatusGone, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	maxPoints, err := requestMaxPoints(q.Get("maxPoints"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	dateRange := DateRangeRequest{From: scope.From, To: scope.To, Timestamps: q.Get("timestamps"), MaxPoints: maxPoints}
+	if len(scope.Locations) > 0 {
+		dateRange.Closed = "include" // named when the link was made
+	}
+	if err := checkRangeRequest(cfg, &dateRange); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	resp, list, pf, status, err := rangeResponse(r, dateRange, false)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	shown := func(location string) bool {
+		if len(scope.Locations) == 0 {
+			return true
+		}
+		for _, name := range scope.Locations {
+			if strings.EqualFold(name, location) {
+				return true
+			}
+		}
+		return false
+	}
+	var kept []*gymdata.Series
+	for _, s := range list {
+		if shown(s.Key.Location) {
+			kept = append(kept, s)
+		}
+	}
+	notes := []Annotation{}
+	for _, a := range resp.Annotations {
+		if a.Location == "" || shown(a.Location) {
+			a.Author = "" // who wrote it stays inside
+			notes = append(notes, a)
+		}
+	}
+	// Only what the link grants: no preferences, outlier or row reports,
+	// and no file names.
+	meta := *resp.Meta
+	meta.Files, meta.Rows = []string{}, 0
+	writeChartResponse(w, r, GenerateResponse{
+		Success:     true,
+		Message:     resp.Message,
+		Annotations: notes,
+		Meta:        &meta,
+		Share:       &scope,
+	}, kept, pf)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gym/internal/fixtures"
+)
+
+func TestShareToken(t *testing.T) {
+	cfg := &Config{DataDir: "a", ShareSecret: "0123456789abcdef"}
+	now := time.Unix(1_760_000_000, 0)
+	scope := ShareScope{From: "2025-12-24", To: "2025-12-26", Locations: []string{"T1"}, Expires: now.Add(time.Hour).Unix()}
+	token := signShare(cfg, scope)
+
+	got, err := parseShare(cfg, token, now)
+	if err != nil || got.From != scope.From || len(got.Locations) != 1 || got.Locations[0] != "T1" {
+		t.Fatalf("round trip: %+v, %v", got, err)
+	}
+	if _, err := parseShare(cfg, token, now.Add(time.Hour)); err != errShareExpired {
+		t.Errorf("at expiry: err %v", err)
+	}
+
+	// Widening the scope breaks the signature, as do another secret and
+	// another tenant's directory.
+	payload, sig, _ := strings.Cut(token, ".")
+	wider, _ := json.Marshal(ShareScope{From: "2020-01-01", To: "2025-12-26", Expires: scope.Expires})
+	forged := base64.RawURLEncoding.EncodeToString(wider) + "." + sig
+	for name, tc := range map[string]struct {
+		cfg   *Config
+		token string
+	}{
+		"forged":       {cfg, forged},
+		"no signature": {cfg, payload},
+		"bad base64":   {cfg, payload + ".!" + sig},
+		"other secret": {&Config{DataDir: "a", ShareSecret: "fedcba9876543210"}, token},
+		"other tenant": {&Config{DataDir: "b", ShareSecret: cfg.ShareSecret}, token},
+	} {
+		if _, err := parseShare(tc.cfg, tc.token, now); err != errShareInvalid {
+			t.Errorf("%s: err %v", name, err)
+		}
+	}
+}
+
+func TestShareLinks(t *testing.T) {
+	tallinn := loadTallinn(t)
+	dir := t.TempDir()
+	spec := fixtures.Spec{Locations: fixtures.DefaultLocations[:2], Start: time.Date(2025, 10, 6, 0, 0, 0, 0, tallinn), Days: 2, Zone: tallinn}
+	if _, err := spec.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{fixtures.DefaultLocations[0].Name, fixtures.DefaultLocations[1].Name}
+	old := currentConfig()
+	defer setConfig(old)
+	cfg := &Config{DataDir: dir, AnnotationsFile: "gym-annotations.json", AuditLog: filepath.Join(dir, "audit.jsonl")}
+	setConfig(cfg)
+
+	mint := func(body string) (int, ShareResponse) {
+		w := httptest.NewRecorder()
+		shareLinksHandler(w, httptest.NewRequest("POST", "/api/share-links", strings.NewReader(body)))
+		var resp ShareResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	open := func(token string) (int, GenerateResponse, string) {
+		w := httptest.NewRecorder()
+		sharedHandler(w, httptest.NewRequest("GET", "/api/shared?t="+token, nil))
+		var resp GenerateResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp, w.Body.String()
+	}
+
+	if code, _ := mint(`{"from":"2025-10-06","to":"2025-10-07"}`); code != http.StatusNotFound {
+		t.Errorf("without SHARE_SECRET: %d, want 404", code)
+	}
+	cfg.ShareSecret = "0123456789abcdef"
+	if err := writeAnnotations(cfg, []Annotation{
+		{ID: 1, Text: "Route setting", Start: "2025-10-06T10:00:00+03:00", Location: names[0], Author: "admin"},
+		{ID: 2, Text: "Closed early", Start: "2025-10-06T20:00:00+03:00", Location: names[1]},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	code, link := mint(`{"from":"2025-10-06","to":"2025-10-07","locations":[" ` + strings.ToLower(names[0]) + ` "],"label":"Autumn","days":3}`)
+	if code != 200 || link.Token == "" || link.URL != "shared.html?t="+link.Token || link.Scope.Label != "Autumn" {
+		t.Fatalf("mint: %d %+v", code, link)
+	}
+	if exp := time.Unix(link.Scope.Expires, 0); time.Until(exp) < 71*time.Hour || time.Until(exp) > 73*time.Hour {
+		t.Errorf("expires %v, want in 3 days", exp)
+	}
+
+	code, resp, body := open(link.Token)
+	if code != 200 || len(resp.Datasets) != 1 || resp.Datasets[0].Label != names[0] || resp.Share == nil || resp.Share.Label != "Autumn" {
+		t.Fatalf("open: %d %s", code, body)
+	}
+	if len(resp.Annotations) != 1 || resp.Annotations[0].Author != "" {
+		t.Errorf("annotations %+v, want the shown gym's without its author", resp.Annotations)
+	}
+	if resp.Preferences != nil || resp.Rows != nil || len(resp.Meta.Files) != 0 {
+		t.Errorf("leaks: prefs %v, rows %v, files %v", resp.Preferences, resp.Rows, resp.Meta.Files)
+	}
+
+	// Tampering, expiry and bad requests.
+	if code, _, _ := open(link.Token + "x"); code != http.StatusForbidden {
+		t.Errorf("tampered: %d, want 403", code)
+	}
+	expired := signShare(cfg, ShareScope{From: "2025-10-06", To: "2025-10-07", Expires: time.Now().Add(-time.Minute).Unix()})
+	if code, _, body := open(expired); code != http.StatusGone || !strings.Contains(body, `"code":"expired"`) {
+		t.Errorf("expired: %d %s", code, body)
+	}
+	for _, bad := range []string{`{"from":"2025-10-07","to":"2025-10-06"}`, `{"from":"2025-10-06","to":"2025-10-07","days":400}`, `{"from":"x"}`, `[`} {
+		if code, _ := mint(bad); code != http.StatusBadRequest {
+			t.Errorf("%s: %d, want 400", bad, code)
+		}
+	}
+}
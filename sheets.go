@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// sheetsAPI is the Sheets API's spreadsheets collection.
+var sheetsAPI = "https://sheets.googleapis.com/v4/spreadsheets/"
+
+var sheetsClient = &http.Client{Timeout: 30 * time.Second}
+
+// serviceAccount is the part of a Google service account's JSON key the
+// import signs in with.
+type serviceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// sheetsToken signs in as the service account in keyFile (the JWT bearer
+// grant) and returns an access token that can read spreadsheets shared with
+// it.
+func sheetsToken(keyFile string) (string, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return "", err
+	}
+	var sa serviceAccount
+	if err := json.Unmarshal(data, &sa); err != nil {
+		return "", fmt.Errorf("%s: %v", keyFile, err)
+	}
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil || sa.ClientEmail == "" || sa.TokenURI == "" {
+		return "", fmt.Errorf("%s: not a service account key", keyFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", keyFile, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("%s: not an RSA key", keyFile)
+	}
+
+	now := time.Now()
+	b64 := base64.RawURLEncoding.EncodeToString
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   sa.ClientEmail,
+		"scope": "https://www.googleapis.com/auth/spreadsheets.readonly",
+		"aud":   sa.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	signed := b64(header) + "." + b64(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	res, err := sheetsClient.PostForm(sa.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {signed + "." + b64(sig)},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	var tok struct {
+		AccessToken string `json:"access_token"`
+	}
+	if res.StatusCode != http.StatusOK || json.NewDecoder(res.Body).Decode(&tok) != nil || tok.AccessToken == "" {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return "", fmt.Errorf("service account sign-in: %s %s", res.Status, strings.TrimSpace(string(body)))
+	}
+	return tok.AccessToken, nil
+}
+
+// sheetValues reads a range of a spreadsheet, first row the header. Dates
+// come as serial numbers so they don't depend on the sheet's locale.
+func sheetValues(token, sheetID, valueRange string) ([][]any, error) {
+	u := sheetsAPI + url.PathEscape(sheetID) + "/values/" + url.PathEscape(valueRange) +
+		"?valueRenderOption=UNFORMATTED_VALUE&dateTimeRenderOption=SERIAL_NUMBER"
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := sheetsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return nil, fmt.Errorf("sheet %s: %s %s", valueRange, res.Status, strings.TrimSpace(string(body)))
+	}
+	var out struct {
+		Values [][]any `json:"values"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return out.Values, nil
+}
+
+// sheetTime reads a timestamp cell as a Tallinn wall-clock time, as someone
+// noting numbers at the gym would have written it: a date serial number
+// (days since 1899-12-30), or text in a few common layouts. Text it can't
+// read is passed on for the ingest checks to reject or accept.
+func sheetTime(cell any, tallinn *time.Location) string {
+	switch v := cell.(type) {
+	case float64:
+		days := math.Floor(v)
+		secs := math.Round((v - days) * 86400)
+		return time.Date(1899, 12, 30+int(days), 0, 0, int(secs), 0, tallinn).Format(time.RFC3339)
+	case string:
+		for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04:05", "02.01.2006 15:04"} {
+			if t, err := time.ParseInLocation(layout, strings.TrimSpace(v), tallinn); err == nil {
+				return t.Format(time.RFC3339)
+			}
+		}
+		return v
+	}
+	return fmt.Sprint(cell)
+}
+
+// sheetCell is a cell as text; whole numbers drop the ".0" JSON gives them.
+func sheetCell(cell any) string {
+	switch v := cell.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return strings.TrimSpace(v)
+	}
+	return fmt.Sprint(cell)
+}
+
+// readingsFromSheet turns a sheet's rows into readings, its header renamed
+// per columns (as CSV_COLUMNS renames a file's). location names the gym for
+// a sheet without a location_name column. Blank rows are skipped.
+func readingsFromSheet(rows [][]any, columns map[string]string, location string, tallinn *time.Location) ([]Reading, error) {
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("the range is empty")
+	}
+	headers := make([]string, len(rows[0]))
+	for i, h := range rows[0] {
+		headers[i] = sheetCell(h)
+	}
+	idx := map[string]int{}
+	for i, h := range gymdata.CanonicalHeaders(headers, columns) {
+		if h != "" {
+			idx[h] = i
+		}
+	}
+	if _, ok := idx["timestamp"]; !ok {
+		return nil, fmt.Errorf("no timestamp column in %q", headers)
+	}
+	if _, ok := idx["location_name"]; !ok && location == "" {
+		return nil, fmt.Errorf("no location_name column in %q, and no location given", headers)
+	}
+
+	var out []Reading
+	for _, row := range rows[1:] {
+		cell := func(name string) any {
+			if i, ok := idx[name]; ok && i < len(row) {
+				return row[i]
+			}
+			return nil
+		}
+		if sheetCell(cell("timestamp")) == "" {
+			continue
+		}
+		rd := Reading{
+			Timestamp:    sheetTime(cell("timestamp"), tallinn),
+			LocationID:   flexString(sheetCell(cell("location_id"))),
+			LocationName: sheetCell(cell("location_name")),
+			UserCount:    flexString(sheetCell(cell("user_count"))),
+			Status:       sheetCell(cell("status")),
+			Response:     `{"source":"sheets"}`,
+		}
+		if rd.LocationName == "" {
+			rd.LocationName = location
+		}
+		out = append(out, rd)
+	}
+	return out, nil
+}
+
+// SheetsImport says which sheet to import; blank fields fall back to the
+// SHEETS_* settings.
+type SheetsImport struct {
+	Sheet    string `json:"sheet,omitempty"`
+	Range    string `json:"range,omitempty"`
+	Columns  string `json:"columns,omitempty"` // as SHEETS_COLUMNS
+	Location string `json:"location,omitempty"`
+}
+
+// sheetsImportHandler pulls historical rows from a Google Sheet into the
+// ingest queue, where they are checked, de-duplicated against what the day
+// files hold and appended like any other readings, so importing twice is
+// harmless.
+//
+//	POST /api/import/sheets [{sheet, range, columns, location}]
+func sheetsImportHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "POST, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	fail := func(status int, err error) {
+		writeError(w, status, err)
+	}
+	cfg := requestConfig(r)
+	if cfg.SheetsCredentials == "" {
+		fail(http.StatusNotFound, fmt.Errorf("SHEETS_CREDENTIALS is not set"))
+		return
+	}
+	if cfg.CSVSource != "" {
+		fail(http.StatusConflict, fmt.Errorf("CSV files are read from %s; import there instead", cfg.CSVSource))
+		return
+	}
+
+	var req SheetsImport
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil && err != io.EOF {
+		fail(http.StatusBadRequest, fmt.Errorf("invalid request body"))
+		return
+	}
+	if req.Sheet == "" {
+		req.Sheet = cfg.SheetsID
+	}
+	if req.Range == "" {
+		req.Range = cfg.SheetsRange
+	}
+	columns := cfg.SheetsColumns
+	if req.Columns != "" {
+		var err error
+		if columns, err = gymdata.ParseColumnMap(req.Columns); err != nil {
+			fail(http.StatusBadRequest, fmt.Errorf("columns: %v", err))
+			return
+		}
+	}
+	if req.Sheet == "" {
+		fail(http.StatusBadRequest, fmt.Errorf("no sheet: set SHEETS_ID or send one"))
+		return
+	}
+
+	params := map[string]any{"sheet": req.Sheet, "range": req.Range}
+	token, err := sheetsToken(cfg.path(cfg.SheetsCredentials))
+	var rows [][]any
+	if err == nil {
+		rows, err = sheetValues(token, req.Sheet, req.Range)
+	}
+	if err != nil {
+		recordAudit(r, "import-sheets", params, err)
+		fail(http.StatusBadGateway, err)
+		return
+	}
+	readings, err := readingsFromSheet(rows, columns, strings.TrimSpace(req.Location), gymdata.Tallinn())
+	if err == nil && len(readings) == 0 {
+		err = fmt.Errorf("no rows below the header")
+	}
+	if err != nil {
+		recordAudit(r, "import-sheets", params, err)
+		fail(http.StatusBadRequest, err)
+		return
+	}
+
+	params["readings"] = len(readings)
+	if readings, err = hookReadings(r.Context(), cfg, readings); err != nil {
+		recordAudit(r, "import-sheets", params, err)
+		fail(http.StatusBadGateway, err)
+		return
+	}
+	status, err := ingestorFor(cfg).submit(readings)
+	recordAudit(r, "import-sheets", params, err)
+	if err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]any{"queued": len(readings), "status": status})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gym/internal/gymdata"
+)
+
+func TestSheetsImport(t *testing.T) {
+	tallinn := loadTallinn(t)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || r.PostFormValue("assertion") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "t0k"})
+	})
+	mux.HandleFunc("/sheets/abc/values/Log!A:D", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0k" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"values": [
+			["Date", "Gym", "People"],
+			[45566.4375, "Hipodroom", 12],
+			[],
+			["2024-10-01 10:45", "T1", "3"],
+			["1.10.24", "T1", 4]
+		]}`))
+	})
+	old := sheetsAPI
+	defer func() { sheetsAPI = old }()
+	sheetsAPI = srv.URL + "/sheets/"
+
+	creds := filepath.Join(t.TempDir(), "sa.json")
+	sa, _ := json.Marshal(serviceAccount{ClientEmail: "import@proj.iam.example", TokenURI: srv.URL + "/token",
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))})
+	os.WriteFile(creds, sa, 0o600)
+
+	token, err := sheetsToken(creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := sheetValues(token, "abc", "Log!A:D")
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns, _ := gymdata.ParseColumnMap("timestamp=Date,location_name=Gym,user_count=People")
+	readings, err := readingsFromSheet(rows, columns, "", tallinn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Reading{
+		{Timestamp: "2024-10-01T10:30:00+03:00", LocationName: "Hipodroom", UserCount: "12"},
+		{Timestamp: "2024-10-01T10:45:00+03:00", LocationName: "T1", UserCount: "3"},
+		{Timestamp: "1.10.24", LocationName: "T1", UserCount: "4"}, // left for the ingest checks to reject
+	}
+	if len(readings) != len(want) {
+		t.Fatalf("readings = %+v", readings)
+	}
+	for i, w := range want {
+		if rd := readings[i]; rd.Timestamp != w.Timestamp || rd.LocationName != w.LocationName || rd.UserCount != w.UserCount {
+			t.Errorf("reading %d = %+v, want %+v", i, rd, w)
+		}
+	}
+
+	if _, err := readingsFromSheet(rows, nil, "", tallinn); err == nil {
+		t.Error("unmapped header: expected error")
+	}
+	single, _ := gymdata.ParseColumnMap("timestamp=Date,user_count=People")
+	if readings, err := readingsFromSheet(rows, single, "Hipodroom", tallinn); err != nil || readings[1].LocationName != "Hipodroom" {
+		t.Errorf("with a location given: %+v, %v", readings, err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// maxSkewRows bounds the rows /api/skew lists; the counts cover them all.
+const maxSkewRows = 500
+
+// SkewRow is a quarantined row: when it says it was taken, and the time it
+// contradicts (its file's last write, or the gym's latest reading).
+type SkewRow struct {
+	File          string `json:"file"`
+	Location      string `json:"location"`
+	Timestamp     string `json:"timestamp"` // as written
+	At            string `json:"at"`
+	Kind          string `json:"kind"`
+	Ref           string `json:"ref"`
+	OffsetMinutes int    `json:"offsetMinutes"` // At - Ref
+}
+
+type SkewLocation struct {
+	Name      string `json:"name"`
+	Future    int    `json:"future"`
+	Backwards int    `json:"backwards"`
+	Latest    string `json:"latest"` // file of its last skewed row
+}
+
+type SkewResponse struct {
+	From             string         `json:"from"`
+	To               string         `json:"to"`
+	ToleranceMinutes int            `json:"toleranceMinutes"`
+	Total            int            `json:"total"`
+	Locations        []SkewLocation `json:"locations"`
+	Rows             []SkewRow      `json:"rows"`
+	Truncated        bool           `json:"truncated,omitempty"`
+}
+
+// buildSkew reports skewed rows, newest files first, times in loc.
+func buildSkew(skewed []gymdata.SkewedRow, loc *time.Location) SkewResponse {
+	resp := SkewResponse{ToleranceMinutes: int(gymdata.SkewTolerance / time.Minute), Total: len(skewed), Locations: []SkewLocation{}, Rows: []SkewRow{}}
+	byName := map[string]*SkewLocation{}
+	for i := len(skewed) - 1; i >= 0; i-- {
+		s := skewed[i]
+		l := byName[s.Location]
+		if l == nil {
+			l = &SkewLocation{Name: s.Location, Latest: s.File}
+			byName[s.Location] = l
+		}
+		if s.Kind == gymdata.SkewFuture {
+			l.Future++
+		} else {
+			l.Backwards++
+		}
+		if len(resp.Rows) == maxSkewRows {
+			resp.Truncated = true
+			continue
+		}
+		resp.Rows = append(resp.Rows, SkewRow{
+			File:          s.File,
+			Location:      s.Location,
+			Timestamp:     s.Timestamp,
+			At:            time.Unix(s.At, 0).In(loc).Format(time.RFC3339),
+			Kind:          s.Kind,
+			Ref:           time.Unix(s.Ref, 0).In(loc).Format(time.RFC3339),
+			OffsetMinutes: int((s.At - s.Ref) / 60),
+		})
+	}
+	for _, l := range byName {
+		resp.Locations = append(resp.Locations, *l)
+	}
+	sort.Slice(resp.Locations, func(i, j int) bool { return resp.Locations[i].Name < resp.Locations[j].Name })
+	return resp
+}
+
+// skewHandler reports the rows left out of every chart because the
+// collector's clock was wrong when it wrote them: stamped after their file
+// was written, or going back over readings already there.
+//
+//	GET /api/skew[?from=YYYY-MM-DD&to=YYYY-MM-DD][&tz=ZONE]
+//
+// The range is of daily files and defaults to the last 30 days.
+func skewHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	tallinn := gymdata.Tallinn()
+	now := time.Now().In(tallinn)
+	q := r.URL.Query()
+	loc, err := requestZone(q.Get("tz"), tallinn)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fieldErr("tz", nil, err))
+		return
+	}
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, tallinn)
+	if t, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(q.Get("to")), tallinn); err == nil {
+		to = t
+	}
+	from := to.AddDate(0, 0, -29)
+	if t, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(q.Get("from")), tallinn); err == nil {
+		from = t
+	}
+	if from.After(to) {
+		writeError(w, http.StatusBadRequest, withKind(ErrBadRange, errors.New("from is after to")))
+		return
+	}
+
+	cfg := requestConfig(r)
+	files, err := gymdata.InRange(cfg.csvDir(), from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		files = nil // no CSVs at all: nothing skewed
+	}
+	skewed, err := gymdata.Skewed(cfg.format(), files)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, withKind(ErrParse, err))
+		return
+	}
+	resp := buildSkew(skewed, loc)
+	resp.From, resp.To = from.Format("2006-01-02"), to.Format("2006-01-02")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+func TestSkewHandler(t *testing.T) {
+	loadTallinn(t)
+	old := currentConfig()
+	defer setConfig(old)
+	dir := t.TempDir()
+	setConfig(&Config{DataDir: dir})
+	writeCSV(t, dir, "gym-stats-20251001.csv",
+		"timestamp,timezone,location_id,location_name,user_count,status,response\n"+
+			"2025-10-01 10:00:00,UTC,1,Hipodroom,10,success,{}\n"+
+			"2025-10-01 10:30:00,UTC,1,Hipodroom,12,success,{}\n"+
+			"2025-10-01 10:00:00,UTC,1,Hipodroom,40,success,{}\n"+
+			"2025-10-01 18:00:00,UTC,2,T1,30,success,{}\n")
+	written := time.Date(2025, 10, 1, 10, 31, 0, 0, time.UTC)
+	os.Chtimes(filepath.Join(dir, "gym-stats-20251001.csv"), written, written)
+
+	w := httptest.NewRecorder()
+	skewHandler(w, httptest.NewRequest("GET", "/api/skew?from=2025-10-01&to=2025-10-01&tz=UTC", nil))
+	var resp SkewResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != 200 {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	if resp.Total != 2 || len(resp.Rows) != 2 || len(resp.Locations) != 2 {
+		t.Fatalf("resp = %+v", resp)
+	}
+	// Newest first: T1 in the evening is after the file was written.
+	if r := resp.Rows[0]; r.Location != "T1" || r.Kind != gymdata.SkewFuture || r.At != "2025-10-01T18:00:00Z" || r.OffsetMinutes != 449 {
+		t.Errorf("first row = %+v", r)
+	}
+	if r := resp.Rows[1]; r.Location != "Hipodroom" || r.Kind != gymdata.SkewBackwards || r.Ref != "2025-10-01T10:30:00Z" {
+		t.Errorf("second row = %+v", r)
+	}
+	if l := resp.Locations[0]; l.Name != "Hipodroom" || l.Backwards != 1 || l.Future != 0 {
+		t.Errorf("Hipodroom = %+v", l)
+	}
+
+	w = httptest.NewRecorder()
+	skewHandler(w, httptest.NewRequest("GET", "/api/skew?from=2025-10-02&to=2025-10-01", nil))
+	if w.Code != 400 {
+		t.Errorf("from after to: %d", w.Code)
+	}
+}
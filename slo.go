@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// sloBurnWindows are the spans burn rates are given over besides the whole
+// SLO window: a 1h rate far above 1 is a page, a 1d one a ticket.
+var sloBurnWindows = []time.Duration{time.Hour, 6 * time.Hour, 24 * time.Hour}
+
+// SLOBurn is how an objective fared over one span: its attainment there,
+// and how many times faster than the budget allows it was spent.
+type SLOBurn struct {
+	Window     string  `json:"window"`
+	Attainment float64 `json:"attainment"`
+	BurnRate   float64 `json:"burnRate"`
+}
+
+// SLOObjective is one objective over the SLO window. BudgetRemaining is
+// the percentage of its error budget left; below 0 the objective is missed.
+type SLOObjective struct {
+	Name            string    `json:"name"`
+	Target          float64   `json:"target"`
+	Attainment      float64   `json:"attainment"`
+	BudgetRemaining float64   `json:"budgetRemaining"`
+	Burn            []SLOBurn `json:"burn"`
+}
+
+type SLOResponse struct {
+	From          string         `json:"from"`
+	To            string         `json:"to"`
+	WindowDays    int            `json:"windowDays"`
+	MaxAgeSeconds int64          `json:"maxAgeSeconds"`
+	AgeSeconds    int64          `json:"ageSeconds"` // -1 without readings
+	Stale         bool           `json:"stale"`
+	Objectives    []SLOObjective `json:"objectives"`
+}
+
+// staleSeconds is how long within [from, to) the newest of the sorted
+// readings was more than maxAge old, or there was none.
+func staleSeconds(times []int64, from, to, maxAge int64) int64 {
+	var stale int64
+	add := func(a, b int64) {
+		if a, b = max(a, from), min(b, to); b > a {
+			stale += b - a
+		}
+	}
+	freshUntil := int64(math.MinInt64)
+	for _, t := range times {
+		if t >= to {
+			break
+		}
+		if t > freshUntil {
+			add(freshUntil, t)
+		}
+		freshUntil = max(freshUntil, t+maxAge)
+	}
+	add(freshUntil, to)
+	return stale
+}
+
+// sloObjective rates an objective from the fraction of each span that was
+// bad: attainment is 100 less that, and the burn rate that over the budget
+// (100 less the target). The last span is the SLO window.
+func sloObjective(name string, target float64, spans []time.Duration, bad func(span time.Duration) float64) SLOObjective {
+	budget := 100 - target
+	obj := SLOObjective{Name: name, Target: target, Burn: []SLOBurn{}}
+	var b float64
+	for _, span := range spans {
+		b = bad(span) * 100
+		label := fmt.Sprintf("%dh", int(span.Hours()))
+		if span%(24*time.Hour) == 0 {
+			label = fmt.Sprintf("%dd", int(span.Hours()/24))
+		}
+		obj.Burn = append(obj.Burn, SLOBurn{
+			Window:     label,
+			Attainment: math.Round((100-b)*1000) / 1000,
+			BurnRate:   math.Round(b/budget*100) / 100,
+		})
+	}
+	obj.Attainment = obj.Burn[len(obj.Burn)-1].Attainment
+	obj.BudgetRemaining = math.Round((budget-b)/budget*1000) / 10
+	return obj
+}
+
+// buildSLO rates the collector over the window ending at now: polls are
+// every row of every gym, good unless STATUS_POLICY drops them, and the
+// data is fresh while some gym's newest good reading is at most maxAge old.
+func buildSLO(cfg *Config, cells map[string]map[string]*qualityCell, now time.Time) SLOResponse {
+	window := time.Duration(cfg.SLOWindowDays) * 24 * time.Hour
+	from := now.Add(-window)
+	var good, failed []int64
+	for _, day := range cells {
+		for _, cell := range day {
+			for _, t := range cell.times {
+				good = append(good, t.Unix())
+			}
+			for _, t := range cell.failed {
+				failed = append(failed, t.Unix())
+			}
+		}
+	}
+	slices.Sort(good)
+	slices.Sort(failed)
+	// since counts the readings in [start, now).
+	since := func(times []int64, start time.Time) int {
+		i := sort.Search(len(times), func(i int) bool { return times[i] >= start.Unix() })
+		j := sort.Search(len(times), func(i int) bool { return times[i] >= now.Unix() })
+		return j - i
+	}
+
+	spans := append(slices.Clone(sloBurnWindows), window)
+	spans = slices.DeleteFunc(spans, func(d time.Duration) bool { return d > window })
+	spans = slices.Compact(spans)
+	maxAge := int64(cfg.SLOMaxAge / time.Second)
+	resp := SLOResponse{
+		From:          from.Format(time.RFC3339),
+		To:            now.Format(time.RFC3339),
+		WindowDays:    cfg.SLOWindowDays,
+		MaxAgeSeconds: maxAge,
+		AgeSeconds:    -1,
+		Objectives: []SLOObjective{
+			sloObjective("success", cfg.SLOSuccess, spans, func(span time.Duration) float64 {
+				start := now.Add(-span)
+				bad := since(failed, start)
+				if total := bad + since(good, start); total > 0 {
+					return float64(bad) / float64(total)
+				}
+				return 0
+			}),
+			sloObjective("freshness", cfg.SLOFreshness, spans, func(span time.Duration) float64 {
+				return float64(staleSeconds(good, now.Add(-span).Unix(), now.Unix(), maxAge)) / span.Seconds()
+			}),
+		},
+	}
+	if i := sort.Search(len(good), func(i int) bool { return good[i] > now.Unix() }); i > 0 {
+		resp.AgeSeconds = now.Unix() - good[i-1]
+	}
+	resp.Stale = resp.AgeSeconds < 0 || resp.AgeSeconds > maxAge
+	return resp
+}
+
+// loadSLO reads the SLO window's rows and rates them.
+func loadSLO(cfg *Config, now time.Time) SLOResponse {
+	tallinn := gymdata.Tallinn()
+	from := now.Add(-time.Duration(cfg.SLOWindowDays) * 24 * time.Hour)
+	// The day before the window says whether it opens fresh.
+	files, err := windowFiles(cfg, from, now)
+	if err != nil {
+		files = nil // counted as no readings: stale
+	}
+	cells := map[string]map[string]*qualityCell{}
+	for _, f := range files {
+		scanQualityRows(cfg, f, tallinn, cells)
+	}
+	return buildSLO(cfg, cells, now)
+}
+
+// sloHandler reports the collector against its objectives.
+//
+//	GET /api/slo
+func sloHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	json.NewEncoder(w).Encode(loadSLO(requestConfig(r), time.Now()))
+}
+
+// sloAlerted is, per data directory, whether a stale-data alert is out, so
+// each outage alerts once and its end once.
+var sloAlerted = struct {
+	sync.Mutex
+	m map[string]bool
+}{m: map[string]bool{}}
+
+// checkFreshness alerts when cfg's newest reading turns older than
+// SLO_MAX_AGE_SECONDS, and again when readings resume. name is the tenant,
+// empty for the base config.
+func checkFreshness(cfg *Config, name string, now time.Time) {
+	status := readLatestStatus(cfg, gymdata.Tallinn())
+	stale := status.AgeSeconds < 0 || time.Duration(status.AgeSeconds)*time.Second > cfg.SLOMaxAge
+	sloAlerted.Lock()
+	was := sloAlerted.m[cfg.DataDir]
+	sloAlerted.m[cfg.DataDir] = stale
+	sloAlerted.Unlock()
+	if stale == was {
+		return
+	}
+	where := "The collector"
+	if name != "" {
+		where = "The " + name + " collector"
+	}
+	age := time.Duration(status.AgeSeconds) * time.Second
+	text := fmt.Sprintf("%s is back: the newest reading is %s old.", where, age)
+	switch {
+	case stale && status.AgeSeconds < 0:
+		text = fmt.Sprintf("%s has stopped: there are no readings.", where)
+	case stale:
+		text = fmt.Sprintf("%s has stopped: the newest reading is %s old, over %s.", where, age, cfg.SLOMaxAge)
+	}
+	log.Printf("SLO: %s", text)
+	sendSLOAlert(cfg, SLOAlert{Tenant: name, Stale: stale, AgeSeconds: status.AgeSeconds, Text: text, At: now.UTC().Format(time.RFC3339)})
+}
+
+// SLOAlert is what SLO_ALERT_URL is posted; Text makes it a Slack or
+// Mattermost incoming webhook's message as it is.
+type SLOAlert struct {
+	Text       string `json:"text"`
+	Tenant     string `json:"tenant,omitempty"`
+	Stale      bool   `json:"stale"`
+	AgeSeconds int64  `json:"ageSeconds"`
+	At         string `json:"at"`
+}
+
+func sendSLOAlert(cfg *Config, a SLOAlert) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	if cfg.SLOAlertURL != "" {
+		body, _ := json.Marshal(a)
+		resp, err := client.Post(cfg.SLOAlertURL, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				err = fmt.Errorf("%s", resp.Status)
+			}
+		}
+		if err != nil {
+			log.Printf("SLO: alert to %s: %v", cfg.SLOAlertURL, err)
+		}
+	}
+	for _, chat := range cfg.SLOAlertChats {
+		if err := telegramSend(client, cfg.TelegramToken, chat, a.Text); err != nil {
+			log.Printf("SLO: Telegram alert: %v", err)
+		}
+	}
+}
+
+// runFreshnessAlerts checks every minute that the base data directory and
+// each tenant's are still being written to.
+func runFreshnessAlerts() {
+	for range time.Tick(time.Minute) {
+		cfg := currentConfig()
+		checkFreshness(cfg, "", time.Now())
+		for name, t := range cfg.Tenants {
+			checkFreshness(t, name, time.Now())
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaleSeconds(t *testing.T) {
+	for _, tc := range []struct {
+		times []int64
+		want  int64
+	}{
+		{nil, 1000},
+		{[]int64{-50}, 950},   // fresh until 50
+		{[]int64{-200}, 1000}, // stale before the span opened
+		{[]int64{0, 100, 200, 600, 700, 800, 900}, 300}, // a gap from 300 to 600
+		{[]int64{-50, 950, 2000}, 900},                  // 2000 is past the span
+	} {
+		if got := staleSeconds(tc.times, 0, 1000, 100); got != tc.want {
+			t.Errorf("%v: stale %d, want %d", tc.times, got, tc.want)
+		}
+	}
+}
+
+func TestBuildSLO(t *testing.T) {
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	from := now.Add(-24 * time.Hour)
+	var good, failed []time.Time
+	for i := 0; i < 990; i++ { // a reading a minute for the window's first 16.5h
+		good = append(good, from.Add(time.Duration(i)*time.Minute))
+	}
+	for i := 0; i < 10; i++ { // then nothing but errors in the last hour
+		failed = append(failed, now.Add(-time.Duration(i+1)*time.Minute))
+	}
+	cells := map[string]map[string]*qualityCell{
+		"2026-03-01": {"A": {times: good[:600]}},
+		"2026-03-02": {"A": {times: good[600:], errors: len(failed), failed: failed}},
+	}
+	cfg := &Config{SLOSuccess: 99, SLOFreshness: 99.9, SLOMaxAge: 10 * time.Minute, SLOWindowDays: 1}
+	resp := buildSLO(cfg, cells, now)
+
+	if !resp.Stale || resp.AgeSeconds != int64(now.Sub(good[989])/time.Second) {
+		t.Errorf("age %d stale %v, want the last good reading's", resp.AgeSeconds, resp.Stale)
+	}
+	success, fresh := resp.Objectives[0], resp.Objectives[1]
+	if len(success.Burn) != 3 || success.Burn[2].Window != "1d" {
+		t.Fatalf("burn windows = %+v, want 1h, 6h and the 1d window", success.Burn)
+	}
+	// 10 of 1000 polls failed: exactly the budget.
+	if success.Attainment != 99 || success.BudgetRemaining != 0 {
+		t.Errorf("success = %+v", success)
+	}
+	if b := success.Burn[0]; b.Attainment != 0 || b.BurnRate != 100 {
+		t.Errorf("success 1h = %+v, want every poll failed", b)
+	}
+	// Fresh until 10 minutes after the last reading, 999 of 1440 minutes.
+	if fresh.Attainment != 69.375 || fresh.BudgetRemaining != -30525 {
+		t.Errorf("freshness = %+v", fresh)
+	}
+	if b := fresh.Burn[1]; b.Attainment != 0 || b.BurnRate != 1000 {
+		t.Errorf("freshness 6h = %+v", b)
+	}
+}
+
+func TestCheckFreshnessAlertsOnChange(t *testing.T) {
+	tallinn := loadTallinn(t)
+	dir := t.TempDir()
+	var alerts []SLOAlert
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a SLOAlert
+		json.NewDecoder(r.Body).Decode(&a)
+		alerts = append(alerts, a)
+	}))
+	defer srv.Close()
+	sloAlerted.Lock()
+	delete(sloAlerted.m, dir)
+	sloAlerted.Unlock()
+
+	cfg := &Config{DataDir: dir, SLOMaxAge: 10 * time.Minute, SLOAlertURL: srv.URL}
+	write := func(at time.Time) {
+		writeCSV(t, dir, "gym-stats-"+at.In(tallinn).Format("20060102")+".csv",
+			"timestamp,timezone,location_id,location_name,user_count,status,response\n"+
+				at.UTC().Format("2006-01-02 15:04:05")+",,1,Hipodroom,5,success,\"{}\"\n")
+	}
+	write(time.Now().Add(-time.Minute))
+	checkFreshness(cfg, "", time.Now())
+	if len(alerts) != 0 {
+		t.Fatalf("fresh data alerted: %+v", alerts)
+	}
+	write(time.Now().Add(-30 * time.Minute))
+	checkFreshness(cfg, "east", time.Now())
+	checkFreshness(cfg, "east", time.Now())
+	if len(alerts) != 1 || !alerts[0].Stale || alerts[0].Tenant != "east" || !strings.Contains(alerts[0].Text, "has stopped") {
+		t.Fatalf("alerts = %+v, want one stale alert", alerts)
+	}
+	write(time.Now())
+	checkFreshness(cfg, "east", time.Now())
+	if len(alerts) != 2 || alerts[1].Stale || !strings.Contains(alerts[1].Text, "is back") {
+		t.Errorf("alerts = %+v, want a recovery", alerts)
+	}
+}
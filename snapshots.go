@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// A range of past days is also saved as a snapshot: the range's
+// gym-data.json under a name hashed from its content, served from /data/ to
+// be cached for good. A day that changes later (a backfill) hashes to a new
+// name rather than going stale under the old one.
+const (
+	snapshotDir   = "gym-snapshots"
+	maxSnapshots  = 64 // the oldest go first; the API hands out their new names
+	snapshotCache = "public, max-age=31536000, immutable"
+)
+
+var snapshotName = regexp.MustCompile(`^[0-9a-f]{16}\.json$`)
+
+// rangeClosed says whether nothing the collector writes can change w: it
+// ends by the start of today in Tallinn, where the daily files roll over.
+func rangeClosed(w rangeWindow, now time.Time) bool {
+	y, m, d := now.In(gymdata.Tallinn()).Date()
+	return !w.to.After(time.Date(y, m, d, 0, 0, 0, 0, gymdata.Tallinn()))
+}
+
+// writeSnapshot saves the series as a snapshot, unless one with the same
+// content exists, and returns its URL relative to the server root.
+func writeSnapshot(cfg *Config, list []*gymdata.Series, loc *time.Location) (string, error) {
+	var buf bytes.Buffer
+	if err := writeDatasets(&buf, list, pointFormat{loc: loc}, true); err != nil {
+		return "", err
+	}
+	buf.WriteString("\n")
+	sum := sha256.Sum256(buf.Bytes())
+	name := hex.EncodeToString(sum[:8]) + ".json"
+	dir := cfg.path(snapshotDir)
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err == nil {
+		now := time.Now()
+		os.Chtimes(path, now, now) // in use again, so pruned last
+		return "data/" + name, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+		return "", err
+	}
+	pruneSnapshots(dir)
+	return "data/" + name, nil
+}
+
+// pruneSnapshots removes all but the maxSnapshots most recently used.
+func pruneSnapshots(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	type snap struct {
+		name string
+		used time.Time
+	}
+	var snaps []snap
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && snapshotName.MatchString(e.Name()) {
+			snaps = append(snaps, snap{e.Name(), info.ModTime()})
+		}
+	}
+	if len(snaps) <= maxSnapshots {
+		return
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].used.After(snaps[j].used) })
+	for _, s := range snaps[maxSnapshots:] {
+		os.Remove(filepath.Join(dir, s.name))
+	}
+}
+
+// snapshotHandler serves a range snapshot by the name a chart reply gave.
+//
+//	GET /data/HASH.json
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/data/")
+	if !snapshotName.MatchString(name) {
+		http.NotFound(w, r)
+		return
+	}
+	serveAsset(w, r, filepath.Join(requestConfig(r).path(snapshotDir), name), snapshotCache)
+}
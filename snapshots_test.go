@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRangeSnapshots(t *testing.T) {
+	tallinn := loadTallinn(t)
+	old := currentConfig()
+	defer setConfig(old)
+	dir := t.TempDir()
+	setConfig(&Config{DataDir: dir, AuditLog: "gym-audit.jsonl", AnnotationsFile: "gym-annotations.json", PrefsFile: "gym-prefs.json"})
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	writeCSV(t, dir, "gym-stats-20251001.csv", header+"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,{}\n")
+	today := time.Now().In(tallinn)
+	writeCSV(t, dir, "gym-stats-"+today.Format("20060102")+".csv", header+today.Format("2006-01-02")+" 00:00:01,,1,Hipodroom,3,success,{}\n")
+
+	post := func(body string) GenerateResponse {
+		w := httptest.NewRecorder()
+		generateDataRangeHandler(w, httptest.NewRequest("POST", "/generate-data-range", strings.NewReader(body)))
+		var resp GenerateResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || !resp.Success {
+			t.Fatalf("%s: %s", body, w.Body)
+		}
+		if cc := w.Header().Get("Cache-Control"); cc != "no-cache" {
+			t.Errorf("%s: Cache-Control %q", body, cc)
+		}
+		return resp
+	}
+	past := post(`{"from": "2025-10-01", "to": "2025-10-01"}`)
+	if !snapshotName.MatchString(strings.TrimPrefix(past.Snapshot, "data/")) {
+		t.Fatalf("snapshot = %q", past.Snapshot)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "gym-data.json"))
+
+	w := httptest.NewRecorder()
+	snapshotHandler(w, httptest.NewRequest("GET", "/"+past.Snapshot, nil))
+	if w.Code != 200 || w.Body.String() != string(data) || w.Header().Get("Cache-Control") != snapshotCache || w.Header().Get("Last-Modified") == "" {
+		t.Errorf("GET %s: code %d, headers %v, body %q; want gym-data.json", past.Snapshot, w.Code, w.Header(), w.Body)
+	}
+	for _, bad := range []string{"/data/../gym-data.json", "/data/0123456789abcdef.json", "/data/x.json"} {
+		w := httptest.NewRecorder()
+		snapshotHandler(w, httptest.NewRequest("GET", bad, nil))
+		if w.Code != 404 {
+			t.Errorf("GET %s: code %d, want 404", bad, w.Code)
+		}
+	}
+
+	// Built again it has the same content, so the same name.
+	if again := post(`{"from": "2025-10-01", "to": "2025-10-01", "metrics": ["user_count"]}`); again.Snapshot != past.Snapshot {
+		t.Errorf("rebuilt snapshot = %q, want %q", again.Snapshot, past.Snapshot)
+	}
+	// Today can still change: no snapshot.
+	if live := post(`{"from": "2025-10-01", "to": "` + today.Format("2006-01-02") + `"}`); live.Snapshot != "" {
+		t.Errorf("range to today has snapshot %q", live.Snapshot)
+	}
+}
+
+func TestPruneSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+	for i := range maxSnapshots + 2 {
+		path := writeCSV(t, dir, fmt.Sprintf("%016x.json", i), "[]")
+		at := start.Add(time.Duration(i) * time.Minute)
+		os.Chtimes(path, at, at)
+	}
+	pruneSnapshots(dir)
+	for i, want := range map[int]bool{0: false, 1: false, 2: true, maxSnapshots + 1: true} {
+		if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("%016x.json", i))); (err == nil) != want {
+			t.Errorf("snapshot %d kept = %v, want %v", i, err == nil, want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// webAssets are the kinds of file served from WEB_DIR, by extension, with
+// the Cache-Control each is sent with. Pages and the app manifest are
+// revalidated on every load so a deploy shows at once; images and scripts
+// may be kept for a day.
+var webAssets = map[string]string{
+	".html":        "no-cache",
+	".json":        "no-cache",
+	".webmanifest": "no-cache",
+	".css":         "public, max-age=86400",
+	".js":          "public, max-age=86400",
+	".svg":         "public, max-age=86400",
+	".png":         "public, max-age=86400",
+	".ico":         "public, max-age=86400",
+	".woff2":       "public, max-age=86400",
+}
+
+// staticFiles serves the pages and their assets from WEB_DIR, shared by
+// every tenant, and a tenant's gym-data.json from its own directory. Nothing
+// else is reachable: not directories, dotfiles or files of other kinds such
+// as the daily CSVs and gym-config.env.
+func staticFiles() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		p := path.Clean("/" + r.URL.Path)
+		if p == "/" {
+			// Relative, so a /t/NAME/ tenant lands on its own dashboard.
+			w.Header().Set("Location", "dashboard.html")
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+		for _, part := range strings.Split(p[1:], "/") {
+			if strings.HasPrefix(part, ".") {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		if strings.HasPrefix(path.Base(p), "gym-") {
+			if p != "/gym-data.json" {
+				http.NotFound(w, r)
+				return
+			}
+			serveAsset(w, r, requestConfig(r).path("gym-data.json"), "no-cache")
+			return
+		}
+		cache, ok := webAssets[strings.ToLower(path.Ext(p))]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		serveAsset(w, r, filepath.Join(currentConfig().WebDir, filepath.FromSlash(p)), cache)
+	})
+}
+
+// serveAsset sends one file, handling ranges and conditional requests (by
+// Last-Modified, or an ETag from its size and mtime); a directory or a
+// missing file is a 404.
+func serveAsset(w http.ResponseWriter, r *http.Request, file, cache string) {
+	f, err := os.Open(file)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", cache)
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStaticFiles(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+	web, data := t.TempDir(), t.TempDir()
+	writeCSV(t, web, "dashboard.html", "<html>")
+	writeCSV(t, web, "icon.png", "png")
+	writeCSV(t, web, ".env", "SECRET=1")
+	writeCSV(t, web, "notes.txt", "hi")
+	writeCSV(t, web, "gym-stats-20251001.csv", "timestamp\n")
+	os.Mkdir(filepath.Join(web, "img"), 0o755)
+	writeCSV(t, filepath.Join(web, "img"), "logo.svg", "<svg/>")
+	writeCSV(t, data, "gym-data.json", "{}")
+	writeCSV(t, data, "gym-prefs.json", "{}")
+	setConfig(&Config{WebDir: web, DataDir: data})
+
+	h := staticFiles()
+	for _, c := range []struct {
+		method, path string
+		code         int
+		cache        string
+	}{
+		{"GET", "/dashboard.html", 200, "no-cache"},
+		{"HEAD", "/dashboard.html", 200, "no-cache"},
+		{"GET", "/icon.png", 200, "public, max-age=86400"},
+		{"GET", "/img/logo.svg", 200, "public, max-age=86400"},
+		{"GET", "/gym-data.json", 200, "no-cache"},
+		{"GET", "/", 302, ""},
+		{"GET", "/img/", 404, ""},
+		{"GET", "/img", 404, ""},
+		{"GET", "/.env", 404, ""},
+		{"GET", "/img/../.env", 404, ""},
+		{"GET", "/notes.txt", 404, ""},
+		{"GET", "/gym-stats-20251001.csv", 404, ""},
+		{"GET", "/gym-prefs.json", 404, ""},
+		{"GET", "/img/gym-data.json", 404, ""},
+		{"GET", "/missing.html", 404, ""},
+		{"POST", "/dashboard.html", 405, ""},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(c.method, c.path, nil))
+		if w.Code != c.code || w.Header().Get("Cache-Control") != c.cache {
+			t.Errorf("%s %s: code %d, Cache-Control %q; want %d, %q", c.method, c.path, w.Code, w.Header().Get("Cache-Control"), c.code, c.cache)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if loc := w.Header().Get("Location"); loc != "dashboard.html" {
+		t.Errorf("/ redirects to %q", loc)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// jsonFloat formats f the way encoding/json does.
+func jsonFloat(b []byte, f float64) []byte {
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	b = strconv.AppendFloat(b, f, format, -1, 64)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		if n := len(b); n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b
+}
+
+// jsonY formats a point's value, null for the NaN of a gap gymdata.Align
+// left unfilled.
+func jsonY(b []byte, y float64) []byte {
+	if math.IsNaN(y) {
+		return append(b, "null"...)
+	}
+	return jsonFloat(b, y)
+}
+
+// pointFormat is how points are written: an object with x an RFC 3339 time
+// in loc, or with millis a Unix time in milliseconds, which Chart.js takes
+// without parsing a date per point. With pairs each point is a bare [x, y]
+// array instead, and a dataset's flagged points are listed by x in its own
+// "flagged" array. loc still aligns the buckets either way.
+type pointFormat struct {
+	loc    *time.Location
+	millis bool
+	pairs  bool
+}
+
+// requestPointFormat reads a request's timestamps option, "iso" (the
+// default) or "ms", and its points option, "objects" (the default) or
+// "pairs".
+func requestPointFormat(loc *time.Location, timestamps, points string) (pointFormat, error) {
+	pf := pointFormat{loc: loc}
+	switch strings.ToLower(strings.TrimSpace(timestamps)) {
+	case "", "iso":
+	case "ms":
+		pf.millis = true
+	default:
+		return pointFormat{}, fmt.Errorf("timestamps must be iso or ms")
+	}
+	switch strings.ToLower(strings.TrimSpace(points)) {
+	case "", "objects":
+	case "pairs":
+		pf.pairs = true
+	default:
+		return pointFormat{}, fmt.Errorf("points must be objects or pairs")
+	}
+	return pf, nil
+}
+
+// writeDatasets encodes the series as a JSON array of Dataset objects one
+// point at a time, so the full []Dataset never exists in memory. indent
+// matches json.Encoder's SetIndent("", "  ") layout. Written to an HTTP
+// response, each location's datasets are flushed to the client as they are
+// done, so a wide range starts arriving before the last gym is encoded, and
+// a client that has gone away stops the encoding there.
+func writeDatasets(w io.Writer, list []*gymdata.Series, pf pointFormat, indent bool) error {
+	bw := bufio.NewWriter(w)
+	flusher, _ := w.(http.Flusher)
+	nl := func(depth int) {
+		if indent {
+			bw.WriteByte('\n')
+			for i := 0; i < depth; i++ {
+				bw.WriteString("  ")
+			}
+		}
+	}
+	field := func(name string) {
+		bw.WriteString(`"` + name + `":`)
+		if indent {
+			bw.WriteByte(' ')
+		}
+	}
+	str := func(s string) {
+		b, _ := json.Marshal(s)
+		bw.Write(b)
+	}
+
+	bw.WriteByte('[')
+	num := make([]byte, 0, 32)
+	for i, s := range list {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		nl(1)
+		bw.WriteByte('{')
+		nl(2)
+		field("label")
+		str(s.Key.Label())
+		if s.Key.Metric != "" {
+			bw.WriteByte(',')
+			nl(2)
+			field("metric")
+			str(s.Key.Metric)
+		}
+		bw.WriteByte(',')
+		nl(2)
+		field("data")
+		bw.WriteByte('[')
+		var flagged map[int64]bool
+		if len(s.Flagged) > 0 {
+			flagged = make(map[int64]bool, len(s.Flagged))
+			for _, at := range s.Flagged {
+				flagged[at] = true
+			}
+		}
+		x := func(at int64) {
+			if pf.millis {
+				num = strconv.AppendInt(num[:0], at*1000, 10)
+				bw.Write(num)
+			} else {
+				bw.WriteByte('"')
+				bw.WriteString(time.Unix(at, 0).In(pf.loc).Format("2006-01-02T15:04:05Z07:00"))
+				bw.WriteByte('"')
+			}
+		}
+		for j, p := range s.Points {
+			if j > 0 {
+				bw.WriteByte(',')
+			}
+			nl(3)
+			if pf.pairs {
+				bw.WriteByte('[')
+				x(p.At)
+				bw.WriteByte(',')
+				bw.Write(jsonY(num[:0], p.Y))
+				bw.WriteByte(']')
+				continue
+			}
+			bw.WriteByte('{')
+			nl(4)
+			field("x")
+			x(p.At)
+			bw.WriteByte(',')
+			nl(4)
+			field("y")
+			num = jsonY(num[:0], p.Y)
+			bw.Write(num)
+			if flagged[p.At] {
+				bw.WriteByte(',')
+				nl(4)
+				field("flagged")
+				bw.WriteString("true")
+			}
+			nl(3)
+			bw.WriteByte('}')
+		}
+		if len(s.Points) > 0 {
+			nl(2)
+		}
+		bw.WriteByte(']')
+		if pf.pairs && len(flagged) > 0 {
+			bw.WriteByte(',')
+			nl(2)
+			field("flagged")
+			bw.WriteByte('[')
+			n := 0
+			for _, p := range s.Points {
+				if flagged[p.At] {
+					if n > 0 {
+						bw.WriteByte(',')
+					}
+					x(p.At)
+					n++
+				}
+			}
+			bw.WriteByte(']')
+		}
+		nl(1)
+		bw.WriteByte('}')
+		if flusher != nil && (i+1 == len(list) || list[i+1].Key.Location != s.Key.Location) {
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+	}
+	if len(list) > 0 {
+		nl(0)
+	}
+	bw.WriteByte(']')
+	return bw.Flush()
+}
+
+// dataFiles remembers, per gym-data.json path, the cache key of the build
+// last written there.
+var (
+	dataFilesMu sync.Mutex
+	dataFiles   = map[string]string{}
+)
+
+// dataFileCurrent says whether the config's gym-data.json already holds the
+// build key names, and is still newer than its newest input (so a file
+// edited or replaced since is written again).
+func dataFileCurrent(cfg *Config, key string, newest time.Time) bool {
+	path := cfg.path("gym-data.json")
+	dataFilesMu.Lock()
+	written := dataFiles[path]
+	dataFilesMu.Unlock()
+	info, err := os.Stat(path)
+	return written == key && err == nil && !info.ModTime().Before(newest)
+}
+
+// writeDataFile streams the series to the config's gym-data.json in the
+// indented layout the file has always had, noting that it now holds the
+// build key names.
+func writeDataFile(cfg *Config, key string, list []*gymdata.Series, loc *time.Location) error {
+	path := cfg.path("gym-data.json")
+	dataFilesMu.Lock()
+	delete(dataFiles, path)
+	dataFilesMu.Unlock()
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeDatasets(file, list, pointFormat{loc: loc}, true); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.WriteString("\n"); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	dataFilesMu.Lock()
+	dataFiles[path] = key
+	dataFilesMu.Unlock()
+	return nil
+}
+
+// writeGenerateResponse writes resp with the series streamed in as its
+// "datasets" field.
+func writeGenerateResponse(w io.Writer, resp GenerateResponse, list []*gymdata.Series, pf pointFormat) error {
+	resp.Datasets = nil
+	head, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(head[:len(head)-1]); err != nil { // drop the closing brace
+		return err
+	}
+	if _, err := io.WriteString(w, `,"datasets":`); err != nil {
+		return err
+	}
+	if err := writeDatasets(w, list, pf, false); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "}\n")
+	return err
+}
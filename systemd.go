@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// sdListenFDsStart is the first descriptor systemd passes a socket-activated
+// service; see sd_listen_fds(3).
+const sdListenFDsStart = 3
+
+// shutdownGrace is how long requests in flight get to finish on SIGTERM,
+// well inside systemd's default TimeoutStopSec of 90s.
+const shutdownGrace = 20 * time.Second
+
+// shuttingDown is closed when the server starts draining (main registers it
+// with Shutdown), so the event streams end and their browsers reconnect to
+// the next process.
+var shuttingDown = make(chan struct{})
+
+// systemdListener returns the socket systemd passed us (gym.socket), or nil
+// when not socket-activated. With the socket held by systemd, connections
+// made while the service restarts wait in its backlog instead of being
+// refused.
+func systemdListener() (net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	if n > 1 {
+		return nil, fmt.Errorf("systemd passed %d sockets, want one", n)
+	}
+	name := "LISTEN_FD_3"
+	if names := os.Getenv("LISTEN_FDNAMES"); names != "" {
+		name = strings.Split(names, ":")[0]
+	}
+	syscall.CloseOnExec(sdListenFDsStart)
+	file := os.NewFile(sdListenFDsStart, name)
+	defer file.Close() // the listener holds its own copy
+	return net.FileListener(file)
+}
+
+// sdNotify sends state to systemd (Type=notify), as sd_notify(3) does; it
+// does nothing outside systemd.
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:] // abstract namespace
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// notifyReady tells systemd the server is up once the startup preload is
+// done, so a restart counts as finished when /readyz would say 200. It then
+// pets the watchdog if the unit sets WatchdogSec.
+func notifyReady() {
+	for {
+		preloadState.Lock()
+		running := preloadState.running
+		preloadState.Unlock()
+		if !running {
+			break
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	upgradeReady()
+	if err := sdNotify("READY=1\nSTATUS=Serving"); err != nil {
+		log.Printf("systemd: %v", err)
+		return
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	for range time.Tick(time.Duration(usec) * time.Microsecond / 2) {
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			log.Printf("systemd: %v", err)
+		}
+	}
+}
+
+// serve runs srv on l until SIGTERM or SIGINT, then stops taking new
+// connections and waits up to shutdownGrace for the rest. SIGUSR2 hands l
+// to a new copy of the binary (see upgrade) and, once it is serving, drains
+// the same way.
+func serve(srv *http.Server, l net.Listener, stop <-chan os.Signal) error {
+	done := make(chan error, 1)
+	go func() {
+		sig := <-stop
+		for sig == syscall.SIGUSR2 {
+			log.Printf("Upgrade: starting %s", executable)
+			pid, err := upgrade(l)
+			if err == nil {
+				log.Printf("Upgrade: process %d is serving, draining requests", pid)
+				sdNotify(fmt.Sprintf("MAINPID=%d", pid))
+				break
+			}
+			log.Printf("Upgrade: %v; still serving", err)
+			sig = <-stop
+		}
+		if sig != syscall.SIGUSR2 {
+			log.Printf("Shutdown: %v, draining requests", sig)
+			sdNotify("STOPPING=1")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+		defer cancel()
+		done <- srv.Shutdown(ctx)
+	}()
+	if err := srv.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return <-done
+}
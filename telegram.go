@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+const telegramAPI = "https://api.telegram.org/bot"
+
+const telegramHelp = `Commands:
+/now [gym] — current occupancy
+/best [today|tomorrow|mon..sun|YYYY-MM-DD] [HH-HH] [gym] — quietest hours`
+
+type telegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+		Text string `json:"text"`
+	} `json:"message"`
+}
+
+// matchLocation resolves a user-typed gym name: an exact (case-insensitive)
+// match wins, otherwise a unique prefix or substring.
+func matchLocation(query string, names []string) (string, bool) {
+	q := strings.ToLower(strings.TrimSpace(query))
+	if q == "" {
+		return "", false
+	}
+	var prefix, contains []string
+	for _, n := range names {
+		l := strings.ToLower(n)
+		switch {
+		case l == q:
+			return n, true
+		case strings.HasPrefix(l, q):
+			prefix = append(prefix, n)
+		case strings.Contains(l, q):
+			contains = append(contains, n)
+		}
+	}
+	if len(prefix) == 1 {
+		return prefix[0], true
+	}
+	if len(prefix) == 0 && len(contains) == 1 {
+		return contains[0], true
+	}
+	return "", false
+}
+
+// parseDayWord resolves "today", "tomorrow", a weekday name (its next
+// occurrence, today included) or YYYY-MM-DD relative to now.
+func parseDayWord(word string, now time.Time) (time.Time, bool) {
+	w := strings.ToLower(word)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch w {
+	case "today":
+		return today, true
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), true
+	}
+	if len(w) >= 3 {
+		for d := 0; d < 7; d++ {
+			wd := time.Weekday(d)
+			if strings.HasPrefix(strings.ToLower(wd.String()), w) {
+				ahead := (int(wd) - int(today.Weekday()) + 7) % 7
+				return today.AddDate(0, 0, ahead), true
+			}
+		}
+	}
+	if t, err := time.ParseInLocation("2006-01-02", word, now.Location()); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// parseBestArgs splits "/best" arguments into day, hour range and gym name.
+// The day and range are recognised anywhere; whatever is left is the gym.
+func parseBestArgs(args []string, now time.Time) (day time.Time, earliest, latest int, location string) {
+	day = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	earliest, latest = 0, 24
+	var rest []string
+	dayFound, rangeFound := false, false
+	for _, a := range args {
+		if !dayFound {
+			if d, ok := parseDayWord(a, now); ok {
+				day, dayFound = d, true
+				continue
+			}
+		}
+		if !rangeFound && strings.Contains(a, "-") {
+			if e, l, ok := parseHourRange(a); ok {
+				earliest, latest, rangeFound = e, l, true
+				continue
+			}
+		}
+		rest = append(rest, a)
+	}
+	return day, earliest, latest, strings.Join(rest, " ")
+}
+
+// telegramReply answers one message using the same query layer as the HTTP
+// endpoints (readLatestStatus, queryRecommendations).
+func telegramReply(text string, tallinn *time.Location, now time.Time) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return telegramHelp
+	}
+	cmd, _, _ := strings.Cut(strings.ToLower(fields[0]), "@") // "/now@my_bot"
+	args := fields[1:]
+
+	// The bot answers for the base deployment, not for tenants.
+	cfg := currentConfig()
+	switch cmd {
+	case "/now":
+		status := readLatestStatus(cfg, tallinn)
+		if status.AgeSeconds < 0 {
+			return "No readings yet."
+		}
+		locs := status.Locations
+		if len(args) > 0 {
+			names := make([]string, len(locs))
+			for i, l := range locs {
+				names[i] = l.Name
+			}
+			name, ok := matchLocation(strings.Join(args, " "), names)
+			if !ok {
+				return fmt.Sprintf("Unknown gym %q. Known: %s", strings.Join(args, " "), strings.Join(names, ", "))
+			}
+			for _, l := range locs {
+				if l.Name == name {
+					locs = []StatusLocation{l}
+				}
+			}
+		}
+		var b strings.Builder
+		for _, l := range locs {
+			at := l.At
+			if t, err := time.Parse("2006-01-02T15:04:05Z07:00", l.At); err == nil {
+				at = t.In(tallinn).Format("15:04")
+			}
+			fmt.Fprintf(&b, "%s: %d people (%s)\n", l.Name, l.Count, at)
+		}
+		if status.AgeSeconds > 15*60 {
+			fmt.Fprintf(&b, "⚠️ Last reading %s ago — collection may be stalled.\n", (time.Duration(status.AgeSeconds) * time.Second).Round(time.Minute))
+		}
+		return strings.TrimSpace(b.String())
+
+	case "/best":
+		day, earliest, latest, location := parseBestArgs(args, now)
+		if location != "" {
+			acc, _, _, err := collectBusyness(cfg, tallinn, nil, nil, nil)
+			if err != nil {
+				return "Could not read data: " + err.Error()
+			}
+			names := make([]string, 0, len(acc))
+			for n := range acc {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			name, ok := matchLocation(location, names)
+			if !ok {
+				return fmt.Sprintf("Unknown gym %q. Known: %s", location, strings.Join(names, ", "))
+			}
+			location = name
+		}
+		top := 1
+		if location != "" {
+			top = 3
+		}
+		results, err := queryRecommendations(cfg, tallinn, recommendationQuery{
+			Location: location, Day: day, Top: top, Window: 1, Earliest: earliest, Latest: latest,
+		})
+		if err != nil {
+			return "No data yet."
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "Quietest on %s %s (%02d:00–%02d:00):\n", day.Weekday(), day.Format("2006-01-02"), earliest, latest)
+		for _, res := range results {
+			if len(res.Slots) == 0 {
+				fmt.Fprintf(&b, "%s: no open hours in that window\n", res.Location)
+				continue
+			}
+			for _, s := range res.Slots {
+				fmt.Fprintf(&b, "%s: %s–%s · ~%s people (confidence %.0f%%)\n",
+					res.Location, s.Start, s.End, strconv.FormatFloat(s.Predicted, 'f', -1, 64), s.Confidence*100)
+			}
+		}
+		return strings.TrimSpace(b.String())
+
+	default:
+		return telegramHelp
+	}
+}
+
+// runTelegramBot long-polls getUpdates and answers commands. Chats outside
+// TELEGRAM_ALLOWED_CHATS (when set) are ignored. It idles while no token is
+// configured and picks up token/allow-list changes on the next poll.
+func runTelegramBot() {
+	tallinn := gymdata.Tallinn()
+
+	client := &http.Client{Timeout: 70 * time.Second}
+	var offset int64
+	var token string
+
+	for {
+		c := currentConfig()
+		if c.TelegramToken == "" {
+			token = ""
+			time.Sleep(30 * time.Second)
+			continue
+		}
+		if c.TelegramToken != token {
+			token, offset = c.TelegramToken, 0
+			log.Printf("Telegram: bot polling started")
+		}
+		allowed := map[int64]bool{}
+		for _, id := range c.TelegramAllowedChats {
+			allowed[id] = true
+		}
+		base := telegramAPI + token
+
+		resp, err := client.Get(base + "/getUpdates?timeout=50&offset=" + strconv.FormatInt(offset, 10))
+		if err != nil {
+			log.Printf("Telegram: getUpdates: %v", telegramError(err))
+			time.Sleep(10 * time.Second)
+			continue
+		}
+		var body struct {
+			OK          bool             `json:"ok"`
+			Description string           `json:"description"`
+			Result      []telegramUpdate `json:"result"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil || !body.OK {
+			log.Printf("Telegram: getUpdates failed: %v %s", err, body.Description)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		for _, u := range body.Result {
+			offset = u.UpdateID + 1
+			if u.Message == nil || u.Message.Text == "" {
+				continue
+			}
+			chatID := u.Message.Chat.ID
+			if len(allowed) > 0 && !allowed[chatID] {
+				continue
+			}
+			reply := telegramReply(u.Message.Text, tallinn, time.Now().In(tallinn))
+			if err := telegramSend(client, token, chatID, reply); err != nil {
+				log.Printf("Telegram: %v", err)
+			}
+		}
+	}
+}
+
+// telegramError strips the request URL, which holds the bot token, from a
+// failed request's error.
+func telegramError(err error) error {
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		return uerr.Err
+	}
+	return err
+}
+
+// telegramSend posts text to a chat as token's bot.
+func telegramSend(client *http.Client, token string, chatID int64, text string) error {
+	payload, _ := json.Marshal(map[string]any{"chat_id": chatID, "text": text})
+	resp, err := client.Post(telegramAPI+token+"/sendMessage", "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("sendMessage: %v", telegramError(err))
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("sendMessage: %s", resp.Status)
+	}
+	return nil
+}
+
+// parseChatIDs reads a comma-separated TELEGRAM_ALLOWED_CHATS list.
+func parseChatIDs(s string) ([]int64, error) {
+	var ids []int64
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chat id %q", part)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
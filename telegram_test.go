@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchLocation(t *testing.T) {
+	names := []string{"Hipodroom", "Mustika", "Suur-Paala", "T1"}
+	for in, want := range map[string]string{"t1": "T1", "hipo": "Hipodroom", "paala": "Suur-Paala", " MUSTIKA ": "Mustika"} {
+		if got, ok := matchLocation(in, names); !ok || got != want {
+			t.Errorf("matchLocation(%q) = %q, %v; want %q", in, got, ok, want)
+		}
+	}
+	for _, in := range []string{"", "xyz", "a"} { // "a" is in several names
+		if got, ok := matchLocation(in, names); ok {
+			t.Errorf("matchLocation(%q) = %q, want no match", in, got)
+		}
+	}
+}
+
+func TestParseBestArgs(t *testing.T) {
+	now := time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC) // a Wednesday
+
+	day, e, l, loc := parseBestArgs([]string{"tomorrow", "18-20"}, now)
+	if day.Format("2006-01-02") != "2026-10-15" || e != 18 || l != 20 || loc != "" {
+		t.Errorf("got %s %d-%d %q, want 2026-10-15 18-20 no gym", day.Format("2006-01-02"), e, l, loc)
+	}
+
+	day, e, l, loc = parseBestArgs([]string{"Suur-Paala", "mon"}, now)
+	if day.Format("2006-01-02") != "2026-10-19" || e != 0 || l != 24 || loc != "Suur-Paala" {
+		t.Errorf("got %s %d-%d %q, want next Monday, whole day, Suur-Paala", day.Format("2006-01-02"), e, l, loc)
+	}
+
+	day, _, _, loc = parseBestArgs([]string{"wednesday", "SQ", "Kristiine"}, now)
+	if day.Format("2006-01-02") != "2026-10-14" || loc != "SQ Kristiine" {
+		t.Errorf("got %s %q, want today and a two-word gym", day.Format("2006-01-02"), loc)
+	}
+}
+
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestTelegramSendHidesToken(t *testing.T) {
+	const token = "123456:secret-token"
+	err := telegramSend(&http.Client{Transport: failingTransport{}}, token, 1, "hi")
+	if err == nil {
+		t.Fatal("send over a failing transport succeeded")
+	}
+	if strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("error %q carries the bot token", err)
+	}
+}
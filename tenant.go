@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type tenantKey struct{}
+
+// requestConfig is the config a request runs under: its tenant's, or the base
+// config when tenancy is off or the request names no tenant.
+func requestConfig(r *http.Request) *Config {
+	if c, ok := r.Context().Value(tenantKey{}).(*Config); ok {
+		return c
+	}
+	return currentConfig()
+}
+
+// requestTenant names the tenant a request is for, from a /t/NAME/ path
+// prefix, an X-Tenant header or a NAME. subdomain, in that order. rest is the
+// path with any prefix removed. Only the subdomain is matched loosely: a host
+// that isn't a tenant is simply the base deployment.
+func requestTenant(r *http.Request, tenants map[string]*Config) (name, rest string) {
+	if after, ok := strings.CutPrefix(r.URL.Path, "/t/"); ok {
+		name, rest, _ = strings.Cut(after, "/")
+		return name, "/" + rest
+	}
+	if h := strings.TrimSpace(r.Header.Get("X-Tenant")); h != "" {
+		return h, r.URL.Path
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if sub, _, ok := strings.Cut(host, "."); ok && tenants[sub] != nil {
+		return sub, r.URL.Path
+	}
+	return "", r.URL.Path
+}
+
+// withTenant runs each request under its tenant's config, with a path prefix
+// stripped so the routes below see the usual paths.
+func withTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenants := currentConfig().Tenants
+		if len(tenants) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		name, rest := requestTenant(r, tenants)
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c := tenants[name]
+		if c == nil {
+			writeError(w, http.StatusNotFound, errors.New("unknown tenant "+name))
+			return
+		}
+		// The pages use relative links, which only resolve under /t/NAME/.
+		if r.URL.Path == "/t/"+name {
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), tenantKey{}, c))
+		u := *r.URL
+		u.Path, u.RawPath = rest, ""
+		r.URL = &u
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadTenants(t *testing.T) {
+	dir := t.TempDir()
+	acme, fit := filepath.Join(dir, "acme"), filepath.Join(dir, "fit")
+	for _, d := range []string{acme, fit} {
+		if err := os.Mkdir(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(acme, "gym-config.env"), []byte("CSV_COLUMNS=location_name=Gym\nAPI_KEYS=acme:tok:admin\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	base := func(key, def string) string {
+		if key == "CORS_ORIGINS" {
+			return "https://gym.example"
+		}
+		return def
+	}
+
+	tenants, err := loadTenants("acme="+acme+", fit="+fit, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := tenants["acme"]; a == nil || a.DataDir != acme || a.CSVColumns["Gym"] != "location_name" || len(a.APIKeys) != 1 || len(a.CORSOrigins) != 1 {
+		t.Errorf("acme = %+v, want its own columns and keys over the base origins", a)
+	}
+	if f := tenants["fit"]; f == nil || len(f.CSVColumns) != 0 || f.path("gym-audit.jsonl") != filepath.Join(fit, "gym-audit.jsonl") {
+		t.Errorf("fit = %+v, want base settings and files in its dir", f)
+	}
+
+	for _, bad := range []string{"acme", "Acme=" + acme, "acme=" + acme + ",acme=" + fit, "gone=" + filepath.Join(dir, "gone")} {
+		if _, err := loadTenants(bad, base); err == nil {
+			t.Errorf("loadTenants(%q): expected error", bad)
+		}
+	}
+}
+
+func TestWithTenant(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+	acme := &Config{DataDir: "/srv/acme"}
+	setConfig(&Config{Tenants: map[string]*Config{"acme": acme}})
+
+	var gotDir, gotPath string
+	h := withTenant(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotDir, gotPath = requestConfig(r).DataDir, r.URL.Path
+	}))
+	cases := []struct {
+		name, host, header, path string
+		wantDir, wantPath        string
+		wantCode                 int
+	}{
+		{"no tenant", "gym.example", "", "/status", "", "/status", 200},
+		{"path prefix", "gym.example", "", "/t/acme/status", "/srv/acme", "/status", 200},
+		{"header", "gym.example", "acme", "/status", "/srv/acme", "/status", 200},
+		{"subdomain", "acme.gym.example:8002", "", "/status", "/srv/acme", "/status", 200},
+		{"other subdomain is the base", "www.gym.example", "", "/status", "", "/status", 200},
+		{"unknown header", "gym.example", "nope", "/status", "", "", 404},
+		{"unknown prefix", "gym.example", "", "/t/nope/status", "", "", 404},
+		{"bare prefix", "gym.example", "", "/t/acme", "", "", 301},
+	}
+	for _, c := range cases {
+		gotDir, gotPath = "", ""
+		r := httptest.NewRequest("GET", c.path, nil)
+		r.Host = c.host
+		if c.header != "" {
+			r.Header.Set("X-Tenant", c.header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != c.wantCode || gotDir != c.wantDir || gotPath != c.wantPath {
+			t.Errorf("%s: code %d, dir %q, path %q; want %d, %q, %q", c.name, w.Code, gotDir, gotPath, c.wantCode, c.wantDir, c.wantPath)
+		}
+	}
+}
+
+func TestTenantDataIsolated(t *testing.T) {
+	old := currentConfig()
+	defer setConfig(old)
+	dir := t.TempDir()
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	writeCSV(t, dir, "gym-stats-20251001.csv", header+"2025-10-01 10:00:00,EEST,1,Acme Gym,12,success,\"{}\"\n")
+	setConfig(&Config{Tenants: map[string]*Config{"acme": {DataDir: dir}}})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", statusHandler)
+	mux.Handle("/", staticFiles())
+	h := withTenant(mux)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/t/acme/status", nil))
+	var status StatusResponse
+	json.NewDecoder(w.Body).Decode(&status)
+	if len(status.Locations) != 1 || status.Locations[0].Name != "Acme Gym" {
+		t.Errorf("tenant status = %+v, want its own gym", status)
+	}
+
+	writeCSV(t, dir, "gym-data.json", `{"acme": true}`)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/t/acme/gym-data.json", nil))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "acme") {
+		t.Errorf("tenant gym-data.json: code %d, %s", w.Code, w.Body)
+	}
+	for _, name := range []string{"gym-stats-20251001.csv", "gym-config.env"} {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/t/acme/"+name, nil))
+		if w.Code != 404 {
+			t.Errorf("tenant %s: code %d, want 404", name, w.Code)
+		}
+	}
+}
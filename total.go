@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// totalLabel is the location the summed series goes under.
+const totalLabel = "Total"
+
+// requestTotal reads a request's total option: "" for none, "all" for every
+// gym, or a comma-separated list of gyms to sum.
+func requestTotal(total string) (locations []string, ok bool) {
+	total = strings.TrimSpace(total)
+	if total == "" {
+		return nil, false
+	}
+	if strings.EqualFold(total, "all") {
+		return nil, true
+	}
+	for _, name := range strings.Split(total, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			locations = append(locations, name)
+		}
+	}
+	return locations, len(locations) > 0
+}
+
+// bridgeGap is the longest gap, in seconds, a sum of series interpolates a
+// series across. Readings are bucketed by then, so a gym's missing bucket is
+// bridged rather than dropping the sum to the rest; a longer gap, or a gym
+// that hasn't started yet, leaves the sum out there.
+func bridgeGap(bucketMinutes int) int64 {
+	return int64(max(10*time.Minute, 2*time.Duration(bucketMinutes)*time.Minute) / time.Second)
+}
+
+// withTotal returns list with, per metric, the sum of the chosen gyms
+// appended as a "Total" series. A club split into areas is summed as its
+// rolled-up whole, so a named club counts all its areas and one that also
+// reports itself isn't counted twice. list itself, which may be cached, is
+// not changed.
+func withTotal(list []*gymdata.Series, total string, bucketMinutes int) []*gymdata.Series {
+	locations, ok := requestTotal(total)
+	if !ok {
+		return list
+	}
+	maxGap := bridgeGap(bucketMinutes)
+	clubs := gymdata.RollUpAreas(list, maxGap)
+	out := list[:len(list):len(list)]
+	seen := map[string]bool{}
+	for _, s := range list {
+		if seen[s.Key.Metric] {
+			continue
+		}
+		seen[s.Key.Metric] = true
+		if sum := gymdata.Total(clubs, s.Key.Metric, totalLabel, locations, maxGap); sum != nil {
+			out = append(out, sum)
+		}
+	}
+	return out
+}
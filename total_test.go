@@ -0,0 +1,40 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"gym/internal/gymdata"
+)
+
+func TestWithTotal(t *testing.T) {
+	series := func(location, metric string, ys ...float64) *gymdata.Series {
+		s := &gymdata.Series{Key: gymdata.Key{Location: location, Metric: metric}}
+		for i, y := range ys {
+			s.Points = append(s.Points, gymdata.Point{At: int64(i) * 3600, Y: y})
+		}
+		return s
+	}
+	list := []*gymdata.Series{
+		series("Hipodroom", "user_count", 10, 20),
+		series("Hipodroom", "queue_length", 1, 2),
+		series("T1", "user_count", 5, 6),
+	}
+
+	if got := withTotal(list, "", 60); len(got) != 3 {
+		t.Errorf("no total: %d series", len(got))
+	}
+	got := withTotal(list, "all", 60)
+	if len(got) != 5 || len(list) != 3 {
+		t.Fatalf("all: %d series (list now %d), want a total per metric and list untouched", len(got), len(list))
+	}
+	if got[3].Key.Label() != "Total" || !slices.Equal(got[3].Points, []gymdata.Point{{At: 0, Y: 15}, {At: 3600, Y: 26}}) {
+		t.Errorf("user_count total = %+v", got[3])
+	}
+	if got[4].Key.Label() != "Total (queue_length)" {
+		t.Errorf("second total = %+v", got[4].Key)
+	}
+	if got := withTotal(list, " T1 , ", 60); len(got) != 4 || got[3].Points[1].Y != 6 {
+		t.Errorf("T1 only = %+v", got)
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// Spans are kept in memory and sent in batches to an OpenTelemetry
+// collector over OTLP/HTTP with the JSON encoding, which every collector
+// accepts on :4318, so tracing needs no SDK.
+const (
+	traceBatch   = 512
+	traceBacklog = 4096 // spans held while the collector is unreachable
+	traceFlush   = 5 * time.Second
+)
+
+// traceSpan is one timed step of a traced request. A nil span is tracing
+// switched off: its methods do nothing, so call sites needn't check.
+type traceSpan struct {
+	traceID [16]byte
+	spanID  [8]byte
+	parent  [8]byte
+	name    string
+	server  bool
+	start   time.Time
+	end     time.Time
+	attrs   map[string]any
+	err     string
+}
+
+type spanKey struct{}
+
+var (
+	tracesMu     sync.Mutex
+	tracesQueued []*traceSpan
+	tracesKick   = make(chan struct{}, 1)
+	tracesClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// startTrace starts a request's root span, continuing the caller's trace
+// when traceparent (the W3C header) names one it sampled. Without
+// OTEL_EXPORTER_OTLP_ENDPOINT it returns a nil span.
+func startTrace(ctx context.Context, name, traceparent string) (context.Context, *traceSpan) {
+	if currentConfig().TraceEndpoint == "" {
+		return ctx, nil
+	}
+	s := &traceSpan{name: name, server: true, start: time.Now()}
+	if parts := strings.Split(strings.TrimSpace(traceparent), "-"); len(parts) == 4 && parts[0] == "00" {
+		tid, err1 := hex.DecodeString(parts[1])
+		pid, err2 := hex.DecodeString(parts[2])
+		if err1 == nil && err2 == nil && len(tid) == 16 && len(pid) == 8 {
+			if flags, err := strconv.ParseUint(parts[3], 16, 8); err == nil && flags&1 == 0 {
+				return ctx, nil // the caller isn't recording this trace
+			}
+			copy(s.traceID[:], tid)
+			copy(s.parent[:], pid)
+		}
+	}
+	if s.traceID == [16]byte{} {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// startSpan starts a step within ctx's trace; a nil span if ctx has none.
+func startSpan(ctx context.Context, name string) (context.Context, *traceSpan) {
+	parent, _ := ctx.Value(spanKey{}).(*traceSpan)
+	s := parent.child(name, time.Now())
+	if s == nil {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// child starts a span under s at start, for steps timed after the fact.
+func (s *traceSpan) child(name string, start time.Time) *traceSpan {
+	if s == nil {
+		return nil
+	}
+	c := &traceSpan{traceID: s.traceID, parent: s.spanID, name: name, start: start}
+	rand.Read(c.spanID[:])
+	return c
+}
+
+// set records an attribute: a string, bool, int or float64.
+func (s *traceSpan) set(key string, value any) {
+	if s == nil {
+		return
+	}
+	if s.attrs == nil {
+		s.attrs = map[string]any{}
+	}
+	s.attrs[key] = value
+}
+
+// finish ends s, marking it failed if err is set, and queues it for export.
+func (s *traceSpan) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	if err != nil {
+		s.err = err.Error()
+	}
+	tracesMu.Lock()
+	if len(tracesQueued) >= traceBacklog {
+		tracesQueued = tracesQueued[1:]
+	}
+	tracesQueued = append(tracesQueued, s)
+	full := len(tracesQueued) >= traceBatch
+	tracesMu.Unlock()
+	if full {
+		select {
+		case tracesKick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// withTracing gives each request a root span named for the mux pattern it
+// matched, like withMetrics, so the steps below it can hang off it.
+func withTracing(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := startTrace(r.Context(), r.Method+" "+route, r.Header.Get("traceparent"))
+		if span == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		span.set("http.request.method", r.Method)
+		span.set("http.route", route)
+		span.set("url.path", r.URL.Path)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+		span.set("http.response.status_code", rec.code)
+		var err error
+		if rec.code >= 500 {
+			err = fmt.Errorf("%s", http.StatusText(rec.code))
+		}
+		span.finish(err)
+	})
+}
+
+// traceLoad is gymdata.LoadProgress with a span for the load and one per
+// file under it, timed from one file's end to the next.
+func traceLoad(ctx context.Context, cfg *Config, files []string, metrics []string, progress func(files int, rows *gymdata.RowCounts)) ([]*gymdata.Series, *gymdata.RowCounts, error) {
+	_, span := startSpan(ctx, "load")
+	span.set("files", len(files))
+	last, lastRows := time.Now(), 0
+	list, rows, err := gymdata.LoadProgress(cfg.format(), files, metrics, func(done int, rows *gymdata.RowCounts) {
+		if span != nil {
+			file := span.child("parse "+filepath.Base(files[done-1]), last)
+			file.set("rows", rows.Total()-lastRows)
+			file.finish(nil)
+			last, lastRows = time.Now(), rows.Total()
+		}
+		if progress != nil {
+			progress(done, rows)
+		}
+	})
+	if rows != nil {
+		span.set("rows", rows.Total())
+	}
+	span.finish(err)
+	return list, rows, err
+}
+
+// traceRollups is gymdata.LoadRollups from cfg's RollupDir under a span.
+func traceRollups(ctx context.Context, cfg *Config, files []string, bucketMinutes int, progress func(files int, rows *gymdata.RowCounts)) ([]*gymdata.Series, *gymdata.RowCounts, int, error) {
+	_, span := startSpan(ctx, "load rollups")
+	span.set("files", len(files))
+	list, rows, resolution, err := gymdata.LoadRollups(cfg.format(), files, cfg.path(cfg.RollupDir), bucketMinutes, progress)
+	span.set("resolutionMinutes", resolution)
+	span.set("bucketMinutes", bucketMinutes)
+	if rows != nil {
+		span.set("rows", rows.Total())
+	}
+	span.finish(err)
+	return list, rows, resolution, err
+}
+
+// traceDiscover is gymdata.InRange under a span.
+func traceDiscover(ctx context.Context, dir, from, to string) ([]string, error) {
+	_, span := startSpan(ctx, "discover files")
+	files, err := gymdata.InRange(dir, from, to)
+	span.set("files", len(files))
+	span.finish(err)
+	return files, err
+}
+
+// otlpValue is an attribute value in OTLP's JSON mapping, where 64-bit
+// integers are strings.
+func otlpValue(v any) map[string]any {
+	switch v := v.(type) {
+	case bool:
+		return map[string]any{"boolValue": v}
+	case int:
+		return map[string]any{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]any{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]any{"doubleValue": v}
+	}
+	return map[string]any{"stringValue": fmt.Sprint(v)}
+}
+
+func otlpAttrs(attrs map[string]any) []map[string]any {
+	out := make([]map[string]any, 0, len(attrs))
+	for _, k := range sortedKeys(attrs) {
+		out = append(out, map[string]any{"key": k, "value": otlpValue(attrs[k])})
+	}
+	return out
+}
+
+// otlpRequest is the OTLP/JSON export request for spans.
+func otlpRequest(service string, spans []*traceSpan) ([]byte, error) {
+	out := make([]map[string]any, 0, len(spans))
+	for _, s := range spans {
+		span := map[string]any{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              1, // internal
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttrs(s.attrs),
+		}
+		if s.parent != [8]byte{} {
+			span["parentSpanId"] = hex.EncodeToString(s.parent[:])
+		}
+		if s.server {
+			span["kind"] = 2
+		}
+		if s.err != "" {
+			span["status"] = map[string]any{"code": 2, "message": s.err}
+		}
+		out = append(out, span)
+	}
+	return json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource":   map[string]any{"attributes": otlpAttrs(map[string]any{"service.name": service})},
+			"scopeSpans": []any{map[string]any{"scope": map[string]any{"name": "gym"}, "spans": out}},
+		}},
+	})
+}
+
+// flushTraces sends the queued spans to the collector, a batch at a time.
+// A batch it refuses stays queued for the next try.
+func flushTraces(c *Config) error {
+	for {
+		tracesMu.Lock()
+		batch := tracesQueued[:min(len(tracesQueued), traceBatch)]
+		tracesMu.Unlock()
+		if len(batch) == 0 || c.TraceEndpoint == "" {
+			return nil
+		}
+		body, err := otlpRequest(c.ServiceName, batch)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest("POST", c.TraceEndpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range c.TraceHeaders {
+			req.Header.Set(k, v)
+		}
+		resp, err := tracesClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("%s: %s", c.TraceEndpoint, resp.Status)
+		}
+		tracesMu.Lock()
+		// Spans only join at the end, and a full queue drops its oldest, so
+		// the batch is whatever is left of the queue up to its last span.
+		for i, s := range tracesQueued {
+			if s == batch[len(batch)-1] {
+				tracesQueued = tracesQueued[i+1:]
+				break
+			}
+		}
+		tracesMu.Unlock()
+	}
+}
+
+// runTraceExporter flushes the spans every few seconds, or as soon as a
+// batch is full. Like the other integrations it idles while unconfigured.
+func runTraceExporter() {
+	ticker := time.NewTicker(traceFlush)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ticker.C:
+		case <-tracesKick:
+		}
+		if err := flushTraces(currentConfig()); err != nil {
+			if !failing {
+				log.Printf("Tracing: %v", err)
+			}
+			failing = true
+		} else {
+			failing = false
+		}
+	}
+}
+
+// parseOTLPHeaders reads OTEL_EXPORTER_OTLP_HEADERS: comma-separated
+// key=value pairs, values percent-encoded.
+func parseOTLPHeaders(s string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, pair := range splitList(s) {
+		k, v, ok := strings.Cut(pair, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("%q is not key=value", pair)
+		}
+		value, err := url.PathUnescape(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", k, err)
+		}
+		out[k] = value
+	}
+	return out, nil
+}
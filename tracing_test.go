@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTracing(t *testing.T) {
+	loadTallinn(t)
+	var bodies [][]byte
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, body)
+	}))
+	defer collector.Close()
+	old := currentConfig()
+	defer setConfig(old)
+	cfg := &Config{TraceEndpoint: collector.URL + "/v1/traces", TraceHeaders: map[string]string{"Authorization": "Bearer t"}, ServiceName: "gym-test"}
+	setConfig(cfg)
+	tracesMu.Lock()
+	tracesQueued = nil
+	tracesMu.Unlock()
+
+	dir := t.TempDir()
+	file := writeCSV(t, dir, "gym-stats-20251001.csv", "timestamp,timezone,location_id,location_name,user_count,status,response\n"+
+		"2025-10-01 10:00:00,EEST,1,Hipodroom,12,success,{}\n"+
+		"2025-10-01 10:02:00,EEST,1,Hipodroom,13,success,{}\n")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/generate-data", func(w http.ResponseWriter, r *http.Request) {
+		if _, _, err := traceLoad(r.Context(), cfg, []string{file}, nil, nil); err != nil {
+			t.Error(err)
+		}
+	})
+	h := withTracing(mux, mux)
+	r := httptest.NewRequest("GET", "/generate-data", nil)
+	r.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	// An unsampled caller's trace isn't recorded.
+	r = httptest.NewRequest("GET", "/generate-data", nil)
+	r.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319d-b7ad6b7169203331-00")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if err := flushTraces(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 1 || len(tracesQueued) != 0 {
+		t.Fatalf("%d exports, %d spans left", len(bodies), len(tracesQueued))
+	}
+	var got struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID, SpanID, ParentSpanID, Name string
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(bodies[0], &got); err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]string{} // name to span ID
+	parents := map[string]string{}
+	for _, s := range got.ResourceSpans[0].ScopeSpans[0].Spans {
+		if s.TraceID != "0af7651916cd43dd8448eb211c80319c" {
+			t.Errorf("%s: trace %s", s.Name, s.TraceID)
+		}
+		ids[s.Name], parents[s.Name] = s.SpanID, s.ParentSpanID
+	}
+	if len(ids) != 3 || parents["GET /generate-data"] != "b7ad6b7169203331" ||
+		parents["load"] != ids["GET /generate-data"] || parents["parse gym-stats-20251001.csv"] != ids["load"] {
+		t.Errorf("spans %v, parents %v", ids, parents)
+	}
+
+	// Without an endpoint nothing is recorded.
+	setConfig(&Config{})
+	if _, span := startTrace(t.Context(), "GET /", ""); span != nil {
+		t.Error("span started with tracing off")
+	}
+}
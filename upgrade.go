@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// upgradeEnv marks a process started by upgrade: it finds the listening
+// socket at descriptor 3 and the pipe to tell its parent it is ready at 4.
+const upgradeEnv = "GYM_UPGRADE"
+
+// upgradeTimeout is how long a new process gets to finish its startup
+// preload and take over, as systemd's TimeoutStartSec gives a fresh start.
+const upgradeTimeout = 5 * time.Minute
+
+// executable is the path this binary was started from, taken before a
+// deploy can replace the file, so an upgrade runs whatever is there now.
+var executable, _ = os.Executable()
+
+// upgradeParent is the pipe to the process that started this one in an
+// upgrade, until upgradeReady closes it.
+var upgradeParent struct {
+	sync.Mutex
+	pipe *os.File
+}
+
+// upgradeListener returns the socket a parent handed over in an upgrade,
+// or nil when this process wasn't started by one.
+func upgradeListener() (net.Listener, error) {
+	if os.Getenv(upgradeEnv) == "" {
+		return nil, nil
+	}
+	os.Unsetenv(upgradeEnv)
+	syscall.CloseOnExec(3)
+	syscall.CloseOnExec(4)
+	upgradeParent.pipe = os.NewFile(4, "upgrade-ready")
+	file := os.NewFile(3, "upgrade-listener")
+	defer file.Close() // the listener holds its own copy
+	return net.FileListener(file)
+}
+
+// upgradeReady tells the parent, if any, that this process is serving, so
+// it can stop taking connections and drain.
+func upgradeReady() {
+	upgradeParent.Lock()
+	defer upgradeParent.Unlock()
+	if upgradeParent.pipe == nil {
+		return
+	}
+	if _, err := upgradeParent.pipe.Write([]byte("ready\n")); err != nil {
+		log.Printf("Upgrade: %v", err)
+	}
+	upgradeParent.pipe.Close()
+	upgradeParent.pipe = nil
+}
+
+// upgrade starts the binary again, with the same arguments and l's socket,
+// and waits for it to say it is ready. The old process stays in charge
+// until then: a new one that fails to start is killed and nothing changes.
+func upgrade(l net.Listener) (int, error) {
+	fl, ok := l.(interface{ File() (*os.File, error) })
+	if !ok {
+		return 0, fmt.Errorf("can't hand over a %T", l)
+	}
+	sock, err := fl.File()
+	if err != nil {
+		return 0, err
+	}
+	defer sock.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	// The watchdog names this process; the new one is made systemd's main
+	// process once it is ready.
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "WATCHDOG_PID=") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env, upgradeEnv+"=1")
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{sock, w}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	r.SetReadDeadline(time.Now().Add(upgradeTimeout))
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if line != "ready\n" {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if err == nil || errors.Is(err, io.EOF) {
+			err = fmt.Errorf("exited before it was ready")
+		}
+		return 0, fmt.Errorf("new process %d: %v", cmd.Process.Pid, err)
+	}
+	go cmd.Wait() // reaped if this process outlives it
+	return cmd.Process.Pid, nil
+}
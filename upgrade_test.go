@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestUpgradeChild is the new process in TestUpgrade, run from the test
+// binary; on its own it does nothing.
+func TestUpgradeChild(t *testing.T) {
+	if os.Getenv(upgradeEnv) == "" {
+		t.Skip("only run by TestUpgrade")
+	}
+	l, err := upgradeListener()
+	if err != nil || l == nil {
+		t.Fatalf("no socket handed over: %v", err)
+	}
+	upgradeReady()
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "new")
+	})}
+	time.AfterFunc(10*time.Second, func() { srv.Close() })
+	srv.Serve(l)
+}
+
+func TestUpgrade(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	oldExe, oldArgs := executable, os.Args
+	defer func() { executable, os.Args = oldExe, oldArgs }()
+
+	executable = "/bin/false"
+	if _, err := upgrade(l); err == nil || !strings.Contains(err.Error(), "before it was ready") {
+		t.Errorf("failed start: %v, want an error", err)
+	}
+
+	executable, os.Args = os.Args[0], []string{os.Args[0], "-test.run=^TestUpgradeChild$"}
+	pid, err := upgrade(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if p, err := os.FindProcess(pid); err == nil {
+			p.Kill()
+		}
+	}()
+	l.Close() // as draining would
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatalf("after the handover: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "new" {
+		t.Errorf("served %q, want the new process's reply", body)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// usualWeeks is how many weeks back a reading is compared with.
+const usualWeeks = 12
+
+// usualMinDays is how many of those days need readings at its time for a
+// reading to be compared at all: with one or two, any reading is at one end.
+const usualMinDays = 4
+
+// usualLevels label a reading by its percentile among the same weekday and
+// time over the usualWeeks before: each applies below its bound.
+var usualLevels = []struct {
+	below        float64
+	level, label string
+}{
+	{10, "much-quieter", "much quieter than usual"},
+	{30, "quieter", "quieter than usual"},
+	{70, "usual", "as usual"},
+	{90, "busier", "busier than usual"},
+	{math.Inf(1), "much-busier", "much busier than usual"},
+}
+
+// UsualLocation is a gym's newest reading against the days before at the
+// same weekday and time. Percentile is the share of those days, 0-100,
+// that were quieter, counting ties as half.
+type UsualLocation struct {
+	Name       string  `json:"name"`
+	Count      int     `json:"count"`
+	At         string  `json:"at"`
+	Slot       string  `json:"slot"` // "HH:MM", the bucket At falls in
+	Percentile float64 `json:"percentile"`
+	Level      string  `json:"level"`
+	Label      string  `json:"label"`
+	P10        float64 `json:"p10"`
+	P50        float64 `json:"p50"`
+	P90        float64 `json:"p90"`
+	Days       int     `json:"days"`
+}
+
+type UsualResponse struct {
+	From          string          `json:"from"`
+	To            string          `json:"to"`
+	BucketMinutes int             `json:"bucketMinutes"`
+	Locations     []UsualLocation `json:"locations"`
+}
+
+// slotValues is each day's average of s in the bucketMinutes slot starting
+// at minute of the Tallinn day, over the days in [from, to) on weekday.
+func slotValues(s *gymdata.Series, bucketMinutes, minute int, weekday time.Weekday, from, to time.Time, tallinn *time.Location) []float64 {
+	type cell struct {
+		sum   float64
+		count int
+	}
+	byDay := map[string]*cell{}
+	clock := wallClock(tallinn)
+	for _, p := range s.Points {
+		d, m, _ := clock(p.At)
+		if d.Before(from) || !d.Before(to) || d.Weekday() != weekday || m/bucketMinutes != minute/bucketMinutes {
+			continue
+		}
+		day := d.Format("2006-01-02")
+		if byDay[day] == nil {
+			byDay[day] = &cell{}
+		}
+		byDay[day].sum += p.Y
+		byDay[day].count++
+	}
+	values := make([]float64, 0, len(byDay))
+	for _, c := range byDay {
+		values = append(values, c.sum/float64(c.count))
+	}
+	sort.Float64s(values)
+	return values
+}
+
+// percentileRank is the percentage of sorted below v, ties counting half.
+func percentileRank(sorted []float64, v float64) float64 {
+	below := sort.SearchFloat64s(sorted, v)
+	equal := sort.Search(len(sorted), func(i int) bool { return sorted[i] > v }) - below
+	return (float64(below) + float64(equal)/2) / float64(len(sorted)) * 100
+}
+
+// compareUsual places count, read at at, among s's days in [from, to). It is
+// false with fewer than usualMinDays of them, or when the gym was closed
+// then on every one.
+func compareUsual(s *gymdata.Series, count int, at time.Time, bucketMinutes int, from, to time.Time, tallinn *time.Location) (UsualLocation, bool) {
+	at = at.In(tallinn)
+	minute := at.Hour()*60 + at.Minute()
+	values := slotValues(s, bucketMinutes, minute, at.Weekday(), from, to, tallinn)
+	if len(values) < usualMinDays || values[len(values)-1] == 0 {
+		return UsualLocation{}, false
+	}
+	round := func(v float64) float64 { return math.Round(v*10) / 10 }
+	loc := UsualLocation{
+		Name:       s.Key.Location,
+		Count:      count,
+		At:         at.Format(time.RFC3339),
+		Slot:       slotLabel(minute/bucketMinutes*bucketMinutes, false),
+		Percentile: round(percentileRank(values, float64(count))),
+		P10:        round(percentile(values, 0.1)),
+		P50:        round(percentile(values, 0.5)),
+		P90:        round(percentile(values, 0.9)),
+		Days:       len(values),
+	}
+	for _, l := range usualLevels {
+		if loc.Percentile < l.below {
+			loc.Level, loc.Label = l.level, l.label
+			break
+		}
+	}
+	return loc, true
+}
+
+// buildUsual compares each gym's newest reading, or location's, as of now
+// with the 12 weeks before now's day in bucketMinutes slots. A location no
+// gym goes by is an ErrNoData error.
+func buildUsual(cfg *Config, location string, bucketMinutes int, now time.Time) (UsualResponse, error) {
+	tallinn := gymdata.Tallinn()
+	now = now.In(tallinn)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, tallinn)
+	from := today.AddDate(0, 0, -7*usualWeeks)
+	resp := UsualResponse{
+		From:          from.Format("2006-01-02"),
+		To:            today.AddDate(0, 0, -1).Format("2006-01-02"),
+		BucketMinutes: bucketMinutes,
+		Locations:     []UsualLocation{},
+	}
+	status := readLatestStatus(cfg, tallinn)
+	known := location == ""
+	for _, l := range status.Locations {
+		known = known || strings.EqualFold(l.Name, location)
+	}
+	if !known {
+		return resp, withKind(ErrNoData, fmt.Errorf("no data for location %q", location))
+	}
+	if len(status.Locations) == 0 {
+		return resp, nil
+	}
+	list, err := loadWindow(cfg, from, today, []string{gymdata.DefaultMetric})
+	if err != nil {
+		return resp, err
+	}
+	series := map[string]*gymdata.Series{}
+	for _, s := range list {
+		series[s.Key.Location] = s
+	}
+	for _, l := range status.Locations {
+		if location != "" && !strings.EqualFold(l.Name, location) {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, l.At)
+		if err != nil || now.Sub(at) > cfg.SLOMaxAge || series[l.Name] == nil {
+			continue
+		}
+		if loc, ok := compareUsual(series[l.Name], l.Count, at, bucketMinutes, from, today, tallinn); ok {
+			resp.Locations = append(resp.Locations, loc)
+		}
+	}
+	return resp, nil
+}
+
+// usualHandler says, per gym, how its newest reading compares with the same
+// weekday and time of day over the 12 weeks before today. With the default
+// hour buckets its levels are what /api/popular-times/{location} gives as
+// the live status.
+//
+//	GET /api/usual[?location=NAME][&bucket=MIN]
+//
+// Gyms whose newest reading is older than SLO_MAX_AGE_SECONDS, or with
+// readings at that time on fewer than usualMinDays days before, are left
+// out; a location no gym goes by is a 404.
+func usualHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	bucketMinutes := 60
+	if s := q.Get("bucket"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 2 || n > 120 || 24*60%n != 0 {
+			writeError(w, http.StatusBadRequest, errors.New("bucket must be 2-120 minutes and divide the day"))
+			return
+		}
+		bucketMinutes = n
+	}
+
+	resp, err := buildUsual(requestConfig(r), strings.TrimSpace(q.Get("location")), bucketMinutes, time.Now())
+	if errors.Is(err, ErrNoData) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+func TestCompareUsual(t *testing.T) {
+	tallinn := loadTallinn(t)
+	monday := time.Date(2025, 10, 6, 0, 0, 0, 0, tallinn)
+	// Ten Mondays reading 1 to 10 at 18:05, and a Tuesday reading 100.
+	s := &gymdata.Series{Key: gymdata.Key{Location: "A", Metric: gymdata.DefaultMetric}}
+	for w := 0; w < 10; w++ {
+		at := monday.AddDate(0, 0, 7*w).Add(18*time.Hour + 5*time.Minute)
+		s.Points = append(s.Points, gymdata.Point{At: at.Unix(), Y: float64(w + 1)})
+	}
+	s.Points = append(s.Points, gymdata.Point{At: monday.AddDate(0, 0, 1).Add(18 * time.Hour).Unix(), Y: 100})
+	now := monday.AddDate(0, 0, 70).Add(18*time.Hour + 10*time.Minute)
+	today := monday.AddDate(0, 0, 70)
+
+	for _, tc := range []struct {
+		count      int
+		percentile float64
+		level      string
+	}{
+		{0, 0, "much-quieter"},
+		{3, 25, "quieter"},
+		{5, 45, "usual"},
+		{9, 85, "busier"},
+		{10, 95, "much-busier"},
+		{50, 100, "much-busier"},
+	} {
+		got, ok := compareUsual(s, tc.count, now, 15, monday, today, tallinn)
+		if !ok {
+			t.Fatal("no history")
+		}
+		if got.Percentile != tc.percentile || got.Level != tc.level {
+			t.Errorf("count %d: percentile %v %s, want %v %s", tc.count, got.Percentile, got.Level, tc.percentile, tc.level)
+		}
+		if got.Slot != "18:00" || got.Days != 10 || got.P50 != 5.5 {
+			t.Errorf("count %d: %+v", tc.count, got)
+		}
+	}
+	if _, ok := compareUsual(s, 5, now.Add(time.Hour), 15, monday, today, tallinn); ok {
+		t.Error("a time with no history was compared")
+	}
+	// Fewer days than usualMinDays say nothing about what is usual.
+	few := &gymdata.Series{Key: s.Key, Points: s.Points[10-usualMinDays : 10]}
+	if _, ok := compareUsual(few, 50, now, 15, monday, today, tallinn); !ok {
+		t.Errorf("%d days not compared", len(few.Points))
+	}
+	few.Points = few.Points[1:]
+	if _, ok := compareUsual(few, 50, now, 15, monday, today, tallinn); ok {
+		t.Errorf("%d days compared", len(few.Points))
+	}
+	closed := &gymdata.Series{Points: []gymdata.Point{{At: monday.Add(6 * time.Hour).Unix(), Y: 0}}}
+	if _, ok := compareUsual(closed, 5, now.Add(-12*time.Hour), 15, monday, today, tallinn); ok {
+		t.Error("a time the gym was always closed was compared")
+	}
+}
+
+// usualRow is a count Hipodroom read at at.
+type usualRow struct {
+	at    time.Time
+	count int
+}
+
+// writeUsual writes each row to its Tallinn day's file, modified at the
+// row's time so the last written holds the latest status.
+func writeUsual(t *testing.T, dir string, rows ...usualRow) {
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	for _, r := range rows {
+		f := writeCSV(t, dir, "gym-stats-"+r.at.In(gymdata.Tallinn()).Format("20060102")+".csv",
+			header+r.at.UTC().Format("2006-01-02 15:04:05")+",,1,Hipodroom,"+strconv.Itoa(r.count)+",success,\"{}\"\n")
+		os.Chtimes(f, r.at, r.at)
+	}
+}
+
+func TestBuildUsual(t *testing.T) {
+	tallinn := loadTallinn(t)
+	saved := currentConfig()
+	defer setConfig(saved)
+	cfg := &Config{DataDir: t.TempDir(), SLOMaxAge: 10 * time.Minute}
+	setConfig(cfg)
+
+	// Four Mondays read 10, 20, 30 and 40 at 18:05; this one reads 35 at 18:20.
+	monday := time.Date(2026, 3, 16, 0, 0, 0, 0, tallinn)
+	now := monday.Add(18*time.Hour + 25*time.Minute)
+	for w := 4; w >= 1; w-- {
+		writeUsual(t, cfg.DataDir, usualRow{monday.AddDate(0, 0, -7*w).Add(18*time.Hour + 5*time.Minute), 10 * (5 - w)})
+	}
+	writeUsual(t, cfg.DataDir, usualRow{now.Add(-5 * time.Minute), 35})
+
+	resp, err := buildUsual(cfg, "", 60, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Locations) != 1 || resp.To != "2026-03-15" {
+		t.Fatalf("usual = %+v", resp)
+	}
+	if got := resp.Locations[0]; got.Slot != "18:00" || got.Days != 4 || got.Percentile != 75 || got.Level != "busier" || got.P50 != 25 {
+		t.Errorf("Hipodroom = %+v", got)
+	}
+	// Popular times' live status says the same, however short the range the
+	// week is drawn from: against the last Monday alone 35 is quieter.
+	for _, weeks := range []int{usualWeeks, 1} {
+		from := monday.AddDate(0, 0, -7*weeks)
+		list, err := loadWindow(cfg, from, monday, []string{gymdata.DefaultMetric})
+		if err != nil {
+			t.Fatal(err)
+		}
+		week, ok := buildPopularWeek(cfg, list, "Hipodroom", from, monday.AddDate(0, 0, -1), gymdata.DefaultMetric, now)
+		if live := week.Live; !ok || live == nil || live.Status != "busier" || live.Usual != 25 || live.Delta == nil || *live.Delta != 40 {
+			t.Errorf("%d weeks: popular live = %+v, want busier, 40%% over 25", weeks, live)
+		}
+	}
+
+	if _, err := buildUsual(cfg, "Nowhere", 60, now); !errors.Is(err, ErrNoData) {
+		t.Errorf("unknown gym: err %v, want no data", err)
+	}
+	// The location matches in any case.
+	if resp, err := buildUsual(cfg, "hipodroom", 60, now.Add(2*time.Minute)); err != nil || len(resp.Locations) != 1 {
+		t.Errorf("by location = %+v, %v", resp, err)
+	}
+
+	empty := &Config{DataDir: t.TempDir(), SLOMaxAge: 10 * time.Minute}
+	writeUsual(t, empty.DataDir, usualRow{now.Add(-5 * time.Minute), 35})
+	if resp, err := buildUsual(empty, "", 60, now); err != nil || len(resp.Locations) != 0 {
+		t.Errorf("no history = %+v, %v", resp, err)
+	}
+}
+
+func TestUsualHandlerTenant(t *testing.T) {
+	saved := currentConfig()
+	defer setConfig(saved)
+	east := &Config{DataDir: t.TempDir(), SLOMaxAge: 10 * time.Minute}
+	setConfig(&Config{DataDir: t.TempDir(), SLOMaxAge: 10 * time.Minute, Tenants: map[string]*Config{"east": east}})
+	at := time.Now().Add(-time.Minute)
+	for w := usualMinDays; w >= 1; w-- {
+		writeUsual(t, east.DataDir, usualRow{at.AddDate(0, 0, -7*w), 10})
+	}
+	writeUsual(t, east.DataDir, usualRow{at, 20})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/usual", usualHandler)
+	h := withTenant(mux)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	if w := get("/t/east/api/usual"); w.Code != 200 || !strings.Contains(w.Body.String(), `"name":"Hipodroom"`) || !strings.Contains(w.Body.String(), `"bucketMinutes":60`) {
+		t.Errorf("tenant: code %d, %s", w.Code, w.Body)
+	}
+	// The base deployment has no gyms of its own.
+	if w := get("/api/usual?location=Hipodroom"); w.Code != 404 || !strings.Contains(w.Body.String(), `"code":"no_data"`) {
+		t.Errorf("base: code %d, %s", w.Code, w.Body)
+	}
+	if w := get("/t/east/api/usual?location=Nowhere"); w.Code != 404 {
+		t.Errorf("unknown gym: code %d, want 404", w.Code)
+	}
+	if w := get("/t/east/api/usual?bucket=7"); w.Code != 400 {
+		t.Errorf("bucket 7: code %d, want 400", w.Code)
+	}
+}
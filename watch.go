@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"gym/internal/gymdata"
+)
+
+// watchSettle is how long a directory must be quiet after a write before it
+// is read: the collector appends a line per gym, one echo at a time.
+const watchSettle = 500 * time.Millisecond
+
+var (
+	// watching is set once runWatcher is following the local data
+	// directories, so runRollover leaves those to it.
+	watching atomic.Bool
+	// configChanged wakes runWatcher after a reload to follow the new set of
+	// directories.
+	configChanged = make(chan struct{}, 1)
+)
+
+// statusHub fans each data directory's newest status out to its
+// /api/stream subscribers.
+type statusHub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan StatusResponse]bool // data dir -> subscribers
+}
+
+var streams = &statusHub{subs: map[string]map[chan StatusResponse]bool{}}
+
+// subscribe returns a channel that gets dir's status after each write, and
+// the func that ends the subscription. A subscriber that falls behind only
+// misses statuses that a later one supersedes.
+func (h *statusHub) subscribe(dir string) (<-chan StatusResponse, func()) {
+	ch := make(chan StatusResponse, 1)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[dir] == nil {
+		h.subs[dir] = map[chan StatusResponse]bool{}
+	}
+	h.subs[dir][ch] = true
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subs[dir], ch)
+	}
+}
+
+func (h *statusHub) publish(dir string, s StatusResponse) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[dir] {
+		select {
+		case <-ch: // drop the stale one
+		default:
+		}
+		ch <- s
+	}
+}
+
+// subscribers is how many streams are open on dir.
+func (h *statusHub) subscribers(dir string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs[dir])
+}
+
+// watchedConfigs are the main config and those of its tenants whose CSVs
+// are local, by their cleaned data directory.
+func watchedConfigs() map[string]*Config {
+	cfg := currentConfig()
+	configs := map[string]*Config{}
+	all := []*Config{cfg}
+	for _, t := range cfg.Tenants {
+		all = append(all, t)
+	}
+	for _, c := range all {
+		if c.CSVSource == "" {
+			configs[filepath.Clean(c.DataDir)] = c
+		}
+	}
+	return configs
+}
+
+// runWatcher follows the local data directories through fsnotify instead of
+// polling them. Once a directory settles after the collector writes, its
+// newest file's new lines are parsed into the load cache, the status is
+// pushed to /api/stream subscribers, and a new day's file rolls over at
+// once. If the watcher can't start, runRollover's checks cover everything.
+func runWatcher() {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("Watcher: %v; checking for new days every %v instead", err, rolloverInterval)
+		return
+	}
+	defer w.Close()
+	watching.Store(true)
+	defer watching.Store(false)
+
+	seen := map[string]string{}
+	watched := map[string]bool{}
+	follow := func() {
+		configs := watchedConfigs()
+		for dir := range watched {
+			if configs[dir] == nil {
+				w.Remove(dir)
+				delete(watched, dir)
+			}
+		}
+		for dir, c := range configs {
+			if watched[dir] {
+				continue
+			}
+			if err := w.Add(dir); err != nil {
+				log.Printf("Watcher: %s: %v", dir, err)
+				continue
+			}
+			watched[dir] = true
+			checkRollover(c, seen) // records the newest day
+		}
+	}
+	follow()
+
+	pending := map[string]bool{}
+	settle := time.NewTimer(watchSettle)
+	settle.Stop()
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if !ev.Has(fsnotify.Write) && !ev.Has(fsnotify.Create) || !strings.HasPrefix(filepath.Base(ev.Name), "gym-stats-") {
+				continue
+			}
+			pending[filepath.Dir(ev.Name)] = true
+			settle.Reset(watchSettle)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Watcher: %v", err)
+		case <-settle.C:
+			configs := watchedConfigs()
+			for dir := range pending {
+				if c := configs[dir]; c != nil {
+					dataWritten(c, seen)
+				}
+			}
+			clear(pending)
+		case <-configChanged:
+			follow()
+		}
+	}
+}
+
+// dataWritten handles a write to c's directory: a new day rolls over, which
+// rebuilds today's range itself; otherwise the newest file's new lines are
+// parsed into the load cache, so the next chart load finds them there, and
+// the latest window and its views are rebuilt with them. Then the records
+// catch up, once /api/records has built them, push alerts are checked, and
+// the status goes out to c's streams.
+func dataWritten(c *Config, seen map[string]string) {
+	if !checkRollover(c, seen) {
+		if file, err := newestDailyFile(c.csvDir()); err == nil && file != "" {
+			if _, _, err := gymdata.Load(c.format(), []string{file}, nil); err != nil {
+				log.Printf("Watcher: %s: %v", file, err)
+			} else if err := gymdata.Rollup(c.format(), file, c.path(c.RollupDir)); err != nil {
+				log.Printf("Watcher: rollup %s: %v", file, err)
+			}
+		}
+	}
+	refreshLatest(c, time.Now())
+	if _, err := os.Stat(c.path(c.RecordsFile)); err == nil {
+		if _, err := refreshRecords(c); err != nil {
+			log.Printf("Watcher: records: %v", err)
+		}
+	}
+	subscribed := streams.subscribers(filepath.Clean(c.DataDir)) > 0
+	if subscribed || c.VAPIDKey != nil {
+		status := readLatestStatus(c, gymdata.Tallinn())
+		checkPushAlerts(c, status)
+		if subscribed {
+			streams.publish(filepath.Clean(c.DataDir), status)
+		}
+	}
+}
+
+// streamHandler serves GET /api/stream: server-sent events, each a "status"
+// event carrying what GET /status would return, once on connecting and then
+// whenever the collector writes, and a "config" event after a reload or a
+// gym is closed, to fetch /api/config again. ?tz= works as for /status. Browsers'
+// EventSource can't send headers, so with API keys on, pass the key as ?key=.
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	tallinn := gymdata.Tallinn()
+	outZone, err := requestZone(r.URL.Query().Get("tz"), tallinn)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	cfg := requestConfig(r)
+	updates, cancel := streams.subscribe(filepath.Clean(cfg.DataDir))
+	defer cancel()
+	reloads, cancelReloads := subscribeConfig()
+	defer cancelReloads()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	// The stream outlives the server's read and write timeouts; instead each
+	// event gets HTTP_WRITE_SECONDS to go out, so a browser that stopped
+	// reading is dropped.
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	writeTimeout := currentConfig().WriteTimeout
+	extend := func() {
+		if writeTimeout > 0 {
+			rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+		}
+	}
+	send := func(s StatusResponse) error {
+		if outZone != tallinn {
+			s = statusInZone(s, outZone)
+		}
+		data, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		extend()
+		if _, err := fmt.Fprintf(w, "event: status\ndata: %s\n\n", data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	if send(readLatestStatus(cfg, tallinn)) != nil {
+		return
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-shuttingDown:
+			return
+		case s := <-updates:
+			if send(s) != nil {
+				return
+			}
+		case <-reloads:
+			extend()
+			if _, err := fmt.Fprint(w, "event: config\ndata: {}\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
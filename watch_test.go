@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamHandler(t *testing.T) {
+	tallinn := loadTallinn(t)
+	dir := t.TempDir()
+	old := currentConfig()
+	defer setConfig(old)
+	cfg := &Config{DataDir: dir}
+	setConfig(cfg)
+
+	now := time.Now().In(tallinn).Truncate(time.Minute)
+	row := func(at time.Time, n string) string {
+		return at.UTC().Format("2006-01-02 15:04:05") + ",UTC,1,Hipodroom," + n + ",success,\"{}\"\n"
+	}
+	file := writeCSV(t, dir, "gym-stats-"+now.Format("20060102")+".csv",
+		"timestamp,timezone,location_id,location_name,user_count,status,response\n"+row(now.Add(-4*time.Minute), "7"))
+
+	srv := httptest.NewServer(http.HandlerFunc(streamHandler))
+	defer srv.Close()
+	res, err := http.Get(srv.URL + "?tz=UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	events := bufio.NewReader(res.Body)
+	next := func() StatusResponse {
+		t.Helper()
+		var s StatusResponse
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				if err := json.Unmarshal([]byte(data), &s); err != nil {
+					t.Fatal(err)
+				}
+				return s
+			}
+		}
+	}
+
+	if s := next(); len(s.Locations) != 1 || s.Locations[0].Count != 7 || !strings.HasSuffix(s.Latest, "Z") {
+		t.Fatalf("first event = %+v, want the 7 in UTC", s)
+	}
+
+	// What runWatcher does once the collector's write settles.
+	for streams.subscribers(dir) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(row(now.Add(-2*time.Minute), "9"))
+	f.Close()
+	seen := map[string]string{}
+	checkRollover(cfg, seen)
+	dataWritten(cfg, seen)
+	if s := next(); len(s.Locations) != 1 || s.Locations[0].Count != 9 {
+		t.Errorf("after a write = %+v, want the 9", s)
+	}
+}
+
+func TestStatusHub(t *testing.T) {
+	h := &statusHub{subs: map[string]map[chan StatusResponse]bool{}}
+	ch, cancel := h.subscribe("a")
+	h.publish("a", StatusResponse{Latest: "1"})
+	h.publish("a", StatusResponse{Latest: "2"}) // nobody read the first yet
+	h.publish("b", StatusResponse{Latest: "other dir"})
+	if s := <-ch; s.Latest != "2" {
+		t.Errorf("got %q, want only the newest", s.Latest)
+	}
+	cancel()
+	if n := h.subscribers("a"); n != 0 {
+		t.Errorf("subscribers = %d after cancel", n)
+	}
+}
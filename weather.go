@@ -0,0 +1,512 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// WeatherHour is an hour's weather at the configured point.
+type WeatherHour struct {
+	At            int64   `json:"t"`      // the hour's start, Unix seconds
+	Temperature   float64 `json:"temp"`   // °C
+	Precipitation float64 `json:"precip"` // mm in the hour
+}
+
+// weatherDay is a UTC day of hours as fetched.
+type weatherDay struct {
+	Fetched time.Time     `json:"fetched"`
+	Hours   []WeatherHour `json:"hours"`
+}
+
+// weatherStore is WEATHER_FILE: the days fetched so far, by UTC date, for
+// the point Location names; moving the point starts afresh.
+type weatherStore struct {
+	Location string                 `json:"location"`
+	Days     map[string]*weatherDay `json:"days"`
+}
+
+const (
+	// weatherFinal is how long after a day ends its weather stops being
+	// revised; until then it is fetched again once weatherRefresh old.
+	weatherFinal   = 2 * 24 * time.Hour
+	weatherRefresh = time.Hour
+	// weatherArchiveLag is how far behind open-meteo's archive runs; newer
+	// days come from the forecast API, which keeps the last few months.
+	weatherArchiveLag = 5 * 24 * time.Hour
+)
+
+var (
+	weatherMu     sync.Mutex
+	weatherClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+func (c *Config) weatherPoint() string {
+	return strconv.FormatFloat(c.WeatherLatitude, 'f', -1, 64) + "," + strconv.FormatFloat(c.WeatherLongitude, 'f', -1, 64)
+}
+
+// readWeather loads the store; a missing file is an empty one.
+func readWeather(cfg *Config) (*weatherStore, error) {
+	store := &weatherStore{Location: cfg.weatherPoint(), Days: map[string]*weatherDay{}}
+	data, err := os.ReadFile(cfg.path(cfg.WeatherFile))
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved weatherStore
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("%s: %v", cfg.WeatherFile, err)
+	}
+	if saved.Location == store.Location && saved.Days != nil {
+		store.Days = saved.Days
+	}
+	return store, nil
+}
+
+// writeWeather saves the store, unindented as its hours add up.
+func writeWeather(cfg *Config, store *weatherStore) error {
+	data, err := json.Marshal(store)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(cfg.path(cfg.WeatherFile), append(data, '\n'))
+}
+
+// fetchOpenMeteo asks endpoint (the forecast or archive API) for the hourly
+// temperature and precipitation of the UTC days from..to, by day.
+func fetchOpenMeteo(cfg *Config, endpoint, from, to string) (map[string][]WeatherHour, error) {
+	q := url.Values{}
+	q.Set("latitude", strconv.FormatFloat(cfg.WeatherLatitude, 'f', -1, 64))
+	q.Set("longitude", strconv.FormatFloat(cfg.WeatherLongitude, 'f', -1, 64))
+	q.Set("hourly", "temperature_2m,precipitation")
+	q.Set("timezone", "GMT")
+	q.Set("timeformat", "unixtime")
+	q.Set("start_date", from)
+	q.Set("end_date", to)
+	resp, err := weatherClient.Get(endpoint + "?" + q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("open-meteo: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var got struct {
+		Hourly struct {
+			Time          []int64    `json:"time"`
+			Temperature   []*float64 `json:"temperature_2m"`
+			Precipitation []*float64 `json:"precipitation"`
+		} `json:"hourly"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 16<<20)).Decode(&got); err != nil {
+		return nil, fmt.Errorf("open-meteo: %v", err)
+	}
+	h := got.Hourly
+	if len(h.Temperature) != len(h.Time) || len(h.Precipitation) != len(h.Time) {
+		return nil, errors.New("open-meteo: hourly arrays differ in length")
+	}
+	out := map[string][]WeatherHour{}
+	for i, at := range h.Time {
+		day := time.Unix(at, 0).UTC().Format("2006-01-02")
+		if _, ok := out[day]; !ok {
+			out[day] = []WeatherHour{}
+		}
+		// The archive has nulls for hours it doesn't have yet.
+		if h.Temperature[i] != nil && h.Precipitation[i] != nil {
+			out[day] = append(out[day], WeatherHour{At: at, Temperature: *h.Temperature[i], Precipitation: *h.Precipitation[i]})
+		}
+	}
+	return out, nil
+}
+
+// weatherHours returns the weather hours in [from, to), fetching the days
+// not yet kept, or still being revised, as a run per API. Days past
+// tomorrow have none. Should a fetch fail, what is kept comes back with the
+// error.
+func weatherHours(cfg *Config, from, to, now time.Time) ([]WeatherHour, error) {
+	weatherMu.Lock()
+	defer weatherMu.Unlock()
+	store, err := readWeather(cfg)
+	if err != nil {
+		return nil, err
+	}
+	first := from.UTC().Truncate(24 * time.Hour)
+	last := to.Add(-time.Second).UTC().Truncate(24 * time.Hour)
+	if limit := now.UTC().Truncate(24*time.Hour).AddDate(0, 0, 1); last.After(limit) {
+		last = limit
+	}
+
+	// Runs of days to fetch, each from one API.
+	type run struct {
+		endpoint string
+		from, to time.Time
+	}
+	var runs []run
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		kept := store.Days[day.Format("2006-01-02")]
+		ended := day.AddDate(0, 0, 1)
+		if kept != nil && (now.Sub(ended) > weatherFinal || now.Sub(kept.Fetched) < weatherRefresh) {
+			continue
+		}
+		endpoint := cfg.WeatherURL
+		if now.Sub(ended) > weatherArchiveLag {
+			endpoint = cfg.WeatherArchiveURL
+		}
+		if n := len(runs); n > 0 && runs[n-1].endpoint == endpoint && runs[n-1].to.AddDate(0, 0, 1).Equal(day) {
+			runs[n-1].to = day
+			continue
+		}
+		runs = append(runs, run{endpoint, day, day})
+	}
+	var fetchErr error
+	for _, r := range runs {
+		days, err := fetchOpenMeteo(cfg, r.endpoint, r.from.Format("2006-01-02"), r.to.Format("2006-01-02"))
+		if err != nil {
+			fetchErr = err
+			break
+		}
+		for day, hours := range days {
+			store.Days[day] = &weatherDay{Fetched: now, Hours: hours}
+		}
+	}
+	if len(runs) > 0 {
+		if err := writeWeather(cfg, store); err != nil && fetchErr == nil {
+			fetchErr = err
+		}
+	}
+
+	var out []WeatherHour
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		if kept := store.Days[day.Format("2006-01-02")]; kept != nil {
+			for _, h := range kept.Hours {
+				if h.At+3600 > from.Unix() && h.At < to.Unix() {
+					out = append(out, h)
+				}
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].At < out[j].At })
+	return out, fetchErr
+}
+
+// WeatherPoint is the weather over one of a chart's buckets: the mean
+// temperature, and precipitation as the mean mm per hour, so buckets of
+// any size compare.
+type WeatherPoint struct {
+	X             any     `json:"x"` // as the datasets' x
+	Temperature   float64 `json:"temperature"`
+	Precipitation float64 `json:"precipitation"`
+}
+
+// joinWeather puts hours onto the instants of a chart's axis, each taken as
+// the start of a bucketMinutes bucket. Instants no hour overlaps are left out.
+func joinWeather(hours []WeatherHour, axis []int64, bucketMinutes int, pf pointFormat) []WeatherPoint {
+	span := int64(max(bucketMinutes, 2)) * 60
+	out := []WeatherPoint{}
+	i := 0
+	for _, at := range axis {
+		for i < len(hours) && hours[i].At+3600 <= at {
+			i++
+		}
+		var temp, precip float64
+		n := 0
+		for j := i; j < len(hours) && hours[j].At < at+span; j++ {
+			temp += hours[j].Temperature
+			precip += hours[j].Precipitation
+			n++
+		}
+		if n == 0 {
+			continue
+		}
+		p := WeatherPoint{Temperature: math.Round(temp/float64(n)*10) / 10, Precipitation: math.Round(precip/float64(n)*100) / 100}
+		if pf.millis {
+			p.X = at * 1000
+		} else {
+			p.X = time.Unix(at, 0).In(pf.loc).Format(time.RFC3339)
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// requestWeather reads a request's weather option, a boolean.
+func requestWeather(s string) (bool, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return false, nil
+	}
+	on, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("weather must be true or false")
+	}
+	return on, nil
+}
+
+// chartWeather is the weather over the buckets list is charted in, when the
+// request asks for it and WEATHER_PROVIDER is set. A provider error leaves
+// the chart without it rather than failing it.
+func chartWeather(cfg *Config, wanted bool, list []*gymdata.Series, bucketMinutes int, pf pointFormat) []WeatherPoint {
+	if !wanted || cfg.WeatherProvider == "" {
+		return nil
+	}
+	var axis []int64
+	for _, s := range list {
+		for _, p := range s.Points {
+			axis = append(axis, p.At)
+		}
+	}
+	if len(axis) == 0 {
+		return nil
+	}
+	sort.Slice(axis, func(i, j int) bool { return axis[i] < axis[j] })
+	axis = compactInstants(axis)
+	hours, err := weatherHours(cfg, time.Unix(axis[0], 0), time.Unix(axis[len(axis)-1]+int64(max(bucketMinutes, 2))*60, 0), time.Now())
+	if err != nil {
+		log.Printf("Weather: %v", err)
+	}
+	return joinWeather(hours, axis, bucketMinutes, pf)
+}
+
+func compactInstants(sorted []int64) []int64 {
+	out := sorted[:0]
+	for i, at := range sorted {
+		if i == 0 || at != sorted[i-1] {
+			out = append(out, at)
+		}
+	}
+	return out
+}
+
+// WeatherEffect is how a gym's crowd moves with one weather variable: R,
+// the correlation of the two (null with too little to go on), and PerUnit,
+// people more (or fewer) than usual per °C or per mm an hour.
+type WeatherEffect struct {
+	R       *float64 `json:"r"`
+	PerUnit *float64 `json:"perUnit"`
+}
+
+// WeatherLocation is a gym's weather effects, over the open hours (those
+// with a usual count of 1 or more) the weather is known for. WetHours had
+// 0.1 mm or more of rain or snow; Wet and Dry are the mean people above or
+// below usual in those hours and the rest.
+type WeatherLocation struct {
+	Name          string        `json:"name"`
+	Hours         int           `json:"hours"`
+	Temperature   WeatherEffect `json:"temperature"`
+	Precipitation WeatherEffect `json:"precipitation"`
+	WetHours      int           `json:"wetHours"`
+	Wet           *float64      `json:"wet"`
+	Dry           *float64      `json:"dry"`
+}
+
+// WeatherResponse is GET /api/weather.
+type WeatherResponse struct {
+	From      string            `json:"from"`
+	To        string            `json:"to"`
+	Provider  string            `json:"provider"`
+	Latitude  float64           `json:"latitude"`
+	Longitude float64           `json:"longitude"`
+	Hours     []WeatherHour     `json:"hours"`
+	Locations []WeatherLocation `json:"locations"`
+	Error     string            `json:"error,omitempty"` // the provider's, if some days are missing
+}
+
+// maxWeatherDays bounds /api/weather's range.
+const maxWeatherDays = 731
+
+// effect fits y against x: the correlation and the slope, or nils with
+// fewer than 3 pairs or no spread.
+func effect(x, y []float64) WeatherEffect {
+	n := float64(len(x))
+	if len(x) < 3 {
+		return WeatherEffect{}
+	}
+	var mx, my float64
+	for i := range x {
+		mx += x[i]
+		my += y[i]
+	}
+	mx, my = mx/n, my/n
+	var sxy, sxx, syy float64
+	for i := range x {
+		sxy += (x[i] - mx) * (y[i] - my)
+		sxx += (x[i] - mx) * (x[i] - mx)
+		syy += (y[i] - my) * (y[i] - my)
+	}
+	if sxx == 0 || syy == 0 {
+		return WeatherEffect{}
+	}
+	r := math.Round(sxy/math.Sqrt(sxx*syy)*1000) / 1000
+	slope := math.Round(sxy/sxx*100) / 100
+	return WeatherEffect{R: &r, PerUnit: &slope}
+}
+
+// weatherEffects relates each gym's hourly headcount to the weather. The
+// count is taken against the gym's usual for that weekday and hour over the
+// range, so the daily and weekly rhythm (busy evenings are also cooler
+// ones) doesn't pass for the weather's doing.
+func weatherEffects(list []*gymdata.Series, hours []WeatherHour, loc *time.Location) []WeatherLocation {
+	weather := make(map[int64]WeatherHour, len(hours))
+	for _, h := range hours {
+		weather[h.At] = h
+	}
+	out := []WeatherLocation{}
+	for _, s := range list {
+		type cell struct {
+			sum float64
+			n   int
+		}
+		var usual [7][24]cell
+		slot := func(at int64) *cell {
+			t := time.Unix(at, 0).In(loc)
+			return &usual[t.Weekday()][t.Hour()]
+		}
+		for _, p := range s.Points {
+			c := slot(p.At)
+			c.sum += p.Y
+			c.n++
+		}
+		wl := WeatherLocation{Name: s.Key.Location}
+		var temps, precips, above []float64
+		var wet, dry float64
+		for _, p := range s.Points {
+			c := slot(p.At)
+			h, ok := weather[p.At]
+			if !ok || c.sum/float64(c.n) < 1 {
+				continue
+			}
+			d := p.Y - c.sum/float64(c.n)
+			temps = append(temps, h.Temperature)
+			precips = append(precips, h.Precipitation)
+			above = append(above, d)
+			if h.Precipitation >= 0.1 {
+				wl.WetHours++
+				wet += d
+			} else {
+				dry += d
+			}
+		}
+		wl.Hours = len(above)
+		wl.Temperature = effect(temps, above)
+		wl.Precipitation = effect(precips, above)
+		if wl.WetHours > 0 {
+			v := math.Round(wet/float64(wl.WetHours)*10) / 10
+			wl.Wet = &v
+		}
+		if dryHours := wl.Hours - wl.WetHours; dryHours > 0 {
+			v := math.Round(dry/float64(dryHours)*10) / 10
+			wl.Dry = &v
+		}
+		out = append(out, wl)
+	}
+	return out
+}
+
+// weatherHandler serves the hourly weather over a range and, per gym, how
+// its crowd moved with it.
+//
+//	GET /api/weather[?from=YYYY-MM-DD&to=YYYY-MM-DD][&location=NAME]
+//
+// The range defaults to the 90 days before today and never includes today.
+func weatherHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := requestConfig(r)
+	if cfg.WeatherProvider == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("WEATHER_PROVIDER is not set"))
+		return
+	}
+
+	tallinn := gymdata.Tallinn()
+	now := time.Now().In(tallinn)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, tallinn)
+	q := r.URL.Query()
+	to := today.AddDate(0, 0, -1)
+	if s := strings.TrimSpace(q.Get("to")); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, tallinn)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fieldErr("to", ErrBadRange, errors.New("to must be YYYY-MM-DD")))
+			return
+		}
+		if t.Before(today) {
+			to = t
+		}
+	}
+	from := to.AddDate(0, 0, -89)
+	if s := strings.TrimSpace(q.Get("from")); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, tallinn)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fieldErr("from", ErrBadRange, errors.New("from must be YYYY-MM-DD")))
+			return
+		}
+		from = t
+	}
+	if from.After(to) {
+		writeError(w, http.StatusBadRequest, withKind(ErrBadRange, errors.New("from is after to (the range ends yesterday at the latest)")))
+		return
+	}
+	if to.Sub(from) > maxWeatherDays*24*time.Hour {
+		writeError(w, http.StatusBadRequest, withKind(ErrBadRange, fmt.Errorf("the range is at most %d days", maxWeatherDays)))
+		return
+	}
+	end := to.AddDate(0, 0, 1)
+
+	list, err := loadWindow(cfg, from, end, nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if location := strings.TrimSpace(q.Get("location")); location != "" {
+		kept := list[:0]
+		for _, s := range list {
+			if strings.EqualFold(s.Key.Location, location) {
+				kept = append(kept, s)
+			}
+		}
+		list = kept
+	}
+	gymdata.Bucket(list, 60, tallinn)
+
+	hours, err := weatherHours(cfg, from, end, time.Now())
+	resp := WeatherResponse{
+		From:      from.Format("2006-01-02"),
+		To:        to.Format("2006-01-02"),
+		Provider:  cfg.WeatherProvider,
+		Latitude:  cfg.WeatherLatitude,
+		Longitude: cfg.WeatherLongitude,
+		Hours:     hours,
+		Locations: weatherEffects(list, hours, tallinn),
+	}
+	if err != nil {
+		log.Printf("Weather: %v", err)
+		resp.Error = err.Error()
+	}
+	if resp.Hours == nil {
+		resp.Hours = []WeatherHour{}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
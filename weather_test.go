@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gym/internal/fixtures"
+	"gym/internal/gymdata"
+)
+
+// fakeOpenMeteo answers like open-meteo with unixtime hours: 10 °C plus the
+// hour of day, and 1 mm an hour on even days of the month.
+func fakeOpenMeteo(t *testing.T, calls map[string]int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		calls[r.URL.Path]++
+		if q.Get("hourly") != "temperature_2m,precipitation" || q.Get("timezone") != "GMT" || q.Get("latitude") != "59.437" {
+			t.Errorf("query %s", r.URL.RawQuery)
+		}
+		from, err1 := time.Parse("2006-01-02", q.Get("start_date"))
+		to, err2 := time.Parse("2006-01-02", q.Get("end_date"))
+		if err1 != nil || err2 != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var times []int64
+		var temps, precips []any
+		for at := from; !at.After(to.Add(23 * time.Hour)); at = at.Add(time.Hour) {
+			times = append(times, at.Unix())
+			temps = append(temps, 10+float64(at.Hour()))
+			precips = append(precips, float64(1-at.Day()%2))
+		}
+		temps[len(temps)-1] = nil // not in yet
+		json.NewEncoder(w).Encode(map[string]any{"hourly": map[string]any{"time": times, "temperature_2m": temps, "precipitation": precips}})
+	}))
+}
+
+func weatherConfig(dir, upstream string) *Config {
+	return &Config{DataDir: dir, WeatherProvider: "open-meteo", WeatherURL: upstream + "/forecast", WeatherArchiveURL: upstream + "/archive",
+		WeatherLatitude: 59.437, WeatherLongitude: 24.754, WeatherFile: "gym-weather.json"}
+}
+
+func TestWeatherHours(t *testing.T) {
+	calls := map[string]int{}
+	upstream := fakeOpenMeteo(t, calls)
+	defer upstream.Close()
+	cfg := weatherConfig(t.TempDir(), upstream.URL)
+
+	// Ten days to now: the older ones from the archive, the last few from
+	// the forecast API.
+	now := time.Date(2025, 10, 20, 12, 0, 0, 0, time.UTC)
+	from := time.Date(2025, 10, 10, 0, 0, 0, 0, time.UTC)
+	hours, err := weatherHours(cfg, from, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls["/archive"] != 1 || calls["/forecast"] != 1 {
+		t.Errorf("calls %v, want one run from each API", calls)
+	}
+	if len(hours) != 10*24+12-1 { // the archive run's last hour is null
+		t.Errorf("%d hours", len(hours))
+	}
+	if h := hours[0]; h.At != from.Unix() || h.Temperature != 10 || h.Precipitation != 1 {
+		t.Errorf("first hour %+v", h)
+	}
+
+	// Kept days aren't fetched again; today's are once an hour has passed.
+	if _, err := weatherHours(cfg, from, now, now.Add(30*time.Minute)); err != nil || calls["/archive"]+calls["/forecast"] != 2 {
+		t.Errorf("within the hour: calls %v, err %v", calls, err)
+	}
+	if _, err := weatherHours(cfg, from, now, now.Add(2*time.Hour)); err != nil || calls["/archive"] != 1 || calls["/forecast"] != 2 {
+		t.Errorf("an hour on: calls %v, err %v", calls, err)
+	}
+
+	// With the provider down, what is kept still comes back.
+	upstream.Close()
+	hours, err = weatherHours(cfg, from, now.Add(48*time.Hour), now.Add(48*time.Hour))
+	if err == nil || len(hours) < 10*24 {
+		t.Errorf("provider down: %d hours, err %v", len(hours), err)
+	}
+}
+
+func TestJoinWeather(t *testing.T) {
+	start := time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC).Unix()
+	hours := []WeatherHour{{start, 4, 0}, {start + 3600, 6, 2}, {start + 7200, 8, 0}}
+	pf := pointFormat{loc: time.UTC}
+
+	got := joinWeather(hours, []int64{start, start + 7200}, 120, pf)
+	if len(got) != 2 || got[0].Temperature != 5 || got[0].Precipitation != 1 || got[1].Temperature != 8 || got[0].X != "2025-10-01T00:00:00Z" {
+		t.Errorf("2-hour buckets: %+v", got)
+	}
+	// Sub-hour buckets take the hour they fall in; instants past the hours
+	// are left out.
+	pf.millis = true
+	got = joinWeather(hours, []int64{start + 600, start + 4200, start + 5*3600}, 10, pf)
+	if len(got) != 2 || got[0].Temperature != 4 || got[1].Temperature != 6 || got[1].X != (start+4200)*1000 {
+		t.Errorf("10-minute buckets: %+v", got)
+	}
+}
+
+func TestWeatherEffects(t *testing.T) {
+	tallinn := loadTallinn(t)
+	// Two weeks of hourly counts, 30 less 3 per mm of rain, with each
+	// weekday wet in one week and dry in the other.
+	monday := time.Date(2025, 10, 6, 0, 0, 0, 0, tallinn)
+	s := &gymdata.Series{Key: gymdata.Key{Location: "A", Metric: gymdata.DefaultMetric}}
+	var hours []WeatherHour
+	for d := 0; d < 14; d++ {
+		rain := float64(2 * ((d%7 + d/7) % 2))
+		for h := 0; h < 24; h++ {
+			at := monday.AddDate(0, 0, d).Add(time.Duration(h) * time.Hour).Unix()
+			s.Points = append(s.Points, gymdata.Point{At: at, Y: 30 - 3*rain})
+			hours = append(hours, WeatherHour{At: at, Temperature: 5, Precipitation: rain})
+		}
+	}
+	got := weatherEffects([]*gymdata.Series{s}, hours, tallinn)
+	if len(got) != 1 {
+		t.Fatalf("%+v", got)
+	}
+	e := got[0]
+	if e.Hours != 14*24 || e.WetHours != 7*24 || *e.Wet != -3 || *e.Dry != 3 {
+		t.Errorf("hours %d, wet %d, wet %v, dry %v", e.Hours, e.WetHours, *e.Wet, *e.Dry)
+	}
+	if e.Precipitation.R == nil || *e.Precipitation.R != -1 || *e.Precipitation.PerUnit != -3 {
+		t.Errorf("precipitation %+v", e.Precipitation)
+	}
+	if e.Temperature.R != nil {
+		t.Errorf("temperature without spread: r %v, want null", *e.Temperature.R)
+	}
+}
+
+func TestWeatherHandler(t *testing.T) {
+	tallinn := loadTallinn(t)
+	calls := map[string]int{}
+	upstream := fakeOpenMeteo(t, calls)
+	defer upstream.Close()
+	dir := t.TempDir()
+	spec := fixtures.Spec{Locations: fixtures.DefaultLocations[:2], Start: time.Date(2025, 10, 6, 0, 0, 0, 0, tallinn), Days: 14, Zone: tallinn, Noise: 3, Seed: 1}
+	if _, err := spec.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+	old := currentConfig()
+	defer setConfig(old)
+
+	get := func(query string) (int, WeatherResponse, string) {
+		w := httptest.NewRecorder()
+		weatherHandler(w, httptest.NewRequest("GET", "/api/weather"+query, nil))
+		var resp WeatherResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp, w.Body.String()
+	}
+
+	setConfig(&Config{DataDir: dir})
+	if code, _, body := get(""); code != http.StatusNotFound || !strings.Contains(body, "WEATHER_PROVIDER") {
+		t.Errorf("no provider: %d %s", code, body)
+	}
+
+	setConfig(weatherConfig(dir, upstream.URL))
+	code, resp, body := get("?from=2025-10-06&to=2025-10-19")
+	if code != 200 || resp.Error != "" || len(resp.Locations) != 2 || resp.Locations[0].Hours == 0 || resp.Locations[0].Temperature.R == nil {
+		t.Fatalf("%d %s", code, body)
+	}
+	// The days in Tallinn start at 21:00 UTC the day before.
+	if len(resp.Hours) != 14*24 || resp.Hours[0].At != spec.Start.Unix() {
+		t.Errorf("%d hours from %d", len(resp.Hours), resp.Hours[0].At)
+	}
+	if code, resp, _ := get("?from=2025-10-06&to=2025-10-19&location=" + resp.Locations[1].Name); code != 200 || len(resp.Locations) != 1 {
+		t.Errorf("one location: %d, %d locations", code, len(resp.Locations))
+	}
+	for _, bad := range []string{"?from=2025-13-01", "?from=2025-10-12&to=2025-10-06", fmt.Sprintf("?from=2020-01-01&to=%s", "2025-10-06")} {
+		if code, _, _ := get(bad); code != http.StatusBadRequest {
+			t.Errorf("%s: %d, want 400", bad, code)
+		}
+	}
+}
+
+func TestRangeWeather(t *testing.T) {
+	tallinn := loadTallinn(t)
+	calls := map[string]int{}
+	upstream := fakeOpenMeteo(t, calls)
+	defer upstream.Close()
+	dir := t.TempDir()
+	spec := fixtures.Spec{Start: time.Date(2025, 10, 6, 0, 0, 0, 0, tallinn), Days: 2, Zone: tallinn}
+	if _, err := spec.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+	old := currentConfig()
+	defer setConfig(old)
+	setConfig(weatherConfig(dir, upstream.URL))
+
+	resp, list, _, status, err := rangeResponse(httptest.NewRequest("POST", "/api/range", nil),
+		DateRangeRequest{From: "2025-10-06", To: "2025-10-07", MaxPoints: 48, Weather: true}, false)
+	if err != nil || status != 200 || len(list) == 0 {
+		t.Fatalf("%d %v", status, err)
+	}
+	// 2-hour buckets, the first over 21:00 and 22:00 UTC.
+	if len(resp.Weather) != 24 || resp.Weather[0].X != "2025-10-06T00:00:00+03:00" || resp.Weather[0].Temperature != 31.5 {
+		t.Errorf("%d weather points, first %+v", len(resp.Weather), resp.Weather[0])
+	}
+
+	resp, _, _, _, _ = rangeResponse(httptest.NewRequest("POST", "/api/range", nil), DateRangeRequest{From: "2025-10-06", To: "2025-10-07", MaxPoints: 48}, false)
+	if resp.Weather != nil {
+		t.Errorf("weather not asked for: %d points", len(resp.Weather))
+	}
+}
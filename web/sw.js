@@ -0,0 +1,60 @@
+// Shows the server's push alerts (webpush.go) and opens the dashboard when
+// one is clicked.
+self.addEventListener('push', event => {
+  let msg = {};
+  try { msg = event.data ? event.data.json() : {}; } catch (e) {}
+  event.waitUntil(self.registration.showNotification(msg.title || 'Gym alert', {
+    body: msg.body || '',
+    tag: msg.tag,
+    icon: 'icon-192.png',
+    data: { url: msg.url || 'dashboard.html' },
+  }));
+});
+
+self.addEventListener('notificationclick', event => {
+  event.notification.close();
+  const url = new URL(event.notification.data.url, self.registration.scope).href;
+  event.waitUntil(clients.matchAll({ type: 'window' }).then(list => {
+    const open = list.find(c => c.url.startsWith(url));
+    return open ? open.focus() : clients.openWindow(url);
+  }));
+});
+
+// Offline viewing. A day's chart data (api/day/DATE?v=VERSION) never
+// changes under its URL, so once fetched it is served from the cache; the
+// newest DAY_LIMIT days fetched are kept. The pages, the config, the day
+// list and the landing view are fetched fresh, falling back to the last
+// copy while offline.
+const DAY_CACHE = 'gym-days-v1', SHELL_CACHE = 'gym-shell-v1', DAY_LIMIT = 60;
+const SHELL = /\/(dashboard\.html|busyness\.html|manifest\.json|api\/config|api\/days|api\/recent|api\/mobile)$/;
+
+self.addEventListener('fetch', event => {
+  const req = event.request;
+  const url = new URL(req.url);
+  if (req.method !== 'GET' || url.origin !== self.location.origin) return;
+  if (/\/api\/day\/\d{4}-\d{2}-\d{2}$/.test(url.pathname) && url.searchParams.has('v')) {
+    event.respondWith(caches.open(DAY_CACHE).then(async cache => {
+      const hit = await cache.match(req);
+      if (hit) return hit;
+      const res = await fetch(req);
+      if (res.ok) {
+        await cache.put(req, res.clone());
+        const keys = await cache.keys(); // oldest first
+        await Promise.all(keys.slice(0, Math.max(0, keys.length - DAY_LIMIT)).map(k => cache.delete(k)));
+      }
+      return res;
+    }));
+  } else if (SHELL.test(url.pathname)) {
+    event.respondWith(caches.open(SHELL_CACHE).then(async cache => {
+      try {
+        const res = await fetch(req);
+        if (res.ok) await cache.put(req, res.clone());
+        return res;
+      } catch (e) {
+        const hit = await cache.match(req);
+        if (hit) return hit;
+        throw e;
+      }
+    }));
+  }
+});
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Alerts are sent by Web Push (RFC 8030): the payload encrypted for the
+// browser's subscription (RFC 8291) and the request signed with the
+// server's VAPID key (RFC 8292), so any browser's push service takes them
+// with no account of its own.
+
+// PushSubscription is what the browser's PushSubscription.toJSON() gives.
+type PushSubscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// PushAlert is "notify me when Location drops below Below people", sent to
+// the subscription it was made from.
+type PushAlert struct {
+	ID           int              `json:"id"`
+	Location     string           `json:"location"`
+	Below        int              `json:"below"`
+	Subscription PushSubscription `json:"subscription"`
+	Created      string           `json:"created,omitempty"`
+	// Fired says the alert went off; it goes off again once the count has
+	// been back up to Below.
+	Fired    bool   `json:"fired,omitempty"`
+	LastSent string `json:"lastSent,omitempty"`
+}
+
+// pushBook is one owner's alerts as stored.
+type pushBook struct {
+	Alerts  []PushAlert `json:"alerts"`
+	NextID  int         `json:"nextId"`
+	Updated string      `json:"updated"`
+}
+
+type PushResponse struct {
+	PublicKey string      `json:"publicKey"`
+	Alerts    []PushAlert `json:"alerts"`
+}
+
+const (
+	maxPushAlerts = 10   // per owner
+	maxPushOwners = 1000 // as for goals, the least recently changed go first
+	// pushStale is how old the newest reading may be for alerts to fire, so
+	// a stalled collector doesn't read as an empty gym.
+	pushStale = 10 * time.Minute
+	pushTTL   = 30 * 60 // seconds a push service holds an alert for an offline browser
+)
+
+var (
+	pushMu     sync.Mutex
+	pushClient = &http.Client{Timeout: 10 * time.Second}
+	b64        = base64.RawURLEncoding
+)
+
+// parseVAPIDKey reads VAPID_PRIVATE_KEY: a P-256 private key as its 32
+// bytes, base64url-encoded, as `gym-server vapid-keys` prints. "" is none.
+func parseVAPIDKey(s string) (*ecdsa.PrivateKey, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	raw, err := b64.DecodeString(strings.TrimRight(s, "="))
+	if err != nil || len(raw) != 32 {
+		return nil, fmt.Errorf("want 32 bytes, base64url-encoded")
+	}
+	k, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, err
+	}
+	pub := k.PublicKey().Bytes()
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(pub[1:33]), Y: new(big.Int).SetBytes(pub[33:])},
+		D:         new(big.Int).SetBytes(raw),
+	}, nil
+}
+
+// newVAPIDKey makes a private key for VAPID_PRIVATE_KEY.
+func newVAPIDKey() (string, error) {
+	k, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	return b64.EncodeToString(k.Bytes()), nil
+}
+
+// vapidPublicKey is the key browsers subscribe with (applicationServerKey):
+// the uncompressed point, base64url-encoded.
+func vapidPublicKey(k *ecdsa.PrivateKey) string {
+	pub := make([]byte, 65)
+	pub[0] = 4
+	k.X.FillBytes(pub[1:33])
+	k.Y.FillBytes(pub[33:])
+	return b64.EncodeToString(pub)
+}
+
+// vapidAuthorization is the Authorization header for a push to endpoint: a
+// JWT for its origin, signed ES256, and the key to check it with.
+func vapidAuthorization(k *ecdsa.PrivateKey, subject, endpoint string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header := b64.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]any{"aud": u.Scheme + "://" + u.Host, "exp": now.Add(12 * time.Hour).Unix(), "sub": subject})
+	if err != nil {
+		return "", err
+	}
+	signed := header + "." + b64.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+	if err != nil {
+		return "", err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return "vapid t=" + signed + "." + b64.EncodeToString(sig) + ", k=" + vapidPublicKey(k), nil
+}
+
+// defaultPushHosts are the push services of Chrome, Firefox, Edge and
+// Safari, for when PUSH_HOSTS isn't set.
+var defaultPushHosts = []string{"fcm.googleapis.com", "*.push.services.mozilla.com", "*.notify.windows.com", "*.push.apple.com"}
+
+// pushHostAllowed says whether host is one of hosts, exactly or, for an
+// entry *.domain, as a subdomain of it.
+func pushHostAllowed(host string, hosts []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, h := range hosts {
+		h = strings.ToLower(h)
+		if domain, ok := strings.CutPrefix(h, "*."); ok {
+			if strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		} else if host == h {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEndpoint rejects an endpoint that isn't https on one of hosts, so a
+// subscription can't point the server's requests at anything else.
+func checkEndpoint(endpoint string, hosts []string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("subscription.endpoint must be an https URL")
+	}
+	if !pushHostAllowed(u.Hostname(), hosts) {
+		return fmt.Errorf("subscription.endpoint %s is not a known push service", u.Hostname())
+	}
+	return nil
+}
+
+// check rejects a subscription that could not be pushed to, or whose
+// endpoint isn't on one of hosts.
+func (s PushSubscription) check(hosts []string) error {
+	if err := checkEndpoint(s.Endpoint, hosts); err != nil {
+		return err
+	}
+	if raw, err := b64.DecodeString(strings.TrimRight(s.Keys.P256dh, "=")); err != nil {
+		return fmt.Errorf("subscription.keys.p256dh is not base64url")
+	} else if _, err := ecdh.P256().NewPublicKey(raw); err != nil {
+		return fmt.Errorf("subscription.keys.p256dh is not a P-256 key")
+	}
+	if raw, err := b64.DecodeString(strings.TrimRight(s.Keys.Auth, "=")); err != nil || len(raw) != 16 {
+		return fmt.Errorf("subscription.keys.auth must be 16 bytes, base64url-encoded")
+	}
+	return nil
+}
+
+// encryptPush encrypts payload for the subscription as one aes128gcm record
+// (RFC 8291): a key agreed between a one-off key of ours and the browser's,
+// mixed with its auth secret.
+func encryptPush(s PushSubscription, payload []byte) ([]byte, error) {
+	uaRaw, err := b64.DecodeString(strings.TrimRight(s.Keys.P256dh, "="))
+	if err != nil {
+		return nil, err
+	}
+	auth, err := b64.DecodeString(strings.TrimRight(s.Keys.Auth, "="))
+	if err != nil {
+		return nil, err
+	}
+	ua, err := ecdh.P256().NewPublicKey(uaRaw)
+	if err != nil {
+		return nil, err
+	}
+	as, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := as.ECDH(ua)
+	if err != nil {
+		return nil, err
+	}
+	asRaw := as.PublicKey().Bytes()
+	prk, err := hkdf.Extract(sha256.New, shared, auth)
+	if err != nil {
+		return nil, err
+	}
+	ikm, err := hkdf.Expand(sha256.New, prk, "WebPush: info\x00"+string(uaRaw)+string(asRaw), 32)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	prk, err = hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	out.Write(salt)
+	binary.Write(&out, binary.BigEndian, uint32(4096)) // record size
+	out.WriteByte(byte(len(asRaw)))
+	out.Write(asRaw)
+	// 2: the last record, unpadded; the full slice expression keeps append
+	// off any spare capacity of the caller's payload.
+	out.Write(gcm.Seal(nil, nonce, append(payload[:len(payload):len(payload)], 2), nil))
+	return out.Bytes(), nil
+}
+
+// sendPush delivers payload to the subscription and returns the push
+// service's status.
+func sendPush(c *Config, s PushSubscription, payload []byte) (int, error) {
+	// Alerts stored before PUSH_HOSTS was narrowed aren't sent either.
+	if err := checkEndpoint(s.Endpoint, c.PushHosts); err != nil {
+		return 0, err
+	}
+	body, err := encryptPush(s, payload)
+	if err != nil {
+		return 0, err
+	}
+	auth, err := vapidAuthorization(c.VAPIDKey, c.VAPIDSubject, s.Endpoint, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest("POST", s.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(pushTTL))
+	req.Header.Set("Urgency", "normal")
+	resp, err := pushClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, fmt.Errorf("%s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// readPush loads the store, owner -> alerts; a missing file is empty.
+func readPush(cfg *Config) (map[string]*pushBook, error) {
+	data, err := os.ReadFile(cfg.path(cfg.PushFile))
+	if os.IsNotExist(err) {
+		return map[string]*pushBook{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	store := map[string]*pushBook{}
+	if err := json.Unmarshal(data, &store); err != nil {
+		return nil, fmt.Errorf("%s: %v", cfg.PushFile, err)
+	}
+	return store, nil
+}
+
+// writePush saves the subscriptions.
+func writePush(cfg *Config, store map[string]*pushBook) error {
+	return writeFileAtomic(cfg.path(cfg.PushFile), store)
+}
+
+// dueAlert is an alert to send: whose it is, and the reading that set it off.
+type dueAlert struct {
+	owner string
+	alert PushAlert
+	count int
+}
+
+// checkPushAlerts fires the alerts the latest status sets off: those whose
+// gym has dropped below their mark since they last went off. They are sent
+// in the background, so the watcher isn't held up by push services.
+func checkPushAlerts(c *Config, s StatusResponse) {
+	if c.VAPIDKey == nil || s.AgeSeconds < 0 || s.AgeSeconds > int64(pushStale/time.Second) {
+		return
+	}
+	counts := map[string]int{}
+	for _, l := range s.Locations {
+		counts[strings.ToLower(l.Name)] = l.Count
+	}
+	pushMu.Lock()
+	defer pushMu.Unlock()
+	store, err := readPush(c)
+	if err != nil {
+		log.Printf("Push: %v", err)
+		return
+	}
+	var due []dueAlert
+	changed := false
+	now := time.Now().UTC().Format(time.RFC3339)
+	for owner, b := range store {
+		for i := range b.Alerts {
+			a := &b.Alerts[i]
+			n, ok := counts[strings.ToLower(a.Location)]
+			switch {
+			case !ok:
+			case n < a.Below && !a.Fired:
+				a.Fired, a.LastSent = true, now
+				due = append(due, dueAlert{owner, *a, n})
+				changed = true
+			case n >= a.Below && a.Fired:
+				a.Fired = false
+				changed = true
+			}
+		}
+	}
+	if changed {
+		if err := writePush(c, store); err != nil {
+			log.Printf("Push: %v", err)
+			return
+		}
+	}
+	if len(due) > 0 {
+		go deliverPushAlerts(c, due)
+	}
+}
+
+// deliverPushAlerts sends each alert, dropping those whose subscription the
+// push service says is gone (the browser unsubscribed, or the site's
+// permission was revoked).
+func deliverPushAlerts(c *Config, due []dueAlert) {
+	for _, d := range due {
+		payload, _ := json.Marshal(map[string]string{
+			"title": fmt.Sprintf("%s: %d people", d.alert.Location, d.count),
+			"body":  fmt.Sprintf("Below your %d, a good time to go.", d.alert.Below),
+			"tag":   "gym-alert-" + strconv.Itoa(d.alert.ID),
+			"url":   "dashboard.html",
+		})
+		status, err := sendPush(c, d.alert.Subscription, payload)
+		if err == nil {
+			continue
+		}
+		log.Printf("Push: alert %d for %s: %v", d.alert.ID, d.alert.Location, err)
+		if status == http.StatusNotFound || status == http.StatusGone {
+			pushMu.Lock()
+			if store, err := readPush(c); err == nil && store[d.owner] != nil {
+				b := store[d.owner]
+				if i := pushAlertIndex(b.Alerts, d.alert.ID); i >= 0 {
+					b.Alerts = append(b.Alerts[:i], b.Alerts[i+1:]...)
+					writePush(c, store)
+				}
+			}
+			pushMu.Unlock()
+		}
+	}
+}
+
+func pushAlertIndex(alerts []PushAlert, id int) int {
+	for i, a := range alerts {
+		if a.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// pushHandler manages the requesting browser's (or API key's) alerts, owned
+// as goals are. GET also gives the key to subscribe with.
+//
+//	GET    /api/push
+//	POST   /api/push {subscription, location, below}
+//	DELETE /api/push/ID
+func pushHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	id := 0
+	if rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/push"), "/"); rest != "" {
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no alert %q", rest))
+			return
+		}
+		id = n
+	}
+	switch {
+	case (r.Method == "GET" || r.Method == "POST") && id == 0:
+	case r.Method == "DELETE" && id != 0:
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg := requestConfig(r)
+	if cfg.VAPIDKey == nil {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("push notifications are off: set VAPID_PRIVATE_KEY"))
+		return
+	}
+	owner := prefsOwner(r, cfg)
+	if owner == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("alerts are per API key or X-Client-ID (8-64 letters, digits, - or _)"))
+		return
+	}
+
+	var alert PushAlert
+	if r.Method == "POST" {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&alert); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body"))
+			return
+		}
+		if err := alert.Subscription.check(cfg.PushHosts); err != nil {
+			writeError(w, http.StatusBadRequest, fieldErr("subscription", nil, err))
+			return
+		}
+		if alert.Below < 1 || alert.Below > 1000 {
+			writeError(w, http.StatusBadRequest, fieldErr("below", nil, fmt.Errorf("below must be 1-1000 people")))
+			return
+		}
+		var names []string
+		for _, l := range readLatestStatus(cfg, time.UTC).Locations {
+			names = append(names, l.Name)
+		}
+		name, ok := matchLocation(alert.Location, names)
+		if !ok {
+			writeError(w, http.StatusBadRequest, fieldErr("location", nil, fmt.Errorf("location %q matches no one gym", alert.Location)))
+			return
+		}
+		alert.Location = name
+	}
+
+	pushMu.Lock()
+	defer pushMu.Unlock()
+	store, err := readPush(cfg)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	b := store[owner]
+	if b == nil {
+		b = &pushBook{Alerts: []PushAlert{}}
+	}
+	if r.Method == "GET" {
+		json.NewEncoder(w).Encode(PushResponse{PublicKey: vapidPublicKey(cfg.VAPIDKey), Alerts: b.Alerts})
+		return
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	if r.Method == "POST" {
+		if len(b.Alerts) >= maxPushAlerts {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("at most %d alerts; delete one first", maxPushAlerts))
+			return
+		}
+		b.NextID++
+		alert.ID, alert.Created, alert.Fired, alert.LastSent = b.NextID, now, false, ""
+		b.Alerts = append(b.Alerts, alert)
+	} else {
+		i := pushAlertIndex(b.Alerts, id)
+		if i < 0 {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no alert %d", id))
+			return
+		}
+		b.Alerts = append(b.Alerts[:i], b.Alerts[i+1:]...)
+	}
+	b.Updated = now
+	store[owner] = b
+	if len(store) > maxPushOwners {
+		owners := make([]string, 0, len(store))
+		for o := range store {
+			owners = append(owners, o)
+		}
+		sort.Slice(owners, func(i, j int) bool { return store[owners[i]].Updated < store[owners[j]].Updated })
+		for _, o := range owners[:len(store)-maxPushOwners] {
+			delete(store, o)
+		}
+	}
+	if err := writePush(cfg, store); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if r.Method == "DELETE" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(alert)
+}
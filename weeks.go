@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// maxWeeks bounds /api/weeks' range, about two years.
+const maxWeeks = 105
+
+// WeekStats is one ISO-8601 week of a gym: Avg is the mean over its days
+// with readings of each day's own average, so a day weighs the same however
+// many readings it has; Peak is its highest reading.
+type WeekStats struct {
+	Week    string  `json:"week"`  // "2025-W40"
+	Start   string  `json:"start"` // its Monday, YYYY-MM-DD
+	Days    int     `json:"days"`
+	Avg     float64 `json:"avg"`
+	Peak    float64 `json:"peak"`
+	PeakAt  string  `json:"peakAt"` // RFC 3339, in the response's zone
+	Partial bool    `json:"partial,omitempty"`
+}
+
+type WeeksLocation struct {
+	Name  string      `json:"name"`
+	Weeks []WeekStats `json:"weeks"`
+}
+
+type WeeksResponse struct {
+	From      string          `json:"from"` // first week's Monday
+	To        string          `json:"to"`   // last week's Sunday
+	Timezone  string          `json:"timezone"`
+	Metric    string          `json:"metric"`
+	Locations []WeeksLocation `json:"locations"`
+}
+
+// weekStart is the Monday 00:00 in t's zone of t's ISO week.
+func weekStart(t time.Time) time.Time {
+	d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return d.AddDate(0, 0, -((int(d.Weekday()) + 6) % 7))
+}
+
+// isoWeekLabel names t's ISO week, as "2025-W40". Its year is the week's,
+// which differs from t's for some days at either end of a year.
+func isoWeekLabel(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// parseWeekDate reads a YYYY-MM-DD date, or an ISO week as YYYY-Www (its
+// Monday), in loc.
+func parseWeekDate(s string, loc *time.Location) (time.Time, error) {
+	var year, week int
+	if n, _ := fmt.Sscanf(s, "%4d-W%2d", &year, &week); n == 2 && len(s) == 8 {
+		// January 4th is always in week 1.
+		monday := weekStart(time.Date(year, 1, 4, 0, 0, 0, 0, loc)).AddDate(0, 0, 7*(week-1))
+		if week < 1 || isoWeekLabel(monday) != s {
+			return time.Time{}, fmt.Errorf("%s is not a week of %d", s, year)
+		}
+		return monday, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, loc)
+}
+
+// computeWeeks folds a series into the ISO weeks of loc from the week
+// starting at from up to end, oldest first, leaving out weeks without
+// readings. A week ending after now is Partial.
+func computeWeeks(s *gymdata.Series, from, end, now time.Time, loc *time.Location) []WeekStats {
+	type day struct {
+		sum   float64
+		count int
+	}
+	type week struct {
+		start time.Time
+		days  map[string]*day
+		peak  gymdata.Point
+	}
+	var weeks []*week
+	byStart := map[int64]*week{}
+	for _, p := range s.Points {
+		t := time.Unix(p.At, 0).In(loc)
+		if t.Before(from) || !t.Before(end) {
+			continue
+		}
+		start := weekStart(t)
+		wk := byStart[start.Unix()]
+		if wk == nil {
+			wk = &week{start: start, days: map[string]*day{}, peak: p}
+			byStart[start.Unix()] = wk
+			weeks = append(weeks, wk)
+		}
+		key := t.Format("2006-01-02")
+		d := wk.days[key]
+		if d == nil {
+			d = &day{}
+			wk.days[key] = d
+		}
+		d.sum += p.Y
+		d.count++
+		if p.Y > wk.peak.Y {
+			wk.peak = p
+		}
+	}
+	sort.Slice(weeks, func(i, j int) bool { return weeks[i].start.Before(weeks[j].start) })
+
+	out := make([]WeekStats, 0, len(weeks))
+	for _, wk := range weeks {
+		sum := 0.0
+		for _, d := range wk.days {
+			sum += d.sum / float64(d.count)
+		}
+		out = append(out, WeekStats{
+			Week:    isoWeekLabel(wk.start),
+			Start:   wk.start.Format("2006-01-02"),
+			Days:    len(wk.days),
+			Avg:     math.Round(sum/float64(len(wk.days))*10) / 10,
+			Peak:    wk.peak.Y,
+			PeakAt:  time.Unix(wk.peak.At, 0).In(loc).Format(time.RFC3339),
+			Partial: wk.start.AddDate(0, 0, 7).After(now),
+		})
+	}
+	return out
+}
+
+// weeksHandler returns each gym's ISO-8601 weeks, Monday to Sunday in the
+// requested zone, for long-term trend charts.
+//
+//	GET /api/weeks[?from=DATE&to=DATE][&metric=NAME][&location=NAME][&tz=ZONE]
+//
+// from and to are YYYY-MM-DD or ISO weeks (YYYY-Www) and widen to whole
+// weeks. The range defaults to the 26 weeks up to this one, which is marked
+// partial.
+func weeksHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	loc, err := requestZone(q.Get("tz"), gymdata.Tallinn())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fieldErr("tz", nil, err))
+		return
+	}
+
+	now := time.Now().In(loc)
+	to := weekStart(now)
+	if s := strings.TrimSpace(q.Get("to")); s != "" {
+		t, err := parseWeekDate(s, loc)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fieldErr("to", ErrBadRange, errors.New("to must be YYYY-MM-DD or YYYY-Www")))
+			return
+		}
+		to = weekStart(t)
+	}
+	from := to.AddDate(0, 0, -7*25)
+	if s := strings.TrimSpace(q.Get("from")); s != "" {
+		t, err := parseWeekDate(s, loc)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fieldErr("from", ErrBadRange, errors.New("from must be YYYY-MM-DD or YYYY-Www")))
+			return
+		}
+		from = weekStart(t)
+	}
+	if from.After(to) {
+		writeError(w, http.StatusBadRequest, withKind(ErrBadRange, errors.New("from is after to")))
+		return
+	}
+	end := to.AddDate(0, 0, 7)
+	if end.Sub(from) > maxWeeks*7*24*time.Hour+time.Hour {
+		writeError(w, http.StatusBadRequest, withKind(ErrBadRange, fmt.Errorf("the range is at most %d weeks", maxWeeks)))
+		return
+	}
+	metric := gymdata.NormalizeMetrics([]string{q.Get("metric")})[0]
+
+	cfg := requestConfig(r)
+	list, err := loadWindow(cfg, from, end, []string{metric})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, withKind(ErrParse, err))
+		return
+	}
+
+	resp := WeeksResponse{
+		From:      from.Format("2006-01-02"),
+		To:        end.AddDate(0, 0, -1).Format("2006-01-02"),
+		Timezone:  loc.String(),
+		Metric:    metric,
+		Locations: []WeeksLocation{},
+	}
+	location := strings.TrimSpace(q.Get("location"))
+	for _, s := range list {
+		if location != "" && !strings.EqualFold(s.Key.Location, location) {
+			continue
+		}
+		if weeks := computeWeeks(s, from, end, now, loc); len(weeks) > 0 {
+			resp.Locations = append(resp.Locations, WeeksLocation{Name: s.Key.Location, Weeks: weeks})
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseWeekDate(t *testing.T) {
+	tallinn := loadTallinn(t)
+	for in, want := range map[string]string{
+		"2026-W01":   "2025-12-29", // week 1 starts in the year before
+		"2020-W53":   "2020-12-28",
+		"2025-10-01": "2025-10-01",
+	} {
+		got, err := parseWeekDate(in, tallinn)
+		if err != nil || got.Format("2006-01-02") != want {
+			t.Errorf("parseWeekDate(%q) = %v, %v; want %s", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"2021-W53", "2025-W00", "2025-W1", "week 40"} {
+		if _, err := parseWeekDate(in, tallinn); err == nil {
+			t.Errorf("parseWeekDate(%q) succeeded", in)
+		}
+	}
+}
+
+func TestWeeksHandler(t *testing.T) {
+	loadTallinn(t)
+	old := currentConfig()
+	defer setConfig(old)
+	dir := t.TempDir()
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	row := func(ts, n string) string { return ts + ",EET,1,Hipodroom," + n + ",success,{}\n" }
+	// Across the new year: Sunday the 28th closes 2025-W52, Monday the 29th
+	// opens 2026-W01, but its 00:30 is still Sunday in UTC.
+	writeCSV(t, dir, "gym-stats-20251228.csv", header+row("2025-12-28 10:00:00", "10")+row("2025-12-28 12:00:00", "20"))
+	writeCSV(t, dir, "gym-stats-20251229.csv", header+row("2025-12-29 00:30:00", "30")+row("2025-12-29 10:00:00", "10"))
+	writeCSV(t, dir, "gym-stats-20251230.csv", header+row("2025-12-30 10:00:00", "40"))
+	setConfig(&Config{DataDir: dir})
+
+	get := func(query string) (int, WeeksResponse) {
+		w := httptest.NewRecorder()
+		weeksHandler(w, httptest.NewRequest("GET", "/api/weeks"+query, nil))
+		var resp WeeksResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+	code, resp := get("?from=2025-12-28&to=2026-W01")
+	if code != 200 || resp.From != "2025-12-22" || resp.To != "2026-01-04" || len(resp.Locations) != 1 {
+		t.Fatalf("code %d, %+v", code, resp)
+	}
+	want := []WeekStats{
+		{Week: "2025-W52", Start: "2025-12-22", Days: 1, Avg: 15, Peak: 20, PeakAt: "2025-12-28T12:00:00+02:00"},
+		{Week: "2026-W01", Start: "2025-12-29", Days: 2, Avg: 30, Peak: 40, PeakAt: "2025-12-30T10:00:00+02:00"},
+	}
+	if got := resp.Locations[0].Weeks; len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Tallinn weeks = %+v\nwant %+v", got, want)
+	}
+
+	_, resp = get("?from=2025-W52&to=2026-W01&tz=UTC")
+	want = []WeekStats{
+		{Week: "2025-W52", Start: "2025-12-22", Days: 1, Avg: 20, Peak: 30, PeakAt: "2025-12-28T22:30:00Z"},
+		{Week: "2026-W01", Start: "2025-12-29", Days: 2, Avg: 25, Peak: 40, PeakAt: "2025-12-30T08:00:00Z"},
+	}
+	if got := resp.Locations[0].Weeks; len(got) != 2 || got[0] != want[0] || got[1] != want[1] || resp.Timezone != "UTC" {
+		t.Errorf("UTC weeks = %+v\nwant %+v", got, want)
+	}
+
+	_, resp = get("")
+	this := weekStart(time.Now().In(loadTallinn(t)))
+	if resp.From != this.AddDate(0, 0, -7*25).Format("2006-01-02") || resp.To != this.AddDate(0, 0, 6).Format("2006-01-02") {
+		t.Errorf("default range %s..%s, want the 26 weeks to this one", resp.From, resp.To)
+	}
+	for _, q := range []string{"?from=2026-W02&to=2026-W01", "?from=2020-01-01&to=2025-01-01", "?to=W40", "?tz=Mars/Olympus"} {
+		if code, _ := get(q); code != 400 {
+			t.Errorf("%s: code %d, want 400", q, code)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+const (
+	widgetHours = 3
+	// widgetStep is the sparkline's resolution: 18 points over 3 hours.
+	widgetStep = 10 * 60
+)
+
+// Widget is one gym's current state, small enough to poll from a smart
+// display. Pct is the count as a share of CAPACITIES, left out for gyms
+// without one. Spark is the last 3 hours in 10-minute averages, oldest
+// first, null where there were no readings.
+type Widget struct {
+	Name  string     `json:"name"`
+	Count int        `json:"count"`
+	At    string     `json:"at"`
+	Pct   *int       `json:"pct,omitempty"`
+	Trend string     `json:"trend"` // up, down or flat
+	Arrow string     `json:"arrow"`
+	Spark []*float64 `json:"spark"`
+}
+
+var widgetArrows = map[string]string{"up": "↑", "down": "↓", "flat": "→"}
+
+// widgetTrend reads where points are heading from their rate of change
+// over the last 10 minutes; less than a person, or 5% of the count, either
+// way is flat.
+func widgetTrend(points []gymdata.Point) string {
+	rates := rateSeries(points, 10)
+	if len(rates) == 0 || len(points) == 0 || rates[len(rates)-1].At != points[len(points)-1].At {
+		return "flat"
+	}
+	rate := rates[len(rates)-1].Y
+	switch limit := math.Max(1, 0.05*points[len(points)-1].Y); {
+	case rate >= limit:
+		return "up"
+	case rate <= -limit:
+		return "down"
+	}
+	return "flat"
+}
+
+// widgetSpark averages points into step-second slots from from to to.
+func widgetSpark(points []gymdata.Point, from, to time.Time, step int) []*float64 {
+	n := int(to.Sub(from).Seconds()) / step
+	sums := make([]float64, n)
+	counts := make([]int, n)
+	for _, p := range points {
+		if i := int(p.At-from.Unix()) / step; i >= 0 && i < n {
+			sums[i] += p.Y
+			counts[i]++
+		}
+	}
+	spark := make([]*float64, n)
+	for i := range spark {
+		if counts[i] > 0 {
+			v := math.Round(sums[i]/float64(counts[i])*10) / 10
+			spark[i] = &v
+		}
+	}
+	return spark
+}
+
+// widgetHandler serves one gym's count, share of capacity, trend and
+// sparkline for embedding in other sites: any origin may read it, and
+// replies may be cached for a minute. It reads /api/recent's cached build.
+//
+//	GET /api/widget/{location}
+func widgetHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r, "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	fail := func(status int, err error) {
+		writeError(w, status, err)
+	}
+	name := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/api/widget/"))
+	if name == "" {
+		fail(http.StatusNotFound, fmt.Errorf("no location given"))
+		return
+	}
+
+	cfg := requestConfig(r)
+	tallinn := gymdata.Tallinn()
+	mode, _ := requestOutlierMode(cfg, "")
+	res, _, _, err := buildRecent(r.Context(), cfg, widgetHours, []string{gymdata.DefaultMetric}, "", tallinn, mode, 0)
+	if err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	var s *gymdata.Series
+	for _, c := range withoutClosed(res.list, closedLocations(cfg)) {
+		if strings.EqualFold(c.Key.Location, name) && (s == nil || c.Key.Location == name) {
+			s = c
+		}
+	}
+	if s == nil || len(s.Points) == 0 {
+		fail(http.StatusNotFound, withKind(ErrNoData, fmt.Errorf("no readings for %s in the last %d hours", name, widgetHours)))
+		return
+	}
+
+	last := s.Points[len(s.Points)-1]
+	out := Widget{
+		Name:  s.Key.Location,
+		Count: int(math.Round(last.Y)),
+		At:    time.Unix(last.At, 0).In(tallinn).Format(time.RFC3339),
+		Trend: widgetTrend(s.Points),
+		Spark: widgetSpark(s.Points, res.from, res.to, widgetStep),
+	}
+	out.Arrow = widgetArrows[out.Trend]
+	if c, ok := cfg.Capacities[strings.ToLower(s.Key.Location)]; ok && c > 0 {
+		pct := int(math.Round(last.Y / c * 100))
+		out.Pct = &pct
+	}
+	w.Header().Set("Cache-Control", "max-age=60")
+	writeNegotiated(w, r, out, nil)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+func TestWidgetTrend(t *testing.T) {
+	points := func(ys ...float64) []gymdata.Point {
+		out := make([]gymdata.Point, len(ys))
+		for i, y := range ys {
+			out[i] = gymdata.Point{At: int64(i) * 120, Y: y}
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		ys   []float64
+		want string
+	}{
+		{[]float64{10, 12, 14, 16, 18, 20, 22, 24}, "up"},
+		{[]float64{40, 36, 32, 28, 24, 20, 16, 12}, "down"},
+		{[]float64{100, 100, 101, 100, 101, 100, 100, 101}, "flat"}, // under 5%
+		{[]float64{10}, "flat"},
+	} {
+		if got := widgetTrend(points(tc.ys...)); got != tc.want {
+			t.Errorf("%v: got %s, want %s", tc.ys, got, tc.want)
+		}
+	}
+}
+
+func TestWidgetHandler(t *testing.T) {
+	tallinn := loadTallinn(t)
+	old := currentConfig()
+	defer setConfig(old)
+	dir := t.TempDir()
+	cfg := &Config{DataDir: dir, AnonymousRole: RoleViewer, Capacities: map[string]float64{"hipodroom": 80}}
+	setConfig(cfg)
+
+	// A reading every 2 minutes for the last hour, climbing; Kristiine
+	// stopped reporting 4 hours ago.
+	days := map[string]string{}
+	add := func(at time.Time, name string, n int) {
+		local := at.In(tallinn)
+		file := "gym-stats-" + local.Format("20060102") + ".csv"
+		if days[file] == "" {
+			days[file] = "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+		}
+		days[file] += fmt.Sprintf("%s,%s,1,%s,%d,success,{}\n", local.Format("2006-01-02 15:04:05"), local.Format("MST"), name, n)
+	}
+	now := time.Now().Truncate(2 * time.Minute)
+	for i := 30; i >= 1; i-- {
+		add(now.Add(-time.Duration(i)*2*time.Minute), "Hipodroom", 60-i)
+	}
+	add(now.Add(-4*time.Hour), "Kristiine", 10)
+	for file, content := range days {
+		writeCSV(t, dir, file, content)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		widgetHandler(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	w := get("/api/widget/hipodroom")
+	var got Widget
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("code %d: %s", w.Code, w.Body)
+	}
+	if got.Name != "Hipodroom" || got.Count != 59 || got.Pct == nil || *got.Pct != 74 || got.Trend != "up" || got.Arrow != "↑" {
+		t.Errorf("got %+v", got)
+	}
+	if len(got.Spark) != 18 || got.Spark[0] != nil || got.Spark[17] == nil {
+		t.Errorf("spark = %v", got.Spark)
+	}
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("headers = %v", w.Header())
+	}
+	for _, path := range []string{"/api/widget/Kristiine", "/api/widget/Nowhere", "/api/widget/"} {
+		if w := get(path); w.Code != http.StatusNotFound {
+			t.Errorf("%s: code %d, want 404", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+// rangeTimeLayouts are the times of day a range may start or end at, read in
+// the request's zone unless they carry an offset.
+var rangeTimeLayouts = []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"}
+
+// rangeWindow is what a DateRangeRequest's from and to cover: the days whose
+// files are read, and the instants [from, to) their rows are cut to. A bare
+// day runs from its Tallinn midnight to the next, as the files do, so only
+// a bound with a time of day (timed) cuts into a file.
+type rangeWindow struct {
+	fromDay, toDay string
+	from, to       time.Time
+	timed          bool
+}
+
+// parseRangeBound reads one end of a range: YYYY-MM-DD, or a time as RFC
+// 3339 or local to loc. at is the day's Tallinn midnight for a bare day.
+func parseRangeBound(s string, loc *time.Location) (day string, at time.Time, timed bool, err error) {
+	tallinn := gymdata.Tallinn()
+	if at, err := time.ParseInLocation("2006-01-02", s, tallinn); err == nil {
+		return s, at, false, nil
+	}
+	at, err = time.Parse(time.RFC3339, s)
+	for _, layout := range rangeTimeLayouts {
+		if err == nil {
+			break
+		}
+		at, err = time.ParseInLocation(layout, s, loc)
+	}
+	if err != nil {
+		return "", time.Time{}, false, fmt.Errorf("%q is neither YYYY-MM-DD nor an RFC 3339 time such as 2006-01-02T15:04:05Z", s)
+	}
+	return at.In(tallinn).Format("2006-01-02"), at, true, nil
+}
+
+// parseRangeWindow reads a range's from and to, times without an offset
+// being in loc.
+func parseRangeWindow(from, to string, loc *time.Location) (rangeWindow, error) {
+	var w rangeWindow
+	var fromTimed, toTimed bool
+	var err error
+	if w.fromDay, w.from, fromTimed, err = parseRangeBound(from, loc); err != nil {
+		return rangeWindow{}, fieldErr("from", ErrBadRange, fmt.Errorf("from: %v", err))
+	}
+	if w.toDay, w.to, toTimed, err = parseRangeBound(to, loc); err != nil {
+		return rangeWindow{}, fieldErr("to", ErrBadRange, fmt.Errorf("to: %v", err))
+	}
+	if !toTimed {
+		w.to = w.to.AddDate(0, 0, 1) // through the end of the day
+	}
+	w.timed = fromTimed || toTimed
+	if w.timed && !w.from.Before(w.to) {
+		return rangeWindow{}, fieldErr("to", ErrBadRange, errors.New("from must be before to"))
+	}
+	return w, nil
+}
+
+// bucketMinutes is the bucket size for the window: at most points per
+// series, or about gymdata.DefaultPoints for 0. Whole days are measured as
+// calendar days, so a range across a clock change buckets like any other.
+func (w rangeWindow) bucketMinutes(points int) int {
+	if w.timed {
+		return bucketFor(w.from, w.to, points)
+	}
+	from, _ := time.Parse("2006-01-02", w.fromDay)
+	to, _ := time.Parse("2006-01-02", w.toDay)
+	return bucketFor(from, to.AddDate(0, 0, 1), points)
+}
+
+// bucketFor is the bucket size for [from, to) under a request's maxPoints:
+// the usual ladder when it has none, else within the budget however long.
+func bucketFor(from, to time.Time, points int) int {
+	if points == 0 {
+		return gymdata.BucketMinutes(from, to)
+	}
+	return gymdata.BucketMinutesFor(from, to, points)
+}
+
+// minMaxPoints and maxMaxPoints bound a request's maxPoints.
+const minMaxPoints, maxMaxPoints = 10, 100000
+
+func checkMaxPoints(n int) error {
+	if n != 0 && (n < minMaxPoints || n > maxMaxPoints) {
+		return fieldErr("maxPoints", nil, fmt.Errorf("maxPoints must be %d-%d", minMaxPoints, maxMaxPoints))
+	}
+	return nil
+}
+
+// requestMaxPoints reads a maxPoints query parameter, 0 when unset.
+func requestMaxPoints(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fieldErr("maxPoints", nil, fmt.Errorf("maxPoints must be %d-%d", minMaxPoints, maxMaxPoints))
+	}
+	return n, checkMaxPoints(n)
+}
+
+// cut drops the rows a timed window leaves out of its days' files.
+func (w rangeWindow) cut(list []*gymdata.Series) []*gymdata.Series {
+	if !w.timed {
+		return list
+	}
+	return gymdata.Window(list, w.from.Unix(), w.to.Unix())
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gym/internal/gymdata"
+)
+
+func TestParseRangeWindow(t *testing.T) {
+	tallinn := loadTallinn(t)
+	for _, tc := range []struct {
+		from, to       string
+		fromDay, toDay string
+		fromAt, toAt   string // RFC 3339
+		timed          bool
+	}{
+		{"2024-05-03", "2024-05-04", "2024-05-03", "2024-05-04", "2024-05-03T00:00:00+03:00", "2024-05-05T00:00:00+03:00", false},
+		{"2024-05-03T12:00", "2024-05-04T12:00", "2024-05-03", "2024-05-04", "2024-05-03T12:00:00+03:00", "2024-05-04T12:00:00+03:00", true},
+		{"2024-05-03 12:00:30", "2024-05-04", "2024-05-03", "2024-05-04", "2024-05-03T12:00:30+03:00", "2024-05-05T00:00:00+03:00", true},
+		// An offset wins over the zone; the day is Tallinn's, as files are named.
+		{"2024-05-03T22:30:00Z", "2024-05-04T01:00:00Z", "2024-05-04", "2024-05-04", "2024-05-04T01:30:00+03:00", "2024-05-04T04:00:00+03:00", true},
+		// As the dashboard sends them: JavaScript's toISOString.
+		{"2024-05-03T15:00:00.000Z", "2024-05-03T21:00:00.000Z", "2024-05-03", "2024-05-04", "2024-05-03T18:00:00+03:00", "2024-05-04T00:00:00+03:00", true},
+	} {
+		w, err := parseRangeWindow(tc.from, tc.to, tallinn)
+		if err != nil {
+			t.Errorf("%s..%s: %v", tc.from, tc.to, err)
+			continue
+		}
+		if w.fromDay != tc.fromDay || w.toDay != tc.toDay || w.from.In(tallinn).Format(time.RFC3339) != tc.fromAt ||
+			w.to.In(tallinn).Format(time.RFC3339) != tc.toAt || w.timed != tc.timed {
+			t.Errorf("%s..%s: got %+v", tc.from, tc.to, w)
+		}
+	}
+	for _, bad := range [][2]string{{"2024-05-03T12:00", "2024-05-03T12:00"}, {"yesterday", "2024-05-03"}, {"2024-05-03", "2024-05-03T25:00"}} {
+		if _, err := parseRangeWindow(bad[0], bad[1], tallinn); err == nil {
+			t.Errorf("%v: expected an error", bad)
+		}
+	}
+}
+
+func TestBuildRangeTimed(t *testing.T) {
+	tallinn := loadTallinn(t)
+	dir := t.TempDir()
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	writeCSV(t, dir, "gym-stats-20240503.csv", header+
+		"2024-05-03 11:58:00,EEST,1,Hipodroom,10,success,{}\n"+
+		"2024-05-03 12:00:00,EEST,1,Hipodroom,11,success,{}\n"+
+		"2024-05-03 18:00:00,EEST,1,Hipodroom,12,success,{}\n")
+	writeCSV(t, dir, "gym-stats-20240504.csv", header+
+		"2024-05-04 11:58:00,EEST,1,Hipodroom,13,success,{}\n"+
+		"2024-05-04 12:00:00,EEST,1,Hipodroom,14,success,{}\n")
+	cfg := &Config{DataDir: dir}
+	req := DateRangeRequest{From: "2024-05-03T12:00", To: "2024-05-04T12:00"}
+	files, _ := gymdata.InRange(dir, "2024-05-03", "2024-05-04")
+	res, bucketMinutes, _, err := buildRange(context.Background(), cfg, req, files, tallinn, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.list) != 1 || bucketMinutes != 2 {
+		t.Fatalf("series = %v, bucket %d", res.list, bucketMinutes)
+	}
+	var got []float64
+	for _, p := range res.list[0].Points {
+		got = append(got, p.Y)
+	}
+	if len(got) != 3 || got[0] != 11 || got[2] != 13 {
+		t.Errorf("counts = %v, want 12:00 on the 3rd up to just before 12:00 on the 4th", got)
+	}
+}
+
+func TestBuildRangeRollups(t *testing.T) {
+	tallinn := loadTallinn(t)
+	dir := t.TempDir()
+	header := "timestamp,timezone,location_id,location_name,user_count,status,response\n"
+	for day := 1; day <= 3; day++ {
+		date := fmt.Sprintf("2024-05-%02d", day)
+		writeCSV(t, dir, "gym-stats-202405"+date[8:]+".csv", header+
+			date+" 10:00:00,EEST,1,Hipodroom,10,success,{}\n"+
+			date+" 10:02:00,EEST,1,Hipodroom,20,success,{}\n"+
+			date+" 12:00:00,EEST,1,Hipodroom,30,success,{}\n")
+	}
+	cfg := &Config{DataDir: dir, RollupDir: "rollups"}
+	files, _ := gymdata.InRange(dir, "2024-05-01", "2024-05-03")
+
+	for _, tc := range []struct {
+		req        DateRangeRequest
+		bucket     int
+		resolution int
+		zone       *time.Location
+	}{
+		{DateRangeRequest{From: "2024-05-01", To: "2024-05-01"}, 2, 0, tallinn},
+		{DateRangeRequest{From: "2024-05-01", To: "2024-05-03", MaxPoints: 80}, 60, 60, tallinn},
+		{DateRangeRequest{From: "2024-05-01", To: "2024-05-03", MaxPoints: 10}, 720, 60, tallinn},
+		// Buckets in another zone come from the rows.
+		{DateRangeRequest{From: "2024-05-01", To: "2024-05-03", MaxPoints: 80, TZ: "UTC"}, 60, 0, time.UTC},
+	} {
+		res, bucketMinutes, _, err := buildRange(context.Background(), cfg, tc.req, files, tc.zone, true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bucketMinutes != tc.bucket || res.resolution != tc.resolution {
+			t.Errorf("maxPoints %d tz %q: bucket %d from %d, want %d from %d", tc.req.MaxPoints, tc.req.TZ, bucketMinutes, res.resolution, tc.bucket, tc.resolution)
+		}
+		if len(res.list) != 1 || res.list[0].Points[0].Y != map[int]float64{2: 10, 60: 15, 720: 15}[tc.bucket] {
+			t.Errorf("maxPoints %d tz %q: series %v", tc.req.MaxPoints, tc.req.TZ, res.list)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "rollups", "gym-stats-20240502.csv.json")); err != nil {
+		t.Errorf("rollup not kept: %v", err)
+	}
+	if err := checkRangeRequest(cfg, &DateRangeRequest{From: "2024-05-01", To: "2024-05-03", MaxPoints: 5}); err == nil {
+		t.Error("maxPoints 5: expected an error")
+	}
+}